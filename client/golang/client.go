@@ -6,16 +6,22 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	_ "github.com/kelindar/talaria/internal/encoding/zstd" // Register the zstd compressor
 	pb "github.com/kelindar/talaria/proto"
 	"github.com/myteksi/hystrix-go/hystrix"
 	"github.com/sercand/kuberesolver/v3"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // Register the gzip compressor
+	"google.golang.org/grpc/metadata"
 )
 
 const (
 	commandName        = "talaria"
+	tableMetadataKey   = "talaria-table"
+	sumMetadataKey     = "talaria-checksum"
 	defaultDialTimeout = 5 * time.Second
 )
 
@@ -71,13 +77,25 @@ func (c *Client) connect() error {
 
 	var dialOptions []grpc.DialOption
 	if c.netconf.LoadBalancer != "" {
-		dialOptions = append(dialOptions, grpc.WithBalancerName(c.netconf.LoadBalancer))
+		// The load balancing policy is only selected through the service config in the newer gRPC releases
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"`+c.netconf.LoadBalancer+`"}`))
 	}
 
 	if !c.netconf.NonBlocking {
 		dialOptions = append(dialOptions, grpc.WithBlock())
 	}
 
+	if c.netconf.Token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(&bearer{
+			token:  c.netconf.Token,
+			secure: !c.isConnectionInsecure(),
+		}))
+	}
+
+	if c.netconf.Compressor != "" {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.UseCompressor(c.netconf.Compressor)))
+	}
+
 	if c.isConnectionInsecure() {
 		dialOptions = append(dialOptions, grpc.WithInsecure())
 	} else {
@@ -88,6 +106,7 @@ func (c *Client) connect() error {
 	if err != nil {
 		return ErrUnableToConnect
 	}
+	c.conn = conn
 	c.ingress = pb.NewIngressClient(conn)
 	return nil
 }
@@ -105,6 +124,15 @@ func (c *Client) IngestBatch(ctx context.Context, batch []Event) error {
 		},
 	}
 
+	return c.ingest(ctx, req)
+}
+
+// ingest sends an ingestion request, along with the checksum of its data if enabled.
+func (c *Client) ingest(ctx context.Context, req *pb.IngestRequest) error {
+	if c.netconf.Checksum {
+		ctx = metadata.AppendToOutgoingContext(ctx, sumMetadataKey, fmt.Sprintf("%08x", pb.Checksum(req)))
+	}
+
 	return hystrix.Do(commandName, func() error {
 		_, err := c.ingress.Ingest(ctx, req)
 		return err
@@ -113,50 +141,74 @@ func (c *Client) IngestBatch(ctx context.Context, batch []Event) error {
 
 // IngestURL sends a request to Talaria to ingest a file from a specific URL.
 func (c *Client) IngestURL(ctx context.Context, url string) error {
-	return hystrix.Do(commandName, func() error {
-		_, err := c.ingress.Ingest(ctx, &pb.IngestRequest{
-			Data: &pb.IngestRequest_Url{
-				Url: url,
-			},
-		})
-		return err
-	}, nil)
+	return c.ingest(ctx, &pb.IngestRequest{
+		Data: &pb.IngestRequest_Url{
+			Url: url,
+		},
+	})
 }
 
 // IngestCSV sends a set of comma-separated file to Talaria to ingest.
 func (c *Client) IngestCSV(ctx context.Context, data []byte) error {
-	return hystrix.Do(commandName, func() error {
-		_, err := c.ingress.Ingest(ctx, &pb.IngestRequest{
-			Data: &pb.IngestRequest_Csv{
-				Csv: data,
-			},
-		})
-		return err
-	}, nil)
+	return c.ingest(ctx, &pb.IngestRequest{
+		Data: &pb.IngestRequest_Csv{
+			Csv: data,
+		},
+	})
 }
 
 // IngestORC sends an ORC-encoded file to Talaria to ingest.
 func (c *Client) IngestORC(ctx context.Context, data []byte) error {
-	return hystrix.Do(commandName, func() error {
-		_, err := c.ingress.Ingest(ctx, &pb.IngestRequest{
-			Data: &pb.IngestRequest_Orc{
-				Orc: data,
-			},
-		})
-		return err
-	}, nil)
+	return c.ingest(ctx, &pb.IngestRequest{
+		Data: &pb.IngestRequest_Orc{
+			Orc: data,
+		},
+	})
 }
 
 // IngestParquet sends an Parquet-encoded file to Talaria to ingest.
 func (c *Client) IngestParquet(ctx context.Context, data []byte) error {
-	return hystrix.Do(commandName, func() error {
-		_, err := c.ingress.Ingest(ctx, &pb.IngestRequest{
-			Data: &pb.IngestRequest_Parquet{
-				Parquet: data,
-			},
-		})
-		return err
-	}, nil)
+	return c.ingest(ctx, &pb.IngestRequest{
+		Data: &pb.IngestRequest_Parquet{
+			Parquet: data,
+		},
+	})
+}
+
+// IngestJSON sends JSON objects, either one per line or in an array, to Talaria to ingest.
+func (c *Client) IngestJSON(ctx context.Context, data []byte) error {
+	return c.ingest(ctx, &pb.IngestRequest{
+		Data: &pb.IngestRequest_Json{
+			Json: data,
+		},
+	})
+}
+
+// WithTables returns a copy of the context which restricts the ingestion to the specified tables. The
+// table names can be qualified with their schema, for example "team.events".
+func WithTables(ctx context.Context, tables ...string) context.Context {
+	pairs := make([]string, 0, 2*len(tables))
+	for _, table := range tables {
+		pairs = append(pairs, tableMetadataKey, table)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// bearer represents the token of the producer, sent with every request
+type bearer struct {
+	token  string
+	secure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (b *bearer) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (b *bearer) RequireTransportSecurity() bool {
+	return b.secure
 }
 
 // Close connection
@@ -21,6 +21,9 @@ type netconf struct {
 	Credentials    credentials.TransportCredentials // Transport credentials to use
 	NonBlocking    bool                             // once set to true, the client will be returned before connection gets ready
 	LoadBalancer   string                           // gRPC load balancing strategy
+	Token          string                           // The API key or JSON Web Token of the producer
+	Compressor     string                           // The compressor of the requests, gzip or zstd (optional)
+	Checksum       bool                             // Whether the checksum of the data is sent with the requests
 }
 
 // WithNetwork specifies the configuration for a connection.
@@ -66,3 +69,26 @@ func WithLoadBalancer(name string) Option {
 		client.netconf.LoadBalancer = name
 	}
 }
+
+// WithToken specifies the API key or the JSON Web Token sent as a bearer token with every request, if the
+// server authenticates the producers.
+func WithToken(token string) Option {
+	return func(client *Client) {
+		client.netconf.Token = token
+	}
+}
+
+// WithCompression compresses the requests with gzip or zstd, the latter being only available when built with cgo.
+func WithCompression(name string) Option {
+	return func(client *Client) {
+		client.netconf.Compressor = name
+	}
+}
+
+// WithChecksum sends the CRC-32C checksum of the data along with every request, so that the server rejects the
+// data which was corrupted on its way.
+func WithChecksum() Option {
+	return func(client *Client) {
+		client.netconf.Checksum = true
+	}
+}
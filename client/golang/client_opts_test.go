@@ -29,3 +29,14 @@ func TestWithCircuit(t *testing.T) {
 	}
 	assert.Equal(t, expectedCircuit, client.netconf.CircuitOptions)
 }
+
+func TestWithToken(t *testing.T) {
+	client, _ := Dial("invalid", WithNonBlock(), WithToken("key"))
+	assert.Equal(t, "key", client.netconf.Token)
+}
+
+func TestWithCompression(t *testing.T) {
+	client, _ := Dial("invalid", WithNonBlock(), WithCompression("gzip"), WithChecksum())
+	assert.Equal(t, "gzip", client.netconf.Compressor)
+	assert.True(t, client.netconf.Checksum)
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestDial(t *testing.T) {
@@ -40,3 +42,10 @@ func TestDial(t *testing.T) {
 		})
 	}
 }
+
+func TestWithTables(t *testing.T) {
+	ctx := WithTables(context.Background(), "eventlog", "team.events")
+	md, ok := metadata.FromOutgoingContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"eventlog", "team.events"}, md.Get("talaria-table"))
+}
@@ -0,0 +1,247 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/myteksi/hystrix-go/hystrix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrProducerClosed is returned when an event is sent to a producer which was closed
+	ErrProducerClosed = errors.New("talaria producer is closed")
+)
+
+// Producer represents a producer which sends the events in batches, in the background, through a client. The
+// events are queued until a batch is full or lingered long enough, and the batches which failed are retried
+// with an exponential backoff.
+type Producer struct {
+	lock    sync.RWMutex                         // The lock guarding the queue against a close, and the error
+	closed  bool                                 // Whether the producer was closed
+	failed  error                                // The error of the first batch which failed since the last flush
+	queue   chan Event                           // The bounded queue of the events to send
+	flush   chan chan error                      // The requests to send the events queued so far
+	done    chan error                           // The error of the last batches, once closed
+	ingest  func(context.Context, []Event) error // The function sending a batch
+	sleep   func(time.Duration)                  // The function waiting between two attempts
+	options producerOptions                      // The options of the producer
+}
+
+// NewProducer creates a producer sending the events through the client, which it does not close. By default,
+// the events are sent in batches of 1000 or every second, up to 10000 events are queued and a batch is
+// attempted up to 5 times with a backoff starting at 100 milliseconds.
+func NewProducer(client *Client, options ...ProducerOption) *Producer {
+	p := &Producer{
+		options: producerOptions{
+			BatchSize:  1000,
+			Linger:     time.Second,
+			QueueSize:  10000,
+			Attempts:   5,
+			Backoff:    100 * time.Millisecond,
+			MaxBackoff: 10 * time.Second,
+		},
+		ingest: client.IngestBatch,
+		sleep:  time.Sleep,
+	}
+
+	// Apply the options to overwrite the defaults
+	for _, option := range options {
+		option(&p.options)
+	}
+
+	p.queue = make(chan Event, p.options.QueueSize)
+	p.flush = make(chan chan error)
+	p.done = make(chan error, 1)
+	go p.run()
+	return p
+}
+
+// Send queues an event to be sent with the next batch, waiting while the queue is full until the context
+// is cancelled.
+func (p *Producer) Send(ctx context.Context, event Event) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	select {
+	case p.queue <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush sends the events queued so far and returns the error of the first batch which failed since the previous
+// flush, if any.
+func (p *Producer) Flush(ctx context.Context) error {
+	p.lock.RLock()
+	if p.closed {
+		p.lock.RUnlock()
+		return ErrProducerClosed
+	}
+
+	reply := make(chan error, 1)
+	select {
+	case p.flush <- reply:
+		p.lock.RUnlock()
+	case <-ctx.Done():
+		p.lock.RUnlock()
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return p.firstError(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events and waits until the events queued are sent, returning the error of the first
+// batch which failed since the last flush, if any.
+func (p *Producer) Close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return ErrProducerClosed
+	}
+
+	p.closed = true
+	close(p.queue)
+	p.lock.Unlock()
+	return p.firstError(<-p.done)
+}
+
+// firstError returns the error of the first batch which failed since the last flush, or else the error given,
+// and resets it
+func (p *Producer) firstError(err error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failed != nil {
+		err, p.failed = p.failed, nil
+	}
+	return err
+}
+
+// fail records the error of a batch sent in the background, unless a previous batch failed already
+func (p *Producer) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failed == nil {
+		p.failed = err
+	}
+}
+
+// run batches the events queued until the queue is closed
+func (p *Producer) run() {
+	ticker := time.NewTicker(p.options.Linger)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, p.options.BatchSize)
+	for {
+		select {
+		case event, ok := <-p.queue:
+			if !ok {
+				p.done <- p.send(batch)
+				return
+			}
+
+			if batch = append(batch, event); len(batch) >= p.options.BatchSize {
+				if err := p.send(batch); err != nil {
+					p.fail(err)
+				}
+				batch = make([]Event, 0, p.options.BatchSize)
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				if err := p.send(batch); err != nil {
+					p.fail(err)
+				}
+				batch = make([]Event, 0, p.options.BatchSize)
+			}
+
+		case reply := <-p.flush:
+			reply <- p.drain(batch)
+			batch = make([]Event, 0, p.options.BatchSize)
+		}
+	}
+}
+
+// drain sends the batch along with the events currently queued, and returns the first error
+func (p *Producer) drain(batch []Event) (err error) {
+	for n := len(p.queue); n > 0; n-- {
+		if batch = append(batch, <-p.queue); len(batch) >= p.options.BatchSize {
+			if serr := p.send(batch); err == nil {
+				err = serr
+			}
+			batch = make([]Event, 0, p.options.BatchSize)
+		}
+	}
+
+	if serr := p.send(batch); err == nil {
+		err = serr
+	}
+	return
+}
+
+// send sends a batch, retrying with an exponential backoff while it fails on a transient error, and reports
+// the batch to the error handler once every attempt failed
+func (p *Producer) send(batch []Event) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx := WithTables(context.Background(), p.options.Tables...)
+	delay := p.options.Backoff
+	for attempt := 1; ; attempt++ {
+		if err = p.ingest(ctx, batch); err == nil {
+			return nil
+		}
+
+		if !retryable(err) || attempt >= p.options.Attempts {
+			break
+		}
+
+		p.sleep(jittered(delay))
+		if delay *= 2; delay > p.options.MaxBackoff {
+			delay = p.options.MaxBackoff
+		}
+	}
+
+	if p.options.OnError != nil {
+		p.options.OnError(batch, err)
+	}
+	return err
+}
+
+// retryable returns whether an error is transient, such as when the server is unavailable or over its quota,
+// or when the circuit of the client is open
+func retryable(err error) bool {
+	switch err {
+	case hystrix.ErrCircuitOpen, hystrix.ErrMaxConcurrency, hystrix.ErrTimeout:
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// jittered randomizes a delay by up to a fifth, in either direction, so the producers do not retry in lockstep
+func jittered(delay time.Duration) time.Duration {
+	return time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64()))
+}
@@ -0,0 +1,76 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package client
+
+import (
+	"time"
+)
+
+// ProducerOption is a functional parameter used to configure the producer.
+type ProducerOption func(options *producerOptions)
+
+// producerOptions defines the batching and the retries of a producer
+type producerOptions struct {
+	BatchSize  int                            // The maximum number of events of a batch
+	Linger     time.Duration                  // The maximum time an event waits for its batch to be full
+	QueueSize  int                            // The maximum number of events queued
+	Attempts   int                            // The maximum number of attempts of a batch
+	Backoff    time.Duration                  // The delay before the first retry, doubled on every attempt
+	MaxBackoff time.Duration                  // The maximum delay between two attempts
+	Tables     []string                       // The tables the events are ingested into (optional)
+	OnError    func(batch []Event, err error) // The handler of the batches which could not be sent (optional)
+}
+
+// WithBatch specifies the maximum number of events of a batch, and the maximum time an event waits for its
+// batch to be full before the batch is sent anyway.
+func WithBatch(size int, linger time.Duration) ProducerOption {
+	return func(options *producerOptions) {
+		if size > 0 {
+			options.BatchSize = size
+		}
+		if linger > 0 {
+			options.Linger = linger
+		}
+	}
+}
+
+// WithQueue specifies the maximum number of events queued, past which sending an event waits.
+func WithQueue(size int) ProducerOption {
+	return func(options *producerOptions) {
+		if size > 0 {
+			options.QueueSize = size
+		}
+	}
+}
+
+// WithRetry specifies the maximum number of attempts of a batch and the delay between two attempts, which
+// starts at the backoff and doubles up to the maximum backoff.
+func WithRetry(attempts int, backoff, maxBackoff time.Duration) ProducerOption {
+	return func(options *producerOptions) {
+		if attempts > 0 {
+			options.Attempts = attempts
+		}
+		if backoff > 0 {
+			options.Backoff = backoff
+		}
+		if maxBackoff > 0 {
+			options.MaxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithProducerTables restricts the ingestion of the events to the specified tables, see WithTables.
+func WithProducerTables(tables ...string) ProducerOption {
+	return func(options *producerOptions) {
+		options.Tables = tables
+	}
+}
+
+// WithErrorHandler specifies the function called with the batches which could not be sent, once every
+// attempt failed or on an error which is not transient, so that they can be logged or stored elsewhere.
+func WithErrorHandler(handler func(batch []Event, err error)) ProducerOption {
+	return func(options *producerOptions) {
+		options.OnError = handler
+	}
+}
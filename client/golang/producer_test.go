@@ -0,0 +1,156 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// recorder records the batches sent, failing the first attempts with an error
+type recorder struct {
+	sync.Mutex
+	batches [][]Event
+	tables  []string
+	fail    int
+	err     error
+}
+
+func (r *recorder) ingest(ctx context.Context, batch []Event) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		return r.err
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	r.tables = md.Get(tableMetadataKey)
+	r.batches = append(r.batches, batch)
+	return nil
+}
+
+func (r *recorder) count() (n int) {
+	r.Lock()
+	defer r.Unlock()
+	for _, batch := range r.batches {
+		n += len(batch)
+	}
+	return
+}
+
+func newTestProducer(r *recorder, options ...ProducerOption) *Producer {
+	client, _ := Dial("invalid", WithNonBlock())
+	p := NewProducer(client, options...)
+	p.ingest = r.ingest
+	p.sleep = func(time.Duration) {}
+	return p
+}
+
+func TestProducer_Batch(t *testing.T) {
+	r := new(recorder)
+	p := newTestProducer(r, WithBatch(2, time.Hour), WithProducerTables("events"))
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, p.Send(context.Background(), Event{"event": "click", "i": i}))
+	}
+
+	// The full batches are sent while the last event waits, until closed
+	assert.Eventually(t, func() bool { return r.count() == 4 }, time.Second, time.Millisecond)
+	assert.NoError(t, p.Close())
+	assert.Len(t, r.batches, 3)
+	assert.Equal(t, 5, r.count())
+	assert.Equal(t, []string{"events"}, r.tables)
+
+	// Once closed, no event is accepted
+	assert.Equal(t, ErrProducerClosed, p.Send(context.Background(), Event{"event": "click"}))
+	assert.Equal(t, ErrProducerClosed, p.Close())
+}
+
+func TestProducer_Linger(t *testing.T) {
+	r := new(recorder)
+	p := newTestProducer(r, WithBatch(100, 10*time.Millisecond))
+	defer p.Close()
+
+	assert.NoError(t, p.Send(context.Background(), Event{"event": "click"}))
+	assert.Eventually(t, func() bool { return r.count() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestProducer_Flush(t *testing.T) {
+	r := new(recorder)
+	p := newTestProducer(r, WithBatch(2, time.Hour))
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Send(context.Background(), Event{"i": i}))
+	}
+
+	assert.NoError(t, p.Flush(context.Background()))
+	assert.Equal(t, 3, r.count())
+}
+
+func TestProducer_Retry(t *testing.T) {
+	r := &recorder{fail: 2, err: status.Error(codes.Unavailable, "unavailable")}
+	p := newTestProducer(r, WithBatch(10, time.Hour), WithRetry(3, time.Millisecond, time.Millisecond))
+	assert.NoError(t, p.Send(context.Background(), Event{"event": "click"}))
+	assert.NoError(t, p.Close())
+	assert.Equal(t, 1, r.count())
+}
+
+func TestProducer_Error(t *testing.T) {
+	var failed []Event
+	r := &recorder{fail: 1, err: status.Error(codes.InvalidArgument, "invalid")}
+	p := newTestProducer(r, WithErrorHandler(func(batch []Event, err error) {
+		failed = batch
+	}))
+
+	// An error which is not transient is not retried
+	assert.NoError(t, p.Send(context.Background(), Event{"event": "click"}))
+	assert.Error(t, p.Close())
+	assert.Equal(t, 0, r.count())
+	assert.Len(t, failed, 1)
+}
+
+func TestProducer_FirstError(t *testing.T) {
+	r := &recorder{fail: 1, err: status.Error(codes.InvalidArgument, "invalid")}
+	p := newTestProducer(r, WithBatch(1, time.Hour))
+
+	// The first batch fails in the background and the last one succeeds, so the first error is returned
+	assert.NoError(t, p.Send(context.Background(), Event{"i": 1}))
+	assert.Eventually(t, func() bool {
+		r.Lock()
+		defer r.Unlock()
+		return r.fail == 0
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, p.Send(context.Background(), Event{"i": 2}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(p.Close()))
+	assert.Equal(t, 1, r.count())
+}
+
+func TestProducer_Queue(t *testing.T) {
+	block := make(chan struct{})
+	p := newTestProducer(new(recorder), WithBatch(1, time.Hour), WithQueue(1))
+	p.ingest = func(context.Context, []Event) error {
+		<-block
+		return nil
+	}
+
+	// One event is being sent and another one is queued, so the next one waits
+	assert.NoError(t, p.Send(context.Background(), Event{"i": 1}))
+	assert.NoError(t, p.Send(context.Background(), Event{"i": 2}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, p.Send(ctx, Event{"i": 3}))
+
+	close(block)
+	assert.NoError(t, p.Close())
+}
@@ -0,0 +1,361 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package embedded runs Talaria inside of a Go program, as a single node whose tables are created and appended
+// to through code, without the binary and its config file. The node can still serve the Presto Thrift and gRPC
+// listeners so that its tables can be queried and ingested into from the outside.
+package embedded
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/kelindar/lua"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/config/static"
+	"github.com/kelindar/talaria/internal/ingress/s3sqs"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/statsd"
+	script "github.com/kelindar/talaria/internal/scripting"
+	mlog "github.com/kelindar/talaria/internal/scripting/log"
+	mstats "github.com/kelindar/talaria/internal/scripting/stats"
+	"github.com/kelindar/talaria/internal/server"
+	"github.com/kelindar/talaria/internal/server/catalog"
+	"github.com/kelindar/talaria/internal/server/registry"
+	"github.com/kelindar/talaria/internal/storage"
+	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/writer"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/timeseries"
+	talaria "github.com/kelindar/talaria/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	tableMetadataKey = "talaria-table"
+	maxBytes         = 16 * 1024 * 1024 // The maximum size of a page of rows read by a query
+)
+
+// Table represents the settings of a table, the same as the ones of a table in the config file.
+type Table = config.Table
+
+// Row represents a row appended to a table, its values being converted to the types of the schema of the table.
+type Row = map[string]interface{}
+
+// Node represents a Talaria node embedded in the program.
+type Node struct {
+	lock    sync.Mutex         // The lock guarding the node against a concurrent close
+	conf    *config.Config     // The config of the node
+	addr    string             // The address of the node, given to Presto along with the splits
+	server  *server.Server     // The underlying server
+	catalog *catalog.Catalog   // The tables created through code
+	monitor monitor.Monitor    // The monitoring layer
+	loader  *script.Loader     // The script loader of the pipelines and the computed columns
+	cancel  context.CancelFunc // The function stopping the listeners, if listening
+	closed  bool               // Whether the node was closed
+}
+
+// New creates a node storing its tables in the directory, opening the tables created by a previous run.
+func New(dir string, options ...Option) (*Node, error) {
+	opts := &nodeOptions{Addr: "127.0.0.1"}
+	for _, option := range options {
+		option(opts)
+	}
+
+	conf, err := config.Check(static.New(), configurer(func(c *config.Config) error {
+		if len(opts.YAML) > 0 {
+			if err := config.Unmarshal(opts.YAML, c); err != nil {
+				return err
+			}
+		}
+
+		c.Storage.Directory = dir
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		conf:    conf,
+		addr:    opts.Addr,
+		monitor: opts.Monitor,
+	}
+
+	if n.monitor == nil {
+		if opts.Logger == nil {
+			opts.Logger = logging.NewStandard()
+		}
+		n.monitor = monitor.New(opts.Logger, statsd.NewNoop(), conf.AppName, conf.Env)
+	}
+
+	n.loader = script.NewLoader([]lua.Module{
+		mlog.New(n.monitor),
+		mstats.New(n.monitor),
+	})
+	n.loader.SetMonitor(n.monitor)
+	n.loader.SetLimits(conf.Scripting)
+
+	// Open the tables of the config, if any
+	tables := make([]table.Table, 0, len(conf.Tables))
+	for name, tableConf := range conf.Tables {
+		t, err := n.open(name, tableConf)
+		if err != nil {
+			closeAll(tables)
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+
+	n.server = server.New(func() *config.Config { return n.conf }, n.monitor, n.loader, tables...)
+	n.server.SetOpener(n.open)
+	if opts.Queue != nil && opts.Bucket != nil {
+		n.server.SetS3SQS(s3sqs.NewWith(opts.Queue, opts.Bucket, n.monitor))
+	}
+
+	// Version the schemas of the tables as the appended columns evolve
+	schemas, err := registry.New(dir)
+	if err != nil {
+		n.server.Close()
+		return nil, err
+	}
+	n.server.SetRegistry(schemas)
+
+	// Keep the tables created through code in a catalog, so they are opened again on the next run
+	if n.catalog, err = catalog.New(dir); err != nil {
+		n.server.Close()
+		return nil, err
+	}
+	n.server.SetCatalog(n.catalog)
+	return n, nil
+}
+
+// CreateTable creates a table, which is kept in the directory of the node.
+func (n *Node) CreateTable(name string, table Table) error {
+	if err := n.check(); err != nil {
+		return err
+	}
+
+	if _, ok := n.conf.Tables[name]; ok {
+		return errors.AlreadyExists("table " + name + " already exists")
+	}
+
+	_, err := n.catalog.Create(name, table, nil)
+	return err
+}
+
+// DropTable drops a table created through code, deleting its data.
+func (n *Node) DropTable(name string) error {
+	if err := n.check(); err != nil {
+		return err
+	}
+
+	_, err := n.catalog.Drop(name)
+	return err
+}
+
+// Append appends the rows to a table, running them through its pipeline and its computed columns like the
+// ingested rows.
+func (n *Node) Append(ctx context.Context, table string, rows ...Row) error {
+	if err := n.check(); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		return errors.InvalidArgument("unable to encode the rows: " + err.Error())
+	}
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(tableMetadataKey, table))
+	_, err = n.server.Ingest(ctx, &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Json{Json: encoded},
+	})
+	return err
+}
+
+// Query reads the columns of the rows of a table matching the filters, such as "event == 'click'", which must
+// select a value of the key of the table and may bound its time. The values are returned by column, in the
+// order of the rows, a null value being nil.
+func (n *Node) Query(ctx context.Context, table string, columns []string, filters ...string) (map[string][]interface{}, error) {
+	if err := n.check(); err != nil {
+		return nil, err
+	}
+
+	splits, err := n.server.GetSplits(ctx, &talaria.GetSplitsRequest{
+		Table:   table,
+		Columns: columns,
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]interface{}, len(columns))
+	for _, split := range splits.Splits {
+		var token []byte
+		for {
+			rows, err := n.server.GetRows(ctx, &talaria.GetRowsRequest{
+				SplitID:   split.SplitID,
+				Columns:   columns,
+				MaxBytes:  maxBytes,
+				NextToken: token,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for i, column := range rows.Columns {
+				out[columns[i]] = append(out[columns[i]], valuesOf(column)...)
+			}
+
+			if token = rows.NextToken; len(token) == 0 {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Listen serves the Presto Thrift and the gRPC listeners until the context is cancelled or the node is closed,
+// along with the HTTP listeners of the config, if any.
+func (n *Node) Listen(ctx context.Context, prestoPort, grpcPort int32) error {
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		return errors.New("embedded: the node is closed")
+	}
+
+	ctx, n.cancel = context.WithCancel(ctx)
+	n.lock.Unlock()
+	return n.server.Listen(ctx, prestoPort, grpcPort)
+}
+
+// Close stops the listeners and closes the tables, flushing the rows which were not compacted yet to the sinks.
+func (n *Node) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return nil
+	}
+
+	n.closed = true
+	if n.cancel != nil {
+		n.cancel()
+	}
+
+	n.server.Close()
+	return nil
+}
+
+// Members returns the address of the node, as the only member of its cluster.
+func (n *Node) Members() []string {
+	return []string{n.addr}
+}
+
+// Addr returns the address of the node.
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// open opens a table with its local storage and its compaction to the sinks, if configured
+func (n *Node) open(name string, conf config.Table) (table.Table, error) {
+	var store storage.Storage = disk.Open(n.conf.Storage.Directory, name, n.monitor, n.conf.Storage.Badger)
+	if conf.Compact != nil {
+		compactor, err := writer.ForCompaction(conf.Compact, n.monitor, store, n.loader)
+		if err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+
+		compactor.SetName(name)
+		store = compactor
+	}
+
+	streams, err := writer.ForStreaming(conf.Streams, n.monitor, n.loader)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	return timeseries.New(name, n, n.monitor, store, &conf, streams), nil
+}
+
+// check returns an error if the node is closed
+func (n *Node) check() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return errors.New("embedded: the node is closed")
+	}
+	return nil
+}
+
+// closeAll closes the tables opened so far
+func closeAll(tables []table.Table) {
+	for _, t := range tables {
+		_ = t.Close()
+	}
+}
+
+// configurer represents a function completing the config
+type configurer func(*config.Config) error
+
+// Configure implements config.Configurer
+func (f configurer) Configure(c *config.Config) error {
+	return f(c)
+}
+
+// valuesOf returns the values of a column read by a query, a null value being nil
+func valuesOf(column *talaria.Column) []interface{} {
+	var out []interface{}
+	switch c := column.GetValue().(type) {
+	case *talaria.Column_Int32:
+		for i, v := range c.Int32.Ints {
+			out = append(out, valueOrNil(c.Int32.Nulls, i, v))
+		}
+	case *talaria.Column_Int64:
+		for i, v := range c.Int64.Longs {
+			out = append(out, valueOrNil(c.Int64.Nulls, i, v))
+		}
+	case *talaria.Column_Float64:
+		for i, v := range c.Float64.Doubles {
+			out = append(out, valueOrNil(c.Float64.Nulls, i, v))
+		}
+	case *talaria.Column_Bool:
+		for i, v := range c.Bool.Bools {
+			out = append(out, valueOrNil(c.Bool.Nulls, i, v))
+		}
+	case *talaria.Column_Time: // In milliseconds
+		for i, v := range c.Time.Longs {
+			out = append(out, valueOrNil(c.Time.Nulls, i, time.Unix(0, v*int64(time.Millisecond)).UTC()))
+		}
+	case *talaria.Column_String_:
+		out = stringsOf(c.String_)
+	case *talaria.Column_Json:
+		out = stringsOf(c.Json)
+	}
+	return out
+}
+
+// stringsOf returns the values of a column of strings, a null value being nil
+func stringsOf(c *talaria.ColumnOfString) []interface{} {
+	out := make([]interface{}, 0, len(c.Sizes))
+	offset := 0
+	for i, size := range c.Sizes {
+		out = append(out, valueOrNil(c.Nulls, i, string(c.Bytes[offset:offset+int(size)])))
+		offset += int(size)
+	}
+	return out
+}
+
+// valueOrNil returns the value of a row, or nil if it is null
+func valueOrNil(nulls []bool, i int, v interface{}) interface{} {
+	if i < len(nulls) && nulls[i] {
+		return nil
+	}
+	return v
+}
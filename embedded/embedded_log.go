@@ -0,0 +1,46 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package embedded
+
+import (
+	"github.com/kelindar/talaria/internal/monitor/logging"
+)
+
+// Logger represents a logger receiving the entries of the node, see WithLogger.
+type Logger = logging.Logger
+
+// Level represents the level of an entry, such as LevelInfo.
+type Level = logging.Level
+
+// Field represents a field of a structured entry, such as the table or the key of the file ingested.
+type Field = logging.Field
+
+// The levels of the entries
+const (
+	LevelError   = logging.LevelError
+	LevelWarning = logging.LevelWarning
+	LevelInfo    = logging.LevelInfo
+	LevelDebug   = logging.LevelDebug
+)
+
+// Sugared is implemented by the leveled loggers taking loosely-typed key/value pairs, such as the
+// *zap.SugaredLogger.
+type Sugared = logging.Sugared
+
+// LogFunc writes out a structured entry, and adapts the loggers with a builder API, such as zerolog:
+//
+//	embedded.LogFunc(func(level embedded.Level, msg string, fields []embedded.Field) {
+//	    event := zlog.WithLevel(toZerolog(level))
+//	    for _, f := range fields {
+//	        event = event.Interface(f.Key, f.Value)
+//	    }
+//	    event.Msg(msg)
+//	})
+type LogFunc = logging.Func
+
+// NewSugaredLogger returns a new logger that writes out the entries, with their fields, to a sugared logger. For
+// example, embedded.NewSugaredLogger(zapLogger.Sugar()) logs through zap.
+func NewSugaredLogger(logger Sugared) Logger {
+	return logging.NewSugared(logger)
+}
@@ -0,0 +1,62 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package embedded
+
+import (
+	"github.com/kelindar/talaria/internal/ingress/s3sqs"
+	"github.com/kelindar/talaria/internal/monitor"
+)
+
+// Option is a functional parameter used to configure the node.
+type Option func(options *nodeOptions)
+
+// nodeOptions defines the settings of an embedded node
+type nodeOptions struct {
+	Addr    string           // The address of the node, given to Presto along with the splits
+	YAML    []byte           // The config of the node, in the format of the config file (optional)
+	Monitor monitor.Monitor  // The monitor of the node, logging to stdout by default (optional)
+	Logger  Logger           // The logger of the node, if no monitor is given (optional)
+	Queue   s3sqs.Reader     // The queue notifying the files to ingest (optional)
+	Bucket  s3sqs.Downloader // The storage the files notified are downloaded from (optional)
+}
+
+// WithAddress specifies the address at which Presto reaches the node to read the splits, defaults to 127.0.0.1.
+func WithAddress(addr string) Option {
+	return func(options *nodeOptions) {
+		options.Addr = addr
+	}
+}
+
+// WithConfig specifies the config of the node, in the YAML format of the config file, such as the tables to open,
+// the schema of Presto or the HTTP listeners. The storage directory is always the one of the node.
+func WithConfig(yaml []byte) Option {
+	return func(options *nodeOptions) {
+		options.YAML = yaml
+	}
+}
+
+// WithMonitor specifies the monitor receiving the logs and the metrics of the node, such as the in-memory monitor
+// of the talariatest package.
+func WithMonitor(monitor monitor.Monitor) Option {
+	return func(options *nodeOptions) {
+		options.Monitor = monitor
+	}
+}
+
+// WithLogger specifies the logger receiving the logs of the node, such as a zap logger adapted by NewSugaredLogger
+// or any other structured logger adapted by a LogFunc. It is ignored if a monitor is given.
+func WithLogger(logger Logger) Option {
+	return func(options *nodeOptions) {
+		options.Logger = logger
+	}
+}
+
+// WithS3SQS ingests the ORC files notified through a queue once the node is listening, downloading them from
+// the bucket, instead of the S3/SQS ingestion of the config.
+func WithS3SQS(queue s3sqs.Reader, bucket s3sqs.Downloader) Option {
+	return func(options *nodeOptions) {
+		options.Queue = queue
+		options.Bucket = bucket
+	}
+}
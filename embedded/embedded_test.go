@@ -0,0 +1,86 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package embedded
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// columnsOf returns the columns of the tables, by table
+func columnsOf(t *testing.T, n *Node) map[string][]string {
+	described, err := n.server.Describe(context.Background(), &talaria.DescribeRequest{})
+	assert.NoError(t, err)
+
+	out := make(map[string][]string)
+	for _, table := range described.Tables {
+		out[table.Table] = nil
+		for _, c := range table.Columns {
+			out[table.Table] = append(out[table.Table], c.Name)
+		}
+	}
+	return out
+}
+
+func TestNode(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "embedded")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	n, err := New(dir, WithConfig([]byte(`
+readers:
+  presto:
+    schema: data
+tables:
+  metrics:
+    hashBy: name
+    sortBy: time
+`)))
+	assert.NoError(t, err)
+
+	// Create a table and append rows to it
+	assert.NoError(t, n.CreateTable("events", Table{TTL: 3600, HashBy: "event", SortBy: "time"}))
+	assert.Error(t, n.CreateTable("events", Table{}))
+	assert.Error(t, n.CreateTable("metrics", Table{}))
+	assert.NoError(t, n.Append(context.Background(), "events",
+		Row{"event": "click", "time": 1600000000, "value": 1.5},
+		Row{"event": "view", "time": 1600000001, "value": 2},
+	))
+
+	columns := columnsOf(t, n)
+	assert.Contains(t, columns, "metrics")
+	assert.ElementsMatch(t, []string{"event", "time", "value"}, columns["events"])
+	assert.Error(t, n.Append(context.Background(), "unknown", Row{"event": "click"}))
+
+	// Once closed, the node can not be used
+	assert.NoError(t, n.Close())
+	assert.Error(t, n.Append(context.Background(), "events", Row{"event": "click"}))
+
+	// The tables created through code are opened again
+	n, err = New(dir)
+	assert.NoError(t, err)
+	assert.Contains(t, columnsOf(t, n), "events")
+	assert.NoError(t, n.DropTable("events"))
+	assert.NotContains(t, columnsOf(t, n), "events")
+	assert.NoError(t, n.Close())
+}
+
+func TestWithLogger(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "embedded")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var entries []string
+	n, err := New(dir, WithLogger(LogFunc(func(level Level, msg string, fields []Field) {
+		entries = append(entries, string(level)+": "+msg)
+	})))
+	assert.NoError(t, err)
+
+	n.monitor.Info("hello %s", "world")
+	assert.Contains(t, entries, "info: hello world")
+	assert.NoError(t, n.Close())
+}
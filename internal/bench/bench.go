@@ -0,0 +1,171 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// Options represents the options of a benchmark
+type Options struct {
+	Schema      typeof.Schema // The schema of the synthetic events
+	HashBy      string        // The column used as key, added as a string if missing from the schema
+	SortBy      string        // The column used as time, added as an int64 if missing from the schema
+	Rate        int           // The target number of events sent per second, unlimited if zero
+	Batch       int           // The number of events sent in each request
+	Concurrency int           // The number of requests in flight
+	Duration    time.Duration // The duration of the benchmark
+}
+
+// Report represents the outcome of a benchmark
+type Report struct {
+	Requests int64         // The number of requests sent
+	Failed   int64         // The number of requests which failed
+	Events   int64         // The number of events ingested successfully
+	Elapsed  time.Duration // The time it took to send the requests
+	P50      time.Duration // The median latency of the requests
+	P99      time.Duration // The 99th percentile latency of the requests
+	Max      time.Duration // The maximum latency of the requests
+	Allocs   uint64        // The number of heap objects allocated by the benchmark
+	Bytes    uint64        // The number of bytes allocated by the benchmark
+	Error    error         // The last error, if any request failed
+}
+
+// Throughput returns the number of events ingested per second
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Events) / r.Elapsed.Seconds()
+}
+
+// String returns a human-readable summary of the report
+func (r *Report) String() string {
+	var allocs, bytes uint64
+	if r.Events > 0 {
+		allocs, bytes = r.Allocs/uint64(r.Events), r.Bytes/uint64(r.Events)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "requests:    %d (%d failed)\n", r.Requests, r.Failed)
+	fmt.Fprintf(&sb, "events:      %d in %s\n", r.Events, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&sb, "throughput:  %.0f events/s\n", r.Throughput())
+	fmt.Fprintf(&sb, "latency:     p50 %s, p99 %s, max %s\n", r.P50, r.P99, r.Max)
+	fmt.Fprintf(&sb, "allocations: %d objects (%d bytes) per event\n", allocs, bytes)
+	if r.Error != nil {
+		fmt.Fprintf(&sb, "last error:  %s\n", r.Error)
+	}
+	return sb.String()
+}
+
+// Run generates synthetic events and sends them in batches through the sender, at the target rate and until
+// the duration elapsed or the context is cancelled. It returns the throughput, the latency of the requests and
+// the allocations of the process per event.
+func Run(ctx context.Context, sender Sender, options Options) *Report {
+	if options.Batch <= 0 {
+		options.Batch = 1
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, options.Duration)
+	defer cancel()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	// Send the batches concurrently, recording the latency of each request
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	report := new(Report)
+	latencies := make([]time.Duration, 0, 1024)
+	queue := make(chan []client.Event, options.Concurrency)
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for events := range queue {
+				start := time.Now()
+				err := sender.Send(context.Background(), events)
+				elapsed := time.Since(start)
+
+				lock.Lock()
+				report.Requests++
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					report.Failed++
+					report.Error = err
+				} else {
+					report.Events += int64(len(events))
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// Generate the batches at the target rate
+	start := time.Now()
+	generate := newGenerator(options.Schema, options.HashBy, options.SortBy)
+	pace := pacer(options.Rate, options.Batch)
+	for ctx.Err() == nil {
+		select {
+		case queue <- generate.Generate(options.Batch):
+		case <-ctx.Done():
+		}
+
+		select {
+		case <-pace():
+		case <-ctx.Done():
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	report.Allocs = after.Mallocs - before.Mallocs
+	report.Bytes = after.TotalAlloc - before.TotalAlloc
+	report.P50, report.P99, report.Max = percentiles(latencies)
+	return report
+}
+
+// pacer returns a function which waits for the next batch to be due, so that the events are sent at the rate
+func pacer(rate, batch int) func() <-chan time.Time {
+	if rate <= 0 {
+		now := make(chan time.Time)
+		close(now)
+		return func() <-chan time.Time { return now }
+	}
+
+	interval := time.Duration(float64(time.Second) * float64(batch) / float64(rate))
+	next := time.Now()
+	return func() <-chan time.Time {
+		next = next.Add(interval)
+		return time.After(time.Until(next))
+	}
+}
+
+// percentiles returns the median, the 99th percentile and the maximum of the latencies
+func percentiles(latencies []time.Duration) (p50, p99, max time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	return at(0.50), at(0.99), latencies[len(latencies)-1]
+}
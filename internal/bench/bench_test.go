@@ -0,0 +1,114 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package bench
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+// senderFunc represents a mock sender
+type senderFunc func([]client.Event) error
+
+func (f senderFunc) Send(_ context.Context, events []client.Event) error { return f(events) }
+func (f senderFunc) Close() error                                        { return nil }
+
+func TestGenerate(t *testing.T) {
+	g := newGenerator(typeof.Schema{
+		"a": typeof.Int32,
+		"b": typeof.Float64,
+		"c": typeof.String,
+		"d": typeof.Bool,
+		"e": typeof.JSON,
+	}, "event", "tsi")
+
+	events := g.Generate(10)
+	assert.Len(t, events, 10)
+	for _, event := range events {
+		assert.Len(t, event, 7)
+		assert.IsType(t, int32(0), event["a"])
+		assert.IsType(t, float64(0), event["b"])
+		assert.IsType(t, "", event["c"])
+		assert.IsType(t, true, event["d"])
+		assert.IsType(t, "", event["event"])
+		assert.IsType(t, int64(0), event["tsi"])
+	}
+}
+
+func TestRun(t *testing.T) {
+	var sent int64
+	sender := senderFunc(func(events []client.Event) error {
+		if atomic.AddInt64(&sent, 1) == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	report := Run(context.Background(), sender, Options{
+		Schema:      typeof.Schema{"value": typeof.Int64},
+		HashBy:      "event",
+		SortBy:      "tsi",
+		Rate:        1000,
+		Batch:       10,
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+	})
+
+	assert.Equal(t, sent, report.Requests)
+	assert.Equal(t, int64(1), report.Failed)
+	assert.Equal(t, 10*(report.Requests-1), report.Events)
+	assert.InDelta(t, 20, report.Requests, 10)
+	assert.True(t, report.P99 >= report.P50)
+	assert.NotZero(t, report.Allocs)
+	assert.Contains(t, report.String(), "throughput:")
+	assert.EqualError(t, report.Error, "boom")
+}
+
+func TestHTTP(t *testing.T) {
+	var body, table, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, table, contentType = string(b), r.URL.Query().Get("table"), r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sender, err := NewHTTP(server.URL, "", time.Second, "eventlog")
+	assert.NoError(t, err)
+	defer sender.Close()
+
+	err = sender.Send(context.Background(), []client.Event{
+		{"event": "a", "tsi": int64(1), "value": 1.5},
+		{"event": "b", "tsi": int64(2), "value": 2.0},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "eventlog", table)
+	assert.Equal(t, "text/csv", contentType)
+	assert.Equal(t, "event,tsi,value\na,1,1.5\nb,2,2\n", body)
+}
+
+func TestHTTP_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "table is full", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sender, err := NewHTTP(server.URL, "", time.Second)
+	assert.NoError(t, err)
+
+	err = sender.Send(context.Background(), []client.Event{{"event": "a"}})
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "503"))
+}
@@ -0,0 +1,106 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package bench
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"time"
+
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+const cardinality = 1000 // The number of distinct values of the synthetic strings
+
+// generator represents a generator of synthetic events matching a schema
+type generator struct {
+	schema typeof.Schema // The schema of the events
+	sortBy string        // The column used as time, set to the current time
+	rand   *rand.Rand    // The source of the random values
+	values []string      // The pre-computed string values
+}
+
+// newGenerator creates a new generator of synthetic events. The key and time columns are added to the schema
+// if missing, so that the events are accepted by the table.
+func newGenerator(schema typeof.Schema, hashBy, sortBy string) *generator {
+	columns := make(typeof.Schema, len(schema)+2)
+	for name, typ := range schema {
+		columns[name] = typ
+	}
+	if _, ok := columns[hashBy]; !ok && hashBy != "" {
+		columns[hashBy] = typeof.String
+	}
+	if _, ok := columns[sortBy]; !ok && sortBy != "" {
+		columns[sortBy] = typeof.Int64
+	}
+
+	values := make([]string, cardinality)
+	for i := range values {
+		values[i] = "value-" + strconv.Itoa(i)
+	}
+
+	return &generator{
+		schema: columns,
+		sortBy: sortBy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		values: values,
+	}
+}
+
+// Generate generates a batch of synthetic events
+func (g *generator) Generate(count int) []client.Event {
+	now := time.Now()
+	out := make([]client.Event, 0, count)
+	for i := 0; i < count; i++ {
+		out = append(out, g.next(now))
+	}
+	return out
+}
+
+// next generates a single event, with random values of the type of each column
+func (g *generator) next(now time.Time) client.Event {
+	event := make(client.Event, len(g.schema))
+	for name, typ := range g.schema {
+		if name == g.sortBy {
+			event[name] = timeOf(typ, now)
+			continue
+		}
+
+		switch typ {
+		case typeof.Int32:
+			event[name] = g.rand.Int31()
+		case typeof.Int64:
+			event[name] = g.rand.Int63()
+		case typeof.Float64:
+			event[name] = g.rand.Float64()
+		case typeof.String:
+			event[name] = g.values[g.rand.Intn(len(g.values))]
+		case typeof.Bool:
+			event[name] = g.rand.Intn(2) == 1
+		case typeof.Timestamp:
+			event[name] = now
+		case typeof.JSON:
+			event[name] = json.RawMessage(`{"value":` + strconv.Itoa(g.rand.Intn(cardinality)) + `}`)
+		}
+	}
+	return event
+}
+
+// timeOf returns the time in the type of the time column
+func timeOf(typ typeof.Type, now time.Time) interface{} {
+	switch typ {
+	case typeof.Int32:
+		return int32(now.Unix())
+	case typeof.Int64:
+		return now.Unix()
+	case typeof.Float64:
+		return float64(now.UnixNano()) / float64(time.Second)
+	case typeof.String:
+		return now.UTC().Format(time.RFC3339)
+	default:
+		return now
+	}
+}
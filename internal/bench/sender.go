@@ -0,0 +1,187 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Sender represents an ingestion path the events are sent through
+type Sender interface {
+	io.Closer
+	Send(ctx context.Context, events []client.Event) error
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// grpcSender sends the events as batches to the gRPC ingress
+type grpcSender struct {
+	client *client.Client
+	tables []string
+}
+
+// NewGRPC creates a sender for the gRPC ingress at the address. The circuit breaker of the client is relaxed,
+// so that the errors are reported rather than short-circuited.
+func NewGRPC(address, token string, concurrency int, timeout time.Duration, tables ...string) (Sender, error) {
+	options := []client.Option{client.WithCircuit(timeout, concurrency, 100)}
+	if token != "" {
+		options = append(options, client.WithToken(token))
+	}
+
+	c, err := client.Dial(address, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcSender{client: c, tables: tables}, nil
+}
+
+// Send sends a batch of events
+func (s *grpcSender) Send(ctx context.Context, events []client.Event) error {
+	if len(s.tables) > 0 {
+		ctx = client.WithTables(ctx, s.tables...)
+	}
+	return s.client.IngestBatch(ctx, events)
+}
+
+// Close closes the connection
+func (s *grpcSender) Close() error {
+	return s.client.Close()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// httpSender sends the events as CSV files to the HTTP ingress
+type httpSender struct {
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+// NewHTTP creates a sender for the HTTP ingress at the base address, such as "http://localhost:8081"
+func NewHTTP(address, token string, timeout time.Duration, tables ...string) (Sender, error) {
+	endpoint, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Path = "/v1/ingest"
+	query := endpoint.Query()
+	for _, table := range tables {
+		query.Add("table", table)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	return &httpSender{
+		client:   &http.Client{Timeout: timeout},
+		endpoint: endpoint.String(),
+		token:    token,
+	}, nil
+}
+
+// Send sends a batch of events
+func (s *httpSender) Send(ctx context.Context, events []client.Event) error {
+	body, err := encodeCSV(events)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/csv")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New(fmt.Sprintf("bench: ingestion failed with status %d: %s", resp.StatusCode, message))
+	}
+	return nil
+}
+
+// Close closes the idle connections
+func (s *httpSender) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// encodeCSV encodes the events as a comma-separated file, with a header listing the columns of the events
+func encodeCSV(events []client.Event) ([]byte, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	header := make([]string, 0, len(events[0]))
+	for name := range events[0] {
+		header = append(header, name)
+	}
+	sort.Strings(header)
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	record := make([]string, len(header))
+	for _, event := range events {
+		for i, name := range header {
+			record[i] = formatValue(event[name])
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buffer.Bytes(), w.Error()
+}
+
+// formatValue formats a value of a synthetic event
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case json.RawMessage:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
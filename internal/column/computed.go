@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sync/atomic"
 	"time"
@@ -33,6 +34,13 @@ type Computed interface {
 	Value(map[string]interface{}) (interface{}, error)
 }
 
+// Multi represents a set of computed columns which are produced by a single script invocation
+type Multi interface {
+	Computed
+	Schema() typeof.Schema
+	Values(map[string]interface{}) (map[string]interface{}, error)
+}
+
 // NewComputed creates a new script from a string
 func NewComputed(name string, typ typeof.Type, uriOrCode string, loader *script.Loader) (Computed, error) {
 	switch uriOrCode {
@@ -40,6 +48,19 @@ func NewComputed(name string, typ typeof.Type, uriOrCode string, loader *script.
 		return newIdentifier(name), nil
 	case "make://timestamp":
 		return newTimestamp(name), nil
+	case "make://ingestion":
+		return newIngestion(name), nil
+	}
+
+	if code, err := loadCompiled(name, uriOrCode, loader); code != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+
+		return &compiled{
+			code: code,
+			typ:  typ,
+		}, nil
 	}
 
 	s, err := loader.Load(name, uriOrCode)
@@ -53,12 +74,36 @@ func NewComputed(name string, typ typeof.Type, uriOrCode string, loader *script.
 	}, nil
 }
 
+// NewMulti creates a new script from a string, which computes all of the columns of the schema at once
+func NewMulti(name string, schema typeof.Schema, uriOrCode string, loader *script.Loader) (Multi, error) {
+	if code, err := loadCompiled(name, uriOrCode, loader); code != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+
+		return &multiCompiled{
+			code:   code,
+			schema: schema,
+		}, nil
+	}
+
+	s, err := loader.Load(name, uriOrCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &multi{
+		code:   s,
+		schema: schema,
+	}, nil
+}
+
 // ------------------------------------------------------------------------------------------------------------
 
 // scripted represents a computed column computed through a lua script
 type scripted struct {
-	code *lua.Script // The script associated with the column
-	typ  typeof.Type // The type of the column
+	code *script.Script // The script associated with the column
+	typ  typeof.Type    // The type of the column
 }
 
 // Name returns the name of the column
@@ -73,21 +118,23 @@ func (c *scripted) Type() typeof.Type {
 
 // Value computes the column value for the row
 func (c *scripted) Value(row map[string]interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
-	// Run the script
-	out, err := c.code.Run(ctx, row)
+	// Run the script, bounded by the time limit of the sandbox
+	out, err := c.code.Run(context.Background(), row)
 	if err != nil {
 		return nil, err
 	}
 
+	return valueOf(c.typ, out)
+}
+
+// valueOf converts the output of a script to a value of the specified type
+func valueOf(typ typeof.Type, out lua.Value) (interface{}, error) {
 	// If there's no new row generated, return nil
 	if out.Type() == lua.TypeNil {
 		return nil, nil
 	}
 
-	switch c.typ {
+	switch typ {
 	case typeof.Bool:
 		if v, ok := out.(lua.Bool); ok {
 			return bool(v), nil
@@ -111,7 +158,267 @@ func (c *scripted) Value(row map[string]interface{}) (interface{}, error) {
 	}
 
 	// Type mismatch
-	return nil, fmt.Errorf("script expects %s type but got %T", c.typ.String(), out)
+	return nil, fmt.Errorf("script expects %s type but got %T", typ.String(), out)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// multi represents a set of computed columns computed through a single invocation of a lua script
+type multi struct {
+	code   *script.Script // The script associated with the columns
+	schema typeof.Schema  // The names and types of the columns produced
+}
+
+// Name returns the name of the column
+func (c *multi) Name() string {
+	return c.code.Name()
+}
+
+// Type returns the type of the column
+func (c *multi) Type() typeof.Type {
+	return typeof.JSON
+}
+
+// Schema returns the columns produced by the script
+func (c *multi) Schema() typeof.Schema {
+	return c.schema
+}
+
+// Value computes all of the columns for the row and returns them encoded as a single JSON object
+func (c *multi) Value(row map[string]interface{}) (interface{}, error) {
+	values, err := c.Values(row)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Values computes all of the columns for the row. The script is expected to return a table keyed by the
+// column names, and the values which are missing or not part of the schema are skipped.
+func (c *multi) Values(row map[string]interface{}) (map[string]interface{}, error) {
+	// Run the script, bounded by the time limit of the sandbox
+	out, err := c.code.Run(context.Background(), row)
+	if err != nil {
+		return nil, err
+	}
+
+	// The script must return a table, unless there's no new row generated
+	table, ok := out.(lua.Table)
+	if !ok {
+		if out.Type() == lua.TypeNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("script expects a table but got %T", out)
+	}
+
+	values := make(map[string]interface{}, len(c.schema))
+	for name, typ := range c.schema {
+		v, ok := table[name]
+		if !ok {
+			continue
+		}
+
+		value, err := valueOf(typ, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			values[name] = value
+		}
+	}
+
+	return values, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// program represents a JavaScript script or a WebAssembly module, which returns Go values rather than Lua ones
+type program interface {
+	Name() string
+	Run(ctx context.Context, row map[string]interface{}) (interface{}, error)
+}
+
+// loadCompiled loads a JavaScript script or a WebAssembly module, or returns nil if the code is a Lua script
+func loadCompiled(name, uriOrCode string, loader *script.Loader) (program, error) {
+	switch loader.EngineOf(uriOrCode) {
+	case script.EngineWASM:
+		return loader.LoadWASM(name, uriOrCode)
+	case script.EngineJavaScript:
+		return loader.LoadJavaScript(name, uriOrCode)
+	default:
+		return nil, nil
+	}
+}
+
+// compiled represents a computed column computed through a JavaScript script or a WebAssembly module
+type compiled struct {
+	code program     // The program associated with the column
+	typ  typeof.Type // The type of the column
+}
+
+// Name returns the name of the column
+func (c *compiled) Name() string {
+	return c.code.Name()
+}
+
+// Type returns the type of the column
+func (c *compiled) Type() typeof.Type {
+	return c.typ
+}
+
+// Value computes the column value for the row
+func (c *compiled) Value(row map[string]interface{}) (interface{}, error) {
+	// Run the program, bounded by the time limit of the sandbox
+	out, err := c.code.Run(context.Background(), row)
+	if err != nil {
+		return nil, err
+	}
+
+	return valueOfGo(c.typ, out)
+}
+
+// valueOfGo converts the output of a program to a value of the specified type. The numbers are either decoded
+// from JSON or exported from JavaScript, and the JSON columns are encoded if the program returned an object.
+func valueOfGo(typ typeof.Type, out interface{}) (interface{}, error) {
+	if out == nil {
+		return nil, nil
+	}
+
+	switch typ {
+	case typeof.Bool:
+		if v, ok := out.(bool); ok {
+			return v, nil
+		}
+	case typeof.Int32:
+		if v, ok := floatOf(out); ok {
+			return int32(v), nil
+		}
+	case typeof.Int64, typeof.Timestamp:
+		if v, ok := out.(json.Number); ok {
+			if n, err := v.Int64(); err == nil {
+				return n, nil
+			}
+		}
+		if v, ok := out.(int64); ok {
+			return v, nil
+		}
+		if v, ok := floatOf(out); ok {
+			return int64(v), nil
+		}
+	case typeof.Float64:
+		if v, ok := floatOf(out); ok {
+			return v, nil
+		}
+	case typeof.String:
+		if v, ok := out.(string); ok {
+			return v, nil
+		}
+	case typeof.JSON:
+		if v, ok := out.(string); ok {
+			return v, nil
+		}
+
+		b, err := json.Marshal(out)
+		return string(b), err
+	}
+
+	// Type mismatch
+	return nil, fmt.Errorf("script expects %s type but got %T", typ.String(), out)
+}
+
+// floatOf returns a number decoded from JSON or exported from JavaScript as a float
+func floatOf(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// multiCompiled represents a set of computed columns computed through a single invocation of a JavaScript script
+// or a WebAssembly module
+type multiCompiled struct {
+	code   program       // The program associated with the columns
+	schema typeof.Schema // The names and types of the columns produced
+}
+
+// Name returns the name of the column
+func (c *multiCompiled) Name() string {
+	return c.code.Name()
+}
+
+// Type returns the type of the column
+func (c *multiCompiled) Type() typeof.Type {
+	return typeof.JSON
+}
+
+// Schema returns the columns produced by the program
+func (c *multiCompiled) Schema() typeof.Schema {
+	return c.schema
+}
+
+// Value computes all of the columns for the row and returns them encoded as a single JSON object
+func (c *multiCompiled) Value(row map[string]interface{}) (interface{}, error) {
+	values, err := c.Values(row)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Values computes all of the columns for the row. The program is expected to return an object keyed by the
+// column names, and the values which are missing or not part of the schema are skipped.
+func (c *multiCompiled) Values(row map[string]interface{}) (map[string]interface{}, error) {
+	// Run the program, bounded by the time limit of the sandbox
+	out, err := c.code.Run(context.Background(), row)
+	if err != nil || out == nil {
+		return nil, err
+	}
+
+	object, ok := out.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("script expects an object but got %T", out)
+	}
+
+	values := make(map[string]interface{}, len(c.schema))
+	for name, typ := range c.schema {
+		v, ok := object[name]
+		if !ok {
+			continue
+		}
+
+		value, err := valueOfGo(typ, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			values[name] = value
+		}
+	}
+
+	return values, nil
 }
 
 // ------------------------------------------------------------------------------------------------------------
@@ -183,3 +490,32 @@ func (c *timestamp) Type() typeof.Type {
 func (c *timestamp) Value(row map[string]interface{}) (interface{}, error) {
 	return time.Now().UTC().Unix(), nil
 }
+
+// ------------------------------------------------------------------------------------------------------------
+
+// ingestion represents a computed column which records the ingestion time, in unix nanoseconds
+type ingestion struct {
+	name string // Name of the column
+}
+
+// newIngestion creates a new ingestion time column
+func newIngestion(name string) *ingestion {
+	return &ingestion{
+		name: name,
+	}
+}
+
+// Name returns the name of the column
+func (c *ingestion) Name() string {
+	return c.name
+}
+
+// Type returns the type of the column
+func (c *ingestion) Type() typeof.Type {
+	return typeof.Int64
+}
+
+// Value computes the column value for the row
+func (c *ingestion) Value(row map[string]interface{}) (interface{}, error) {
+	return time.Now().UnixNano(), nil
+}
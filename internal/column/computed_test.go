@@ -4,8 +4,14 @@
 package column
 
 import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/kelindar/talaria/internal/scripting"
 	"github.com/stretchr/testify/assert"
@@ -53,6 +59,19 @@ func Test_Timestamp(t *testing.T) {
 	assert.NotZero(t, out.(int64))
 }
 
+func Test_Ingestion(t *testing.T) {
+	c, err := NewComputed("ingested", typeof.Int64, "make://ingestion", nil)
+	assert.NoError(t, err)
+	out, err := c.Value(map[string]interface{}{
+		"a": 1,
+	})
+
+	assert.Equal(t, "ingested", c.Name())
+	assert.Equal(t, typeof.Int64, c.Type())
+	assert.NoError(t, err)
+	assert.True(t, out.(int64) > time.Now().Add(-time.Minute).UnixNano())
+}
+
 func Test_Download(t *testing.T) {
 	l := script.NewLoader(nil)
 	c, err := NewComputed("data", typeof.JSON, "https://raw.githubusercontent.com/kelindar/lua/master/fixtures/json.lua", l)
@@ -66,6 +85,102 @@ func Test_Download(t *testing.T) {
 	assert.Equal(t, `{"a":1,"b":"hello"}`, out)
 }
 
+func Test_Multi(t *testing.T) {
+	c, err := NewMulti("split", typeof.Schema{
+		"host": typeof.String,
+		"port": typeof.Int64,
+	}, `
+	function main(row)
+		local host, port = string.match(row["addr"], "(.+):(%d+)")
+		return { host = host, port = tonumber(port), other = "skipped" }
+	end`, script.NewLoader(nil))
+	assert.NoError(t, err)
+
+	values, err := c.Values(map[string]interface{}{
+		"addr": "127.0.0.1:8080",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host": "127.0.0.1",
+		"port": int64(8080),
+	}, values)
+
+	out, err := c.Value(map[string]interface{}{
+		"addr": "127.0.0.1:8080",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, typeof.JSON, c.Type())
+	assert.Equal(t, `{"host":"127.0.0.1","port":8080}`, out)
+}
+
+func Test_WASM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A module whose main function returns the row it was given
+	echo, _ := hex.DecodeString("0061736d01000000010c0260017f017f60027f7f017e030302000105030100010607017f014180080b071903066d656d" +
+		"6f7279020005616c6c6f630000046d61696e00010a1a020b002300230020006a24000b0c002000ad4220862001ad840b")
+	path := filepath.Join(dir, "echo.wasm")
+	assert.NoError(t, ioutil.WriteFile(path, echo, 0644))
+
+	c, err := NewComputed("data", typeof.JSON, "file:///"+filepath.ToSlash(path), script.NewLoader(nil))
+	assert.NoError(t, err)
+	out, err := c.Value(map[string]interface{}{"a": 1, "b": "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1,"b":"hello"}`, out)
+
+	m, err := NewMulti("split", typeof.Schema{
+		"host": typeof.String,
+		"port": typeof.Int64,
+	}, "file:///"+filepath.ToSlash(path), script.NewLoader(nil))
+	assert.NoError(t, err)
+
+	values, err := m.Values(map[string]interface{}{"host": "127.0.0.1", "port": 8080, "other": "skipped"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host": "127.0.0.1",
+		"port": int64(8080),
+	}, values)
+
+	// The values of another type are rejected
+	_, err = m.Values(map[string]interface{}{"port": "8080"})
+	assert.Error(t, err)
+}
+
+func Test_JavaScript(t *testing.T) {
+	l := script.NewLoader(nil)
+	l.SetLimits(config.Scripting{Engine: script.EngineJavaScript})
+
+	// A filter, as used by the ingestion pipelines
+	c, err := NewComputed("filter", typeof.Bool, "function main(row) {\n return row.level !== 'debug';\n}", l)
+	assert.NoError(t, err)
+	out, err := c.Value(map[string]interface{}{"level": "debug"})
+	assert.NoError(t, err)
+	assert.Equal(t, false, out)
+
+	m, err := NewMulti("split", typeof.Schema{
+		"host":  typeof.String,
+		"port":  typeof.Int64,
+		"ratio": typeof.Float64,
+		"meta":  typeof.JSON,
+	}, `
+	function main(row) {
+		var parts = row.addr.split(":");
+		return { host: parts[0], port: parseInt(parts[1]), ratio: 0.5, meta: { tls: true }, other: "skipped" };
+	}`, l)
+	assert.NoError(t, err)
+
+	values, err := m.Values(map[string]interface{}{"addr": "127.0.0.1:8080"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host":  "127.0.0.1",
+		"port":  int64(8080),
+		"ratio": 0.5,
+		"meta":  `{"tls":true}`,
+	}, values)
+}
+
 func newDataColumn(t *testing.T) Computed {
 	l := script.NewLoader(nil)
 	c, err := NewComputed("data", typeof.JSON, `
@@ -0,0 +1,244 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package column
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// lookup represents a set of computed columns which are joined from a reference dataset on a key
+type lookup struct {
+	sync.RWMutex
+	name   string                            // The name of the dataset
+	key    string                            // The column to join on
+	schema typeof.Schema                     // The columns to add from the dataset
+	json   bool                              // Whether the dataset is encoded as JSON, otherwise CSV
+	data   map[string]map[string]interface{} // The dataset, indexed by key
+}
+
+// NewLookup creates a set of computed columns which are looked up from a reference dataset (CSV or JSON,
+// depending on the extension of the URL) by the value of the key column. The dataset is kept in memory
+// and reloaded from the source whenever it is modified, checking at the specified interval.
+func NewLookup(uri, key string, schema typeof.Schema, interval time.Duration) (Multi, error) {
+	c := &lookup{
+		name:   uri,
+		key:    key,
+		schema: schema,
+		json:   strings.HasSuffix(uri, ".json") || strings.HasSuffix(uri, ".ndjson"),
+	}
+
+	// Load the dataset right away
+	updates := Loader.Watch(context.Background(), uri, interval)
+	u := <-updates
+	if u.Err != nil {
+		return nil, u.Err
+	}
+
+	if err := c.update(u.Data); err != nil {
+		return nil, err
+	}
+
+	// Reload the dataset asynchronously, keeping the previous one if it can not be decoded
+	async.Invoke(context.Background(), func(ctx context.Context) (interface{}, error) {
+		for u := range updates {
+			if u.Err == nil {
+				_ = c.update(u.Data)
+			}
+		}
+		return nil, nil
+	})
+
+	return c, nil
+}
+
+// Name returns the name of the column
+func (c *lookup) Name() string {
+	return c.name
+}
+
+// Type returns the type of the column
+func (c *lookup) Type() typeof.Type {
+	return typeof.JSON
+}
+
+// Schema returns the columns produced by the lookup
+func (c *lookup) Schema() typeof.Schema {
+	return c.schema
+}
+
+// Value computes all of the columns for the row and returns them encoded as a single JSON object
+func (c *lookup) Value(row map[string]interface{}) (interface{}, error) {
+	values, err := c.Values(row)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Values looks up the columns for the row
+func (c *lookup) Values(row map[string]interface{}) (map[string]interface{}, error) {
+	key, ok := row[c.key]
+	if !ok || key == nil {
+		return nil, nil
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	return c.data[fmt.Sprintf("%v", key)], nil
+}
+
+// update decodes the dataset and swaps it with the current one
+func (c *lookup) update(b []byte) (err error) {
+	var data map[string]map[string]interface{}
+	if c.json {
+		data, err = c.decodeJSON(b)
+	} else {
+		data, err = c.decodeCSV(b)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	c.data = data
+	c.Unlock()
+	return nil
+}
+
+// decodeCSV decodes a CSV dataset with a header
+func (c *lookup) decodeCSV(b []byte) (map[string]map[string]interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("lookup: dataset %s is empty", c.name)
+	}
+
+	header, keyAt := records[0], -1
+	for i, name := range header {
+		if name == c.key {
+			keyAt = i
+		}
+	}
+
+	if keyAt < 0 {
+		return nil, fmt.Errorf("lookup: dataset %s does not contain the key %s", c.name, c.key)
+	}
+
+	data := make(map[string]map[string]interface{}, len(records)-1)
+	for _, record := range records[1:] {
+		values := make(map[string]interface{}, len(c.schema))
+		for i, v := range record {
+			if typ, ok := c.schema[header[i]]; ok {
+				if value, ok := convertTo(v, typ); ok {
+					values[header[i]] = value
+				}
+			}
+		}
+		data[record[keyAt]] = values
+	}
+	return data, nil
+}
+
+// decodeJSON decodes a JSON dataset, either as an array of objects or newline-delimited objects
+func (c *lookup) decodeJSON(b []byte) (map[string]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(bytes.TrimSpace(b)))
+	decoder.UseNumber()
+
+	// Skip the opening bracket of the array, if any
+	isArray := bytes.HasPrefix(bytes.TrimSpace(b), []byte("["))
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	data := make(map[string]map[string]interface{})
+	for decoder.More() {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			return nil, err
+		}
+
+		key, ok := record[c.key]
+		if !ok {
+			continue
+		}
+
+		values := make(map[string]interface{}, len(c.schema))
+		for name, typ := range c.schema {
+			if value, ok := convertTo(record[name], typ); ok {
+				values[name] = value
+			}
+		}
+		data[fmt.Sprintf("%v", key)] = values
+	}
+
+	if isArray {
+		if _, err := decoder.Token(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// convertTo converts a decoded value to the specified type
+func convertTo(v interface{}, typ typeof.Type) (interface{}, bool) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		if typ != typeof.JSON {
+			return nil, false
+		}
+
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	}
+
+	switch typ {
+	case typeof.String, typeof.JSON:
+		return s, true
+	case typeof.Bool:
+		v, err := strconv.ParseBool(s)
+		return v, err == nil
+	case typeof.Int32:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return int32(v), err == nil
+	case typeof.Int64, typeof.Timestamp:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return v, err == nil
+	case typeof.Float64:
+		v, err := strconv.ParseFloat(s, 64)
+		return v, err == nil
+	}
+	return nil, false
+}
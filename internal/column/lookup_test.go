@@ -0,0 +1,62 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package column
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lookup(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	schema := typeof.Schema{
+		"city_name":  typeof.String,
+		"population": typeof.Int64,
+	}
+
+	datasets := map[string]string{
+		"cities.csv":    "city_id,city_name,population,country\n1,Singapore,5600000,SG\n2,Jakarta,10560000,ID\n",
+		"cities.json":   `[{"city_id": 1, "city_name": "Singapore", "population": 5600000}, {"city_id": 2, "city_name": "Jakarta", "population": 10560000}]`,
+		"cities.ndjson": "{\"city_id\": \"1\", \"city_name\": \"Singapore\", \"population\": 5600000}\n{\"city_id\": \"2\", \"city_name\": \"Jakarta\"}\n",
+	}
+
+	for file, content := range datasets {
+		path := filepath.Join(dir, file)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+		c, err := NewLookup("file:///"+path, "city_id", schema, time.Minute)
+		assert.NoError(t, err, file)
+		assert.Equal(t, schema, c.Schema())
+
+		values, err := c.Values(map[string]interface{}{"city_id": int64(1)})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"city_name":  "Singapore",
+			"population": int64(5600000),
+		}, values, file)
+
+		values, err = c.Values(map[string]interface{}{"city_id": "3"})
+		assert.NoError(t, err)
+		assert.Nil(t, values)
+	}
+}
+
+func Test_LookupInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "cities.csv")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("id,city_name\n1,Singapore\n"), 0644))
+
+	_, err := NewLookup("file:///"+path, "city_id", typeof.Schema{"city_name": typeof.String}, time.Minute)
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package column
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"unicode"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// The built-in masking functions
+const (
+	MaskSHA256   = "sha256"   // Hashes the value with SHA-256, prefixed with a salt
+	MaskHMAC     = "hmac"     // Computes the HMAC-SHA256 of the value with a secret key
+	MaskTruncate = "truncate" // Keeps only the first N characters of the value
+	MaskRedact   = "mask"     // Replaces letters and digits with '*', except for the last N ones
+)
+
+// NewMask creates a computed column which pseudonymizes the value of a column of the same name
+// using one of the built-in masking functions.
+func NewMask(name, fn, secret string, length int) (Computed, error) {
+	switch fn {
+	case MaskSHA256:
+		return &mask{name: name, fn: func(v string) string {
+			h := sha256.Sum256([]byte(secret + v))
+			return hex.EncodeToString(h[:])
+		}}, nil
+	case MaskHMAC:
+		if secret == "" {
+			return nil, fmt.Errorf("mask: hmac of column %s requires a secret", name)
+		}
+
+		return &mask{name: name, fn: func(v string) string {
+			h := hmac.New(sha256.New, []byte(secret))
+			h.Write([]byte(v))
+			return hex.EncodeToString(h.Sum(nil))
+		}}, nil
+	case MaskTruncate:
+		return &mask{name: name, fn: func(v string) string {
+			if r := []rune(v); len(r) > length {
+				return string(r[:length])
+			}
+			return v
+		}}, nil
+	case MaskRedact:
+		return &mask{name: name, fn: func(v string) string {
+			return redact(v, length)
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("mask: unsupported function %s for column %s", fn, name)
+}
+
+// mask represents a computed column which masks the value of an input column
+type mask struct {
+	name string                // Name of the column
+	fn   func(v string) string // The masking function
+}
+
+// Name returns the name of the column
+func (c *mask) Name() string {
+	return c.name
+}
+
+// Type returns the type of the column
+func (c *mask) Type() typeof.Type {
+	return typeof.String
+}
+
+// Value computes the column value for the row
+func (c *mask) Value(row map[string]interface{}) (interface{}, error) {
+	switch v := row[c.name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return c.fn(v), nil
+	default:
+		return c.fn(fmt.Sprintf("%v", v)), nil
+	}
+}
+
+// redact replaces every letter and digit with '*' while preserving the format of the value
+// (separators, length), leaving the last 'visible' letters or digits untouched.
+func redact(v string, visible int) string {
+	out := []rune(v)
+	for i := len(out) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(out[i]) && !unicode.IsDigit(out[i]) {
+			continue
+		}
+
+		if visible > 0 {
+			visible--
+			continue
+		}
+
+		out[i] = '*'
+	}
+	return string(out)
+}
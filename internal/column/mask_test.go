@@ -0,0 +1,49 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package column
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Mask(t *testing.T) {
+	tests := []struct {
+		fn     string
+		secret string
+		length int
+		input  interface{}
+		output interface{}
+	}{
+		{fn: MaskSHA256, secret: "salt", input: "roman@grab.com", output: "b84a95474b6e3d957a2b4ab0e04240ea0abd1519b328abdf37ad5199ffcb8b15"},
+		{fn: MaskHMAC, secret: "key", input: "roman@grab.com", output: "78ae59ccd02258e1174bedb131db955aba2386f61b5fe532c937614959662acb"},
+		{fn: MaskTruncate, length: 4, input: "+6591234567", output: "+659"},
+		{fn: MaskTruncate, length: 4, input: "+65", output: "+65"},
+		{fn: MaskRedact, length: 4, input: "+65 9123 4567", output: "+** **** 4567"},
+		{fn: MaskRedact, input: "roman@grab.com", output: "*****@****.***"},
+		{fn: MaskRedact, length: 2, input: int64(91234567), output: "******67"},
+		{fn: MaskRedact, input: nil, output: nil},
+	}
+
+	for _, tc := range tests {
+		c, err := NewMask("pii", tc.fn, tc.secret, tc.length)
+		assert.NoError(t, err)
+		assert.Equal(t, "pii", c.Name())
+		assert.Equal(t, typeof.String, c.Type())
+
+		out, err := c.Value(map[string]interface{}{"pii": tc.input})
+		assert.NoError(t, err)
+		assert.Equal(t, tc.output, out, tc.fn)
+	}
+}
+
+func Test_MaskInvalid(t *testing.T) {
+	_, err := NewMask("pii", "xxx", "", 0)
+	assert.Error(t, err)
+
+	_, err = NewMask("pii", MaskHMAC, "", 0)
+	assert.Error(t, err)
+}
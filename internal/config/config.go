@@ -27,21 +27,72 @@ const (
 
 // Config global
 type Config struct {
-	URI      string     `json:"uri" yaml:"uri" env:"URI"`
-	Env      string     `json:"env" yaml:"env" env:"ENV"`             // The environment (eg: prd, stg)
-	AppName  string     `json:"appName" yaml:"appName" env:"APPNAME"` // app name used for monitoring
-	Domain   string     `json:"domain" yaml:"domain" env:"DOMAIN"`
-	Readers  Readers    `json:"readers" yaml:"readers" env:"READERS"`
-	Writers  Writers    `json:"writers" yaml:"writers" env:"WRITERS"`
-	Storage  Storage    `json:"storage" yaml:"storage" env:"STORAGE"`
-	Tables   Tables     `json:"tables" yaml:"tables"`
-	Statsd   *StatsD    `json:"statsd,omitempty" yaml:"statsd" env:"STATSD"`
-	Computed []Computed `json:"computed" yaml:"computed" env:"COMPUTED"`
-	K8s      *K8s       `json:"k8s,omitempty" yaml:"k8s" env:"K8S"`
+	URI       string     `json:"uri" yaml:"uri" env:"URI"`
+	Env       string     `json:"env" yaml:"env" env:"ENV"`             // The environment (eg: prd, stg)
+	Profile   string     `json:"profile" yaml:"profile" env:"PROFILE"` // The profile whose overlay is applied to the config (eg: dev, prod)
+	AppName   string     `json:"appName" yaml:"appName" env:"APPNAME"` // app name used for monitoring
+	Domain    string     `json:"domain" yaml:"domain" env:"DOMAIN"`
+	Readers   Readers    `json:"readers" yaml:"readers" env:"READERS"`
+	Writers   Writers    `json:"writers" yaml:"writers" env:"WRITERS"`
+	Storage   Storage    `json:"storage" yaml:"storage" env:"STORAGE"`
+	Tables    Tables     `json:"tables" yaml:"tables"`
+	Defaults  *Table     `json:"defaults,omitempty" yaml:"defaults"` // The default settings of the tables, which each table can override
+	Statsd    *StatsD    `json:"statsd,omitempty" yaml:"statsd" env:"STATSD"`
+	Computed  []Computed `json:"computed" yaml:"computed" env:"COMPUTED"`
+	Scripting Scripting  `json:"scripting" yaml:"scripting" env:"SCRIPTING"`
+	K8s       *K8s       `json:"k8s,omitempty" yaml:"k8s" env:"K8S"`
+	Cluster   Cluster    `json:"cluster" yaml:"cluster" env:"CLUSTER"`
+	Admin     *Admin     `json:"admin,omitempty" yaml:"admin" env:"ADMIN"`
+	Logging   Logging    `json:"logging" yaml:"logging" env:"LOGGING"`
+	Errors    *Errors    `json:"errors,omitempty" yaml:"errors" env:"ERRORS"`
+	Events    *Events    `json:"events,omitempty" yaml:"events" env:"EVENTS"`
+	Profiling *Profiling `json:"profiling,omitempty" yaml:"profiling" env:"PROFILING"`
+	Audit     *Audit     `json:"audit,omitempty" yaml:"audit" env:"AUDIT"`
+	Memory    *Memory    `json:"memory,omitempty" yaml:"memory" env:"MEMORY"` // The limits of the memory used by the node (optional)
+	Tenants   Tenants    `json:"tenants,omitempty" yaml:"tenants"`            // The tenants sharing the cluster, each with the tables of its namespace (optional)
+	Version   string     `json:"-" yaml:"-"`                                  // The version of the config, a hash of its content set once loaded
+}
+
+// Cluster represents the configuration of the cluster
+type Cluster struct {
+	Ownership   bool      `json:"ownership,omitempty" yaml:"ownership" env:"OWNERSHIP"`       // Whether each node owns a range of the hash keys, rows being forwarded to their owner
+	VNodes      int       `json:"vnodes,omitempty" yaml:"vnodes" env:"VNODES"`                // The number of virtual nodes per member on the hash ring, defaults to 64
+	Replicas    int       `json:"replicas,omitempty" yaml:"replicas" env:"REPLICAS"`          // The number of nodes storing each hash key, defaults to 1
+	Replication string    `json:"replication,omitempty" yaml:"replication" env:"REPLICATION"` // Whether the replicas are written to "sync" (default) or "async"
+	Zone        string    `json:"zone,omitempty" yaml:"zone" env:"ZONE"`                      // The availability zone of the node, gossiped to its peers
+	Discovery   Discovery `json:"discovery" yaml:"discovery" env:"DISCOVERY"`                 // The mechanism to discover the peers to join
+	Rebalance   Rebalance `json:"rebalance" yaml:"rebalance" env:"REBALANCE"`                 // The transfer of the data to the new owners when the membership changes
+	TLS         *TLS      `json:"tls,omitempty" yaml:"tls" env:"TLS"`                         // The certificate of the node and the CA of the cluster, for mutual TLS between the nodes (optional)
+	Keys        []string  `json:"keys,omitempty" yaml:"keys" env:"KEYS"`                      // The base64 keys encrypting the gossip, the first one encrypting and every one decrypting (optional)
+}
+
+// Rebalance represents the transfer of the data to the nodes owning it after the membership changes
+type Rebalance struct {
+	Enabled  bool `json:"enabled,omitempty" yaml:"enabled" env:"ENABLED"`    // Whether the data is moved to its new owner when the membership changes
+	Rate     int  `json:"rate,omitempty" yaml:"rate" env:"RATE"`             // The maximum transfer rate, in bytes per second, defaults to 10 MB/s
+	Interval int  `json:"interval,omitempty" yaml:"interval" env:"INTERVAL"` // The interval (in seconds) at which the membership is checked, defaults to 60
+}
+
+// Discovery represents the mechanism used to discover the peers of the cluster
+type Discovery struct {
+	Provider  string   `json:"provider,omitempty" yaml:"provider" env:"PROVIDER"`    // The provider, either "dns" (default, resolves the domain), "kubernetes" or "static"
+	Namespace string   `json:"namespace,omitempty" yaml:"namespace" env:"NAMESPACE"` // The namespace of the kubernetes service, defaults to the one of the pod
+	Service   string   `json:"service,omitempty" yaml:"service" env:"SERVICE"`       // The name of the kubernetes service whose endpoints are the peers
+	Interval  int      `json:"interval,omitempty" yaml:"interval" env:"INTERVAL"`    // The interval (in seconds) at which the peers are discovered, defaults to 30
+	Peers     []string `json:"peers,omitempty" yaml:"peers" env:"PEERS"`             // The addresses or hostnames of the peers, for the static provider
+	SRV       string   `json:"srv,omitempty" yaml:"srv" env:"SRV"`                   // The DNS SRV record listing the peers, for the static provider (optional)
+}
+
+// Scripting represents the limits and the engine of the scripting environment
+type Scripting struct {
+	Timeout int64  `json:"timeout,omitempty" yaml:"timeout" env:"TIMEOUT"` // The maximum time (in milliseconds) a single script invocation can run for, defaults to 100ms
+	Unsafe  bool   `json:"unsafe,omitempty" yaml:"unsafe" env:"UNSAFE"`    // Whether the scripts can access the filesystem and the operating system
+	Engine  string `json:"engine,omitempty" yaml:"engine" env:"ENGINE"`    // The engine of the scripts given as code, "lua" (default) or "javascript"
 }
 
 type K8s struct {
-	ProbePort int32 `json:"probePort" yaml:"probePort" env:"PROBEPORT"` // The port which is used for liveness and readiness probes (default: 8080)
+	ProbePort int32   `json:"probePort" yaml:"probePort" env:"PROBEPORT"` // The port which is used for liveness and readiness probes (default: 8080)
+	Headroom  float64 `json:"headroom" yaml:"headroom" env:"HEADROOM"`    // The minimum fraction of free disk space for the node to be ready (default: 0.05)
 }
 
 // Tables is a list of table configs
@@ -49,18 +100,32 @@ type Tables map[string]Table
 
 // Table is the config for the timeseries table
 type Table struct {
-	TTL     int64       `json:"ttl,omitempty" yaml:"ttl" env:"TTL"`          // The ttl (in seconds) for the storage, defaults to 1 hour.
-	HashBy  string      `json:"hashBy,omitempty" yaml:"hashBy" env:"HASHBY"` // The column to use as key (metric), defaults to 'event'.
-	SortBy  string      `json:"sortBy,omitempty" yaml:"sortBy" env:"SORTBY"` // The column to use as time, defaults to 'tsi'.
-	Schema  string      `json:"schema" yaml:"schema" env:"SCHEMA"`           // The schema of the table
-	Compact *Compaction `json:"compact" yaml:"compact" env:"COMPACT"`        // The compaction configuration for the table
-	Streams Streams     `json:"streams" yaml:"streams" env:"STREAMS"`        // The streams to stream data to for data in this table
+	TTL         int64       `json:"ttl,omitempty" yaml:"ttl" env:"TTL"`                         // The ttl (in seconds) for the storage, defaults to 1 hour.
+	HashBy      string      `json:"hashBy,omitempty" yaml:"hashBy" env:"HASHBY"`                // The column to use as key (metric), defaults to 'event'.
+	SortBy      string      `json:"sortBy,omitempty" yaml:"sortBy" env:"SORTBY"`                // The column to use as time, defaults to 'tsi'.
+	IngestedBy  string      `json:"ingestedBy,omitempty" yaml:"ingestedBy" env:"INGESTEDBY"`    // The column to record the ingestion time in, enables time-travel reads.
+	Filter      string      `json:"filter,omitempty" yaml:"filter" env:"FILTER"`                // The script (or its URL) which decides whether an ingested row is kept.
+	Enrich      []Lookup    `json:"enrich,omitempty" yaml:"enrich" env:"ENRICH"`                // The reference datasets to join the ingested rows with.
+	Masks       []Mask      `json:"masks,omitempty" yaml:"masks" env:"MASKS"`                   // The columns to pseudonymize at ingestion.
+	Sample      *Sample     `json:"sample,omitempty" yaml:"sample" env:"SAMPLE"`                // The fraction of the ingested rows to keep.
+	Pipeline    []Stage     `json:"pipeline,omitempty" yaml:"pipeline" env:"PIPELINE"`          // The ordered stages to run on the ingested rows, replaces filter, enrich and masks.
+	Schema      string      `json:"schema" yaml:"schema" env:"SCHEMA"`                          // The schema of the table
+	Compact     *Compaction `json:"compact" yaml:"compact" env:"COMPACT"`                       // The compaction configuration for the table
+	Streams     Streams     `json:"streams" yaml:"streams" env:"STREAMS"`                       // The streams to stream data to for data in this table
+	Concurrency int         `json:"concurrency,omitempty" yaml:"concurrency" env:"CONCURRENCY"` // The maximum number of ingestion requests appended to the table concurrently, unlimited by default.
+	Batch       *Batch      `json:"batch,omitempty" yaml:"batch" env:"BATCH"`                   // The grouping of the concurrent appends into fewer transactions of the local store (optional)
+	EventTime   *EventTime  `json:"eventTime,omitempty" yaml:"eventTime" env:"EVENTTIME"`       // The keying of the rows by their event time, with the handling of the late rows (optional)
+	Timestamps  Timestamps  `json:"timestamps,omitempty" yaml:"timestamps" env:"TIMESTAMPS"`    // The formats of the timestamp columns, parsed at ingestion (optional)
+	TenantBy    string      `json:"tenantBy,omitempty" yaml:"tenantBy" env:"TENANTBY"`          // The column set to the tenant of the producer, which makes the table shared by the tenants (optional)
+	Downsample  []Tier      `json:"downsample,omitempty" yaml:"downsample" env:"DOWNSAMPLE"`    // The tables the compacted rows are aggregated into over longer retentions (optional)
+	Strict      *Strict     `json:"strict,omitempty" yaml:"strict" env:"STRICT"`                // The enforcement of the schema, rejecting the rows which do not match it (optional)
 }
 
 // Storage is the location to write the data
 type Storage struct {
 	Badger
 	Directory string `json:"dir" yaml:"dir" env:"DIR"`
+	Idle      int64  `json:"idle,omitempty" yaml:"idle" env:"IDLE"` // The time (in seconds) after which the storage of a table not written to is closed, until it is used again (optional)
 }
 
 // Badger configures badger K-V store that we use underlying.
@@ -77,33 +142,230 @@ type Badger struct {
 
 // Readers are ways to read the data
 type Readers struct {
-	Presto *Presto `json:"presto" yaml:"presto" env:"PRESTO"`
+	Presto    *Presto    `json:"presto" yaml:"presto" env:"PRESTO"`
+	REST      *REST      `json:"rest,omitempty" yaml:"rest" env:"REST"`
+	FlightSQL *FlightSQL `json:"flightSQL,omitempty" yaml:"flightSQL" env:"FLIGHTSQL"` // The Arrow Flight SQL service, on the gRPC listener (optional)
+	Cache     *Cache     `json:"cache,omitempty" yaml:"cache" env:"CACHE"`
+	SlowQuery *SlowQuery `json:"slowQuery,omitempty" yaml:"slowQuery" env:"SLOWQUERY"`
+	Admission *Admission `json:"admission,omitempty" yaml:"admission" env:"ADMISSION"`
+	ACL       []Access   `json:"acl,omitempty" yaml:"acl" env:"ACL"` // The tables and the columns each client can query, every client querying every table if empty
+}
+
+// Access represents the tables and the columns a client can query
+type Access struct {
+	Identity string              `json:"identity" yaml:"identity" env:"IDENTITY"` // The common name of the client certificate, the subject of the token or the name of the key
+	Tables   []string            `json:"tables" yaml:"tables" env:"TABLES"`       // The tables the client can query, or "*" for any table
+	Columns  map[string][]string `json:"columns" yaml:"columns" env:"COLUMNS"`    // The columns the client can query, per table, every column of the tables not listed (optional)
+	Tenant   string              `json:"tenant" yaml:"tenant" env:"TENANT"`       // The tenant of the client, which can only query the tables of its namespace (optional)
 }
 
 // Writers are sources to write data
 type Writers struct {
-	GRPC  *GRPC  `json:"grpc,omitempty" yaml:"grpc" env:"GRPC"`    // The GRPC ingress
-	S3SQS *S3SQS `json:"s3sqs,omitempty" yaml:"s3sqs" env:"S3SQS"` // The S3SQS ingress
+	GRPC   *GRPC            `json:"grpc,omitempty" yaml:"grpc" env:"GRPC"`       // The GRPC ingress
+	HTTP   *HTTP            `json:"http,omitempty" yaml:"http" env:"HTTP"`       // The HTTP ingress
+	S3SQS  *S3SQS           `json:"s3sqs,omitempty" yaml:"s3sqs" env:"S3SQS"`    // The S3SQS ingress
+	Auth   *Auth            `json:"auth,omitempty" yaml:"auth" env:"AUTH"`       // The authentication of the producers on the gRPC and HTTP ingress (optional)
+	Quotas map[string]Quota `json:"quotas,omitempty" yaml:"quotas" env:"QUOTAS"` // The ingestion quotas, per producer or "*" for each of the other producers (optional)
+	OTLP   *OTLP            `json:"otlp,omitempty" yaml:"otlp" env:"OTLP"`       // The ingestion of the spans and the log records sent with OpenTelemetry, on the gRPC and HTTP ingress (optional)
+	Syslog *Shipper         `json:"syslog,omitempty" yaml:"syslog" env:"SYSLOG"` // The syslog listener (RFC5424), over TCP and UDP (optional)
+	Fluent *Shipper         `json:"fluent,omitempty" yaml:"fluent" env:"FLUENT"` // The listener of the forward protocol of Fluentd and Fluent Bit (optional)
+}
+
+// Shipper represents a listener of the records sent by a log shipper, each ingested into the table of its tag, which
+// is the name of the application for syslog.
+type Shipper struct {
+	Port   int32             `json:"port" yaml:"port" env:"PORT"`              // The port for the listener
+	Tables map[string]string `json:"tables" yaml:"tables" env:"TABLES"`        // The table of each tag or pattern of tags, such as "app.*" or "*" for any tag
+	Token  string            `json:"token,omitempty" yaml:"token" env:"TOKEN"` // The token presented for the records, if the producers are authenticated (optional)
+}
+
+// OTLP represents the ingestion of the telemetry sent with the OpenTelemetry protocol, a row per span or log record
+// with its trace and span identifiers, its attributes and the attributes of its resource as JSON.
+type OTLP struct {
+	Traces string `json:"traces,omitempty" yaml:"traces" env:"TRACES"` // The table the spans are ingested into (optional)
+	Logs   string `json:"logs,omitempty" yaml:"logs" env:"LOGS"`       // The table the log records are ingested into (optional)
+}
+
+// Quota represents the ingestion quota of a producer, identified by its name or by its address if the producers
+// are not authenticated
+type Quota struct {
+	Rate  int64 `json:"rate,omitempty" yaml:"rate" env:"RATE"`    // The events ingested per second (optional)
+	Burst int64 `json:"burst,omitempty" yaml:"burst" env:"BURST"` // The events ingested at once above the rate, defaults to the rate
+	Bytes int64 `json:"bytes,omitempty" yaml:"bytes" env:"BYTES"` // The bytes ingested per day, in UTC (optional)
+}
+
+// Tenants represents the tenants sharing the cluster, by name
+type Tenants map[string]Tenant
+
+// Tenant represents a team sharing the cluster. The tables of a tenant are the ones of its namespace, named
+// after the tenant such as "payments.orders", which the clients of other tenants can neither write to nor query.
+type Tenant struct {
+	Quota   *Quota `json:"quota,omitempty" yaml:"quota" env:"QUOTA"`       // The ingestion quota shared by the producers of the tenant (optional)
+	Storage int64  `json:"storage,omitempty" yaml:"storage" env:"STORAGE"` // The maximum size (in bytes) of the tables of the tenant on each node, unlimited by default
+}
+
+// HTTP represents the configuration for HTTP ingress
+type HTTP struct {
+	Port       int32       `json:"port" yaml:"port" env:"PORT"`                             // The port for the HTTP listener
+	Prometheus *Prometheus `json:"prometheus,omitempty" yaml:"prometheus" env:"PROMETHEUS"` // The ingestion of the samples sent with the remote-write protocol of Prometheus (optional)
+}
+
+// Prometheus represents the ingestion of the samples of Prometheus into a table, a row per sample with the name of
+// its metric, its time in unix milliseconds, its value and its labels as JSON.
+type Prometheus struct {
+	Table  string   `json:"table" yaml:"table" env:"TABLE"`              // The table the samples are ingested into
+	Labels []string `json:"labels,omitempty" yaml:"labels" env:"LABELS"` // The labels also stored as their own column (optional)
+}
+
+// Auth represents the authentication of the producers, each allowed to write to a set of tables
+type Auth struct {
+	Token string   `json:"token" yaml:"token" env:"TOKEN"`     // The token the nodes present when forwarding rows to each other, allowed to write to any table
+	Keys  []APIKey `json:"keys" yaml:"keys" env:"KEYS"`        // The API keys of the producers
+	JWT   *JWT     `json:"jwt,omitempty" yaml:"jwt" env:"JWT"` // The validation of the JSON Web Tokens of the producers (optional)
+}
+
+// APIKey represents the API key of a producer
+type APIKey struct {
+	Name   string   `json:"name" yaml:"name" env:"NAME"`       // The name of the producer, reported in the logs
+	Key    string   `json:"key" yaml:"key" env:"KEY"`          // The API key, sent as a bearer token
+	Tables []string `json:"tables" yaml:"tables" env:"TABLES"` // The tables the producer can write to, or "*" for any table
+	Tenant string   `json:"tenant" yaml:"tenant" env:"TENANT"` // The tenant of the producer (optional)
+}
+
+// JWT represents the validation of the JSON Web Tokens signed with HMAC-SHA256
+type JWT struct {
+	Secret   string `json:"secret" yaml:"secret" env:"SECRET"`       // The secret the tokens are signed with
+	Issuer   string `json:"issuer" yaml:"issuer" env:"ISSUER"`       // The expected issuer of the tokens (optional)
+	Audience string `json:"audience" yaml:"audience" env:"AUDIENCE"` // The expected audience of the tokens (optional)
+	Claim    string `json:"claim" yaml:"claim" env:"CLAIM"`          // The claim listing the tables the producer can write to (default: tables)
+	Tenant   string `json:"tenant" yaml:"tenant" env:"TENANT"`       // The claim naming the tenant of the producer (default: tenant)
 }
 
 // GRPC represents the configuration for gRPC ingress
 type GRPC struct {
-	Port int32 `json:"port" yaml:"port" env:"PORT"` // The port for the gRPC listener (default: 8080)
+	Port    int32 `json:"port" yaml:"port" env:"PORT"`          // The port for the gRPC listener (default: 8080)
+	TLS     *TLS  `json:"tls,omitempty" yaml:"tls" env:"TLS"`   // The TLS configuration of the listener (optional)
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" env:"MAXSIZE"` // The maximum size of a message received, once decompressed, in bytes (default: 32MB)
+}
+
+// TLS represents the certificate of a listener, reloaded once its files are modified
+type TLS struct {
+	Cert     string `json:"cert" yaml:"cert" env:"CERT"`             // The path of the PEM certificate
+	Key      string `json:"key" yaml:"key" env:"KEY"`                // The path of the PEM private key
+	ClientCA string `json:"clientCA" yaml:"clientCA" env:"CLIENTCA"` // The path of the PEM bundle of the CAs issuing the client certificates, which enables mutual TLS (optional)
 }
 
 // S3SQS represents the aws S3 SQS configuration
 type S3SQS struct {
-	Region            string `json:"region" yaml:"region" env:"REGION"`
-	Queue             string `json:"queue" yaml:"queue" env:"QUEUE"`
-	WaitTimeout       int64  `json:"waitTimeout,omitempty" yaml:"waitTimeout" env:"WAITTIMEOUT"`                   // in seconds
-	VisibilityTimeout int64  `json:"visibilityTimeout,omitempty" yaml:"visibilityTimeout" env:"VISIBILITYTIMEOUT"` // in seconds
-	Retries           int    `json:"retries" yaml:"retries" env:"RETRIES"`
+	Region            string        `json:"region" yaml:"region" env:"REGION"`
+	Queue             string        `json:"queue" yaml:"queue" env:"QUEUE"`
+	WaitTimeout       int64         `json:"waitTimeout,omitempty" yaml:"waitTimeout" env:"WAITTIMEOUT"`                   // in seconds
+	VisibilityTimeout int64         `json:"visibilityTimeout,omitempty" yaml:"visibilityTimeout" env:"VISIBILITYTIMEOUT"` // in seconds
+	Retries           int           `json:"retries" yaml:"retries" env:"RETRIES"`
+	MetricsInterval   int64         `json:"metricsInterval,omitempty" yaml:"metricsInterval" env:"METRICSINTERVAL"` // The interval (in seconds) at which the depth of the queue is measured (default: 30)
+	Endpoint          string        `json:"endpoint,omitempty" yaml:"endpoint" env:"ENDPOINT"`                      // The custom endpoint of the store of the files, such as MinIO or Ceph (optional)
+	PathStyle         *bool         `json:"pathStyle,omitempty" yaml:"pathStyle" env:"PATHSTYLE"`                   // Whether to address the bucket in the path, by default with a custom endpoint (optional)
+	DisableSSL        bool          `json:"disableSSL,omitempty" yaml:"disableSSL" env:"DISABLESSL"`                // Whether to use plain HTTP, by default with an "http://" endpoint (optional)
+	CACert            string        `json:"caCert,omitempty" yaml:"caCert" env:"CACERT"`                            // The PEM certificate of a custom certificate authority, or the path to it (optional)
+	Backpressure      *Backpressure `json:"backpressure,omitempty" yaml:"backpressure" env:"BACKPRESSURE"`          // The thresholds above which the queue is no longer polled (optional)
+	Prefetch          *Prefetch     `json:"prefetch,omitempty" yaml:"prefetch" env:"PREFETCH"`                      // The download of the objects ahead of their ingestion (optional)
+}
+
+// Prefetch represents the download of the objects referenced by the received messages while the workers are busy
+type Prefetch struct {
+	Count    int   `json:"count,omitempty" yaml:"count" env:"COUNT"`          // The maximum number of objects downloaded ahead of the workers, the number of CPUs by default
+	MaxBytes int64 `json:"maxBytes,omitempty" yaml:"maxBytes" env:"MAXBYTES"` // The maximum size (in bytes) of the objects downloaded and not ingested yet, 256MB by default
+}
+
+// Backpressure represents the thresholds above which an ingestion source pauses, until the data is ingested and flushed
+type Backpressure struct {
+	MaxInFlight  int64 `json:"maxInFlight,omitempty" yaml:"maxInFlight" env:"MAXINFLIGHT"`    // The maximum size (in bytes) of the data downloaded and not ingested yet, unlimited by default
+	MaxUnflushed int64 `json:"maxUnflushed,omitempty" yaml:"maxUnflushed" env:"MAXUNFLUSHED"` // The maximum size (in bytes) of the data buffered by the tables and not flushed to the sinks yet, unlimited by default
 }
 
 // Presto represents the Presto configuration
 type Presto struct {
 	Port   int32  `json:"port" yaml:"port" env:"PORT"`
 	Schema string `json:"schema" yaml:"schema" env:"SCHEMA"`
+	TLS    *TLS   `json:"tls,omitempty" yaml:"tls" env:"TLS"` // The TLS configuration of the thrift listener (optional)
+}
+
+// REST represents the configuration for the HTTP query endpoint
+type REST struct {
+	Port       int32  `json:"port" yaml:"port" env:"PORT"`                             // The port for the HTTP listener
+	Token      string `json:"token" yaml:"token" env:"TOKEN"`                          // The bearer token required to query, if set
+	Limit      int    `json:"limit" yaml:"limit" env:"LIMIT"`                          // The maximum number of rows returned (default: 1000)
+	Prometheus string `json:"prometheus,omitempty" yaml:"prometheus" env:"PROMETHEUS"` // The table of samples served with the remote-read protocol of Prometheus (optional)
+}
+
+// FlightSQL represents the configuration of the Arrow Flight SQL service
+type FlightSQL struct {
+	BatchSize int64 `json:"batchSize" yaml:"batchSize" env:"BATCHSIZE"` // The maximum size of the rows read for each record batch, in bytes (default: 4MB)
+}
+
+// Admin represents the configuration for the cluster administration API
+type Admin struct {
+	Port  int32  `json:"port" yaml:"port" env:"PORT"`    // The port for the HTTP listener, which must be the same on every node
+	Token string `json:"token" yaml:"token" env:"TOKEN"` // The bearer token required for every request
+}
+
+// Logging represents the configuration of the logs written to stdout and stderr
+type Logging struct {
+	Format     string `json:"format" yaml:"format" env:"FORMAT"`             // The format of the logs, either "text" (default) or "json"
+	Level      string `json:"level" yaml:"level" env:"LEVEL"`                // The minimum level of the JSON logs (default: debug)
+	Initial    int    `json:"initial" yaml:"initial" env:"INITIAL"`          // The number of identical JSON entries written each second before sampling
+	Thereafter int    `json:"thereafter" yaml:"thereafter" env:"THEREAFTER"` // The interval at which the identical JSON entries are written past the initial ones
+}
+
+// Errors represents the configuration of the sinks to which the errors are forwarded
+type Errors struct {
+	Sentry  string `json:"sentry" yaml:"sentry" env:"SENTRY"`    // The DSN of the Sentry project (optional)
+	Webhook string `json:"webhook" yaml:"webhook" env:"WEBHOOK"` // The URL to which the errors are posted as JSON (optional)
+}
+
+// Events represents the configuration of the system.events table, recording the operational events of the node
+type Events struct {
+	TTL int64 `json:"ttl" yaml:"ttl" env:"TTL"` // The time-to-live (in seconds) of the events (default: 1 day)
+}
+
+// Audit represents the configuration of the audit trail, recording the administrative operations and the denied queries
+type Audit struct {
+	File     string  `json:"file" yaml:"file" env:"FILE"`             // The file the entries are appended to, as JSON lines (optional)
+	Interval int64   `json:"interval" yaml:"interval" env:"INTERVAL"` // The interval (in seconds) between two uploads of the entries (default: 60)
+	S3       *S3Sink `json:"s3,omitempty" yaml:"s3" env:"S3"`         // The bucket to which the entries are uploaded (optional)
+}
+
+// Profiling represents the configuration of the pprof handlers and of the periodic upload of the profiles
+type Profiling struct {
+	Port     int32   `json:"port" yaml:"port" env:"PORT"`             // The port of the pprof HTTP handlers
+	Interval int64   `json:"interval" yaml:"interval" env:"INTERVAL"` // The interval (in seconds) between two uploads of the profiles (default: 300)
+	CPU      int64   `json:"cpu" yaml:"cpu" env:"CPU"`                // The duration (in seconds) of each CPU profile (default: 30)
+	S3       *S3Sink `json:"s3,omitempty" yaml:"s3" env:"S3"`         // The bucket to which the heap and CPU profiles are uploaded (optional)
+}
+
+// Cache represents the configuration for the query result cache
+type Cache struct {
+	TTL  int64 `json:"ttl" yaml:"ttl" env:"TTL"`    // The time-to-live (in seconds) of a cached result
+	Size int64 `json:"size" yaml:"size" env:"SIZE"` // The maximum size (in bytes) of the cache
+}
+
+// SlowQuery represents the configuration for the slow query log
+type SlowQuery struct {
+	Threshold int64 `json:"threshold" yaml:"threshold" env:"THRESHOLD"` // The latency (in milliseconds) above which a request is logged
+	Capacity  int   `json:"capacity" yaml:"capacity" env:"CAPACITY"`    // The number of recent slow requests kept in memory (default: 1000)
+}
+
+// Admission represents the configuration for the query admission control
+type Admission struct {
+	Splits  int   `json:"splits" yaml:"splits" env:"SPLITS"`    // The maximum number of splits served concurrently
+	Bytes   int64 `json:"bytes" yaml:"bytes" env:"BYTES"`       // The maximum number of bytes of column blocks in flight
+	Timeout int64 `json:"timeout" yaml:"timeout" env:"TIMEOUT"` // The time (in milliseconds) a request is queued for before being rejected
+}
+
+// Memory represents the limits of the heap of the node, above which it sheds load instead of running out of memory
+type Memory struct {
+	Soft int64 `json:"soft,omitempty" yaml:"soft" env:"SOFT"` // The size (in bytes) above which the caches are shrunk and the ingestion from the queue is paused
+	Hard int64 `json:"hard,omitempty" yaml:"hard" env:"HARD"` // The size (in bytes) above which the queries are rejected with a retryable error
 }
 
 // StatsD represents the configuration for statsD client
@@ -114,17 +376,167 @@ type StatsD struct {
 
 // Computed represents a computed column
 type Computed struct {
-	Name string      `json:"name"`
-	Type typeof.Type `json:"type"`
-	Func string      `json:"func"`
+	Name    string        `json:"name"`
+	Type    typeof.Type   `json:"type"`
+	Func    string        `json:"func"`
+	Columns typeof.Schema `json:"columns,omitempty"` // The columns produced by the script in a single pass, if more than one
+}
+
+// Stage represents a single stage of the ingestion pipeline of a table, only one of the transforms should be set.
+type Stage struct {
+	Filter  string    `json:"filter,omitempty" yaml:"filter" env:"FILTER"`    // The script (or its URL) which decides whether the row is kept
+	Flatten string    `json:"flatten,omitempty" yaml:"flatten" env:"FLATTEN"` // The separator to use when flattening the JSON columns
+	Enrich  *Lookup   `json:"enrich,omitempty" yaml:"enrich" env:"ENRICH"`    // The reference dataset to join the row with
+	Mask    *Mask     `json:"mask,omitempty" yaml:"mask" env:"MASK"`          // The column to pseudonymize
+	Compute *Computed `json:"compute,omitempty" yaml:"compute" env:"COMPUTE"` // The computed column(s) to add
+	Sample  *Sample   `json:"sample,omitempty" yaml:"sample" env:"SAMPLE"`    // The fraction of the rows to keep
+	OnError string    `json:"onError,omitempty" yaml:"onError" env:"ONERROR"` // What to do with the row if the stage fails, "skip" the stage or "drop" the row (default for a filter or a mask)
+}
+
+// Lookup represents a reference dataset which is joined with the ingested rows on a key
+type Lookup struct {
+	Source   string        `json:"source" yaml:"source" env:"SOURCE"`                 // The URL of the CSV or JSON file containing the dataset
+	Key      string        `json:"key" yaml:"key" env:"KEY"`                          // The column to join on, present in both the rows and the dataset
+	Columns  typeof.Schema `json:"columns" yaml:"columns" env:"COLUMNS"`              // The columns of the dataset to add to the rows
+	Interval int64         `json:"interval,omitempty" yaml:"interval" env:"INTERVAL"` // The interval (in seconds) to check for updates of the dataset, defaults to 5 minutes
+}
+
+// Mask represents a built-in transform which pseudonymizes the value of a column before it is stored
+type Mask struct {
+	Column string `json:"column" yaml:"column" env:"COLUMN"`           // The column to mask
+	Func   string `json:"func" yaml:"func" env:"FUNC"`                 // The function to use, one of "sha256", "hmac", "truncate" or "mask"
+	Secret string `json:"secret,omitempty" yaml:"secret" env:"SECRET"` // The salt for "sha256" or the key for "hmac"
+	Length int    `json:"length,omitempty" yaml:"length" env:"LENGTH"` // The number of characters kept by "truncate" or left visible by "mask"
+}
+
+// EventTime represents the keying of the rows by their event time, the rows arriving later than the lateness
+// behind the latest event time seen by the table being handled by the late policy
+type EventTime struct {
+	Column   string `json:"column,omitempty" yaml:"column" env:"COLUMN"`       // The column of the event time, defaults to the time column of the table
+	Lateness int64  `json:"lateness,omitempty" yaml:"lateness" env:"LATENESS"` // The time (in seconds) the rows can arrive behind the watermark, 1 hour by default
+	Late     string `json:"late,omitempty" yaml:"late" env:"LATE"`             // The policy of the late rows, either "partition" (default), "drop" or "metric"
+}
+
+// Timestamps represents the formats of the timestamp columns of a table, by column
+type Timestamps map[string]Timestamp
+
+// Timestamp represents the formats a timestamp column is ingested in, tried in order until one matches
+type Timestamp struct {
+	Formats  []string `json:"formats,omitempty" yaml:"formats" env:"FORMATS"`    // The formats to try, either "rfc3339", "rfc3339nano", "rfc1123", "epoch", "unix", "unixmilli", "unixmicro", "unixnano" or a Go layout, defaults to "rfc3339nano" then "epoch"
+	Timezone string   `json:"timezone,omitempty" yaml:"timezone" env:"TIMEZONE"` // The time zone of the values without one, defaults to UTC
+	Output   string   `json:"output,omitempty" yaml:"output" env:"OUTPUT"`       // The representation stored, either "unix" (default), "unixmilli", "unixmicro", "unixnano" or "timestamp"
+}
+
+// Tier represents a downsampled copy of a table, each row of which aggregates the rows of a key within a time
+// bucket. The table of the tier is configured as any other table, with the retention of the aggregates.
+type Tier struct {
+	Table    string            `json:"table" yaml:"table" env:"TABLE"`          // The table the aggregates are appended to
+	Interval int64             `json:"interval" yaml:"interval" env:"INTERVAL"` // The width (in seconds) of the time buckets
+	Columns  map[string]string `json:"columns" yaml:"columns" env:"COLUMNS"`    // The aggregation of each column, either "sum", "min", "max", "first" or "last"
+}
+
+// Strict represents the enforcement of the schema of a table at ingestion. The rows which miss a required column
+// or have a value which can not be converted to the type of its column are rejected rather than stored with nulls.
+type Strict struct {
+	Required []string `json:"required,omitempty" yaml:"required" env:"REQUIRED"` // The columns every row must have
+	Errors   Streams  `json:"errors,omitempty" yaml:"errors" env:"ERRORS"`       // The streams the rejected rows are sent to, annotated with the reason (optional)
+}
+
+// Batch represents the grouping of the concurrent appends to the local store into a single transaction
+type Batch struct {
+	Size  int64 `json:"size,omitempty" yaml:"size" env:"SIZE"`    // The maximum size (in bytes) of the appends committed together, defaults to 4MB
+	Delay int64 `json:"delay,omitempty" yaml:"delay" env:"DELAY"` // The maximum time (in milliseconds) an append waits for the others to join its transaction, defaults to 5ms
+}
+
+// Sample represents a sampling of the ingested rows, either at random or consistently for a key
+type Sample struct {
+	Rate float64 `json:"rate" yaml:"rate" env:"RATE"`        // The fraction of the rows to keep, between 0 and 1
+	Key  string  `json:"key,omitempty" yaml:"key" env:"KEY"` // The column whose value decides whether the row is kept, so that all of the rows with the same value are either kept or dropped
 }
 
 // Compaction represents a configuration for compaction sinks
 type Compaction struct {
-	Sinks    `yaml:",inline"`
-	Encoder  string `json:"encoder" yaml:"encoder"`                  // The default encoder for the compaction
-	NameFunc string `json:"nameFunc" yaml:"nameFunc" env:"NAMEFUNC"` // The lua script to compute file name given a row
-	Interval int    `json:"interval" yaml:"interval" env:"INTERVAL"` // The compaction interval, in seconds
+	Sinks         `yaml:",inline"`
+	Encoder       string               `json:"encoder" yaml:"encoder"`                                           // The default encoder for the compaction
+	NameFunc      string               `json:"nameFunc" yaml:"nameFunc" env:"NAMEFUNC"`                          // The lua script to compute file name given a row
+	Interval      int                  `json:"interval" yaml:"interval" env:"INTERVAL"`                          // The compaction interval, in seconds
+	Coordinate    bool                 `json:"coordinate" yaml:"coordinate" env:"COORDINATE"`                    // Whether a single node, elected among the cluster, writes the compacted files
+	MaxSize       int64                `json:"maxSize,omitempty" yaml:"maxSize" env:"MAXSIZE"`                   // The maximum size (in bytes) of the blocks merged into a single file, unlimited by default
+	Compression   string               `json:"compression,omitempty" yaml:"compression" env:"COMPRESSION"`       // The compression codec of the files, either "zlib" (default), "snappy" or "none"
+	Retry         *Retry               `json:"retry,omitempty" yaml:"retry" env:"RETRY"`                         // The retry policy of the failed writes, which are otherwise retried at the next interval
+	Manifest      *Manifest            `json:"manifest,omitempty" yaml:"manifest" env:"MANIFEST"`                // The manifest written after each compaction window (optional)
+	Catalog       *Catalog             `json:"catalog,omitempty" yaml:"catalog" env:"CATALOG"`                   // The catalog in which the partitions of the files are registered (optional)
+	Throttle      map[string]*Throttle `json:"throttle,omitempty" yaml:"throttle"`                               // The bandwidth limits of the uploads, by name of the sink such as "s3" (optional)
+	Deterministic bool                 `json:"deterministic,omitempty" yaml:"deterministic" env:"DETERMINISTIC"` // Whether the files are named after the buffered data, so a compaction retried after a crash overwrites them
+	Concurrency   int                  `json:"concurrency,omitempty" yaml:"concurrency" env:"CONCURRENCY"`       // The number of disjoint key ranges compacted concurrently, the number of CPUs by default
+	Sketches      *Sketches            `json:"sketches,omitempty" yaml:"sketches" env:"SKETCHES"`                // The distinct-count sketches written after each compaction window (optional)
+	Breaker       *Breaker             `json:"breaker,omitempty" yaml:"breaker" env:"BREAKER"`                   // The circuit breaker in front of each sink (optional)
+}
+
+// Throttle represents the bandwidth limit of the uploads to a sink, which can differ within windows of the week
+type Throttle struct {
+	Bandwidth int64            `json:"bandwidth,omitempty" yaml:"bandwidth"` // The maximum number of bytes per second, unlimited if zero
+	Timezone  string           `json:"timezone,omitempty" yaml:"timezone"`   // The timezone of the windows, such as "Asia/Singapore", UTC by default
+	Windows   []ThrottleWindow `json:"windows,omitempty" yaml:"windows"`     // The windows with their own bandwidth, such as the business hours
+}
+
+// ThrottleWindow represents a window of the week with its own bandwidth
+type ThrottleWindow struct {
+	From      string   `json:"from" yaml:"from"`                     // The start of the window, such as "09:00"
+	Until     string   `json:"until" yaml:"until"`                   // The end of the window, such as "18:00", on the next day if not after the start
+	Days      []string `json:"days,omitempty" yaml:"days"`           // The days the window starts on, such as "mon", every day by default
+	Bandwidth int64    `json:"bandwidth,omitempty" yaml:"bandwidth"` // The maximum number of bytes per second, unlimited if zero
+}
+
+// Catalog represents the catalog, either AWS Glue or a Hive Metastore, in which the partitions of the files written
+// by the compaction are registered
+type Catalog struct {
+	Database string       `json:"database" yaml:"database" env:"DATABASE"` // The database of the table
+	Table    string       `json:"table" yaml:"table" env:"TABLE"`          // The table whose partitions are registered
+	Glue     *GlueCatalog `json:"glue,omitempty" yaml:"glue" env:"GLUE"`   // The AWS Glue Data Catalog
+	Hive     *HiveCatalog `json:"hive,omitempty" yaml:"hive" env:"HIVE"`   // The Hive Metastore
+}
+
+// GlueCatalog represents the AWS Glue Data Catalog
+type GlueCatalog struct {
+	Region    string `json:"region" yaml:"region" env:"REGION"`                    // The region of the catalog
+	CatalogID string `json:"catalogId,omitempty" yaml:"catalogId" env:"CATALOGID"` // The ID of the catalog, by default the one of the account
+}
+
+// HiveCatalog represents a Hive Metastore
+type HiveCatalog struct {
+	Address string `json:"address" yaml:"address" env:"ADDRESS"` // The address of the thrift API of the metastore, such as "metastore:9083"
+}
+
+// Manifest represents the manifest of the files written by a compaction window, for the downstream batch jobs
+type Manifest struct {
+	Column  string `json:"column,omitempty" yaml:"column" env:"COLUMN"`    // The column of the event time, whose range is reported for each file (optional)
+	Success bool   `json:"success,omitempty" yaml:"success" env:"SUCCESS"` // Whether to write a _SUCCESS marker after the manifest
+}
+
+// Sketches represents the distinct-count sketches of columns, computed per window of event time as the rows are
+// compacted and written next to the files
+type Sketches struct {
+	Columns   []string `json:"columns" yaml:"columns" env:"COLUMNS"`                 // The columns whose distinct values are counted
+	Column    string   `json:"column" yaml:"column" env:"COLUMN"`                    // The column of the event time, a timestamp or a unix time in seconds
+	Window    int64    `json:"window,omitempty" yaml:"window" env:"WINDOW"`          // The width (in seconds) of the windows, 1 hour by default
+	Precision int      `json:"precision,omitempty" yaml:"precision" env:"PRECISION"` // The precision of the sketches, between 4 and 16, 14 by default
+}
+
+// Breaker represents the circuit breaker of the sinks, which stops writing to a sink once it failed repeatedly
+type Breaker struct {
+	Failures int `json:"failures,omitempty" yaml:"failures" env:"FAILURES"` // The number of consecutive failures opening the circuit, 5 by default
+	Cooldown int `json:"cooldown,omitempty" yaml:"cooldown" env:"COOLDOWN"` // The time (in seconds) the circuit stays open before the sink is probed, 30 by default
+}
+
+// Retry represents the retry policy of the writes to the sinks
+type Retry struct {
+	MaxAttempts    int     `json:"maxAttempts,omitempty" yaml:"maxAttempts" env:"MAXATTEMPTS"`          // The maximum number of attempts of a write, 5 by default
+	Backoff        int     `json:"backoff,omitempty" yaml:"backoff" env:"BACKOFF"`                      // The delay before the first retry, in seconds, doubled on every attempt (1 by default)
+	MaxBackoff     int     `json:"maxBackoff,omitempty" yaml:"maxBackoff" env:"MAXBACKOFF"`             // The maximum delay between two attempts, in seconds, 60 by default
+	Jitter         float64 `json:"jitter,omitempty" yaml:"jitter" env:"JITTER"`                         // The fraction of the delay which is randomized, 0.2 by default
+	DeadLetter     string  `json:"deadLetter,omitempty" yaml:"deadLetter" env:"DEADLETTER"`             // The directory into which the files are spilled once their attempts are exhausted (optional)
+	ReplayInterval int     `json:"replayInterval,omitempty" yaml:"replayInterval" env:"REPLAYINTERVAL"` // The interval at which the spilled files are replayed, in seconds, 60 by default
 }
 
 // Streams are lists of sinks to be streamed to
@@ -132,25 +544,38 @@ type Streams []Sinks
 
 // Sinks represents a configuration for writer sinks
 type Sinks struct {
-	S3       *S3Sink       `json:"s3" yaml:"s3"`              // The S3 writer configuration
-	Azure    *AzureSink    `json:"azure" yaml:"azure"`        // The Azure writer configuration
-	BigQuery *BigQuerySink `json:"bigquery" yaml:"bigquery" ` // The Big Query writer configuration
-	GCS      *GCSSink      `json:"gcs" yaml:"gcs" `           // The Google Cloud Storage writer configuration
-	File     *FileSink     `json:"file" yaml:"file" `         // The local file system writer configuration
-	Talaria  *TalariaSink  `json:"talaria" yaml:"talaria" `   // The Talaria writer configuration
-	PubSub   *PubSubSink   `json:"pubsub" yaml:"pubsub" `     // The Google Pub/Sub writer configuration
+	S3         *S3Sink         `json:"s3" yaml:"s3"`                  // The S3 writer configuration
+	Azure      *AzureSink      `json:"azure" yaml:"azure"`            // The Azure writer configuration
+	BigQuery   *BigQuerySink   `json:"bigquery" yaml:"bigquery" `     // The Big Query writer configuration
+	GCS        *GCSSink        `json:"gcs" yaml:"gcs" `               // The Google Cloud Storage writer configuration
+	File       *FileSink       `json:"file" yaml:"file" `             // The local file system writer configuration
+	Talaria    *TalariaSink    `json:"talaria" yaml:"talaria" `       // The Talaria writer configuration
+	PubSub     *PubSubSink     `json:"pubsub" yaml:"pubsub" `         // The Google Pub/Sub writer configuration
+	Snowflake  *SnowflakeSink  `json:"snowflake" yaml:"snowflake" `   // The Snowflake writer configuration
+	ClickHouse *ClickHouseSink `json:"clickhouse" yaml:"clickhouse" ` // The ClickHouse writer configuration
+	Elastic    *ElasticSink    `json:"elastic" yaml:"elastic" `       // The Elasticsearch or OpenSearch writer configuration
+	Kafka      *KafkaSink      `json:"kafka" yaml:"kafka" `           // The Kafka writer configuration
+	ADLS       *ADLSSink       `json:"adls" yaml:"adls" `             // The Azure Data Lake Storage Gen2 writer configuration
+	Postgres   *PostgresSink   `json:"postgres" yaml:"postgres" `     // The PostgreSQL or Redshift writer configuration
+	HDFS       *HDFSSink       `json:"hdfs" yaml:"hdfs" `             // The HDFS writer configuration
 }
 
 // S3Sink represents a sink for AWS S3 and compatible stores.
 type S3Sink struct {
-	Region      string `json:"region" yaml:"region" env:"REGION"`                // The region of AWS bucket
-	Bucket      string `json:"bucket" yaml:"bucket" env:"BUCKET"`                // The name of AWS bucket
-	Prefix      string `json:"prefix" yaml:"prefix" env:"PREFIX"`                // The prefix to add
-	Endpoint    string `json:"endpoint" yaml:"endpoint" env:"ENDPOINT"`          // The custom endpoint to use
-	SSE         string `json:"sse" yaml:"sse" env:"SSE"`                         // The server side encryption to use
-	AccessKey   string `json:"accessKey" yaml:"accessKey" env:"ACCESSKEY"`       // The optional static access key
-	SecretKey   string `json:"secretKey" yaml:"secretKey" env:"SECRETKEY"`       // The optional static secret key
-	Concurrency int    `json:"concurrency" yaml:"concurrency" env:"CONCURRENCY"` // The S3 upload concurrency
+	Region      string      `json:"region" yaml:"region" env:"REGION"`                       // The region of AWS bucket
+	Bucket      string      `json:"bucket" yaml:"bucket" env:"BUCKET"`                       // The name of AWS bucket
+	Prefix      string      `json:"prefix" yaml:"prefix" env:"PREFIX"`                       // The prefix to add
+	Endpoint    string      `json:"endpoint" yaml:"endpoint" env:"ENDPOINT"`                 // The custom endpoint to use
+	PathStyle   *bool       `json:"pathStyle" yaml:"pathStyle" env:"PATHSTYLE"`              // Whether to address the bucket in the path, by default with a custom endpoint (optional)
+	DisableSSL  bool        `json:"disableSSL" yaml:"disableSSL" env:"DISABLESSL"`           // Whether to use plain HTTP, by default with an "http://" endpoint (optional)
+	CACert      string      `json:"caCert" yaml:"caCert" env:"CACERT"`                       // The PEM certificate of a custom certificate authority, or the path to it (optional)
+	SSE         string      `json:"sse" yaml:"sse" env:"SSE"`                                // The server side encryption to use
+	AccessKey   string      `json:"accessKey" yaml:"accessKey" env:"ACCESSKEY"`              // The optional static access key
+	SecretKey   string      `json:"secretKey" yaml:"secretKey" env:"SECRETKEY"`              // The optional static secret key
+	Concurrency int         `json:"concurrency" yaml:"concurrency" env:"CONCURRENCY"`        // The S3 upload concurrency
+	KMSKey      string      `json:"kmsKey" yaml:"kmsKey" env:"KMSKEY"`                       // The ARN of the KMS key, for SSE-KMS (optional)
+	ACL         string      `json:"acl" yaml:"acl" env:"ACL"`                                // The canned ACL of the objects, such as "bucket-owner-full-control" (optional)
+	Encryption  *Encryption `json:"encryption,omitempty" yaml:"encryption" env:"ENCRYPTION"` // The encryption of the files before they are uploaded (optional)
 }
 
 // AzureSink reprents a sink to Azure
@@ -159,6 +584,28 @@ type AzureSink struct {
 	Prefix    string `json:"prefix" yaml:"prefix" env:"PREFIX"`          // The prefix to add
 }
 
+// ADLSSink represents a sink to Azure Data Lake Storage Gen2
+type ADLSSink struct {
+	Account    string `json:"account" yaml:"account" env:"ACCOUNT"`          // The storage account, with the hierarchical namespace enabled
+	Filesystem string `json:"filesystem" yaml:"filesystem" env:"FILESYSTEM"` // The file system (container)
+	Prefix     string `json:"prefix" yaml:"prefix" env:"PREFIX"`             // The directory of the files (optional)
+}
+
+// HDFSSink represents a sink to HDFS, through WebHDFS or an HttpFS gateway
+type HDFSSink struct {
+	Endpoints []string  `json:"endpoints" yaml:"endpoints" env:"ENDPOINTS"` // The endpoints of the name nodes or gateways, such as "http://namenode-1:9870"
+	Directory string    `json:"dir" yaml:"dir" env:"DIR"`                   // The directory of the files
+	User      string    `json:"user" yaml:"user" env:"USER"`                // The user, with the simple authentication (optional)
+	Kerberos  *Kerberos `json:"kerberos" yaml:"kerberos" env:"KERBEROS"`    // The SPNEGO authentication with Kerberos (optional)
+}
+
+// Kerberos represents the SPNEGO authentication with the tickets of a credential cache
+type Kerberos struct {
+	CCache  string `json:"ccache" yaml:"ccache" env:"CCACHE"`    // The path to the credential cache, by default the one of KRB5CCNAME (optional)
+	Config  string `json:"config" yaml:"config" env:"CONFIG"`    // The path to the configuration, by default the one of KRB5_CONFIG or /etc/krb5.conf (optional)
+	Service string `json:"service" yaml:"service" env:"SERVICE"` // The service name of the principal of the endpoints, "HTTP" by default (optional)
+}
+
 // BigQuerySink reprents a sink to Google Big Query
 type BigQuerySink struct {
 	Project string `json:"project" yaml:"project" env:"PROJECT"` // The project ID
@@ -168,8 +615,16 @@ type BigQuerySink struct {
 
 // GCSSink represents a sink to Google Cloud Storage
 type GCSSink struct {
-	Bucket string `json:"bucket" yaml:"bucket" env:"BUCKET"` // The name of the bucket
-	Prefix string `json:"prefix" yaml:"prefix" env:"PREFIX"` // The prefix to add
+	Bucket     string      `json:"bucket" yaml:"bucket" env:"BUCKET"`                       // The name of the bucket
+	Prefix     string      `json:"prefix" yaml:"prefix" env:"PREFIX"`                       // The prefix to add
+	KMSKey     string      `json:"kmsKey" yaml:"kmsKey" env:"KMSKEY"`                       // The name of the Cloud KMS key encrypting the objects (optional)
+	ACL        string      `json:"acl" yaml:"acl" env:"ACL"`                                // The predefined ACL of the objects, such as "bucketOwnerFullControl" (optional)
+	Encryption *Encryption `json:"encryption,omitempty" yaml:"encryption" env:"ENCRYPTION"` // The encryption of the files before they are uploaded (optional)
+}
+
+// Encryption represents the client-side envelope encryption of the files written to a sink
+type Encryption struct {
+	Key string `json:"key" yaml:"key" env:"KEY"` // The master key of 32 bytes encoded in base64, encrypting the key of each file
 }
 
 // FileSink represents a sink to the local file system
@@ -179,10 +634,82 @@ type FileSink struct {
 
 // PubSubSink represents a stream to Google Pub/Sub
 type PubSubSink struct {
-	Project string `json:"project" yaml:"project" env:"PROJECT"`
-	Topic   string `json:"topic" yaml:"topic" env:"TOPIC"`
-	Filter  string `json:"filter" yaml:"filter" env:"FILTER"`
-	Encoder string `json:"encoder" yaml:"encoder" env:"ENCODER"`
+	Project     string       `json:"project" yaml:"project" env:"PROJECT"`
+	Topic       string       `json:"topic" yaml:"topic" env:"TOPIC"`
+	Filter      string       `json:"filter" yaml:"filter" env:"FILTER"`
+	Encoder     string       `json:"encoder" yaml:"encoder" env:"ENCODER"`
+	OrderingKey string       `json:"orderingKey" yaml:"orderingKey" env:"ORDERINGKEY"` // The column of the ordering key of the messages (optional)
+	Attributes  []string     `json:"attributes" yaml:"attributes" env:"ATTRIBUTES"`    // The columns copied into the attributes of the messages (optional)
+	FlowControl *FlowControl `json:"flowControl" yaml:"flowControl" env:"FLOWCONTROL"` // The limits of the messages not yet published (optional)
+}
+
+// FlowControl represents the limits of the messages streamed but not yet published
+type FlowControl struct {
+	MaxOutstandingMessages int    `json:"maxOutstandingMessages" yaml:"maxOutstandingMessages" env:"MAXOUTSTANDINGMESSAGES"` // The maximum number of messages
+	MaxOutstandingBytes    int    `json:"maxOutstandingBytes" yaml:"maxOutstandingBytes" env:"MAXOUTSTANDINGBYTES"`          // The maximum size of the messages, in bytes
+	LimitExceeded          string `json:"limitExceeded" yaml:"limitExceeded" env:"LIMITEXCEEDED"`                            // Either "error" (default) or "block" once a limit is reached
+}
+
+// SnowflakeSink represents a sink to Snowflake, loading the files uploaded to the location of an external stage
+type SnowflakeSink struct {
+	Account    string `json:"account" yaml:"account" env:"ACCOUNT"`          // The account identifier, such as "xy12345.ap-southeast-1"
+	User       string `json:"user" yaml:"user" env:"USER"`                   // The user, authenticated with its key pair
+	PrivateKey string `json:"privateKey" yaml:"privateKey" env:"PRIVATEKEY"` // The path to the unencrypted private key of the user, in PEM format
+	Role       string `json:"role" yaml:"role" env:"ROLE"`                   // The role of the user loading the files (optional)
+	Warehouse  string `json:"warehouse" yaml:"warehouse" env:"WAREHOUSE"`    // The warehouse running the COPY INTO (optional)
+	Table      string `json:"table" yaml:"table" env:"TABLE"`                // The fully qualified name of the table, such as "db.schema.table"
+	Stage      string `json:"stage" yaml:"stage" env:"STAGE"`                // The fully qualified name of the external stage, such as "db.schema.stage"
+	Pipe       string `json:"pipe" yaml:"pipe" env:"PIPE"`                   // The fully qualified name of the Snowpipe loading the files instead of a COPY INTO (optional)
+	Upload     Sinks  `json:"upload" yaml:"upload"`                          // The sink uploading the files to the location of the stage
+}
+
+// ClickHouseSink represents a sink to ClickHouse, through its HTTP interface
+type ClickHouseSink struct {
+	Endpoints []string `json:"endpoints" yaml:"endpoints" env:"ENDPOINTS"` // The HTTP endpoints of the replicas, such as "http://clickhouse-1:8123"
+	Table     string   `json:"table" yaml:"table" env:"TABLE"`             // The name of the table, optionally qualified by its database
+	User      string   `json:"user" yaml:"user" env:"USER"`                // The user (optional)
+	Password  string   `json:"password" yaml:"password" env:"PASSWORD"`    // The password of the user (optional)
+	Engine    string   `json:"engine" yaml:"engine" env:"ENGINE"`          // The engine of the table, created if it does not exist (optional)
+}
+
+// PostgresSink represents a sink copying the rows into a PostgreSQL table, or into a Redshift table from staged files
+type PostgresSink struct {
+	Address  string           `json:"address" yaml:"address" env:"ADDRESS"`    // The address of the database, such as "postgres:5432"
+	Database string           `json:"database" yaml:"database" env:"DATABASE"` // The name of the database
+	User     string           `json:"user" yaml:"user" env:"USER"`             // The user
+	Password string           `json:"password" yaml:"password" env:"PASSWORD"` // The password of the user (optional)
+	SSLMode  string           `json:"sslMode" yaml:"sslMode" env:"SSLMODE"`    // Either "disable" (default), "require" or "verify-full" (optional)
+	Table    string           `json:"table" yaml:"table" env:"TABLE"`          // The name of the table, optionally qualified by its schema
+	Redshift *RedshiftStaging `json:"redshift" yaml:"redshift"`                // The staging of the files, to copy them into Redshift (optional)
+}
+
+// RedshiftStaging represents the staging of the files copied into Redshift
+type RedshiftStaging struct {
+	Location string `json:"location" yaml:"location" env:"LOCATION"` // The location of the staged files, such as "s3://bucket/staging/"
+	IAMRole  string `json:"iamRole" yaml:"iamRole" env:"IAMROLE"`    // The ARN of the IAM role of the cluster reading the staged files
+	Region   string `json:"region" yaml:"region" env:"REGION"`       // The region of the bucket, if not the one of the cluster (optional)
+	Upload   Sinks  `json:"upload" yaml:"upload"`                    // The sink uploading the files to the location
+}
+
+// ElasticSink represents a sink to Elasticsearch or OpenSearch
+type ElasticSink struct {
+	Endpoints  []string `json:"endpoints" yaml:"endpoints" env:"ENDPOINTS"`    // The endpoints of the nodes, such as "https://elastic-1:9200"
+	Index      string   `json:"index" yaml:"index" env:"INDEX"`                // The name of the index, with an optional time layout such as "eventlog-{2006.01.02}"
+	TimeColumn string   `json:"timeColumn" yaml:"timeColumn" env:"TIMECOLUMN"` // The column with the time of the row, naming its index (optional, current time by default)
+	User       string   `json:"user" yaml:"user" env:"USER"`                   // The user (optional)
+	Password   string   `json:"password" yaml:"password" env:"PASSWORD"`       // The password of the user (optional)
+	Template   string   `json:"template" yaml:"template" env:"TEMPLATE"`       // The index template, in JSON (optional)
+}
+
+// KafkaSink represents a sink producing the rows to a Kafka topic
+type KafkaSink struct {
+	Brokers     []string `json:"brokers" yaml:"brokers" env:"BROKERS"`             // The addresses of the brokers to bootstrap from, such as "kafka-1:9092"
+	Topic       string   `json:"topic" yaml:"topic" env:"TOPIC"`                   // The topic to produce to
+	Key         string   `json:"key" yaml:"key" env:"KEY"`                         // The column keying the records (optional)
+	Compression string   `json:"compression" yaml:"compression" env:"COMPRESSION"` // The compression codec, either "none", "gzip", "snappy", "lz4" or "zstd" (optional)
+	Idempotent  bool     `json:"idempotent" yaml:"idempotent" env:"IDEMPOTENT"`    // Whether the producer is idempotent (optional)
+	Filter      string   `json:"filter" yaml:"filter" env:"FILTER"`                // The Lua filter of the rows (optional)
+	Encoder     string   `json:"encoder" yaml:"encoder" env:"ENCODER"`             // The encoder of the records, "json" by default (optional)
 }
 
 // TalariaSink represents a sink to an instance of Talaria
@@ -196,6 +723,13 @@ type TalariaSink struct {
 // Func represents a config function
 type Func func() *Config
 
+// Check loads the config once through the providers and validates it, returning the error instead of
+// panicking, so that a config can be checked before it is deployed.
+func Check(configurers ...Configurer) (*Config, error) {
+	cs := &store{configurers: configurers}
+	return cs.value()
+}
+
 // Load iterates through all the providers and fills the config object.
 // Order of providers is important as the the last provider can override the previous one
 // It sets watch on the config for hot reload of the config
@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -25,3 +26,142 @@ func TestConfig(t *testing.T) {
 
 	assert.Equal(t, cfg().Storage.Directory, "dir-2")
 }
+
+func TestConfig_Reject(t *testing.T) {
+	const refreshTime = 50 * time.Millisecond
+	const waitTime = 100 * time.Millisecond
+
+	os.Setenv("TALARIA_LOGGING_FORMAT", "json")
+	defer os.Unsetenv("TALARIA_LOGGING_FORMAT")
+
+	cfg := config.Load(context.Background(), refreshTime, static.New(), env.New("TALARIA"))
+	assert.Equal(t, "json", cfg().Logging.Format)
+	version := cfg().Version
+	assert.Len(t, version, 8)
+
+	// An invalid config is rejected, the previous one is kept
+	os.Setenv("TALARIA_LOGGING_FORMAT", "xml")
+	time.Sleep(waitTime)
+	assert.Equal(t, "json", cfg().Logging.Format)
+	assert.Equal(t, version, cfg().Version)
+}
+
+func TestValidate(t *testing.T) {
+	downsampled := &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}}
+	assert.NoError(t, (&config.Config{}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {TTL: -1}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Sample: &config.Sample{Rate: 2}}}}).Validate())
+	assert.Error(t, (&config.Config{Computed: []config.Computed{{Name: "x"}}}).Validate())
+	assert.Error(t, (&config.Config{Cluster: config.Cluster{Replication: "quorum"}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {HashBy: "x", SortBy: "x"}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {EventTime: &config.EventTime{Late: "later"}}}}).Validate())
+	assert.NoError(t, (&config.Config{Tables: config.Tables{"a": {EventTime: &config.EventTime{Late: "drop"}}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Timestamps: config.Timestamps{"x": {Output: "weeks"}}}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Downsample: []config.Tier{{Table: "b", Interval: 60}}}, "b": {}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Compact: downsampled, Downsample: []config.Tier{{Table: "c", Interval: 60}}}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Compact: downsampled, Downsample: []config.Tier{{Table: "b"}}}, "b": {}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Compact: downsampled, Downsample: []config.Tier{{Table: "b", Interval: 60, Columns: map[string]string{"x": "avg"}}}}, "b": {}}}).Validate())
+	assert.NoError(t, (&config.Config{Tables: config.Tables{"a": {Compact: downsampled, Downsample: []config.Tier{{Table: "b", Interval: 60, Columns: map[string]string{"x": "sum"}}}}, "b": {}}}).Validate())
+	assert.Error(t, (&config.Config{Tenants: config.Tenants{"a.b": {}}}).Validate())
+	assert.Error(t, (&config.Config{Tenants: config.Tenants{"a": {Storage: -1}}}).Validate())
+	assert.Error(t, (&config.Config{Storage: config.Storage{Idle: -1}}).Validate())
+	assert.Error(t, (&config.Config{Tenants: config.Tenants{"a": {Quota: &config.Quota{}}}}).Validate())
+	assert.Error(t, (&config.Config{Readers: config.Readers{ACL: []config.Access{{Identity: "x", Tenant: "a"}}}}).Validate())
+	assert.Error(t, (&config.Config{Writers: config.Writers{Auth: &config.Auth{Keys: []config.APIKey{{Name: "x", Key: "k", Tables: []string{"*"}, Tenant: "a"}}}}}).Validate())
+	assert.NoError(t, (&config.Config{Tenants: config.Tenants{"a": {Storage: 1}}, Readers: config.Readers{ACL: []config.Access{{Identity: "x", Tenant: "a"}}}}).Validate())
+	assert.NoError(t, (&config.Config{Tables: config.Tables{"a": {Timestamps: config.Timestamps{"x": {Formats: []string{"rfc3339"}, Timezone: "Asia/Singapore"}}}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Schema: "x: nope"}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Compact: &config.Compaction{Interval: 60}}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {Masks: []config.Mask{{Column: "x", Func: "md5"}}}}}).Validate())
+	assert.Error(t, (&config.Config{Writers: config.Writers{Auth: &config.Auth{Keys: []config.APIKey{{Name: "a", Key: "k"}}}}}).Validate())
+	assert.Error(t, (&config.Config{Writers: config.Writers{Auth: &config.Auth{JWT: &config.JWT{}}}}).Validate())
+	assert.Error(t, (&config.Config{Cluster: config.Cluster{Ownership: true}, Writers: config.Writers{Auth: &config.Auth{}}}).Validate())
+	assert.Error(t, (&config.Config{Readers: config.Readers{ACL: []config.Access{{Tables: []string{"*"}}}}}).Validate())
+	assert.Error(t, (&config.Config{Audit: &config.Audit{}}).Validate())
+	assert.Error(t, (&config.Config{Writers: config.Writers{Quotas: map[string]config.Quota{"*": {Burst: 10}}}}).Validate())
+	assert.Error(t, (&config.Config{Readers: config.Readers{ACL: []config.Access{{Identity: "a"}, {Identity: "a"}}}}).Validate())
+	assert.Error(t, (&config.Config{Cluster: config.Cluster{TLS: &config.TLS{Cert: "a", Key: "b"}}}).Validate())
+	assert.Error(t, (&config.Config{Cluster: config.Cluster{Keys: []string{"c2hvcnQ="}}}).Validate())
+	assert.Error(t, (&config.Config{Readers: config.Readers{FlightSQL: &config.FlightSQL{BatchSize: -1}}}).Validate())
+	assert.Error(t, (&config.Config{Scripting: config.Scripting{Engine: "python"}}).Validate())
+	assert.NoError(t, (&config.Config{Cluster: config.Cluster{Keys: []string{"Q2x1c3RlcktleTEyMzQ1Ng=="}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Filter:   "gcs://bucket/filter.lua",
+		Pipeline: []config.Stage{{Flatten: "."}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Pipeline: []config.Stage{{Flatten: ".", Filter: "gcs://bucket/filter.lua"}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{GCS: &config.GCSSink{Bucket: "bucket", Encryption: &config.Encryption{Key: "c2hvcnQ="}}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{Snowflake: &config.SnowflakeSink{
+			Account: "xy12345", User: "talaria", PrivateKey: "key.p8", Table: "db.public.events", Stage: "db.public.stage",
+		}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{ClickHouse: &config.ClickHouseSink{Table: "events"}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{Elastic: &config.ElasticSink{Endpoints: []string{"http://elastic:9200"}}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Streams: []config.Sinks{{PubSub: &config.PubSubSink{Project: "p", Topic: "t", FlowControl: &config.FlowControl{LimitExceeded: "drop"}}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{Kafka: &config.KafkaSink{Brokers: []string{"kafka:9092"}}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{ADLS: &config.ADLSSink{Account: "account"}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{HDFS: &config.HDFSSink{Endpoints: []string{"http://namenode:9870"}}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{Postgres: &config.PostgresSink{
+			Address: "redshift:5439", Database: "analytics", User: "talaria", Table: "events", Redshift: &config.RedshiftStaging{Location: "s3://bucket/staging/"},
+		}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}, Retry: &config.Retry{Jitter: 2}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{Kafka: &config.KafkaSink{Brokers: []string{"kafka:9092"}, Topic: "t"}}, Manifest: &config.Manifest{}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}, Sketches: &config.Sketches{Columns: []string{"user"}}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}, Sketches: &config.Sketches{Columns: []string{"user"}, Column: "tsi", Precision: 20}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}, Catalog: &config.Catalog{Database: "db", Table: "t"}},
+	}}}).Validate())
+	assert.Error(t, (&config.Config{Tables: config.Tables{"a": {
+		Compact: &config.Compaction{Sinks: config.Sinks{File: &config.FileSink{Directory: "/tmp"}}, Throttle: map[string]*config.Throttle{
+			"file": {Windows: []config.ThrottleWindow{{From: "9am", Until: "18:00"}}},
+		}},
+	}}}).Validate())
+	assert.NoError(t, (&config.Config{Tables: config.Tables{"a": {
+		HashBy: "event",
+		SortBy: "tsi",
+		Schema: "event: string\ntsi: int64",
+		Compact: &config.Compaction{Sinks: config.Sinks{S3: &config.S3Sink{Bucket: "bucket", KMSKey: "arn:aws:kms:key", Encryption: &config.Encryption{
+			Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+		}}}},
+		Pipeline: []config.Stage{{Flatten: "."}, {Mask: &config.Mask{Column: "email", Func: "sha256"}}},
+	}}}).Validate())
+}
+
+func TestValidate_Sample(t *testing.T) {
+	b, err := ioutil.ReadFile("sample_config.yaml")
+	assert.NoError(t, err)
+
+	c := new(config.Config)
+	assert.NoError(t, config.Unmarshal(b, c))
+	assert.NoError(t, c.Validate())
+
+	// Unknown keys are rejected
+	assert.Error(t, config.Unmarshal([]byte("tablez:\n  events: {}\n"), new(config.Config)))
+}
@@ -0,0 +1,55 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package consul
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Client reads the keys of the key/value store of Consul, authenticated by the CONSUL_HTTP_TOKEN environment
+// variable.
+type Client struct {
+	client *http.Client
+	token  string // The ACL token, if any
+}
+
+// New creates a new Consul client.
+func New() *Client {
+	return &Client{
+		client: &http.Client{Timeout: 30 * time.Second},
+		token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+	}
+}
+
+// Load reads the raw value of a key, such as "talaria/config", from the agent at the address.
+func (c *Client) Load(ctx context.Context, address, key string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+"/v1/kv/"+key+"?raw", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("consul: key %s not found", key)
+	default:
+		return nil, fmt.Errorf("consul: unable to read %s, status %d", key, resp.StatusCode)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package consul
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Consul-Token") != "secret":
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/v1/kv/talaria/config" && r.URL.RawQuery == "raw":
+			_, _ = w.Write([]byte("appName: consul"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	client := New()
+	client.token = "secret"
+
+	b, err := client.Load(context.Background(), host, "talaria/config")
+	assert.NoError(t, err)
+	assert.Equal(t, "appName: consul", string(b))
+
+	_, err = client.Load(context.Background(), host, "missing")
+	assert.EqualError(t, err, "consul: key missing not found")
+
+	client.token = ""
+	_, err = client.Load(context.Background(), host, "talaria/config")
+	assert.EqualError(t, err, "consul: unable to read talaria/config, status 403")
+}
@@ -0,0 +1,111 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package config
+
+import (
+	"encoding/json"
+)
+
+// WithDefaults returns the settings of a table, completed by the default settings shared by every table. The
+// settings of the table take precedence, the nested ones being merged, so that a table can override a single
+// setting of its compaction while keeping the default sinks.
+func WithDefaults(defaults *Table, t Table) (Table, error) {
+	if defaults == nil {
+		return t, nil
+	}
+
+	base, err := mapOf(*defaults)
+	if err != nil {
+		return t, err
+	}
+
+	overlay, err := mapOf(t)
+	if err != nil {
+		return t, err
+	}
+
+	b, err := json.Marshal(mergeJSON(base, overlay))
+	if err != nil {
+		return t, err
+	}
+
+	var out Table
+	if err := json.Unmarshal(b, &out); err != nil {
+		return t, err
+	}
+	return out, nil
+}
+
+// applyDefaults completes the settings of every table with the default ones
+func (c *Config) applyDefaults() error {
+	for name, t := range c.Tables {
+		merged, err := WithDefaults(c.Defaults, t)
+		if err != nil {
+			return err
+		}
+		c.Tables[name] = merged
+	}
+	return nil
+}
+
+// mapOf converts the settings of a table to a map, without the settings which are not set
+func mapOf(t Table) (map[string]interface{}, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+
+	prune(out)
+	return out, nil
+}
+
+// prune removes the values which are not set, recursively
+func prune(m map[string]interface{}) {
+	for k, v := range m {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if prune(value); len(value) == 0 {
+				delete(m, k)
+			}
+		case []interface{}:
+			if len(value) == 0 {
+				delete(m, k)
+			}
+		case nil:
+			delete(m, k)
+		case string:
+			if value == "" {
+				delete(m, k)
+			}
+		case float64:
+			if value == 0 {
+				delete(m, k)
+			}
+		case bool:
+			if !value {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// mergeJSON merges the overlay into the base, recursively for the objects
+func mergeJSON(base, overlay map[string]interface{}) map[string]interface{} {
+	for k, v := range overlay {
+		src, ok1 := v.(map[string]interface{})
+		dst, ok2 := base[k].(map[string]interface{})
+		if ok1 && ok2 {
+			base[k] = mergeJSON(dst, src)
+			continue
+		}
+
+		base[k] = v
+	}
+	return base
+}
@@ -0,0 +1,73 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDefaults(t *testing.T) {
+	defaults := &Table{
+		TTL:         3600,
+		HashBy:      "event",
+		Concurrency: 8,
+		Compact: &Compaction{
+			Interval: 300,
+			MaxSize:  1 << 30,
+			Sinks:    Sinks{S3: &S3Sink{Bucket: "firehose"}},
+		},
+	}
+
+	merged, err := WithDefaults(defaults, Table{
+		TTL:     60,
+		Compact: &Compaction{Interval: 10, Compression: "snappy"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), merged.TTL)
+	assert.Equal(t, "event", merged.HashBy)
+	assert.Equal(t, 8, merged.Concurrency)
+	assert.Equal(t, 10, merged.Compact.Interval)
+	assert.Equal(t, "snappy", merged.Compact.Compression)
+	assert.Equal(t, int64(1<<30), merged.Compact.MaxSize)
+	assert.Equal(t, "firehose", merged.Compact.S3.Bucket)
+
+	// The defaults are left unchanged
+	assert.Equal(t, 300, defaults.Compact.Interval)
+
+	// Without defaults, the table is unchanged
+	table := Table{TTL: 10}
+	merged, err = WithDefaults(nil, table)
+	assert.NoError(t, err)
+	assert.Equal(t, table, merged)
+}
+
+func TestUnmarshal_Defaults(t *testing.T) {
+	c := new(Config)
+	assert.NoError(t, Unmarshal([]byte(`
+defaults:
+  ttl: 86400
+  compact:
+    interval: 60
+    file:
+      dir: /data/out
+tables:
+  firehose:
+    concurrency: 32
+  metadata:
+    ttl: 3600
+    compact:
+      interval: 3600
+`), c))
+
+	assert.NoError(t, c.applyDefaults())
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, int64(86400), c.Tables["firehose"].TTL)
+	assert.Equal(t, 32, c.Tables["firehose"].Concurrency)
+	assert.Equal(t, 60, c.Tables["firehose"].Compact.Interval)
+	assert.Equal(t, int64(3600), c.Tables["metadata"].TTL)
+	assert.Equal(t, 3600, c.Tables["metadata"].Compact.Interval)
+	assert.Equal(t, "/data/out", c.Tables["metadata"].Compact.File.Directory)
+}
@@ -0,0 +1,89 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// variable matches the references to environment variables, such as ${HOST} or ${PORT:-8080}
+var variable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Unmarshal decodes a YAML config document into the config, rejecting the unknown keys. The references to environment variables are
+// replaced by their value, and the overlay of the selected profile, given by the config already loaded or by
+// the "profile" key of the document, is merged into the document.
+func Unmarshal(data []byte, c *Config) error {
+	return UnmarshalAll([][]byte{data}, c)
+}
+
+// UnmarshalAll decodes several YAML config documents into the config, each document being merged into the
+// previous ones so that the last one takes precedence, before applying the overlay of the selected profile.
+func UnmarshalAll(data [][]byte, c *Config) error {
+	doc := make(map[interface{}]interface{})
+	for _, b := range data {
+		var next map[interface{}]interface{}
+		if err := yaml.Unmarshal(Interpolate(b, os.LookupEnv), &next); err != nil {
+			return err
+		}
+
+		doc = merge(doc, next)
+	}
+
+	profile := c.Profile
+	if name, ok := doc["profile"].(string); ok && profile == "" {
+		profile = name
+	}
+
+	if profile != "" {
+		profiles, _ := doc["profiles"].(map[interface{}]interface{})
+		overlay, ok := profiles[profile].(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("config: unknown profile %s", profile)
+		}
+
+		doc = merge(doc, overlay)
+		doc["profile"] = profile
+	}
+
+	delete(doc, "profiles")
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// Reject the unknown keys, which are most likely a typo
+	return yaml.UnmarshalStrict(out, c)
+}
+
+// Interpolate replaces the references to environment variables by their value. A variable which is not set
+// or empty is replaced by its default, such as "8080" for ${PORT:-8080}, or by an empty string.
+func Interpolate(data []byte, lookup func(string) (string, bool)) []byte {
+	return variable.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := variable.FindSubmatch(ref)
+		if value, ok := lookup(string(match[1])); ok && value != "" {
+			return []byte(value)
+		}
+		return match[3]
+	})
+}
+
+// merge merges the overlay into the document, recursively for the mappings while the other values of the
+// overlay, such as the lists, replace the ones of the document.
+func merge(doc, overlay map[interface{}]interface{}) map[interface{}]interface{} {
+	for k, v := range overlay {
+		src, ok1 := v.(map[interface{}]interface{})
+		dst, ok2 := doc[k].(map[interface{}]interface{})
+		if ok1 && ok2 {
+			doc[k] = merge(dst, src)
+			continue
+		}
+
+		doc[k] = v
+	}
+	return doc
+}
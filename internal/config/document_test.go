@@ -0,0 +1,72 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const document = `
+env: ${ENV:-dev}
+domain: ${DOMAIN}
+writers:
+  grpc:
+    port: ${GRPC_PORT:-8080}
+tables:
+  eventlog:
+    ttl: 3600
+    hashBy: event
+profiles:
+  prod:
+    writers:
+      grpc:
+        port: 9090
+    tables:
+      eventlog:
+        ttl: 86400
+`
+
+func TestInterpolate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		switch name {
+		case "HOST":
+			return "talaria", true
+		case "EMPTY":
+			return "", true
+		}
+		return "", false
+	}
+
+	out := Interpolate([]byte("${HOST}:${PORT:-8080} ${EMPTY:-x} ${MISSING} $HOST"), lookup)
+	assert.Equal(t, "talaria:8080 x  $HOST", string(out))
+}
+
+func TestUnmarshal(t *testing.T) {
+	c := new(Config)
+	assert.NoError(t, Unmarshal([]byte(document), c))
+	assert.Equal(t, "dev", c.Env)
+	assert.Equal(t, "", c.Domain)
+	assert.Equal(t, int32(8080), c.Writers.GRPC.Port)
+	assert.Equal(t, int64(3600), c.Tables["eventlog"].TTL)
+	assert.Equal(t, "", c.Profile)
+}
+
+func TestUnmarshal_Profile(t *testing.T) {
+	c := &Config{Profile: "prod"}
+	assert.NoError(t, Unmarshal([]byte(document), c))
+	assert.Equal(t, "prod", c.Profile)
+	assert.Equal(t, int32(9090), c.Writers.GRPC.Port)
+	assert.Equal(t, int64(86400), c.Tables["eventlog"].TTL)
+	assert.Equal(t, "event", c.Tables["eventlog"].HashBy)
+
+	// The profile can also be selected by the document
+	c = new(Config)
+	assert.NoError(t, Unmarshal([]byte("profile: prod\n"+document), c))
+	assert.Equal(t, int32(9090), c.Writers.GRPC.Port)
+
+	c = &Config{Profile: "staging"}
+	assert.EqualError(t, Unmarshal([]byte(document), c), "config: unknown profile staging")
+}
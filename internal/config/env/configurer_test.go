@@ -9,6 +9,7 @@ import (
 
 	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/config/static"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -99,6 +100,15 @@ computed:
       function main(input)
         return json.encode(input)
       end
+  - name: "split"
+    columns:
+      first: string
+      rest: string
+    func: |
+      function main(input)
+        local first, rest = string.match(input["event"], "(%w+)%.(.+)")
+        return { first = first, rest = rest }
+      end
 `)
 
 	// populate the config with the env variable
@@ -107,7 +117,8 @@ computed:
 
 	// asserts
 	assert.Len(t, c.Tables["eventlog"].Streams, 2)
-	assert.Len(t, c.Computed, 1)
+	assert.Len(t, c.Computed, 2)
+	assert.Equal(t, typeof.String, c.Computed[1].Columns["rest"])
 	assert.Len(t, c.Tables, 1)
 
 	assert.Equal(t, "my-gcp-project", c.Tables["eventlog"].Streams[0].PubSub.Project)
@@ -0,0 +1,66 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client reads the keys of etcd through its JSON gateway.
+type Client struct {
+	client *http.Client
+}
+
+// New creates a new etcd client.
+func New() *Client {
+	return &Client{
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Load reads the value of a key, such as "talaria/config", from the member at the address.
+func (c *Client) Load(ctx context.Context, address, key string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+address+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd: unable to read %s, status %d", key, resp.StatusCode)
+	}
+
+	var out struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+
+	if len(out.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd: key %s not found", key)
+	}
+
+	return base64.StdEncoding.DecodeString(out.Kvs[0].Value)
+}
@@ -0,0 +1,46 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v3/kv/range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req struct {
+			Key string `json:"key"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if key, _ := base64.StdEncoding.DecodeString(req.Key); string(key) != "talaria/config" {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"kvs": [{"value": "` + base64.StdEncoding.EncodeToString([]byte("appName: etcd")) + `"}]}`))
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	client := New()
+
+	b, err := client.Load(context.Background(), host, "talaria/config")
+	assert.NoError(t, err)
+	assert.Equal(t, "appName: etcd", string(b))
+
+	_, err = client.Load(context.Background(), host, "missing")
+	assert.EqualError(t, err, "etcd: key missing not found")
+}
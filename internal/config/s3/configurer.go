@@ -5,28 +5,39 @@ package s3
 
 import (
 	"context"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kelindar/loader"
 	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/monitor/logging"
-	"gopkg.in/yaml.v2"
 )
 
 type downloader interface {
-	Load(ctx context.Context, uri string) ([]byte, error)
+	LoadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error)
 }
 
-// Configurer to fetch configuration from a s3 object
+// Configurer fetches the config from the sources listed by the URI, such as an S3 object, a GCS object, an
+// HTTP(S) URL, a file or a key of Consul or etcd. The sources are separated by commas and merged in order,
+// so that a local file listed last can override the config shared by the cluster.
 type Configurer struct {
 	sync.Mutex
-	client downloader
-	log    logging.Logger
+	client  downloader
+	log     logging.Logger
+	sources map[string]*source // The sources last downloaded, by URI
+	strict  bool               // Whether a source which can not be downloaded is an error
 }
 
-// New creates a new S3 configurer.
+// source represents the content of a source, as last downloaded
+type source struct {
+	data    []byte    // The content of the config last downloaded
+	updated time.Time // The time of the last download
+}
+
+// New creates a new configurer.
 func New(log logging.Logger) *Configurer {
-	return NewWith(loader.New(), log)
+	return NewWith(newSources(loader.New()), log)
 }
 
 // SetLogger to set the logger after initialization
@@ -36,15 +47,23 @@ func (s *Configurer) SetLogger(lo logging.Logger) {
 	s.log = lo
 }
 
-// NewWith creates a new S3 configurer.
+// SetStrict sets whether a source which can not be downloaded is an error, instead of being skipped
+func (s *Configurer) SetStrict(strict bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.strict = strict
+}
+
+// NewWith creates a new configurer.
 func NewWith(dl downloader, log logging.Logger) *Configurer {
 	return &Configurer{
-		client: dl,
-		log:    log,
+		client:  dl,
+		log:     log,
+		sources: make(map[string]*source),
 	}
 }
 
-// Configure fetches a yaml config file from a s3 path and populate the config object
+// Configure fetches the yaml config from every source and populates the config object
 func (s *Configurer) Configure(c *config.Config) error {
 	s.Lock()
 	defer s.Unlock()
@@ -52,15 +71,48 @@ func (s *Configurer) Configure(c *config.Config) error {
 		return nil
 	}
 
-	// download the config
-	b, err := s.client.Load(context.Background(), c.URI)
-	if err != nil {
-		s.log.Warningf("error in downloading config from s3. Load error %+v", err)
+	var docs [][]byte
+	for _, uri := range strings.Split(c.URI, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			b, err := s.load(uri)
+			switch {
+			case err != nil && s.strict:
+				return err
+			case b != nil:
+				docs = append(docs, b)
+			}
+		}
+	}
+
+	if len(docs) == 0 {
 		return nil // Unable to load, skip
 	}
 
-	if err := yaml.Unmarshal(b, c); err != nil {
-		return err
+	return config.UnmarshalAll(docs, c)
+}
+
+// load downloads a source if it was modified since the last download (file or object timestamp), and
+// returns its content or the last downloaded one if unchanged or unavailable.
+func (s *Configurer) load(uri string) ([]byte, error) {
+	last, ok := s.sources[uri]
+	if !ok {
+		last = new(source)
+		s.sources[uri] = last
+	}
+
+	var since time.Time
+	if last.data != nil {
+		since = last.updated.Add(-time.Second) // The timestamps are compared to the second
 	}
-	return nil
+
+	start := time.Now()
+	b, err := s.client.LoadIf(context.Background(), uri, since)
+	switch {
+	case err != nil:
+		s.log.Warningf("error in downloading config from %s. Load error %+v", uri, err)
+	case b != nil:
+		last.data, last.updated = b, start
+	}
+
+	return last.data, err
 }
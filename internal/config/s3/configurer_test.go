@@ -5,7 +5,15 @@ package s3
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/config/static"
@@ -29,7 +37,7 @@ func TestConfigure(t *testing.T) {
 
 type downloadMock func(ctx context.Context, uri string) ([]byte, error)
 
-func (d downloadMock) Load(ctx context.Context, uri string) ([]byte, error) {
+func (d downloadMock) LoadIf(ctx context.Context, uri string, _ time.Time) ([]byte, error) {
 	return d(ctx, uri)
 }
 func TestUpdateAppName(t *testing.T) {
@@ -51,3 +59,108 @@ func TestUpdateAppName(t *testing.T) {
 	assert.Equal(t, c.AppName, "talaria-processor")
 	assert.Nil(t, err)
 }
+
+// modifiedMock returns the content only on its first download, as if it was not modified afterwards
+type modifiedMock struct {
+	since []time.Time
+}
+
+func (d *modifiedMock) LoadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	d.since = append(d.since, updatedSince)
+	if len(d.since) > 1 {
+		return nil, nil
+	}
+	return []byte("appName: talaria-processor"), nil
+}
+
+func TestUnchanged(t *testing.T) {
+	down := new(modifiedMock)
+	configurer := NewWith(down, logging.NewNoop())
+	for i := 0; i < 3; i++ {
+		c := &config.Config{URI: "s3://bucket/conf.yaml"}
+		assert.NoError(t, configurer.Configure(c))
+		assert.Equal(t, "talaria-processor", c.AppName)
+	}
+
+	assert.Len(t, down.since, 3)
+	assert.True(t, down.since[0].IsZero())
+	assert.False(t, down.since[2].IsZero())
+}
+
+func TestMerge(t *testing.T) {
+	var down downloadMock = func(ctx context.Context, uri string) ([]byte, error) {
+		switch uri {
+		case "s3://bucket/conf.yaml":
+			return []byte("appName: talaria\nenv: stg\ntables:\n  eventlog:\n    ttl: 3600\n    hashBy: event\n"), nil
+		case "file:///local.yaml":
+			return []byte("env: dev\ntables:\n  eventlog:\n    ttl: 60\n"), nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	c := &config.Config{URI: "s3://bucket/conf.yaml, file:///local.yaml, gs://bucket/missing.yaml"}
+	assert.NoError(t, NewWith(down, logging.NewNoop()).Configure(c))
+	assert.Equal(t, "talaria", c.AppName)
+	assert.Equal(t, "dev", c.Env)
+	assert.Equal(t, int64(60), c.Tables["eventlog"].TTL)
+	assert.Equal(t, "event", c.Tables["eventlog"].HashBy)
+}
+
+func TestKeyValue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv/talaria/config":
+			_, _ = w.Write([]byte("appName: consul"))
+		case "/v3/kv/range":
+			var req struct {
+				Key string `json:"key"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if key, _ := base64.StdEncoding.DecodeString(req.Key); string(key) != "talaria/config" {
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"kvs": [{"value": "` + base64.StdEncoding.EncodeToString([]byte("appName: etcd")) + `"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	configurer := NewWith(newSources(nil), logging.NewNoop())
+	{
+		c := &config.Config{URI: "consul://" + host + "/talaria/config"}
+		assert.NoError(t, configurer.Configure(c))
+		assert.Equal(t, "consul", c.AppName)
+	}
+	{
+		c := &config.Config{URI: "etcd://" + host + "/talaria/config"}
+		assert.NoError(t, configurer.Configure(c))
+		assert.Equal(t, "etcd", c.AppName)
+	}
+	{
+		_, err := newSources(nil).LoadIf(context.Background(), "etcd://"+host+"/missing", time.Time{})
+		assert.Error(t, err)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	for _, uri := range []string{"consul://consul:8500/talaria/config", "etcd://etcd:2379/talaria/config"} {
+		u, err := url.Parse(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, "talaria/config", keyOf(u))
+	}
+}
+
+func TestStrict(t *testing.T) {
+	var down downloadMock = func(ctx context.Context, uri string) ([]byte, error) {
+		return nil, errors.New("not found")
+	}
+
+	configurer := NewWith(down, logging.NewNoop())
+	assert.NoError(t, configurer.Configure(&config.Config{URI: "s3://bucket/conf.yaml"}))
+
+	configurer.SetStrict(true)
+	assert.Error(t, configurer.Configure(&config.Config{URI: "s3://bucket/conf.yaml"}))
+}
@@ -0,0 +1,58 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package s3
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config/consul"
+	"github.com/kelindar/talaria/internal/config/etcd"
+)
+
+// keyValue reads a key of a key/value store from the member at the address
+type keyValue interface {
+	Load(ctx context.Context, address, key string) ([]byte, error)
+}
+
+// sources downloads the config from the key/value stores, such as Consul or etcd, and from any other source
+// supported by the loader, such as S3, GCS, HTTP(S) or a file.
+type sources struct {
+	loader downloader
+	stores map[string]keyValue // The key/value stores, by scheme
+}
+
+// newSources creates a new downloader for every supported source
+func newSources(loader downloader) *sources {
+	return &sources{
+		loader: loader,
+		stores: map[string]keyValue{
+			"consul": consul.New(),
+			"etcd":   etcd.New(),
+		},
+	}
+}
+
+// LoadIf downloads the config from the URI. The keys of Consul and etcd are small enough to be downloaded
+// on every reload, so they are always returned.
+func (s *sources) LoadIf(ctx context.Context, uri string, updatedSince time.Time) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if store, ok := s.stores[strings.ToLower(u.Scheme)]; ok {
+		return store.Load(ctx, u.Host, keyOf(u))
+	}
+
+	return s.loader.LoadIf(ctx, uri, updatedSince)
+}
+
+// keyOf returns the key of a key/value store, which is the path of the URI without its leading slash, so that
+// consul://consul:8500/talaria/config and etcd://etcd:2379/talaria/config both read the "talaria/config" key.
+func keyOf(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
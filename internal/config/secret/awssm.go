@@ -0,0 +1,58 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+type secretsClient interface {
+	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManager resolves the secrets stored in AWS Secrets Manager.
+type SecretsManager struct {
+	client secretsClient
+}
+
+// NewSecretsManager creates a new AWS Secrets Manager resolver, using the default credentials and region.
+func NewSecretsManager() *SecretsManager {
+	return &SecretsManager{
+		client: secretsmanager.New(session.Must(session.NewSession())),
+	}
+}
+
+// Resolve reads a secret by its name. If a key is specified, the secret must be a JSON object and the
+// value of the key is returned.
+func (s *SecretsManager) Resolve(ctx context.Context, name, key string) (string, error) {
+	out, err := s.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	value := aws.StringValue(out.SecretString)
+	if key == "" {
+		return value, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("the secret is not a JSON object")
+	}
+
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found", key)
+	}
+	return fmt.Sprint(field), nil
+}
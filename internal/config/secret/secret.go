@@ -0,0 +1,148 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package secret
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+)
+
+const (
+	defaultTTL     = 5 * time.Minute  // The time during which a resolved secret is cached
+	resolveTimeout = 10 * time.Second // The timeout for resolving a secret
+)
+
+// Resolver represents a store of secrets, such as Vault or AWS Secrets Manager.
+type Resolver interface {
+	Resolve(ctx context.Context, path, key string) (string, error)
+}
+
+// Configurer replaces the references to secrets in the config, such as "vault://secret/data/talaria#password"
+// or "awssm://talaria/sinks#secretKey", by their value. It must be the last configurer, so that the references
+// coming from every other configurer are resolved.
+type Configurer struct {
+	lock      sync.Mutex
+	resolvers map[string]Resolver // The resolvers, by scheme
+	cache     map[string]cached   // The resolved secrets, by reference
+	ttl       time.Duration       // The time during which a resolved secret is cached
+}
+
+// cached represents a resolved secret
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+// New creates a new configurer which resolves the secrets stored in Vault and AWS Secrets Manager.
+func New() *Configurer {
+	return NewWith(map[string]Resolver{
+		"vault": NewVault(),
+		"awssm": NewSecretsManager(),
+	})
+}
+
+// NewWith creates a new configurer with a set of resolvers, by scheme.
+func NewWith(resolvers map[string]Resolver) *Configurer {
+	return &Configurer{
+		resolvers: resolvers,
+		cache:     make(map[string]cached),
+		ttl:       defaultTTL,
+	}
+}
+
+// Configure resolves the secrets referenced by the string fields of the config.
+func (c *Configurer) Configure(conf *config.Config) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	return c.walk(ctx, reflect.ValueOf(conf).Elem())
+}
+
+// walk resolves the secrets of a value, recursively
+func (c *Configurer) walk(ctx context.Context, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return c.walk(ctx, v.Elem())
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Field(i); field.CanSet() {
+				if err := c.walk(ctx, field); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := c.walk(ctx, v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(k)) // Map values are not addressable, update a copy
+			if err := c.walk(ctx, item); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, item)
+		}
+
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+
+		value, err := c.resolve(ctx, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+// resolve returns the value of a secret, or the value itself if it does not reference a secret
+func (c *Configurer) resolve(ctx context.Context, value string) (string, error) {
+	i := strings.Index(value, "://")
+	if i < 0 {
+		return value, nil
+	}
+
+	resolver, ok := c.resolvers[value[:i]]
+	if !ok {
+		return value, nil // Not a secret, such as an S3 URI
+	}
+
+	if s, ok := c.cache[value]; ok && time.Now().Before(s.expires) {
+		return s.value, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("secret: invalid reference %s", value)
+	}
+
+	path := strings.TrimPrefix(u.Host+u.Path, "/")
+	secret, err := resolver.Resolve(ctx, path, u.Fragment)
+	if err != nil {
+		return "", fmt.Errorf("secret: unable to resolve %s://%s, %s", u.Scheme, path, err)
+	}
+
+	c.cache[value] = cached{value: secret, expires: time.Now().Add(c.ttl)}
+	return secret, nil
+}
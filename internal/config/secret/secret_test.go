@@ -0,0 +1,146 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package secret
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type resolverMock func(path, key string) (string, error)
+
+func (r resolverMock) Resolve(_ context.Context, path, key string) (string, error) {
+	return r(path, key)
+}
+
+func TestConfigure(t *testing.T) {
+	calls := 0
+	c := NewWith(map[string]Resolver{
+		"vault": resolverMock(func(path, key string) (string, error) {
+			calls++
+			return path + "/" + key, nil
+		}),
+	})
+
+	conf := &config.Config{
+		URI: "s3://bucket/config.yaml",
+		Tables: map[string]config.Table{
+			"events": {
+				Masks: []config.Mask{{Secret: "vault://secret/data/mask#salt"}},
+				Streams: config.Streams{
+					{S3: &config.S3Sink{SecretKey: "vault://secret/data/s3#secretKey"}},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, c.Configure(conf))
+	assert.Equal(t, "s3://bucket/config.yaml", conf.URI)
+	assert.Equal(t, "secret/data/mask/salt", conf.Tables["events"].Masks[0].Secret)
+	assert.Equal(t, "secret/data/s3/secretKey", conf.Tables["events"].Streams[0].S3.SecretKey)
+	assert.Equal(t, 2, calls)
+
+	// Resolved secrets are cached across reloads
+	conf.Tables["events"].Streams[0].S3.SecretKey = "vault://secret/data/s3#secretKey"
+	assert.NoError(t, c.Configure(conf))
+	assert.Equal(t, "secret/data/s3/secretKey", conf.Tables["events"].Streams[0].S3.SecretKey)
+	assert.Equal(t, 2, calls)
+}
+
+func TestConfigure_Error(t *testing.T) {
+	c := NewWith(map[string]Resolver{
+		"vault": resolverMock(func(path, key string) (string, error) {
+			return "", errors.New("permission denied")
+		}),
+	})
+
+	conf := &config.Config{Tables: map[string]config.Table{
+		"events": {Masks: []config.Mask{{Secret: "vault://secret/data/mask#salt"}}},
+	}}
+	assert.EqualError(t, c.Configure(conf), "secret: unable to resolve vault://secret/data/mask, permission denied")
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/talaria": // version 2
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "v2"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/talaria": // version 1
+			_, _ = w.Write([]byte(`{"data": {"password": "v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	v := &Vault{client: srv.Client(), address: srv.URL, token: "token"}
+	ctx := context.Background()
+	{
+		value, err := v.Resolve(ctx, "secret/data/talaria", "password")
+		assert.NoError(t, err)
+		assert.Equal(t, "v2", value)
+	}
+	{
+		value, err := v.Resolve(ctx, "kv/talaria", "password")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", value)
+	}
+	{
+		_, err := v.Resolve(ctx, "kv/talaria", "missing")
+		assert.Error(t, err)
+		_, err = v.Resolve(ctx, "kv/talaria", "")
+		assert.Error(t, err)
+		_, err = v.Resolve(ctx, "kv/unknown", "password")
+		assert.Error(t, err)
+	}
+}
+
+type secretsMock map[string]string
+
+func (m secretsMock) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := m[*in.SecretId]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func TestSecretsManager(t *testing.T) {
+	s := &SecretsManager{client: secretsMock{
+		"talaria/plain": "hello",
+		"talaria/json":  `{"accessKey": "a", "secretKey": "b"}`,
+	}}
+
+	ctx := context.Background()
+	{
+		value, err := s.Resolve(ctx, "talaria/plain", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", value)
+	}
+	{
+		value, err := s.Resolve(ctx, "talaria/json", "secretKey")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", value)
+	}
+	{
+		_, err := s.Resolve(ctx, "talaria/plain", "secretKey")
+		assert.Error(t, err)
+		_, err = s.Resolve(ctx, "talaria/unknown", "")
+		assert.Error(t, err)
+	}
+}
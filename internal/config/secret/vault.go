@@ -0,0 +1,76 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Vault resolves the secrets stored in the key/value engine (version 1 or 2) of HashiCorp Vault.
+type Vault struct {
+	client  *http.Client
+	address string // The address of the Vault server
+	token   string // The token used to authenticate
+}
+
+// NewVault creates a new Vault resolver, configured by the VAULT_ADDR and VAULT_TOKEN environment variables.
+func NewVault() *Vault {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = "http://127.0.0.1:8200"
+	}
+
+	return &Vault{
+		client:  &http.Client{Timeout: resolveTimeout},
+		address: strings.TrimSuffix(address, "/"),
+		token:   os.Getenv("VAULT_TOKEN"),
+	}
+}
+
+// Resolve reads a key of the secret at the path, such as "secret/data/talaria".
+func (v *Vault) Resolve(ctx context.Context, path, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("a key is required, such as vault://%s#password", path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, v.address+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+
+	// The key/value engine version 2 nests the values under "data"
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found", key)
+	}
+	return fmt.Sprint(value), nil
+}
@@ -27,7 +27,7 @@ func newStore(li time.Duration, co []Configurer) *store {
 
 	c, err := s.value()
 	if err != nil {
-		panic("unable to load config")
+		panic("unable to load config: " + err.Error())
 	}
 	s.config.Store(c)
 	return s
@@ -38,13 +38,18 @@ func (cs *store) watch(ctx context.Context) {
 	async.Repeat(ctx, cs.loadInterval, cs.reload)
 }
 
-// reloads the config
+// reloads the config, keeping the current one if the new one is invalid or unchanged
 func (cs *store) reload(ctx context.Context) (interface{}, error) {
 	newConfig, err := cs.value()
 	if err != nil {
+		log.Printf("config: rejected the new config, %s", err)
 		return nil, err
 	}
 
+	if current := cs.config.Load().(*Config); current.Version == newConfig.Version {
+		return nil, nil
+	}
+
 	cs.config.Store(newConfig)
 	return nil, nil
 }
@@ -60,5 +65,20 @@ func (cs *store) value() (*Config, error) {
 			return nil, err
 		}
 	}
+
+	if err := c.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	version, err := versionOf(c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Version = version
 	return c, nil
 }
@@ -0,0 +1,631 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/timestamp"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/twmb/murmur3"
+	"gopkg.in/yaml.v2"
+)
+
+// Validate checks the settings which can not be applied safely, such as a negative TTL, a sampling rate
+// outside of [0, 1], a missing setting, an unknown type or conflicting settings of a table, so that an
+// invalid config is rejected instead of replacing the one in use.
+func (c *Config) Validate() error {
+	for name, t := range c.Tables {
+		if err := ValidateTable(name, t); err != nil {
+			return err
+		}
+	}
+
+	for _, computed := range c.Computed {
+		if err := validateComputed(&computed); err != nil {
+			return fmt.Errorf("config: %s", err)
+		}
+	}
+
+	if c.Storage.Idle < 0 {
+		return fmt.Errorf("config: storage has a negative idle duration")
+	}
+
+	switch c.Cluster.Replication {
+	case "", "sync", "async":
+	default:
+		return fmt.Errorf("config: unknown replication %s", c.Cluster.Replication)
+	}
+
+	switch c.Cluster.Discovery.Provider {
+	case "", "dns", "kubernetes", "static":
+	default:
+		return fmt.Errorf("config: unknown discovery provider %s", c.Cluster.Discovery.Provider)
+	}
+
+	switch c.Scripting.Engine {
+	case "", "lua", "javascript":
+	default:
+		return fmt.Errorf("config: unknown scripting engine %s", c.Scripting.Engine)
+	}
+
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("config: unknown logging format %s", c.Logging.Format)
+	}
+
+	if c.Writers.GRPC != nil && !validTLS(c.Writers.GRPC.TLS) {
+		return fmt.Errorf("config: the tls of the grpc listener requires both a cert and a key")
+	}
+
+	if c.Writers.GRPC != nil && c.Writers.GRPC.MaxSize < 0 {
+		return fmt.Errorf("config: the maximum message size of the grpc listener can not be negative")
+	}
+
+	if c.Readers.FlightSQL != nil && c.Readers.FlightSQL.BatchSize < 0 {
+		return fmt.Errorf("config: the batch size of flight sql can not be negative")
+	}
+
+	if c.Readers.Presto != nil && !validTLS(c.Readers.Presto.TLS) {
+		return fmt.Errorf("config: the tls of the presto listener requires both a cert and a key")
+	}
+
+	if t := c.Cluster.TLS; t != nil && (t.Cert == "" || t.Key == "" || t.ClientCA == "") {
+		return fmt.Errorf("config: the tls of the cluster requires a cert, a key and the clientCA of the nodes")
+	}
+
+	for _, key := range c.Cluster.Keys {
+		if b, err := base64.StdEncoding.DecodeString(key); err != nil || (len(b) != 16 && len(b) != 24 && len(b) != 32) {
+			return fmt.Errorf("config: the gossip keys must be 16, 24 or 32 bytes encoded in base64")
+		}
+	}
+
+	if c.Writers.S3SQS != nil && c.Writers.S3SQS.Queue == "" {
+		return fmt.Errorf("config: the s3sqs ingestion requires a queue")
+	}
+
+	if c.Writers.HTTP != nil && c.Writers.HTTP.Prometheus != nil && c.Writers.HTTP.Prometheus.Table == "" {
+		return fmt.Errorf("config: the prometheus ingestion requires a table")
+	}
+
+	if c.Writers.OTLP != nil && c.Writers.OTLP.Traces == "" && c.Writers.OTLP.Logs == "" {
+		return fmt.Errorf("config: the opentelemetry ingestion requires a table for the traces or the logs")
+	}
+
+	if c.Writers.Syslog != nil && len(c.Writers.Syslog.Tables) == 0 {
+		return fmt.Errorf("config: the syslog listener requires the tables of the tags")
+	}
+
+	if c.Writers.Fluent != nil && len(c.Writers.Fluent.Tables) == 0 {
+		return fmt.Errorf("config: the fluent listener requires the tables of the tags")
+	}
+
+	if c.Audit != nil && c.Audit.File == "" && c.Audit.S3 == nil {
+		return fmt.Errorf("config: the audit trail requires a file or an s3 bucket")
+	}
+
+	for producer, q := range c.Writers.Quotas {
+		if q.Rate < 0 || q.Burst < 0 || q.Bytes < 0 || (q.Rate == 0 && q.Bytes == 0) {
+			return fmt.Errorf("config: the quota of producer '%s' requires a positive rate or bytes", producer)
+		}
+	}
+
+	for name, t := range c.Tables {
+		for _, tier := range t.Downsample {
+			if target, ok := c.Tables[tier.Table]; !ok || target.HashBy != t.HashBy || target.SortBy != t.SortBy {
+				return fmt.Errorf("config: table %s is downsampled into %s, which must be a table with the same key and time columns", name, tier.Table)
+			}
+		}
+	}
+
+	if err := validateTenants(c); err != nil {
+		return err
+	}
+
+	if err := validateACL(c.Readers.ACL); err != nil {
+		return err
+	}
+
+	if c.Writers.Auth != nil {
+		return validateAuth(c.Writers.Auth, c.Cluster.Ownership)
+	}
+	return nil
+}
+
+// validateTenants checks the tenants, along with the producers and the clients assigned to them
+func validateTenants(c *Config) error {
+	for name, t := range c.Tenants {
+		switch {
+		case name == "" || strings.ContainsAny(name, ".*"):
+			return fmt.Errorf("config: the tenant '%s' must be named without a dot or a wildcard", name)
+		case t.Storage < 0:
+			return fmt.Errorf("config: the tenant '%s' has a negative storage", name)
+		case t.Quota != nil && (t.Quota.Rate < 0 || t.Quota.Burst < 0 || t.Quota.Bytes < 0 || (t.Quota.Rate == 0 && t.Quota.Bytes == 0)):
+			return fmt.Errorf("config: the quota of tenant '%s' requires a positive rate or bytes", name)
+		}
+	}
+
+	known := func(tenant string) bool {
+		_, ok := c.Tenants[tenant]
+		return tenant == "" || ok
+	}
+
+	if c.Writers.Auth != nil {
+		for _, k := range c.Writers.Auth.Keys {
+			if !known(k.Tenant) {
+				return fmt.Errorf("config: the auth key of producer '%s' belongs to an unknown tenant %s", k.Name, k.Tenant)
+			}
+		}
+	}
+
+	for _, a := range c.Readers.ACL {
+		if !known(a.Tenant) {
+			return fmt.Errorf("config: the acl of client '%s' belongs to an unknown tenant %s", a.Identity, a.Tenant)
+		}
+	}
+	return nil
+}
+
+// validateACL checks the access control of the queries
+func validateACL(list []Access) error {
+	identities := make(map[string]bool, len(list))
+	for _, a := range list {
+		switch {
+		case a.Identity == "":
+			return fmt.Errorf("config: the acl requires an identity for every client")
+		case identities[a.Identity]:
+			return fmt.Errorf("config: the acl of client '%s' is not unique", a.Identity)
+		}
+		identities[a.Identity] = true
+	}
+	return nil
+}
+
+// validateAuth checks the authentication of the producers
+func validateAuth(a *Auth, ownership bool) error {
+	if ownership && a.Token == "" {
+		return fmt.Errorf("config: the auth requires a token for the nodes to forward rows to each other")
+	}
+
+	keys := make(map[string]bool, len(a.Keys))
+	for _, k := range a.Keys {
+		switch {
+		case k.Name == "" || k.Key == "":
+			return fmt.Errorf("config: the auth key of producer '%s' requires a name and a key", k.Name)
+		case len(k.Tables) == 0:
+			return fmt.Errorf("config: the auth key of producer '%s' can not write to any table", k.Name)
+		case keys[k.Key] || k.Key == a.Token:
+			return fmt.Errorf("config: the auth key of producer '%s' is not unique", k.Name)
+		}
+		keys[k.Key] = true
+	}
+
+	if a.JWT != nil && a.JWT.Secret == "" {
+		return fmt.Errorf("config: the auth jwt requires a secret")
+	}
+	return nil
+}
+
+// validTLS checks that the TLS of a listener, if any, has both a certificate and a key
+func validTLS(t *TLS) bool {
+	return t == nil || (t.Cert != "" && t.Key != "")
+}
+
+// validEncryption checks that the encryption of a sink, if any, has a master key of 32 bytes
+func validEncryption(e *Encryption) bool {
+	if e == nil {
+		return true
+	}
+
+	b, err := base64.StdEncoding.DecodeString(e.Key)
+	return err == nil && len(b) == 32
+}
+
+// ValidateTable checks the settings of a table.
+func ValidateTable(name string, t Table) error {
+	if name == "" {
+		return fmt.Errorf("config: a table has no name")
+	}
+
+	if t.TTL < 0 {
+		return fmt.Errorf("config: table %s has a negative ttl", name)
+	}
+
+	if t.Sample != nil && (t.Sample.Rate < 0 || t.Sample.Rate > 1) {
+		return fmt.Errorf("config: table %s has a sampling rate outside of [0, 1]", name)
+	}
+
+	if t.Compact != nil && t.Compact.Interval < 0 {
+		return fmt.Errorf("config: table %s has a negative compaction interval", name)
+	}
+
+	if t.Concurrency < 0 {
+		return fmt.Errorf("config: table %s has a negative concurrency", name)
+	}
+
+	if t.HashBy != "" && t.HashBy == t.SortBy {
+		return fmt.Errorf("config: table %s uses the column %s as both its key and its time", name, t.HashBy)
+	}
+
+	if t.IngestedBy != "" && (t.IngestedBy == t.HashBy || t.IngestedBy == t.SortBy) {
+		return fmt.Errorf("config: table %s records the ingestion time in its key or time column %s", name, t.IngestedBy)
+	}
+
+	if e := t.EventTime; e != nil {
+		switch strings.ToLower(e.Late) {
+		case "", "partition", "drop", "metric":
+		default:
+			return fmt.Errorf("config: table %s has an unknown late policy %s", name, e.Late)
+		}
+
+		if e.Lateness < 0 {
+			return fmt.Errorf("config: table %s has a negative lateness", name)
+		}
+	}
+
+	for column, ts := range t.Timestamps {
+		if _, err := timestamp.New(ts.Formats, ts.Timezone, ts.Output); err != nil {
+			return fmt.Errorf("config: table %s has an invalid timestamp column %s, %s", name, column, err)
+		}
+	}
+
+	for _, tier := range t.Downsample {
+		if err := validateTier(name, t, tier); err != nil {
+			return fmt.Errorf("config: table %s has an invalid downsampling, %s", name, err)
+		}
+	}
+
+	if len(t.Pipeline) > 0 && (t.Filter != "" || t.Sample != nil || len(t.Enrich) > 0 || len(t.Masks) > 0) {
+		return fmt.Errorf("config: table %s has both a pipeline and a filter, sample, enrich or masks", name)
+	}
+
+	if err := validateSchema(t.Schema); err != nil {
+		return fmt.Errorf("config: table %s has an invalid schema, %s", name, err)
+	}
+
+	if err := validateStages(t); err != nil {
+		return fmt.Errorf("config: table %s has an invalid pipeline, %s", name, err)
+	}
+
+	if t.Compact != nil {
+		switch strings.ToLower(t.Compact.Encoder) {
+		case "", "orc":
+		default:
+			return fmt.Errorf("config: table %s has an unknown compaction encoder %s", name, t.Compact.Encoder)
+		}
+
+		switch strings.ToLower(t.Compact.Compression) {
+		case "", "zlib", "snappy", "none":
+		default:
+			return fmt.Errorf("config: table %s has an unknown compression %s", name, t.Compact.Compression)
+		}
+
+		if t.Compact.MaxSize < 0 {
+			return fmt.Errorf("config: table %s has a negative compaction maxSize", name)
+		}
+
+		if err := validateSinks(t.Compact.Sinks); err != nil {
+			return fmt.Errorf("config: table %s has an invalid compaction, %s", name, err)
+		}
+
+		if r := t.Compact.Retry; r != nil && (r.MaxAttempts < 0 || r.Backoff < 0 || r.MaxBackoff < 0 || r.ReplayInterval < 0 || r.Jitter < 0 || r.Jitter > 1) {
+			return fmt.Errorf("config: table %s has an invalid compaction retry policy", name)
+		}
+
+		if s := t.Compact.Sinks; t.Compact.Manifest != nil && (s.BigQuery != nil || s.Talaria != nil || s.PubSub != nil ||
+			s.Snowflake != nil || s.ClickHouse != nil || s.Elastic != nil || s.Kafka != nil || s.Postgres != nil) {
+			return fmt.Errorf("config: table %s has a compaction manifest, which requires file or object store sinks", name)
+		}
+
+		if s := t.Compact.Sinks; t.Compact.Sketches != nil && (s.BigQuery != nil || s.Talaria != nil || s.PubSub != nil ||
+			s.Snowflake != nil || s.ClickHouse != nil || s.Elastic != nil || s.Kafka != nil || s.Postgres != nil) {
+			return fmt.Errorf("config: table %s has compaction sketches, which require file or object store sinks", name)
+		}
+
+		if b := t.Compact.Breaker; b != nil && (b.Failures < 0 || b.Cooldown < 0) {
+			return fmt.Errorf("config: table %s has a circuit breaker with a negative number of failures or cooldown", name)
+		}
+
+		if k := t.Compact.Sketches; k != nil && (len(k.Columns) == 0 || k.Column == "" || k.Window < 0 ||
+			(k.Precision != 0 && (k.Precision < 4 || k.Precision > 16))) {
+			return fmt.Errorf("config: table %s has compaction sketches which require columns, a time column, a positive window and a precision between 4 and 16", name)
+		}
+
+		if c := t.Compact.Catalog; c != nil && (c.Database == "" || c.Table == "" || (c.Glue == nil) == (c.Hive == nil)) {
+			return fmt.Errorf("config: table %s has a compaction catalog which requires a database, a table and either glue or hive", name)
+		}
+
+		if c := t.Compact.Catalog; c != nil && c.Hive != nil && c.Hive.Address == "" {
+			return fmt.Errorf("config: table %s has a compaction catalog which requires the address of the hive metastore", name)
+		}
+
+		for sink, throttle := range t.Compact.Throttle {
+			if err := validateThrottle(sink, throttle); err != nil {
+				return fmt.Errorf("config: table %s has an invalid throttle, %s", name, err)
+			}
+		}
+	}
+
+	for _, sinks := range t.Streams {
+		if err := validateSinks(sinks); err != nil {
+			return fmt.Errorf("config: table %s has an invalid stream, %s", name, err)
+		}
+	}
+
+	if t.Strict != nil {
+		for _, column := range t.Strict.Required {
+			if column == "" {
+				return fmt.Errorf("config: table %s requires a column without a name", name)
+			}
+		}
+
+		for _, sinks := range t.Strict.Errors {
+			if err := validateSinks(sinks); err != nil {
+				return fmt.Errorf("config: table %s has an invalid errors stream, %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSchema checks the types of an inline schema, the schemas loaded from a URL being checked once loaded
+func validateSchema(schema string) error {
+	if schema == "" || strings.Contains(schema, "://") {
+		return nil
+	}
+
+	var columns typeof.Schema
+	if err := yaml.Unmarshal([]byte(schema), &columns); err != nil {
+		return err
+	}
+	return validateColumns(columns)
+}
+
+// validateColumns checks that every column has a known type
+func validateColumns(columns typeof.Schema) error {
+	for name, typ := range columns {
+		if typ == typeof.Unsupported {
+			return fmt.Errorf("column %s has an unknown type", name)
+		}
+	}
+	return nil
+}
+
+// validateStages checks the stages of the ingestion pipeline of a table, including the legacy options
+func validateStages(t Table) error {
+	for i := range t.Enrich {
+		if err := validateLookup(&t.Enrich[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := range t.Masks {
+		if err := validateMask(&t.Masks[i]); err != nil {
+			return err
+		}
+	}
+
+	for i, stage := range t.Pipeline {
+		transforms := 0
+		for _, set := range []bool{stage.Filter != "", stage.Flatten != "", stage.Enrich != nil,
+			stage.Mask != nil, stage.Compute != nil, stage.Sample != nil} {
+			if set {
+				transforms++
+			}
+		}
+
+		if transforms != 1 {
+			return fmt.Errorf("stage %d must have exactly one transform", i)
+		}
+
+		switch stage.OnError {
+		case "", "skip", "drop":
+		default:
+			return fmt.Errorf("stage %d has an unknown onError %s", i, stage.OnError)
+		}
+
+		var err error
+		switch {
+		case stage.Enrich != nil:
+			err = validateLookup(stage.Enrich)
+		case stage.Mask != nil:
+			err = validateMask(stage.Mask)
+		case stage.Compute != nil:
+			err = validateComputed(stage.Compute)
+		case stage.Sample != nil && (stage.Sample.Rate < 0 || stage.Sample.Rate > 1):
+			err = fmt.Errorf("the sampling rate is outside of [0, 1]")
+		}
+
+		if err != nil {
+			return fmt.Errorf("stage %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// validateTier checks a downsampling tier of a table
+func validateTier(name string, t Table, tier Tier) error {
+	switch {
+	case tier.Table == "" || tier.Table == name:
+		return fmt.Errorf("the tier requires a table other than the downsampled one")
+	case tier.Interval <= 0:
+		return fmt.Errorf("the tier %s requires a positive interval", tier.Table)
+	case t.Compact == nil:
+		return fmt.Errorf("the tier %s requires the compaction of the table, during which the rows are aggregated", tier.Table)
+	}
+
+	for column, agg := range tier.Columns {
+		switch strings.ToLower(agg) {
+		case "sum", "min", "max", "first", "last":
+		default:
+			return fmt.Errorf("the tier %s has an unknown aggregation %s of column %s", tier.Table, agg, column)
+		}
+
+		if column == "count" || column == t.HashBy || column == t.SortBy {
+			return fmt.Errorf("the tier %s can not aggregate the column %s", tier.Table, column)
+		}
+	}
+	return nil
+}
+
+// validateComputed checks a computed column
+func validateComputed(c *Computed) error {
+	if c.Name == "" || c.Func == "" {
+		return fmt.Errorf("a computed column requires both a name and a func")
+	}
+
+	if len(c.Columns) > 0 {
+		return validateColumns(c.Columns)
+	}
+
+	if c.Type == typeof.Unsupported {
+		return fmt.Errorf("computed column %s has an unknown type", c.Name)
+	}
+	return nil
+}
+
+// validateLookup checks a reference dataset
+func validateLookup(l *Lookup) error {
+	if l.Source == "" || l.Key == "" {
+		return fmt.Errorf("an enrichment requires both a source and a key")
+	}
+	return validateColumns(l.Columns)
+}
+
+// validateMask checks a masked column
+func validateMask(m *Mask) error {
+	if m.Column == "" {
+		return fmt.Errorf("a mask requires a column")
+	}
+
+	switch m.Func {
+	case "sha256", "hmac", "truncate", "mask":
+		return nil
+	default:
+		return fmt.Errorf("the mask of column %s has an unknown func %s", m.Column, m.Func)
+	}
+}
+
+// validateThrottle checks that the throttle of a sink has valid limits and windows
+func validateThrottle(sink string, t *Throttle) error {
+	switch sink {
+	case "s3", "azure", "adls", "gcs", "bigquery", "file", "talaria", "pubsub", "snowflake", "clickhouse", "elastic", "kafka", "postgres", "hdfs":
+	default:
+		return fmt.Errorf("the sink %s is unknown", sink)
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	if t.Bandwidth < 0 {
+		return fmt.Errorf("the bandwidth of the %s sink is negative", sink)
+	}
+
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return fmt.Errorf("the timezone of the %s sink is unknown", sink)
+	}
+
+	for _, w := range t.Windows {
+		_, errFrom := time.Parse("15:04", w.From)
+		_, errUntil := time.Parse("15:04", w.Until)
+		if errFrom != nil || errUntil != nil || w.Bandwidth < 0 {
+			return fmt.Errorf("the %s sink has a window which requires a bandwidth and times formatted as 15:04", sink)
+		}
+
+		for _, day := range w.Days {
+			switch strings.ToLower(day) {
+			case "mon", "tue", "wed", "thu", "fri", "sat", "sun",
+				"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday":
+			default:
+				return fmt.Errorf("the %s sink has a window with an unknown day %s", sink, day)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSinks checks that the sinks have their required settings
+func validateSinks(s Sinks) error {
+	switch {
+	case s.S3 != nil && s.S3.Bucket == "":
+		return fmt.Errorf("the s3 sink requires a bucket")
+	case s.Azure != nil && s.Azure.Container == "":
+		return fmt.Errorf("the azure sink requires a container")
+	case s.BigQuery != nil && (s.BigQuery.Project == "" || s.BigQuery.Dataset == "" || s.BigQuery.Table == ""):
+		return fmt.Errorf("the bigquery sink requires a project, a dataset and a table")
+	case s.GCS != nil && s.GCS.Bucket == "":
+		return fmt.Errorf("the gcs sink requires a bucket")
+	case s.S3 != nil && !validEncryption(s.S3.Encryption):
+		return fmt.Errorf("the encryption of the s3 sink requires a key of 32 bytes encoded in base64")
+	case s.GCS != nil && !validEncryption(s.GCS.Encryption):
+		return fmt.Errorf("the encryption of the gcs sink requires a key of 32 bytes encoded in base64")
+	case s.File != nil && s.File.Directory == "":
+		return fmt.Errorf("the file sink requires a dir")
+	case s.Talaria != nil && s.Talaria.Endpoint == "":
+		return fmt.Errorf("the talaria sink requires an endpoint")
+	case s.PubSub != nil && (s.PubSub.Project == "" || s.PubSub.Topic == ""):
+		return fmt.Errorf("the pubsub sink requires a project and a topic")
+	case s.PubSub != nil && s.PubSub.FlowControl != nil && s.PubSub.FlowControl.LimitExceeded != "" &&
+		s.PubSub.FlowControl.LimitExceeded != "error" && s.PubSub.FlowControl.LimitExceeded != "block":
+		return fmt.Errorf("the flow control of the pubsub sink must either block or error once a limit is exceeded")
+	case s.Snowflake != nil && (s.Snowflake.Account == "" || s.Snowflake.User == "" || s.Snowflake.PrivateKey == ""):
+		return fmt.Errorf("the snowflake sink requires an account, a user and a private key")
+	case s.Snowflake != nil && (s.Snowflake.Table == "" || s.Snowflake.Stage == ""):
+		return fmt.Errorf("the snowflake sink requires a table and a stage")
+	case s.ClickHouse != nil && (len(s.ClickHouse.Endpoints) == 0 || s.ClickHouse.Table == ""):
+		return fmt.Errorf("the clickhouse sink requires endpoints and a table")
+	case s.Elastic != nil && (len(s.Elastic.Endpoints) == 0 || s.Elastic.Index == ""):
+		return fmt.Errorf("the elastic sink requires endpoints and an index")
+	case s.Elastic != nil && s.Elastic.Template != "" && !json.Valid([]byte(s.Elastic.Template)):
+		return fmt.Errorf("the index template of the elastic sink is not valid JSON")
+	case s.ADLS != nil && (s.ADLS.Account == "" || s.ADLS.Filesystem == ""):
+		return fmt.Errorf("the adls sink requires an account and a file system")
+	case s.Kafka != nil && (len(s.Kafka.Brokers) == 0 || s.Kafka.Topic == ""):
+		return fmt.Errorf("the kafka sink requires brokers and a topic")
+	case s.HDFS != nil && (len(s.HDFS.Endpoints) == 0 || s.HDFS.Directory == ""):
+		return fmt.Errorf("the hdfs sink requires endpoints and a dir")
+	case s.Postgres != nil && (s.Postgres.Address == "" || s.Postgres.Database == "" || s.Postgres.User == "" || s.Postgres.Table == ""):
+		return fmt.Errorf("the postgres sink requires an address, a database, a user and a table")
+	case s.Postgres != nil && s.Postgres.SSLMode != "" && s.Postgres.SSLMode != "disable" && s.Postgres.SSLMode != "require" && s.Postgres.SSLMode != "verify-full":
+		return fmt.Errorf("the ssl mode of the postgres sink must be disable, require or verify-full")
+	case s.Postgres != nil && s.Postgres.Redshift != nil && (s.Postgres.Redshift.Location == "" || s.Postgres.Redshift.IAMRole == ""):
+		return fmt.Errorf("the redshift staging of the postgres sink requires a location and an iam role")
+	case s.S3 == nil && s.Azure == nil && s.BigQuery == nil && s.GCS == nil && s.File == nil && s.Talaria == nil && s.PubSub == nil &&
+		s.Snowflake == nil && s.ClickHouse == nil && s.Elastic == nil && s.Kafka == nil &&
+		s.ADLS == nil && s.Postgres == nil && s.HDFS == nil:
+		return fmt.Errorf("no sink is configured")
+	}
+
+	// The files loaded into Snowflake are first uploaded to the location of its stage
+	if s.Snowflake != nil {
+		if err := validateSinks(s.Snowflake.Upload); err != nil {
+			return fmt.Errorf("the upload of the snowflake sink is invalid: %v", err)
+		}
+	}
+
+	// The files copied into Redshift are first uploaded to the location they are staged to
+	if s.Postgres != nil && s.Postgres.Redshift != nil {
+		if err := validateSinks(s.Postgres.Redshift.Upload); err != nil {
+			return fmt.Errorf("the upload of the postgres sink is invalid: %v", err)
+		}
+	}
+	return nil
+}
+
+// versionOf returns the version of the config, a hash of its content which is identical on every node
+// loading the same config.
+func versionOf(c *Config) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%08x", murmur3.Sum32(b)), nil
+}
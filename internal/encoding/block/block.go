@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/golang/snappy"
 	"github.com/kelindar/talaria/internal/column"
@@ -16,6 +17,9 @@ import (
 	"github.com/kelindar/binary/nocopy"
 )
 
+// ErrDropped is returned by a transformation when the row should be discarded.
+var ErrDropped = errors.New("row was dropped")
+
 var (
 	errSchemaMismatch    = errors.New("mismatch between internal schema and requested columns")
 	errEmptyBatch        = errors.New("batch is empty")
@@ -132,6 +136,36 @@ func (b *Block) Min(column string) (int64, bool) {
 	return col.Min()
 }
 
+// Bounds selects both the smallest and the largest value for a column (must be an integer or a bigint)
+func (b *Block) Bounds(column string) (min, max int64, ok bool) {
+	columns, err := b.Select(typeof.Schema{
+		column: typeof.Int64,
+	})
+	if err != nil {
+		return 0, 0, false
+	}
+
+	col := columns[column]
+	min, max = math.MaxInt64, math.MinInt64
+	_ = col.Range(0, col.Count(), func(_ int, v interface{}) error {
+		if i32, isInt32 := v.(int32); isInt32 {
+			v = int64(i32)
+		}
+
+		if value, isInt := v.(int64); isInt {
+			if value < min {
+				min = value
+			}
+			if value > max {
+				max = value
+			}
+			ok = true
+		}
+		return nil
+	})
+	return
+}
+
 // Writes a set of columns into the block
 func (b *Block) writeColumns(columns column.Columns) error {
 	var offset uint32
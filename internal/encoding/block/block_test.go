@@ -59,6 +59,13 @@ func TestBlock_Types(t *testing.T) {
 		assert.False(t, ok) // Actually might be wrong...
 		assert.Equal(t, int64(9223372036854775807), min)
 	}
+
+	{
+		min, max, ok := b[0].Bounds("int1")
+		assert.True(t, ok)
+		assert.Equal(t, int64(65536), min)
+		assert.Equal(t, int64(65536), max)
+	}
 }
 
 // BenchmarkBlockRead/read-8         	   13884	     86016 ns/op	  321704 B/op	      12 allocs/op
@@ -47,7 +47,7 @@ func FromBatchBy(batch *talaria.Batch, partitionBy string, filter *typeof.Schema
 		}
 
 		// Prepare a row for transformation
-		row := NewRow(filter.Clone(), len(event.Value))
+		row := acquireRow(filter.Clone())
 		for k, v := range event.Value {
 			columnName := stringAt(batch.Strings, k)
 			columnValue, err := readValue(batch.Strings, v)
@@ -60,11 +60,16 @@ func FromBatchBy(batch *talaria.Batch, partitionBy string, filter *typeof.Schema
 
 		// Append computed columns
 		// Error can only be from encoding the row, error is logged in Publish() so we can ignore the error here and continue to convert row to columns
-		out, _ := apply(row)
+		out, err := apply(row)
+		if err == ErrDropped {
+			releaseRow(row, out)
+			continue
+		}
 
 		// Append to columnar data structure and fill nulls for row
 		out.AppendTo(columns)
 		columns.FillNulls()
+		releaseRow(row, out)
 	}
 
 	// Write the columns into the block
@@ -145,6 +150,10 @@ func findParitionKey(dict map[uint32][]byte, partitionBy string) (uint32, bool)
 func makeBlocks(v map[string]column.Columns) ([]Block, error) {
 	blocks := make([]Block, 0, len(v))
 	for k, columns := range v {
+		if columns.Max() == 0 {
+			continue // Every row of the partition was dropped
+		}
+
 		block, err := FromColumns(k, columns)
 		if err != nil {
 			return nil, err
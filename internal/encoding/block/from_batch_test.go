@@ -108,6 +108,23 @@ func TestBlock_FromBatch(t *testing.T) {
 	assert.Contains(t, string(row["data"].(json.RawMessage)), "event3")
 }
 
+func TestBlock_FromBatchDropped(t *testing.T) {
+	dropEvent2 := func(r Row) (Row, error) {
+		if r.Values["d"] == "event2" {
+			return r, ErrDropped
+		}
+		return r, nil
+	}
+
+	// Every event2 row should be dropped, along with its partition
+	blocks, err := FromBatchBy(testBatch, "d", nil, dropEvent2)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+	for _, b := range blocks {
+		assert.NotEqual(t, "event2", string(b.Key))
+	}
+}
+
 func newDataColumn() (column.Computed, error) {
 	return column.NewComputed("data", typeof.JSON, `
 	local json = require("json")
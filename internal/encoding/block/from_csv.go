@@ -71,15 +71,21 @@ func FromCSVBy(input []byte, partitionBy string, filter *typeof.Schema, apply ap
 		}
 
 		// Prepare a row for transformation
-		row := NewRow(filter.Clone(), len(r))
+		row := acquireRow(filter.Clone())
 		for i, v := range r {
 			row.Set(header[i], v)
 		}
 
 		// Append computed columns and fill nulls for the row
-		out, _ := apply(row)
+		out, err := apply(row)
+		if err == ErrDropped {
+			releaseRow(row, out)
+			continue
+		}
+
 		size += out.AppendTo(columns)
 		size += columns.FillNulls()
+		releaseRow(row, out)
 	}
 
 	// Write the last chunk
@@ -0,0 +1,230 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package block
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// FromJSONBy creates a block from JSON objects, either one per line or in an array. It repartitions the batch
+// by a given partition key at the same time. The columns of the schema are converted to their type, if
+// every value of the batch can be converted, while the type of the other columns is inferred from their
+// values, a column with different types being widened to the type which can hold every value.
+func FromJSONBy(input []byte, partitionBy string, filter *typeof.Schema, apply applyFunc) ([]Block, error) {
+	objects, err := decodeJSON(input)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := inferJSON(objects, filter)
+	result := make(map[string]column.Columns, 16)
+	for _, object := range objects {
+
+		// Get the partition value, skipping the objects without one
+		partition, ok := convertJSON(object[partitionBy], typeof.String)
+		if !ok || partition.(string) == "" {
+			continue
+		}
+
+		// Get the block for that partition
+		columns, exists := result[partition.(string)]
+		if !exists {
+			columns = column.MakeColumns(nil)
+			result[partition.(string)] = columns
+		}
+
+		// Prepare a row for transformation
+		row := acquireRow(make(typeof.Schema, len(object)))
+		for k, v := range object {
+			typ, ok := schema[k]
+			if !ok {
+				continue
+			}
+
+			if value, ok := convertJSON(v, typ); ok {
+				row.Schema[k] = typ
+				row.Values[k] = value
+			}
+		}
+
+		// Append computed columns
+		out, err := apply(row)
+		if err == ErrDropped {
+			releaseRow(row, out)
+			continue
+		}
+
+		// Append to columnar data structure and fill nulls for row
+		out.AppendTo(columns)
+		columns.FillNulls()
+		releaseRow(row, out)
+	}
+
+	// Write the columns into the block
+	return makeBlocks(result)
+}
+
+// decodeJSON decodes the objects of the input, either one after the other or in an array
+func decodeJSON(input []byte) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+
+	objects := make([]map[string]interface{}, 0, 64)
+	if trimmed := bytes.TrimSpace(input); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := decoder.Decode(&objects); err != nil {
+			return nil, errors.Internal("block: unable to decode the json array", err)
+		}
+		return objects, nil
+	}
+
+	for {
+		var object map[string]interface{}
+		switch err := decoder.Decode(&object); {
+		case err == io.EOF:
+			return objects, nil
+		case err != nil:
+			return nil, errors.Internal("block: unable to decode the json objects", err)
+		}
+		objects = append(objects, object)
+	}
+}
+
+// inferJSON returns the type of each column of the objects. The columns of the schema keep their type unless
+// one of their values can not be converted to it.
+func inferJSON(objects []map[string]interface{}, filter *typeof.Schema) typeof.Schema {
+	hints := filter.Clone()
+	inferred := make(typeof.Schema, 16)
+	invalid := make(map[string]bool, 4)
+	for _, object := range objects {
+		for k, v := range object {
+			typ, ok := typeOfJSON(v)
+			if !ok {
+				continue // Null values do not tell the type
+			}
+
+			if existing, ok := inferred[k]; ok {
+				typ = widen(existing, typ)
+			}
+			inferred[k] = typ
+
+			if known, ok := hints[k]; ok {
+				if _, ok := convertJSON(v, known); !ok {
+					invalid[k] = true
+				}
+			}
+		}
+	}
+
+	for k, typ := range hints {
+		if _, ok := inferred[k]; ok && !invalid[k] {
+			inferred[k] = typ
+		}
+	}
+	return inferred
+}
+
+// typeOfJSON returns the type of a decoded JSON value
+func typeOfJSON(v interface{}) (typeof.Type, bool) {
+	switch v := v.(type) {
+	case string:
+		return typeof.String, true
+	case bool:
+		return typeof.Bool, true
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return typeof.Int64, true
+		}
+		return typeof.Float64, true
+	case map[string]interface{}, []interface{}:
+		return typeof.JSON, true
+	default:
+		return typeof.Unsupported, false
+	}
+}
+
+// widen returns the type which can hold the values of both types: integers are widened to floats, any value
+// mixed with objects or arrays to JSON and any other mix to strings.
+func widen(a, b typeof.Type) typeof.Type {
+	switch {
+	case a == b:
+		return a
+	case a == typeof.JSON || b == typeof.JSON:
+		return typeof.JSON
+	case (a == typeof.Int64 || a == typeof.Float64) && (b == typeof.Int64 || b == typeof.Float64):
+		return typeof.Float64
+	default:
+		return typeof.String
+	}
+}
+
+// convertJSON converts a decoded JSON value to a type
+func convertJSON(v interface{}, typ typeof.Type) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+
+	switch typ {
+	case typeof.String:
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case json.Number:
+			return v.String(), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case typeof.Bool:
+		if v, ok := v.(bool); ok {
+			return v, true
+		}
+	case typeof.Int64:
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, true
+			}
+
+			// A float without a fraction, such as 2.0, is still an integer
+			if f, err := n.Float64(); err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+				return int64(f), true
+			}
+		}
+	case typeof.Int32:
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil && i >= math.MinInt32 && i <= math.MaxInt32 {
+				return int32(i), true
+			}
+		}
+	case typeof.Float64:
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, true
+			}
+		}
+	case typeof.Timestamp:
+		switch v := v.(type) {
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t, true
+			}
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return time.Unix(i, 0), true
+			}
+		}
+	case typeof.JSON:
+		if b, err := json.Marshal(v); err == nil {
+			return json.RawMessage(b), true
+		}
+	}
+	return nil, false
+}
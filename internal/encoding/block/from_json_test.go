@@ -0,0 +1,76 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package block
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromJSON(t *testing.T) {
+	input := []byte(`{"event": "click", "count": 1, "amount": 1, "flag": true, "mixed": 1, "tags": ["a"]}
+{"event": "click", "count": 2, "amount": 1.5, "mixed": "two", "tags": "b", "empty": null}
+{"event": "view", "count": 3, "amount": 2, "flag": false, "mixed": false}
+{"count": 4}
+`)
+
+	blocks, err := FromJSONBy(input, "event", nil, Transform(nil))
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+
+	// The types are widened to hold every value of the batch
+	for _, b := range blocks {
+		schema := b.Schema()
+		assert.Equal(t, typeof.Int64, schema["count"])
+		assert.Equal(t, typeof.Float64, schema["amount"])
+		assert.Equal(t, typeof.Bool, schema["flag"])
+		assert.Equal(t, typeof.String, schema["mixed"])
+		assert.NotContains(t, schema, "empty")
+	}
+
+	click := blocks[0]
+	if string(click.Key) != "click" {
+		click = blocks[1]
+	}
+
+	columns, err := click.Select(click.Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, columns["count"].Count())
+	assert.Equal(t, 1.0, columns["amount"].At(0))
+	assert.Equal(t, "1", columns["mixed"].At(0))
+	assert.Equal(t, "two", columns["mixed"].At(1))
+	assert.Equal(t, typeof.JSON, click.Schema()["tags"])
+	assert.Equal(t, `["a"]`, columns["tags"].At(0))
+	assert.Equal(t, `"b"`, columns["tags"].At(1))
+}
+
+func TestFromJSON_Schema(t *testing.T) {
+	input := []byte(`[
+		{"event": "click", "count": 1, "amount": 1, "id": 10, "n": 1},
+		{"event": "click", "count": 2.5, "amount": 2, "id": "eleven", "n": 2.0}
+	]`)
+
+	// The known types are kept, unless a value can not be converted
+	blocks, err := FromJSONBy(input, "event", &typeof.Schema{
+		"count":  typeof.Int64,
+		"amount": typeof.Float64,
+		"id":     typeof.String,
+		"n":      typeof.Int64,
+	}, Transform(nil))
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, typeof.Schema{
+		"event":  typeof.String,
+		"count":  typeof.Float64,
+		"amount": typeof.Float64,
+		"id":     typeof.String,
+		"n":      typeof.Int64,
+	}, blocks[0].Schema())
+
+	// The input must be JSON
+	_, err = FromJSONBy([]byte(`{"event": `), "event", nil, Transform(nil))
+	assert.Error(t, err)
+}
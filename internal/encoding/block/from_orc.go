@@ -69,7 +69,7 @@ func FromOrcBy(payload []byte, partitionBy string, filter *typeof.Schema, apply
 		}
 
 		// Prepare a row for transformation
-		row := NewRow(schema, len(r))
+		row := acquireRow(schema)
 		for i, v := range r {
 			columnName := cols[i]
 			columnType := schema[columnName]
@@ -85,7 +85,12 @@ func FromOrcBy(payload []byte, partitionBy string, filter *typeof.Schema, apply
 		}
 
 		// Append computed columns and fill nulls for the row
-		out, _ := apply(row)
+		out, err := apply(row)
+		defer releaseRow(row, out)
+		if err == ErrDropped {
+			return false
+		}
+
 		size += out.AppendTo(columns)
 		size += columns.FillNulls()
 		return false
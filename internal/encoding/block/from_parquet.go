@@ -60,7 +60,7 @@ func FromParquetBy(payload []byte, partitionBy string, filter *typeof.Schema, ap
 		}
 
 		// Prepare a row for transformation
-		row := NewRow(schema, len(r))
+		row := acquireRow(schema)
 		for i, v := range r {
 			columnName := cols[i]
 			columnType := schema[columnName]
@@ -76,7 +76,11 @@ func FromParquetBy(payload []byte, partitionBy string, filter *typeof.Schema, ap
 		}
 
 		// Append computed columns and fill nulls for the row
-		out, _ := apply(row)
+		out, err := apply(row)
+		defer releaseRow(row, out)
+		if err == ErrDropped {
+			return false
+		}
 
 		size += out.AppendTo(columns)
 		size += columns.FillNulls()
@@ -23,6 +23,8 @@ func FromURLBy(uri string, partitionBy string, filter *typeof.Schema, apply appl
 		handler = FromCSVBy
 	case ".parquet":
 		handler = FromParquetBy
+	case ".json", ".ndjson":
+		handler = FromJSONBy
 	default:
 		return nil, errors.Newf("block: unsupported file extension %s", filepath.Ext(uri))
 	}
@@ -0,0 +1,59 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package block
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+const maxPooledRow = 256 // The rows with more values are not kept in the pool
+
+var (
+	rowsAcquired  uint64 // The number of rows acquired from the pool
+	rowsAllocated uint64 // The number of rows allocated, since the pool was empty
+)
+
+// rows pools the values of the rows being decoded, which are copied by the transformation of the rows
+var rows = sync.Pool{
+	New: func() interface{} {
+		atomic.AddUint64(&rowsAllocated, 1)
+		return make(map[string]interface{}, 16)
+	},
+}
+
+// PoolStats returns the number of rows acquired while decoding and the number of them which had to be
+// allocated, the others being reused.
+func PoolStats() (acquired, allocated uint64) {
+	return atomic.LoadUint64(&rowsAcquired), atomic.LoadUint64(&rowsAllocated)
+}
+
+// acquireRow returns an empty row with a schema, reusing the values of a released row if possible
+func acquireRow(schema typeof.Schema) Row {
+	atomic.AddUint64(&rowsAcquired, 1)
+	if schema == nil {
+		schema = make(typeof.Schema, 16)
+	}
+
+	return Row{
+		Values: rows.Get().(map[string]interface{}),
+		Schema: schema,
+	}
+}
+
+// releaseRow returns the values of a decoded row to the pool once the transformations are applied, unless
+// the output of the transformations is the same row, which may then be retained, such as by a stream.
+func releaseRow(in, out Row) {
+	if len(in.Values) > maxPooledRow || reflect.ValueOf(in.Values).Pointer() == reflect.ValueOf(out.Values).Pointer() {
+		return
+	}
+
+	for k := range in.Values {
+		delete(in.Values, k)
+	}
+	rows.Put(in.Values)
+}
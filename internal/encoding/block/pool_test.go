@@ -0,0 +1,42 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package block
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseRow(t *testing.T) {
+	row := acquireRow(nil)
+	assert.NotNil(t, row.Schema)
+	row.Set("a", int64(1))
+
+	// The row is kept by the output, so it is not cleared
+	releaseRow(row, row)
+	assert.Equal(t, int64(1), row.Values["a"])
+
+	// The row is copied by the output, so it is cleared and can be reused
+	out := NewRow(typeof.Schema{}, 1)
+	out.Set("a", int64(1))
+	releaseRow(row, out)
+	assert.Empty(t, row.Values)
+	assert.Equal(t, int64(1), out.Values["a"])
+}
+
+func TestPoolStats(t *testing.T) {
+	o, err := ioutil.ReadFile(smallFile)
+	assert.NoError(t, err)
+
+	acquired, _ := PoolStats()
+	_, err = FromOrcBy(o, "string1", nil, Transform(nil))
+	assert.NoError(t, err)
+
+	after, allocated := PoolStats()
+	assert.True(t, after > acquired)
+	assert.True(t, allocated <= after)
+}
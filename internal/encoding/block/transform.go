@@ -23,6 +23,11 @@ func Transform(filter *typeof.Schema, computed ...column.Computed) applyFunc {
 
 		// Compute the Values
 		for _, c := range computed {
+			if m, ok := c.(column.Multi); ok {
+				transformMulti(out, r, filter, m)
+				continue
+			}
+
 			if filter != nil && !filter.Contains(c.Name(), c.Type()) {
 				continue // Skip computed Values which aren't part of the filter
 			}
@@ -42,3 +47,22 @@ func Transform(filter *typeof.Schema, computed ...column.Computed) applyFunc {
 		return out, nil
 	}
 }
+
+// transformMulti runs a script producing multiple columns in a single pass and overwrites/appends them to the set.
+func transformMulti(out Row, r Row, filter *typeof.Schema, m column.Multi) {
+	values, err := m.Values(r.Values)
+	if err != nil {
+		return
+	}
+
+	for name, typ := range m.Schema() {
+		if filter != nil && !filter.Contains(name, typ) {
+			continue // Skip computed Values which aren't part of the filter
+		}
+
+		if v, ok := values[name]; ok {
+			out.Schema[name] = typ
+			out.Values[name] = v
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kelindar/talaria/internal/column"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
+	script "github.com/kelindar/talaria/internal/scripting"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -79,3 +81,31 @@ func TestTransform_NoFilter(t *testing.T) {
 	assert.Equal(t, 4, len(out.Values))
 	assert.Equal(t, `[{"column":"a","type":"VARCHAR"},{"column":"b","type":"TIMESTAMP"},{"column":"c","type":"INTEGER"},{"column":"data","type":"JSON"}]`, out.Schema.String())
 }
+
+func TestTransform_Multi(t *testing.T) {
+	multi, err := column.NewMulti("split", typeof.Schema{
+		"first": typeof.String,
+		"rest":  typeof.String,
+	}, `
+	function main(row)
+		local first, rest = string.match(row["a"], "(%w+)%.(.+)")
+		return { first = first, rest = rest }
+	end`, script.NewLoader(nil))
+	assert.NoError(t, err)
+
+	// Create a new row
+	in := NewRow(typeof.Schema{"a": typeof.String}, 1)
+	in.Set("a", "table1.update")
+
+	// Only the columns which are part of the filter are added
+	filter := typeof.Schema{"a": typeof.String, "first": typeof.String}
+	out, err := Transform(&filter, multi)(in)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "table1.update", "first": "table1"}, out.Values)
+
+	// Without a filter, all of the columns are added
+	out, err = Transform(nil, multi)(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "update", out.Values["rest"])
+	assert.Equal(t, typeof.String, out.Schema["rest"])
+}
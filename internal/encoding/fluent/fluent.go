@@ -0,0 +1,234 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package fluent decodes the messages of the forward protocol of Fluentd and Fluent Bit, which are msgpack arrays
+// sent over TCP in either the message, forward or (compressed) packed forward mode.
+package fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// The tag of the msgpack extension holding an event time, with its seconds and nanoseconds
+const eventTimeExt = 0
+
+var errInvalidMessage = errors.New("fluent: invalid message")
+
+// handle decodes the strings as strings and the maps as maps keyed by string
+var handle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// Message represents the entries of a tag. If a chunk is set, the sender expects it to be acknowledged once the
+// entries are stored.
+type Message struct {
+	Tag     string
+	Entries []Entry
+	Chunk   string
+}
+
+// Entry represents a record and its time
+type Entry struct {
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// Decoder decodes the messages sent over a connection
+type Decoder struct {
+	decoder *codec.Decoder
+}
+
+// NewDecoder creates a new decoder of the messages read from the reader
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{decoder: codec.NewDecoder(r, handle)}
+}
+
+// Decode decodes the next message
+func (d *Decoder) Decode() (*Message, error) {
+	var raw []interface{}
+	if err := d.decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	if len(raw) < 2 {
+		return nil, errInvalidMessage
+	}
+
+	tag, ok := raw[0].(string)
+	if !ok {
+		return nil, errInvalidMessage
+	}
+
+	var err error
+	var options interface{}
+	msg := &Message{Tag: tag}
+	switch entries := raw[1].(type) {
+	case []interface{}: // Forward mode, an array of entries
+		options = at(raw, 2)
+		for _, e := range entries {
+			entry, ok := e.([]interface{})
+			if !ok || len(entry) < 2 {
+				return nil, errInvalidMessage
+			}
+
+			if err := msg.add(entry[0], entry[1]); err != nil {
+				return nil, err
+			}
+		}
+
+	case []byte, string: // Packed forward mode, a stream of entries
+		options = at(raw, 2)
+		if msg.Entries, err = unpack(entries, options); err != nil {
+			return nil, err
+		}
+
+	default: // Message mode, a single entry
+		options = at(raw, 3)
+		if err := msg.add(raw[1], at(raw, 2)); err != nil {
+			return nil, err
+		}
+	}
+
+	if options, ok := options.(map[string]interface{}); ok {
+		msg.Chunk, _ = options["chunk"].(string)
+	}
+	return msg, nil
+}
+
+// add adds an entry to the message
+func (m *Message) add(t, record interface{}) error {
+	entry, err := entryOf(t, record)
+	if err != nil {
+		return err
+	}
+
+	m.Entries = append(m.Entries, entry)
+	return nil
+}
+
+// unpack decodes the stream of entries of the packed forward mode, which may be compressed with gzip
+func unpack(packed interface{}, options interface{}) ([]Entry, error) {
+	var b []byte
+	switch v := packed.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	}
+
+	if options, ok := options.(map[string]interface{}); ok && options["compressed"] == "gzip" {
+		reader, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("fluent: unable to decompress the entries: %v", err)
+		}
+
+		if b, err = ioutil.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("fluent: unable to decompress the entries: %v", err)
+		}
+	}
+
+	var entries []Entry
+	decoder := codec.NewDecoderBytes(b, handle)
+	for {
+		var raw []interface{}
+		switch err := decoder.Decode(&raw); {
+		case err == io.EOF:
+			return entries, nil
+		case err != nil:
+			return nil, err
+		case len(raw) < 2:
+			return nil, errInvalidMessage
+		}
+
+		entry, err := entryOf(raw[0], raw[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// entryOf converts the time and the record of an entry
+func entryOf(t, record interface{}) (Entry, error) {
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return Entry{}, errInvalidMessage
+	}
+
+	at, ok := timeOf(t)
+	if !ok {
+		return Entry{}, errInvalidMessage
+	}
+	return Entry{Time: at, Record: fields}, nil
+}
+
+// timeOf converts the time of an entry, either in unix seconds or as an event time
+func timeOf(t interface{}) (time.Time, bool) {
+	switch v := t.(type) {
+	case int64:
+		return time.Unix(v, 0), true
+	case uint64:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), true
+	case codec.RawExt:
+		if v.Tag != eventTimeExt || len(v.Data) != 8 {
+			return time.Time{}, false
+		}
+
+		sec, nsec := binary.BigEndian.Uint32(v.Data[:4]), binary.BigEndian.Uint32(v.Data[4:])
+		return time.Unix(int64(sec), int64(nsec)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// at returns the element of the array at an index, or nil if the array is shorter
+func at(array []interface{}, i int) interface{} {
+	if i < len(array) {
+		return array[i]
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// Ack encodes the acknowledgement of a chunk
+func Ack(chunk string) []byte {
+	var out []byte
+	_ = codec.NewEncoderBytes(&out, handle).Encode(map[string]string{"ack": chunk})
+	return out
+}
+
+// Marshal encodes the message in the forward mode, with the times as event times
+func Marshal(m *Message) ([]byte, error) {
+	entries := make([]interface{}, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data[:4], uint32(e.Time.Unix()))
+		binary.BigEndian.PutUint32(data[4:], uint32(e.Time.Nanosecond()))
+		entries = append(entries, []interface{}{&codec.RawExt{Tag: eventTimeExt, Data: data}, e.Record})
+	}
+
+	raw := []interface{}{m.Tag, entries}
+	if m.Chunk != "" {
+		raw = append(raw, map[string]interface{}{"chunk": m.Chunk})
+	}
+
+	var out []byte
+	err := codec.NewEncoderBytes(&out, handle).Encode(raw)
+	return out, err
+}
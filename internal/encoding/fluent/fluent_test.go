@@ -0,0 +1,86 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+// encode encodes a value in msgpack
+func encode(v interface{}) []byte {
+	var out []byte
+	_ = codec.NewEncoderBytes(&out, handle).Encode(v)
+	return out
+}
+
+func TestDecode(t *testing.T) {
+	at := time.Unix(1600000000, 123456789)
+	forward, err := Marshal(&Message{
+		Tag:   "app.web",
+		Chunk: "p8n9gmxTQVC8/nh2wlKKeQ==",
+		Entries: []Entry{
+			{Time: at, Record: map[string]interface{}{"log": "GET /cart", "status": int64(200)}},
+			{Time: at, Record: map[string]interface{}{"log": "GET /", "user": map[string]interface{}{"id": "42"}}},
+		},
+	})
+	assert.NoError(t, err)
+
+	// The entries of a packed forward message are concatenated and compressed
+	var packed bytes.Buffer
+	writer := gzip.NewWriter(&packed)
+	_, _ = writer.Write(encode([]interface{}{int64(1600000000), map[string]interface{}{"log": "packed"}}))
+	_, _ = writer.Write(encode([]interface{}{int64(1600000001), map[string]interface{}{"log": "packed"}}))
+	_ = writer.Close()
+
+	var stream bytes.Buffer
+	stream.Write(forward)
+	stream.Write(encode([]interface{}{"app.db", int64(1600000000), map[string]interface{}{"log": "slow query"}}))
+	stream.Write(encode([]interface{}{"app.batch", packed.Bytes(), map[string]interface{}{"compressed": "gzip"}}))
+	stream.Write(encode([]interface{}{"app.db", "not a time", map[string]interface{}{}}))
+
+	// Forward mode
+	decoder := NewDecoder(&stream)
+	msg, err := decoder.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "app.web", msg.Tag)
+	assert.Equal(t, "p8n9gmxTQVC8/nh2wlKKeQ==", msg.Chunk)
+	assert.Len(t, msg.Entries, 2)
+	assert.True(t, at.Equal(msg.Entries[0].Time))
+	assert.Equal(t, "GET /cart", msg.Entries[0].Record["log"])
+	assert.EqualValues(t, 200, msg.Entries[0].Record["status"])
+	assert.Equal(t, map[string]interface{}{"id": "42"}, msg.Entries[1].Record["user"])
+
+	// Message mode
+	msg, err = decoder.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, &Message{Tag: "app.db", Entries: []Entry{
+		{Time: time.Unix(1600000000, 0), Record: map[string]interface{}{"log": "slow query"}},
+	}}, msg)
+
+	// Compressed packed forward mode
+	msg, err = decoder.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "app.batch", msg.Tag)
+	assert.Len(t, msg.Entries, 2)
+	assert.Equal(t, time.Unix(1600000001, 0), msg.Entries[1].Time)
+
+	_, err = decoder.Decode()
+	assert.Error(t, err)
+
+	_, err = decoder.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestAck(t *testing.T) {
+	var ack map[string]interface{}
+	assert.NoError(t, codec.NewDecoderBytes(Ack("chunk"), handle).Decode(&ack))
+	assert.Equal(t, map[string]interface{}{"ack": "chunk"}, ack)
+}
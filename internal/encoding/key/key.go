@@ -34,6 +34,15 @@ func HashOf(k Key) uint32 {
 	return binary.BigEndian.Uint32(k[0:4])
 }
 
+// RangeOf returns the first and the last key of the events of a name
+func RangeOf(eventName string) (Key, Key) {
+	seek, until := First(), Last()
+	hash := murmur3.StringSum32(eventName)
+	binary.BigEndian.PutUint32(seek[0:4], hash)
+	binary.BigEndian.PutUint32(until[0:4], hash)
+	return seek, until
+}
+
 // Clone clones a key
 func Clone(k Key) Key {
 	b := make(Key, 16)
@@ -29,3 +29,11 @@ func TestClone(t *testing.T) {
 func asKey(s string) Key {
 	return Key(s)
 }
+
+func TestRangeOf(t *testing.T) {
+	seek, until := RangeOf("a")
+	assert.Equal(t, HashOf(New("a", time.Unix(50, 0))), HashOf(seek))
+	assert.Equal(t, HashOf(seek), HashOf(until))
+	assert.True(t, string(seek) < string(New("a", time.Unix(50, 0))))
+	assert.True(t, string(until) > string(New("a", time.Unix(50, 0))))
+}
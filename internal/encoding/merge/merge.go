@@ -15,11 +15,25 @@ type Func func([]block.Block, typeof.Schema) ([]byte, error)
 
 // New creates a new merge function
 func New(mergeFunc string) (Func, error) {
+	return NewWith(mergeFunc, "")
+}
+
+// NewWith creates a new merge function which compresses the output with a codec, either "zlib" (default for
+// orc), "snappy" (default for parquet), "gzip" or "none".
+func NewWith(mergeFunc, compression string) (Func, error) {
 	switch strings.ToLower(mergeFunc) {
-	case "orc":
-		return ToOrc, nil
-	case "": // Default to "orc" so we don't break existing configs
-		return ToOrc, nil
+	case "orc", "": // Default to "orc" so we don't break existing configs
+		codec, err := orcCodecOf(compression)
+		if err != nil {
+			return nil, err
+		}
+		return toOrc(codec), nil
+	case "parquet":
+		codec, err := parquetCodecOf(compression)
+		if err != nil {
+			return nil, err
+		}
+		return toParquet(codec), nil
 	}
 
 	return nil, errors.Newf("unsupported merge function %v", mergeFunc)
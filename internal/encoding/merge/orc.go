@@ -2,6 +2,7 @@ package merge
 
 import (
 	"compress/flate"
+	"strings"
 
 	eorc "github.com/crphang/orc"
 	"github.com/kelindar/talaria/internal/column"
@@ -13,6 +14,32 @@ import (
 
 // ToOrc merges multiple blocks together and outputs a key and merged orc data
 func ToOrc(blocks []block.Block, schema typeof.Schema) ([]byte, error) {
+	return mergeOrc(blocks, schema, eorc.CompressionZlib{Level: flate.DefaultCompression})
+}
+
+// toOrc returns a merge function which outputs orc data compressed with the codec
+func toOrc(codec eorc.CompressionCodec) Func {
+	return func(blocks []block.Block, schema typeof.Schema) ([]byte, error) {
+		return mergeOrc(blocks, schema, codec)
+	}
+}
+
+// orcCodecOf returns the orc compression codec of a name
+func orcCodecOf(compression string) (eorc.CompressionCodec, error) {
+	switch strings.ToLower(compression) {
+	case "zlib", "":
+		return eorc.CompressionZlib{Level: flate.DefaultCompression}, nil
+	case "snappy":
+		return eorc.CompressionSnappy{}, nil
+	case "none":
+		return nil, nil
+	}
+
+	return nil, errors.Newf("unsupported compression %v", compression)
+}
+
+// mergeOrc merges multiple blocks together into orc data compressed with the codec
+func mergeOrc(blocks []block.Block, schema typeof.Schema, codec eorc.CompressionCodec) ([]byte, error) {
 	orcSchema, err := orc.SchemaFor(schema)
 	if err != nil {
 		return nil, errors.Internal("merge: error generating orc schema", err)
@@ -22,10 +49,16 @@ func ToOrc(blocks []block.Block, schema typeof.Schema) ([]byte, error) {
 	buffer := acquire()
 	defer release(buffer)
 
-	// Create a new writer
-	writer, err := eorc.NewWriter(buffer,
-		eorc.SetSchema(orcSchema),
-		eorc.SetCompression(eorc.CompressionZlib{Level: flate.DefaultCompression}))
+	// Create a new writer, uncompressed unless a codec is given
+	options := []eorc.WriterConfigFunc{eorc.SetSchema(orcSchema)}
+	if codec != nil {
+		options = append(options, eorc.SetCompression(codec))
+	}
+
+	writer, err := eorc.NewWriter(buffer, options...)
+	if err != nil {
+		return nil, errors.Internal("merge: error creating orc writer", err)
+	}
 
 	for _, blk := range blocks {
 		rows, err := blk.Select(blk.Schema())
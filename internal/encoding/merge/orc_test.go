@@ -132,3 +132,37 @@ func TestMerge_DifferentSchema(t *testing.T) {
 	}
 
 }
+
+func TestToOrc_Compression(t *testing.T) {
+	schema := typeof.Schema{
+		"col0": typeof.String,
+		"col1": typeof.Int64,
+		"col2": typeof.Float64,
+	}
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	input := &bytes.Buffer{}
+	writer, _ := eorc.NewWriter(input, eorc.SetSchema(orcSchema))
+	_ = writer.Write("eventName", 1, 1.0)
+	_ = writer.Close()
+
+	blocks, err := block.FromOrcBy(input.Bytes(), "col0", nil, block.Transform(nil))
+	assert.NoError(t, err)
+
+	for _, codec := range []string{"snappy", "none"} {
+		fn, err := NewWith("orc", codec)
+		assert.NoError(t, err)
+
+		merged, err := fn(blocks, schema)
+		assert.NoError(t, err)
+
+		// The merged data can be read back
+		out, err := block.FromOrcBy(merged, "col0", nil, block.Transform(nil))
+		assert.NoError(t, err)
+		assert.Len(t, out, 1)
+	}
+
+	_, err = NewWith("orc", "lz4")
+	assert.Error(t, err)
+}
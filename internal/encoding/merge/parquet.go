@@ -0,0 +1,136 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// toParquet returns a merge function which outputs parquet data compressed with the codec
+func toParquet(codec parquet.CompressionCodec) Func {
+	return func(blocks []block.Block, schema typeof.Schema) ([]byte, error) {
+		return mergeParquet(blocks, schema, codec)
+	}
+}
+
+// parquetCodecOf returns the parquet compression codec of a name
+func parquetCodecOf(compression string) (parquet.CompressionCodec, error) {
+	switch strings.ToLower(compression) {
+	case "snappy", "":
+		return parquet.CompressionCodec_SNAPPY, nil
+	case "gzip", "zlib":
+		return parquet.CompressionCodec_GZIP, nil
+	case "none":
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	}
+
+	return 0, errors.Newf("unsupported compression %v", compression)
+}
+
+// parquetSchemaOf returns the parquet schema of a schema, every column being optional
+func parquetSchemaOf(schema typeof.Schema) (*parquetschema.SchemaDefinition, error) {
+	var sb strings.Builder
+	sb.WriteString("message talaria {\n")
+	for _, name := range schema.Columns() {
+		var kind, annotation string
+		switch schema[name] {
+		case typeof.Bool:
+			kind = "boolean"
+		case typeof.Int32:
+			kind = "int32"
+		case typeof.Int64:
+			kind = "int64"
+		case typeof.Float64:
+			kind = "double"
+		case typeof.String:
+			kind, annotation = "binary", " (STRING)"
+		case typeof.Timestamp:
+			kind, annotation = "int64", " (TIMESTAMP(MILLIS, true))"
+		case typeof.JSON:
+			kind, annotation = "binary", " (JSON)"
+		default:
+			return nil, fmt.Errorf("unsupported type %v of column %s", schema[name], name)
+		}
+		fmt.Fprintf(&sb, "  optional %s %s%s;\n", kind, name, annotation)
+	}
+	sb.WriteString("}")
+	return parquetschema.ParseSchemaDefinition(sb.String())
+}
+
+// mergeParquet merges multiple blocks together into parquet data compressed with the codec
+func mergeParquet(blocks []block.Block, schema typeof.Schema, codec parquet.CompressionCodec) ([]byte, error) {
+	parquetSchema, err := parquetSchemaOf(schema)
+	if err != nil {
+		return nil, errors.Internal("merge: error generating parquet schema", err)
+	}
+
+	// Acquire a buffer to be used during the merging process
+	buffer := acquire()
+	defer release(buffer)
+
+	writer := goparquet.NewFileWriter(buffer,
+		goparquet.WithSchemaDefinition(parquetSchema),
+		goparquet.WithCompressionCodec(codec),
+		goparquet.WithCreator("talaria"),
+	)
+
+	for _, blk := range blocks {
+		columns, err := blk.Select(blk.Schema())
+		if err != nil || len(columns) == 0 {
+			continue
+		}
+
+		// Only write the columns of the schema, the nulls and the mismatched types being left out of the row
+		rows := make([]map[string]interface{}, columns.Any().Count())
+		for i := range rows {
+			rows[i] = make(map[string]interface{}, len(schema))
+		}
+
+		for name, col := range columns {
+			if typ, ok := schema[name]; !ok || col.Kind() != typ {
+				continue
+			}
+
+			_ = col.Range(0, col.Count(), func(i int, v interface{}) error {
+				if v = parquetValueOf(v); v != nil {
+					rows[i][name] = v
+				}
+				return nil
+			})
+		}
+
+		for _, row := range rows {
+			if err := writer.AddData(row); err != nil {
+				return nil, errors.Internal("merge: error writing parquet row", err)
+			}
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, errors.Internal("merge: error closing parquet writer", err)
+	}
+
+	// Always return a cloned buffer since we're reusing the working one
+	return clone(buffer), nil
+}
+
+// parquetValueOf converts a value of a column to the value of its parquet type
+func parquetValueOf(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		return []byte(value)
+	case time.Time:
+		return value.UnixNano() / int64(time.Millisecond)
+	}
+	return v
+}
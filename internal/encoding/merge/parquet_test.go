@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package merge
+
+import (
+	"bytes"
+	"testing"
+
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToParquet(t *testing.T) {
+	blocks, err := block.FromJSONBy([]byte(`[
+		{"event": "click", "time": 1600000000, "value": 1.5},
+		{"event": "click", "time": 1600000100},
+		{"event": "view", "time": 1600000200, "value": 3.5}
+	]`), "event", nil, block.Transform(nil))
+	assert.NoError(t, err)
+
+	schema := typeof.Schema{
+		"event": typeof.String,
+		"time":  typeof.Int64,
+		"value": typeof.Float64,
+	}
+
+	merge, err := NewWith("parquet", "snappy")
+	assert.NoError(t, err)
+	output, err := merge(blocks, schema)
+	assert.NoError(t, err)
+
+	// Read the rows back, the nulls being left out
+	reader, err := goparquet.NewFileReader(bytes.NewReader(output))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), reader.NumRows())
+
+	var rows []map[string]interface{}
+	for {
+		row, err := reader.NextRow()
+		if err != nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	assert.Len(t, rows, 3)
+	assert.Contains(t, rows, map[string]interface{}{"event": []byte("view"), "time": int64(1600000200), "value": 3.5})
+	assert.Contains(t, rows, map[string]interface{}{"event": []byte("click"), "time": int64(1600000100)})
+
+	_, err = NewWith("parquet", "xxx")
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"runtime"
 
 	"github.com/crphang/orc"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
@@ -14,6 +15,9 @@ import (
 
 var errNoWriter = errors.New("unable to create an orc writer")
 
+// workers is the number of stripes decoded concurrently
+var workers = runtime.NumCPU()
+
 // Iterator represents orc data frame.
 type Iterator interface {
 	io.Closer
@@ -28,7 +32,7 @@ func FromFile(filename string) (Iterator, error) {
 		return nil, err
 	}
 
-	return &iterator{reader: r}, nil
+	return &iterator{reader: r, workers: workers}, nil
 }
 
 // FromBuffer creates an iterator from a buffer.
@@ -38,7 +42,7 @@ func FromBuffer(b []byte) (Iterator, error) {
 		return nil, err
 	}
 
-	return &iterator{reader: r}, nil
+	return &iterator{reader: r, workers: workers}, nil
 }
 
 // Range is a helper function that ranges over a set of columns in an orc buffer
@@ -63,11 +67,79 @@ func First(payload []byte, columns ...string) (result []interface{}, err error)
 
 // Iterator represents orc data frame.
 type iterator struct {
-	reader *orc.Reader
+	reader  *orc.Reader
+	workers int // The number of stripes decoded concurrently
+}
+
+// stripe represents the rows decoded from a stripe
+type stripe struct {
+	rows [][]interface{}
+	err  error
 }
 
-// Range iterates through the reader.
+// Range iterates through the reader. When the file has several stripes, they are decoded concurrently by
+// a bounded number of workers, while the rows are still handed to the function one at a time and in order.
 func (i *iterator) Range(f func(int, []interface{}) bool, columns ...string) (index int, stop bool) {
+	count, err := i.reader.NumStripes()
+	if err != nil || count <= 1 || i.workers <= 1 {
+		return i.rangeSequential(f, columns...)
+	}
+
+	// Start decoding the stripes, with a limit on the stripes decoded but not iterated through yet
+	done, limit := make(chan struct{}), make(chan struct{}, i.workers)
+	defer close(done)
+	stripes := make([]chan stripe, count)
+	for n := range stripes {
+		stripes[n] = make(chan stripe, 1)
+	}
+
+	go func() {
+		for n := range stripes {
+			select {
+			case <-done:
+				return
+			case limit <- struct{}{}:
+				go func(n int) {
+					stripes[n] <- i.decode(n, columns...)
+				}(n)
+			}
+		}
+	}()
+
+	// Iterate through the stripes in order, stopping at the first one which can not be decoded
+	for n := range stripes {
+		s := <-stripes[n]
+		if s.err != nil {
+			return index, true
+		}
+
+		for _, row := range s.rows {
+			index++
+			if stop = f(index-1, row); stop {
+				return index, false
+			}
+		}
+		<-limit
+	}
+	return index, true
+}
+
+// decode decodes the rows of a stripe
+func (i *iterator) decode(n int, columns ...string) stripe {
+	c := i.reader.Select(columns...)
+	if err := c.SelectStripe(n); err != nil {
+		return stripe{err: err}
+	}
+
+	rows := make([][]interface{}, 0, c.GetNumberOfRows())
+	for c.Next() {
+		rows = append(rows, c.Row())
+	}
+	return stripe{rows: rows}
+}
+
+// rangeSequential iterates through the stripes of the reader one after the other.
+func (i *iterator) rangeSequential(f func(int, []interface{}) bool, columns ...string) (index int, stop bool) {
 	c := i.reader.Select(columns...)
 	for c.Stripes() {
 		for c.Next() {
@@ -59,3 +59,36 @@ func TestRange(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, count)
 }
+
+func TestRange_Stripes(t *testing.T) {
+	b, err := ioutil.ReadFile("../../../test/test3.orc")
+	assert.NoError(t, err)
+
+	// Decode the stripes one after the other, then concurrently
+	var expected, actual [][]interface{}
+	for _, n := range []int{1, 4} {
+		i, err := FromBuffer(b)
+		assert.NoError(t, err)
+		i.(*iterator).workers = n
+
+		var rows [][]interface{}
+		count, _ := i.Range(func(_ int, v []interface{}) bool {
+			rows = append(rows, v)
+			return false
+		}, i.Schema().Columns()...)
+		assert.Equal(t, 50000, count)
+
+		expected, actual = actual, rows
+	}
+
+	// The rows are in the same order
+	assert.Equal(t, expected, actual)
+
+	// The iteration can be stopped half way through
+	i, err := FromBuffer(b)
+	assert.NoError(t, err)
+	count, _ := i.Range(func(index int, _ []interface{}) bool {
+		return index == 30000
+	}, i.Schema().Columns()...)
+	assert.Equal(t, 30001, count)
+}
@@ -0,0 +1,654 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package otlp encodes and decodes the export requests of the OpenTelemetry protocol for the spans and the log
+// records, which are sent as protobuf over gRPC or HTTP. Only the fields stored by Talaria are kept.
+package otlp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	wire "github.com/kelindar/talaria/internal/encoding/protowire"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The services of the OpenTelemetry collector
+const (
+	TraceService = "opentelemetry.proto.collector.trace.v1.TraceService"
+	LogsService  = "opentelemetry.proto.collector.logs.v1.LogsService"
+)
+
+// TracesRequest represents the spans exported by a client, grouped by resource and instrumentation scope
+type TracesRequest struct {
+	ResourceSpans []ResourceSpans
+}
+
+// ResourceSpans represents the spans of a resource, such as a service
+type ResourceSpans struct {
+	Resource   Resource
+	ScopeSpans []ScopeSpans
+}
+
+// ScopeSpans represents the spans recorded by an instrumentation scope
+type ScopeSpans struct {
+	Scope Scope
+	Spans []Span
+}
+
+// Span represents an operation of a trace
+type Span struct {
+	TraceID           []byte
+	SpanID            []byte
+	ParentSpanID      []byte
+	Name              string
+	Kind              int32
+	StartTimeUnixNano uint64
+	EndTimeUnixNano   uint64
+	Attributes        []KeyValue
+	Status            Status
+}
+
+// Status represents the status of a span
+type Status struct {
+	Message string
+	Code    int32
+}
+
+// LogsRequest represents the log records exported by a client, grouped by resource and instrumentation scope
+type LogsRequest struct {
+	ResourceLogs []ResourceLogs
+}
+
+// ResourceLogs represents the log records of a resource, such as a service
+type ResourceLogs struct {
+	Resource  Resource
+	ScopeLogs []ScopeLogs
+}
+
+// ScopeLogs represents the log records emitted by an instrumentation scope
+type ScopeLogs struct {
+	Scope      Scope
+	LogRecords []LogRecord
+}
+
+// LogRecord represents a log record, optionally within a span
+type LogRecord struct {
+	TimeUnixNano         uint64
+	ObservedTimeUnixNano uint64
+	SeverityNumber       int32
+	SeverityText         string
+	Body                 interface{}
+	Attributes           []KeyValue
+	TraceID              []byte
+	SpanID               []byte
+}
+
+// Resource represents the entity producing the telemetry, described by its attributes
+type Resource struct {
+	Attributes []KeyValue
+}
+
+// Scope represents the library which recorded the telemetry
+type Scope struct {
+	Name    string
+	Version string
+}
+
+// KeyValue represents an attribute. The value is a string, a bool, an int64, a float64, a []byte, an
+// []interface{} or a map[string]interface{} of these, or nil.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// The names of the kinds of the spans, by number
+var kinds = []string{"unspecified", "internal", "server", "client", "producer", "consumer"}
+
+// The names of the status codes of the spans, by number
+var statuses = []string{"unset", "ok", "error"}
+
+// KindName returns the name of the kind of the span
+func (s *Span) KindName() string {
+	if s.Kind >= 0 && int(s.Kind) < len(kinds) {
+		return kinds[s.Kind]
+	}
+	return kinds[0]
+}
+
+// StatusName returns the name of the status code of the span
+func (s *Span) StatusName() string {
+	if s.Status.Code >= 0 && int(s.Status.Code) < len(statuses) {
+		return statuses[s.Status.Code]
+	}
+	return statuses[0]
+}
+
+// Map returns the attributes as a map of plain values, so they can be encoded in JSON.
+func Map(attributes []KeyValue) map[string]interface{} {
+	out := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		out[kv.Key] = Plain(kv.Value)
+	}
+	return out
+}
+
+// Plain converts the bytes of a value to hexadecimal and drops the numbers which are not finite, recursively, so
+// the value can be encoded in JSON.
+func Plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return hex.EncodeToString(v)
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			out = append(out, Plain(item))
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = Plain(item)
+		}
+		return out
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil // Not representable in JSON
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// Reset resets the request, as required by gRPC
+func (r *TracesRequest) Reset() { *r = TracesRequest{} }
+
+// String returns a description of the request, as required by gRPC
+func (r *TracesRequest) String() string {
+	return fmt.Sprintf("%d resource spans", len(r.ResourceSpans))
+}
+
+// ProtoMessage marks the request as a protobuf message, as required by gRPC
+func (*TracesRequest) ProtoMessage() {}
+
+// Unmarshal decodes the request from its protobuf encoding, skipping the fields it does not know
+func (r *TracesRequest) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var rs ResourceSpans
+		if err := rs.unmarshal(v); err != nil {
+			return err
+		}
+		r.ResourceSpans = append(r.ResourceSpans, rs)
+		return nil
+	})
+}
+
+// Marshal encodes the request to protobuf
+func (r *TracesRequest) Marshal() ([]byte, error) {
+	var out []byte
+	for i := range r.ResourceSpans {
+		out = appendMessage(out, 1, r.ResourceSpans[i].marshal())
+	}
+	return out, nil
+}
+
+// Reset resets the request, as required by gRPC
+func (r *LogsRequest) Reset() { *r = LogsRequest{} }
+
+// String returns a description of the request, as required by gRPC
+func (r *LogsRequest) String() string { return fmt.Sprintf("%d resource logs", len(r.ResourceLogs)) }
+
+// ProtoMessage marks the request as a protobuf message, as required by gRPC
+func (*LogsRequest) ProtoMessage() {}
+
+// Unmarshal decodes the request from its protobuf encoding, skipping the fields it does not know
+func (r *LogsRequest) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var rl ResourceLogs
+		if err := rl.unmarshal(v); err != nil {
+			return err
+		}
+		r.ResourceLogs = append(r.ResourceLogs, rl)
+		return nil
+	})
+}
+
+// Marshal encodes the request to protobuf
+func (r *LogsRequest) Marshal() ([]byte, error) {
+	var out []byte
+	for i := range r.ResourceLogs {
+		out = appendMessage(out, 1, r.ResourceLogs[i].marshal())
+	}
+	return out, nil
+}
+
+// Response represents the response to an export request, which is empty when every item was accepted
+type Response struct{}
+
+// Reset resets the response, as required by gRPC
+func (r *Response) Reset() {}
+
+// String returns a description of the response, as required by gRPC
+func (r *Response) String() string { return "" }
+
+// ProtoMessage marks the response as a protobuf message, as required by gRPC
+func (*Response) ProtoMessage() {}
+
+// Unmarshal decodes the response from its protobuf encoding, skipping the partial success
+func (r *Response) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(protowire.Number, protowire.Type, []byte) error { return nil })
+}
+
+// Marshal encodes the response to protobuf
+func (r *Response) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// unmarshal decodes the spans of the resource from their protobuf encoding
+func (r *ResourceSpans) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return r.Resource.unmarshal(v)
+		case num == 2 && typ == protowire.BytesType:
+			var ss ScopeSpans
+			if err := ss.unmarshal(v); err != nil {
+				return err
+			}
+			r.ScopeSpans = append(r.ScopeSpans, ss)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the spans of the resource to protobuf
+func (r *ResourceSpans) marshal() []byte {
+	out := appendMessage(nil, 1, r.Resource.marshal())
+	for i := range r.ScopeSpans {
+		out = appendMessage(out, 2, r.ScopeSpans[i].marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the spans of the scope from their protobuf encoding
+func (s *ScopeSpans) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return s.Scope.unmarshal(v)
+		case num == 2 && typ == protowire.BytesType:
+			var span Span
+			if err := span.unmarshal(v); err != nil {
+				return err
+			}
+			s.Spans = append(s.Spans, span)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the spans of the scope to protobuf
+func (s *ScopeSpans) marshal() []byte {
+	out := appendMessage(nil, 1, s.Scope.marshal())
+	for i := range s.Spans {
+		out = appendMessage(out, 2, s.Spans[i].marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the span from its protobuf encoding
+func (s *Span) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s.TraceID = append([]byte(nil), v...)
+		case num == 2 && typ == protowire.BytesType:
+			s.SpanID = append([]byte(nil), v...)
+		case num == 4 && typ == protowire.BytesType:
+			s.ParentSpanID = append([]byte(nil), v...)
+		case num == 5 && typ == protowire.BytesType:
+			s.Name = string(v)
+		case num == 6 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			s.Kind = int32(n)
+		case num == 7 && typ == protowire.Fixed64Type:
+			s.StartTimeUnixNano, _ = protowire.ConsumeFixed64(v)
+		case num == 8 && typ == protowire.Fixed64Type:
+			s.EndTimeUnixNano, _ = protowire.ConsumeFixed64(v)
+		case num == 9 && typ == protowire.BytesType:
+			var kv KeyValue
+			if err := kv.unmarshal(v); err != nil {
+				return err
+			}
+			s.Attributes = append(s.Attributes, kv)
+		case num == 15 && typ == protowire.BytesType:
+			return s.Status.unmarshal(v)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the span to protobuf
+func (s *Span) marshal() []byte {
+	out := appendBytes(nil, 1, s.TraceID)
+	out = appendBytes(out, 2, s.SpanID)
+	out = appendBytes(out, 4, s.ParentSpanID)
+	out = appendBytes(out, 5, []byte(s.Name))
+	out = protowire.AppendTag(out, 6, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(s.Kind))
+	out = protowire.AppendTag(out, 7, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, s.StartTimeUnixNano)
+	out = protowire.AppendTag(out, 8, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, s.EndTimeUnixNano)
+	for i := range s.Attributes {
+		out = appendMessage(out, 9, s.Attributes[i].marshal())
+	}
+	return appendMessage(out, 15, s.Status.marshal())
+}
+
+// unmarshal decodes the status from its protobuf encoding
+func (s *Status) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 2 && typ == protowire.BytesType:
+			s.Message = string(v)
+		case num == 3 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			s.Code = int32(n)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the status to protobuf
+func (s *Status) marshal() []byte {
+	out := appendBytes(nil, 2, []byte(s.Message))
+	out = protowire.AppendTag(out, 3, protowire.VarintType)
+	return protowire.AppendVarint(out, uint64(s.Code))
+}
+
+// unmarshal decodes the log records of the resource from their protobuf encoding
+func (r *ResourceLogs) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return r.Resource.unmarshal(v)
+		case num == 2 && typ == protowire.BytesType:
+			var sl ScopeLogs
+			if err := sl.unmarshal(v); err != nil {
+				return err
+			}
+			r.ScopeLogs = append(r.ScopeLogs, sl)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the log records of the resource to protobuf
+func (r *ResourceLogs) marshal() []byte {
+	out := appendMessage(nil, 1, r.Resource.marshal())
+	for i := range r.ScopeLogs {
+		out = appendMessage(out, 2, r.ScopeLogs[i].marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the log records of the scope from their protobuf encoding
+func (s *ScopeLogs) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return s.Scope.unmarshal(v)
+		case num == 2 && typ == protowire.BytesType:
+			var record LogRecord
+			if err := record.unmarshal(v); err != nil {
+				return err
+			}
+			s.LogRecords = append(s.LogRecords, record)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the log records of the scope to protobuf
+func (s *ScopeLogs) marshal() []byte {
+	out := appendMessage(nil, 1, s.Scope.marshal())
+	for i := range s.LogRecords {
+		out = appendMessage(out, 2, s.LogRecords[i].marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the log record from its protobuf encoding
+func (l *LogRecord) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			l.TimeUnixNano, _ = protowire.ConsumeFixed64(v)
+		case num == 11 && typ == protowire.Fixed64Type:
+			l.ObservedTimeUnixNano, _ = protowire.ConsumeFixed64(v)
+		case num == 2 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			l.SeverityNumber = int32(n)
+		case num == 3 && typ == protowire.BytesType:
+			l.SeverityText = string(v)
+		case num == 5 && typ == protowire.BytesType:
+			body, err := unmarshalValue(v)
+			if err != nil {
+				return err
+			}
+			l.Body = body
+		case num == 6 && typ == protowire.BytesType:
+			var kv KeyValue
+			if err := kv.unmarshal(v); err != nil {
+				return err
+			}
+			l.Attributes = append(l.Attributes, kv)
+		case num == 9 && typ == protowire.BytesType:
+			l.TraceID = append([]byte(nil), v...)
+		case num == 10 && typ == protowire.BytesType:
+			l.SpanID = append([]byte(nil), v...)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the log record to protobuf
+func (l *LogRecord) marshal() []byte {
+	out := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, l.TimeUnixNano)
+	out = protowire.AppendTag(out, 2, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(l.SeverityNumber))
+	out = appendBytes(out, 3, []byte(l.SeverityText))
+	if l.Body != nil {
+		out = appendMessage(out, 5, marshalValue(l.Body))
+	}
+	for i := range l.Attributes {
+		out = appendMessage(out, 6, l.Attributes[i].marshal())
+	}
+	out = appendBytes(out, 9, l.TraceID)
+	out = appendBytes(out, 10, l.SpanID)
+	out = protowire.AppendTag(out, 11, protowire.Fixed64Type)
+	return protowire.AppendFixed64(out, l.ObservedTimeUnixNano)
+}
+
+// unmarshal decodes the resource from its protobuf encoding
+func (r *Resource) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var kv KeyValue
+		if err := kv.unmarshal(v); err != nil {
+			return err
+		}
+		r.Attributes = append(r.Attributes, kv)
+		return nil
+	})
+}
+
+// marshal encodes the resource to protobuf
+func (r *Resource) marshal() []byte {
+	var out []byte
+	for i := range r.Attributes {
+		out = appendMessage(out, 1, r.Attributes[i].marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the scope from its protobuf encoding
+func (s *Scope) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s.Name = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			s.Version = string(v)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the scope to protobuf
+func (s *Scope) marshal() []byte {
+	out := appendBytes(nil, 1, []byte(s.Name))
+	return appendBytes(out, 2, []byte(s.Version))
+}
+
+// unmarshal decodes the attribute from its protobuf encoding
+func (kv *KeyValue) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			kv.Key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			value, err := unmarshalValue(v)
+			if err != nil {
+				return err
+			}
+			kv.Value = value
+		}
+		return nil
+	})
+}
+
+// marshal encodes the attribute to protobuf
+func (kv *KeyValue) marshal() []byte {
+	out := appendBytes(nil, 1, []byte(kv.Key))
+	return appendMessage(out, 2, marshalValue(kv.Value))
+}
+
+// unmarshalValue decodes a value from the protobuf encoding of an AnyValue
+func unmarshalValue(b []byte) (out interface{}, err error) {
+	err = wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			out = string(v)
+		case num == 2 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			out = n != 0
+		case num == 3 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			out = int64(n)
+		case num == 4 && typ == protowire.Fixed64Type:
+			n, _ := protowire.ConsumeFixed64(v)
+			out = math.Float64frombits(n)
+		case num == 5 && typ == protowire.BytesType:
+			values := []interface{}{}
+			if err := wire.Decode(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+
+				value, err := unmarshalValue(v)
+				values = append(values, value)
+				return err
+			}); err != nil {
+				return err
+			}
+			out = values
+		case num == 6 && typ == protowire.BytesType:
+			values := map[string]interface{}{}
+			if err := wire.Decode(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+
+				var kv KeyValue
+				err := kv.unmarshal(v)
+				values[kv.Key] = kv.Value
+				return err
+			}); err != nil {
+				return err
+			}
+			out = values
+		case num == 7 && typ == protowire.BytesType:
+			out = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return
+}
+
+// marshalValue encodes a value to the protobuf encoding of an AnyValue
+func marshalValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendMessage(nil, 1, []byte(v))
+	case bool:
+		out := protowire.AppendTag(nil, 2, protowire.VarintType)
+		return protowire.AppendVarint(out, protowire.EncodeBool(v))
+	case int64:
+		out := protowire.AppendTag(nil, 3, protowire.VarintType)
+		return protowire.AppendVarint(out, uint64(v))
+	case float64:
+		out := protowire.AppendTag(nil, 4, protowire.Fixed64Type)
+		return protowire.AppendFixed64(out, math.Float64bits(v))
+	case []interface{}:
+		var values []byte
+		for _, item := range v {
+			values = appendMessage(values, 1, marshalValue(item))
+		}
+		return appendMessage(nil, 5, values)
+	case map[string]interface{}:
+		var values []byte
+		for key, item := range v {
+			kv := KeyValue{Key: key, Value: item}
+			values = appendMessage(values, 1, kv.marshal())
+		}
+		return appendMessage(nil, 6, values)
+	case []byte:
+		return appendMessage(nil, 7, v)
+	default:
+		return nil
+	}
+}
+
+// appendBytes appends a field of bytes, unless they are empty
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return appendMessage(b, num, v)
+}
+
+// appendMessage appends a field holding an encoded message
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
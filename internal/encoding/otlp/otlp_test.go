@@ -0,0 +1,110 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package otlp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestTracesRequest(t *testing.T) {
+	input := TracesRequest{ResourceSpans: []ResourceSpans{{
+		Resource: Resource{Attributes: []KeyValue{{Key: "service.name", Value: "checkout"}}},
+		ScopeSpans: []ScopeSpans{{
+			Scope: Scope{Name: "net/http", Version: "1.0"},
+			Spans: []Span{{
+				TraceID:           []byte{1, 2, 3, 4},
+				SpanID:            []byte{5, 6},
+				ParentSpanID:      []byte{7, 8},
+				Name:              "GET /cart",
+				Kind:              2,
+				StartTimeUnixNano: 1600000000000000000,
+				EndTimeUnixNano:   1600000000500000000,
+				Attributes: []KeyValue{
+					{Key: "http.status_code", Value: int64(200)},
+					{Key: "retry", Value: true},
+					{Key: "ratio", Value: 0.5},
+					{Key: "tags", Value: []interface{}{"a", int64(1)}},
+					{Key: "user", Value: map[string]interface{}{"id": "42"}},
+					{Key: "digest", Value: []byte{0xca, 0xfe}},
+				},
+				Status: Status{Code: 2, Message: "timeout"},
+			}},
+		}},
+	}}}
+
+	encoded, err := input.Marshal()
+	assert.NoError(t, err)
+
+	// The fields which are not stored, such as the events of the spans, are skipped
+	encoded = protowire.AppendTag(encoded, 1000, protowire.BytesType)
+	encoded = protowire.AppendBytes(encoded, []byte("deprecated"))
+
+	var output TracesRequest
+	assert.NoError(t, output.Unmarshal(encoded))
+	assert.Equal(t, input, output)
+
+	span := output.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "server", span.KindName())
+	assert.Equal(t, "error", span.StatusName())
+	assert.Equal(t, map[string]interface{}{
+		"http.status_code": int64(200),
+		"retry":            true,
+		"ratio":            0.5,
+		"tags":             []interface{}{"a", int64(1)},
+		"user":             map[string]interface{}{"id": "42"},
+		"digest":           "cafe",
+	}, Map(span.Attributes))
+
+	assert.Error(t, new(TracesRequest).Unmarshal([]byte{0x0a, 0xff}))
+}
+
+func TestLogsRequest(t *testing.T) {
+	input := LogsRequest{ResourceLogs: []ResourceLogs{{
+		Resource: Resource{Attributes: []KeyValue{{Key: "service.name", Value: "checkout"}}},
+		ScopeLogs: []ScopeLogs{{
+			LogRecords: []LogRecord{{
+				TimeUnixNano:         1600000000000000000,
+				ObservedTimeUnixNano: 1600000000100000000,
+				SeverityNumber:       17,
+				SeverityText:         "ERROR",
+				Body:                 "payment declined",
+				Attributes:           []KeyValue{{Key: "order", Value: int64(42)}},
+				TraceID:              []byte{1, 2, 3, 4},
+				SpanID:               []byte{5, 6},
+			}},
+		}},
+	}}}
+
+	encoded, err := input.Marshal()
+	assert.NoError(t, err)
+
+	var output LogsRequest
+	assert.NoError(t, output.Unmarshal(encoded))
+	assert.Equal(t, input, output)
+}
+
+func TestCodec(t *testing.T) {
+	input := TracesRequest{ResourceSpans: []ResourceSpans{{
+		ScopeSpans: []ScopeSpans{{Spans: []Span{{Name: "GET /cart", TraceID: []byte{1}}}}},
+	}}}
+
+	// The messages can be sent and received by gRPC with its protobuf codec
+	codec := encoding.GetCodec("proto")
+	encoded, err := codec.Marshal(&input)
+	assert.NoError(t, err)
+
+	var output TracesRequest
+	assert.NoError(t, codec.Unmarshal(encoded, &output))
+	assert.Equal(t, "GET /cart", output.ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+
+	encoded, err = codec.Marshal(new(Response))
+	assert.NoError(t, err)
+	assert.Empty(t, encoded)
+	assert.NoError(t, codec.Unmarshal(encoded, new(Response)))
+}
@@ -106,7 +106,7 @@ func (i *iterator) Range(f func(int, []interface{}) bool, columns ...string) (in
 
 // Schema gets the SQL schema for the iterator.
 func (i *iterator) Schema() typeof.Schema {
-	schema := i.reader.SchemaReader
+	schema := i.reader
 	result := make(typeof.Schema, len(schema.Columns()))
 	for _, c := range schema.Columns() {
 		t := c.Type()
@@ -0,0 +1,328 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package prompb encodes and decodes the messages of the remote storage protocols of Prometheus, which are
+// protobuf messages compressed with snappy.
+package prompb
+
+import (
+	"math"
+	"sort"
+
+	wire "github.com/kelindar/talaria/internal/encoding/protowire"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MetricName is the name of the label holding the name of the metric
+const MetricName = "__name__"
+
+// WriteRequest represents the samples sent by Prometheus with the remote-write protocol
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// TimeSeries represents the samples of a series, identified by its labels
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label represents a label of a series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample represents a value of a series at a time, in unix milliseconds
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// The types of the label matchers
+const (
+	MatchEqual     = 0
+	MatchNotEqual  = 1
+	MatchRegexp    = 2
+	MatchNotRegexp = 3
+)
+
+// ReadRequest represents the queries sent by Prometheus with the remote-read protocol
+type ReadRequest struct {
+	Queries []Query
+}
+
+// Query represents the selection of the series matching every matcher, between two times in unix milliseconds
+type Query struct {
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []LabelMatcher
+}
+
+// LabelMatcher represents a condition on the value of a label
+type LabelMatcher struct {
+	Type  int
+	Name  string
+	Value string
+}
+
+// ReadResponse represents the result of each query of a remote-read request, in the same order
+type ReadResponse struct {
+	Results []QueryResult
+}
+
+// QueryResult represents the series selected by a query
+type QueryResult struct {
+	Timeseries []TimeSeries
+}
+
+// Name returns the name of the metric of the series
+func (t *TimeSeries) Name() string {
+	for _, l := range t.Labels {
+		if l.Name == MetricName {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// SortLabels sorts the labels of the series by name, as Prometheus expects them
+func (t *TimeSeries) SortLabels() {
+	sort.Slice(t.Labels, func(i, j int) bool {
+		return t.Labels[i].Name < t.Labels[j].Name
+	})
+}
+
+// Unmarshal decodes the request from its protobuf encoding, skipping the fields it does not know
+func (r *WriteRequest) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var ts TimeSeries
+		if err := ts.unmarshal(v); err != nil {
+			return err
+		}
+		r.Timeseries = append(r.Timeseries, ts)
+		return nil
+	})
+}
+
+// Marshal encodes the request to protobuf
+func (r *WriteRequest) Marshal() []byte {
+	var out []byte
+	for i := range r.Timeseries {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, r.Timeseries[i].marshal())
+	}
+	return out
+}
+
+// Unmarshal decodes the request from its protobuf encoding, skipping the fields it does not know
+func (r *ReadRequest) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var q Query
+		if err := q.unmarshal(v); err != nil {
+			return err
+		}
+		r.Queries = append(r.Queries, q)
+		return nil
+	})
+}
+
+// Marshal encodes the request to protobuf
+func (r *ReadRequest) Marshal() []byte {
+	var out []byte
+	for i := range r.Queries {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, r.Queries[i].marshal())
+	}
+	return out
+}
+
+// Unmarshal decodes the response from its protobuf encoding, skipping the fields it does not know
+func (r *ReadResponse) Unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		var result QueryResult
+		if err := wire.Decode(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			if num != 1 || typ != protowire.BytesType {
+				return nil
+			}
+
+			var ts TimeSeries
+			if err := ts.unmarshal(v); err != nil {
+				return err
+			}
+			result.Timeseries = append(result.Timeseries, ts)
+			return nil
+		}); err != nil {
+			return err
+		}
+		r.Results = append(r.Results, result)
+		return nil
+	})
+}
+
+// Marshal encodes the response to protobuf
+func (r *ReadResponse) Marshal() []byte {
+	var out []byte
+	for _, result := range r.Results {
+		var encoded []byte
+		for i := range result.Timeseries {
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, result.Timeseries[i].marshal())
+		}
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, encoded)
+	}
+	return out
+}
+
+// unmarshal decodes the query from its protobuf encoding
+func (q *Query) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			q.StartTimestampMs = int64(n)
+		case num == 2 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			q.EndTimestampMs = int64(n)
+		case num == 3 && typ == protowire.BytesType:
+			var m LabelMatcher
+			if err := m.unmarshal(v); err != nil {
+				return err
+			}
+			q.Matchers = append(q.Matchers, m)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the query to protobuf
+func (q *Query) marshal() []byte {
+	out := protowire.AppendTag(nil, 1, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(q.StartTimestampMs))
+	out = protowire.AppendTag(out, 2, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(q.EndTimestampMs))
+	for _, m := range q.Matchers {
+		out = protowire.AppendTag(out, 3, protowire.BytesType)
+		out = protowire.AppendBytes(out, m.marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the matcher from its protobuf encoding
+func (m *LabelMatcher) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			m.Type = int(n)
+		case num == 2 && typ == protowire.BytesType:
+			m.Name = string(v)
+		case num == 3 && typ == protowire.BytesType:
+			m.Value = string(v)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the matcher to protobuf
+func (m *LabelMatcher) marshal() []byte {
+	out := protowire.AppendTag(nil, 1, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(m.Type))
+	out = protowire.AppendTag(out, 2, protowire.BytesType)
+	out = protowire.AppendString(out, m.Name)
+	out = protowire.AppendTag(out, 3, protowire.BytesType)
+	return protowire.AppendString(out, m.Value)
+}
+
+// unmarshal decodes the series from its protobuf encoding
+func (t *TimeSeries) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var l Label
+			if err := l.unmarshal(v); err != nil {
+				return err
+			}
+			t.Labels = append(t.Labels, l)
+		case num == 2 && typ == protowire.BytesType:
+			var s Sample
+			if err := s.unmarshal(v); err != nil {
+				return err
+			}
+			t.Samples = append(t.Samples, s)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the series to protobuf
+func (t *TimeSeries) marshal() []byte {
+	var out []byte
+	for _, l := range t.Labels {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, l.marshal())
+	}
+	for _, s := range t.Samples {
+		out = protowire.AppendTag(out, 2, protowire.BytesType)
+		out = protowire.AppendBytes(out, s.marshal())
+	}
+	return out
+}
+
+// unmarshal decodes the label from its protobuf encoding
+func (l *Label) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			l.Name = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			l.Value = string(v)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the label to protobuf
+func (l *Label) marshal() []byte {
+	out := protowire.AppendTag(nil, 1, protowire.BytesType)
+	out = protowire.AppendString(out, l.Name)
+	out = protowire.AppendTag(out, 2, protowire.BytesType)
+	return protowire.AppendString(out, l.Value)
+}
+
+// unmarshal decodes the sample from its protobuf encoding
+func (s *Sample) unmarshal(b []byte) error {
+	return wire.Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			bits, _ := protowire.ConsumeFixed64(v)
+			s.Value = math.Float64frombits(bits)
+		case num == 2 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			s.Timestamp = int64(n)
+		}
+		return nil
+	})
+}
+
+// marshal encodes the sample to protobuf
+func (s *Sample) marshal() []byte {
+	out := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, math.Float64bits(s.Value))
+	out = protowire.AppendTag(out, 2, protowire.VarintType)
+	return protowire.AppendVarint(out, uint64(s.Timestamp))
+}
@@ -0,0 +1,75 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package prompb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWriteRequest(t *testing.T) {
+	input := WriteRequest{Timeseries: []TimeSeries{{
+		Labels: []Label{
+			{Name: "job", Value: "api"},
+			{Name: MetricName, Value: "http_requests_total"},
+		},
+		Samples: []Sample{
+			{Value: 1.5, Timestamp: 1600000000000},
+			{Value: -2, Timestamp: 1600000015000},
+		},
+	}, {
+		Labels: []Label{{Name: MetricName, Value: "up"}},
+	}}}
+
+	// The fields which are not known, such as the metadata, are skipped
+	encoded := input.Marshal()
+	encoded = protowire.AppendTag(encoded, 3, protowire.BytesType)
+	encoded = protowire.AppendBytes(encoded, []byte("metadata"))
+
+	var output WriteRequest
+	assert.NoError(t, output.Unmarshal(encoded))
+	assert.Equal(t, input, output)
+	assert.Equal(t, "http_requests_total", output.Timeseries[0].Name())
+
+	output.Timeseries[0].SortLabels()
+	assert.Equal(t, MetricName, output.Timeseries[0].Labels[0].Name)
+
+	assert.Error(t, new(WriteRequest).Unmarshal([]byte{0x0a, 0xff}))
+}
+
+func TestReadRequest(t *testing.T) {
+	input := ReadRequest{Queries: []Query{{
+		StartTimestampMs: 1600000000000,
+		EndTimestampMs:   1600000300000,
+		Matchers: []LabelMatcher{
+			{Type: MatchEqual, Name: MetricName, Value: "http_requests_total"},
+			{Type: MatchRegexp, Name: "code", Value: "5.."},
+		},
+	}}}
+
+	// The hints and the accepted response types are skipped
+	encoded := input.Marshal()
+	encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, 0)
+
+	var output ReadRequest
+	assert.NoError(t, output.Unmarshal(encoded))
+	assert.Equal(t, input, output)
+	assert.Error(t, new(ReadRequest).Unmarshal([]byte{0x0a, 0xff}))
+}
+
+func TestReadResponse(t *testing.T) {
+	input := ReadResponse{Results: []QueryResult{{
+		Timeseries: []TimeSeries{{
+			Labels:  []Label{{Name: MetricName, Value: "up"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1600000000000}},
+		}},
+	}, {}}}
+
+	var output ReadResponse
+	assert.NoError(t, output.Unmarshal(input.Marshal()))
+	assert.Equal(t, input, output)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package protowire
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Decode iterates through the fields of a protobuf message, calling f with the value of each of them, which is
+// the content of the field for the bytes and the encoded value for the other types
+func Decode(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("protowire: invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var v []byte
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return fmt.Errorf("protowire: invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			v, b = value, b[n:]
+		} else {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("protowire: invalid field %d: %v", num, protowire.ParseError(n))
+			}
+			v, b = b[:n], b[n:]
+		}
+
+		if err := f(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
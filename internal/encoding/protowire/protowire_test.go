@@ -0,0 +1,38 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package protowire
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecode(t *testing.T) {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 300)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "hello")
+
+	// The bytes are given without their length, the other types as encoded
+	var types []protowire.Type
+	assert.NoError(t, Decode(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			value, _ := protowire.ConsumeVarint(v)
+			assert.Equal(t, uint64(300), value)
+		case 2:
+			assert.Equal(t, "hello", string(v))
+		}
+		types = append(types, typ)
+		return nil
+	}))
+	assert.Equal(t, []protowire.Type{protowire.VarintType, protowire.BytesType}, types)
+
+	// A truncated message is invalid
+	assert.Error(t, Decode(b[:len(b)-1], func(protowire.Number, protowire.Type, []byte) error {
+		return nil
+	}))
+}
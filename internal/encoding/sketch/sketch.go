@@ -0,0 +1,153 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package sketch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/twmb/murmur3"
+)
+
+// The bounds of the precision, the number of registers being 2^precision
+const (
+	MinPrecision     = 4
+	MaxPrecision     = 16
+	DefaultPrecision = 14
+)
+
+// HLL represents a HyperLogLog sketch, which estimates the number of distinct values added to it with a standard
+// error of about 1.04/sqrt(2^precision). Sketches of the same precision can be merged, such as the sketches of
+// the windows of a longer range, the estimate of the merged sketch being the distinct count of the whole range.
+type HLL struct {
+	precision uint8
+	registers []uint8
+}
+
+// New creates a sketch with a precision, between 4 and 16
+func New(precision uint8) (*HLL, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, fmt.Errorf("sketch: precision %d is outside of [%d, %d]", precision, MinPrecision, MaxPrecision)
+	}
+
+	return &HLL{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}, nil
+}
+
+// Precision returns the precision of the sketch
+func (h *HLL) Precision() uint8 {
+	return h.precision
+}
+
+// Add adds a value to the sketch, the null values being ignored
+func (h *HLL) Add(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		return
+	case string:
+		h.AddHash(murmur3.StringSum64(v))
+	case []byte:
+		h.AddHash(murmur3.Sum64(v))
+	case int64:
+		h.addUint64(uint64(v))
+	case int32:
+		h.addUint64(uint64(v))
+	case float64:
+		h.addUint64(math.Float64bits(v))
+	case bool:
+		h.Add(strconv.FormatBool(v))
+	case time.Time:
+		h.addUint64(uint64(v.UnixNano()))
+	default:
+		h.Add(fmt.Sprintf("%v", v))
+	}
+}
+
+// addUint64 adds a fixed-size value to the sketch
+func (h *HLL) addUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	h.AddHash(murmur3.Sum64(b[:]))
+}
+
+// AddHash adds the 64-bit hash of a value to the sketch
+func (h *HLL) AddHash(hash uint64) {
+	index := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Merge adds the values of another sketch of the same precision to this one
+func (h *HLL) Merge(other *HLL) error {
+	if other.precision != h.precision {
+		return fmt.Errorf("sketch: unable to merge a sketch of precision %d into one of precision %d", other.precision, h.precision)
+	}
+
+	for i, v := range other.registers {
+		if v > h.registers[i] {
+			h.registers[i] = v
+		}
+	}
+	return nil
+}
+
+// Estimate returns the estimated number of distinct values added to the sketch
+func (h *HLL) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, v := range h.registers {
+		sum += 1 / float64(uint64(1)<<v)
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(len(h.registers)) * m * m / sum
+
+	// Use linear counting for the small cardinalities, which is more accurate while registers are empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// alpha returns the bias correction of the number of registers
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// MarshalBinary encodes the sketch as its precision followed by its registers
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, len(h.registers)+1)
+	out = append(out, h.precision)
+	return append(out, h.registers...), nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (h *HLL) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 || b[0] < MinPrecision || b[0] > MaxPrecision || len(b) != 1+1<<b[0] {
+		return fmt.Errorf("sketch: unable to decode a sketch of %d bytes", len(b))
+	}
+
+	h.precision = b[0]
+	h.registers = append(make([]uint8, 0, len(b)-1), b[1:]...)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package sketch
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHLL(t *testing.T) {
+	_, err := New(20)
+	assert.Error(t, err)
+
+	a, err := New(DefaultPrecision)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), a.Estimate())
+
+	// The duplicates and the null values are not counted
+	for i := 0; i < 100; i++ {
+		a.Add("user-" + strconv.Itoa(i%10))
+		a.Add(nil)
+	}
+	assert.Equal(t, uint64(10), a.Estimate())
+
+	// The estimate of large cardinalities is within a few percents
+	b, _ := New(DefaultPrecision)
+	for i := int64(0); i < 100000; i++ {
+		a.Add(i)
+		b.Add(i + 50000)
+	}
+	assert.InDelta(t, 100010, float64(a.Estimate()), 2000)
+
+	// The merged sketch counts the distinct values of both
+	assert.NoError(t, a.Merge(b))
+	assert.InDelta(t, 150010, float64(a.Estimate()), 3000)
+
+	other, _ := New(8)
+	assert.Error(t, a.Merge(other))
+
+	// The sketch is encoded with its precision
+	encoded, err := a.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, encoded, 1+int(math.Pow(2, DefaultPrecision)))
+
+	var decoded HLL
+	assert.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, a.Estimate(), decoded.Estimate())
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:10]))
+}
@@ -0,0 +1,238 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package syslog parses the messages of the syslog protocol (RFC5424), either sent in UDP datagrams or framed over
+// TCP with octet counting or a trailing newline (RFC6587).
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// maxFrame is the maximum size of a message sent over TCP
+const maxFrame = 64 * 1024
+
+// ErrTooLarge is returned when a message terminated by a newline is larger than a frame, the message being skipped
+var ErrTooLarge = errors.New("syslog: message too large")
+
+var (
+	errInvalidPriority = errors.New("syslog: invalid priority")
+	errInvalidVersion  = errors.New("syslog: unsupported version, only RFC5424 is supported")
+	errInvalidHeader   = errors.New("syslog: invalid header")
+	errInvalidData     = errors.New("syslog: invalid structured data")
+	errInvalidFrame    = errors.New("syslog: invalid frame")
+)
+
+// The names of the severities, by number
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message represents a syslog message. The fields which were not set by the sender are empty.
+type Message struct {
+	Facility       int
+	Severity       int
+	Time           time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// SeverityName returns the name of the severity of the message, such as "err"
+func (m *Message) SeverityName() string {
+	return severities[m.Severity]
+}
+
+// Parse parses a message formatted as per RFC5424
+func Parse(b []byte) (*Message, error) {
+	b = bytes.TrimRight(b, "\r\n")
+	if len(b) < 3 || b[0] != '<' {
+		return nil, errInvalidPriority
+	}
+
+	// The priority combines the facility and the severity
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return nil, errInvalidPriority
+	}
+
+	priority, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || priority > 191 {
+		return nil, errInvalidPriority
+	}
+
+	msg := &Message{Facility: priority / 8, Severity: priority % 8}
+	b = b[end+1:]
+	if !bytes.HasPrefix(b, []byte("1 ")) {
+		return nil, errInvalidVersion
+	}
+	b = b[2:]
+
+	// The header is made of fields separated by a space, "-" standing for a missing value
+	var header [5]string
+	for i := range header {
+		field, rest, ok := next(b)
+		if !ok {
+			return nil, errInvalidHeader
+		}
+		if field != "-" {
+			header[i] = field
+		}
+		b = rest
+	}
+
+	if header[0] != "" {
+		if msg.Time, err = time.Parse(time.RFC3339Nano, header[0]); err != nil {
+			return nil, errInvalidHeader
+		}
+	}
+	msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID = header[1], header[2], header[3], header[4]
+
+	// The structured data is either missing or a list of elements, followed by the message
+	switch {
+	case bytes.HasPrefix(b, []byte("-")):
+		b = b[1:]
+	case bytes.HasPrefix(b, []byte("[")):
+		if msg.StructuredData, b, err = parseData(b); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errInvalidData
+	}
+
+	if len(b) > 0 {
+		if b[0] != ' ' {
+			return nil, errInvalidData
+		}
+		msg.Message = string(bytes.TrimPrefix(b[1:], []byte("\xef\xbb\xbf")))
+	}
+	return msg, nil
+}
+
+// next returns the field up to the next space, and the rest after it
+func next(b []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(b, ' ')
+	if i <= 0 {
+		return "", nil, false
+	}
+	return string(b[:i]), b[i+1:], true
+}
+
+// parseData parses the elements of the structured data, such as [id key="value"], and returns the rest
+func parseData(b []byte) (map[string]map[string]string, []byte, error) {
+	data := make(map[string]map[string]string)
+	for len(b) > 0 && b[0] == '[' {
+		b = b[1:]
+
+		// The identifier of the element, followed by its parameters
+		i := bytes.IndexAny(b, " ]")
+		if i <= 0 {
+			return nil, nil, errInvalidData
+		}
+
+		params := make(map[string]string)
+		data[string(b[:i])] = params
+		b = b[i:]
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+			eq := bytes.IndexByte(b, '=')
+			if eq <= 0 || len(b) < eq+2 || b[eq+1] != '"' {
+				return nil, nil, errInvalidData
+			}
+
+			name := string(b[:eq])
+			value, rest, ok := quoted(b[eq+2:])
+			if !ok {
+				return nil, nil, errInvalidData
+			}
+			params[name], b = value, rest
+		}
+
+		if len(b) == 0 || b[0] != ']' {
+			return nil, nil, errInvalidData
+		}
+		b = b[1:]
+	}
+	return data, b, nil
+}
+
+// quoted reads a parameter value up to its closing quote, unescaping the quotes, backslashes and brackets
+func quoted(b []byte) (string, []byte, bool) {
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == '\\' && i+1 < len(b) && (b[i+1] == '"' || b[i+1] == '\\' || b[i+1] == ']'):
+			out = append(out, b[i+1])
+			i++
+		case c == '"':
+			return string(out), b[i+1:], true
+		default:
+			out = append(out, c)
+		}
+	}
+	return "", nil, false
+}
+
+// ReadFrame reads a message sent over TCP, framed either with its length in octets followed by a space, or with a
+// trailing newline. A message terminated by a newline which is larger than a frame is skipped, and ErrTooLarge is
+// returned so the next message can still be read.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without octet counting, the messages are terminated by a newline
+	if first[0] < '0' || first[0] > '9' {
+		return readLine(r)
+	}
+
+	prefix, err := r.ReadString(' ')
+	if err != nil {
+		return nil, errInvalidFrame
+	}
+
+	size, err := strconv.Atoi(prefix[:len(prefix)-1])
+	if err != nil || size <= 0 || size > maxFrame {
+		return nil, errInvalidFrame
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readLine reads a message terminated by a newline or by the end of the stream, without buffering more than a frame
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	var skipped bool
+	for {
+		chunk, err := r.ReadSlice('\n')
+		switch {
+		case skipped:
+		case len(line)+len(chunk) > maxFrame:
+			line, skipped = nil, true
+		default:
+			line = append(line, chunk...)
+		}
+
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case skipped && (err == nil || err == io.EOF):
+			return nil, ErrTooLarge
+		case err == io.EOF && len(line) > 0:
+			return line, nil
+		default:
+			return line, err
+		}
+	}
+}
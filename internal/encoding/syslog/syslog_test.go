@@ -0,0 +1,88 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	msg, err := Parse([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication" eventID="1011"][examplePriority@32473 class="high"] ` + "\xef\xbb\xbf" + "An application event log entry...\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Message{
+		Facility: 20,
+		Severity: 5,
+		Time:     time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		Hostname: "mymachine.example.com",
+		AppName:  "evntslog",
+		MsgID:    "ID47",
+		StructuredData: map[string]map[string]string{
+			"exampleSDID@32473":     {"iut": "3", "eventSource": `App"lication`, "eventID": "1011"},
+			"examplePriority@32473": {"class": "high"},
+		},
+		Message: "An application event log entry...",
+	}, msg)
+	assert.Equal(t, "notice", msg.SeverityName())
+
+	// The missing values are empty
+	msg, err = Parse([]byte(`<34>1 - - su - - -`))
+	assert.NoError(t, err)
+	assert.Equal(t, &Message{Facility: 4, Severity: 2, AppName: "su"}, msg)
+
+	for _, invalid := range []string{
+		`34>1 - - - - - -`,
+		`<999>1 - - - - - -`,
+		`<34>Oct 11 22:14:15 mymachine su: 'su root' failed`,
+		`<34>1 - - -`,
+		`<34>1 yesterday - - - - -`,
+		`<34>1 - - - - - [id key="value"`,
+		`<34>1 - - - - - message`,
+	} {
+		_, err := Parse([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <34>1 - - -<34>1 a b c d e -\n<34>1 - - - - - -"))
+
+	frame, err := ReadFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<34>1 - - -", string(frame))
+
+	frame, err = ReadFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<34>1 a b c d e -\n", string(frame))
+
+	frame, err = ReadFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<34>1 - - - - - -", string(frame))
+
+	_, err = ReadFrame(r)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadFrame(bufio.NewReader(strings.NewReader("99999999 <34>")))
+	assert.Error(t, err)
+
+	// A line larger than a frame is skipped, the next one still being read
+	r = bufio.NewReader(strings.NewReader("<34>1 " + strings.Repeat("-", maxFrame) + "\n<34>1 - - -\n<34>1 " + strings.Repeat("-", maxFrame)))
+	_, err = ReadFrame(r)
+	assert.Equal(t, ErrTooLarge, err)
+
+	frame, err = ReadFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<34>1 - - -\n", string(frame))
+
+	_, err = ReadFrame(r)
+	assert.Equal(t, ErrTooLarge, err)
+
+	_, err = ReadFrame(r)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,238 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timestamp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// The named formats, any other format being a Go layout such as "2006-01-02 15:04:05"
+const (
+	RFC3339     = "rfc3339"     // A string such as "2006-01-02T15:04:05Z07:00"
+	RFC3339Nano = "rfc3339nano" // A string such as "2006-01-02T15:04:05.999999999Z07:00"
+	RFC1123     = "rfc1123"     // A string such as "Mon, 02 Jan 2006 15:04:05 MST"
+	Epoch       = "epoch"       // A number of seconds, milliseconds, microseconds or nanoseconds, guessed from its value
+	Unix        = "unix"        // A number of seconds since the epoch
+	UnixMilli   = "unixmilli"   // A number of milliseconds since the epoch
+	UnixMicro   = "unixmicro"   // A number of microseconds since the epoch
+	UnixNano    = "unixnano"    // A number of nanoseconds since the epoch
+	Timestamp   = "timestamp"   // A timestamp, only as an output
+)
+
+// defaultFormats are the formats tried if none is given
+var defaultFormats = []string{RFC3339Nano, Epoch}
+
+// Parser represents a parser of the values of a timestamp column, trying each of its formats in order
+type Parser struct {
+	formats  []string       // The formats to try, in order
+	location *time.Location // The time zone of the layouts without one
+	output   string         // The representation of the parsed time
+}
+
+// New creates a new parser for the formats, the timezone of the layouts without a time zone, UTC by default,
+// and the output, either "unix" (default), "unixmilli", "unixmicro", "unixnano" or "timestamp".
+func New(formats []string, timezone, output string) (*Parser, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(formats) == 0 {
+		formats = defaultFormats
+	}
+
+	p := &Parser{
+		formats:  make([]string, 0, len(formats)),
+		location: location,
+		output:   strings.ToLower(output),
+	}
+
+	for _, f := range formats {
+		switch name := strings.ToLower(f); name {
+		case RFC3339, RFC3339Nano, RFC1123, Epoch, Unix, UnixMilli, UnixMicro, UnixNano:
+			p.formats = append(p.formats, name)
+		default:
+			p.formats = append(p.formats, f)
+		}
+	}
+
+	switch p.output {
+	case "":
+		p.output = Unix
+	case Unix, UnixMilli, UnixMicro, UnixNano, Timestamp:
+	default:
+		return nil, fmt.Errorf("timestamp: unknown output %s", output)
+	}
+	return p, nil
+}
+
+// Type returns the type of the parsed values
+func (p *Parser) Type() typeof.Type {
+	if p.output == Timestamp {
+		return typeof.Timestamp
+	}
+	return typeof.Int64
+}
+
+// Parse parses a value in the first of the formats which matches, and converts it to the output
+func (p *Parser) Parse(v interface{}) (interface{}, bool) {
+	t, ok := p.parse(v)
+	if !ok {
+		return nil, false
+	}
+
+	switch p.output {
+	case Timestamp:
+		return t, true
+	case UnixMilli:
+		return t.UnixNano() / int64(time.Millisecond), true
+	case UnixMicro:
+		return t.UnixNano() / int64(time.Microsecond), true
+	case UnixNano:
+		return t.UnixNano(), true
+	default:
+		return t.Unix(), true
+	}
+}
+
+// parse parses a value as a time
+func (p *Parser) parse(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		return p.parseString(strings.TrimSpace(v))
+	case int64:
+		return p.parseInteger(v)
+	case int32:
+		return p.parseInteger(int64(v))
+	case float64:
+		return p.parseNumber(v)
+	default:
+		return time.Time{}, false
+	}
+}
+
+// parseString parses a string in the first of the formats which matches
+func (p *Parser) parseString(s string) (time.Time, bool) {
+	for _, format := range p.formats {
+		var t time.Time
+		var err error
+		switch format {
+		case RFC3339:
+			t, err = time.Parse(time.RFC3339, s)
+		case RFC3339Nano:
+			t, err = time.Parse(time.RFC3339Nano, s)
+		case RFC1123:
+			t, err = time.Parse(time.RFC1123, s)
+		case Epoch, Unix, UnixMilli, UnixMicro, UnixNano:
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return fromInteger(n, format)
+			}
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				return fromNumber(n, format)
+			}
+			continue
+		default:
+			t, err = time.ParseInLocation(format, s, p.location)
+		}
+
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseNumber converts a number with the first of the numeric formats
+func (p *Parser) parseNumber(n float64) (time.Time, bool) {
+	for _, format := range p.formats {
+		if t, ok := fromNumber(n, format); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseInteger converts an integer with the first of the numeric formats
+func (p *Parser) parseInteger(n int64) (time.Time, bool) {
+	for _, format := range p.formats {
+		if t, ok := fromInteger(n, format); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fromInteger converts an integer to a time without losing precision, if the format is numeric
+func fromInteger(n int64, format string) (time.Time, bool) {
+	unit := time.Duration(0)
+	switch format {
+	case Unix:
+		unit = time.Second
+	case UnixMilli:
+		unit = time.Millisecond
+	case UnixMicro:
+		unit = time.Microsecond
+	case UnixNano:
+		unit = time.Nanosecond
+	case Epoch:
+		unit = unitOf(float64(n))
+	default:
+		return time.Time{}, false
+	}
+
+	perSecond := int64(time.Second / unit)
+	return time.Unix(n/perSecond, (n%perSecond)*int64(unit)).UTC(), true
+}
+
+// fromNumber converts a number to a time, if the format is numeric
+func fromNumber(n float64, format string) (time.Time, bool) {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return time.Time{}, false
+	}
+
+	switch format {
+	case Unix:
+		return fromUnit(n, time.Second), true
+	case UnixMilli:
+		return fromUnit(n, time.Millisecond), true
+	case UnixMicro:
+		return fromUnit(n, time.Microsecond), true
+	case UnixNano:
+		return fromUnit(n, time.Nanosecond), true
+	case Epoch:
+		return fromUnit(n, unitOf(n)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// fromUnit converts a number of units since the epoch to a time
+func fromUnit(n float64, unit time.Duration) time.Time {
+	seconds, fraction := math.Modf(n * float64(unit) / float64(time.Second))
+	return time.Unix(int64(seconds), int64(fraction*float64(time.Second))).UTC()
+}
+
+// unitOf guesses whether a number is in seconds, milliseconds, microseconds or nanoseconds, the same way as the
+// time constraints of the queries.
+func unitOf(n float64) time.Duration {
+	watermark := float64(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	switch {
+	case n > watermark:
+		return time.Nanosecond
+	case n > watermark/1000:
+		return time.Microsecond
+	case n > watermark/1000000:
+		return time.Millisecond
+	default:
+		return time.Second
+	}
+}
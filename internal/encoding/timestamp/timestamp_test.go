@@ -0,0 +1,76 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timestamp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	p, err := New(nil, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, typeof.Int64, p.Type())
+
+	for _, tc := range []struct {
+		input interface{}
+		ok    bool
+	}{
+		{input: "2020-01-01T08:00:00+08:00", ok: true},
+		{input: "2020-01-01T00:00:00.123Z", ok: true},
+		{input: "1577836800", ok: true},
+		{input: "1577836800000", ok: true},
+		{input: int64(1577836800000000), ok: true},
+		{input: int64(1577836800000000000), ok: true},
+		{input: int32(1577836800), ok: true},
+		{input: 1577836800.5, ok: true},
+		{input: time.Unix(1577836800, 0), ok: true},
+		{input: "yesterday", ok: false},
+		{input: true, ok: false},
+	} {
+		v, ok := p.Parse(tc.input)
+		assert.Equal(t, tc.ok, ok, "%v", tc.input)
+		if tc.ok {
+			assert.Equal(t, int64(1577836800), v, "%v", tc.input)
+		}
+	}
+}
+
+func TestParse_Formats(t *testing.T) {
+	p, err := New([]string{"2006-01-02 15:04:05", "unixmilli"}, "Asia/Singapore", "unixmilli")
+	assert.NoError(t, err)
+
+	// A layout without a time zone is in the default one
+	v, ok := p.Parse("2020-01-01 08:00:00")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1577836800000), v)
+
+	// The numbers are never guessed
+	v, ok = p.Parse("1577836800123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1577836800123), v)
+
+	// None of the formats matches
+	_, ok = p.Parse("2020-01-01T00:00:00Z")
+	assert.False(t, ok)
+}
+
+func TestParse_Output(t *testing.T) {
+	p, err := New([]string{"RFC3339"}, "", "timestamp")
+	assert.NoError(t, err)
+	assert.Equal(t, typeof.Timestamp, p.Type())
+
+	v, ok := p.Parse("2020-01-01T08:00:00+08:00")
+	assert.True(t, ok)
+	assert.True(t, time.Unix(1577836800, 0).Equal(v.(time.Time)))
+
+	_, err = New(nil, "Mars/Olympus", "")
+	assert.Error(t, err)
+
+	_, err = New(nil, "", "weeks")
+	assert.Error(t, err)
+}
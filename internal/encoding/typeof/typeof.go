@@ -97,6 +97,11 @@ func FromType(rt reflect.Type) (Type, bool) {
 		return Unsupported, false
 	}
 
+	// The raw JSON is compared by type, since its name depends on the version of the encoding/json package
+	if rt == reflectOfJSON {
+		return JSON, true
+	}
+
 	switch rt.Name() {
 	case "int32":
 		return Int32, true
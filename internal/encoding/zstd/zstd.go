@@ -0,0 +1,9 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package zstd registers the zstd compressor of the gRPC messages, once imported. As the compressor relies on
+// the zstd library through cgo, it is only registered when built with cgo.
+package zstd
+
+// Name is the name of the compressor, which the clients pass to grpc.UseCompressor
+const Name = "zstd"
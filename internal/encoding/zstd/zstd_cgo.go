@@ -0,0 +1,66 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+//go:build cgo
+// +build cgo
+
+package zstd
+
+import (
+	"io"
+	"runtime"
+
+	"github.com/DataDog/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+func init() {
+	encoding.RegisterCompressor(new(compressor))
+}
+
+// compressor represents the zstd compressor of the gRPC messages
+type compressor struct{}
+
+// Name returns the name of the compressor
+func (c *compressor) Name() string {
+	return Name
+}
+
+// Compress returns a writer compressing the message into the underlying writer
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w), nil
+}
+
+// Decompress returns a reader decompressing the message read from the underlying reader
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	dr := &reader{src: zstd.NewReader(r)}
+	runtime.SetFinalizer(dr, (*reader).close)
+	return dr, nil
+}
+
+// reader represents a decompressing reader, which releases the zstd context once it reaches the end of the message,
+// or once collected if the message is not read to the end, such as when it exceeds the maximum size
+type reader struct {
+	src io.ReadCloser
+}
+
+// Read reads the decompressed message
+func (r *reader) Read(p []byte) (int, error) {
+	if r.src == nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.src.Read(p)
+	if err != nil {
+		r.close()
+	}
+	return n, err
+}
+
+// close releases the zstd context
+func (r *reader) close() {
+	if r.src != nil {
+		_ = r.src.Close()
+		r.src = nil
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+//go:build cgo
+// +build cgo
+
+package zstd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestCompressor(t *testing.T) {
+	c := encoding.GetCompressor(Name)
+	assert.NotNil(t, c)
+
+	message := bytes.Repeat([]byte("event,phone\nclick,+6591234567\n"), 100)
+	var out bytes.Buffer
+	w, err := c.Compress(&out)
+	assert.NoError(t, err)
+	_, err = w.Write(message)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Less(t, out.Len(), len(message))
+
+	r, err := c.Decompress(&out)
+	assert.NoError(t, err)
+	decoded, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, message, decoded)
+}
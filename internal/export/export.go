@@ -0,0 +1,281 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/merge"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/inspect"
+	talaria "github.com/kelindar/talaria/proto"
+)
+
+const (
+	defaultFileSize = 128 * 1024 * 1024 // The default size of the rows written to a file, before encoding
+	maxBytes        = 16 * 1024 * 1024  // The maximum size of a page of rows read from a node
+)
+
+// Source represents the blocks of a table to export, calling f with each of them until it returns an error
+type Source func(f func(block.Block) error) error
+
+// Sink represents the storage the exported files are written to, such as a local directory or S3
+type Sink interface {
+	Write(key key.Key, val []byte) error
+}
+
+// Options represents the format and the size of the exported files
+type Options struct {
+	Format      string // The format of the files, either "orc" (default) or "parquet"
+	Compression string // The compression of the files, defaults to zlib for orc and snappy for parquet
+	FileSize    int64  // The size of the rows of a file before encoding, in bytes, defaults to 128MB
+}
+
+// Report represents the outcome of an export
+type Report struct {
+	Files  []string      // The names of the files written
+	Rows   int64         // The number of rows written
+	Bytes  int64         // The size of the files written, in bytes
+	Took   time.Duration // The time it took to export the table
+	Schema typeof.Schema // The schema of the files written
+}
+
+// String returns a human-readable summary of the report
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "rows:  %d in %d file(s) of %d bytes\n", r.Rows, len(r.Files), r.Bytes)
+	fmt.Fprintf(&sb, "took:  %s\n", r.Took.Round(time.Millisecond))
+	for _, name := range r.Files {
+		fmt.Fprintf(&sb, "file:  %s\n", name)
+	}
+	return sb.String()
+}
+
+// FromDirectory reads the blocks of a table in a range from the data directory of a stopped node.
+func FromDirectory(dir, table string, r inspect.Range) Source {
+	return func(f func(block.Block) error) error {
+		return inspect.Blocks(dir, table, r, f)
+	}
+}
+
+// FromNode reads the rows of a table matching the filters from a live node through its gRPC query API, such as
+// "event == 'click'", which must select a value of the key of the table. Only the rows stored by the node are
+// read, the splits being read from the node itself.
+func FromNode(ctx context.Context, client talaria.QueryClient, table string, columns []string, filters ...string) Source {
+	return func(f func(block.Block) error) error {
+		splits, err := client.GetSplits(ctx, &talaria.GetSplitsRequest{
+			Table:   table,
+			Columns: columns,
+			Filters: filters,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, split := range splits.Splits {
+			var token []byte
+			for {
+				rows, err := client.GetRows(ctx, &talaria.GetRowsRequest{
+					SplitID:   split.SplitID,
+					Columns:   columns,
+					MaxBytes:  maxBytes,
+					NextToken: token,
+				})
+				if err != nil {
+					return err
+				}
+
+				if rows.RowCount > 0 {
+					b, err := block.FromColumns("", columnsOf(columns, rows.Columns))
+					if err != nil {
+						return err
+					}
+					if err := f(b); err != nil {
+						return err
+					}
+				}
+
+				if token = rows.NextToken; len(token) == 0 {
+					break
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// ColumnsOf returns the columns of a table of a live node, as described by its gRPC query API.
+func ColumnsOf(ctx context.Context, client talaria.QueryClient, table string) ([]string, error) {
+	described, err := client.Describe(ctx, &talaria.DescribeRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range described.Tables {
+		if t.Table != table {
+			continue
+		}
+
+		columns := make([]string, 0, len(t.Columns))
+		for _, c := range t.Columns {
+			columns = append(columns, c.Name)
+		}
+		return columns, nil
+	}
+	return nil, fmt.Errorf("table %s was not found", table)
+}
+
+// Run exports the blocks of the source into files of the table written to the sink, such as "eventlog-00001.orc".
+// A file is written every time the rows read reach the file size, its schema being the union of the schemas
+// of its blocks, the column of the first block read winning on a mismatched type.
+func Run(source Source, sink Sink, table string, options Options) (*Report, error) {
+	format := strings.ToLower(options.Format)
+	if format == "" {
+		format = "orc"
+	}
+
+	encode, err := merge.NewWith(format, options.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.FileSize <= 0 {
+		options.FileSize = defaultFileSize
+	}
+
+	start := time.Now()
+	report := &Report{Schema: make(typeof.Schema)}
+	var pending []block.Block
+	var size int64
+
+	// flush encodes the pending blocks into a file and writes it to the sink
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+
+		schema := make(typeof.Schema)
+		for _, b := range pending {
+			for name, typ := range b.Schema() {
+				if _, ok := schema[name]; !ok {
+					schema[name] = typ
+				}
+			}
+		}
+
+		output, err := encode(pending, schema)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s-%05d.%s", table, len(report.Files)+1, format)
+		if err := sink.Write(key.Key(name), output); err != nil {
+			return err
+		}
+
+		for column, typ := range schema {
+			if _, ok := report.Schema[column]; !ok {
+				report.Schema[column] = typ
+			}
+		}
+
+		report.Files = append(report.Files, name)
+		report.Bytes += int64(len(output))
+		pending, size = pending[:0], 0
+		return nil
+	}
+
+	if err := source(func(b block.Block) error {
+		columns, err := b.Select(b.Schema())
+		if err != nil || len(columns) == 0 {
+			return err
+		}
+
+		report.Rows += int64(columns.Any().Count())
+		pending = append(pending, b)
+		if size += b.Size; size >= options.FileSize {
+			return flush()
+		}
+		return nil
+	}); err != nil {
+		return report, err
+	}
+
+	err = flush()
+	report.Took = time.Since(start)
+	return report, err
+}
+
+// columnsOf converts the columns read from a node, in the order of their names
+func columnsOf(names []string, columns []*talaria.Column) column.Columns {
+	out := make(column.Columns, len(columns))
+	for i, c := range columns {
+		if i >= len(names) {
+			break
+		}
+
+		switch v := c.GetValue().(type) {
+		case *talaria.Column_Int32:
+			out[names[i]] = appendAll(typeof.Int32, v.Int32.Nulls, len(v.Int32.Ints), func(i int) interface{} {
+				return v.Int32.Ints[i]
+			})
+		case *talaria.Column_Int64:
+			out[names[i]] = appendAll(typeof.Int64, v.Int64.Nulls, len(v.Int64.Longs), func(i int) interface{} {
+				return v.Int64.Longs[i]
+			})
+		case *talaria.Column_Float64:
+			out[names[i]] = appendAll(typeof.Float64, v.Float64.Nulls, len(v.Float64.Doubles), func(i int) interface{} {
+				return v.Float64.Doubles[i]
+			})
+		case *talaria.Column_Bool:
+			out[names[i]] = appendAll(typeof.Bool, v.Bool.Nulls, len(v.Bool.Bools), func(i int) interface{} {
+				return v.Bool.Bools[i]
+			})
+		case *talaria.Column_Time: // In milliseconds
+			out[names[i]] = appendAll(typeof.Timestamp, v.Time.Nulls, len(v.Time.Longs), func(i int) interface{} {
+				return time.Unix(0, v.Time.Longs[i]*int64(time.Millisecond))
+			})
+		case *talaria.Column_String_:
+			out[names[i]] = appendStrings(typeof.String, v.String_)
+		case *talaria.Column_Json:
+			out[names[i]] = appendStrings(typeof.JSON, v.Json)
+		}
+	}
+	return out
+}
+
+// appendAll creates a column of a type with the values, a null value being appended as nil
+func appendAll(typ typeof.Type, nulls []bool, count int, valueAt func(int) interface{}) column.Column {
+	out := column.NewColumn(typ)
+	for i := 0; i < count; i++ {
+		if i < len(nulls) && nulls[i] {
+			out.Append(nil)
+			continue
+		}
+		out.Append(valueAt(i))
+	}
+	return out
+}
+
+// appendStrings creates a column of a type with the values of a column of strings
+func appendStrings(typ typeof.Type, c *talaria.ColumnOfString) column.Column {
+	out := column.NewColumn(typ)
+	offset := 0
+	for i, size := range c.Sizes {
+		v := string(c.Bytes[offset : offset+int(size)])
+		offset += int(size)
+		if i < len(c.Nulls) && c.Nulls[i] {
+			out.Append(nil)
+			continue
+		}
+		out.Append(v)
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package export
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/inspect"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/writer/file"
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/kelindar/talaria/talariatest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+const events = `[
+	{"event": "click", "time": 1600000000, "value": 1.5},
+	{"event": "click", "time": 1600000100, "value": 2.5},
+	{"event": "view", "time": 1600000200, "value": 3.5}
+]`
+
+func TestFromDirectory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "export")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// Append the blocks the way a table does, then stop
+	store := disk.Open(dir, "events", monitor.NewNoop(), config.Badger{})
+	blocks, err := block.FromJSONBy([]byte(events), "event", nil, block.Transform(nil))
+	assert.NoError(t, err)
+	for _, b := range blocks {
+		buffer, err := b.Encode()
+		assert.NoError(t, err)
+		assert.NoError(t, store.Append(key.New(string(b.Key), time.Unix(1600000000, 0)), buffer, time.Hour))
+	}
+	assert.NoError(t, store.Close())
+
+	// Export every key into a file per block
+	out := filepath.Join(dir, "out")
+	sink, err := file.New(out)
+	assert.NoError(t, err)
+	report, err := Run(FromDirectory(dir, "events", inspect.Range{}), sink, "events", Options{FileSize: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.Rows)
+	assert.Equal(t, []string{"events-00001.orc", "events-00002.orc"}, report.Files)
+	assert.Equal(t, typeof.Schema{"event": typeof.String, "time": typeof.Int64, "value": typeof.Float64}, report.Schema)
+	assert.Contains(t, report.String(), "rows:  3 in 2 file(s)")
+
+	count := 0
+	for _, name := range report.Files {
+		i, err := orc.FromFile(filepath.Join(out, name))
+		assert.NoError(t, err)
+		n, _ := i.Range(func(int, []interface{}) bool { return false }, "event")
+		count += n
+		_ = i.Close()
+	}
+	assert.Equal(t, 3, count)
+
+	// Export a single key as parquet
+	report, err = Run(FromDirectory(dir, "events", inspect.Range{Key: "click"}), sink, "clicks", Options{Format: "parquet"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Rows)
+	assert.Equal(t, []string{"clicks-00001.parquet"}, report.Files)
+	assert.FileExists(t, filepath.Join(out, "clicks-00001.parquet"))
+
+	_, err = Run(FromDirectory(dir, "events", inspect.Range{}), sink, "events", Options{Format: "avro"})
+	assert.Error(t, err)
+}
+
+func TestFromNode(t *testing.T) {
+	h := talariatest.New(t, `
+tables:
+  events:
+    hashBy: event
+    sortBy: time
+    ttl: 3600
+`)
+
+	assert.NoError(t, h.Append(context.Background(), "events",
+		map[string]interface{}{"event": "click", "time": 1600000000, "value": 1.5},
+		map[string]interface{}{"event": "click", "time": 1600000100},
+		map[string]interface{}{"event": "view", "time": 1600000200, "value": 3.5},
+	))
+
+	conn, err := grpc.Dial(h.GRPC(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	dir, _ := ioutil.TempDir("", "export")
+	defer func() { _ = os.RemoveAll(dir) }()
+	sink, err := file.New(dir)
+	assert.NoError(t, err)
+
+	client := talaria.NewQueryClient(conn)
+	columns, err := ColumnsOf(context.Background(), client, "events")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"event", "time", "value"}, columns)
+	_, err = ColumnsOf(context.Background(), client, "unknown")
+	assert.Error(t, err)
+
+	source := FromNode(context.Background(), client, "events", columns, "event == 'click'")
+	report, err := Run(source, sink, "events", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Rows)
+	assert.Equal(t, typeof.Schema{"event": typeof.String, "time": typeof.Int64, "value": typeof.Float64}, report.Schema)
+
+	i, err := orc.FromFile(filepath.Join(dir, "events-00001.orc"))
+	assert.NoError(t, err)
+	defer i.Close()
+
+	var values []interface{}
+	i.Range(func(_ int, row []interface{}) bool {
+		values = append(values, row[0])
+		return false
+	}, "value")
+	assert.Len(t, values, 2)
+	assert.Contains(t, values, nil)
+}
@@ -0,0 +1,190 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/timestamp"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	script "github.com/kelindar/talaria/internal/scripting"
+)
+
+// applyFunc applies a transformation on a row and returns a new row
+type applyFunc = func(block.Row) (block.Row, error)
+
+const (
+	ctxTag           = "pipeline"
+	stageErrorKey    = "stage.error"
+	stageDroppedKey  = "stage.dropped"
+	onErrorDrop      = "drop"
+	defaultInterval  = 5 * time.Minute
+	defaultSeparator = "."
+)
+
+// New creates the ingestion pipeline of a table, as an ordered list of stages to apply to every decoded
+// row. If the table does not declare a pipeline, it is made of the filter, sample, enrich and
+// masks options. The other stages which can not be loaded are skipped, but a filter or a mask which
+// can not be loaded is an error, so that the table does not ingest the rows it should drop or mask.
+func New(table string, conf config.Table, monitor monitor.Monitor, loader *script.Loader) ([]applyFunc, error) {
+	stages := conf.Pipeline
+	if len(stages) == 0 {
+		stages = legacyOf(conf)
+	}
+
+	// Parse the timestamp columns first, so that every stage sees them as they are stored
+	out := make([]applyFunc, 0, len(stages)+1)
+	if len(conf.Timestamps) > 0 {
+		parsers := make(map[string]*timestamp.Parser, len(conf.Timestamps))
+		for column, ts := range conf.Timestamps {
+			parser, err := timestamp.New(ts.Formats, ts.Timezone, ts.Output)
+			if err != nil {
+				monitor.Error(errors.Internal("pipeline: unable to load a timestamp column", err,
+					errors.WithTag("table", table),
+					errors.WithTag("column", column)))
+				continue
+			}
+			parsers[column] = parser
+		}
+
+		out = append(out, measure(parseTimestamps(parsers), "", monitor, "table:"+table, "stage:timestamp"))
+	}
+
+	for i, stage := range stages {
+		name, apply, err := newStage(table, stage, loader)
+		switch {
+		case err != nil && isProtective(stage):
+			return nil, errors.Internal("pipeline: unable to load a stage", err,
+				errors.WithTag("table", table),
+				errors.WithTag("stage", i))
+		case err != nil:
+			monitor.Error(errors.Internal("pipeline: unable to load a stage", err,
+				errors.WithTag("table", table),
+				errors.WithTag("stage", i)))
+			continue
+		}
+
+		// A filter or a mask which fails drops the row, unless told otherwise
+		onError := stage.OnError
+		if onError == "" && isProtective(stage) {
+			onError = onErrorDrop
+		}
+
+		monitor.Log(logging.LevelInfo, "pipeline: loaded a stage", logging.F("table", table), logging.F("stage", name))
+		out = append(out, measure(apply, onError, monitor, "table:"+table, "stage:"+name))
+	}
+	return out, nil
+}
+
+// isProtective checks whether a stage keeps the rows or the values which should not be ingested out of the
+// table, in which case the rows must not skip it
+func isProtective(stage config.Stage) bool {
+	return stage.Filter != "" || stage.Mask != nil
+}
+
+// legacyOf converts the filter, sample, enrich and masks options of a table to a list of stages
+func legacyOf(conf config.Table) (stages []config.Stage) {
+	if conf.Filter != "" {
+		stages = append(stages, config.Stage{Filter: conf.Filter})
+	}
+
+	if conf.Sample != nil {
+		stages = append(stages, config.Stage{Sample: conf.Sample})
+	}
+
+	for i := range conf.Enrich {
+		stages = append(stages, config.Stage{Enrich: &conf.Enrich[i]})
+	}
+
+	for i := range conf.Masks {
+		stages = append(stages, config.Stage{Mask: &conf.Masks[i]})
+	}
+	return
+}
+
+// newStage creates the transformation for a stage
+func newStage(table string, stage config.Stage, loader *script.Loader) (string, applyFunc, error) {
+	switch {
+	case stage.Filter != "":
+		predicate, err := column.NewComputed(table, typeof.Bool, stage.Filter, loader)
+		if err != nil {
+			return "", nil, err
+		}
+		return "filter", filter(predicate), nil
+
+	case stage.Sample != nil:
+		if stage.Sample.Rate < 0 || stage.Sample.Rate > 1 {
+			return "", nil, fmt.Errorf("pipeline: sampling rate of table %s must be between 0 and 1", table)
+		}
+		return "sample", sample(stage.Sample.Rate, stage.Sample.Key), nil
+
+	case stage.Flatten != "":
+		return "flatten", flatten(stage.Flatten), nil
+
+	case stage.Enrich != nil:
+		interval := time.Duration(stage.Enrich.Interval) * time.Second
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+
+		lookup, err := column.NewLookup(stage.Enrich.Source, stage.Enrich.Key, stage.Enrich.Columns, interval)
+		if err != nil {
+			return "", nil, err
+		}
+		return "enrich", compute(lookup), nil
+
+	case stage.Mask != nil:
+		mask, err := column.NewMask(stage.Mask.Column, stage.Mask.Func, stage.Mask.Secret, stage.Mask.Length)
+		if err != nil {
+			return "", nil, err
+		}
+		return "mask", compute(mask), nil
+
+	case stage.Compute != nil:
+		c := stage.Compute
+		if len(c.Columns) > 0 {
+			multi, err := column.NewMulti(c.Name, c.Columns, c.Func, loader)
+			if err != nil {
+				return "", nil, err
+			}
+			return "compute", compute(multi), nil
+		}
+
+		computed, err := column.NewComputed(c.Name, c.Type, c.Func, loader)
+		if err != nil {
+			return "", nil, err
+		}
+		return "compute", compute(computed), nil
+	}
+
+	return "", nil, fmt.Errorf("pipeline: stage of table %s does not specify a transform", table)
+}
+
+// measure applies the error policy of a stage and counts the rows which were dropped or failed
+func measure(apply applyFunc, onError string, monitor monitor.Monitor, tags ...string) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		out, err := apply(r)
+		switch {
+		case err == nil:
+			return out, nil
+		case err == block.ErrDropped:
+			monitor.Count1(ctxTag, stageDroppedKey, tags...)
+			return r, err
+		case onError == onErrorDrop:
+			monitor.Count1(ctxTag, stageErrorKey, tags...)
+			monitor.Count1(ctxTag, stageDroppedKey, tags...)
+			return r, block.ErrDropped
+		default:
+			monitor.Count1(ctxTag, stageErrorKey, tags...)
+			return r, nil // Skip the stage
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	stages, err := New("events", config.Table{
+		Pipeline: []config.Stage{
+			{Flatten: "_"},
+			{Filter: `
+			function main(row)
+				return row["event"] ~= "heartbeat"
+			end`},
+			{Mask: &config.Mask{Column: "user_phone", Func: "mask", Length: 2}},
+			{Compute: &config.Computed{Name: "length", Type: typeof.Int64, Func: `
+			function main(row)
+				return #row["user_phone"]
+			end`}},
+			{OnError: "drop", Compute: &config.Computed{Name: "fails", Type: typeof.Int64, Func: `
+			function main(row)
+				return row["user_missing"] + 1
+			end`}},
+			{},
+		},
+	}, monitor.NewNoop(), script.NewLoader(nil))
+	assert.NoError(t, err)
+	assert.Len(t, stages, 5) // The empty stage is skipped
+
+	run := func(event string, user string) (block.Row, error) {
+		row := block.NewRow(nil, 2)
+		row.Set("event", event)
+		row.Schema["user"] = typeof.JSON
+		row.Values["user"] = json.RawMessage(user)
+
+		var err error
+		for _, stage := range stages {
+			if row, err = stage(row); err != nil {
+				return row, err
+			}
+		}
+		return row, nil
+	}
+
+	// Filtered by the filter stage
+	_, err = run("heartbeat", `{"phone": "91234567"}`)
+	assert.Equal(t, block.ErrDropped, err)
+
+	// Dropped by the last stage, as it fails
+	_, err = run("click", `{"phone": "91234567"}`)
+	assert.Equal(t, block.ErrDropped, err)
+
+	// Goes through all of the stages
+	row, err := run("click", `{"phone": "91234567", "missing": 1, "address": {"zip": 12345}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"event":            "click",
+		"user_phone":       "******67",
+		"user_missing":     int64(1),
+		"user_address_zip": int64(12345),
+		"length":           int64(8),
+		"fails":            int64(2),
+	}, row.Values)
+	assert.Equal(t, typeof.Int64, row.Schema["user_address_zip"])
+}
+
+func TestPipeline_Legacy(t *testing.T) {
+	stages, err := New("events", config.Table{
+		Filter: `
+		function main(row)
+			return row["event"] ~= "heartbeat"
+		end`,
+		Masks: []config.Mask{{Column: "phone", Func: "truncate", Length: 3}},
+	}, monitor.NewNoop(), script.NewLoader(nil))
+	assert.NoError(t, err)
+	assert.Len(t, stages, 2)
+
+	row := block.NewRow(nil, 2)
+	row.Set("event", "click")
+	row.Set("phone", "91234567")
+	row, err = stages[0](row)
+	assert.NoError(t, err)
+	row, err = stages[1](row)
+	assert.NoError(t, err)
+	assert.Equal(t, "912", row.Values["phone"])
+
+	// A row without the event is not filtered
+	_, err = stages[0](block.NewRow(nil, 0))
+	assert.NoError(t, err)
+}
+
+func TestPipeline_FailClosed(t *testing.T) {
+	loader := script.NewLoader(nil)
+
+	// A filter or a mask which can not be loaded fails the pipeline
+	for _, stage := range []config.Stage{
+		{Filter: "local x = 1\n"},
+		{Mask: &config.Mask{Column: "phone", Func: "unknown"}},
+	} {
+		_, err := New("events", config.Table{Pipeline: []config.Stage{stage}}, monitor.NewNoop(), loader)
+		assert.Error(t, err)
+	}
+
+	_, err := New("events", config.Table{Masks: []config.Mask{{Column: "phone", Func: "unknown"}}}, monitor.NewNoop(), loader)
+	assert.Error(t, err)
+
+	// While the other stages are skipped
+	stages, err := New("events", config.Table{Pipeline: []config.Stage{
+		{Compute: &config.Computed{Name: "length", Type: typeof.Int64, Func: "local x = 1\n"}},
+	}}, monitor.NewNoop(), loader)
+	assert.NoError(t, err)
+	assert.Len(t, stages, 0)
+
+	// A filter which fails drops the row, unless told otherwise, while a computed column is skipped
+	failing := `
+	function main(row)
+		return row["missing"] + 1
+	end`
+	stages, err = New("events", config.Table{Pipeline: []config.Stage{
+		{Filter: failing},
+		{OnError: "skip", Filter: failing},
+		{Compute: &config.Computed{Name: "length", Type: typeof.Int64, Func: failing}},
+	}}, monitor.NewNoop(), loader)
+	assert.NoError(t, err)
+	assert.Len(t, stages, 3)
+
+	for i, expected := range []error{block.ErrDropped, nil, nil} {
+		_, err = stages[i](block.NewRow(nil, 0))
+		assert.Equal(t, expected, err, i)
+	}
+}
+
+func TestPipeline_Sample(t *testing.T) {
+	run := func(stages []applyFunc, user string) bool {
+		row := block.NewRow(nil, 1)
+		row.Schema["user"] = typeof.String
+		row.Values["user"] = user
+		_, err := stages[0](row)
+		return err == nil
+	}
+
+	// Random sampling keeps roughly the rate
+	random, err := New("events", config.Table{Sample: &config.Sample{Rate: 0.1}}, monitor.NewNoop(), nil)
+	assert.NoError(t, err)
+	assert.Len(t, random, 1)
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if run(random, "") {
+			kept++
+		}
+	}
+	assert.InDelta(t, 1000, kept, 200)
+
+	// Consistent sampling always makes the same decision for a key
+	keyed, err := New("events", config.Table{Pipeline: []config.Stage{
+		{Sample: &config.Sample{Rate: 0.5, Key: "user"}},
+	}}, monitor.NewNoop(), nil)
+	assert.NoError(t, err)
+
+	kept = 0
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		decision := run(keyed, user)
+		for j := 0; j < 5; j++ {
+			assert.Equal(t, decision, run(keyed, user))
+		}
+		if decision {
+			kept++
+		}
+	}
+	assert.InDelta(t, 500, kept, 100)
+
+	// Invalid rate
+	invalid, err := New("events", config.Table{Sample: &config.Sample{Rate: 2}}, monitor.NewNoop(), nil)
+	assert.NoError(t, err)
+	assert.Len(t, invalid, 0)
+}
+
+func TestPipeline_Timestamps(t *testing.T) {
+	stages, err := New("events", config.Table{
+		Filter: `
+		function main(row)
+			return row["time"] == nil or row["time"] > 1577836800
+		end`,
+		Timestamps: config.Timestamps{
+			"time":  {Formats: []string{"rfc3339", "unixmilli"}},
+			"other": {Timezone: "Mars/Olympus"},
+		},
+	}, monitor.NewNoop(), script.NewLoader(nil))
+	assert.NoError(t, err)
+	assert.Len(t, stages, 2) // The invalid timestamp column is skipped
+
+	run := func(v interface{}) (block.Row, error) {
+		row := block.NewRow(nil, 1)
+		row.Set("time", v)
+
+		var err error
+		for _, stage := range stages {
+			if row, err = stage(row); err != nil {
+				return row, err
+			}
+		}
+		return row, nil
+	}
+
+	// The timestamps are parsed before the other stages see them
+	for _, v := range []interface{}{"2020-01-01T00:00:01Z", "1577836801000", int64(1577836801000)} {
+		row, err := run(v)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1577836801), row.Values["time"])
+		assert.Equal(t, typeof.Int64, row.Schema["time"])
+	}
+
+	_, err = run("2020-01-01T00:00:00Z")
+	assert.Equal(t, block.ErrDropped, err)
+
+	// The value which can not be parsed is removed, but the row is kept
+	row, err := run("yesterday")
+	assert.NoError(t, err)
+	assert.NotContains(t, row.Values, "time")
+}
@@ -0,0 +1,182 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/timestamp"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// filter drops the rows for which the predicate returns false
+func filter(predicate column.Computed) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		keep, err := predicate.Value(r.Values)
+		switch {
+		case err != nil:
+			return r, err
+		case keep == false:
+			return r, block.ErrDropped
+		default:
+			return r, nil
+		}
+	}
+}
+
+// sample keeps a fraction of the rows. If a key is specified, the decision is made on the hash of its
+// value so that all of the rows with the same key are either kept or dropped, otherwise it is random.
+func sample(rate float64, key string) applyFunc {
+	threshold := uint64(rate * math.MaxUint64)
+	if rate >= 1 {
+		threshold = math.MaxUint64
+	}
+
+	return func(r block.Row) (block.Row, error) {
+		var h uint64
+		if v, ok := r.Values[key]; ok && key != "" && v != nil {
+			hash := fnv.New64a()
+			_, _ = fmt.Fprintf(hash, "%v", v)
+			h = hash.Sum64()
+		} else {
+			h = rand.Uint64()
+		}
+
+		if h > threshold || rate <= 0 {
+			return r, block.ErrDropped
+		}
+		return r, nil
+	}
+}
+
+// parseTimestamps parses the timestamp columns in any of their formats. A value which matches none of them is
+// removed from the row, which is otherwise kept.
+func parseTimestamps(parsers map[string]*timestamp.Parser) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		var err error
+		for column, parser := range parsers {
+			v, ok := r.Values[column]
+			if !ok || v == nil {
+				continue
+			}
+
+			if parsed, ok := parser.Parse(v); ok {
+				r.Schema[column] = parser.Type()
+				r.Values[column] = parsed
+				continue
+			}
+
+			delete(r.Values, column)
+			err = fmt.Errorf("pipeline: unable to parse the timestamp %v of column %s", v, column)
+		}
+		return r, err
+	}
+}
+
+// compute adds the computed column(s) to the row, overwriting the columns with the same name
+func compute(c column.Computed) applyFunc {
+	if m, ok := c.(column.Multi); ok {
+		return func(r block.Row) (block.Row, error) {
+			values, err := m.Values(r.Values)
+			if err != nil {
+				return r, err
+			}
+
+			schema := m.Schema()
+			for name, v := range values {
+				r.Schema[name] = schema[name]
+				r.Values[name] = v
+			}
+			return r, nil
+		}
+	}
+
+	return func(r block.Row) (block.Row, error) {
+		v, err := c.Value(r.Values)
+		if err != nil || v == nil {
+			return r, err
+		}
+
+		r.Schema[c.Name()] = c.Type()
+		r.Values[c.Name()] = v
+		return r, nil
+	}
+}
+
+// flatten replaces the JSON columns containing an object with a column for each of its fields, named
+// after the path to the field and joined with the separator.
+func flatten(separator string) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		var columns []string
+		for name, typ := range r.Schema {
+			if _, ok := r.Values[name]; ok && typ == typeof.JSON {
+				columns = append(columns, name)
+			}
+		}
+
+		for _, name := range columns {
+			var b []byte
+			switch v := r.Values[name].(type) {
+			case json.RawMessage:
+				b = v
+			case []byte:
+				b = v
+			case string:
+				b = []byte(v)
+			default:
+				continue
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(b))
+			decoder.UseNumber()
+
+			var object map[string]interface{}
+			if err := decoder.Decode(&object); err != nil {
+				continue // Not an object, keep the column as it is
+			}
+
+			delete(r.Values, name)
+			delete(r.Schema, name)
+			flattenInto(r, name, separator, object)
+		}
+		return r, nil
+	}
+}
+
+// flattenInto sets the fields of the object as columns of the row, recursively
+func flattenInto(r block.Row, prefix, separator string, object map[string]interface{}) {
+	for k, v := range object {
+		name := prefix + separator + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenInto(r, name, separator, v)
+		case string:
+			r.Schema[name] = typeof.String
+			r.Values[name] = v
+		case bool:
+			r.Schema[name] = typeof.Bool
+			r.Values[name] = v
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				r.Schema[name] = typeof.Int64
+				r.Values[name] = i
+			} else if f, err := v.Float64(); err == nil {
+				r.Schema[name] = typeof.Float64
+				r.Values[name] = f
+			}
+		case []interface{}:
+			if b, err := json.Marshal(v); err == nil {
+				r.Schema[name] = typeof.JSON
+				r.Values[name] = json.RawMessage(b)
+			}
+		}
+	}
+}
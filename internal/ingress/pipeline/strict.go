@@ -0,0 +1,138 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// The columns added to the rejected rows, with the reason and the class of the error
+const (
+	ErrorColumn      = "_error"
+	ErrorClassColumn = "_error_class"
+)
+
+// The classes of the errors the rows are rejected for
+const (
+	classMissing = "missing"
+	classType    = "type"
+)
+
+const strictRejectedKey = "strict.rejected"
+
+// Strict creates the stage enforcing the schema of a table on the rows as they are decoded. A row which misses
+// a required column, or has a value which can not be converted to the type of its column in the schema, is
+// dropped and given to the reject function with the reason of the error. The other values are converted.
+func Strict(table string, conf *config.Strict, schema typeof.Schema, reject func(block.Row) error, monitor monitor.Monitor) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		class, reason := enforce(r, conf.Required, schema)
+		if class == "" {
+			return r, nil
+		}
+
+		monitor.Count1(ctxTag, strictRejectedKey, "table:"+table, "class:"+class)
+		if reject != nil {
+			if err := reject(annotate(r, class, reason)); err != nil {
+				monitor.Warning(errors.Internal("pipeline: unable to route a rejected row", err,
+					errors.WithTag("table", table)))
+			}
+		}
+		return r, block.ErrDropped
+	}
+}
+
+// enforce checks a row against the required columns and the schema, converting its values to their type. It
+// returns the class and the reason of the first error found, the row being left unchanged in that case.
+func enforce(r block.Row, required []string, schema typeof.Schema) (class, reason string) {
+	for _, column := range required {
+		if v, ok := r.Values[column]; !ok || v == nil {
+			return classMissing, fmt.Sprintf("column %s is required", column)
+		}
+	}
+
+	// Convert the values in a deterministic order, so the same row is always rejected for the same reason
+	columns := make([]string, 0, len(r.Values))
+	for column := range r.Values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	converted := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		v := r.Values[column]
+		typ, ok := schema[column]
+		if !ok || v == nil {
+			continue
+		}
+
+		value, ok := conform(v, r.Schema[column], typ)
+		if !ok {
+			return classType, fmt.Sprintf("column %s is %s, but %v was ingested as %s", column, typ, v, r.Schema[column])
+		}
+		converted[column] = value
+	}
+
+	for column, value := range converted {
+		r.Schema[column] = schema[column]
+		r.Values[column] = value
+	}
+	return "", ""
+}
+
+// conform converts a value to a type, only when no information is lost: strings are parsed, integers are
+// widened and floats without a fraction are kept as integers.
+func conform(v interface{}, from, to typeof.Type) (interface{}, bool) {
+	if from == to {
+		return v, true
+	}
+
+	switch v := v.(type) {
+	case string:
+		return typeof.Parse(v, to)
+	case int32:
+		switch to {
+		case typeof.Int64:
+			return int64(v), true
+		case typeof.Float64:
+			return float64(v), true
+		}
+	case int64:
+		switch to {
+		case typeof.Int32:
+			if v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int32(v), true
+			}
+		case typeof.Float64:
+			return float64(v), true
+		}
+	case float64:
+		if to == typeof.Int64 && v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true
+		}
+	}
+	return nil, false
+}
+
+// annotate returns a copy of a rejected row, with the reason and the class of the error
+func annotate(r block.Row, class, reason string) block.Row {
+	out := block.NewRow(nil, len(r.Values)+2)
+	for k, v := range r.Values {
+		out.Schema[k] = r.Schema[k]
+		out.Values[k] = v
+	}
+
+	out.Schema[ErrorColumn] = typeof.String
+	out.Values[ErrorColumn] = reason
+	out.Schema[ErrorClassColumn] = typeof.String
+	out.Values[ErrorClassColumn] = class
+	return out
+}
@@ -0,0 +1,66 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	var rejected []block.Row
+	strict := Strict("events", &config.Strict{Required: []string{"event"}}, typeof.Schema{
+		"event": typeof.String,
+		"count": typeof.Int64,
+		"ratio": typeof.Float64,
+		"ok":    typeof.Bool,
+	}, func(r block.Row) error {
+		rejected = append(rejected, r)
+		return nil
+	}, monitor.NewNoop())
+
+	row := func(values map[string]interface{}) block.Row {
+		r := block.NewRow(nil, len(values))
+		for k, v := range values {
+			r.Set(k, v)
+		}
+		return r
+	}
+
+	// The values are converted to the types of the schema
+	out, err := strict(row(map[string]interface{}{"event": "click", "count": "2", "ratio": int64(1), "ok": "true", "other": 1.5}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), out.Values["count"])
+	assert.Equal(t, 1.0, out.Values["ratio"])
+	assert.Equal(t, true, out.Values["ok"])
+	assert.Equal(t, typeof.Float64, out.Schema["ratio"])
+	assert.Equal(t, 1.5, out.Values["other"])
+
+	// The floats without a fraction are kept as integers
+	out, err = strict(row(map[string]interface{}{"event": "click", "count": 3.0}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), out.Values["count"])
+	assert.Empty(t, rejected)
+
+	// The rows missing a required column are rejected
+	_, err = strict(row(map[string]interface{}{"count": int64(1)}))
+	assert.Equal(t, block.ErrDropped, err)
+	assert.Len(t, rejected, 1)
+	assert.Equal(t, "missing", rejected[0].Values[ErrorClassColumn])
+	assert.Equal(t, "column event is required", rejected[0].Values[ErrorColumn])
+
+	// The rows with a value which can not be converted are rejected, unchanged
+	_, err = strict(row(map[string]interface{}{"event": "click", "count": "2", "ratio": "high"}))
+	assert.Equal(t, block.ErrDropped, err)
+	assert.Len(t, rejected, 2)
+	assert.Equal(t, "type", rejected[1].Values[ErrorClassColumn])
+	assert.Equal(t, "column ratio is float64, but high was ingested as string", rejected[1].Values[ErrorColumn])
+	assert.Equal(t, "2", rejected[1].Values["count"])
+	assert.Equal(t, typeof.String, rejected[1].Schema[ErrorColumn])
+}
@@ -0,0 +1,183 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package replay
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/kelindar/loader"
+	s3loader "github.com/kelindar/loader/s3"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "replay"
+
+// Downloader represents an object downloader
+type Downloader interface {
+	Load(ctx context.Context, uri string) ([]byte, error)
+}
+
+// Lister represents a lister of the objects under a prefix, returning their URIs
+type Lister interface {
+	List(ctx context.Context, prefix *url.URL) ([]string, error)
+}
+
+// Ingress represents an ingress re-reading the files previously flushed to a sink, such as to rebuild the hot
+// store of a node after a disaster or a migration of the data format.
+type Ingress struct {
+	listers map[string]Lister // The listers, by scheme of the source
+	loader  Downloader        // The downloader of the files
+	monitor monitor.Monitor   // The monitor to use
+}
+
+// New creates a new ingress replaying the files of the local filesystem or of S3, in the region.
+func New(region string, monitor monitor.Monitor) (*Ingress, error) {
+	conf := aws.NewConfig().WithMaxRetries(5)
+	if region != "" {
+		conf = conf.WithRegion(region)
+	}
+
+	sess, err := session.NewSession(conf)
+	if err != nil {
+		return nil, errors.Internal("replay: unable to create the AWS session", err)
+	}
+
+	return NewWith(map[string]Lister{
+		"file": new(fileLister),
+		"s3":   &s3Lister{client: s3.New(sess)},
+	}, loader.New(loader.WithS3Client(s3loader.NewFromSession(sess))), monitor), nil
+}
+
+// NewWith creates a new ingress with the listers, by scheme of the source, and the downloader.
+func NewWith(listers map[string]Lister, loader Downloader, monitor monitor.Monitor) *Ingress {
+	return &Ingress{
+		listers: listers,
+		loader:  loader,
+		monitor: monitor,
+	}
+}
+
+// Range lists the ORC and Parquet files under the source, such as "s3://bucket/prefix/" or "file:///data/",
+// and calls f with each of them in the order of their names. The files which can not be downloaded or which
+// f rejects are skipped and reported, so a single corrupted file does not abort the replay. It returns the
+// number of files replayed.
+func (r *Ingress) Range(ctx context.Context, source string, f func(name string, data []byte) error) (int, error) {
+	prefix, err := url.Parse(source)
+	if err != nil {
+		return 0, errors.Internal("replay: invalid source "+source, err)
+	}
+
+	lister, ok := r.listers[strings.ToLower(prefix.Scheme)]
+	if !ok {
+		return 0, errors.Newf("replay: unsupported source scheme %s", prefix.Scheme)
+	}
+
+	names, err := lister.List(ctx, prefix)
+	if err != nil {
+		return 0, errors.Internal("replay: unable to list "+source, err)
+	}
+
+	sort.Strings(names)
+	replayed, failed := 0, 0
+	for _, name := range names {
+		if !isReplayable(name) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		data, err := r.loader.Load(ctx, name)
+		if err == nil {
+			err = f(name, data)
+		}
+
+		if err != nil {
+			failed++
+			r.monitor.Count1(ctxTag, "error")
+			r.monitor.Warning(errors.Internal("replay: unable to replay "+name, err))
+			continue
+		}
+
+		replayed++
+		r.monitor.Count1(ctxTag, "file")
+		r.monitor.Count(ctxTag, "bytes", int64(len(data)))
+	}
+
+	if failed > 0 {
+		return replayed, errors.Newf("replay: unable to replay %d of %d files", failed, failed+replayed)
+	}
+	return replayed, nil
+}
+
+// isReplayable returns whether a file was written by a sink, skipping the files being written and the
+// manifests listing them.
+func isReplayable(name string) bool {
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if strings.HasPrefix(dir, "_") {
+			return false
+		}
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".orc", ".parquet":
+		return true
+	default:
+		return false
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// fileLister lists the files of a directory of the local filesystem, recursively. As with the loader, the
+// first slash of the path is removed, so "file:///data" is relative to the working directory.
+type fileLister struct{}
+
+// List lists the files under the directory
+func (fileLister) List(_ context.Context, prefix *url.URL) ([]string, error) {
+	var names []string
+	dir := filepath.FromSlash(strings.TrimPrefix(prefix.Path, "/"))
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		names = append(names, "file:///"+filepath.ToSlash(name))
+		return nil
+	})
+	return names, err
+}
+
+// s3Lister lists the objects of an S3 bucket
+type s3Lister struct {
+	client s3iface.S3API
+}
+
+// List lists the objects under the prefix
+func (l *s3Lister) List(ctx context.Context, prefix *url.URL) ([]string, error) {
+	var names []string
+	bucket := prefix.Host
+	err := l.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(strings.TrimLeft(prefix.Path, "/")),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			names = append(names, "s3://"+bucket+"/"+aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return names, err
+}
@@ -0,0 +1,61 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package replay
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelindar/loader"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	orc, err := ioutil.ReadFile("../../../test/test2.orc")
+	assert.NoError(t, err)
+	for _, name := range []string{
+		"dt=2020-01-02/b.orc",
+		"dt=2020-01-01/a.parquet",
+		"dt=2020-01-01/_manifest.json",
+		"_temporary/dt=2020-01-03/c.orc",
+		"dt=2020-01-02/corrupted.orc",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), orc, 0644))
+	}
+
+	// Only the files flushed by the sinks are replayed, in the order of their names
+	var replayed []string
+	r := NewWith(map[string]Lister{"file": new(fileLister)}, loader.New(), monitor.NewNoop())
+	n, err := r.Range(context.Background(), "file:///"+filepath.ToSlash(dir), func(name string, data []byte) error {
+		assert.Equal(t, orc, data)
+		if filepath.Base(name) == "corrupted.orc" {
+			return errors.New("corrupted")
+		}
+
+		replayed = append(replayed, name)
+		return nil
+	})
+
+	// The corrupted file is skipped and reported
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{
+		"file:///" + filepath.ToSlash(dir) + "/dt=2020-01-01/a.parquet",
+		"file:///" + filepath.ToSlash(dir) + "/dt=2020-01-02/b.orc",
+	}, replayed)
+
+	// The source must be supported
+	_, err = r.Range(context.Background(), "gs://bucket/prefix", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package s3sqs
+
+import (
+	"context"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	s3writer "github.com/kelindar/talaria/internal/storage/writer/s3"
+)
+
+const maxPooledBuffer = 64 << 20 // The buffers larger than 64MB are not kept in the pool
+
+// downloader represents a downloader of the objects of S3, into buffers which are reused once the objects are
+// ingested so that draining a backlog does not allocate a new buffer for every object.
+type downloader struct {
+	client    *s3manager.Downloader
+	buffers   sync.Pool
+	allocated uint64 // The number of buffers allocated, since the pool was empty
+}
+
+// newDownloader creates a downloader, from the custom endpoint of an S3-compatible store if any
+func newDownloader(conf *config.S3SQS, region string) (*downloader, error) {
+	awsconf := aws.NewConfig().WithRegion(region).WithMaxRetries(5)
+	if conf.Endpoint != "" || conf.CACert != "" {
+		endpoint := s3writer.Endpoint{
+			URL:        conf.Endpoint,
+			PathStyle:  conf.PathStyle,
+			DisableSSL: conf.DisableSSL,
+			CACert:     conf.CACert,
+		}
+
+		if err := endpoint.Apply(awsconf); err != nil {
+			return nil, err
+		}
+	}
+
+	sess, err := session.NewSession(awsconf)
+	if err != nil {
+		return nil, errors.Internal("s3sqs: unable to create the S3 client", err)
+	}
+
+	d := &downloader{
+		client: s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+			d.Concurrency = runtime.NumCPU() * 4
+		}),
+	}
+	d.buffers.New = func() interface{} {
+		atomic.AddUint64(&d.allocated, 1)
+		return new([]byte)
+	}
+	return d, nil
+}
+
+// Load downloads an object, such as "s3://bucket/key", into a buffer of the pool.
+func (d *downloader) Load(ctx context.Context, uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := d.buffers.Get().(*[]byte)
+	w := aws.NewWriteAtBuffer((*buffer)[:0])
+	n, err := d.client.DownloadWithContext(ctx, w, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	if err != nil {
+		d.buffers.Put(buffer)
+		return nil, err
+	}
+
+	return w.Bytes()[:n], nil
+}
+
+// Release returns the buffer of an object to the pool, once the object is ingested.
+func (d *downloader) Release(b []byte) {
+	if cap(b) <= maxPooledBuffer {
+		b = b[:0]
+		d.buffers.Put(&b)
+	}
+}
+
+// Allocated returns the number of buffers allocated, since the pool was empty.
+func (d *downloader) Allocated() uint64 {
+	return atomic.LoadUint64(&d.allocated)
+}
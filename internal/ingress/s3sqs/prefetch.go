@@ -0,0 +1,78 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package s3sqs
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/kelindar/talaria/internal/config"
+	"golang.org/x/sync/semaphore"
+)
+
+const defaultPrefetchBytes = 256 << 20 // The default maximum size of the objects downloaded ahead, 256MB
+
+// prefetcher bounds the objects downloaded ahead of their ingestion, both by their number and by their size as
+// announced by the notifications, so that more of the small objects are downloaded ahead than of the large ones.
+type prefetcher struct {
+	count    int64               // The maximum number of objects waiting for a worker
+	maxBytes int64               // The maximum size of the objects downloaded and not ingested yet, in bytes
+	waiting  *semaphore.Weighted // The objects downloaded, or being downloaded, and waiting for a worker
+	bytes    *semaphore.Weighted // The size of the objects downloaded and not ingested yet
+}
+
+// newPrefetcher creates a new prefetcher from the configuration
+func newPrefetcher(conf *config.Prefetch) *prefetcher {
+	count, maxBytes := int64(conf.Count), conf.MaxBytes
+	if count <= 0 {
+		count = int64(runtime.NumCPU())
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultPrefetchBytes
+	}
+
+	return &prefetcher{
+		count:    count,
+		maxBytes: maxBytes,
+		waiting:  semaphore.NewWeighted(count),
+		bytes:    semaphore.NewWeighted(maxBytes),
+	}
+}
+
+// Acquire waits until an object of the size can be downloaded ahead, and returns the weight to release once it
+// is ingested. An object of unknown size weights a single byte, and one larger than the budget the whole budget.
+func (p *prefetcher) Acquire(ctx context.Context, size int64) (int64, error) {
+	weight := size
+	switch {
+	case weight < 1:
+		weight = 1
+	case weight > p.maxBytes:
+		weight = p.maxBytes
+	}
+
+	if err := p.waiting.Acquire(ctx, 1); err != nil {
+		return 0, err
+	}
+
+	if err := p.bytes.Acquire(ctx, weight); err != nil {
+		p.waiting.Release(1)
+		return 0, err
+	}
+	return weight, nil
+}
+
+// Started releases the slot of an object once a worker starts ingesting it, so that another one can be downloaded
+func (p *prefetcher) Started() {
+	p.waiting.Release(1)
+}
+
+// Release releases the budget of an object once it is ingested
+func (p *prefetcher) Release(weight int64) {
+	p.bytes.Release(weight)
+}
+
+// Wait waits until every object downloaded ahead was handed over to a worker
+func (p *prefetcher) Wait() {
+	_ = p.waiting.Acquire(context.Background(), p.count)
+}
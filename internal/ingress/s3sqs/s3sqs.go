@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/url"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	awssqs "github.com/aws/aws-sdk-go/service/sqs"
@@ -17,23 +18,32 @@ import (
 	"github.com/kelindar/talaria/internal/ingress/s3sqs/sqs"
 	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/monitor/errors"
-	"github.com/kelindar/loader"
+	"github.com/kelindar/talaria/internal/monitor/logging"
 	"golang.org/x/sync/semaphore"
 )
 
 const (
-	ctxTag = "s3sqs"
+	ctxTag          = "s3sqs"
+	defaultInterval = 30 * time.Second       // The default interval at which the depth of the queue is measured
+	pauseInterval   = 100 * time.Millisecond // The interval at which the backpressure is checked while paused
 )
 
 var concurrency = int64(runtime.NumCPU() * 3)
 
 // Ingress represents an ingress layer.
 type Ingress struct {
-	sqs     Reader              // The SQS reader to use.
-	loader  Downloader          // The S3 downloader to use.
-	monitor monitor.Monitor     // The monitor to use.
-	cancel  context.CancelFunc  // The cancellation function to apply at the end.
-	limit   *semaphore.Weighted // The limit of workers
+	sqs      Reader              // The SQS reader to use.
+	loader   Downloader          // The S3 downloader to use.
+	monitor  monitor.Monitor     // The monitor to use.
+	cancel   context.CancelFunc  // The cancellation function to apply at the end.
+	limit    *semaphore.Weighted // The limit of workers
+	interval time.Duration       // The interval at which the depth of the queue is measured
+	inflight int64               // The size of the objects downloaded and not ingested yet, in bytes
+	ahead    int64               // The number of objects downloaded ahead and waiting for a worker
+	limits   config.Backpressure // The thresholds above which the queue is no longer polled
+	buffered func() int64        // The function measuring the size of the data not flushed yet (optional)
+	paused   func() bool         // The function deciding whether the ingestion is paused (optional)
+	prefetch *prefetcher         // The objects downloaded ahead of their ingestion (optional)
 }
 
 // Downloader represents an object downloader
@@ -41,6 +51,12 @@ type Downloader interface {
 	Load(ctx context.Context, uri string) ([]byte, error)
 }
 
+// releaser represents a downloader which reuses the buffers of the downloaded objects once they are ingested
+type releaser interface {
+	Release(b []byte)
+	Allocated() uint64
+}
+
 // Reader represents a consumer for SQS
 type Reader interface {
 	io.Closer
@@ -50,27 +66,60 @@ type Reader interface {
 
 // New creates a new ingestion with SQS/S3 files.
 func New(conf *config.S3SQS, region string, monitor monitor.Monitor) (*Ingress, error) {
-	loader := loader.New()
+	loader, err := newDownloader(conf, region)
+	if err != nil {
+		return nil, err
+	}
+
 	reader, err := sqs.NewReader(conf, region)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewWith(reader, loader, monitor), nil
+	ingress := NewWith(reader, loader, monitor)
+	if conf.MetricsInterval > 0 {
+		ingress.interval = time.Duration(conf.MetricsInterval) * time.Second
+	}
+	if conf.Backpressure != nil {
+		ingress.limits = *conf.Backpressure
+	}
+	if conf.Prefetch != nil {
+		ingress.prefetch = newPrefetcher(conf.Prefetch)
+	}
+	return ingress, nil
 }
 
 // NewWith creates a new ingestion with SQS/S3 files.
 func NewWith(reader Reader, loader Downloader, monitor monitor.Monitor) *Ingress {
 	return &Ingress{
-		sqs:     reader,
-		loader:  loader,
-		monitor: monitor,
-		limit:   semaphore.NewWeighted(concurrency),
+		sqs:      reader,
+		loader:   loader,
+		monitor:  monitor,
+		limit:    semaphore.NewWeighted(concurrency),
+		interval: defaultInterval,
 	}
 }
 
+// SetBuffered sets the function measuring the size of the data ingested but not flushed to the sinks yet, in
+// bytes, so that the queue is no longer polled while the flush falls behind.
+func (s *Ingress) SetBuffered(buffered func() int64) {
+	s.buffered = buffered
+}
+
+// SetPaused sets the function deciding whether the queue should no longer be polled, such as while the node
+// is running low on memory.
+func (s *Ingress) SetPaused(paused func() bool) {
+	s.paused = paused
+}
+
+// InFlight returns the size of the objects downloaded and not ingested yet, in bytes.
+func (s *Ingress) InFlight() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
 // Range iterates through the queue, stops only if Close() is called or the f callback
-// returns true.
+// returns true. The downloaded payload may be reused once the callback returns, so it
+// must not be retained.
 func (s *Ingress) Range(f func(v []byte) bool) {
 
 	// Create a cancellation context
@@ -80,12 +129,49 @@ func (s *Ingress) Range(f func(v []byte) bool) {
 	// Start draining the queue, asynchronously
 	queue := s.sqs.StartPolling(1, 100, nil, nil)
 	go s.drain(ctx, queue, f)
+
+	// Periodically measure the depth of the queue, if supported by the reader
+	if reader, ok := s.sqs.(depthReader); ok {
+		go s.measure(ctx, reader)
+	}
+}
+
+// depthReader represents a reader which can measure the depth of the queue
+type depthReader interface {
+	Depth() (sqs.Depth, error)
+}
+
+// measure periodically reports the number of messages waiting and in flight in the queue
+func (s *Ingress) measure(ctx context.Context, reader depthReader) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			depth, err := reader.Depth()
+			if err != nil {
+				s.monitor.Count1(ctxTag, "depth.error")
+				s.monitor.Warning(err)
+				continue
+			}
+
+			s.monitor.Gauge(ctxTag, "queue.visible", float64(depth.Visible))
+			s.monitor.Gauge(ctxTag, "queue.inflight", float64(depth.InFlight))
+			s.monitor.Gauge(ctxTag, "queue.delayed", float64(depth.Delayed))
+		}
+	}
 }
 
 // drains files from SQS
 func (s *Ingress) drain(ctx context.Context, queue <-chan *awssqs.Message, handler func(v []byte) bool) {
 	const tag = "drain"
 	for {
+		if !s.await(ctx) {
+			return
+		}
+
 		select {
 		case <-ctx.Done():
 			return
@@ -111,7 +197,18 @@ func (s *Ingress) drain(ctx context.Context, queue <-chan *awssqs.Message, handl
 				bucket := event.S3.Bucket.Name
 				key, err := url.QueryUnescape(event.S3.Object.Key)
 				if err != nil {
-					s.monitor.Error(errors.Internal("sqs: unable to unescape query", err))
+					s.monitor.Error(errors.Internal("sqs: unable to unescape query", err, errors.WithTag("bucket", bucket)))
+					continue
+				}
+
+				// Download the object ahead while the workers are busy, if prefetching
+				if s.prefetch != nil {
+					weight, err := s.prefetch.Acquire(ctx, int64(event.S3.Object.Size))
+					if err != nil {
+						continue
+					}
+
+					go s.ingestAhead(bucket, key, weight, handler)
 					continue
 				}
 
@@ -126,6 +223,38 @@ func (s *Ingress) drain(ctx context.Context, queue <-chan *awssqs.Message, handl
 	}
 }
 
+// await waits while the data downloaded or not flushed exceeds the thresholds, so the messages are left in the
+// queue until the data is ingested and flushed. It returns false if the ingestion is closed while waiting.
+func (s *Ingress) await(ctx context.Context) bool {
+	if !s.pressured() {
+		return true
+	}
+
+	s.monitor.Count1(ctxTag, "backpressure")
+	ticker := time.NewTicker(pauseInterval)
+	defer ticker.Stop()
+	for s.pressured() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// pressured checks whether the data downloaded or not flushed exceeds the thresholds, or the ingestion is paused
+func (s *Ingress) pressured() bool {
+	if max := s.limits.MaxInFlight; max > 0 && atomic.LoadInt64(&s.inflight) >= max {
+		return true
+	}
+
+	if max := s.limits.MaxUnflushed; max > 0 && s.buffered != nil && s.buffered() >= max {
+		return true
+	}
+	return s.paused != nil && s.paused()
+}
+
 // Acknowledge deletes the message from SQS
 func (s *Ingress) acknowledge(msg *awssqs.Message) error {
 	if msg.ReceiptHandle == nil {
@@ -142,18 +271,70 @@ func (s *Ingress) acknowledge(msg *awssqs.Message) error {
 // payload. Few of these can be executed in parallel.
 func (s *Ingress) ingest(bucket, key string, handler func(v []byte) bool) {
 	defer s.monitor.Duration(ctxTag, "s3sqs", time.Now())
+	defer s.limit.Release(1)
+
+	if data, ok := s.download(bucket, key); ok {
+		s.handle(data, handler)
+	}
+}
+
+// ingestAhead downloads an object from S3 right away, then waits for a worker to apply the handler to the
+// downloaded payload, hiding the latency of the download while the workers are busy.
+func (s *Ingress) ingestAhead(bucket, key string, weight int64, handler func(v []byte) bool) {
+	defer s.monitor.Duration(ctxTag, "s3sqs", time.Now())
+	defer s.prefetch.Release(weight)
+
+	data, ok := s.download(bucket, key)
+	if !ok {
+		s.prefetch.Started()
+		return
+	}
+
+	// Wait for a worker, the payload being counted as in flight meanwhile
+	s.monitor.Gauge(ctxTag, "prefetched", float64(atomic.AddInt64(&s.ahead, 1)))
+	_ = s.limit.Acquire(context.Background(), 1)
+	atomic.AddInt64(&s.ahead, -1)
+	s.prefetch.Started()
 
-	data, err := s.loader.Load(context.Background(), fmt.Sprintf("s3://%s/%s", bucket, key))
 	defer s.limit.Release(1)
+	s.handle(data, handler)
+}
+
+// download downloads an object from S3, counting its payload as in flight until it is handled
+func (s *Ingress) download(bucket, key string) ([]byte, bool) {
+	data, err := s.loader.Load(context.Background(), fmt.Sprintf("s3://%s/%s", bucket, key))
 	if err != nil {
-		s.monitor.Error(err)
-		return
+		s.monitor.Error(errors.Internal("sqs: unable to download", err,
+			errors.WithTag("bucket", bucket),
+			errors.WithTag("key", key)))
+		return nil, false
 	}
 
-	//s.monitor.Info("sqs: downloading %v", key)
+	s.monitor.Log(logging.LevelDebug, "sqs: downloaded",
+		logging.F("bucket", bucket),
+		logging.F("key", key),
+		logging.F("size", len(data)))
+
+	s.monitor.Gauge(ctxTag, "inflight", float64(atomic.AddInt64(&s.inflight, int64(len(data)))))
+	return data, true
+}
 
-	// Call the handler
+// handle calls the handler and reuses the buffer of the payload, if the downloader supports it
+func (s *Ingress) handle(data []byte, handler func(v []byte) bool) {
 	_ = handler(data)
+	atomic.AddInt64(&s.inflight, -int64(len(data)))
+	if r, ok := s.loader.(releaser); ok {
+		r.Release(data)
+		s.monitor.Gauge(ctxTag, "buffer.allocated", float64(r.Allocated()))
+	}
+}
+
+// Check checks whether the queue is being consumed, if the reader supports the check.
+func (s *Ingress) Check(ctx context.Context) error {
+	if checker, ok := s.sqs.(interface{ Check() error }); ok {
+		return checker.Check()
+	}
+	return nil
 }
 
 // Close stops consuming
@@ -161,7 +342,10 @@ func (s *Ingress) Close() {
 	s.cancel()
 	s.sqs.Close()
 
-	// Wait for ingestion to finish ...
+	// Wait for the objects downloaded ahead to be handed over, then for ingestion to finish ...
+	if s.prefetch != nil {
+		s.prefetch.Wait()
+	}
 	_ = s.limit.Acquire(context.Background(), concurrency)
 	return
 }
@@ -5,11 +5,16 @@ package s3sqs
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/ingress/s3sqs/sqs"
 	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -48,6 +53,145 @@ func TestQueueReader(t *testing.T) {
 	wg.Wait()
 }
 
+func TestBackpressure(t *testing.T) {
+	ingress := NewWith(new(MockReader), nil, monitor.NewNoop())
+	ingress.limits = config.Backpressure{MaxInFlight: 100, MaxUnflushed: 1000}
+	assert.False(t, ingress.pressured())
+
+	// Paused while the downloaded objects are being ingested
+	ingress.inflight = 100
+	assert.True(t, ingress.pressured())
+	ingress.inflight = 0
+
+	// Paused while the tables do not flush
+	var buffered int64 = 2000
+	ingress.SetBuffered(func() int64 {
+		return atomic.LoadInt64(&buffered)
+	})
+	assert.True(t, ingress.pressured())
+
+	// Resumed once flushed
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt64(&buffered, 0)
+	}()
+	assert.True(t, ingress.await(context.Background()))
+
+	// Stops waiting once closed
+	atomic.StoreInt64(&buffered, 2000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, ingress.await(ctx))
+}
+
+func TestPrefetch(t *testing.T) {
+	queue := make(chan *awssqs.Message, 4)
+	for i := 0; i < 4; i++ {
+		queue <- newMessage()
+	}
+
+	sqs := new(MockReader)
+	sqs.On("StartPolling", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return((<-chan *awssqs.Message)(queue))
+	sqs.On("DeleteMessage", mock.Anything).Return(nil)
+	sqs.On("Close").Return(nil)
+
+	var downloads int64
+	var s3 MockLoader = func(context.Context, string) ([]byte, error) {
+		atomic.AddInt64(&downloads, 1)
+		return []byte("data"), nil
+	}
+
+	// A single worker, with two objects downloaded ahead of it
+	defer func(n int64) { concurrency = n }(concurrency)
+	concurrency = 1
+	ingress := NewWith(sqs, s3, monitor.NewNoop())
+	ingress.prefetch = newPrefetcher(&config.Prefetch{Count: 2, MaxBytes: 1024})
+
+	var handled int64
+	unblock := make(chan struct{})
+	ingress.Range(func(v []byte) bool {
+		<-unblock
+		atomic.AddInt64(&handled, 1)
+		return false
+	})
+
+	// While the worker is busy, the next two objects are downloaded but not the last one
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&downloads) == 3
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&downloads))
+	assert.Equal(t, int64(12), ingress.InFlight())
+
+	// Every object is ingested once the worker is free
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&handled) == 4
+	}, time.Second, time.Millisecond)
+	ingress.Close()
+	assert.Equal(t, int64(0), ingress.InFlight())
+}
+
+func TestPrefetcher_Acquire(t *testing.T) {
+	p := newPrefetcher(&config.Prefetch{Count: 10, MaxBytes: 100})
+
+	// Unknown sizes weight a byte, large objects the whole budget
+	w1, err := p.Acquire(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), w1)
+	p.Started()
+	p.Release(w1)
+
+	w2, err := p.Acquire(context.Background(), 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), w2)
+
+	// No budget left until released
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.Acquire(ctx, 10)
+	assert.Error(t, err)
+
+	p.Started()
+	p.Release(w2)
+	w3, err := p.Acquire(context.Background(), 50)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), w3)
+}
+
+// depthMock is a reader which reports the depth of the queue
+type depthMock struct {
+	*MockReader
+}
+
+func (r *depthMock) Depth() (sqs.Depth, error) {
+	return sqs.Depth{Visible: 10, InFlight: 2}, nil
+}
+
+// gaugeMonitor records the gauges
+type gaugeMonitor struct {
+	monitor.Monitor
+	gauges chan string
+}
+
+func (m *gaugeMonitor) Gauge(contextTag, key string, value float64, tags ...string) {
+	m.gauges <- fmt.Sprintf("%s.%s=%v", contextTag, key, value)
+}
+
+func TestQueueDepth(t *testing.T) {
+	stats := &gaugeMonitor{Monitor: monitor.NewNoop(), gauges: make(chan string, 3)}
+	ingress := NewWith(&depthMock{MockReader: new(MockReader)}, nil, stats)
+	ingress.interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ingress.measure(ctx, &depthMock{})
+
+	assert.Equal(t, "s3sqs.queue.visible=10", <-stats.gauges)
+	assert.Equal(t, "s3sqs.queue.inflight=2", <-stats.gauges)
+	assert.Equal(t, "s3sqs.queue.delayed=0", <-stats.gauges)
+}
+
 func newMessage() *awssqs.Message {
 	evt := `{  
 		"Records":[  
@@ -90,3 +234,15 @@ func newMessage() *awssqs.Message {
 		Body: &evt,
 	}
 }
+
+func TestDownloader_Release(t *testing.T) {
+	d, err := newDownloader(&config.S3SQS{}, "ap-southeast-1")
+	assert.NoError(t, err)
+
+	// The released buffer is reused by the next download, unless it is too large to be kept
+	d.Release(make([]byte, 10, 1024))
+	assert.Equal(t, 1024, cap(*d.buffers.Get().(*[]byte)))
+	d.Release(make([]byte, 0, maxPooledBuffer+1))
+	assert.Equal(t, 0, cap(*d.buffers.Get().(*[]byte)))
+	assert.Equal(t, uint64(1), d.Allocated())
+}
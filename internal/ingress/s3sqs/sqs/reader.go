@@ -4,12 +4,15 @@
 package sqs
 
 import (
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor/errors"
 )
 
 const (
@@ -18,6 +21,7 @@ const (
 	maxMaxNumberOfMessages    = 10
 	minVisibilityTimeoutInSec = 0
 	maxVisibilityTimeoutInSec = 12 * 60 * 60 // 12 hour
+	maxPollDelay              = time.Minute  // The delay after which a reader which has not polled is considered stuck
 )
 
 // NewReader returns a reader
@@ -46,6 +50,7 @@ func NewReader(c *config.S3SQS, region string) (*Reader, error) {
 		visibilityTimeout: visibilityTimeout,
 		queueURL:          c.Queue,
 		waitTimeSeconds:   c.WaitTimeout,
+		polled:            time.Now().UnixNano(),
 	}, nil
 }
 
@@ -56,6 +61,8 @@ type Reader struct {
 	visibilityTimeout time.Duration
 	queueURL          string
 	waitTimeSeconds   int64 // The duration (in seconds) for which the call will wait for a message to arrive
+	polled            int64 // The time of the last successful poll, in unix nanoseconds
+	failure           atomic.Value
 }
 
 // StartPolling messages from SQS. User defines
@@ -100,10 +107,74 @@ func (r *Reader) receive(attributeNames, messageAttributeNames []*string, maxPer
 		WaitTimeSeconds:       &r.waitTimeSeconds,
 	}
 
-	resp, _ := r.sqs.ReceiveMessage(input)
+	resp, err := r.sqs.ReceiveMessage(input)
+	r.failure.Store(failure{err: err})
+	if err != nil {
+		return nil
+	}
+
+	atomic.StoreInt64(&r.polled, time.Now().UnixNano())
 	return resp.Messages
 }
 
+// Check returns an error if the last poll failed, or if the reader has not polled for a while, which
+// happens when the messages received are not consumed.
+func (r *Reader) Check() error {
+	if failure, ok := r.failure.Load().(failure); ok && failure.err != nil {
+		return errors.Internal("sqs: unable to receive", failure.err)
+	}
+
+	since := time.Since(time.Unix(0, atomic.LoadInt64(&r.polled)))
+	if since > maxPollDelay+time.Duration(r.waitTimeSeconds)*time.Second {
+		return errors.Newf("sqs: the queue was last polled %v ago", since.Truncate(time.Second))
+	}
+	return nil
+}
+
+// failure wraps the error of a poll, as an atomic value can not store nil
+type failure struct {
+	err error
+}
+
+// Depth represents the approximate number of messages in the queue
+type Depth struct {
+	Visible  int64 // The number of messages available for retrieval
+	InFlight int64 // The number of messages received but not deleted yet
+	Delayed  int64 // The number of messages not available for retrieval yet
+}
+
+// Depth returns the approximate number of messages in the queue
+func (r *Reader) Depth() (Depth, error) {
+	out, err := r.sqs.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: &r.queueURL,
+		AttributeNames: aws.StringSlice([]string{
+			sqs.QueueAttributeNameApproximateNumberOfMessages,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		}),
+	})
+	if err != nil {
+		return Depth{}, errors.Internal("sqs: unable to get the queue attributes", err)
+	}
+
+	return Depth{
+		Visible:  attributeOf(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessages),
+		InFlight: attributeOf(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+		Delayed:  attributeOf(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed),
+	}, nil
+}
+
+// attributeOf parses a numeric queue attribute, defaulting to zero
+func attributeOf(attributes map[string]*string, name string) int64 {
+	v, ok := attributes[name]
+	if !ok || v == nil {
+		return 0
+	}
+
+	n, _ := strconv.ParseInt(*v, 10, 64)
+	return n
+}
+
 // DeleteMessage from SQS (ack)
 func (r *Reader) DeleteMessage(msg *sqs.Message) error {
 	input := &sqs.DeleteMessageInput{
@@ -0,0 +1,313 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package inspect
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/storage/disk"
+)
+
+const manifest = "MANIFEST" // The file present in the directory of every table
+
+// Table represents the summary of the data stored locally for a table
+type Table struct {
+	Name   string    // The name of the table, which is the name of its directory
+	Size   int64     // The size of the table on disk, in bytes
+	Blocks int       // The number of blocks stored
+	Rows   int64     // The number of rows stored
+	From   time.Time // The time of the oldest block
+	Until  time.Time // The time of the newest block
+	Keys   []Key     // The keys of the table, by name
+	Errors int       // The number of blocks which could not be decoded
+}
+
+// Key represents the summary of the blocks of a key, which is a value of the column the table is hashed by
+type Key struct {
+	Name   string    // The value of the key
+	Blocks int       // The number of blocks stored
+	Rows   int64     // The number of rows stored
+	From   time.Time // The time of the oldest block
+	Until  time.Time // The time of the newest block
+}
+
+// Range represents the range of the blocks to dump
+type Range struct {
+	Key   string    // The value of the key, or every key if empty
+	From  time.Time // The time of the oldest block, or the beginning of time if zero
+	Until time.Time // The time of the newest block, or the end of time if zero
+}
+
+// Report returns a human-readable summary of the table, listing its keys if requested
+func (t *Table) Report(keys bool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "table:  %s\n", t.Name)
+	fmt.Fprintf(&sb, "size:   %d bytes on disk\n", t.Size)
+	fmt.Fprintf(&sb, "rows:   %d in %d block(s) of %d key(s)\n", t.Rows, t.Blocks, len(t.Keys))
+	if t.Blocks > 0 {
+		fmt.Fprintf(&sb, "time:   %s to %s\n", t.From.Format(time.RFC3339), t.Until.Format(time.RFC3339))
+	}
+	if t.Errors > 0 {
+		fmt.Fprintf(&sb, "errors: %d block(s) could not be decoded\n", t.Errors)
+	}
+
+	if keys {
+		w := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  KEY\tBLOCKS\tROWS\tFROM\tUNTIL")
+		for _, k := range t.Keys {
+			fmt.Fprintf(w, "  %s\t%d\t%d\t%s\t%s\n", k.Name, k.Blocks, k.Rows,
+				k.From.Format(time.RFC3339), k.Until.Format(time.RFC3339))
+		}
+		_ = w.Flush()
+	}
+	return sb.String()
+}
+
+// Tables returns the names of the tables stored in the data directory of a node.
+func Tables(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), manifest)); entry.IsDir() && err == nil {
+			out = append(out, entry.Name())
+		}
+	}
+	return out, nil
+}
+
+// Summarize opens a table read-only and summarizes the data stored locally. The time of a block is the time
+// of its oldest row, with a precision of a second.
+func Summarize(dir, table string) (*Table, error) {
+	store, err := open(dir, table)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	out := &Table{Name: table, Size: sizeOf(filepath.Join(dir, table))}
+	keys := make(map[string]*Key)
+	if err := store.Range(key.First(), key.Last(), func(k, v []byte) bool {
+		b, err := block.FromBuffer(v)
+		if err != nil {
+			out.Errors++
+			return false
+		}
+
+		rows, at := rowsOf(&b), timeOf(k)
+		summary, ok := keys[string(b.Key)]
+		if !ok {
+			summary = &Key{Name: string(b.Key), From: at, Until: at}
+			keys[summary.Name] = summary
+		}
+
+		summary.Blocks++
+		summary.Rows += rows
+		summary.From, summary.Until = earliest(summary.From, at), latest(summary.Until, at)
+		return false
+	}); err != nil {
+		return nil, err
+	}
+
+	// Sum up the keys, sorted by name
+	for _, k := range keys {
+		if len(out.Keys) == 0 {
+			out.From, out.Until = k.From, k.Until
+		}
+
+		out.Keys = append(out.Keys, *k)
+		out.Blocks += k.Blocks
+		out.Rows += k.Rows
+		out.From, out.Until = earliest(out.From, k.From), latest(out.Until, k.Until)
+	}
+
+	sort.Slice(out.Keys, func(i, j int) bool {
+		return out.Keys[i].Name < out.Keys[j].Name
+	})
+	return out, nil
+}
+
+// Blocks opens a table read-only and calls f with each block in the range, in the order of their keys, until
+// it returns an error.
+func Blocks(dir, table string, r Range, f func(block.Block) error) error {
+	store, err := open(dir, table)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	seek, until := r.keys()
+	if e := store.Range(seek, until, func(k, v []byte) bool {
+		if at := timeOf(k); !r.contains(at) {
+			return false
+		}
+
+		b, e := block.FromBuffer(v)
+		if e != nil {
+			err = fmt.Errorf("unable to decode a block of key %x: %w", k, e)
+			return true
+		}
+
+		// The blocks of different keys may share the same hash
+		if r.Key != "" && string(b.Key) != r.Key {
+			return false
+		}
+
+		err = f(b)
+		return err != nil
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// Dump opens a table read-only and writes the rows of the blocks in the range as newline-delimited JSON,
+// returning the number of rows written. A timestamp is written in RFC 3339 and a JSON column as is.
+func Dump(dir, table string, r Range, w io.Writer) (int64, error) {
+	encoder := json.NewEncoder(w)
+	count := int64(0)
+	err := Blocks(dir, table, r, func(b block.Block) error {
+		columns, err := b.Select(b.Schema())
+		if err != nil || len(columns) == 0 {
+			return err
+		}
+
+		rows := make([]map[string]interface{}, columns.Any().Count())
+		for i := range rows {
+			rows[i] = make(map[string]interface{}, len(columns))
+		}
+
+		for name, column := range columns {
+			kind := column.Kind()
+			_ = column.Range(0, column.Count(), func(i int, v interface{}) error {
+				rows[i][name] = jsonOf(kind, v)
+				return nil
+			})
+		}
+
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// keys returns the first and the last key of the range
+func (r *Range) keys() (key.Key, key.Key) {
+	seek, until := key.First(), key.Last()
+	if r.Key != "" {
+		seek, until = key.RangeOf(r.Key)
+	}
+
+	// Narrow down the time only within a single key, since the hash comes first
+	if r.Key != "" && !r.From.IsZero() {
+		binary.BigEndian.PutUint64(seek[4:12], uint64(r.From.Unix()))
+	}
+	if r.Key != "" && !r.Until.IsZero() {
+		binary.BigEndian.PutUint64(until[4:12], uint64(r.Until.Unix()))
+		binary.BigEndian.PutUint32(until[12:16], math.MaxUint32)
+	}
+	return seek, until
+}
+
+// contains checks whether the time of a block is within the range
+func (r *Range) contains(at time.Time) bool {
+	return (r.From.IsZero() || !at.Before(r.From.Truncate(time.Second))) &&
+		(r.Until.IsZero() || !at.After(r.Until))
+}
+
+// open opens the storage of a table, read-only
+func open(dir, table string) (*disk.Storage, error) {
+	if _, err := os.Stat(filepath.Join(dir, table, manifest)); err != nil {
+		return nil, fmt.Errorf("table %s was not found in %s", table, dir)
+	}
+
+	store := disk.New(monitor.NewNoop())
+	if err := store.OpenReadOnly(filepath.Join(dir, table)); err != nil {
+		if strings.Contains(err.Error(), "lock") {
+			return nil, fmt.Errorf("table %s is in use, the node must be stopped first: %w", table, err)
+		}
+		return nil, err
+	}
+	return store, nil
+}
+
+// rowsOf returns the number of rows of a block
+func rowsOf(b *block.Block) int64 {
+	for name, kind := range b.Schema() {
+		columns, err := b.Select(typeof.Schema{name: kind})
+		if err != nil {
+			return 0
+		}
+		return int64(columns[name].Count())
+	}
+	return 0
+}
+
+// timeOf returns the time of a key, in seconds
+func timeOf(k []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(k[4:12])), 0).UTC()
+}
+
+// jsonOf returns the value of a column as it should be encoded in JSON
+func jsonOf(kind typeof.Type, v interface{}) interface{} {
+	switch value := v.(type) {
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	case string:
+		if kind == typeof.JSON && json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+	}
+	return v
+}
+
+// sizeOf returns the size of the files of a directory, in bytes
+func sizeOf(dir string) (size int64) {
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// earliest returns the earliest of two times
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// latest returns the latest of two times
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,92 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package inspect
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "inspect")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// Append the blocks of two keys, the way a table does
+	store := disk.Open(dir, "events", monitor.NewNoop(), config.Badger{})
+	blocks, err := block.FromJSONBy([]byte(`[
+		{"event": "click", "time": 1600000000, "value": 1.5},
+		{"event": "click", "time": 1600000100, "value": 2.5},
+		{"event": "view", "time": 1600000200, "value": 3.5}
+	]`), "event", nil, block.Transform(nil))
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 2)
+	for _, b := range blocks {
+		at := time.Unix(1600000000, 0)
+		if string(b.Key) == "view" {
+			at = at.Add(time.Hour)
+		}
+
+		buffer, err := b.Encode()
+		assert.NoError(t, err)
+		assert.NoError(t, store.Append(key.New(string(b.Key), at), buffer, time.Hour))
+	}
+
+	// The table is in use until the node is stopped
+	_, err = Summarize(dir, "events")
+	assert.Error(t, err)
+	assert.NoError(t, store.Close())
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "catalog.json"), []byte("{}"), 0644))
+
+	tables, err := Tables(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"events"}, tables)
+
+	summary, err := Summarize(dir, "events")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Blocks)
+	assert.Equal(t, int64(3), summary.Rows)
+	assert.True(t, summary.Size > 0)
+	assert.Len(t, summary.Keys, 2)
+	assert.Equal(t, "click", summary.Keys[0].Name)
+	assert.Equal(t, int64(2), summary.Keys[0].Rows)
+	assert.Equal(t, "view", summary.Keys[1].Name)
+	assert.Equal(t, int64(1), summary.Keys[1].Rows)
+	assert.Equal(t, summary.Until.Sub(summary.From), time.Hour)
+	assert.Contains(t, summary.Report(true), "rows:   3 in 2 block(s) of 2 key(s)")
+	assert.Contains(t, summary.Report(true), "view")
+
+	// Dump every key, then a single one
+	var out bytes.Buffer
+	count, err := Dump(dir, "events", Range{}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 3)
+
+	out.Reset()
+	count, err = Dump(dir, "events", Range{Key: "view"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, `{"event":"view","time":1600000200,"value":3.5}`+"\n", out.String())
+
+	// Narrow down the time of the blocks
+	out.Reset()
+	count, err = Dump(dir, "events", Range{From: summary.Until}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = Summarize(dir, "unknown")
+	assert.Error(t, err)
+}
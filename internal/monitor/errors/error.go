@@ -41,6 +41,11 @@ func (r *Error) GRPC() codes.Code {
 	return codes.Unknown
 }
 
+// Tags returns the tags of the error, such as the table or the bucket.
+func (r *Error) Tags() []Tag {
+	return r.tags
+}
+
 // Error ...
 func (r *Error) Error() string {
 	return fmt.Sprintf("ServerError: target=%v, reason=%v, msg=%v", r.Target, r.Reason, r.Message)
@@ -70,6 +75,7 @@ func withError(code codes.Code, message string, err error, tags ...Tag) error {
 		Reason:  http.StatusText(status),
 		Target:  caller(3), // withError -> (eg. Internal) -> X
 		Message: message,
+		tags:    tags,
 	}
 }
 
@@ -82,6 +88,7 @@ func withMessage(code codes.Code, message string, tags ...Tag) *Error {
 		Reason:  http.StatusText(status),
 		Target:  caller(3), // makeError -> (eg. Internal) -> X
 		Message: message,
+		tags:    tags,
 	}
 }
 
@@ -90,8 +97,11 @@ func caller(skip int) string {
 	const watermark = "talaria/internal/"
 	_, fn, line, _ := runtime.Caller(skip)
 	idx := strings.LastIndex(fn, watermark)
-	if idx > 0 {
+	switch {
+	case idx >= 0:
 		idx += len(watermark)
+	default: // Called from outside of the internal packages, such as the embedded node
+		idx = 0
 	}
 
 	return fmt.Sprintf("%s.%d", fn[idx:], line)
@@ -18,6 +18,13 @@ func TestError(t *testing.T) {
 
 }
 
+func TestError_Tags(t *testing.T) {
+	err := Internal("test", nil, WithTag("table", "events")).(*Error)
+	assert.Len(t, err.Tags(), 1)
+	assert.Equal(t, "table", err.Tags()[0].Key())
+	assert.Equal(t, "events", err.Tags()[0].Value())
+}
+
 func newErr() *Error {
 	return withMessage(codes.Unavailable, "test")
 }
@@ -5,6 +5,7 @@ package errors
 
 import (
 	"fmt"
+	"net/http"
 
 	"google.golang.org/grpc/codes"
 )
@@ -21,7 +22,7 @@ func Newf(f string, args ...interface{}) error {
 
 // Internal ...
 func Internal(msg string, err error, tags ...Tag) error {
-	return withError(codes.Internal, msg, err)
+	return withError(codes.Internal, msg, err, tags...)
 }
 
 // InvalidArgument ...
@@ -54,6 +55,14 @@ func ResourceExhausted(msg string, tags ...Tag) error {
 	return withMessage(codes.ResourceExhausted, msg, tags...)
 }
 
+// TooLarge ...
+func TooLarge(msg string, tags ...Tag) error {
+	err := withMessage(codes.ResourceExhausted, msg, tags...)
+	err.http = http.StatusRequestEntityTooLarge
+	err.Reason = http.StatusText(err.http)
+	return err
+}
+
 // Unauthenticated ...
 func Unauthenticated(msg string, tags ...Tag) error {
 	return withMessage(codes.Unauthenticated, msg, tags...)
@@ -22,4 +22,5 @@ func TestErrors(t *testing.T) {
 	assert.Equal(t, `ServerError: target=monitor/errors/errors_test.go.22, reason=Service Unavailable, msg=boom`, Unavailable("boom").Error())
 	assert.Equal(t, `ServerError: target=monitor/errors/errors_test.go.23, reason=Gateway Timeout, msg=boom`, DeadlineExceeded("boom").Error())
 	assert.Equal(t, `ServerError: target=monitor/errors/errors_test.go.24, reason=, msg=boom`, Canceled("boom").Error())
+	assert.Equal(t, `ServerError: target=monitor/errors/errors_test.go.25, reason=Request Entity Too Large, msg=boom`, TooLarge("boom").Error())
 }
@@ -0,0 +1,101 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"fmt"
+)
+
+// Sugared is implemented by the leveled loggers taking loosely-typed key/value pairs, such as the
+// *zap.SugaredLogger.
+type Sugared interface {
+	Errorw(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Debugw(msg string, keysAndValues ...interface{})
+}
+
+// NewSugared returns a new logger that writes out the entries, with their fields, to a sugared logger. For
+// example, logging.NewSugared(zapLogger.Sugar()) logs through zap.
+func NewSugared(logger Sugared) Logger {
+	return &sugaredLogger{logger: logger}
+}
+
+// Implement the Logger interface
+type sugaredLogger struct {
+	logger Sugared
+}
+
+func (l *sugaredLogger) Errorf(f string, v ...interface{}) {
+	l.logger.Errorw(fmt.Sprintf(f, v...))
+}
+
+func (l *sugaredLogger) Warningf(f string, v ...interface{}) {
+	l.logger.Warnw(fmt.Sprintf(f, v...))
+}
+
+func (l *sugaredLogger) Infof(f string, v ...interface{}) {
+	l.logger.Infow(fmt.Sprintf(f, v...))
+}
+
+func (l *sugaredLogger) Debugf(f string, v ...interface{}) {
+	l.logger.Debugw(fmt.Sprintf(f, v...))
+}
+
+// Log writes out the entry with its fields
+func (l *sugaredLogger) Log(level Level, msg string, fields ...Field) {
+	kv := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		kv = append(kv, f.Key, f.Value)
+	}
+
+	switch level {
+	case LevelError:
+		l.logger.Errorw(msg, kv...)
+	case LevelWarning:
+		l.logger.Warnw(msg, kv...)
+	case LevelInfo:
+		l.logger.Infow(msg, kv...)
+	default:
+		l.logger.Debugw(msg, kv...)
+	}
+}
+
+// ------------------------------------------------------------------------------------------
+
+// Func writes out a structured entry, and adapts the loggers with a builder API, such as zerolog:
+//
+//	logging.Func(func(level logging.Level, msg string, fields []logging.Field) {
+//	    event := zlog.WithLevel(toZerolog(level))
+//	    for _, f := range fields {
+//	        event = event.Interface(f.Key, f.Value)
+//	    }
+//	    event.Msg(msg)
+//	})
+type Func func(level Level, msg string, fields []Field)
+
+// Errorf writes out an error message
+func (fn Func) Errorf(f string, v ...interface{}) {
+	fn(LevelError, fmt.Sprintf(f, v...), nil)
+}
+
+// Warningf writes out a warning message
+func (fn Func) Warningf(f string, v ...interface{}) {
+	fn(LevelWarning, fmt.Sprintf(f, v...), nil)
+}
+
+// Infof writes out an informational message
+func (fn Func) Infof(f string, v ...interface{}) {
+	fn(LevelInfo, fmt.Sprintf(f, v...), nil)
+}
+
+// Debugf writes out a debug message
+func (fn Func) Debugf(f string, v ...interface{}) {
+	fn(LevelDebug, fmt.Sprintf(f, v...), nil)
+}
+
+// Log writes out the entry with its fields
+func (fn Func) Log(level Level, msg string, fields ...Field) {
+	fn(level, msg, fields)
+}
@@ -35,3 +35,10 @@ func (c *compositeLogger) Debugf(f string, v ...interface{}) {
 		l.Debugf(f, v...)
 	}
 }
+
+// Log writes out the entry with its fields to every logger
+func (c *compositeLogger) Log(level Level, msg string, fields ...Field) {
+	for _, l := range c.loggers {
+		Log(l, level, msg, fields...)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// NewJSON returns a new logger that writes every entry as a line of JSON, with the "time", "level" and
+// "message" keys followed by its fields. The entries below the minimum level are discarded, and the
+// sampler, if set, limits the number of repeated entries.
+func NewJSON(w io.Writer, min Level, sampler *Sampler) Logger {
+	return &jsonLogger{
+		out:     w,
+		min:     min,
+		sampler: sampler,
+	}
+}
+
+// Implement the Logger interface
+type jsonLogger struct {
+	lock    sync.Mutex
+	out     io.Writer
+	min     Level
+	sampler *Sampler
+}
+
+func (l *jsonLogger) Errorf(f string, v ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(f, v...))
+}
+
+func (l *jsonLogger) Warningf(f string, v ...interface{}) {
+	l.Log(LevelWarning, fmt.Sprintf(f, v...))
+}
+
+func (l *jsonLogger) Infof(f string, v ...interface{}) {
+	l.Log(LevelInfo, fmt.Sprintf(f, v...))
+}
+
+func (l *jsonLogger) Debugf(f string, v ...interface{}) {
+	l.Log(LevelDebug, fmt.Sprintf(f, v...))
+}
+
+// Log writes out the entry with its fields
+func (l *jsonLogger) Log(level Level, msg string, fields ...Field) {
+	if !level.Enabled(l.min) || !l.sampler.Allow(level, msg) {
+		return
+	}
+
+	buffer := make([]byte, 0, 256)
+	buffer = append(buffer, `{"time":`...)
+	buffer = appendJSON(buffer, time.Now().UTC().Format(time.RFC3339Nano))
+	buffer = append(buffer, `,"level":`...)
+	buffer = appendJSON(buffer, string(level))
+	buffer = append(buffer, `,"message":`...)
+	buffer = appendJSON(buffer, msg)
+	for _, f := range fields {
+		buffer = append(buffer, ',')
+		buffer = appendJSON(buffer, f.Key)
+		buffer = append(buffer, ':')
+		buffer = appendJSON(buffer, f.Value)
+	}
+	buffer = append(buffer, '}', '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(buffer)
+}
+
+// appendJSON appends the value encoded as JSON, or its string representation if it can not be encoded
+func appendJSON(dst []byte, v interface{}) []byte {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return append(dst, encoded...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var out bytes.Buffer
+	l := NewJSON(&out, LevelInfo, nil)
+	l.Debugf("hidden %d", 1)
+	l.Infof("test %d", 1)
+	Log(l, LevelError, "unable to download", F("bucket", "b"), F("key", "a/b.orc"), F("size", 10), F("err", errors.New("boom")))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "test 1", entry["message"])
+	assert.NotEmpty(t, entry["time"])
+
+	entry = nil
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "unable to download", entry["message"])
+	assert.Equal(t, "b", entry["bucket"])
+	assert.Equal(t, "a/b.orc", entry["key"])
+	assert.Equal(t, float64(10), entry["size"])
+	assert.Equal(t, "boom", entry["err"])
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(2, 3)
+	var allowed int
+	for i := 0; i < 11; i++ {
+		if s.Allow(LevelError, "same") {
+			allowed++
+		}
+	}
+
+	// The first 2, then the 5th, 8th and 11th
+	assert.Equal(t, 5, allowed)
+	assert.True(t, s.Allow(LevelError, "other"))
+	assert.True(t, (*Sampler)(nil).Allow(LevelError, "same"))
+}
+
+func TestParseLevel(t *testing.T) {
+	assert.Equal(t, LevelWarning, ParseLevel("WARN"))
+	assert.Equal(t, LevelInfo, ParseLevel("info"))
+	assert.Equal(t, LevelDebug, ParseLevel(""))
+	assert.True(t, LevelError.Enabled(LevelInfo))
+	assert.False(t, LevelDebug.Enabled(LevelInfo))
+}
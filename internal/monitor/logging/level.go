@@ -3,6 +3,10 @@
 
 package logging
 
+import (
+	"strings"
+)
+
 type Level string
 
 const (
@@ -11,3 +15,34 @@ const (
 	LevelInfo    Level = "info"
 	LevelDebug   Level = "debug"
 )
+
+// ParseLevel parses the level from a string, defaulting to the debug level
+func ParseLevel(v string) Level {
+	switch l := Level(strings.ToLower(v)); l {
+	case LevelError, LevelWarning, LevelInfo, LevelDebug:
+		return l
+	case "warn":
+		return LevelWarning
+	default:
+		return LevelDebug
+	}
+}
+
+// Enabled returns whether the level is at or above the minimum level
+func (l Level) Enabled(min Level) bool {
+	return l.rank() >= min.rank()
+}
+
+// rank returns the severity of the level
+func (l Level) rank() int {
+	switch l {
+	case LevelError:
+		return 3
+	case LevelWarning:
+		return 2
+	case LevelInfo:
+		return 1
+	default:
+		return 0
+	}
+}
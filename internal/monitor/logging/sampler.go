@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler limits the number of identical entries written each second. The first entries with the same level
+// and message are all written, then only one of every few, so a hot error path can not flood the logs.
+type Sampler struct {
+	lock       sync.Mutex
+	initial    int            // The number of entries always written each second
+	thereafter int            // The interval at which the entries are written past the initial ones
+	second     int64          // The current second, in unix time
+	counts     map[string]int // The number of entries seen during the current second
+}
+
+// NewSampler creates a new sampler, which writes the initial entries with the same level and message each
+// second, then one of every "thereafter" entries.
+func NewSampler(initial, thereafter int) *Sampler {
+	return &Sampler{
+		initial:    initial,
+		thereafter: thereafter,
+		counts:     make(map[string]int),
+	}
+}
+
+// Allow returns whether the entry should be written
+func (s *Sampler) Allow(level Level, msg string) bool {
+	if s == nil || s.initial <= 0 {
+		return true
+	}
+
+	now := time.Now().Unix()
+	key := string(level) + msg
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if now != s.second {
+		s.second = now
+		s.counts = make(map[string]int, len(s.counts))
+	}
+
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.initial {
+		return true
+	}
+
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,77 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field represents a key/value pair attached to a structured log entry, such as the table or the bucket.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// EventKey is the key of the field which marks an entry as an operational event, such as a compaction.
+const EventKey = "event"
+
+// F creates a new field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Event creates the field which marks an entry as an operational event of a kind, such as "compaction".
+func Event(kind string) Field {
+	return Field{Key: EventKey, Value: kind}
+}
+
+// Structured is implemented by any logging system which writes the fields of an entry separately from
+// its message, so they can be indexed.
+type Structured interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Log writes out an entry with its fields. If the logger is not structured, the fields are appended to the
+// message as key=value pairs.
+func Log(logger Logger, level Level, msg string, fields ...Field) {
+	if s, ok := logger.(Structured); ok {
+		s.Log(level, msg, fields...)
+		return
+	}
+
+	line := Format(msg, fields...)
+	switch level {
+	case LevelError:
+		logger.Errorf("%s", line)
+	case LevelWarning:
+		logger.Warningf("%s", line)
+	case LevelInfo:
+		logger.Infof("%s", line)
+	default:
+		logger.Debugf("%s", line)
+	}
+}
+
+// Format formats the message with its fields as key=value pairs
+func Format(msg string, fields ...Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, f := range fields {
+		value := fmt.Sprint(f.Value)
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		sb.WriteByte(' ')
+		sb.WriteString(f.Key)
+		sb.WriteByte('=')
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
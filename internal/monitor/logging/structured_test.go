@@ -0,0 +1,72 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// sugared records the entries written to a sugared logger
+type sugared struct {
+	entries [][]interface{}
+}
+
+func (s *sugared) Errorw(msg string, kv ...interface{}) {
+	s.entries = append(s.entries, append([]interface{}{"error", msg}, kv...))
+}
+func (s *sugared) Warnw(msg string, kv ...interface{}) {
+	s.entries = append(s.entries, append([]interface{}{"warn", msg}, kv...))
+}
+func (s *sugared) Infow(msg string, kv ...interface{}) {
+	s.entries = append(s.entries, append([]interface{}{"info", msg}, kv...))
+}
+func (s *sugared) Debugw(msg string, kv ...interface{}) {
+	s.entries = append(s.entries, append([]interface{}{"debug", msg}, kv...))
+}
+
+func TestLog_Unstructured(t *testing.T) {
+	logger := &MockLogger{}
+	logger.On("Warningf", "%s", "unable to flush table=events err=\"a b\"").Once()
+
+	Log(logger, LevelWarning, "unable to flush", F("table", "events"), F("err", "a b"))
+	logger.AssertExpectations(t)
+}
+
+func TestLog_Composite(t *testing.T) {
+	logger := &MockLogger{}
+	logger.On("Infof", "%s", mock.Anything).Once()
+	s := new(sugared)
+
+	Log(NewComposite(logger, NewSugared(s)), LevelInfo, "opened", F("table", "events"))
+	logger.AssertExpectations(t)
+	assert.Equal(t, [][]interface{}{{"info", "opened", "table", "events"}}, s.entries)
+}
+
+func TestSugared(t *testing.T) {
+	s := new(sugared)
+	l := NewSugared(s)
+	l.Errorf("test %d", 1)
+	l.Debugf("test %d", 2)
+	Log(l, LevelWarning, "test", F("key", "value"))
+	assert.Equal(t, [][]interface{}{
+		{"error", "test 1"},
+		{"debug", "test 2"},
+		{"warn", "test", "key", "value"},
+	}, s.entries)
+}
+
+func TestFunc(t *testing.T) {
+	var got []Field
+	l := Func(func(level Level, msg string, fields []Field) {
+		assert.Equal(t, LevelInfo, level)
+		assert.Equal(t, "test", msg)
+		got = fields
+	})
+
+	Log(l, LevelInfo, "test", F("table", "events"))
+	assert.Equal(t, []Field{{Key: "table", Value: "events"}}, got)
+}
@@ -6,11 +6,14 @@ package monitor
 import (
 	"fmt"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/kelindar/talaria/internal/monitor/errors"
 	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/sink"
+	"google.golang.org/grpc/codes"
 )
 
 // StatsdClient ...
@@ -36,20 +39,28 @@ type Monitor interface {
 	Info(f string, v ...interface{})
 	Warning(err error)
 	Error(err error)
+	Log(level logging.Level, msg string, fields ...logging.Field)
 }
 
 // clientImpl monitors the system and hardware
 type clientImpl struct {
-	logger logging.Logger
-	stats  StatsdClient
-	tags   []string
+	logger  logging.Logger
+	stats   StatsdClient
+	sinks   []sink.Sink
+	tags    []string
+	appname string
+	env     string
 }
 
-// New ...
-func New(log logging.Logger, stats StatsdClient, appname string, env string) Monitor {
+// New creates a new monitor. The errors, as well as the internal errors reported as warnings, are also
+// forwarded to the optional error sinks along with their stack trace.
+func New(log logging.Logger, stats StatsdClient, appname string, env string, sinks ...sink.Sink) Monitor {
 	return &clientImpl{
-		logger: log,
-		stats:  stats,
+		logger:  log,
+		stats:   stats,
+		sinks:   sinks,
+		appname: appname,
+		env:     env,
 		tags: []string{
 			"app_name:" + appname,
 			"env:" + env,
@@ -109,7 +120,10 @@ func (c *clientImpl) Warning(err error) {
 		}), 1)
 	}
 
-	c.logger.Errorf(err.Error())
+	c.logError(logging.LevelWarning, err)
+	if xerr, ok := err.(*errors.Error); ok && xerr.GRPC() == codes.Internal {
+		c.capture(logging.LevelWarning, err)
+	}
 }
 
 // Error writes out an error message into the output logger.
@@ -125,7 +139,60 @@ func (c *clientImpl) Error(err error) {
 		}), 1)
 	}
 
-	c.logger.Errorf(err.Error())
+	c.logError(logging.LevelError, err)
+	c.capture(logging.LevelError, err)
+}
+
+// capture forwards the error, with its tags and the current stack trace, to the error sinks
+func (c *clientImpl) capture(level logging.Level, err error) {
+	if len(c.sinks) == 0 {
+		return
+	}
+
+	event := sink.Event{
+		Time:    time.Now(),
+		Level:   string(level),
+		Message: err.Error(),
+		Stack:   string(debug.Stack()),
+		Tags: map[string]string{
+			"app_name": c.appname,
+			"env":      c.env,
+		},
+	}
+
+	if xerr, ok := err.(*errors.Error); ok {
+		event.Message = xerr.Message
+		event.Target = xerr.Target
+		event.Reason = xerr.Reason
+		for _, tag := range xerr.Tags() {
+			event.Tags[tag.Key()] = fmt.Sprint(tag.Value())
+		}
+	}
+
+	for _, s := range c.sinks {
+		s.Capture(event)
+	}
+}
+
+// Log writes out a message with its fields, such as the table or the bucket, into the output logger.
+func (c *clientImpl) Log(level logging.Level, msg string, fields ...logging.Field) {
+	logging.Log(c.logger, level, msg, fields...)
+}
+
+// logError writes out an error, with its target, reason and tags as separate fields if the logger is structured.
+func (c *clientImpl) logError(level logging.Level, err error) {
+	xerr, ok := err.(*errors.Error)
+	if _, structured := c.logger.(logging.Structured); !ok || !structured {
+		c.logger.Errorf(err.Error())
+		return
+	}
+
+	fields := make([]logging.Field, 0, 2+len(xerr.Tags()))
+	fields = append(fields, logging.F("target", xerr.Target), logging.F("reason", xerr.Reason))
+	for _, tag := range xerr.Tags() {
+		fields = append(fields, logging.F(tag.Key(), tag.Value()))
+	}
+	c.Log(level, xerr.Message, fields...)
 }
 
 // ------------------------------------------------------------------------------------------
@@ -4,11 +4,15 @@
 package monitor_test
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
 	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/sink"
 	"github.com/kelindar/talaria/internal/monitor/statsd"
 	"github.com/stretchr/testify/assert"
 )
@@ -29,5 +33,43 @@ func TestNoop(t *testing.T) {
 		c.Info(testTag, testMsg)
 		c.Error(nil)
 		c.Warning(nil)
+		c.Log(logging.LevelInfo, testMsg, logging.F(testTag, testKey))
 	})
 }
+
+func TestStructured(t *testing.T) {
+	var out bytes.Buffer
+	c := monitor.New(logging.NewJSON(&out, logging.LevelDebug, nil), statsd.NewNoop(), "x", "y")
+	c.Log(logging.LevelInfo, "opened", logging.F("table", "events"))
+	c.Warning(errors.Internal("unable to download", nil, errors.WithTag("bucket", "b")))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"message":"opened","table":"events"}`)
+	assert.Contains(t, lines[1], `"level":"warning","message":"unable to download","target":"`)
+	assert.Contains(t, lines[1], `"reason":"Internal Server Error","bucket":"b"}`)
+}
+
+// recorder records the events captured
+type recorder []sink.Event
+
+func (r *recorder) Capture(event sink.Event) {
+	*r = append(*r, event)
+}
+
+func TestErrorSink(t *testing.T) {
+	events := new(recorder)
+	c := monitor.New(logging.NewNoop(), statsd.NewNoop(), "talaria", "prd", events)
+	c.Error(errors.Internal("unable to flush", nil, errors.WithTag("table", "events")))
+	c.Warning(errors.NotFound("table not found"))
+	c.Warning(errors.Internal("unable to forward", nil))
+
+	assert.Len(t, *events, 2)
+	event := (*events)[0]
+	assert.Equal(t, "error", event.Level)
+	assert.Equal(t, "unable to flush", event.Message)
+	assert.Contains(t, event.Target, "monitor/monitor_test.go")
+	assert.Equal(t, map[string]string{"app_name": "talaria", "env": "prd", "table": "events"}, event.Tags)
+	assert.Contains(t, event.Stack, "TestErrorSink")
+	assert.Equal(t, "warning", (*events)[1].Level)
+}
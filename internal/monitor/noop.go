@@ -5,6 +5,8 @@ package monitor
 
 import (
 	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/logging"
 )
 
 type noopClient struct{}
@@ -36,3 +38,5 @@ func (c *noopClient) Warning(error) {}
 func (c *noopClient) Info(f string, v ...interface{}) {}
 
 func (c *noopClient) Debug(f string, v ...interface{}) {}
+
+func (c *noopClient) Log(level logging.Level, msg string, fields ...logging.Field) {}
@@ -0,0 +1,128 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package profile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "profile"
+
+// Writer represents a destination to which the profiles are uploaded, such as an S3 bucket.
+type Writer interface {
+	Write(key key.Key, value []byte) error
+}
+
+// Handler returns the pprof HTTP handlers, served under /debug/pprof/.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Profiler periodically captures the heap and CPU profiles of the node and uploads them, so that the memory
+// growth and the hot paths can be diagnosed after the fact.
+type Profiler struct {
+	node    string          // The name of the node, prefixing the uploaded profiles
+	cpu     time.Duration   // The duration of each CPU profile
+	dest    Writer          // The destination of the profiles
+	monitor monitor.Monitor // The monitor client
+}
+
+// New creates a new profiler, which captures CPU profiles for the given duration.
+func New(node string, cpu time.Duration, dest Writer, monitor monitor.Monitor) *Profiler {
+	return &Profiler{
+		node:    node,
+		cpu:     cpu,
+		dest:    dest,
+		monitor: monitor,
+	}
+}
+
+// Run captures and uploads the profiles on a regular interval, until the context is cancelled.
+func (p *Profiler) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			if err := p.Capture(ctx); err != nil {
+				p.monitor.Warning(err)
+			}
+		}
+	}
+}
+
+// Capture captures and uploads the heap profile, followed by a CPU profile.
+func (p *Profiler) Capture(ctx context.Context) error {
+	now := time.Now().UTC()
+	heap, err := p.heap()
+	if err != nil {
+		return errors.Internal("profile: unable to capture the heap", err)
+	}
+
+	if err := p.upload(now, "heap", heap); err != nil {
+		return err
+	}
+
+	cpu, err := p.profileCPU(ctx)
+	if err != nil {
+		return errors.Internal("profile: unable to capture the cpu", err)
+	}
+
+	return p.upload(now, "cpu", cpu)
+}
+
+// heap writes out the heap profile, after a garbage collection so it is up to date
+func (p *Profiler) heap() ([]byte, error) {
+	runtime.GC()
+
+	var buffer bytes.Buffer
+	if err := rpprof.Lookup("heap").WriteTo(&buffer, 0); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// profileCPU profiles the CPU until the duration elapses or the context is cancelled
+func (p *Profiler) profileCPU(ctx context.Context) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := rpprof.StartCPUProfile(&buffer); err != nil {
+		return nil, err // Another CPU profile is running, such as one requested through the handlers
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(p.cpu):
+	}
+
+	rpprof.StopCPUProfile()
+	return buffer.Bytes(), nil
+}
+
+// upload writes out a profile, named after the node and the time it was captured
+func (p *Profiler) upload(t time.Time, kind string, profile []byte) error {
+	name := fmt.Sprintf("%s/%s/%s-%s.pb.gz", p.node, t.Format("2006-01-02"), t.Format("15-04-05"), kind)
+	if err := p.dest.Write(key.Key(name), profile); err != nil {
+		return errors.Internal("profile: unable to upload", err)
+	}
+
+	p.monitor.Count1(ctxTag, "upload", "kind:"+kind)
+	return nil
+}
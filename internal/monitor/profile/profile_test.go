@@ -0,0 +1,51 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package profile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockWriter struct {
+	sync.Mutex
+	files map[string][]byte
+}
+
+func (w *mockWriter) Write(key key.Key, value []byte) error {
+	w.Lock()
+	defer w.Unlock()
+	w.files[string(key)] = value
+	return nil
+}
+
+func TestCapture(t *testing.T) {
+	dest := &mockWriter{files: make(map[string][]byte)}
+	p := New("node1", 10*time.Millisecond, dest, monitor.NewNoop())
+	assert.NoError(t, p.Capture(context.Background()))
+
+	var kinds []string
+	for name, profile := range dest.files {
+		assert.True(t, strings.HasPrefix(name, "node1/"))
+		assert.NotEmpty(t, profile)
+		kinds = append(kinds, name[strings.LastIndexByte(name, '-')+1:])
+	}
+	assert.ElementsMatch(t, []string{"heap.pb.gz", "cpu.pb.gz"}, kinds)
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/heap", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.Bytes())
+}
@@ -0,0 +1,104 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package sink
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// NewSentry creates a new sink which sends every event to Sentry, through the store endpoint of the project
+// identified by the DSN, such as https://<key>@sentry.io/<project>.
+func NewSentry(dsn string) (Sink, error) {
+	endpoint, auth, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPoster(func(event Event) (*http.Request, error) {
+		body, err := json.Marshal(sentryEventOf(event))
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", auth)
+		return req, nil
+	}), nil
+}
+
+// parseDSN returns the store endpoint and the authentication header of a Sentry DSN
+func parseDSN(dsn string) (endpoint, auth string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+
+	project := path.Base(u.Path)
+	if u.User == nil || u.User.Username() == "" || project == "/" || project == "." {
+		return "", "", fmt.Errorf("sentry: invalid dsn %s", dsn)
+	}
+
+	auth = "Sentry sentry_version=7, sentry_client=talaria/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	prefix := strings.TrimSuffix(path.Dir(u.Path), "/")
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project)
+	return endpoint, auth, nil
+}
+
+// sentryEvent represents an event, as expected by the store endpoint of Sentry
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger"`
+	Platform    string                 `json:"platform"`
+	Message     string                 `json:"message"`
+	Culprit     string                 `json:"culprit,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+// sentryEventOf converts the event to a Sentry event
+func sentryEventOf(event Event) sentryEvent {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	extra := make(map[string]interface{}, 2)
+	if event.Reason != "" {
+		extra["reason"] = event.Reason
+	}
+	if event.Stack != "" {
+		extra["stack"] = event.Stack
+	}
+
+	return sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   event.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:       event.Level,
+		Logger:      "talaria",
+		Platform:    "go",
+		Message:     event.Message,
+		Culprit:     event.Target,
+		Environment: event.Tags["env"],
+		Tags:        event.Tags,
+		Extra:       extra,
+	}
+}
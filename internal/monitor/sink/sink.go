@@ -0,0 +1,107 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultCapacity = 1000             // The number of events queued before dropping them
+	defaultTimeout  = 10 * time.Second // The timeout of a request
+)
+
+// Event represents an error captured by the monitor, with its context.
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Target  string            `json:"target,omitempty"` // The line of code which created the error
+	Reason  string            `json:"reason,omitempty"` // The reason of the error
+	Tags    map[string]string `json:"tags,omitempty"`   // The tags of the error, such as the table
+	Stack   string            `json:"stack,omitempty"`  // The stack trace of the goroutine which reported the error
+}
+
+// Sink represents a destination to which the errors are forwarded, such as Sentry.
+type Sink interface {
+	Capture(event Event)
+}
+
+// ------------------------------------------------------------------------------------------
+
+// NewWebhook creates a new sink which posts every event as JSON to the URL.
+func NewWebhook(url string) Sink {
+	return newPoster(func(event Event) (*http.Request, error) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
+
+// poster sends the events in the background, one at a time, and drops them when too many are queued so
+// that reporting an error never blocks the caller.
+type poster struct {
+	client  *http.Client
+	queue   chan Event
+	request func(Event) (*http.Request, error)
+}
+
+// newPoster creates a new poster and starts sending the events
+func newPoster(request func(Event) (*http.Request, error)) *poster {
+	p := &poster{
+		client:  &http.Client{Timeout: defaultTimeout},
+		queue:   make(chan Event, defaultCapacity),
+		request: request,
+	}
+
+	go p.run()
+	return p
+}
+
+// Capture queues the event, or drops it if the queue is full
+func (p *poster) Capture(event Event) {
+	select {
+	case p.queue <- event:
+	default:
+	}
+}
+
+// run sends the queued events
+func (p *poster) run() {
+	for event := range p.queue {
+		_ = p.send(event)
+	}
+}
+
+// send sends a single event
+func (p *poster) send(event Event) error {
+	req, err := p.request(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
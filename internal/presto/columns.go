@@ -928,3 +928,42 @@ func (b *PrestoThriftJson) At(index int) interface{} {
 func binaryToString(b *[]byte) string {
 	return *(*string)(unsafe.Pointer(b))
 }
+
+// ------------------------------------------------------------------------------------------------------------
+
+// Slice returns the values of a column between two indices, sharing the memory of the column.
+func Slice(c Column, from, until int) Column {
+	switch b := c.(type) {
+	case *PrestoThriftInteger:
+		return &PrestoThriftInteger{Nulls: b.Nulls[from:until], Ints: b.Ints[from:until]}
+	case *PrestoThriftBigint:
+		return &PrestoThriftBigint{Nulls: b.Nulls[from:until], Longs: b.Longs[from:until]}
+	case *PrestoThriftDouble:
+		return &PrestoThriftDouble{Nulls: b.Nulls[from:until], Doubles: b.Doubles[from:until]}
+	case *PrestoThriftBoolean:
+		return &PrestoThriftBoolean{Nulls: b.Nulls[from:until], Booleans: b.Booleans[from:until]}
+	case *PrestoThriftTimestamp:
+		return &PrestoThriftTimestamp{Nulls: b.Nulls[from:until], Timestamps: b.Timestamps[from:until]}
+	case *PrestoThriftVarchar:
+		sizes, bytes := sliceVariable(b.Sizes, b.Bytes, from, until)
+		return &PrestoThriftVarchar{Nulls: b.Nulls[from:until], Sizes: sizes, Bytes: bytes}
+	case *PrestoThriftJson:
+		sizes, bytes := sliceVariable(b.Sizes, b.Bytes, from, until)
+		return &PrestoThriftJson{Nulls: b.Nulls[from:until], Sizes: sizes, Bytes: bytes}
+	default:
+		panic(fmt.Errorf("presto: unable to slice %T", c))
+	}
+}
+
+// sliceVariable returns the sizes and the bytes of the variable length values between two indices
+func sliceVariable(sizes []int32, bytes []byte, from, until int) ([]int32, []byte) {
+	var begin, end int32
+	for i := 0; i < until; i++ {
+		if i < from {
+			begin += sizes[i]
+		}
+		end += sizes[i]
+	}
+
+	return sizes[from:until], bytes[begin:end]
+}
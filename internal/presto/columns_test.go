@@ -621,3 +621,26 @@ func TestAppend_Json(t *testing.T) {
 		})
 	}
 }
+
+func TestSlice(t *testing.T) {
+	varchars, longs := new(PrestoThriftVarchar), new(PrestoThriftBigint)
+	for _, v := range []interface{}{"a", nil, "bc", "def"} {
+		varchars.Append(v)
+		if v == nil {
+			longs.Append(nil)
+			continue
+		}
+		longs.Append(int64(len(v.(string))))
+	}
+
+	assert.Equal(t, &PrestoThriftVarchar{
+		Nulls: []bool{true, false},
+		Sizes: []int32{0, 2},
+		Bytes: []byte("bc"),
+	}, Slice(varchars, 1, 3))
+	assert.Equal(t, &PrestoThriftBigint{
+		Nulls: []bool{false, false},
+		Longs: []int64{2, 3},
+	}, Slice(longs, 2, 4))
+	assert.Equal(t, 0, Slice(varchars, 4, 4).Count())
+}
@@ -93,6 +93,82 @@ func equalsHash(value string) *PrestoThriftDomain {
 	}
 }
 
+// KeyDomain creates a domain for the hash key which matches any of the non-null keys of the block. This is
+// used for index lookups, where the keys are provided by Presto as a column block.
+func KeyDomain(keys *PrestoThriftBlock) *PrestoThriftDomain {
+	var ranges []*PrestoThriftRange
+	switch {
+	case keys.VarcharData != nil:
+		var offset int32
+		for i, size := range keys.VarcharData.Sizes {
+			start := offset
+			offset += size
+			if i < len(keys.VarcharData.Nulls) && keys.VarcharData.Nulls[i] {
+				continue
+			}
+
+			value := string(keys.VarcharData.Bytes[start:offset])
+			ranges = append(ranges, equalsHash(value).ValueSet.RangeValueSet.Ranges...)
+		}
+
+	case keys.BigintData != nil:
+		for i, value := range keys.BigintData.Longs {
+			if i < len(keys.BigintData.Nulls) && keys.BigintData.Nulls[i] {
+				continue
+			}
+
+			exact := &PrestoThriftMarker{
+				Value: &PrestoThriftBlock{
+					BigintData: &PrestoThriftBigint{
+						Nulls: []bool{false},
+						Longs: []int64{value},
+					},
+				},
+				Bound: PrestoThriftBoundExactly,
+			}
+			ranges = append(ranges, &PrestoThriftRange{Low: exact, High: exact})
+		}
+	}
+
+	return &PrestoThriftDomain{
+		ValueSet: &PrestoThriftValueSet{
+			RangeValueSet: &PrestoThriftRangeValueSet{
+				Ranges: ranges,
+			},
+		},
+	}
+}
+
+// TimeRange creates a domain for the sort key (inclusive interval, in unix seconds)
+func TimeRange(from, until time.Time) *PrestoThriftDomain {
+	return &PrestoThriftDomain{
+		ValueSet: &PrestoThriftValueSet{
+			RangeValueSet: &PrestoThriftRangeValueSet{
+				Ranges: []*PrestoThriftRange{{
+					Low: &PrestoThriftMarker{
+						Value: &PrestoThriftBlock{
+							BigintData: &PrestoThriftBigint{
+								Nulls: []bool{false},
+								Longs: []int64{from.Unix()},
+							},
+						},
+						Bound: PrestoThriftBoundExactly,
+					},
+					High: &PrestoThriftMarker{
+						Value: &PrestoThriftBlock{
+							BigintData: &PrestoThriftBigint{
+								Nulls: []bool{false},
+								Longs: []int64{until.Unix()},
+							},
+						},
+						Bound: PrestoThriftBoundExactly,
+					},
+				}},
+			},
+		},
+	}
+}
+
 // ------------------------------------------------------------------------------------------------------------
 
 // AsTimeRange converts thrift range as a time range
@@ -124,6 +200,15 @@ func (r *PrestoThriftRange) AsTimeRange() (time.Time, time.Time, bool) {
 	return zero, zero, false
 }
 
+// AsUpperBound converts the upper bound of the thrift range as a time, if the range is bounded above
+func (r *PrestoThriftRange) AsUpperBound() (time.Time, bool) {
+	if r.High == nil || r.High.Value == nil || r.High.Value.BigintData == nil {
+		return time.Unix(0, 0), false
+	}
+
+	return toTime(r.High.Value.BigintData.Min()), true
+}
+
 // Converts time provided to a golang time
 func toTime(t int64, ok bool) time.Time {
 	if !ok {
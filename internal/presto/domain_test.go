@@ -5,6 +5,7 @@ package presto
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,3 +19,32 @@ func TestNewDomain(t *testing.T) {
 		},
 	}, d)
 }
+
+func TestTimeRange(t *testing.T) {
+	t0, t1 := time.Unix(1586500157, 0), time.Unix(1586503757, 0)
+	from, until, ok := TimeRange(t0, t1).ValueSet.RangeValueSet.Ranges[0].AsTimeRange()
+	assert.True(t, ok)
+	assert.Equal(t, t0, from)
+	assert.Equal(t, t1, until)
+}
+
+func TestKeyDomain(t *testing.T) {
+	keys := &PrestoThriftBlock{
+		VarcharData: &PrestoThriftVarchar{
+			Nulls: []bool{false, true, false},
+			Sizes: []int32{1, 0, 2},
+			Bytes: []byte("abc"),
+		},
+	}
+
+	domain := KeyDomain(keys)
+	ranges := domain.ValueSet.RangeValueSet.Ranges
+	assert.Len(t, ranges, 2)
+	assert.Equal(t, []byte("a"), ranges[0].Low.Value.VarcharData.Bytes)
+	assert.Equal(t, []byte("bc"), ranges[1].High.Value.VarcharData.Bytes)
+
+	domain = KeyDomain(&PrestoThriftBlock{
+		BigintData: &PrestoThriftBigint{Longs: []int64{1, 2}},
+	})
+	assert.Len(t, domain.ValueSet.RangeValueSet.Ranges, 2)
+}
@@ -0,0 +1,322 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package presto
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/samuel/go-thrift/thrift"
+)
+
+// The columns encode themselves to the output protocol instead of going through the reflection of the thrift
+// encoder. When the protocol writes to a buffer, the values are encoded in chunks appended to it directly, and
+// the buffer is grown once to the size of the page, so a large page is never copied while it is written.
+
+const chunkSize = 4096 // The size of the chunks of encoded values
+
+// chunks pools the chunks the values are encoded into
+var chunks = sync.Pool{
+	New: func() interface{} {
+		return new([chunkSize]byte)
+	},
+}
+
+// grower represents a protocol writer which can reserve the space for the bytes about to be written
+type grower interface {
+	Grow(n int)
+}
+
+// EncodeThrift encodes the page to the output protocol.
+func (p *PrestoThriftPageResult) EncodeThrift(w thrift.ProtocolWriter) error {
+	if g, ok := w.(grower); ok {
+		g.Grow(p.encodedSize())
+	}
+
+	if err := w.WriteStructBegin("PrestoThriftPageResult"); err != nil {
+		return err
+	}
+
+	if err := w.WriteFieldBegin("ColumnBlocks", thrift.TypeList, 1); err != nil {
+		return err
+	}
+	if err := w.WriteListBegin(thrift.TypeStruct, len(p.ColumnBlocks)); err != nil {
+		return err
+	}
+	for _, b := range p.ColumnBlocks {
+		if err := b.EncodeThrift(w); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteListEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+
+	if err := w.WriteFieldBegin("RowCount", thrift.TypeI32, 2); err != nil {
+		return err
+	}
+	if err := w.WriteI32(p.RowCount); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+
+	if p.NextToken != nil {
+		if err := writeStruct(w, "NextToken", 3, p.NextToken); err != nil {
+			return err
+		}
+	}
+
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	return w.WriteStructEnd()
+}
+
+// encodedSize returns the approximate number of bytes of the encoded page
+func (p *PrestoThriftPageResult) encodedSize() int {
+	size := 64
+	for _, b := range p.ColumnBlocks {
+		size += 16 + b.Size()
+	}
+	return size
+}
+
+// EncodeThrift encodes the block to the output protocol.
+func (b *PrestoThriftBlock) EncodeThrift(w thrift.ProtocolWriter) error {
+	if err := w.WriteStructBegin("PrestoThriftBlock"); err != nil {
+		return err
+	}
+
+	for i, v := range []struct {
+		name  string
+		value interface{}
+		isNil bool
+	}{
+		{"IntegerData", b.IntegerData, b.IntegerData == nil},
+		{"BigintData", b.BigintData, b.BigintData == nil},
+		{"DoubleData", b.DoubleData, b.DoubleData == nil},
+		{"VarcharData", b.VarcharData, b.VarcharData == nil},
+		{"BooleanData", b.BooleanData, b.BooleanData == nil},
+		{"DateData", b.DateData, b.DateData == nil},
+		{"TimestampData", b.TimestampData, b.TimestampData == nil},
+		{"JsonData", b.JsonData, b.JsonData == nil},
+		{"HyperLogLogData", b.HyperLogLogData, b.HyperLogLogData == nil},
+		{"BigintArrayData", b.BigintArrayData, b.BigintArrayData == nil},
+	} {
+		if v.isNil {
+			continue
+		}
+
+		if err := writeStruct(w, v.name, int16(i+1), v.value); err != nil {
+			return err
+		}
+	}
+
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	return w.WriteStructEnd()
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftInteger) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftInteger", func() error {
+		if err := writeBools(w, 1, b.Nulls); err != nil {
+			return err
+		}
+		return writeInt32s(w, 2, b.Ints)
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftBigint) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftBigint", func() error {
+		if err := writeBools(w, 1, b.Nulls); err != nil {
+			return err
+		}
+		return writeInt64s(w, 2, b.Longs)
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftDouble) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftDouble", func() error {
+		if err := writeBools(w, 1, b.Nulls); err != nil {
+			return err
+		}
+		return writeList(w, 2, thrift.TypeDouble, len(b.Doubles), 8, func(out []byte, i int) {
+			binary.BigEndian.PutUint64(out, math.Float64bits(b.Doubles[i]))
+		})
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftVarchar) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftVarchar", func() error {
+		return writeVariable(w, b.Nulls, b.Sizes, b.Bytes)
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftJson) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftJson", func() error {
+		return writeVariable(w, b.Nulls, b.Sizes, b.Bytes)
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftBoolean) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftBoolean", func() error {
+		if err := writeBools(w, 1, b.Nulls); err != nil {
+			return err
+		}
+		return writeBools(w, 2, b.Booleans)
+	})
+}
+
+// EncodeThrift encodes the column to the output protocol.
+func (b *PrestoThriftTimestamp) EncodeThrift(w thrift.ProtocolWriter) error {
+	return writeColumn(w, "PrestoThriftTimestamp", func() error {
+		if err := writeBools(w, 1, b.Nulls); err != nil {
+			return err
+		}
+		return writeInt64s(w, 2, b.Timestamps)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// writeStruct writes a field containing a struct, encoding itself if it can
+func writeStruct(w thrift.ProtocolWriter, name string, id int16, v interface{}) error {
+	if err := w.WriteFieldBegin(name, thrift.TypeStruct, id); err != nil {
+		return err
+	}
+	if err := thrift.EncodeStruct(w, v); err != nil {
+		return err
+	}
+	return w.WriteFieldEnd()
+}
+
+// writeColumn writes a column struct, its fields being written by the function
+func writeColumn(w thrift.ProtocolWriter, name string, fields func() error) error {
+	if err := w.WriteStructBegin(name); err != nil {
+		return err
+	}
+	if err := fields(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	return w.WriteStructEnd()
+}
+
+// writeVariable writes the fields of a column of variable length values
+func writeVariable(w thrift.ProtocolWriter, nulls []bool, sizes []int32, bytes []byte) error {
+	if err := writeBools(w, 1, nulls); err != nil {
+		return err
+	}
+	if err := writeInt32s(w, 2, sizes); err != nil {
+		return err
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+
+	if err := w.WriteFieldBegin("Bytes", thrift.TypeString, 3); err != nil {
+		return err
+	}
+	if err := w.WriteBytes(bytes); err != nil {
+		return err
+	}
+	return w.WriteFieldEnd()
+}
+
+func writeBools(w thrift.ProtocolWriter, id int16, values []bool) error {
+	return writeList(w, id, thrift.TypeBool, len(values), 1, func(out []byte, i int) {
+		out[0] = 0
+		if values[i] {
+			out[0] = 1
+		}
+	})
+}
+
+func writeInt32s(w thrift.ProtocolWriter, id int16, values []int32) error {
+	return writeList(w, id, thrift.TypeI32, len(values), 4, func(out []byte, i int) {
+		binary.BigEndian.PutUint32(out, uint32(values[i]))
+	})
+}
+
+func writeInt64s(w thrift.ProtocolWriter, id int16, values []int64) error {
+	return writeList(w, id, thrift.TypeI64, len(values), 8, func(out []byte, i int) {
+		binary.BigEndian.PutUint64(out, uint64(values[i]))
+	})
+}
+
+// writeList writes a field containing a list of fixed size values, skipping it if the list is empty as the
+// thrift encoder does. The values are encoded by chunks if the protocol writes to a buffer.
+func writeList(w thrift.ProtocolWriter, id int16, typ byte, count, size int, put func(out []byte, i int)) error {
+	if count == 0 {
+		return nil
+	}
+
+	if err := w.WriteFieldBegin("", thrift.TypeList, id); err != nil {
+		return err
+	}
+	if err := w.WriteListBegin(typ, count); err != nil {
+		return err
+	}
+
+	chunk := chunks.Get().(*[chunkSize]byte)
+	defer chunks.Put(chunk)
+
+	raw, ok := w.(io.Writer)
+	for i := 0; i < count; {
+		n := 0
+		for ; i < count && n+size <= len(chunk); i++ {
+			put(chunk[n:], i)
+			n += size
+		}
+
+		if ok {
+			if _, err := raw.Write(chunk[:n]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Without access to the buffer, go through the protocol one value at a time
+		for offset := 0; offset < n; offset += size {
+			if err := writeValue(w, typ, chunk[offset:offset+size]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.WriteListEnd(); err != nil {
+		return err
+	}
+	return w.WriteFieldEnd()
+}
+
+// writeValue writes an encoded value through the protocol
+func writeValue(w thrift.ProtocolWriter, typ byte, b []byte) error {
+	switch typ {
+	case thrift.TypeBool:
+		return w.WriteBool(b[0] == 1)
+	case thrift.TypeI32:
+		return w.WriteI32(int32(binary.BigEndian.Uint32(b)))
+	case thrift.TypeDouble:
+		return w.WriteDouble(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	default:
+		return w.WriteI64(int64(binary.BigEndian.Uint64(b)))
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package presto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/samuel/go-thrift/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// conn represents a connection recording the bytes written
+type conn struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *conn) Write(p []byte) (int, error) { c.writes++; return c.Buffer.Write(p) }
+func (c *conn) Close() error                { return nil }
+
+func newPage() *PrestoThriftPageResult {
+	integers, bigints, doubles := new(PrestoThriftInteger), new(PrestoThriftBigint), new(PrestoThriftDouble)
+	varchars, booleans, timestamps := new(PrestoThriftVarchar), new(PrestoThriftBoolean), new(PrestoThriftTimestamp)
+	json := new(PrestoThriftJson)
+	for i := 0; i < 5000; i++ {
+		integers.Append(int32(i))
+		bigints.Append(int64(i) << 40)
+		doubles.Append(float64(i) / 3)
+		varchars.Append("hello")
+		booleans.Append(i%3 == 0)
+		timestamps.Append(int64(1600000000 + i))
+		json.Append(nil)
+	}
+
+	return &PrestoThriftPageResult{
+		ColumnBlocks: []*PrestoThriftBlock{
+			integers.AsThrift(), bigints.AsThrift(), doubles.AsThrift(), varchars.AsThrift(),
+			booleans.AsThrift(), timestamps.AsThrift(), json.AsThrift(),
+			{DateData: &PrestoThriftDate{Nulls: []bool{false}, Dates: []int32{18000}}},
+		},
+		RowCount:  5000,
+		NextToken: &PrestoThriftId{Id: []byte("next")},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	page := newPage()
+
+	// Encode through the transport, which writes the frame at once
+	c := new(conn)
+	tr := newTransport(c)
+	assert.NoError(t, tr.WriteMessageBegin("getRows", thrift.MessageTypeReply, 1))
+	assert.NoError(t, thrift.EncodeStruct(tr, page))
+	assert.NoError(t, tr.WriteMessageEnd())
+	assert.NoError(t, tr.Flush())
+	assert.Equal(t, 1, c.writes)
+	assert.Equal(t, c.Len()-4, int(binary.BigEndian.Uint32(c.Bytes())))
+
+	// The page decodes to the same values
+	r := thrift.NewBinaryProtocolReader(bytes.NewReader(c.Bytes()[4:]), false)
+	_, _, _, err := r.ReadMessageBegin()
+	assert.NoError(t, err)
+	decoded := new(PrestoThriftPageResult)
+	assert.NoError(t, thrift.DecodeStruct(r, decoded))
+	assert.Equal(t, page, decoded)
+
+	// The encoding is the same one value at a time, without access to the buffer
+	var expected bytes.Buffer
+	w := thrift.NewBinaryProtocolWriter(&expected, true)
+	assert.NoError(t, w.WriteMessageBegin("getRows", thrift.MessageTypeReply, 1))
+	assert.NoError(t, thrift.EncodeStruct(w, page))
+	assert.Equal(t, expected.Bytes(), c.Bytes()[4:])
+}
+
+func TestTransport_TooLarge(t *testing.T) {
+	c := new(conn)
+	tr := newTransport(c)
+	assert.NoError(t, tr.WriteI32(1))
+	_, err := tr.Write(make([]byte, frameSize))
+	assert.Error(t, err)
+
+	// The rest of the response is discarded, and nothing is sent
+	assert.Equal(t, err, tr.WriteI32(2))
+	tr.Grow(100)
+	assert.Nil(t, tr.frame)
+	assert.Equal(t, err, tr.Flush())
+	assert.Equal(t, 0, c.writes)
+
+	// The next response starts a new frame
+	assert.NoError(t, tr.WriteI32(1))
+	assert.NoError(t, tr.Flush())
+	assert.Equal(t, []byte{0, 0, 0, 4, 0, 0, 0, 1}, c.Bytes())
+}
+
+// BenchmarkEncode-8   	    3320	    354777 ns/op	     166 B/op	       5 allocs/op
+func BenchmarkEncode(b *testing.B) {
+	page := newPage()
+	tr := newTransport(new(conn))
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_ = thrift.EncodeStruct(tr, page)
+		tr.frame.Reset()
+	}
+}
@@ -5,9 +5,11 @@ package presto
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/rpc"
+	"time"
 
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	talaria "github.com/kelindar/talaria/proto"
@@ -31,12 +33,22 @@ type Column interface {
 	At(index int) interface{}
 }
 
-// Serve creates and serves thrift RPC for presto. Context is used for cancellation purposes.
-func Serve(ctx context.Context, port int32, service PrestoThriftService) error {
-	if err := rpc.RegisterName("Thrift", &PrestoThriftServiceServer{
-		Implementation: service,
-	}); err != nil {
-		return err
+// Binder represents a service which serves each connection on behalf of its client, identified by the
+// certificate it presented if the listener is secured with TLS.
+type Binder interface {
+	Bind(state *tls.ConnectionState) PrestoThriftService
+}
+
+// Serve creates and serves thrift RPC for presto, over TLS if a config is given. Context is used for
+// cancellation purposes.
+func Serve(ctx context.Context, port int32, service PrestoThriftService, tlsConfig *tls.Config) error {
+	binder, bound := service.(Binder)
+	if !bound {
+		if err := rpc.RegisterName("Thrift", &PrestoThriftServiceServer{
+			Implementation: service,
+		}); err != nil {
+			return err
+		}
 	}
 
 	// Create a TCP listener for our thrift
@@ -45,6 +57,10 @@ func Serve(ctx context.Context, port int32, service PrestoThriftService) error {
 		return err
 	}
 
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
 	// Close the listener if context is cancelled
 	go func() {
 		<-ctx.Done()
@@ -58,13 +74,70 @@ func Serve(ctx context.Context, port int32, service PrestoThriftService) error {
 			return nil
 		default:
 			if conn, err := ln.Accept(); err == nil {
-				t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, frameSize), thrift.BinaryProtocol)
-				go rpc.ServeCodec(thrift.NewServerCodec(t))
+				if bound {
+					go serveBound(conn, binder)
+					continue
+				}
+
+				go rpc.ServeCodec(codecOf(conn))
 			}
 		}
 	}
 }
 
+// serveBound serves a connection with the service bound to its client
+func serveBound(conn net.Conn, binder Binder) {
+	var state *tls.ConnectionState
+	if secure, ok := conn.(*tls.Conn); ok {
+		if err := secure.Handshake(); err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		cs := secure.ConnectionState()
+		state = &cs
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Thrift", &PrestoThriftServiceServer{
+		Implementation: binder.Bind(state),
+	}); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	server.ServeCodec(codecOf(conn))
+}
+
+// codecOf returns the thrift codec of a connection
+func codecOf(conn net.Conn) rpc.ServerCodec {
+	return thrift.NewServerCodec(newTransport(conn))
+}
+
+// Dial connects to the thrift service of another node, over TLS if a config is given. The connection expires
+// after the timeout, and the client must be closed once done.
+func Dial(address string, timeout time.Duration, tlsConfig *tls.Config) (*rpc.Client, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, frameSize), thrift.BinaryProtocol)
+	return thrift.NewClient(t, false), nil
+}
+
 // ------------------------------------------------------------------------------------------------------------
 
 // Size returns the size of the block.
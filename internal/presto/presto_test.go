@@ -16,7 +16,7 @@ func TestServeCancellation(t *testing.T) {
 	assert.NotPanics(t, func() {
 		ctx, cancel := context.WithCancel(context.Background())
 		cancel()
-		_ = Serve(ctx, 9999, nil)
+		_ = Serve(ctx, 9999, nil, nil)
 	})
 }
 
@@ -0,0 +1,121 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package presto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/samuel/go-thrift/thrift"
+)
+
+const maxPooledFrame = 4 << 20 // The frames larger than 4MB are not kept in the pool
+
+// frames pools the buffers of the frames being written, shared by the connections
+var frames = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// transport represents a framed transport with the binary protocol. Unlike the framed transport of the thrift
+// package, the buffer of the frame is exposed to the encoders, so they can reserve its size and append to it,
+// and it is only held while a response is written rather than for the lifetime of the connection.
+type transport struct {
+	thrift.ProtocolReader
+	thrift.ProtocolWriter
+	conn      io.ReadWriteCloser
+	frame     *bytes.Buffer // The frame being written, if any
+	abandoned error         // The error which abandoned the response being written, until it is flushed
+}
+
+// newTransport creates a new framed transport for the connection
+func newTransport(conn io.ReadWriteCloser) *transport {
+	t := &transport{conn: conn}
+	t.ProtocolReader = thrift.NewBinaryProtocolReader(thrift.NewFramedReadWriteCloser(conn, frameSize), false)
+	t.ProtocolWriter = thrift.NewBinaryProtocolWriter(t, true)
+	return t
+}
+
+// Write appends the bytes to the frame. Once the frame exceeds its maximum size, the response is abandoned and
+// the rest of it is discarded, every write returning the same error until it is flushed.
+func (t *transport) Write(p []byte) (int, error) {
+	if t.abandoned != nil {
+		return 0, t.abandoned
+	}
+
+	t.begin()
+	n, _ := t.frame.Write(p)
+	if size := t.frame.Len() - 4; size > frameSize {
+		release(t.frame)
+		t.frame = nil
+		t.abandoned = thrift.ErrFrameTooBig{Size: int64(size), MaxSize: frameSize}
+		return n, t.abandoned
+	}
+	return n, nil
+}
+
+// Grow reserves the space for the bytes about to be written to the frame.
+func (t *transport) Grow(n int) {
+	if t.abandoned != nil {
+		return
+	}
+
+	if n > frameSize {
+		n = frameSize
+	}
+
+	t.begin()
+	t.frame.Grow(n)
+}
+
+// begin starts a frame, reserving the space for its size, unless one was already started
+func (t *transport) begin() {
+	if t.frame == nil {
+		t.frame = frames.Get().(*bytes.Buffer)
+		t.frame.Write(make([]byte, 4))
+	}
+}
+
+// Flush writes the frame to the connection, prefixed with its size, and releases its buffer. If the response
+// was abandoned, nothing is written and the error which abandoned it is returned, the next response starting
+// a new frame.
+func (t *transport) Flush() error {
+	if err := t.abandoned; err != nil {
+		t.abandoned = nil
+		return err
+	}
+
+	if t.frame == nil {
+		return nil
+	}
+
+	frame := t.frame
+	t.frame = nil
+	defer release(frame)
+
+	b := frame.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := t.conn.Write(b)
+	return err
+}
+
+// Close closes the connection.
+func (t *transport) Close() error {
+	if t.frame != nil {
+		release(t.frame)
+		t.frame = nil
+	}
+	return t.conn.Close()
+}
+
+// release returns the buffer of a frame to the pool, unless it is too large to be kept around
+func release(frame *bytes.Buffer) {
+	if frame.Cap() <= maxPooledFrame {
+		frame.Reset()
+		frames.Put(frame)
+	}
+}
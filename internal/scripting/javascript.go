@@ -0,0 +1,218 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package script
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// The maximum depth of the call stack of a JavaScript script
+const maxCallStack = 1024
+
+// JavaScript represents a JavaScript script loaded in the sandbox, which declares a 'main' function taking the
+// row and returning the value computed.
+type JavaScript struct {
+	name    string        // The name of the script
+	timeout time.Duration // The maximum time a single invocation can run for
+	loader  *Loader       // The loader which loaded the script
+	code    atomic.Value  // The compiled code currently used by the script
+}
+
+// LoadJavaScript creates a new JavaScript script from code or URL and starts watching it for updates.
+func (l *Loader) LoadJavaScript(name string, uriOrCode string) (*JavaScript, error) {
+	s := &JavaScript{
+		name:    name,
+		timeout: l.timeout,
+		loader:  l,
+	}
+
+	if _, err := url.Parse(uriOrCode); err != nil {
+		return s, l.updateJavaScript(s, []byte(uriOrCode)) // Assume it's the actual code
+	}
+
+	if err := l.watchURL(uriOrCode, name, func(code []byte) error {
+		return l.updateJavaScript(s, code)
+	}, func() string {
+		return l.versionOf(s)
+	}); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Name returns the name of the script
+func (s *JavaScript) Name() string {
+	return s.name
+}
+
+// Version returns the version of the code currently used by the script, which is derived from its content.
+func (s *JavaScript) Version() string {
+	return s.loader.versionOf(s)
+}
+
+// Run runs the main function of the script on a copy of the row, within the time limit of the sandbox. The value
+// returned is exported to Go, with its numbers as int64 or float64 and its objects as maps.
+func (s *JavaScript) Run(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+	code := s.code.Load().(*jsCode)
+	vm, err := code.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := vm.invoke(ctx, s.timeout, row)
+	if err != nil {
+		if _, ok := err.(*goja.InterruptedError); ok && ctx.Err() == nil {
+			if s.loader.monitor != nil {
+				s.loader.monitor.Count1(ctxTag, "timeout", "script:"+s.name)
+			}
+			err = fmt.Errorf("script: %s did not complete within %v", s.name, s.timeout)
+		}
+	}
+
+	code.release(vm)
+	return out, err
+}
+
+// updateJavaScript swaps the code of the script, unless the code is unchanged or does not pass the smoke test, in
+// which case the script keeps running the previous version.
+func (l *Loader) updateJavaScript(s *JavaScript, code []byte) error {
+	hash := sha256.Sum256(code)
+	version := hex.EncodeToString(hash[:4])
+	if l.versionOf(s) == version {
+		return nil // Same content, nothing to update
+	}
+
+	compiled, err := compileJavaScript(s.name, code)
+	if err != nil {
+		return err
+	}
+
+	s.code.Store(compiled)
+	l.versions.Store(s, version)
+	return nil
+}
+
+// compileJavaScript compiles the code of a script and runs it on an empty row. The code is rejected if it can not
+// be compiled, has no main function or does not complete in time. Other runtime errors are tolerated, as the
+// script may legitimately expect some of the columns to be present.
+func compileJavaScript(name string, code []byte) (*jsCode, error) {
+	program, err := goja.Compile(name, string(code), false)
+	if err != nil {
+		return nil, fmt.Errorf("script: unable to compile %s: %v", name, err)
+	}
+
+	candidate := &jsCode{
+		program: program,
+		idle:    make(chan *jsVM, runtime.GOMAXPROCS(0)),
+	}
+
+	vm, err := candidate.acquire()
+	if err != nil {
+		return nil, fmt.Errorf("script: %s %v", name, err)
+	}
+
+	switch _, err := vm.invoke(context.Background(), smokeTimeout, map[string]interface{}{}); err.(type) {
+	case nil:
+	case *goja.InterruptedError:
+		return nil, fmt.Errorf("script: %s did not complete within %v", name, smokeTimeout)
+	}
+
+	candidate.release(vm)
+	return candidate, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// jsCode represents a version of the code of a script, along with its idle runtimes
+type jsCode struct {
+	program *goja.Program // The compiled code
+	idle    chan *jsVM    // The runtimes which can be reused
+}
+
+// acquire returns an idle runtime of the script, or a new one
+func (c *jsCode) acquire() (*jsVM, error) {
+	select {
+	case vm := <-c.idle:
+		return vm, nil
+	default:
+	}
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStack)
+	if _, err := vm.RunProgram(c.program); err != nil {
+		return nil, err
+	}
+
+	main, ok := goja.AssertFunction(vm.Get("main"))
+	if !ok {
+		return nil, fmt.Errorf("does not have a main function")
+	}
+
+	return &jsVM{runtime: vm, main: main}, nil
+}
+
+// release returns a runtime to the idle ones, unless there are enough of them
+func (c *jsCode) release(vm *jsVM) {
+	select {
+	case c.idle <- vm:
+	default:
+	}
+}
+
+// jsVM represents a runtime, which runs a single invocation at a time
+type jsVM struct {
+	runtime *goja.Runtime // The runtime of the script
+	main    goja.Callable // The main function of the script
+}
+
+// invoke calls the main function on a copy of the row, interrupting it once the context is done or the timeout
+// has elapsed
+func (vm *jsVM) invoke(ctx context.Context, timeout time.Duration, row map[string]interface{}) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Interrupt the runtime once the context is done, and wait for it so the interruption does not outlive it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			vm.runtime.Interrupt(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	defer vm.runtime.ClearInterrupt()
+	defer wg.Wait()
+	defer close(done)
+
+	// The script gets a copy, so that it can not modify the row
+	arg := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		arg[k] = v
+	}
+
+	out, err := vm.main(goja.Undefined(), vm.runtime.ToValue(arg))
+	if err != nil {
+		return nil, err
+	}
+
+	if goja.IsUndefined(out) || goja.IsNull(out) {
+		return nil, nil
+	}
+	return out.Export(), nil
+}
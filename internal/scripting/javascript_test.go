@@ -0,0 +1,114 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package script
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJavaScript(t *testing.T) {
+	l := NewLoader(nil)
+	s, err := l.LoadJavaScript("split", `
+	function main(row) {
+		var parts = row.event.split(".");
+		row.event = "modified";
+		return { scope: parts[0], count: parts.length, ratio: parts.length / 4 };
+	}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "split", s.Name())
+	assert.Len(t, s.Version(), 8)
+
+	// The runtimes are reused concurrently, and the row given is not modified
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			row := map[string]interface{}{"event": "table1.update"}
+			out, err := s.Run(context.Background(), row)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"scope": "table1", "count": int64(2), "ratio": 0.5}, out)
+			assert.Equal(t, "table1.update", row["event"])
+		}()
+	}
+	wg.Wait()
+
+	// A runtime error is returned, and an undefined value is no value
+	_, err = s.Run(context.Background(), map[string]interface{}{})
+	assert.Error(t, err)
+
+	none, err := l.LoadJavaScript("none", "function main(row) {\n}")
+	assert.NoError(t, err)
+	out, err := none.Run(context.Background(), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+}
+
+func TestJavaScript_Update(t *testing.T) {
+	l := NewLoader(nil)
+	s, err := l.LoadJavaScript("data", "function main(row) {\n return 1;\n}")
+	assert.NoError(t, err)
+	version := s.Version()
+
+	// Code which does not compile, has no main or never completes is rejected
+	assert.Error(t, l.updateJavaScript(s, []byte(`function main(row {`)))
+	assert.Error(t, l.updateJavaScript(s, []byte(`var x = 1;`)))
+	assert.Error(t, l.updateJavaScript(s, []byte("function main(row) {\n while (true) {}\n}")))
+	assert.Equal(t, version, s.Version())
+
+	// Runtime errors on an empty row are tolerated
+	assert.NoError(t, l.updateJavaScript(s, []byte("function main(row) {\n return row.event.toUpperCase();\n}")))
+	assert.NotEqual(t, version, s.Version())
+
+	out, err := s.Run(context.Background(), map[string]interface{}{"event": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "A", out)
+}
+
+func TestJavaScript_Sandbox(t *testing.T) {
+	l := NewLoader(nil)
+	l.SetLimits(config.Scripting{Timeout: 50})
+
+	// The runtime has no access to the host
+	s, err := l.LoadJavaScript("data", "function main(row) {\n return typeof require + typeof process;\n}")
+	assert.NoError(t, err)
+	out, err := s.Run(context.Background(), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "undefinedundefined", out)
+
+	// A script which stops completing is interrupted at the time limit, and its runtime reused
+	s, err = l.LoadJavaScript("loop", "function main(row) {\n while (row.loop) {}\n return 1;\n}")
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background(), map[string]interface{}{"loop": true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "did not complete within 50ms")
+
+	out, err = s.Run(context.Background(), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), out)
+}
+
+func TestJavaScript_URL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "javascript")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "double.js")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("function main(row) { return row.value * 2; }"), 0644))
+
+	s, err := NewLoader(nil).LoadJavaScript("double", "file:///"+filepath.ToSlash(path))
+	assert.NoError(t, err)
+	out, err := s.Run(context.Background(), map[string]interface{}{"value": 21})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), out)
+}
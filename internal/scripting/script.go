@@ -6,25 +6,67 @@ package script
 import (
 	"bytes"
 	"context"
-	"io"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grab/async"
 	"github.com/kelindar/loader"
 	"github.com/kelindar/lua"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/tetratelabs/wazero"
 )
 
+const (
+	ctxTag         = "script"
+	watchEvery     = 5 * time.Minute
+	smokeTimeout   = time.Second
+	defaultTimeout = 100 * time.Millisecond
+)
+
+// The prelude which removes the access to the filesystem and the operating system, both from the globals and
+// from the modules already loaded, so that require only returns the safe modules and the ones injected by the
+// node. It is kept on a single line so that the line numbers reported in the errors match the ones of the
+// script, and within a block so that the script can not reach its locals.
+const sandbox = `do local os_, loaded = os, package.loaded; ` +
+	`os = { time = os_.time, clock = os_.clock, date = os_.date, difftime = os_.difftime }; ` +
+	`loaded.os = os; loaded.io = nil; loaded.debug = nil; end; ` +
+	`io = nil; debug = nil; dofile = nil; loadfile = nil; load = nil; loadstring = nil; ` +
+	`package.loadlib = nil; package.path = ""; package.cpath = ""; `
+
+// The error returned by the scripting runtime when the script has no main function
+const errNoMain = "lua: script is not in a valid state"
+
 // Default empty script
 const emptyScript = `function main(row) 
 	return null
 end`
 
+// The engines running the scripts
+const (
+	EngineLua        = "lua"
+	EngineJavaScript = "javascript"
+	EngineWASM       = "wasm"
+)
+
 // Loader represents a script loader
 type Loader struct {
-	modules []lua.Module   // The modules for the scripting environment
-	loader  *loader.Loader // The loader to use to load and watch for code updates
+	modules  []lua.Module    // The modules for the scripting environment
+	loader   *loader.Loader  // The loader to use to load and watch for code updates
+	monitor  monitor.Monitor // The monitor to report the script versions to (optional)
+	versions sync.Map        // The versions of the scripts loaded
+	timeout  time.Duration   // The maximum time a single invocation can run for
+	unsafe   bool            // Whether the scripts can access the filesystem and the operating system
+	engine   string          // The engine of the scripts given as code
+	wasm     wazero.Runtime  // The runtime of the WebAssembly modules, created on first use
+	wasmOnce sync.Once       // The creation of the WebAssembly runtime
+	wasmErr  error           // The error creating the WebAssembly runtime
 }
 
 // NewLoader creates a new loader that can be used to load scripts
@@ -32,11 +74,73 @@ func NewLoader(modules []lua.Module) *Loader {
 	return &Loader{
 		modules: modules,
 		loader:  loader.New(),
+		timeout: defaultTimeout,
+		engine:  EngineLua,
+	}
+}
+
+// SetMonitor sets the monitor to report the script updates to.
+func (l *Loader) SetMonitor(monitor monitor.Monitor) {
+	l.monitor = monitor
+}
+
+// SetLimits sets the limits and the engine of the scripting environment, for the scripts loaded afterwards.
+func (l *Loader) SetLimits(conf config.Scripting) {
+	l.unsafe = conf.Unsafe
+	if l.timeout = time.Duration(conf.Timeout) * time.Millisecond; l.timeout <= 0 {
+		l.timeout = defaultTimeout
+	}
+
+	if l.engine = conf.Engine; l.engine == "" {
+		l.engine = EngineLua
+	}
+}
+
+// EngineOf returns the engine which runs a script. The scripts given as a URL are run according to their
+// extension, WebAssembly modules being always given as a URL ending in .wasm, while the scripts given as code
+// are run by the engine of the scripting environment, Lua by default.
+func (l *Loader) EngineOf(uriOrCode string) string {
+	u, err := url.Parse(uriOrCode)
+	if err != nil || u.Scheme == "" {
+		return l.engine
+	}
+
+	switch path := strings.ToLower(u.Path); {
+	case strings.HasSuffix(path, ".wasm"):
+		return EngineWASM
+	case strings.HasSuffix(path, ".js") || strings.HasSuffix(path, ".mjs"):
+		return EngineJavaScript
+	default:
+		return EngineLua
 	}
 }
 
+// Script represents a script loaded in the sandbox
+type Script struct {
+	*lua.Script
+	timeout time.Duration // The maximum time a single invocation can run for
+	loader  *Loader       // The loader which loaded the script
+}
+
+// Run runs the main function of the script with arguments, within the time limit of the sandbox. The runtime
+// bounds the call stack and the registry of the script, however it can neither limit its memory nor count its
+// instructions, so the time limit is what bounds each invocation.
+func (s *Script) Run(ctx context.Context, args ...interface{}) (lua.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	out, err := s.Script.Run(ctx, args...)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		if s.loader.monitor != nil {
+			s.loader.monitor.Count1(ctxTag, "timeout", "script:"+s.Name())
+		}
+		return nil, fmt.Errorf("script: %s did not complete within %v", s.Name(), s.timeout)
+	}
+	return out, err
+}
+
 // Load creates a new script from code or URL and starts a watching if needed
-func (l *Loader) Load(name string, uriOrCode string) (*lua.Script, error) {
+func (l *Loader) Load(name string, uriOrCode string) (*Script, error) {
 
 	// Create an empty script, we'll update it right away
 	s, err := lua.FromString(name, emptyScript, l.modules...)
@@ -45,21 +149,47 @@ func (l *Loader) Load(name string, uriOrCode string) (*lua.Script, error) {
 	}
 
 	// If the string is actually a URL, try to download it
-	if err := l.watch(uriOrCode, s.Update); err != nil {
+	if err := l.watch(uriOrCode, s); err != nil {
 		return nil, err
 	}
 
-	return s, nil
+	return &Script{
+		Script:  s,
+		timeout: l.timeout,
+		loader:  l,
+	}, nil
+}
+
+// Version returns the version of the code currently used by the script, which is derived from its content.
+func (l *Loader) Version(s *Script) string {
+	return l.versionOf(s.Script)
+}
+
+// versionOf returns the version of the code currently used by the script
+func (l *Loader) versionOf(s interface{}) string {
+	if v, ok := l.versions.Load(s); ok {
+		return v.(string)
+	}
+	return ""
 }
 
 // Wtch starts watching for script updates
-func (l *Loader) watch(uriOrCode string, onUpdate func(io.Reader) error) error {
+func (l *Loader) watch(uriOrCode string, s *lua.Script) error {
 	if _, err := url.Parse(uriOrCode); err != nil {
-		return onUpdate(strings.NewReader(uriOrCode)) // Assume it's the actual lua code
+		return l.update(s, []byte(uriOrCode)) // Assume it's the actual lua code
 	}
 
-	// Start watching on the URL
-	updates := l.loader.Watch(context.Background(), uriOrCode, 5*time.Minute)
+	return l.watchURL(uriOrCode, s.Name(), func(code []byte) error {
+		return l.update(s, code)
+	}, func() string {
+		return l.versionOf(s)
+	})
+}
+
+// watchURL downloads the code of a script from a URL, applying it right away and then asynchronously whenever
+// it changes.
+func (l *Loader) watchURL(uri, name string, update func([]byte) error, version func() string) error {
+	updates := l.loader.Watch(context.Background(), uri, watchEvery)
 	u := <-updates
 	if u.Err != nil {
 		return u.Err
@@ -69,12 +199,83 @@ func (l *Loader) watch(uriOrCode string, onUpdate func(io.Reader) error) error {
 	async.Invoke(context.Background(), func(ctx context.Context) (interface{}, error) {
 		for u := range updates {
 			if u.Err == nil {
-				_ = onUpdate(bytes.NewReader(u.Data))
+				err := update(u.Data)
+				l.report(name, version(), err)
 			}
 		}
 		return nil, nil
 	})
 
 	// Perform a first update
-	return onUpdate(bytes.NewReader(u.Data))
+	if err := update(u.Data); err != nil {
+		return err
+	}
+
+	l.report(name, version(), nil)
+	return nil
+}
+
+// update swaps the code of the script, unless the code is unchanged or does not pass the smoke test,
+// in which case the script keeps running the previous version.
+func (l *Loader) update(s *lua.Script, code []byte) error {
+	hash := sha256.Sum256(code)
+	version := hex.EncodeToString(hash[:4])
+	if l.versionOf(s) == version {
+		return nil // Same content, nothing to update
+	}
+
+	// Unless explicitly allowed, run the script in the sandbox
+	if !l.unsafe {
+		code = append([]byte(sandbox), code...)
+	}
+
+	if err := l.smokeTest(s.Name(), code); err != nil {
+		return err
+	}
+
+	if err := s.Update(bytes.NewReader(code)); err != nil {
+		return err
+	}
+
+	l.versions.Store(s, version)
+	return nil
+}
+
+// smokeTest loads the code in a separate script and runs it on an empty row. The code is rejected
+// if it can not be compiled, has no main function or does not complete in time. Other runtime errors
+// are tolerated, as the script may legitimately expect some of the columns to be present.
+func (l *Loader) smokeTest(name string, code []byte) error {
+	candidate, err := lua.FromReader(name, bytes.NewReader(code), l.modules...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), smokeTimeout)
+	defer cancel()
+
+	switch _, err := candidate.Run(ctx, map[string]interface{}{}); {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("script: %s did not complete within %v", name, smokeTimeout)
+	case err.Error() == errNoMain:
+		return fmt.Errorf("script: %s does not have a main function", name)
+	default:
+		return nil
+	}
+}
+
+// report reports the outcome of a script update, if a monitor is set
+func (l *Loader) report(name, version string, err error) {
+	if l.monitor == nil {
+		return
+	}
+
+	if err != nil {
+		l.monitor.Count1(ctxTag, "update.error", "script:"+name)
+		l.monitor.Warning(errors.Internal(fmt.Sprintf("script: unable to update %s, keeping the previous version", name), err))
+		return
+	}
+
+	l.monitor.Gauge(ctxTag, "version", 1, "script:"+name, "version:"+version)
 }
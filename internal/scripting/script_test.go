@@ -6,8 +6,10 @@ package script
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/kelindar/lua"
+	"github.com/kelindar/talaria/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,3 +28,114 @@ func Test_Download(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, lua.String(`{"a":1,"b":"hello"}`), out)
 }
+
+func Test_Update(t *testing.T) {
+	l := NewLoader(nil)
+	s, err := l.Load("data", `
+	function main(row)
+		return 1
+	end`)
+	assert.NoError(t, err)
+	version := l.Version(s)
+	assert.Len(t, version, 8)
+
+	// The same code does not change the version
+	assert.NoError(t, l.update(s.Script, []byte(`
+	function main(row)
+		return 1
+	end`)))
+	assert.Equal(t, version, l.Version(s))
+
+	// Code which does not compile, has no main or never completes is rejected
+	assert.Error(t, l.update(s.Script, []byte(`function main(row`)))
+	assert.Error(t, l.update(s.Script, []byte(`local x = 1`)))
+	assert.Error(t, l.update(s.Script, []byte(`
+	function main(row)
+		while true do end
+	end`)))
+
+	// The previous version is still running
+	out, err := s.Run(context.Background(), map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, lua.Number(1), out)
+	assert.Equal(t, version, l.Version(s))
+
+	// Runtime errors on an empty row are tolerated
+	assert.NoError(t, l.update(s.Script, []byte(`
+	function main(row)
+		return string.upper(row["event"])
+	end`)))
+	assert.NotEqual(t, version, l.Version(s))
+
+	out, err = s.Run(context.Background(), map[string]interface{}{"event": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, lua.String("A"), out)
+}
+
+func Test_Sandbox(t *testing.T) {
+	l := NewLoader(nil)
+	l.SetLimits(config.Scripting{Timeout: 50})
+
+	// The filesystem and the operating system are not accessible
+	s, err := l.Load("data", `
+	function main(row)
+		return tostring(io) .. " " .. tostring(os.execute) .. " " .. type(os.time())
+	end`)
+	assert.NoError(t, err)
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, lua.String("nil nil number"), out)
+
+	// Nor through the modules already loaded, the locals of the sandbox or the loading of code
+	s, err = l.Load("data", `
+	function main(row)
+		return type(package.loaded.io) .. " " .. type(package.loaded.debug) .. " " .. type(require("os").execute) ..
+			" " .. type(os_) .. " " .. type(load) .. " " .. type(loadstring) .. " " .. type(require("json").encode)
+	end`)
+	assert.NoError(t, err)
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, lua.String("nil nil nil nil nil nil function"), out)
+
+	for _, module := range []string{"io", "debug"} {
+		s, err = l.Load("data", `
+		function main(row)
+			return type(require("`+module+`"))
+		end`)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background())
+		assert.Error(t, err, module)
+	}
+
+	// Errors report the line numbers of the script
+	s, err = l.Load("data", "function main(row)\n\treturn row.a + 1\nend")
+	assert.NoError(t, err)
+	_, err = s.Run(context.Background(), map[string]interface{}{})
+	assert.Contains(t, err.Error(), "data:2:")
+
+	// Scripts are interrupted once they run for too long
+	s, err = l.Load("data", `
+	function main(row)
+		if row["loop"] then
+			while true do end
+		end
+		return 1
+	end`)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = s.Run(context.Background(), map[string]interface{}{"loop": true})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// Unless explicitly allowed
+	l.SetLimits(config.Scripting{Unsafe: true})
+	s, err = l.Load("data", `
+	function main(row)
+		return type(io)
+	end`)
+	assert.NoError(t, err)
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, lua.String("table"), out)
+}
@@ -0,0 +1,297 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package script
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// The maximum memory of an instance of a WebAssembly module, in pages of 64KB
+const wasmMemoryPages = 256 // 16 MB
+
+// Module represents a WebAssembly module loaded in the sandbox. The module exports an 'alloc' function, which
+// reserves the given number of bytes in its memory, and a 'main' function, which takes the address and length
+// of the row encoded as JSON and returns the value computed, encoded as JSON, packed as its address in the
+// upper 32 bits and its length in the lower ones, a length of zero meaning that there is no value.
+type Module struct {
+	name    string        // The name of the module
+	timeout time.Duration // The maximum time a single invocation can run for
+	loader  *Loader       // The loader which loaded the module
+	code    atomic.Value  // The compiled code currently used by the module
+}
+
+// LoadWASM creates a new module from a URL and starts watching it for updates.
+func (l *Loader) LoadWASM(name string, uri string) (*Module, error) {
+	m := &Module{
+		name:    name,
+		timeout: l.timeout,
+		loader:  l,
+	}
+
+	if err := l.watchURL(uri, name, func(code []byte) error {
+		return l.updateWASM(m, code)
+	}, func() string {
+		return l.versionOf(m)
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Name returns the name of the module
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Version returns the version of the code currently used by the module, which is derived from its content.
+func (m *Module) Version() string {
+	return m.loader.versionOf(m)
+}
+
+// Run runs the main function of the module on a row, within the time limit of the sandbox. As the runtime does
+// not meter the instructions executed, the time limit is what bounds each invocation. The value returned is
+// decoded from JSON, with its numbers as json.Number.
+func (m *Module) Run(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+	input, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	code := m.code.Load().(*wasmCode)
+	instance, err := code.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	// An instance which failed is discarded, as its state may be corrupted or it may have been closed
+	output, err := invoke(ctx, instance, input)
+	if err != nil {
+		_ = instance.Close(context.Background())
+		if ctx.Err() == context.DeadlineExceeded {
+			if m.loader.monitor != nil {
+				m.loader.monitor.Count1(ctxTag, "timeout", "script:"+m.name)
+			}
+			return nil, fmt.Errorf("script: %s did not complete within %v", m.name, m.timeout)
+		}
+		return nil, fmt.Errorf("script: %s failed: %v", m.name, err)
+	}
+
+	code.release(instance)
+	if len(output) == 0 {
+		return nil, nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("script: %s returned invalid JSON: %v", m.name, err)
+	}
+	return value, nil
+}
+
+// invoke copies the input in the memory of the instance, calls its main function and returns a copy of its output
+func invoke(ctx context.Context, instance api.Module, input []byte) ([]byte, error) {
+	result, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	ptr := uint32(result[0])
+	if !instance.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("alloc returned an address out of the memory")
+	}
+
+	if result, err = instance.ExportedFunction("main").Call(ctx, uint64(ptr), uint64(len(input))); err != nil {
+		return nil, err
+	}
+
+	outPtr, outLen := uint32(result[0]>>32), uint32(result[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+
+	output, ok := instance.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("main returned a value out of the memory")
+	}
+	return append([]byte(nil), output...), nil
+}
+
+// updateWASM swaps the code of the module, unless the code is unchanged or does not pass the smoke test, in which
+// case the module keeps running the previous version.
+func (l *Loader) updateWASM(m *Module, code []byte) error {
+	hash := sha256.Sum256(code)
+	version := hex.EncodeToString(hash[:4])
+	if l.versionOf(m) == version {
+		return nil // Same content, nothing to update
+	}
+
+	compiled, err := l.compileWASM(m.name, code)
+	if err != nil {
+		return err
+	}
+
+	if previous, ok := m.code.Load().(*wasmCode); ok {
+		defer previous.close()
+	}
+
+	m.code.Store(compiled)
+	l.versions.Store(m, version)
+	return nil
+}
+
+// compileWASM compiles the code of a module and runs it on an empty row. The code is rejected if it can not be
+// compiled, does not export the functions expected or does not complete in time. Other runtime errors are
+// tolerated, as the module may legitimately expect some of the columns to be present.
+func (l *Loader) compileWASM(name string, code []byte) (*wasmCode, error) {
+	wasm, err := l.runtime()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	compiled, err := wasm.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("script: unable to compile %s: %v", name, err)
+	}
+
+	if err := validateExports(compiled); err != nil {
+		_ = compiled.Close(ctx)
+		return nil, fmt.Errorf("script: %s %v", name, err)
+	}
+
+	candidate := &wasmCode{
+		runtime:  wasm,
+		compiled: compiled,
+		idle:     make(chan api.Module, runtime.GOMAXPROCS(0)),
+	}
+
+	instance, err := candidate.acquire()
+	if err != nil {
+		candidate.close()
+		return nil, fmt.Errorf("script: unable to instantiate %s: %v", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smokeTimeout)
+	defer cancel()
+
+	switch _, err := invoke(ctx, instance, []byte("{}")); {
+	case err == nil:
+		candidate.release(instance)
+	case ctx.Err() != nil:
+		_ = instance.Close(context.Background())
+		candidate.close()
+		return nil, fmt.Errorf("script: %s did not complete within %v", name, smokeTimeout)
+	default:
+		_ = instance.Close(context.Background())
+	}
+	return candidate, nil
+}
+
+// validateExports checks whether the module exports the functions expected, with their signatures
+func validateExports(compiled wazero.CompiledModule) error {
+	expected := map[string][2][]api.ValueType{
+		"alloc": {{api.ValueTypeI32}, {api.ValueTypeI32}},
+		"main":  {{api.ValueTypeI32, api.ValueTypeI32}, {api.ValueTypeI64}},
+	}
+
+	exports := compiled.ExportedFunctions()
+	for name, signature := range expected {
+		fn, ok := exports[name]
+		if !ok {
+			return fmt.Errorf("does not export a %s function", name)
+		}
+
+		if !bytes.Equal(fn.ParamTypes(), signature[0]) || !bytes.Equal(fn.ResultTypes(), signature[1]) {
+			return fmt.Errorf("exports a %s function with an unexpected signature", name)
+		}
+	}
+	return nil
+}
+
+// runtime returns the WebAssembly runtime of the loader, creating it on first use. The modules can not access
+// the filesystem, the environment or the network, and their memory is limited.
+func (l *Loader) runtime() (wazero.Runtime, error) {
+	l.wasmOnce.Do(func() {
+		ctx := context.Background()
+		l.wasm = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(wasmMemoryPages))
+		_, l.wasmErr = wasi_snapshot_preview1.Instantiate(ctx, l.wasm)
+	})
+	return l.wasm, l.wasmErr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// wasmCode represents a version of the code of a module, along with its idle instances
+type wasmCode struct {
+	runtime  wazero.Runtime        // The runtime which compiled the code
+	compiled wazero.CompiledModule // The compiled code
+	lock     sync.Mutex            // The lock protecting the idle instances
+	idle     chan api.Module       // The instances which can be reused
+	closed   bool                  // Whether the code was replaced by another version
+}
+
+// acquire returns an idle instance of the module, or a new one
+func (c *wasmCode) acquire() (api.Module, error) {
+	select {
+	case instance := <-c.idle:
+		return instance, nil
+	default:
+		return c.runtime.InstantiateModule(context.Background(), c.compiled, wazero.NewModuleConfig().
+			WithName("").
+			WithStartFunctions("_initialize"))
+	}
+}
+
+// release returns an instance to the idle ones, or closes it if there are enough of them or if the code was
+// replaced by another version
+func (c *wasmCode) release(instance api.Module) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		select {
+		case c.idle <- instance:
+			return
+		default:
+		}
+	}
+
+	_ = instance.Close(context.Background())
+}
+
+// close closes the idle instances and the compiled code, the instances in use being closed once released
+func (c *wasmCode) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for {
+		select {
+		case instance := <-c.idle:
+			_ = instance.Close(context.Background())
+		default:
+			_ = c.compiled.Close(context.Background())
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmOf assembles a module exporting its memory, a bump 'alloc' function starting at 1024 and a 'main'
+// function with the body provided, along with the data copied at the start of its memory
+func wasmOf(main []byte, data string) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e)...)
+	module = append(module, section(3, 0x02, 0x00, 0x01)...)
+	module = append(module, section(5, 0x01, 0x00, 0x01)...)
+	module = append(module, section(6, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b)...)
+	module = append(module, section(7, 0x03,
+		0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+		0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+		0x04, 'm', 'a', 'i', 'n', 0x00, 0x01)...)
+
+	// alloc: global.get 0, global.get 0, local.get 0, i32.add, global.set 0
+	alloc := []byte{0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b}
+	code := append([]byte{0x02, byte(len(alloc))}, alloc...)
+	code = append(append(code, byte(len(main))), main...)
+	module = append(module, section(10, code...)...)
+
+	if data != "" {
+		segment := append([]byte{0x01, 0x00, 0x41, 0x00, 0x0b, byte(len(data))}, data...)
+		module = append(module, section(11, segment...)...)
+	}
+	return module
+}
+
+var (
+	// main returns the row it was given: (ptr << 32) | len
+	wasmEcho = wasmOf([]byte{0x00, 0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84, 0x0b}, "")
+
+	// main returns the 2 bytes at the start of the memory
+	wasmAnswer = wasmOf([]byte{0x00, 0x42, 0x02, 0x0b}, "42")
+
+	// main never completes
+	wasmLoop = wasmOf([]byte{0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00, 0x0b}, "")
+)
+
+// writeWASM writes a module to a temporary file and returns its URL
+func writeWASM(t *testing.T, dir, name string, code []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, code, 0644))
+	return "file:///" + filepath.ToSlash(path)
+}
+
+func TestEngineOf(t *testing.T) {
+	l := NewLoader(nil)
+	assert.Equal(t, EngineWASM, l.EngineOf("s3://bucket/columns/geohash.wasm"))
+	assert.Equal(t, EngineWASM, l.EngineOf("gcs://bucket/columns/GEOHASH.WASM"))
+	assert.Equal(t, EngineJavaScript, l.EngineOf("https://example.com/columns/geohash.js"))
+	assert.Equal(t, EngineLua, l.EngineOf("s3://bucket/columns/geohash.lua"))
+	assert.Equal(t, EngineLua, l.EngineOf("function main(row)\n return 'a.wasm'\nend"))
+
+	// The scripts given as code are run by the engine of the environment
+	l.SetLimits(config.Scripting{Engine: EngineJavaScript})
+	assert.Equal(t, EngineJavaScript, l.EngineOf("function main(row) {\n return 1\n}"))
+	assert.Equal(t, EngineLua, l.EngineOf("s3://bucket/columns/geohash.lua"))
+}
+
+func TestWASM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l := NewLoader(nil)
+	l.SetLimits(config.Scripting{Timeout: 50})
+
+	// The row is passed to the module as JSON, and its value returned decoded from JSON
+	echo, err := l.LoadWASM("echo", writeWASM(t, dir, "echo.wasm", wasmEcho))
+	assert.NoError(t, err)
+	assert.Equal(t, "echo", echo.Name())
+	assert.Len(t, echo.Version(), 8)
+
+	for i := 0; i < 3; i++ {
+		out, err := echo.Run(context.Background(), map[string]interface{}{"a": 1, "b": "hello"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"a": json.Number("1"), "b": "hello"}, out)
+	}
+
+	answer, err := l.LoadWASM("answer", writeWASM(t, dir, "answer.wasm", wasmAnswer))
+	assert.NoError(t, err)
+	out, err := answer.Run(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("42"), out)
+
+	// The modules which can not be compiled, do not export the functions or never complete are rejected
+	_, err = l.LoadWASM("invalid", writeWASM(t, dir, "invalid.wasm", []byte("function main(row) end")))
+	assert.Error(t, err)
+	_, err = l.LoadWASM("loop", writeWASM(t, dir, "loop.wasm", wasmLoop))
+	assert.Error(t, err)
+
+	// An update which is rejected keeps the previous version running
+	version := echo.Version()
+	assert.Error(t, l.updateWASM(echo, wasmLoop))
+	assert.Equal(t, version, echo.Version())
+
+	// An update swaps the code of the module
+	assert.NoError(t, l.updateWASM(echo, wasmAnswer))
+	assert.NotEqual(t, version, echo.Version())
+	out, err = echo.Run(context.Background(), map[string]interface{}{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("42"), out)
+}
+
+func TestWASM_Timeout(t *testing.T) {
+	l := NewLoader(nil)
+	l.SetLimits(config.Scripting{Timeout: 50})
+
+	// A module which stops completing, once past the smoke test, is interrupted at the time limit
+	wasm, err := l.runtime()
+	assert.NoError(t, err)
+	compiled, err := wasm.CompileModule(context.Background(), wasmLoop)
+	assert.NoError(t, err)
+
+	m := &Module{name: "loop", timeout: l.timeout, loader: l}
+	m.code.Store(&wasmCode{runtime: wasm, compiled: compiled, idle: make(chan api.Module, 1)})
+
+	_, err = m.Run(context.Background(), map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "did not complete within 50ms")
+}
@@ -0,0 +1,107 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package acl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kelindar/talaria/internal/config"
+)
+
+const anyTable = "*"
+
+// rule represents the tables and the columns a client can query
+type rule struct {
+	tenant  string                         // The tenant of the client, restricted to the tables of its namespace (optional)
+	tables  map[string]struct{}            // The tables the client can query
+	columns map[string]map[string]struct{} // The columns the client can query, per table (optional)
+}
+
+// ACL represents the access control of the queries, per client
+type ACL struct {
+	rules map[string]*rule
+}
+
+// New creates a new access control list, or returns nil if every client can query every table.
+func New(conf []config.Access) *ACL {
+	if len(conf) == 0 {
+		return nil
+	}
+
+	acl := &ACL{rules: make(map[string]*rule, len(conf))}
+	for _, access := range conf {
+		r := &rule{
+			tenant:  access.Tenant,
+			tables:  make(map[string]struct{}, len(access.Tables)),
+			columns: make(map[string]map[string]struct{}, len(access.Columns)),
+		}
+
+		for _, t := range access.Tables {
+			r.tables[t] = struct{}{}
+		}
+
+		for t, columns := range access.Columns {
+			r.columns[t] = make(map[string]struct{}, len(columns))
+			for _, c := range columns {
+				r.columns[t][c] = struct{}{}
+			}
+		}
+		acl.rules[access.Identity] = r
+	}
+	return acl
+}
+
+// CanQuery checks whether the client can query the table, regardless of the columns
+func (a *ACL) CanQuery(identity, table string) bool {
+	if a == nil {
+		return true
+	}
+
+	r, ok := a.rules[identity]
+	if !ok {
+		return false
+	}
+
+	if r.tenant != "" && !strings.HasPrefix(table, r.tenant+".") {
+		return false
+	}
+
+	_, all := r.tables[anyTable]
+	_, one := r.tables[table]
+	return all || one
+}
+
+// CanSelect checks whether the column of the table can be queried by the client
+func (a *ACL) CanSelect(identity, table, column string) bool {
+	if !a.CanQuery(identity, table) {
+		return false
+	}
+
+	if a == nil {
+		return true
+	}
+
+	allowed, ok := a.rules[identity].columns[table]
+	if !ok {
+		return true
+	}
+
+	_, ok = allowed[column]
+	return ok
+}
+
+// Check returns an error if the client can not query the columns of the table
+func (a *ACL) Check(identity, table string, columns []string) error {
+	if !a.CanQuery(identity, table) {
+		return fmt.Errorf("'%s' can not query table %s", identity, table)
+	}
+
+	for _, c := range columns {
+		if !a.CanSelect(identity, table, c) {
+			return fmt.Errorf("'%s' can not query column %s of table %s", identity, c, table)
+		}
+	}
+	return nil
+}
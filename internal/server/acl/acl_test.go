@@ -0,0 +1,50 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package acl
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	acl := New([]config.Access{
+		{Identity: "analyst", Tables: []string{"eventlog", "payments.orders"}, Columns: map[string][]string{
+			"payments.orders": {"id", "amount"},
+		}},
+		{Identity: "admin", Tables: []string{"*"}},
+		{Identity: "ledger", Tables: []string{"*"}, Tenant: "payments"},
+	})
+
+	assert.True(t, acl.CanQuery("analyst", "eventlog"))
+	assert.True(t, acl.CanQuery("admin", "eventlog"))
+	assert.False(t, acl.CanQuery("analyst", "nodes"))
+	assert.False(t, acl.CanQuery("unknown", "eventlog"))
+	assert.False(t, acl.CanQuery("", "eventlog"))
+
+	// The clients of a tenant only query the tables of its namespace
+	assert.True(t, acl.CanQuery("ledger", "payments.orders"))
+	assert.False(t, acl.CanQuery("ledger", "eventlog"))
+	assert.False(t, acl.CanQuery("ledger", "paymentsorders"))
+
+	// The columns are only restricted for the tables listed
+	assert.True(t, acl.CanSelect("analyst", "eventlog", "email"))
+	assert.True(t, acl.CanSelect("analyst", "payments.orders", "amount"))
+	assert.False(t, acl.CanSelect("analyst", "payments.orders", "email"))
+	assert.False(t, acl.CanSelect("analyst", "nodes", "address"))
+
+	assert.NoError(t, acl.Check("analyst", "payments.orders", []string{"id", "amount"}))
+	assert.Error(t, acl.Check("analyst", "payments.orders", []string{"id", "email"}))
+	assert.Error(t, acl.Check("analyst", "nodes", nil))
+}
+
+func TestACL_Empty(t *testing.T) {
+	acl := New(nil)
+	assert.Nil(t, acl)
+	assert.True(t, acl.CanQuery("", "eventlog"))
+	assert.True(t, acl.CanSelect("", "eventlog", "email"))
+	assert.NoError(t, acl.Check("", "eventlog", []string{"email"}))
+}
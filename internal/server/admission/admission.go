@@ -0,0 +1,91 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package admission
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrRejected is returned when a request could not be admitted in time.
+var ErrRejected = errors.New("admission: the node is overloaded, please retry later")
+
+// Controller caps the number of concurrent splits being served and the total number of bytes of the
+// column blocks in flight, queueing the requests beyond the limits for up to a timeout.
+type Controller struct {
+	splits   *semaphore.Weighted // The semaphore for the concurrent splits (optional)
+	bytes    *semaphore.Weighted // The semaphore for the in-flight bytes (optional)
+	maxBytes int64               // The maximum number of in-flight bytes
+	timeout  time.Duration       // The maximum time a request is queued for
+	inflight int64               // The number of bytes admitted and not released yet
+}
+
+// New creates a new admission controller. A non-positive limit disables the corresponding check.
+func New(maxSplits int, maxBytes int64, timeout time.Duration) *Controller {
+	c := &Controller{
+		maxBytes: maxBytes,
+		timeout:  timeout,
+	}
+
+	if maxSplits > 0 {
+		c.splits = semaphore.NewWeighted(int64(maxSplits))
+	}
+
+	if maxBytes > 0 {
+		c.bytes = semaphore.NewWeighted(maxBytes)
+	}
+	return c
+}
+
+// Acquire admits a request which reads up to the specified number of bytes. The returned function
+// must be called once the request is served.
+func (c *Controller) Acquire(bytes int64) (func(), error) {
+	requested := bytes
+	if bytes > c.maxBytes {
+		bytes = c.maxBytes // A single request may use all of the budget
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if c.splits != nil && !acquire(ctx, c.splits, 1) {
+		return nil, ErrRejected
+	}
+
+	if c.bytes != nil && bytes > 0 && !acquire(ctx, c.bytes, bytes) {
+		if c.splits != nil {
+			c.splits.Release(1)
+		}
+		return nil, ErrRejected
+	}
+
+	atomic.AddInt64(&c.inflight, requested)
+	return func() {
+		atomic.AddInt64(&c.inflight, -requested)
+		if c.bytes != nil && bytes > 0 {
+			c.bytes.Release(bytes)
+		}
+		if c.splits != nil {
+			c.splits.Release(1)
+		}
+	}, nil
+}
+
+// InFlight returns the number of bytes the requests admitted and not released yet may read.
+func (c *Controller) InFlight() int64 {
+	return atomic.LoadInt64(&c.inflight)
+}
+
+// acquire acquires the semaphore, waiting until the context is done
+func acquire(ctx context.Context, sem *semaphore.Weighted, n int64) bool {
+	if sem.TryAcquire(n) {
+		return true
+	}
+
+	return sem.Acquire(ctx, n) == nil
+}
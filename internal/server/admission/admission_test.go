@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission_Splits(t *testing.T) {
+	c := New(1, 0, time.Millisecond)
+
+	release, err := c.Acquire(100)
+	assert.NoError(t, err)
+
+	// The second split is rejected until the first one is released
+	_, err = c.Acquire(100)
+	assert.Equal(t, ErrRejected, err)
+
+	release()
+	release, err = c.Acquire(100)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestAdmission_Bytes(t *testing.T) {
+	c := New(0, 100, time.Millisecond)
+
+	// A single large request uses the whole budget
+	release, err := c.Acquire(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), c.InFlight())
+
+	_, err = c.Acquire(1)
+	assert.Equal(t, ErrRejected, err)
+	release()
+	assert.Equal(t, int64(0), c.InFlight())
+
+	// Queued requests are admitted once the budget is released
+	release, err = c.Acquire(60)
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		release()
+	}()
+
+	c.timeout = time.Second
+	release, err = c.Acquire(60)
+	assert.NoError(t, err)
+	release()
+}
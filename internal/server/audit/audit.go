@@ -0,0 +1,156 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "audit"
+
+// Writer represents a destination to which the entries are uploaded, such as an S3 bucket.
+type Writer interface {
+	Write(key key.Key, value []byte) error
+}
+
+// Entry represents a single operation recorded in the audit trail
+type Entry struct {
+	Time     time.Time              `json:"time"`              // The time at which the operation was completed
+	Node     string                 `json:"node"`              // The node which served the operation
+	Identity string                 `json:"identity"`          // The client which requested the operation
+	Address  string                 `json:"address,omitempty"` // The network address of the client, if any
+	Action   string                 `json:"action"`            // The operation, such as "table.drop"
+	Params   map[string]interface{} `json:"params,omitempty"`  // The parameters of the operation
+	Error    string                 `json:"error,omitempty"`   // The reason the operation failed or was denied
+}
+
+// Trail represents an append-only audit trail, written to a local file and uploaded in batches. An entry is
+// never modified once written: the file is only appended to and every batch is uploaded as a new object.
+type Trail struct {
+	lock    sync.Mutex      // The lock protecting the file and the pending entries
+	node    string          // The name of the node, prefixing the uploaded batches
+	file    *os.File        // The file the entries are appended to (optional)
+	dest    Writer          // The destination of the batches (optional)
+	pending bytes.Buffer    // The entries not uploaded yet
+	monitor monitor.Monitor // The monitoring layer
+}
+
+// New creates a new audit trail, appending the entries to the file and uploading them to the destination,
+// either of which is optional.
+func New(node, path string, dest Writer, monitor monitor.Monitor) (*Trail, error) {
+	t := &Trail{
+		node:    node,
+		dest:    dest,
+		monitor: monitor,
+	}
+
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Internal("audit: unable to open "+path, err)
+		}
+		t.file = f
+	}
+	return t, nil
+}
+
+// Record records an entry, which is synced to the file before returning. A nil trail records nothing.
+func (t *Trail) Record(e Entry) {
+	if t == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	e.Node = t.node
+	line, err := json.Marshal(e)
+	if err != nil {
+		t.monitor.Error(errors.Internal("audit: unable to encode an entry", err))
+		return
+	}
+
+	line = append(line, '\n')
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.monitor.Count1(ctxTag, "record", "action:"+e.Action)
+	if t.dest != nil {
+		t.pending.Write(line)
+	}
+
+	if t.file != nil {
+		if _, err := t.file.Write(line); err != nil {
+			t.monitor.Error(errors.Internal("audit: unable to write an entry", err))
+			return
+		}
+
+		if err := t.file.Sync(); err != nil {
+			t.monitor.Error(errors.Internal("audit: unable to sync", err))
+		}
+	}
+}
+
+// Run uploads the pending entries on a regular interval, until the context is cancelled.
+func (t *Trail) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			if err := t.Upload(); err != nil {
+				t.monitor.Warning(err)
+			}
+		}
+	}
+}
+
+// Upload uploads the pending entries as a new object, named after the node and the time of the upload. The
+// entries are kept pending if the upload fails, so they are part of the next batch.
+func (t *Trail) Upload() error {
+	if t == nil || t.dest == nil {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending.Len() == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s/%s/%s.jsonl", t.node, now.Format("2006-01-02"), now.Format("15-04-05.000000000"))
+	if err := t.dest.Write(key.Key(name), t.pending.Bytes()); err != nil {
+		return errors.Internal("audit: unable to upload", err)
+	}
+
+	t.monitor.Count1(ctxTag, "upload")
+	t.pending.Reset()
+	return nil
+}
+
+// Close uploads the pending entries and closes the file.
+func (t *Trail) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	err := t.Upload()
+	if t.file != nil {
+		if cerr := t.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryWriter records the objects uploaded, failing while broken
+type memoryWriter struct {
+	objects map[string]string
+	broken  bool
+}
+
+func (w *memoryWriter) Write(k key.Key, v []byte) error {
+	if w.broken {
+		return errors.New("broken")
+	}
+
+	w.objects[string(k)] = string(v)
+	return nil
+}
+
+func TestTrail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	dest := &memoryWriter{objects: map[string]string{}, broken: true}
+	trail, err := New("node-1", path, dest, monitor.NewNoop())
+	assert.NoError(t, err)
+
+	trail.Record(Entry{Identity: "admin", Action: "table.drop", Params: map[string]interface{}{"table": "events"}})
+	trail.Record(Entry{Identity: "analyst", Action: "query.denied", Error: "denied"})
+
+	// The entries are kept until they are uploaded
+	assert.Error(t, trail.Upload())
+	dest.broken = false
+	assert.NoError(t, trail.Upload())
+	assert.Len(t, dest.objects, 1)
+	for name, batch := range dest.objects {
+		assert.True(t, strings.HasPrefix(name, "node-1/"))
+		assert.Equal(t, 2, strings.Count(batch, "\n"))
+	}
+
+	// Nothing is uploaded without new entries
+	assert.NoError(t, trail.Upload())
+	assert.Len(t, dest.objects, 1)
+	assert.NoError(t, trail.Close())
+
+	// The file is appended to when reopened
+	trail, err = New("node-1", path, nil, monitor.NewNoop())
+	assert.NoError(t, err)
+	trail.Record(Entry{Identity: "system", Action: "config.reload"})
+	assert.NoError(t, trail.Close())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.Equal(t, "node-1", e.Node)
+		assert.False(t, e.Time.IsZero())
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"table.drop", "query.denied", "config.reload"}, actions)
+}
+
+func TestTrail_Nil(t *testing.T) {
+	var trail *Trail
+	trail.Record(Entry{Action: "flush"})
+	assert.NoError(t, trail.Upload())
+	assert.NoError(t, trail.Close())
+}
@@ -0,0 +1,207 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	defaultClaim       = "tables"
+	defaultTenantClaim = "tenant"
+	anyTable           = "*"
+)
+
+// Identity represents an authenticated producer
+type Identity struct {
+	Name     string              // The name of the producer
+	Internal bool                // Whether the identity is a node of the cluster
+	Tenant   string              // The tenant of the producer, if any
+	tables   map[string]struct{} // The tables the producer can write to
+}
+
+// Allows checks whether the identity can write to the table
+func (i *Identity) Allows(table string) bool {
+	if i == nil || i.Internal {
+		return true
+	}
+
+	if _, ok := i.tables[anyTable]; ok {
+		return true
+	}
+
+	_, ok := i.tables[table]
+	return ok
+}
+
+// Authenticator authenticates the producers by their API key or their JSON Web Token
+type Authenticator struct {
+	token string               // The token of the nodes of the cluster
+	keys  map[string]*Identity // The identities by their API key
+	jwt   *config.JWT          // The validation of the JSON Web Tokens (optional)
+}
+
+// New creates a new authenticator, or returns nil if the producers are not authenticated.
+func New(conf *config.Auth) *Authenticator {
+	if conf == nil {
+		return nil
+	}
+
+	a := &Authenticator{
+		token: conf.Token,
+		keys:  make(map[string]*Identity, len(conf.Keys)),
+		jwt:   conf.JWT,
+	}
+
+	for _, k := range conf.Keys {
+		a.keys[k.Key] = newIdentity(k.Name, k.Tenant, k.Tables)
+	}
+	return a
+}
+
+// Authenticate returns the identity of the bearer token of a request
+func (a *Authenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, errors.Unauthenticated("auth: a bearer token is required")
+	}
+
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+		return &Identity{Name: "cluster", Internal: true}, nil
+	}
+
+	// A JSON Web Token has 3 parts separated by dots, which an API key is not expected to have
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		return a.verify(token, time.Now())
+	}
+
+	for key, identity := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return identity, nil
+		}
+	}
+
+	return nil, errors.Unauthenticated("auth: the token is invalid")
+}
+
+// claims represents the registered claims of a JSON Web Token
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+// verify verifies the signature and the claims of a JSON Web Token signed with HMAC-SHA256
+func (a *Authenticator) verify(token string, now time.Time) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	header, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, errors.Unauthenticated("auth: the token is malformed")
+	}
+
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return nil, errors.Unauthenticated("auth: the token must be signed with HS256")
+	}
+
+	// Check the signature before reading the claims
+	mac := hmac.New(sha256.New, []byte(a.jwt.Secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := decodeSegment(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.Unauthenticated("auth: the token signature is invalid")
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, errors.Unauthenticated("auth: the token is malformed")
+	}
+
+	var c claims
+	var all map[string]json.RawMessage
+	if json.Unmarshal(payload, &c) != nil || json.Unmarshal(payload, &all) != nil {
+		return nil, errors.Unauthenticated("auth: the token claims are malformed")
+	}
+
+	switch {
+	case c.ExpiresAt != 0 && now.Unix() >= c.ExpiresAt:
+		return nil, errors.Unauthenticated("auth: the token has expired")
+	case c.NotBefore != 0 && now.Unix() < c.NotBefore:
+		return nil, errors.Unauthenticated("auth: the token is not valid yet")
+	case a.jwt.Issuer != "" && c.Issuer != a.jwt.Issuer:
+		return nil, errors.Unauthenticated("auth: the token issuer is invalid")
+	case a.jwt.Audience != "" && !hasAudience(c.Audience, a.jwt.Audience):
+		return nil, errors.Unauthenticated("auth: the token audience is invalid")
+	}
+
+	claim := a.jwt.Claim
+	if claim == "" {
+		claim = defaultClaim
+	}
+
+	var tables []string
+	if raw, ok := all[claim]; ok {
+		if err := json.Unmarshal(raw, &tables); err != nil {
+			return nil, errors.Unauthenticated("auth: the " + claim + " claim must be a list of tables")
+		}
+	}
+
+	tenantClaim := a.jwt.Tenant
+	if tenantClaim == "" {
+		tenantClaim = defaultTenantClaim
+	}
+
+	var tenant string
+	if raw, ok := all[tenantClaim]; ok {
+		if err := json.Unmarshal(raw, &tenant); err != nil {
+			return nil, errors.Unauthenticated("auth: the " + tenantClaim + " claim must be the name of a tenant")
+		}
+	}
+
+	return newIdentity(c.Subject, tenant, tables), nil
+}
+
+// hasAudience checks whether the audience claim, either a string or a list of strings, contains the audience
+func hasAudience(raw json.RawMessage, audience string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == audience
+	}
+
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, v := range many {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a segment of a JSON Web Token, base64url encoded without padding
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// newIdentity creates an identity of the tenant allowed to write to the tables
+func newIdentity(name, tenant string, tables []string) *Identity {
+	allowed := make(map[string]struct{}, len(tables))
+	for _, t := range tables {
+		allowed[t] = struct{}{}
+	}
+	return &Identity{Name: name, Tenant: tenant, tables: allowed}
+}
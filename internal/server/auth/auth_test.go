@@ -0,0 +1,117 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// sign creates a JSON Web Token signed with HMAC-SHA256
+func sign(secret string, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticate_Keys(t *testing.T) {
+	a := New(&config.Auth{
+		Token: "cluster-token",
+		Keys: []config.APIKey{
+			{Name: "orders", Key: "key-1", Tables: []string{"orders", "team.payments"}},
+			{Name: "admin", Key: "key-2", Tables: []string{"*"}},
+			{Name: "ledger", Key: "key-4", Tables: []string{"*"}, Tenant: "payments"},
+		},
+	})
+
+	identity, err := a.Authenticate("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", identity.Name)
+	assert.True(t, identity.Allows("orders"))
+	assert.True(t, identity.Allows("team.payments"))
+	assert.False(t, identity.Allows("eventlog"))
+
+	identity, err = a.Authenticate("key-2")
+	assert.NoError(t, err)
+	assert.True(t, identity.Allows("eventlog"))
+
+	identity, err = a.Authenticate("key-4")
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", identity.Tenant)
+
+	identity, err = a.Authenticate("cluster-token")
+	assert.NoError(t, err)
+	assert.True(t, identity.Internal)
+	assert.True(t, identity.Allows("eventlog"))
+
+	_, err = a.Authenticate("key-3")
+	assert.Error(t, err)
+	_, err = a.Authenticate("")
+	assert.Error(t, err)
+
+	assert.Nil(t, New(nil))
+}
+
+func TestAuthenticate_JWT(t *testing.T) {
+	a := New(&config.Auth{
+		JWT: &config.JWT{Secret: "secret", Issuer: "issuer", Audience: "talaria"},
+	})
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{
+		"sub":    "orders",
+		"iss":    "issuer",
+		"aud":    []string{"talaria", "other"},
+		"exp":    now + 60,
+		"tables": []string{"orders"},
+		"tenant": "payments",
+	}
+
+	identity, err := a.Authenticate(sign("secret", valid))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", identity.Name)
+	assert.Equal(t, "payments", identity.Tenant)
+	assert.True(t, identity.Allows("orders"))
+	assert.False(t, identity.Allows("eventlog"))
+
+	// Signed with another secret
+	_, err = a.Authenticate(sign("other", valid))
+	assert.Error(t, err)
+
+	// Expired, issued by another issuer, for another audience, or with an invalid claim
+	for key, value := range map[string]interface{}{
+		"exp":    now - 1,
+		"nbf":    now + 60,
+		"iss":    "other",
+		"aud":    "other",
+		"tables": "orders",
+		"tenant": []string{"payments"},
+	} {
+		claims := make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			claims[k] = v
+		}
+
+		claims[key] = value
+		_, err = a.Authenticate(sign("secret", claims))
+		assert.Error(t, err, key)
+	}
+
+	// A token without the tables claim can not write to any table
+	delete(valid, "tables")
+	identity, err = a.Authenticate(sign("secret", valid))
+	assert.NoError(t, err)
+	assert.False(t, identity.Allows("orders"))
+}
@@ -0,0 +1,122 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/table"
+)
+
+// Cache represents a size-bounded LRU cache of query results, where every entry expires after a TTL.
+type Cache struct {
+	lock    sync.Mutex               // The lock protecting the cache
+	ttl     time.Duration            // The time-to-live of an entry
+	maxSize int64                    // The maximum size (in bytes) of the cache
+	size    int64                    // The current size (in bytes) of the cache
+	order   *list.List               // The list of entries, most recently used first
+	entries map[string]*list.Element // The entries by key
+}
+
+// entry represents a cached page
+type entry struct {
+	key     string            // The key of the entry
+	page    *table.PageResult // The cached page
+	size    int64             // The size of the page
+	expires time.Time         // The expiration time of the entry
+}
+
+// New creates a new cache.
+func New(maxSize int64, ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get retrieves a page from the cache, if it is present and has not expired.
+func (c *Cache) Get(key string) (*table.PageResult, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return e.page, true
+}
+
+// Put adds a page to the cache, evicting the least recently used entries if the cache is full.
+func (c *Cache) Put(key string, page *table.PageResult) {
+	size := sizeOf(page)
+	if size > c.maxSize {
+		return // Too large to be cached
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.size+size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+
+	c.size += size
+	c.entries[key] = c.order.PushFront(&entry{
+		key:     key,
+		page:    page,
+		size:    size,
+		expires: time.Now().Add(c.ttl),
+	})
+}
+
+// Size returns the current size (in bytes) of the cache.
+func (c *Cache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+// Shrink evicts the least recently used entries until the cache is at most half of its current size, so
+// that memory is released when the node is running low on it.
+func (c *Cache) Shrink() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	target := c.size / 2
+	for c.size > target && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove removes an element from the cache
+func (c *Cache) remove(elem *list.Element) {
+	e := c.order.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// sizeOf estimates the size of a page
+func sizeOf(page *table.PageResult) int64 {
+	size := int64(len(page.NextToken))
+	for _, c := range page.Columns {
+		size += int64(c.Size())
+	}
+	return size
+}
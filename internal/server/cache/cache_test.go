@@ -0,0 +1,81 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPage(value string) *table.PageResult {
+	c := column.NewColumn(typeof.String)
+	c.Append(value)
+	return &table.PageResult{Columns: []presto.Column{c}}
+}
+
+func TestCache(t *testing.T) {
+	page := newPage("hello")
+	size := sizeOf(page)
+	c := New(2*size, time.Minute)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	c.Put("a", page)
+	c.Put("b", newPage("world"))
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, page, v)
+	assert.Equal(t, 2*size, c.Size())
+
+	// "b" is the least recently used and gets evicted
+	c.Put("c", newPage("again"))
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2*size, c.Size())
+}
+
+func TestCache_Shrink(t *testing.T) {
+	page := newPage("hello")
+	size := sizeOf(page)
+	c := New(4*size, time.Minute)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		c.Put(k, newPage("hello"))
+	}
+
+	// The least recently used half is evicted
+	_, _ = c.Get("a")
+	c.Shrink()
+	assert.Equal(t, 2*size, c.Size())
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+}
+
+func TestCache_Expire(t *testing.T) {
+	c := New(1024, time.Millisecond)
+	c.Put("a", newPage("hello"))
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), c.Size())
+}
+
+func TestCache_TooLarge(t *testing.T) {
+	c := New(1, time.Minute)
+	c.Put("a", newPage("hello"))
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,286 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package catalog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const fileName = "catalog.json"
+
+// Definition represents a table created at runtime, as replicated to every node of the cluster.
+type Definition struct {
+	Name    string       `json:"name"`
+	Table   config.Table `json:"table"`
+	Updated int64        `json:"updated"`           // The time of the last change, in unix nanoseconds, the latest change wins
+	Dropped bool         `json:"dropped,omitempty"` // Whether the table was dropped, kept so the drop is replicated
+}
+
+// Schema returns the static schema of the table, if the schema is defined inline.
+func (d *Definition) Schema() (typeof.Schema, bool) {
+	if d.Table.Schema == "" {
+		return nil, false
+	}
+
+	var schema typeof.Schema
+	if err := yaml.Unmarshal([]byte(d.Table.Schema), &schema); err != nil || len(schema) == 0 {
+		return nil, false
+	}
+	return schema, true
+}
+
+// Catalog represents the tables created, altered and dropped at runtime. The catalog is persisted in the
+// storage directory of the node and replicated to the other nodes, each change being applied on every node.
+type Catalog struct {
+	lock      sync.Mutex
+	path      string                // The file in which the catalog is persisted
+	tables    map[string]Definition // The definitions, including the dropped tables
+	apply     func(Definition)      // The function applying a change on the node (optional)
+	broadcast func([]byte)          // The function broadcasting a change to the other nodes (optional)
+}
+
+// New loads the catalog persisted in the directory, or creates an empty one.
+func New(dir string) (*Catalog, error) {
+	if dir == "" {
+		dir = "/data" // Same default as the storage
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{
+		path:   filepath.Join(dir, fileName),
+		tables: make(map[string]Definition),
+	}
+
+	b, err := ioutil.ReadFile(c.path)
+	switch {
+	case os.IsNotExist(err):
+		return c, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &c.tables); err != nil {
+		return nil, errors.Internal("catalog: unable to read "+c.path, err)
+	}
+	return c, nil
+}
+
+// OnChange sets the function applying the changes made on this node or replicated from the other nodes.
+func (c *Catalog) OnChange(apply func(Definition)) {
+	c.apply = apply
+}
+
+// SetBroadcast sets the function broadcasting the changes made on this node to the other nodes.
+func (c *Catalog) SetBroadcast(broadcast func([]byte)) {
+	c.broadcast = broadcast
+}
+
+// Tables returns the tables of the catalog which were not dropped, sorted by name.
+func (c *Catalog) Tables() []Definition {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	out := make([]Definition, 0, len(c.tables))
+	for _, def := range c.tables {
+		if !def.Dropped {
+			out = append(out, def)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Get returns the definition of a table, unless it was dropped.
+func (c *Catalog) Get(name string) (Definition, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	def, ok := c.tables[name]
+	return def, ok && !def.Dropped
+}
+
+// Create adds a new table to the catalog. If columns are specified, they become the static schema of the table.
+func (c *Catalog) Create(name string, table config.Table, columns typeof.Schema) (Definition, error) {
+	if name == "" {
+		return Definition{}, errors.InvalidArgument("catalog: a table requires a name")
+	}
+
+	if _, exists := c.Get(name); exists {
+		return Definition{}, errors.AlreadyExists("catalog: table " + name + " already exists")
+	}
+
+	if len(columns) > 0 {
+		encoded, err := encodeSchema(columns)
+		if err != nil {
+			return Definition{}, err
+		}
+		table.Schema = encoded
+	}
+
+	if err := config.ValidateTable(name, table); err != nil {
+		return Definition{}, errors.InvalidArgument(err.Error())
+	}
+
+	return c.update(Definition{Name: name, Table: table})
+}
+
+// Alter adds the columns to the static schema of a table. The columns of a table with a dynamic schema are
+// added as they are ingested, so they can not be altered.
+func (c *Catalog) Alter(name string, columns typeof.Schema) (Definition, error) {
+	def, ok := c.Get(name)
+	if !ok {
+		return Definition{}, errors.NotFound("catalog: table " + name + " does not exist")
+	}
+
+	schema, static := def.Schema()
+	if !static {
+		return Definition{}, errors.InvalidArgument("catalog: table " + name + " does not have an inline static schema")
+	}
+
+	for column, typ := range columns {
+		if existing, ok := schema[column]; ok && existing != typ {
+			return Definition{}, errors.InvalidArgument("catalog: column " + column + " already exists with type " + existing.String())
+		}
+		schema[column] = typ
+	}
+
+	encoded, err := encodeSchema(schema)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	def.Table.Schema = encoded
+	return c.update(def)
+}
+
+// Drop removes a table from the catalog.
+func (c *Catalog) Drop(name string) (Definition, error) {
+	def, ok := c.Get(name)
+	if !ok {
+		return Definition{}, errors.NotFound("catalog: table " + name + " does not exist")
+	}
+
+	def.Dropped = true
+	return c.update(def)
+}
+
+// Encode encodes the full catalog, including the dropped tables.
+func (c *Catalog) Encode() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	b, _ := json.Marshal(c.tables)
+	return b
+}
+
+// Merge merges the definitions received from another node, keeping the latest change of each table.
+func (c *Catalog) Merge(update []byte) {
+	var remote map[string]Definition
+	if err := json.Unmarshal(update, &remote); err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	changed := make([]Definition, 0, len(remote))
+	for name, def := range remote {
+		if local, ok := c.tables[name]; !ok || def.Updated > local.Updated {
+			c.tables[name] = def
+			changed = append(changed, def)
+		}
+	}
+
+	var err error
+	if len(changed) > 0 {
+		err = c.persist()
+	}
+	c.lock.Unlock()
+
+	if err == nil {
+		c.notify(changed...)
+	}
+}
+
+// update records the change of a table, persists it, applies it and broadcasts it
+func (c *Catalog) update(def Definition) (Definition, error) {
+	c.lock.Lock()
+	def.Updated = time.Now().UnixNano()
+	if previous, ok := c.tables[def.Name]; ok && def.Updated <= previous.Updated {
+		def.Updated = previous.Updated + 1 // The change must win over the previous one
+	}
+
+	c.tables[def.Name] = def
+	err := c.persist()
+	c.lock.Unlock()
+	if err != nil {
+		return Definition{}, err
+	}
+
+	c.notify(def)
+	if c.broadcast != nil {
+		b, _ := json.Marshal(map[string]Definition{def.Name: def})
+		c.broadcast(b)
+	}
+	return def, nil
+}
+
+// notify applies the changes on this node
+func (c *Catalog) notify(defs ...Definition) {
+	if c.apply == nil {
+		return
+	}
+
+	for _, def := range defs {
+		c.apply(def)
+	}
+}
+
+// persist writes the catalog to its file, replacing the previous one at once
+func (c *Catalog) persist() error {
+	b, err := json.MarshalIndent(c.tables, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Internal("catalog: unable to write "+tmp, err)
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return errors.Internal("catalog: unable to write "+c.path, err)
+	}
+	return nil
+}
+
+// encodeSchema encodes the schema as inline YAML, as expected by the table config
+func encodeSchema(schema typeof.Schema) (string, error) {
+	columns := make(map[string]string, len(schema))
+	for column, typ := range schema {
+		if typ == typeof.Unsupported {
+			return "", errors.InvalidArgument("catalog: column " + column + " has an unsupported type")
+		}
+		columns[column] = typ.String()
+	}
+
+	b, err := yaml.Marshal(columns)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
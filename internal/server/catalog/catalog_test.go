@@ -0,0 +1,99 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := New(dir)
+	assert.NoError(t, err)
+
+	var applied []Definition
+	var broadcast [][]byte
+	c.OnChange(func(def Definition) { applied = append(applied, def) })
+	c.SetBroadcast(func(b []byte) { broadcast = append(broadcast, b) })
+
+	// Create a table with a static schema
+	def, err := c.Create("orders", config.Table{TTL: 3600, HashBy: "id"}, typeof.Schema{"id": typeof.String})
+	assert.NoError(t, err)
+	schema, static := def.Schema()
+	assert.True(t, static)
+	assert.Equal(t, typeof.Schema{"id": typeof.String}, schema)
+
+	_, err = c.Create("orders", config.Table{}, nil)
+	assert.Error(t, err)
+
+	// Add columns to it, the types of the existing columns can not change
+	def, err = c.Alter("orders", typeof.Schema{"amount": typeof.Float64})
+	assert.NoError(t, err)
+	schema, _ = def.Schema()
+	assert.Equal(t, typeof.Schema{"id": typeof.String, "amount": typeof.Float64}, schema)
+
+	_, err = c.Alter("orders", typeof.Schema{"id": typeof.Int64})
+	assert.Error(t, err)
+
+	// The columns of a dynamic schema are not altered
+	_, err = c.Create("clicks", config.Table{}, nil)
+	assert.NoError(t, err)
+	_, err = c.Alter("clicks", typeof.Schema{"x": typeof.Int64})
+	assert.Error(t, err)
+
+	// Drop a table
+	_, err = c.Drop("clicks")
+	assert.NoError(t, err)
+	_, ok := c.Get("clicks")
+	assert.False(t, ok)
+	assert.Len(t, c.Tables(), 1)
+	assert.Len(t, applied, 4)
+	assert.Len(t, broadcast, 4)
+
+	// The catalog is persisted
+	reloaded, err := New(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, c.Tables(), reloaded.Tables())
+	assert.Equal(t, c.Encode(), reloaded.Encode())
+}
+
+func TestMerge(t *testing.T) {
+	dir1, _ := ioutil.TempDir("", "catalog-")
+	dir2, _ := ioutil.TempDir("", "catalog-")
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	c1, err := New(dir1)
+	assert.NoError(t, err)
+	c2, err := New(dir2)
+	assert.NoError(t, err)
+
+	var applied []string
+	c2.OnChange(func(def Definition) { applied = append(applied, def.Name) })
+	c1.SetBroadcast(c2.Merge)
+
+	// The changes are replicated
+	_, err = c1.Create("orders", config.Table{}, typeof.Schema{"id": typeof.String})
+	assert.NoError(t, err)
+	_, ok := c2.Get("orders")
+	assert.True(t, ok)
+
+	// The latest change wins, and replaying an older one has no effect
+	older := c1.Encode()
+	_, err = c1.Drop("orders")
+	assert.NoError(t, err)
+	c2.Merge(older)
+	_, ok = c2.Get("orders")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"orders", "orders"}, applied)
+}
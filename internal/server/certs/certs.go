@@ -0,0 +1,274 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+)
+
+const checkInterval = 10 * time.Second // The interval at which the files are checked for changes
+
+// Reloader loads a certificate, its key and optionally a CA bundle from PEM files, and reloads them once
+// they are modified, so that a certificate can be rotated without restarting the node.
+type Reloader struct {
+	lock     sync.Mutex
+	conf     config.TLS
+	cert     *tls.Certificate    // The certificate currently in use
+	pool     *x509.CertPool      // The CA bundle currently in use (optional)
+	cas      []*x509.Certificate // The certificates of the CA bundle currently in use
+	modified time.Time           // The latest modification time of the files loaded
+	checked  time.Time           // The time of the last check for changes
+}
+
+// New creates a new reloader and loads the files a first time.
+func New(conf *config.TLS) (*Reloader, error) {
+	if conf == nil || conf.Cert == "" || conf.Key == "" {
+		return nil, errors.New("tls: a certificate and a key are required")
+	}
+
+	r := &Reloader{conf: *conf}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig returns the TLS config of a listener. When a CA bundle is configured, the clients must present
+// a certificate issued by it.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+
+			if pool != nil {
+				conf.ClientCAs = pool
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return conf, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS config used to connect to the other nodes of the cluster, presenting the same
+// certificate. When a CA bundle is configured, the certificate of the node connected to must be issued by it,
+// without checking its host name as the nodes are addressed by their IP address.
+func (r *Reloader) ClientConfig() *tls.Config {
+	_, pool := r.current()
+	if pool == nil {
+		return &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, _ := r.current()
+				return cert, nil
+			},
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // The chain is verified below, without the host name
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			_, pool := r.current()
+			return verify(raw, pool)
+		},
+	}
+}
+
+// ListenerConfig returns the TLS config of a listener which both the clients and the other nodes of the cluster
+// connect to. The listener presents its own certificate, or the one of the node if it has none, and accepts the
+// clients presenting a certificate issued by the CA of the cluster on top of the ones issued by its own CA. The
+// clients without a certificate are only rejected if the listener has its own CA.
+func ListenerConfig(own, node *Reloader) *tls.Config {
+	switch {
+	case node == nil && own == nil:
+		return nil
+	case node == nil:
+		return own.ServerConfig()
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, _ := node.current()
+			pool := x509.NewCertPool()
+			for _, ca := range node.trusted() {
+				pool.AddCert(ca)
+			}
+
+			auth := tls.VerifyClientCertIfGiven
+			if own != nil {
+				cert, _ = own.current()
+				for _, ca := range own.trusted() {
+					pool.AddCert(ca)
+				}
+
+				if own.conf.ClientCA != "" {
+					auth = tls.RequireAndVerifyClientCert
+				}
+			}
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   auth,
+			}, nil
+		},
+	}
+}
+
+// trusted returns the certificates of the CA bundle in use
+func (r *Reloader) trusted() []*x509.Certificate {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.cas
+}
+
+// current returns the certificate and the CA bundle in use, reloading them if the files were modified
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if time.Since(r.checked) >= checkInterval {
+		r.checked = time.Now()
+		if r.latest().After(r.modified) {
+			_ = r.loadLocked() // Keep the previous files if the new ones are invalid
+		}
+	}
+
+	return r.cert, r.pool
+}
+
+// load loads the files
+func (r *Reloader) load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checked = time.Now()
+	return r.loadLocked()
+}
+
+// loadLocked loads the files, the lock being held
+func (r *Reloader) loadLocked() error {
+	modified := r.latest()
+	cert, err := tls.LoadX509KeyPair(r.conf.Cert, r.conf.Key)
+	if err != nil {
+		return fmt.Errorf("tls: unable to load the certificate, %s", err)
+	}
+
+	var pool *x509.CertPool
+	var cas []*x509.Certificate
+	if r.conf.ClientCA != "" {
+		bundle, err := ioutil.ReadFile(r.conf.ClientCA)
+		if err != nil {
+			return fmt.Errorf("tls: unable to load the CA bundle, %s", err)
+		}
+
+		if cas, err = parseAll(bundle); err != nil || len(cas) == 0 {
+			return fmt.Errorf("tls: no certificate found in %s", r.conf.ClientCA)
+		}
+
+		pool = x509.NewCertPool()
+		for _, ca := range cas {
+			pool.AddCert(ca)
+		}
+	}
+
+	r.cert, r.pool, r.cas, r.modified = &cert, pool, cas, modified
+	return nil
+}
+
+// latest returns the latest modification time of the files
+func (r *Reloader) latest() (latest time.Time) {
+	for _, path := range []string{r.conf.Cert, r.conf.Key, r.conf.ClientCA} {
+		if path == "" {
+			continue
+		}
+
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return
+}
+
+// parseAll parses the certificates of a PEM bundle
+func parseAll(data []byte) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for len(data) > 0 {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, cert)
+	}
+	return out, nil
+}
+
+// Issued checks whether the certificate chain presented by a peer is issued by the CA bundle, such as to tell
+// whether a client is another node of the cluster.
+func (r *Reloader) Issued(chain []*x509.Certificate) bool {
+	_, pool := r.current()
+	return pool != nil && verifyChain(chain, pool) == nil
+}
+
+// verify verifies the certificate chain presented by a peer against the CA bundle
+func verify(raw [][]byte, pool *x509.CertPool) error {
+	certs := make([]*x509.Certificate, 0, len(raw))
+	for _, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	return verifyChain(certs, pool)
+}
+
+// verifyChain verifies a certificate chain against the CA bundle
+func verifyChain(certs []*x509.Certificate, pool *x509.CertPool) error {
+	if len(certs) == 0 {
+		return errors.New("tls: no certificate presented")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
@@ -0,0 +1,242 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// issuer represents a test certificate authority
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newIssuer creates a self-signed certificate authority
+func newIssuer(t *testing.T) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "talaria-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue issues a certificate and writes it with its key to the directory
+func (i *issuer) issue(t *testing.T, dir, name string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.cert, &key.PublicKey, i.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	ca := newIssuer(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+	certFile, keyFile := ca.issue(t, dir, "node", 2)
+
+	r, err := New(&config.TLS{Cert: certFile, Key: keyFile, ClientCA: caFile})
+	assert.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.ServerConfig())
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("ok"))
+			_ = conn.Close()
+		}
+	}()
+
+	// A node presenting a certificate issued by the CA is accepted
+	conn, err := tls.Dial("tcp", ln.Addr().String(), r.ClientConfig())
+	assert.NoError(t, err)
+	out, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(out))
+	_ = conn.Close()
+
+	// A client without a certificate is rejected
+	conn, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		_, err = ioutil.ReadAll(conn)
+		_ = conn.Close()
+	}
+	assert.Error(t, err)
+
+	// A server with a certificate from another CA is rejected
+	other := newIssuer(t)
+	otherCert, otherKey := other.issue(t, dir, "other", 3)
+	cert, err := tls.LoadX509KeyPair(otherCert, otherKey)
+	assert.NoError(t, err)
+
+	otherLn, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NoError(t, err)
+	defer otherLn.Close()
+	go func() {
+		if conn, err := otherLn.Accept(); err == nil {
+			_, _ = conn.Write([]byte("ok"))
+			_ = conn.Close()
+		}
+	}()
+
+	_, err = tls.Dial("tcp", otherLn.Addr().String(), r.ClientConfig())
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	ca := newIssuer(t)
+	certFile, keyFile := ca.issue(t, dir, "node", 2)
+	r, err := New(&config.TLS{Cert: certFile, Key: keyFile})
+	assert.NoError(t, err)
+
+	cert, _ := r.current()
+	first, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(2), first.SerialNumber.Int64())
+
+	// Rotate the certificate, with a later modification time
+	ca.issue(t, dir, "node", 5)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	r.checked = time.Time{}
+
+	cert, _ = r.current()
+	rotated, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(5), rotated.SerialNumber.Int64())
+
+	// An invalid certificate keeps the previous one
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("invalid"), 0600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	r.checked = time.Time{}
+
+	cert, _ = r.current()
+	kept, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, int64(5), kept.SerialNumber.Int64())
+
+	_, err = New(&config.TLS{Cert: filepath.Join(dir, "missing.crt"), Key: keyFile})
+	assert.Error(t, err)
+	_, err = New(&config.TLS{})
+	assert.Error(t, err)
+}
+
+func TestListenerConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	// The listener has its own certificate authority, distinct from the one of the cluster
+	clusterCA, listenerCA := newIssuer(t), newIssuer(t)
+	clusterFile, listenerFile := filepath.Join(dir, "cluster.crt"), filepath.Join(dir, "listener.crt")
+	assert.NoError(t, ioutil.WriteFile(clusterFile, clusterCA.pem, 0600))
+	assert.NoError(t, ioutil.WriteFile(listenerFile, listenerCA.pem, 0600))
+
+	nodeCert, nodeKey := clusterCA.issue(t, dir, "node", 2)
+	node, err := New(&config.TLS{Cert: nodeCert, Key: nodeKey, ClientCA: clusterFile})
+	assert.NoError(t, err)
+
+	producerCert, producerKey := listenerCA.issue(t, dir, "producer", 3)
+	producer, err := tls.LoadX509KeyPair(producerCert, producerKey)
+	assert.NoError(t, err)
+
+	assert.Nil(t, ListenerConfig(nil, nil))
+	for _, tc := range []struct {
+		own       *config.TLS
+		anonymous bool
+	}{
+		{own: nil, anonymous: true},
+		{own: &config.TLS{Cert: nodeCert, Key: nodeKey, ClientCA: listenerFile}, anonymous: false},
+	} {
+		var own *Reloader
+		if tc.own != nil {
+			own, err = New(tc.own)
+			assert.NoError(t, err)
+		}
+
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", ListenerConfig(own, node))
+		assert.NoError(t, err)
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte("ok"))
+				_ = conn.Close()
+			}
+		}()
+
+		dial := func(conf *tls.Config) error {
+			conn, err := tls.Dial("tcp", ln.Addr().String(), conf)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = ioutil.ReadAll(conn)
+			return err
+		}
+
+		// The nodes are always accepted, the producers with a certificate of the listener as well
+		assert.NoError(t, dial(node.ClientConfig()))
+		assert.Equal(t, tc.own != nil, dial(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{producer}}) == nil)
+		assert.Equal(t, tc.anonymous, dial(&tls.Config{InsecureSkipVerify: true}) == nil)
+		_ = ln.Close()
+	}
+}
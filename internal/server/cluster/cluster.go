@@ -5,16 +5,20 @@ package cluster
 
 import (
 	"io/ioutil"
+	"log"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/emitter-io/address"
 	"github.com/hashicorp/memberlist"
+	"github.com/twmb/murmur3"
 )
 
 const (
-	ctxTag = "cluster"
+	ctxTag       = "cluster"
+	leaveTimeout = 5 * time.Second
 )
 
 // Membership represents a contract which returns a list of IP addresses.
@@ -25,26 +29,69 @@ type Membership interface {
 
 // Cluster represents a cluster management/discovery mechanism using gossip.
 type Cluster struct {
-	list *memberlist.Memberlist
-	addr string
+	list    *memberlist.Memberlist
+	addr    string
+	meta    *delegate
+	keyring *memberlist.Keyring // The keys encrypting the gossip (optional)
 }
 
 // New creates a new gossip cluster.
 func New(port int) *Cluster {
+	c, err := NewSecure(port, Security{})
+	if err != nil {
+		panic("failed to create gossip memberlist: " + err.Error())
+	}
+	return c
+}
+
+// NewSecure creates a new gossip cluster, encrypting the gossip with the keys and securing the streams between
+// the nodes with mutual TLS, if configured.
+func NewSecure(port int, security Security) (*Cluster, error) {
 	cfg := memberlist.DefaultWANConfig()
 	cfg.BindPort = port
 	cfg.AdvertisePort = port
 	cfg.AdvertiseAddr = getAddress()
 	cfg.LogOutput = ioutil.Discard // Ignore memberlist logs
+	var list *memberlist.Memberlist
+	meta := &delegate{queue: &memberlist.TransmitLimitedQueue{
+		NumNodes:       func() int { return list.NumMembers() },
+		RetransmitMult: cfg.RetransmitMult,
+	}}
+
+	// Encrypt the gossip, the peers without the keys being ignored
+	if len(security.Keys) > 0 {
+		keyring, err := memberlist.NewKeyring(security.Keys, security.Keys[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg.Keyring = keyring
+	}
+
+	// Secure the streams with TLS
+	if security.Server != nil && security.Client != nil {
+		inner, err := memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+			BindAddrs: []string{cfg.BindAddr},
+			BindPort:  cfg.BindPort,
+			Logger:    log.New(ioutil.Discard, "", 0),
+		})
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport = newTLSTransport(inner, security.Server, security.Client)
+	}
+
+	cfg.Delegate = meta
 	list, err := memberlist.Create(cfg)
 	if err != nil {
-		panic("failed to create gossip memberlist: " + err.Error())
+		return nil, err
 	}
 
 	return &Cluster{
-		list: list,
-		addr: net.JoinHostPort(cfg.AdvertiseAddr, strconv.FormatInt(int64(cfg.AdvertisePort), 10)),
-	}
+		list:    list,
+		addr:    net.JoinHostPort(cfg.AdvertiseAddr, strconv.FormatInt(int64(cfg.AdvertisePort), 10)),
+		meta:    meta,
+		keyring: cfg.Keyring,
+	}, nil
 }
 
 // Members returns the current set of nodes available.
@@ -78,8 +125,13 @@ func (c *Cluster) JoinHostname(name string) error {
 	return c.Join(addr...)
 }
 
-// Close closes the gossip
+// Close leaves the cluster, so that the peers stop using this node right away, and closes the gossip
 func (c *Cluster) Close() error {
+	if err := c.list.Leave(leaveTimeout); err != nil {
+		_ = c.list.Shutdown()
+		return err
+	}
+
 	return c.list.Shutdown()
 }
 
@@ -99,3 +151,27 @@ func getAddress() string {
 	}
 	return private
 }
+
+// Leader elects the member coordinating a named resource, such as the compaction of a table, and returns
+// whether this is the local node. Every node computes the same leader from the membership, by picking
+// the member with the highest hash for the name, so no coordination is required and the leadership only
+// moves when that member leaves the cluster.
+func (c *Cluster) Leader(name string) (string, bool) {
+	return leaderOf(c.Members(), name, c.addr)
+}
+
+// leaderOf elects the leader among the members using rendezvous hashing
+func leaderOf(members []string, name, self string) (leader string, local bool) {
+	var max uint32
+	for _, m := range members {
+		if h := murmur3.StringSum32(m + "/" + name); leader == "" || h > max || (h == max && m < leader) {
+			leader, max = m, h
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(self); err == nil {
+		self = host
+	}
+
+	return leader, leader == "" || leader == self
+}
@@ -5,7 +5,9 @@ package cluster
 
 import (
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,3 +25,122 @@ func TestClusterNew(t *testing.T) {
 
 	})
 }
+
+func TestClusterZone(t *testing.T) {
+	cluster := New(rand.Intn(30000) + 2000)
+	defer cluster.Close()
+	assert.Empty(t, cluster.ZoneOf(cluster.Addr()))
+
+	// The zone is part of the metadata of the node
+	assert.NoError(t, cluster.SetZone("ap-southeast-1a"))
+	assert.Equal(t, "ap-southeast-1a", cluster.ZoneOf(cluster.Addr()))
+	assert.Empty(t, cluster.ZoneOf("10.255.255.1"))
+}
+
+func TestLeaderOf(t *testing.T) {
+	members := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	// Every node elects the same leader, regardless of the order of the members
+	leader, _ := leaderOf(members, "eventlog", "10.0.0.1:7946")
+	for _, self := range members {
+		other, local := leaderOf([]string{members[2], members[0], members[1]}, "eventlog", self+":7946")
+		assert.Equal(t, leader, other)
+		assert.Equal(t, self == leader, local)
+	}
+
+	// The leadership moves only if the leader leaves
+	var remaining []string
+	for _, m := range members {
+		if m != leader {
+			remaining = append(remaining, m)
+		}
+	}
+	next, _ := leaderOf(remaining, "eventlog", "")
+	assert.NotEqual(t, leader, next)
+
+	// Without any member, the local node leads
+	_, local := leaderOf(nil, "eventlog", "10.0.0.1:7946")
+	assert.True(t, local)
+}
+
+// replicatedState is a state which records the updates merged
+type replicatedState struct {
+	sync.Mutex
+	local   []byte
+	updates []string
+}
+
+func (s *replicatedState) Encode() []byte { return s.local }
+func (s *replicatedState) Merge(update []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.updates = append(s.updates, string(update))
+}
+
+func (s *replicatedState) received(update string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, u := range s.updates {
+		if u == update {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClusterReplicate(t *testing.T) {
+	port := rand.Intn(30000) + 2000
+	c1, c2 := New(port), New(port+1)
+	defer c1.Close()
+	defer c2.Close()
+
+	s1 := &replicatedState{local: []byte("state1")}
+	s2 := &replicatedState{local: []byte("state2")}
+	c1.Replicate(s1)
+	c2.Replicate(s2)
+
+	// The full state is exchanged when joining
+	assert.NoError(t, c2.Join(c1.Addr()))
+	assert.Eventually(t, func() bool {
+		return s1.received("state2") && s2.received("state1")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The updates are queued for the gossip, and merged once received
+	c1.Broadcast([]byte("update"))
+	assert.Equal(t, [][]byte{[]byte("update")}, c1.meta.GetBroadcasts(0, 1024))
+	c2.meta.NotifyMsg([]byte("update"))
+	assert.True(t, s2.received("update"))
+}
+
+func TestClusterReplicateAs(t *testing.T) {
+	port := rand.Intn(30000) + 2000
+	c1, c2 := New(port), New(port+1)
+	defer c1.Close()
+	defer c2.Close()
+
+	catalog1, catalog2 := &replicatedState{local: []byte("catalog1")}, &replicatedState{local: []byte("catalog2")}
+	deletes1, deletes2 := &replicatedState{local: []byte("deletes1")}, &replicatedState{local: []byte("deletes2")}
+	c1.Replicate(catalog1)
+	c2.Replicate(catalog2)
+	broadcast := c1.ReplicateAs("deletes", deletes1)
+	c2.ReplicateAs("deletes", deletes2)
+
+	// Each state is exchanged with the state of the same name
+	assert.NoError(t, c2.Join(c1.Addr()))
+	assert.Eventually(t, func() bool {
+		return catalog1.received("catalog2") && catalog2.received("catalog1") &&
+			deletes1.received("deletes2") && deletes2.received("deletes1")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The updates of a named state are framed, and merged into the state of the same name
+	broadcast([]byte("update"))
+	msgs := c1.meta.GetBroadcasts(0, 1024)
+	assert.Len(t, msgs, 1)
+	c2.meta.NotifyMsg(msgs[0])
+	assert.True(t, deletes2.received("update"))
+	assert.False(t, catalog2.received("update"))
+
+	// A corrupted frame is ignored
+	c2.meta.NotifyMsg(msgs[0][:len(msgs[0])-1])
+	c2.meta.NotifyMsg([]byte{frameMarker, 0xff})
+}
@@ -0,0 +1,119 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Discovery represents a mechanism which returns the addresses of the peers to join.
+type Discovery interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// DNS discovers the peers by resolving a hostname, such as a headless service.
+type DNS string
+
+// Peers returns the addresses the hostname resolves to.
+func (d DNS) Peers(ctx context.Context) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, string(d))
+}
+
+// Static discovers the peers from a fixed list of addresses or hostnames, optionally extended with the
+// targets of a DNS SRV record, for deployments without any orchestrator or cloud metadata.
+type Static struct {
+	Addrs []string // The addresses or hostnames of the peers, with an optional port
+	SRV   string   // The name of the SRV record listing the peers (optional)
+}
+
+// Peers returns the addresses of the peers, resolving the hostnames.
+func (s *Static) Peers(ctx context.Context) ([]string, error) {
+	hosts := make([]string, 0, len(s.Addrs))
+	for _, addr := range s.Addrs {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		hosts = append(hosts, addr)
+	}
+
+	// Add the targets of the SRV record
+	if s.SRV != "" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", s.SRV)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			hosts = append(hosts, strings.TrimSuffix(r.Target, "."))
+		}
+	}
+
+	// Resolve the hostnames, as the members of the cluster are listed by address
+	var peers []string
+	for _, host := range hosts {
+		if net.ParseIP(host) != nil {
+			peers = append(peers, host)
+			continue
+		}
+
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, addrs...)
+	}
+	return peers, nil
+}
+
+// Discover joins the peers returned by the discovery mechanism right away, then periodically joins
+// the ones which are not yet members of the cluster.
+func (c *Cluster) Discover(ctx context.Context, discovery Discovery, interval time.Duration, monitor monitor.Monitor) {
+	c.discover(ctx, discovery, monitor)
+	async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, nil
+			case <-ticker.C:
+				c.discover(ctx, discovery, monitor)
+			}
+		}
+	})
+}
+
+// discover joins the peers which are not yet members of the cluster
+func (c *Cluster) discover(ctx context.Context, discovery Discovery, monitor monitor.Monitor) {
+	peers, err := discovery.Peers(ctx)
+	if err != nil {
+		monitor.Count1(ctxTag, "discovery.error")
+		monitor.Warning(errors.Internal("cluster: unable to discover the peers", err))
+		return
+	}
+
+	if missing := newPeers(peers, c.Members()); len(missing) > 0 {
+		monitor.Info("cluster: joining %v...", missing)
+		if err := c.Join(missing...); err != nil {
+			monitor.Warning(errors.Internal("cluster: unable to join the peers", err))
+		}
+	}
+}
+
+// newPeers returns the peers which are not members yet
+func newPeers(peers, members []string) (out []string) {
+	for _, p := range peers {
+		if !contains(members, p) {
+			out = append(out, p)
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNS(t *testing.T) {
+	peers, err := DNS("localhost").Peers(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, peers, "127.0.0.1")
+}
+
+func TestStatic(t *testing.T) {
+	static := &Static{Addrs: []string{"10.0.0.1", "10.0.0.2:7946", "localhost:7946"}}
+	peers, err := static.Peers(context.Background())
+	assert.NoError(t, err)
+	assert.Subset(t, peers, []string{"10.0.0.1", "10.0.0.2", "127.0.0.1"})
+
+	// Unresolvable SRV record
+	static.SRV = "_gossip._tcp.invalid"
+	_, err = static.Peers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewPeers(t *testing.T) {
+	assert.Equal(t, []string{"10.0.0.2"}, newPeers(
+		[]string{"10.0.0.1", "10.0.0.2"},
+		[]string{"10.0.0.1", "10.0.0.3"},
+	))
+	assert.Empty(t, newPeers(nil, []string{"10.0.0.1"}))
+}
@@ -0,0 +1,104 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The location of the service account credentials, mounted in every pod
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes discovers the peers from the endpoints of a kubernetes service, using the API server.
+type Kubernetes struct {
+	client   *http.Client // The client for the API server
+	endpoint string       // The URL of the endpoints of the service
+	token    string       // The bearer token of the service account
+}
+
+// NewKubernetes creates a discovery of the pods backing a service, using the service account of the pod. If
+// the namespace is not specified, the namespace of the pod is used.
+func NewKubernetes(namespace, service string) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes: not running in a cluster")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccount + "/token")
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccount + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccount + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &Kubernetes{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		endpoint: fmt.Sprintf("https://%s/api/v1/namespaces/%s/endpoints/%s", net.JoinHostPort(host, port), namespace, service),
+		token:    strings.TrimSpace(string(token)),
+	}, nil
+}
+
+// Peers returns the addresses of the pods which are ready, as listed in the endpoints of the service.
+func (k *Kubernetes) Peers(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, k.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	resp, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes: unable to get the endpoints, status %d", resp.StatusCode)
+	}
+
+	var endpoints struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP string `json:"ip"`
+			} `json:"addresses"`
+		} `json:"subsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			peers = append(peers, addr.IP)
+		}
+	}
+	return peers, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/default/endpoints/talaria", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"subsets": [
+			{"addresses": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}], "notReadyAddresses": [{"ip": "10.0.0.9"}]},
+			{"addresses": [{"ip": "10.0.0.3"}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	k := &Kubernetes{
+		client:   server.Client(),
+		endpoint: server.URL + "/api/v1/namespaces/default/endpoints/talaria",
+		token:    "token",
+	}
+
+	// Only the ready addresses are returned
+	peers, err := k.Peers(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, peers)
+
+	// Unauthorized
+	k.token = "invalid"
+	_, err = k.Peers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestKubernetes_NotInCluster(t *testing.T) {
+	_, err := NewKubernetes("default", "talaria")
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/twmb/murmur3"
+)
+
+const defaultVNodes = 64
+
+// Ring represents a consistent hash ring over the members of the cluster, which assigns each hash key
+// to the node owning it. The ring is rebuilt whenever the membership changes.
+type Ring struct {
+	sync.RWMutex
+	cluster  Membership // The membership to build the ring from
+	vnodes   int        // The number of virtual nodes per member
+	replicas int        // The number of nodes storing each hash key
+	members  string     // The members the ring was built for
+	points   []point    // The points of the ring, sorted by hash
+}
+
+// Range represents an inclusive range of hash keys.
+type Range struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// point represents a virtual node on the ring
+type point struct {
+	hash uint32 // The position on the ring
+	addr string // The member owning the point
+}
+
+// NewRing creates a new consistent hash ring with a number of virtual nodes for each of the members,
+// and a number of replicas storing each hash key.
+func NewRing(cluster Membership, vnodes, replicas int) *Ring {
+	if vnodes <= 0 {
+		vnodes = defaultVNodes
+	}
+
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	return &Ring{
+		cluster:  cluster,
+		vnodes:   vnodes,
+		replicas: replicas,
+	}
+}
+
+// Members returns the current set of nodes available.
+func (r *Ring) Members() []string {
+	return r.cluster.Members()
+}
+
+// Addr returns the advertised address
+func (r *Ring) Addr() string {
+	return r.cluster.Addr()
+}
+
+// Owner returns the member which owns the hash key and whether this is the local node. If the
+// membership is empty, the local node owns every key.
+func (r *Ring) Owner(hash uint32) (string, bool) {
+	owners := r.Owners(hash, 1)
+	if len(owners) == 0 {
+		return "", true
+	}
+
+	return owners[0], r.IsLocal(owners[0])
+}
+
+// Replicas returns the members storing the hash key, starting with its owner.
+func (r *Ring) Replicas(hash uint32) []string {
+	return r.Owners(hash, r.replicas)
+}
+
+// IsLocal checks whether the member is the local node.
+func (r *Ring) IsLocal(addr string) bool {
+	return addr == r.self()
+}
+
+// ZoneOf returns the availability zone of a member, if the membership gossips it.
+func (r *Ring) ZoneOf(addr string) string {
+	if zones, ok := r.cluster.(Zones); ok {
+		return zones.ZoneOf(addr)
+	}
+	return ""
+}
+
+// Owners returns up to n distinct members for the hash key, starting with its owner and followed
+// by the next members on the ring. If the zones of the members are known, the members in the zone
+// of the owner are preferred, so that the replicas are written without crossing zones.
+func (r *Ring) Owners(hash uint32, n int) []string {
+	r.refresh()
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+
+	// Walk the entire ring if the members need to be ordered by zone
+	zones, zoned := r.cluster.(Zones)
+	limit := n
+	if zoned && n > 1 {
+		limit = len(r.points)
+	}
+
+	out := make([]string, 0, n)
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+
+	for i := 0; i < len(r.points) && len(out) < limit; i++ {
+		addr := r.points[(start+i)%len(r.points)].addr
+		if !contains(out, addr) {
+			out = append(out, addr)
+		}
+	}
+
+	if zoned && len(out) > 1 {
+		preferZone(out[1:], zones.ZoneOf(out[0]), zones)
+	}
+
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Ranges returns the ranges of hash keys owned by a member, sorted and merged when adjacent.
+func (r *Ring) Ranges(addr string) (ranges []Range) {
+	r.refresh()
+	r.RLock()
+	defer r.RUnlock()
+
+	// Each point owns the keys after the previous point, up to its own position
+	for i, p := range r.points {
+		if p.addr != addr {
+			continue
+		}
+
+		var from uint32
+		if i > 0 {
+			from = r.points[i-1].hash + 1
+		}
+		ranges = appendRange(ranges, Range{From: from, To: p.hash})
+	}
+
+	// The keys after the last point wrap around to the first one
+	if n := len(r.points); n > 0 && r.points[0].addr == addr && r.points[n-1].hash < math.MaxUint32 {
+		ranges = appendRange(ranges, Range{From: r.points[n-1].hash + 1, To: math.MaxUint32})
+	}
+	return
+}
+
+// appendRange appends a range, merging it with the last one if they are adjacent
+func appendRange(ranges []Range, next Range) []Range {
+	if n := len(ranges); n > 0 && ranges[n-1].To+1 == next.From {
+		ranges[n-1].To = next.To
+		return ranges
+	}
+	return append(ranges, next)
+}
+
+// self returns the address of the local node, as it appears in the membership
+func (r *Ring) self() string {
+	addr := r.cluster.Addr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// refresh rebuilds the ring if the membership has changed since it was last built
+func (r *Ring) refresh() {
+	members := r.cluster.Members()
+	sort.Strings(members)
+	signature := strings.Join(members, ",")
+
+	r.RLock()
+	unchanged := r.members == signature
+	r.RUnlock()
+	if unchanged {
+		return
+	}
+
+	points := make([]point, 0, len(members)*r.vnodes)
+	for _, m := range members {
+		for i := 0; i < r.vnodes; i++ {
+			points = append(points, point{
+				hash: murmur3.StringSum32(m + "#" + strconv.Itoa(i)),
+				addr: m,
+			})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	r.Lock()
+	r.members = signature
+	r.points = points
+	r.Unlock()
+}
+
+// preferZone orders the members in place so that the ones in the zone come first, while preserving
+// their order otherwise. If the zone is unknown, the members are left untouched.
+func preferZone(addrs []string, zone string, zones Zones) {
+	if zone == "" {
+		return
+	}
+
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return zones.ZoneOf(addrs[i]) == zone && zones.ZoneOf(addrs[j]) != zone
+	})
+}
+
+// contains checks whether the address is in the list
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/murmur3"
+)
+
+type staticMembership []string
+
+func (m *staticMembership) Members() []string {
+	return append([]string(nil), (*m)...)
+}
+
+func (m *staticMembership) Addr() string {
+	return "10.0.0.1:7946"
+}
+
+func TestRing(t *testing.T) {
+	members := &staticMembership{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	ring := NewRing(members, 0, 2)
+
+	// Every node owns a share of the keys
+	owned := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		owner, _ := ring.Owner(uint32(i * 1431655))
+		owned[owner]++
+	}
+	assert.Len(t, owned, 3)
+	for _, count := range owned {
+		assert.InDelta(t, 1000, count, 400)
+	}
+
+	// The ownership is deterministic
+	hash := murmur3.StringSum32("user-123")
+	owner, local := ring.Owner(hash)
+	assert.Equal(t, owner, NewRing(members, 0, 2).Owners(hash, 1)[0])
+	assert.Equal(t, owner == "10.0.0.1", local)
+
+	// Owners are distinct and start with the owner
+	owners := ring.Owners(hash, 5)
+	assert.Len(t, owners, 3)
+	assert.Equal(t, owner, owners[0])
+	assert.ElementsMatch(t, []string(*members), owners)
+	assert.Equal(t, owners[:2], ring.Replicas(hash))
+	assert.True(t, ring.IsLocal("10.0.0.1"))
+	assert.False(t, ring.IsLocal("10.0.0.2"))
+
+	// Only the keys of a removed node are moved
+	before := make(map[uint32]string)
+	for i := uint32(0); i < 1000; i++ {
+		before[i*4294967], _ = ring.Owner(i * 4294967)
+	}
+
+	*members = (*members)[:2]
+	for h, previous := range before {
+		now, _ := ring.Owner(h)
+		if previous != "10.0.0.3" {
+			assert.Equal(t, previous, now)
+		}
+		assert.NotEqual(t, "10.0.0.3", now)
+	}
+}
+
+func TestRing_Empty(t *testing.T) {
+	ring := NewRing(new(staticMembership), 16, 0)
+	assert.Empty(t, ring.Owners(1, 3))
+
+	// Without any member, the local node owns everything
+	owner, local := ring.Owner(1)
+	assert.Empty(t, owner)
+	assert.True(t, local)
+}
+
+type zonedMembership struct {
+	staticMembership
+	zones map[string]string
+}
+
+func (m *zonedMembership) ZoneOf(addr string) string {
+	return m.zones[addr]
+}
+
+func TestRing_Zones(t *testing.T) {
+	members := &zonedMembership{
+		staticMembership: staticMembership{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+		zones:            map[string]string{"10.0.0.1": "a", "10.0.0.2": "a", "10.0.0.3": "b", "10.0.0.4": "b"},
+	}
+
+	ring := NewRing(members, 0, 2)
+	assert.Equal(t, "a", ring.ZoneOf("10.0.0.1"))
+	for i := 0; i < 1000; i++ {
+		hash := uint32(i * 4294967)
+
+		// The replica is in the same zone as the owner, which is unchanged
+		owner, _ := ring.Owner(hash)
+		replicas := ring.Replicas(hash)
+		assert.Len(t, replicas, 2)
+		assert.Equal(t, owner, replicas[0])
+		assert.Equal(t, members.zones[replicas[0]], members.zones[replicas[1]])
+		assert.ElementsMatch(t, []string(members.staticMembership), ring.Owners(hash, 10))
+	}
+}
+
+func TestRing_Ranges(t *testing.T) {
+	members := &staticMembership{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	ring := NewRing(members, 8, 1)
+
+	// The ranges of all of the members cover every key exactly once
+	var total uint64
+	for _, m := range *members {
+		for _, r := range ring.Ranges(m) {
+			assert.True(t, r.From <= r.To)
+			total += uint64(r.To-r.From) + 1
+
+			// Every key of the range is owned by the member
+			for _, h := range []uint32{r.From, r.To, r.From + (r.To-r.From)/2} {
+				owner, _ := ring.Owner(h)
+				assert.Equal(t, m, owner)
+			}
+		}
+	}
+	assert.Equal(t, uint64(1)<<32, total)
+	assert.Empty(t, ring.Ranges("10.0.0.4"))
+}
@@ -0,0 +1,157 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// Security represents the protection of the gossip between the nodes
+type Security struct {
+	Keys   [][]byte    // The keys encrypting the gossip, the first one encrypting and every one decrypting (optional)
+	Server *tls.Config // The TLS config of the gossip streams accepted, which must verify the peers (optional)
+	Client *tls.Config // The TLS config of the gossip streams opened to the peers (optional)
+}
+
+// DecodeKeys decodes the base64 keys encrypting the gossip
+func DecodeKeys(keys []string) ([][]byte, error) {
+	out := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// SetKeys replaces the keys encrypting the gossip, so they can be rotated without a restart. To rotate the keys
+// without a partition, a new key is first added to every node as a secondary key, then made the primary one and
+// the previous key is finally removed.
+func (c *Cluster) SetKeys(keys [][]byte) error {
+	if c.keyring == nil {
+		return errors.New("cluster: the gossip is not encrypted")
+	}
+
+	if len(keys) == 0 {
+		return errors.New("cluster: the gossip can not be decrypted once encrypted")
+	}
+
+	for _, k := range keys {
+		if err := c.keyring.AddKey(k); err != nil {
+			return err
+		}
+	}
+
+	if err := c.keyring.UseKey(keys[0]); err != nil {
+		return err
+	}
+
+	// Remove the keys which are no longer configured
+	for _, installed := range c.keyring.GetKeys() {
+		if !hasKey(keys, installed) {
+			if err := c.keyring.RemoveKey(installed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasKey checks whether the key is one of the keys
+func hasKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// tlsTransport is a memberlist transport which secures the streams, such as the exchange of the full state
+// between two nodes, with TLS. The packets are sent over UDP as before and are encrypted with the keys.
+type tlsTransport struct {
+	*memberlist.NetTransport
+	server  *tls.Config   // The TLS config of the streams accepted
+	client  *tls.Config   // The TLS config of the streams opened
+	streams chan net.Conn // The streams accepted, once their handshake is complete
+	done    chan struct{} // Closed once the transport is shut down
+}
+
+// newTLSTransport creates a new transport securing the streams of the underlying transport with TLS
+func newTLSTransport(inner *memberlist.NetTransport, server, client *tls.Config) *tlsTransport {
+	t := &tlsTransport{
+		NetTransport: inner,
+		server:       server,
+		client:       client,
+		streams:      make(chan net.Conn),
+		done:         make(chan struct{}),
+	}
+
+	go t.accept()
+	return t
+}
+
+// accept performs the handshake of the streams accepted by the underlying transport
+func (t *tlsTransport) accept() {
+	for {
+		select {
+		case <-t.done:
+			return
+		case conn := <-t.NetTransport.StreamCh():
+			go t.handshake(conn)
+		}
+	}
+}
+
+// handshake performs the handshake of a stream accepted, closing it if the peer can not be verified
+func (t *tlsTransport) handshake(conn net.Conn) {
+	secure := tls.Server(conn, t.server)
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := secure.Handshake(); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	select {
+	case t.streams <- secure:
+	case <-t.done:
+		_ = conn.Close()
+	}
+}
+
+// StreamCh returns the streams accepted, once secured
+func (t *tlsTransport) StreamCh() <-chan net.Conn {
+	return t.streams
+}
+
+// DialTimeout opens a secured stream to a peer
+func (t *tlsTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", addr, t.client)
+}
+
+// DialAddressTimeout opens a secured stream to a peer
+func (t *tlsTransport) DialAddressTimeout(addr memberlist.Address, timeout time.Duration) (net.Conn, error) {
+	return t.DialTimeout(addr.Addr, timeout)
+}
+
+// Shutdown shuts the transport down
+func (t *tlsTransport) Shutdown() error {
+	close(t.done)
+	return t.NetTransport.Shutdown()
+}
@@ -0,0 +1,108 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTLSConfigs issues a certificate with a new CA and returns the TLS configs of a node presenting it
+func newTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "talaria-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, _ = x509.ParseCertificate(caDer)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	server = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	client = &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, ServerName: "node", InsecureSkipVerify: true}
+	return
+}
+
+func TestClusterSecure(t *testing.T) {
+	server, client := newTLSConfigs(t)
+	keys, err := DecodeKeys([]string{"Q2x1c3RlcktleTEyMzQ1Ng=="})
+	assert.NoError(t, err)
+
+	port := mrand.Intn(30000) + 2000
+	first, err := NewSecure(port, Security{Keys: keys, Server: server, Client: client})
+	assert.NoError(t, err)
+	defer first.Close()
+
+	// A node with the same certificate authority and keys joins the cluster
+	second, err := NewSecure(port+1, Security{Keys: keys, Server: server, Client: client})
+	assert.NoError(t, err)
+	defer second.Close()
+	assert.NoError(t, second.Join(fmt.Sprintf("127.0.0.1:%d", port)))
+
+	// A node without a certificate can not join the cluster
+	plain := New(port + 2)
+	defer plain.Close()
+	assert.Error(t, plain.Join(fmt.Sprintf("127.0.0.1:%d", port)))
+
+	// A node with another certificate authority can not join the cluster
+	otherServer, otherClient := newTLSConfigs(t)
+	other, err := NewSecure(port+3, Security{Keys: keys, Server: otherServer, Client: otherClient})
+	assert.NoError(t, err)
+	defer other.Close()
+	assert.Error(t, other.Join(fmt.Sprintf("127.0.0.1:%d", port)))
+}
+
+func TestClusterSetKeys(t *testing.T) {
+	assert.Error(t, New(mrand.Intn(30000)+2000).SetKeys(nil))
+
+	keys, err := DecodeKeys([]string{"Q2x1c3RlcktleTEyMzQ1Ng==", "T3RoZXJLZXkxMjM0NTY3OA=="})
+	assert.NoError(t, err)
+	c, err := NewSecure(mrand.Intn(30000)+2000, Security{Keys: keys[:1]})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Add a secondary key, promote it and remove the previous one
+	assert.NoError(t, c.SetKeys([][]byte{keys[0], keys[1]}))
+	assert.Len(t, c.keyring.GetKeys(), 2)
+	assert.NoError(t, c.SetKeys([][]byte{keys[1], keys[0]}))
+	assert.Equal(t, keys[1], c.keyring.GetPrimaryKey())
+	assert.NoError(t, c.SetKeys([][]byte{keys[1]}))
+	assert.Equal(t, [][]byte{keys[1]}, c.keyring.GetKeys())
+
+	assert.Error(t, c.SetKeys(nil))
+	_, err = DecodeKeys([]string{"not base64"})
+	assert.Error(t, err)
+}
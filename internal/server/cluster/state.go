@@ -0,0 +1,173 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"encoding/binary"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// frameMarker starts the framed messages, which carry the states replicated under a name. The messages of the
+// default state are sent as they are, and never start with the marker as they are encoded as JSON.
+const frameMarker = 0x00
+
+// State represents a state replicated to every member of the cluster, such as the catalog of the tables. Since
+// the updates may be received more than once and in any order, merging them must converge to the same state.
+type State interface {
+	Encode() []byte
+	Merge(update []byte)
+}
+
+// Replicate replicates the state through the gossip. The updates are broadcast to the peers as they happen,
+// and the full state is exchanged with a random peer periodically and when a node joins, to repair the
+// updates which were missed.
+func (c *Cluster) Replicate(state State) {
+	c.meta.Lock()
+	defer c.meta.Unlock()
+	c.meta.state = state
+}
+
+// ReplicateAs replicates another state through the gossip under a name, which tells its updates apart from the
+// ones of the other states, and returns the function broadcasting its updates to the peers.
+func (c *Cluster) ReplicateAs(name string, state State) func(update []byte) {
+	c.meta.Lock()
+	defer c.meta.Unlock()
+	if c.meta.named == nil {
+		c.meta.named = make(map[string]State, 1)
+	}
+
+	c.meta.named[name] = state
+	return func(update []byte) {
+		c.meta.queue.QueueBroadcast(broadcast(appendFrame(nil, name, update)))
+	}
+}
+
+// Broadcast gossips an update of the replicated state to the peers.
+func (c *Cluster) Broadcast(update []byte) {
+	c.meta.queue.QueueBroadcast(broadcast(update))
+}
+
+// replicated returns the replicated state of a name, the default state having an empty name
+func (d *delegate) replicated(name string) State {
+	d.RLock()
+	defer d.RUnlock()
+	if name == "" {
+		return d.state
+	}
+	return d.named[name]
+}
+
+// NotifyMsg is called when an update of the replicated state is received.
+func (d *delegate) NotifyMsg(msg []byte) {
+	if len(msg) > 0 {
+		update := make([]byte, len(msg)) // The buffer is reused by memberlist
+		copy(update, msg)
+		d.merge(update)
+	}
+}
+
+// merge merges the updates of a message into the states they belong to
+func (d *delegate) merge(msg []byte) {
+	if msg[0] != frameMarker {
+		if state := d.replicated(""); state != nil {
+			state.Merge(msg)
+		}
+		return
+	}
+
+	for len(msg) > 0 {
+		name, update, rest, ok := readFrame(msg)
+		if !ok {
+			return // The message is corrupted
+		}
+
+		if state := d.replicated(name); state != nil && len(update) > 0 {
+			state.Merge(update)
+		}
+		msg = rest
+	}
+}
+
+// GetBroadcasts returns the updates of the replicated state to broadcast.
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return d.queue.GetBroadcasts(overhead, limit)
+}
+
+// LocalState returns the full replicated state, exchanged on a push/pull. The default state is sent as it is
+// unless other states are replicated, in which case every state is framed with its name.
+func (d *delegate) LocalState(join bool) []byte {
+	d.RLock()
+	defer d.RUnlock()
+	if len(d.named) == 0 {
+		if d.state != nil {
+			return d.state.Encode()
+		}
+		return nil
+	}
+
+	var out []byte
+	if d.state != nil {
+		out = appendFrame(out, "", d.state.Encode())
+	}
+	for name, state := range d.named {
+		out = appendFrame(out, name, state.Encode())
+	}
+	return out
+}
+
+// MergeRemoteState merges the full replicated state received on a push/pull.
+func (d *delegate) MergeRemoteState(buf []byte, join bool) {
+	if len(buf) > 0 {
+		d.merge(buf)
+	}
+}
+
+// appendFrame appends the update of a named state to a message, as the marker followed by the length of the
+// name, the name, the length of the update and the update
+func appendFrame(out []byte, name string, update []byte) []byte {
+	var size [binary.MaxVarintLen64]byte
+	out = append(out, frameMarker)
+	out = append(out, size[:binary.PutUvarint(size[:], uint64(len(name)))]...)
+	out = append(out, name...)
+	out = append(out, size[:binary.PutUvarint(size[:], uint64(len(update)))]...)
+	return append(out, update...)
+}
+
+// readFrame reads the first frame of a message, returning the rest of the message
+func readFrame(msg []byte) (name string, update, rest []byte, ok bool) {
+	if len(msg) == 0 || msg[0] != frameMarker {
+		return "", nil, nil, false
+	}
+
+	msg = msg[1:]
+	n, read := binary.Uvarint(msg)
+	if read <= 0 || uint64(len(msg)-read) < n {
+		return "", nil, nil, false
+	}
+
+	name, msg = string(msg[read:read+int(n)]), msg[read+int(n):]
+	n, read = binary.Uvarint(msg)
+	if read <= 0 || uint64(len(msg)-read) < n {
+		return "", nil, nil, false
+	}
+
+	return name, msg[read : read+int(n)], msg[read+int(n):], true
+}
+
+// broadcast represents an update of the replicated state, by implementing memberlist.Broadcast
+type broadcast []byte
+
+// Invalidates checks whether the update replaces a previous one, which is never the case.
+func (b broadcast) Invalidates(other memberlist.Broadcast) bool {
+	return false
+}
+
+// Message returns the update to broadcast.
+func (b broadcast) Message() []byte {
+	return b
+}
+
+// Finished is called once the update was broadcast.
+func (b broadcast) Finished() {}
@@ -0,0 +1,76 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package cluster
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const updateTimeout = 5 * time.Second
+
+// Zones represents a contract which returns the availability zone of the members of the cluster.
+type Zones interface {
+	ZoneOf(addr string) string
+}
+
+// meta represents the metadata of a node, gossiped to its peers
+type meta struct {
+	Zone string `json:"zone,omitempty"` // The availability zone of the node
+}
+
+// SetZone sets the availability zone of the local node and gossips it to the peers.
+func (c *Cluster) SetZone(zone string) error {
+	encoded, err := json.Marshal(meta{Zone: zone})
+	if err != nil {
+		return err
+	}
+
+	c.meta.Lock()
+	c.meta.local = encoded
+	c.meta.Unlock()
+	return c.list.UpdateNode(updateTimeout)
+}
+
+// ZoneOf returns the availability zone of a member, or an empty string if it is unknown.
+func (c *Cluster) ZoneOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	for _, m := range c.list.Members() {
+		if m.Addr.String() != addr {
+			continue
+		}
+
+		var info meta
+		if err := json.Unmarshal(m.Meta, &info); err == nil {
+			return info.Zone
+		}
+	}
+	return ""
+}
+
+// delegate gossips the metadata of the local node and the replicated state, by implementing memberlist.Delegate
+type delegate struct {
+	sync.RWMutex
+	local []byte                           // The encoded metadata of the local node
+	state State                            // The state replicated to the peers (optional)
+	named map[string]State                 // The other states replicated to the peers, by name (optional)
+	queue *memberlist.TransmitLimitedQueue // The updates of the state to broadcast
+}
+
+// NodeMeta returns the metadata of the local node.
+func (d *delegate) NodeMeta(limit int) []byte {
+	d.RLock()
+	defer d.RUnlock()
+	if len(d.local) > limit {
+		return nil
+	}
+	return d.local
+}
@@ -0,0 +1,25 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package health
+
+import (
+	"context"
+	"fmt"
+)
+
+// Headroom returns a check which fails when the fraction of free space left on the volume of the
+// directory is below the minimum, for example 0.05 for 5%.
+func Headroom(dir string, min float64) Check {
+	return func(ctx context.Context) error {
+		free, total, err := diskSpace(dir)
+		if err != nil || total == 0 {
+			return err
+		}
+
+		if ratio := float64(free) / float64(total); ratio < min {
+			return fmt.Errorf("only %.1f%% of the disk is free, below %.1f%%", ratio*100, min*100)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package health
+
+// diskSpace is not supported on windows, and the headroom is never checked
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check represents a health check, which returns an error if the component checked is not healthy.
+type Check func(ctx context.Context) error
+
+// Report represents the outcome of the health checks, as returned by the probes.
+type Report struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]Result `json:"checks"`
+}
+
+// Result represents the outcome of a single health check.
+type Result struct {
+	Healthy  bool    `json:"healthy"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"` // The duration of the check, in milliseconds
+}
+
+// Health runs the checks of the liveness and readiness probes. The liveness checks detect the failures
+// which only a restart can fix, and the readiness checks also include the dependencies of the node.
+type Health struct {
+	lock    sync.RWMutex
+	timeout time.Duration
+	live    map[string]Check
+	ready   map[string]Check
+}
+
+// New creates a new set of health checks, each of them running for up to the timeout.
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout: timeout,
+		live:    make(map[string]Check),
+		ready:   make(map[string]Check),
+	}
+}
+
+// Live adds a check to both the liveness and the readiness probes.
+func (h *Health) Live(name string, check Check) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.live[name] = check
+	h.ready[name] = check
+}
+
+// Ready adds a check to the readiness probe only.
+func (h *Health) Ready(name string, check Check) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.ready[name] = check
+}
+
+// Liveness runs the liveness checks
+func (h *Health) Liveness(ctx context.Context) Report {
+	return h.run(ctx, h.live)
+}
+
+// Readiness runs the readiness checks
+func (h *Health) Readiness(ctx context.Context) Report {
+	return h.run(ctx, h.ready)
+}
+
+// HandleLive serves the liveness probe, with a 503 status if any of the checks fails.
+func (h *Health) HandleLive(w http.ResponseWriter, r *http.Request) {
+	write(w, h.Liveness(r.Context()))
+}
+
+// HandleReady serves the readiness probe, with a 503 status if any of the checks fails.
+func (h *Health) HandleReady(w http.ResponseWriter, r *http.Request) {
+	write(w, h.Readiness(r.Context()))
+}
+
+// run runs the checks concurrently and reports their outcome
+func (h *Health) run(ctx context.Context, checks map[string]Check) Report {
+	h.lock.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	funcs := make([]Check, len(names))
+	for i, name := range names {
+		funcs[i] = checks[name]
+	}
+	h.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i, check := range funcs {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Healthy: true, Checks: make(map[string]Result, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		report.Healthy = report.Healthy && results[i].Healthy
+	}
+	return report
+}
+
+// run runs a single check, failing it if it does not complete before the context is done
+func run(ctx context.Context, check Check) Result {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Healthy:  err == nil,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// write writes out the report as JSON
+func write(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	h := New(50 * time.Millisecond)
+	h.Live("storage", func(ctx context.Context) error { return nil })
+	h.Ready("sink", func(ctx context.Context) error { return errors.New("unreachable") })
+	h.Ready("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	// The liveness probe only runs the liveness checks
+	w := httptest.NewRecorder()
+	h.HandleLive(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report Report
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.True(t, report.Healthy)
+	assert.Len(t, report.Checks, 1)
+
+	// The readiness probe runs every check, and reports the failures
+	w = httptest.NewRecorder()
+	h.HandleReady(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	report = Report{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.False(t, report.Healthy)
+	assert.Len(t, report.Checks, 3)
+	assert.True(t, report.Checks["storage"].Healthy)
+	assert.Equal(t, "unreachable", report.Checks["sink"].Error)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
+
+func TestHeadroom(t *testing.T) {
+	assert.NoError(t, Headroom(".", 0)(context.Background()))
+	assert.Error(t, Headroom(".", 1.01)(context.Background()))
+}
@@ -10,8 +10,18 @@ import (
 
 // SplitID represents a split key along with the table name
 type SplitID struct {
-	Table string // The name of the table
-	Split []byte // The encoded split key
+	Table   string // The name of the table
+	Split   []byte // The encoded split key
+	Origin  []byte // The split key to read from the owner once the local pages are read (optional)
+	Owner   string // The node the remaining pages are read from, on behalf of presto (optional)
+	Proxied bool   // Whether the split is read on behalf of another node, which only reads local data
+}
+
+// localID represents the encoding of a split ID which is only read locally, unchanged from the
+// earlier releases so the IDs remain compatible across nodes during a rolling deployment
+type localID struct {
+	Table string
+	Split []byte
 }
 
 // encodeThriftID creates a split ID by encoding a query.
@@ -27,17 +37,25 @@ func decodeThriftID(id *presto.PrestoThriftId, token *presto.PrestoThriftNullabl
 		id = token.Token
 	}
 
-	out = new(SplitID)
-	err = binary.Unmarshal(id.Id, out)
-	return
+	return decodeSplitID(id.Id)
 }
 
 // encodeID creates a split ID by encoding a query.
 func encodeID(table string, split []byte) []byte {
-	b, err := binary.Marshal(&SplitID{
+	return encodeSplitID(&SplitID{
 		Table: table,
 		Split: split,
 	})
+}
+
+// encodeSplitID encodes a split ID, using the local encoding if it is not read across nodes.
+func encodeSplitID(id *SplitID) []byte {
+	var v interface{} = id
+	if id.Origin == nil && id.Owner == "" && !id.Proxied {
+		v = &localID{Table: id.Table, Split: id.Split}
+	}
+
+	b, err := binary.Marshal(v)
 	if err != nil {
 		panic(err)
 	}
@@ -45,13 +63,26 @@ func encodeID(table string, split []byte) []byte {
 	return b
 }
 
+// decodeSplitID decodes a split ID, falling back to the local encoding.
+func decodeSplitID(b []byte) (*SplitID, error) {
+	out := new(SplitID)
+	if err := binary.Unmarshal(b, out); err == nil {
+		return out, nil
+	}
+
+	local := new(localID)
+	if err := binary.Unmarshal(b, local); err != nil {
+		return nil, err
+	}
+
+	return &SplitID{Table: local.Table, Split: local.Split}, nil
+}
+
 // decodeID unmarshals thrift ID back to a struct.
 func decodeID(id, token []byte) (out *SplitID, err error) {
 	if token != nil {
 		id = token
 	}
 
-	out = new(SplitID)
-	err = binary.Unmarshal(id, out)
-	return
+	return decodeSplitID(id)
 }
@@ -21,3 +21,15 @@ func TestSplitCodec(t *testing.T) {
 		assert.Equal(t, []byte("ABC"), out.Split)
 	})
 }
+
+func TestSplitCodec_Proxy(t *testing.T) {
+	in := &SplitID{Table: "roman", Split: []byte("ABC"), Origin: []byte("A"), Owner: "10.0.0.1"}
+	out, err := decodeID(encodeSplitID(in), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	in = &SplitID{Table: "roman", Split: []byte("ABC"), Proxied: true}
+	out, err = decodeID(nil, encodeSplitID(in))
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
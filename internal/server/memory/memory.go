@@ -0,0 +1,170 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor"
+)
+
+const ctxTag = "memory"
+
+// ErrHardLimit is returned when a request is rejected because the node uses too much memory.
+var ErrHardLimit = errors.New("memory: the node is above its memory limit, please retry later")
+
+// Level represents the pressure on the memory of the node
+type Level int32
+
+// The levels of pressure
+const (
+	Normal Level = iota // Below the soft limit
+	Soft                // Above the soft limit, the caches are shrunk and the ingestion is paused
+	Hard                // Above the hard limit, the queries are rejected
+)
+
+// Limiter represents a process-wide accountant of the memory. It periodically measures the heap of the process,
+// along with the size of the components tracked such as the column blocks in flight, the decode buffers and the
+// caches, and shrinks the caches once the heap is above the soft limit.
+type Limiter struct {
+	soft     int64                   // The soft limit, in bytes
+	hard     int64                   // The hard limit, in bytes
+	level    int32                   // The current level of pressure
+	lock     sync.Mutex              // The lock protecting the components
+	sizes    map[string]func() int64 // The size of the components tracked, by name
+	shrinks  []func()                // The functions releasing memory once above the soft limit
+	monitor  monitor.Monitor         // The monitor to report to
+	heap     func() int64            // The function measuring the heap in use
+	interval time.Duration           // The interval at which the memory is measured
+}
+
+// New creates a new memory limiter. A non-positive limit disables the corresponding level.
+func New(soft, hard int64, monitor monitor.Monitor) *Limiter {
+	return &Limiter{
+		soft:     soft,
+		hard:     hard,
+		sizes:    make(map[string]func() int64),
+		monitor:  monitor,
+		heap:     heapInUse,
+		interval: time.Second,
+	}
+}
+
+// Track adds a component whose size, in bytes, is reported along with the heap.
+func (l *Limiter) Track(name string, size func() int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sizes[name] = size
+}
+
+// OnSoftLimit adds a function which releases memory, such as by shrinking a cache, called every time the heap
+// is measured above the soft limit.
+func (l *Limiter) OnSoftLimit(shrink func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.shrinks = append(l.shrinks, shrink)
+}
+
+// Level returns the current level of pressure on the memory.
+func (l *Limiter) Level() Level {
+	if l == nil {
+		return Normal
+	}
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+// Paused returns whether the ingestion should be paused, as the heap is above the soft limit.
+func (l *Limiter) Paused() bool {
+	return l.Level() >= Soft
+}
+
+// Admit returns an error if the heap is above the hard limit, so that new queries are rejected.
+func (l *Limiter) Admit() error {
+	if l.Level() >= Hard {
+		return ErrHardLimit
+	}
+	return nil
+}
+
+// Run periodically measures the memory, until the context is cancelled.
+func (l *Limiter) Run(ctx context.Context) (interface{}, error) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+			l.Measure()
+		}
+	}
+}
+
+// Measure measures the heap and the components tracked, updates the level of pressure and shrinks the caches
+// if the heap is above the soft limit.
+func (l *Limiter) Measure() Level {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	names := make([]string, 0, len(l.sizes))
+	for name := range l.sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l.monitor.Gauge(ctxTag, "component", float64(l.sizes[name]()), "component:"+name)
+	}
+
+	heap := l.heap()
+	level := levelOf(heap, l.soft, l.hard)
+	l.monitor.Gauge(ctxTag, "heap", float64(heap))
+	if previous := Level(atomic.SwapInt32(&l.level, int32(level))); previous != level {
+		l.monitor.Count1(ctxTag, "level", "level:"+level.String())
+		l.monitor.Info("memory: the heap is %d bytes, now at the %s level", heap, level)
+	}
+
+	if level >= Soft {
+		for _, shrink := range l.shrinks {
+			shrink()
+		}
+	}
+	return level
+}
+
+// levelOf returns the level of pressure for the size of the heap
+func levelOf(heap, soft, hard int64) Level {
+	switch {
+	case hard > 0 && heap >= hard:
+		return Hard
+	case soft > 0 && heap >= soft:
+		return Soft
+	default:
+		return Normal
+	}
+}
+
+// String returns the name of the level
+func (v Level) String() string {
+	switch v {
+	case Soft:
+		return "soft"
+	case Hard:
+		return "hard"
+	default:
+		return "normal"
+	}
+}
+
+// heapInUse returns the number of bytes in the spans of the heap being used
+func heapInUse() int64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapInuse)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	var heap int64 = 10
+	var shrunk int
+	l := New(100, 200, monitor.NewNoop())
+	l.heap = func() int64 { return heap }
+	l.Track("cache", func() int64 { return 5 })
+	l.OnSoftLimit(func() { shrunk++ })
+
+	// Below the soft limit
+	assert.Equal(t, Normal, l.Measure())
+	assert.False(t, l.Paused())
+	assert.NoError(t, l.Admit())
+	assert.Equal(t, 0, shrunk)
+
+	// Above the soft limit, the caches are shrunk and the ingestion is paused
+	heap = 150
+	assert.Equal(t, Soft, l.Measure())
+	assert.True(t, l.Paused())
+	assert.NoError(t, l.Admit())
+	assert.Equal(t, 1, shrunk)
+
+	// Above the hard limit, the queries are rejected
+	heap = 250
+	assert.Equal(t, Hard, l.Measure())
+	assert.Equal(t, ErrHardLimit, l.Admit())
+	assert.Equal(t, 2, shrunk)
+
+	// Back to normal once the memory is released
+	heap = 50
+	assert.Equal(t, Normal, l.Measure())
+	assert.NoError(t, l.Admit())
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	var l *Limiter
+	assert.False(t, l.Paused())
+	assert.NoError(t, l.Admit())
+
+	l = New(0, 0, monitor.NewNoop())
+	assert.Equal(t, Normal, l.Measure())
+}
+
+func TestLimiter_Run(t *testing.T) {
+	l := New(1, 0, monitor.NewNoop())
+	l.interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go l.Run(ctx)
+	defer cancel()
+
+	assert.Eventually(t, l.Paused, time.Second, time.Millisecond)
+}
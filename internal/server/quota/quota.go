@@ -0,0 +1,134 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const anyProducer = "*"
+
+// Exceeded represents the kind of quota a producer exceeded
+type Exceeded string
+
+// The kinds of quota
+const (
+	None  Exceeded = ""
+	Rate  Exceeded = "rate"
+	Bytes Exceeded = "bytes"
+)
+
+// usage represents the ingestion of a producer, counted against its quota
+type usage struct {
+	tokens float64   // The events the producer can still ingest right away, negative once in debt
+	last   time.Time // The time at which the tokens were last refilled
+	day    int64     // The day the bytes are counted for, in days since the epoch (UTC)
+	bytes  int64     // The bytes ingested during the day
+}
+
+// Limiter represents the ingestion quotas of the producers. Since the number of events and bytes of a request
+// are only known once it is decoded, a request is admitted as long as the producer is within its quota and
+// is charged once ingested, a burst above the rate being paid back before the next request is admitted.
+type Limiter struct {
+	lock   sync.Mutex              // The lock protecting the quotas and the usage
+	quotas map[string]config.Quota // The quotas, per producer or "*" for each of the other producers
+	usage  map[string]*usage       // The usage, per producer
+	now    func() time.Time        // The clock
+}
+
+// New creates a new limiter, without any quota.
+func New() *Limiter {
+	return &Limiter{
+		usage: make(map[string]*usage),
+		now:   time.Now,
+	}
+}
+
+// Configure replaces the quotas, keeping the usage of the producers.
+func (l *Limiter) Configure(quotas map[string]config.Quota) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.quotas = quotas
+}
+
+// Allow returns the kind of quota the producer exceeded, if any, along with a ResourceExhausted error.
+func (l *Limiter) Allow(producer string) (Exceeded, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	q, ok := l.quotaOf(producer)
+	if !ok {
+		return None, nil
+	}
+
+	u := l.usageOf(producer, q)
+	switch {
+	case q.Rate > 0 && u.tokens <= 0:
+		return Rate, errors.ResourceExhausted(producer + " exceeded its quota of events per second")
+	case q.Bytes > 0 && u.bytes >= q.Bytes:
+		return Bytes, errors.ResourceExhausted(producer + " exceeded its quota of bytes per day")
+	default:
+		return None, nil
+	}
+}
+
+// Charge counts the events and the bytes ingested by the producer against its quota.
+func (l *Limiter) Charge(producer string, events, bytes int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	q, ok := l.quotaOf(producer)
+	if !ok {
+		return
+	}
+
+	u := l.usageOf(producer, q)
+	u.tokens -= float64(events)
+	u.bytes += bytes
+}
+
+// quotaOf returns the quota of the producer, if any
+func (l *Limiter) quotaOf(producer string) (config.Quota, bool) {
+	if producer == "" {
+		return config.Quota{}, false
+	}
+
+	if q, ok := l.quotas[producer]; ok {
+		return q, true
+	}
+
+	q, ok := l.quotas[anyProducer]
+	return q, ok
+}
+
+// usageOf returns the usage of the producer, with the tokens refilled and the bytes reset on a new day
+func (l *Limiter) usageOf(producer string, q config.Quota) *usage {
+	now := l.now()
+	burst := float64(q.Burst)
+	if burst <= 0 {
+		burst = float64(q.Rate)
+	}
+
+	u, ok := l.usage[producer]
+	if !ok {
+		u = &usage{tokens: burst, last: now}
+		l.usage[producer] = u
+	}
+
+	u.tokens += now.Sub(u.last).Seconds() * float64(q.Rate)
+	if u.tokens > burst {
+		u.tokens = burst
+	}
+	u.last = now
+
+	if day := now.Unix() / 86400; day != u.day {
+		u.day = day
+		u.bytes = 0
+	}
+	return u
+}
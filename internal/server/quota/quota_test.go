@@ -0,0 +1,81 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Rate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New()
+	l.now = func() time.Time { return now }
+	l.Configure(map[string]config.Quota{
+		"*":        {Rate: 100},
+		"payments": {Rate: 10, Burst: 50},
+	})
+
+	// A burst above the rate is admitted, then paid back before the next request
+	exceeded, err := l.Allow("payments")
+	assert.NoError(t, err)
+	assert.Equal(t, None, exceeded)
+	l.Charge("payments", 60, 0)
+
+	exceeded, err = l.Allow("payments")
+	assert.Error(t, err)
+	assert.Equal(t, Rate, exceeded)
+
+	now = now.Add(time.Second)
+	_, err = l.Allow("payments")
+	assert.Error(t, err)
+	now = now.Add(time.Second)
+	_, err = l.Allow("payments")
+	assert.NoError(t, err)
+
+	// Each of the other producers has its own quota
+	l.Charge("10.0.0.1", 100, 0)
+	_, err = l.Allow("10.0.0.1")
+	assert.Error(t, err)
+	_, err = l.Allow("10.0.0.2")
+	assert.NoError(t, err)
+
+	// The requests without a producer are not limited
+	l.Charge("", 1000, 0)
+	_, err = l.Allow("")
+	assert.NoError(t, err)
+}
+
+func TestLimiter_Bytes(t *testing.T) {
+	now := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	l := New()
+	l.now = func() time.Time { return now }
+	l.Configure(map[string]config.Quota{
+		"payments": {Bytes: 1024},
+	})
+
+	l.Charge("payments", 10, 1024)
+	exceeded, err := l.Allow("payments")
+	assert.Error(t, err)
+	assert.Equal(t, Bytes, exceeded)
+
+	// The bytes are counted per day
+	now = now.Add(2 * time.Hour)
+	_, err = l.Allow("payments")
+	assert.NoError(t, err)
+
+	// The usage is kept when the quotas change
+	l.Charge("payments", 10, 512)
+	l.Configure(map[string]config.Quota{"payments": {Bytes: 256}})
+	_, err = l.Allow("payments")
+	assert.Error(t, err)
+
+	// Without a quota, the producer is not limited
+	l.Configure(nil)
+	_, err = l.Allow("payments")
+	assert.NoError(t, err)
+}
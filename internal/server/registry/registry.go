@@ -0,0 +1,275 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const fileName = "schemas.json"
+
+// Version represents a version of the schema of a table. Each version adds columns to the previous one, the
+// columns being nullable as the rows ingested before do not have them.
+type Version struct {
+	Version int           `json:"version"`
+	Schema  typeof.Schema `json:"schema"`
+	Added   []string      `json:"added"`   // The columns added by this version, sorted by name
+	Created int64         `json:"created"` // The time of the version, in unix nanoseconds
+}
+
+// Registry represents the versions of the schemas of the tables, as they evolve with the ingested data. The
+// registry is persisted in the storage directory of the node and replicated to the other nodes, which add the
+// columns ingested by the other nodes to their own versions.
+type Registry struct {
+	lock      sync.RWMutex
+	path      string                // The file in which the registry is persisted
+	tables    map[string][]Version  // The versions of the schema, by table, oldest first
+	apply     func(string, Version) // The function applying a new version on the node (optional)
+	broadcast func([]byte)          // The function broadcasting the new versions to the other nodes (optional)
+}
+
+// New loads the registry persisted in the directory, or creates an empty one.
+func New(dir string) (*Registry, error) {
+	if dir == "" {
+		dir = "/data" // Same default as the storage
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		path:   filepath.Join(dir, fileName),
+		tables: make(map[string][]Version),
+	}
+
+	b, err := ioutil.ReadFile(r.path)
+	switch {
+	case os.IsNotExist(err):
+		return r, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &r.tables); err != nil {
+		return nil, errors.Internal("registry: unable to read "+r.path, err)
+	}
+	return r, nil
+}
+
+// OnChange sets the function applying the versions added on this node or replicated from the other nodes.
+func (r *Registry) OnChange(apply func(table string, version Version)) {
+	r.apply = apply
+}
+
+// SetBroadcast sets the function broadcasting the versions added on this node to the other nodes.
+func (r *Registry) SetBroadcast(broadcast func([]byte)) {
+	r.broadcast = broadcast
+}
+
+// Latest returns the latest version of the schema of a table.
+func (r *Registry) Latest(table string) (Version, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	versions := r.tables[table]
+	if len(versions) == 0 {
+		return Version{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// Versions returns every version of the schema of a table, oldest first.
+func (r *Registry) Versions(table string) []Version {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]Version(nil), r.tables[table]...)
+}
+
+// Schemas returns the latest schema of every table.
+func (r *Registry) Schemas() map[string]typeof.Schema {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	out := make(map[string]typeof.Schema, len(r.tables))
+	for name, versions := range r.tables {
+		if len(versions) > 0 {
+			out[name] = versions[len(versions)-1].Schema
+		}
+	}
+	return out
+}
+
+// Evolve checks the schema of the data ingested into a table against its latest version. The columns which
+// are not known yet are added as a new version, which is persisted and broadcast, while a column whose type
+// changed is rejected. It returns the latest version of the schema.
+func (r *Registry) Evolve(table string, schema typeof.Schema) (Version, error) {
+	if latest, ok := r.Latest(table); ok {
+		if err := compatible(table, latest, schema); err != nil {
+			return latest, err
+		}
+
+		// Most of the time the columns are already known
+		if _, same := latest.Schema.Compare(schema); same {
+			return latest, nil
+		}
+	}
+
+	r.lock.Lock()
+	version, added, err := r.evolve(table, schema)
+	if err == nil && added {
+		err = r.persist()
+	}
+	r.lock.Unlock()
+	if err != nil || !added {
+		return version, err
+	}
+
+	r.notify(table, version)
+	if r.broadcast != nil {
+		b, _ := json.Marshal(map[string]typeof.Schema{table: version.Schema})
+		r.broadcast(b)
+	}
+	return version, nil
+}
+
+// Forget removes the versions of a table, once the table is dropped.
+func (r *Registry) Forget(table string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.tables[table]; !ok {
+		return nil
+	}
+
+	delete(r.tables, table)
+	return r.persist()
+}
+
+// Encode encodes the latest schema of every table.
+func (r *Registry) Encode() []byte {
+	b, _ := json.Marshal(r.Schemas())
+	return b
+}
+
+// Merge merges the schemas received from another node, adding the columns which were not known yet as new
+// versions. The columns whose type differs from the one known by this node are ignored.
+func (r *Registry) Merge(update []byte) {
+	var remote map[string]typeof.Schema
+	if err := json.Unmarshal(update, &remote); err != nil {
+		return
+	}
+
+	r.lock.Lock()
+	changed := make(map[string]Version, len(remote))
+	for table, schema := range remote {
+		if versions := r.tables[table]; len(versions) > 0 {
+			schema = schema.Except(conflicts(versions[len(versions)-1].Schema, schema))
+		}
+
+		if version, added, err := r.evolve(table, schema); err == nil && added {
+			changed[table] = version
+		}
+	}
+
+	var err error
+	if len(changed) > 0 {
+		err = r.persist()
+	}
+	r.lock.Unlock()
+
+	if err == nil {
+		for table, version := range changed {
+			r.notify(table, version)
+		}
+	}
+}
+
+// notify applies a new version on this node
+func (r *Registry) notify(table string, version Version) {
+	if r.apply != nil {
+		r.apply(table, version)
+	}
+}
+
+// evolve adds the new columns of the schema as a new version of the table, returning whether a version
+// was added. The lock must be held.
+func (r *Registry) evolve(table string, schema typeof.Schema) (Version, bool, error) {
+	versions := r.tables[table]
+	latest := Version{Schema: typeof.Schema{}}
+	if len(versions) > 0 {
+		latest = versions[len(versions)-1]
+	}
+
+	// Check again, as the schema might have changed since
+	if err := compatible(table, latest, schema); err != nil {
+		return latest, false, err
+	}
+
+	added := schema.Except(latest.Schema).Columns()
+	if len(added) == 0 {
+		return latest, false, nil
+	}
+
+	merged, _ := latest.Schema.Union(schema)
+	version := Version{
+		Version: latest.Version + 1,
+		Schema:  merged,
+		Added:   added,
+		Created: time.Now().UnixNano(),
+	}
+
+	r.tables[table] = append(versions, version)
+	return version, true, nil
+}
+
+// persist writes the registry to its file, replacing the previous one at once
+func (r *Registry) persist() error {
+	b, err := json.MarshalIndent(r.tables, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Internal("registry: unable to write "+tmp, err)
+	}
+
+	if err := os.Rename(tmp, r.path); err != nil {
+		return errors.Internal("registry: unable to write "+r.path, err)
+	}
+	return nil
+}
+
+// compatible checks whether the columns of a schema have the same type as in the version of a table
+func compatible(table string, version Version, schema typeof.Schema) error {
+	diff := conflicts(version.Schema, schema)
+	if len(diff) == 0 {
+		return nil
+	}
+
+	column := diff.Columns()[0]
+	return errors.InvalidArgument(fmt.Sprintf("registry: column %s of table %s is %s in version %d of its schema, but was ingested as %s",
+		column, table, version.Schema[column], version.Version, diff[column]))
+}
+
+// conflicts returns the columns of a schema whose type differs from the known schema
+func conflicts(known, schema typeof.Schema) typeof.Schema {
+	var diff typeof.Schema
+	for name, typ := range schema {
+		if existing, ok := known[name]; ok && existing != typ {
+			if diff == nil {
+				diff = make(typeof.Schema, 1)
+			}
+			diff[name] = typ
+		}
+	}
+	return diff
+}
@@ -0,0 +1,69 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := New(dir)
+	assert.NoError(t, err)
+
+	var broadcast [][]byte
+	r.SetBroadcast(func(b []byte) { broadcast = append(broadcast, b) })
+
+	// The first schema ingested is the first version
+	v, err := r.Evolve("events", typeof.Schema{"id": typeof.String, "ts": typeof.Int64})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v.Version)
+	assert.Equal(t, []string{"id", "ts"}, v.Added)
+
+	// The same columns, or fewer, do not add a version
+	v, err = r.Evolve("events", typeof.Schema{"id": typeof.String})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v.Version)
+	assert.Len(t, broadcast, 1)
+
+	// A new column is added to a new version
+	v, err = r.Evolve("events", typeof.Schema{"id": typeof.String, "amount": typeof.Float64})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v.Version)
+	assert.Equal(t, []string{"amount"}, v.Added)
+	assert.Equal(t, typeof.Schema{"id": typeof.String, "ts": typeof.Int64, "amount": typeof.Float64}, v.Schema)
+	assert.Len(t, broadcast, 2)
+
+	// A column whose type changed is rejected
+	_, err = r.Evolve("events", typeof.Schema{"ts": typeof.String, "other": typeof.Bool})
+	assert.Contains(t, err.Error(), "column ts of table events is int64 in version 2 of its schema, but was ingested as string")
+	assert.Len(t, r.Versions("events"), 2)
+
+	// The registry is persisted
+	loaded, err := New(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Versions("events"), loaded.Versions("events"))
+
+	// The columns of the other nodes are merged, except the conflicting ones
+	loaded.Merge([]byte(`{"events":{"ts":"string","country":"string"},"orders":{"id":"int64"}}`))
+	latest, ok := loaded.Latest("events")
+	assert.True(t, ok)
+	assert.Equal(t, 3, latest.Version)
+	assert.Equal(t, []string{"country"}, latest.Added)
+	assert.Equal(t, typeof.Int64, latest.Schema["ts"])
+	assert.Len(t, loaded.Schemas(), 2)
+
+	// The versions of a dropped table are forgotten
+	assert.NoError(t, loaded.Forget("orders"))
+	_, ok = loaded.Latest("orders")
+	assert.False(t, ok)
+}
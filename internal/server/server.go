@@ -5,24 +5,42 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/grab/async"
-	"github.com/kelindar/talaria/internal/column"
 	"github.com/kelindar/talaria/internal/config"
+	_ "github.com/kelindar/talaria/internal/encoding/zstd" // Register the zstd compressor
 	"github.com/kelindar/talaria/internal/ingress/s3sqs"
 	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
 	"github.com/kelindar/talaria/internal/presto"
 	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/admission"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/cache"
+	"github.com/kelindar/talaria/internal/server/catalog"
+	"github.com/kelindar/talaria/internal/server/certs"
+	"github.com/kelindar/talaria/internal/server/memory"
+	"github.com/kelindar/talaria/internal/server/quota"
+	"github.com/kelindar/talaria/internal/server/registry"
+	"github.com/kelindar/talaria/internal/server/slowlog"
 	"github.com/kelindar/talaria/internal/server/thriftlog"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
 	"github.com/kelindar/talaria/internal/table"
 	talaria "github.com/kelindar/talaria/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // Register the gzip compressor
 )
 
 const (
@@ -34,6 +52,7 @@ const (
 // Membership represents a contract required for recovering cluster information.
 type Membership interface {
 	Members() []string
+	Addr() string
 }
 
 // Storage represents an eventlog storage contract.
@@ -49,45 +68,118 @@ type Storage interface {
 func New(conf config.Func, monitor monitor.Monitor, loader *script.Loader, tables ...table.Table) *Server {
 	const maxMessageSize = 32 * 1024 * 1024 // 32 MB
 	server := &Server{
-		server:  grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize)),
 		conf:    conf,
 		monitor: monitor,
+		loader:  loader,
 		tables:  make(map[string]table.Table),
+		quotas:  quota.New(),
+		tenants: quota.New(),
 	}
 
-	// Load computed columns
-	for _, c := range conf().Computed {
-		col, err := column.NewComputed(c.Name, c.Type, c.Func, loader)
-		if err != nil {
-			monitor.Error(err)
-			continue
-		}
+	// Load the certificates of the listeners and of the node (optional), refusing to listen in plain text if
+	// they are invalid
+	if c := conf().Cluster.TLS; c != nil {
+		server.nodeTLS = mustLoad(c)
+	}
+
+	if c := conf().Writers.GRPC; c != nil && c.TLS != nil {
+		server.grpcTLS = mustLoad(c.TLS)
+	}
+
+	if c := conf().Readers.Presto; c != nil && c.TLS != nil {
+		server.prestoTLS = mustLoad(c.TLS)
+	}
+
+	// The messages compressed with gzip or zstd are decompressed by the compressors registered on import
+	maxSize := maxMessageSize
+	if c := conf().Writers.GRPC; c != nil && c.MaxSize > 0 {
+		maxSize = int(c.MaxSize)
+	}
+
+	options := []grpc.ServerOption{grpc.MaxRecvMsgSize(maxSize)}
+	if tlsConfig := certs.ListenerConfig(server.grpcTLS, server.nodeTLS); tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server.server = grpc.NewServer(options...)
+
+	// Load the computed columns and the ingestion pipelines of the tables
+	server.dynamic.Store(server.loadSettings(conf()))
+	server.quotas.Configure(conf().Writers.Quotas)
+	server.tenants.Configure(quotasOf(conf().Tenants))
+
+	// Create the query result cache (optional)
+	if c := conf().Readers.Cache; c != nil {
+		server.cache = cache.New(c.Size, time.Duration(c.TTL)*time.Second)
+	}
+
+	// Create the slow query log (optional)
+	if c := conf().Readers.SlowQuery; c != nil {
+		server.slowlog = slowlog.New(time.Duration(c.Threshold)*time.Millisecond, c.Capacity, monitor)
+	}
+
+	// Create the admission controller (optional)
+	if c := conf().Readers.Admission; c != nil {
+		server.admission = admission.New(c.Splits, c.Bytes, time.Duration(c.Timeout)*time.Millisecond)
+	}
 
-		monitor.Info("server: loaded computed column %v of type %v", c.Name, c.Type)
-		server.computed = append(server.computed, col)
+	// Create the memory limiter (optional), accounting for the caches and the column blocks in flight
+	if c := conf().Memory; c != nil {
+		server.memory = memory.New(c.Soft, c.Hard, monitor)
+		if server.cache != nil {
+			server.memory.Track("cache", server.cache.Size)
+			server.memory.OnSoftLimit(server.cache.Shrink)
+		}
+		if server.admission != nil {
+			server.memory.Track("query", server.admission.InFlight)
+		}
 	}
 
 	// Register the gRPC servers
 	talaria.RegisterIngressServer(server.server, server)
 	talaria.RegisterQueryServer(server.server, server)
+	server.server.RegisterService(&traceServiceDesc, server)
+	server.server.RegisterService(&logsServiceDesc, server)
+	if conf().Readers.FlightSQL != nil {
+		server.server.RegisterService(&flightServiceDesc, newFlightServer(server))
+	}
 
 	// Build a registry of tables
-	for _, table := range tables {
-		monitor.Info("server: registered %s table...", table.Name())
-		server.tables[table.Name()] = table
-	}
+	server.Register(tables...)
 	return server
 }
 
 // Server represents the talaria server which should implement presto thrift interface.
 type Server struct {
-	server   *grpc.Server           // The underlying gRPC server
-	conf     config.Func            // The presto configuration
-	monitor  monitor.Monitor        // The monitoring layer
-	cancel   context.CancelFunc     // The cancellation function for the server
-	tables   map[string]table.Table // The list of tables
-	computed []column.Computed      // The set of computed columns
-	s3sqs    *s3sqs.Ingress         // The S3SQS Ingress (optional)
+	server      *grpc.Server           // The underlying gRPC server
+	conf        config.Func            // The presto configuration
+	monitor     monitor.Monitor        // The monitoring layer
+	cancel      context.CancelFunc     // The cancellation function for the server
+	loader      *script.Loader         // The script loader for the computed columns and the pipelines
+	lock        sync.RWMutex           // The lock protecting the list of tables
+	tables      map[string]table.Table // The list of tables
+	dynamic     atomic.Value           // The settings which are reloaded with the config
+	open        Opener                 // The function opening the tables added to the config (optional)
+	catalog     *catalog.Catalog       // The tables created at runtime (optional)
+	tombstones  *tombstone.Log         // The deletions of the rows of the tables (optional)
+	registry    *registry.Registry     // The versions of the schemas of the tables (optional)
+	s3sqs       *s3sqs.Ingress         // The S3SQS Ingress (optional)
+	cache       *cache.Cache           // The query result cache (optional)
+	slowlog     *slowlog.Log           // The slow query log (optional)
+	admission   *admission.Controller  // The admission controller for queries (optional)
+	memory      *memory.Limiter        // The memory limiter (optional)
+	ring        Ownership              // The hash ring assigning the keys to the nodes (optional)
+	peers       sync.Map               // The clients connected to the other nodes, for forwarding
+	cluster     Membership             // The membership of the cluster, for administration (optional)
+	drain       func()                 // The function draining the node, for administration (optional)
+	meters      sync.Map               // The ingestion rate of each table
+	rebalancing int32                  // Whether a rebalance is in progress
+	replaying   int32                  // Whether a replay is in progress
+	grpcTLS     *certs.Reloader        // The certificate of the gRPC listener (optional)
+	prestoTLS   *certs.Reloader        // The certificate of the thrift listener (optional)
+	nodeTLS     *certs.Reloader        // The certificate of the node, for mutual TLS between the nodes (optional)
+	audit       *audit.Trail           // The audit trail of the administrative operations (optional)
+	quotas      *quota.Limiter         // The ingestion quotas of the producers
+	tenants     *quota.Limiter         // The ingestion quotas of the tenants
 }
 
 // Listen starts listening on presto RPC & gRPC.
@@ -100,6 +192,56 @@ func (s *Server) Listen(ctx context.Context, prestoPort, grpcPort int32) error {
 		return err
 	}
 
+	// Asynchronously start the HTTP query listener (if configured)
+	if conf := s.conf().Readers.REST; conf != nil {
+		async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, s.listenREST(ctx, conf)
+		})
+	}
+
+	// Asynchronously start the HTTP ingestion listener (if configured)
+	if conf := s.conf().Writers.HTTP; conf != nil {
+		async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, s.listenIngest(ctx, conf)
+		})
+	}
+
+	// Asynchronously start the listeners of the log shippers (if configured)
+	if conf := s.conf().Writers.Syslog; conf != nil {
+		async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, s.listenSyslog(ctx, conf)
+		})
+	}
+
+	if conf := s.conf().Writers.Fluent; conf != nil {
+		async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, s.listenFluent(ctx, conf)
+		})
+	}
+
+	// Asynchronously start the administration listener (if configured)
+	if conf := s.conf().Admin; conf != nil {
+		async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, s.listenAdmin(ctx, conf)
+		})
+	}
+
+	// Asynchronously measure the age of the rows not flushed yet
+	async.Invoke(ctx, s.measureLag)
+
+	// Periodically measure the memory, shedding load above the limits (if configured)
+	if s.memory != nil {
+		async.Invoke(ctx, s.memory.Run)
+	}
+
+	// Asynchronously apply the changes of the config
+	async.Invoke(ctx, s.watchConfig)
+
+	// Asynchronously move the data to the new owners when the membership changes (if configured)
+	if s.conf().Cluster.Rebalance.Enabled {
+		async.Invoke(ctx, s.rebalanceOnChange)
+	}
+
 	// Asynchronously start the gRPC listener
 	async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
 		s.monitor.Info("server: listening for grpc on :%d...", grpcPort)
@@ -120,27 +262,114 @@ func (s *Server) Listen(ctx context.Context, prestoPort, grpcPort int32) error {
 	return presto.Serve(ctx, int32(prestoPort), &thriftlog.Service{
 		Service: s,
 		Monitor: s.monitor,
+	}, certs.ListenerConfig(s.prestoTLS, s.nodeTLS))
+}
+
+// peerTLS returns the TLS config used to connect to a listener of another node, presenting the certificate of
+// the node if there is one, or the one of the listener otherwise. It returns nil for plain text.
+func (s *Server) peerTLS(listener *certs.Reloader) *tls.Config {
+	switch {
+	case s.nodeTLS != nil:
+		return s.nodeTLS.ClientConfig()
+	case listener != nil:
+		return listener.ClientConfig()
+	default:
+		return nil
+	}
+}
+
+// mustLoad loads the certificate of a listener, or panics if it can not be loaded
+func mustLoad(conf *config.TLS) *certs.Reloader {
+	r, err := certs.New(conf)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Register adds the tables to the registry of the server.
+func (s *Server) Register(tables ...table.Table) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, table := range tables {
+		s.monitor.Log(logging.LevelInfo, "server: registered table", logging.F("table", table.Name()))
+		s.tables[table.Name()] = table
+	}
+}
+
+// Tables returns the registered tables, sorted by name.
+func (s *Server) Tables() []table.Table {
+	s.lock.RLock()
+	tables := make([]table.Table, 0, len(s.tables))
+	for _, table := range s.tables {
+		tables = append(tables, table)
+	}
+	s.lock.RUnlock()
+
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name() < tables[j].Name()
 	})
+	return tables
+}
+
+// SchemaOf splits the registered name of a table into its schema and table names. Tables which are
+// not qualified with a schema belong to the schema configured for presto.
+func (s *Server) SchemaOf(name string) (schema, table string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return s.conf().Readers.Presto.Schema, name
+}
+
+// SlowQueries returns the recent slow requests, if the slow query log is enabled.
+func (s *Server) SlowQueries() []slowlog.Entry {
+	if s.slowlog == nil {
+		return nil
+	}
+
+	return s.slowlog.Entries()
+}
+
+// qualify returns the registered name of a table within a schema.
+func (s *Server) qualify(schema, table string) string {
+	if schema == "" || schema == s.conf().Readers.Presto.Schema {
+		return table
+	}
+
+	return schema + "." + table
+}
+
+// SetS3SQS sets the ingestion of the files notified through SQS, started once listening, instead of the one
+// of the config, such as to ingest from a fake queue in tests.
+func (s *Server) SetS3SQS(ingress *s3sqs.Ingress) {
+	s.s3sqs = ingress
 }
 
 // Optionally starts an S3 SQS ingress
 func (s *Server) pollFromSQS(conf *config.Config) (err error) {
-	if conf.Writers.S3SQS == nil {
+	switch {
+	case s.s3sqs != nil: // Set beforehand
+	case conf.Writers.S3SQS == nil:
 		return nil
+	default: // Create a new ingestor
+		s.s3sqs, err = s3sqs.New(conf.Writers.S3SQS, conf.Writers.S3SQS.Region, s.monitor)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Create a new ingestor
-	s.s3sqs, err = s3sqs.New(conf.Writers.S3SQS, conf.Writers.S3SQS.Region, s.monitor)
-	if err != nil {
-		return err
+	// Start ingesting, pausing while the tables do not flush their data fast enough or the memory is low
+	s.s3sqs.SetBuffered(s.buffered)
+	if s.memory != nil {
+		s.memory.Track("ingestion", s.s3sqs.InFlight)
+		s.s3sqs.SetPaused(s.memory.Paused)
 	}
-
-	// Start ingesting
 	s.monitor.Info("server: starting ingestion from S3/SQS...")
 	s.s3sqs.Range(func(v []byte) bool {
-		if _, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		if _, _, _, err := s.ingest(context.Background(), &talaria.IngestRequest{
 			Data: &talaria.IngestRequest_Orc{Orc: v},
-		}); err != nil {
+		}, s.settings(), nil); err != nil {
 			s.monitor.Warning(err)
 		}
 		return false
@@ -148,22 +377,36 @@ func (s *Server) pollFromSQS(conf *config.Config) (err error) {
 	return nil
 }
 
-// Close closes the server and related resources.
+// Close drains and closes the server and related resources. It stops accepting gRPC requests while
+// completing the in-flight ones, waits for the S3/SQS ingestion in progress and finally closes the tables,
+// which flushes the data which was not compacted yet to the sinks.
 func (s *Server) Close() {
+	s.monitor.Info("server: draining...")
 	s.server.GracefulStop()
-	s.cancel()
 
-	// Stop S3/SQS ingress
+	// Stop S3/SQS ingress, once the files being ingested are done
 	if s.s3sqs != nil {
 		s.s3sqs.Close()
 	}
 
-	// Close all the open tables
-	for _, t := range s.tables {
+	// Stop serving queries
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	// Close the connections to the other nodes
+	s.peers.Range(func(_, v interface{}) bool {
+		_ = v.(interface{ Close() error }).Close()
+		return true
+	})
+
+	// Close all the open tables, flushing them
+	for _, t := range s.Tables() {
 		if err := t.Close(); err != nil {
 			s.monitor.Error(err)
 		}
 	}
+	s.monitor.Info("server: drained")
 }
 
 // ------------------------------------------------------------------------------------------------------------
@@ -0,0 +1,204 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/server/acl"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const queryDeniedKey = "query.denied"
+
+// Bind implements presto.Binder, so that the thrift requests of a connection are authorized on behalf of
+// the client which presented its certificate.
+func (s *Server) Bind(state *tls.ConnectionState) presto.PrestoThriftService {
+	return &session{Server: s, identity: s.identityOf(state)}
+}
+
+// identityOf returns the identity of a client from the certificate it presented, if any
+func (s *Server) identityOf(state *tls.ConnectionState) *auth.Identity {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	// The other nodes of the cluster, such as when proxying a split, are not restricted
+	if s.nodeTLS != nil && s.nodeTLS.Issued(state.PeerCertificates) {
+		return &auth.Identity{Name: state.PeerCertificates[0].Subject.CommonName, Internal: true}
+	}
+
+	return &auth.Identity{Name: state.PeerCertificates[0].Subject.CommonName}
+}
+
+// queryIdentity returns the identity of the client of a gRPC query, either from the certificate it presented
+// or from its bearer token.
+func (s *Server) queryIdentity(ctx context.Context) *auth.Identity {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if identity := s.identityOf(&info.State); identity != nil {
+				return identity
+			}
+		}
+	}
+
+	settings := s.settings()
+	if settings.auth == nil {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(authMetadataKey); len(values) > 0 {
+		identity, _ := settings.auth.Authenticate(strings.TrimPrefix(values[0], "Bearer "))
+		return identity
+	}
+	return nil
+}
+
+// authorize checks whether the client can query the columns of the table, reporting the denied attempts and
+// recording them in the audit trail. The clients of a tenant can only query the tables of its namespace.
+func (s *Server) authorize(identity *auth.Identity, table string, columns []string) error {
+	list, name := s.restrictionOf(identity)
+	err := list.Check(name, table, columns)
+	if err == nil && isolated(identity, table) {
+		err = fmt.Errorf("'%s' of tenant %s can not query table %s", name, identity.Tenant, table)
+	}
+
+	if err != nil {
+		s.monitor.Count1(ctxTag, queryDeniedKey, "table:"+table)
+		s.monitor.Log(logging.LevelWarning, "server: query denied", logging.Event(queryDeniedKey),
+			logging.F("identity", name), logging.F("table", table), logging.F("columns", columns))
+		s.record(audit.Entry{
+			Identity: name,
+			Action:   queryDeniedKey,
+			Params:   map[string]interface{}{"table": table, "columns": columns},
+			Error:    err.Error(),
+		})
+		return errors.PermissionDenied(err.Error())
+	}
+	return nil
+}
+
+// canList checks whether the client can see the table, when listing the tables
+func (s *Server) canList(identity *auth.Identity, table string) bool {
+	list, name := s.restrictionOf(identity)
+	return list.CanQuery(name, table) && !isolated(identity, table)
+}
+
+// canSelect checks whether the client can see the column of the table, when describing the table
+func (s *Server) canSelect(identity *auth.Identity, table, column string) bool {
+	list, name := s.restrictionOf(identity)
+	return list.CanSelect(name, table, column) && !isolated(identity, table)
+}
+
+// restrictionOf returns the access control list restricting the client along with its name, or nil if the
+// client is not restricted.
+func (s *Server) restrictionOf(identity *auth.Identity) (*acl.ACL, string) {
+	list := s.settings().acl
+	switch {
+	case list == nil || (identity != nil && identity.Internal):
+		return nil, ""
+	case identity == nil:
+		return list, ""
+	default:
+		return list, identity.Name
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// session serves the thrift requests of a connection on behalf of its client
+type session struct {
+	*Server
+	identity *auth.Identity
+}
+
+// PrestoGetIndexSplits returns a batch of index splits for the given batch of keys.
+func (s *session) PrestoGetIndexSplits(schemaTableName *presto.PrestoThriftSchemaTableName, indexColumnNames []string, outputColumnNames []string, keys *presto.PrestoThriftPageResult, outputConstraint *presto.PrestoThriftTupleDomain, maxSplitCount int32, nextToken *presto.PrestoThriftNullableToken) (*presto.PrestoThriftSplitBatch, error) {
+	columns := append(append([]string{}, indexColumnNames...), outputColumnNames...)
+	if err := s.authorize(s.identity, s.qualify(schemaTableName.SchemaName, schemaTableName.TableName), columns); err != nil {
+		return nil, err
+	}
+
+	return s.Server.PrestoGetIndexSplits(schemaTableName, indexColumnNames, outputColumnNames, keys, outputConstraint, maxSplitCount, nextToken)
+}
+
+// PrestoGetSplits returns a batch of splits.
+func (s *session) PrestoGetSplits(schemaTableName *presto.PrestoThriftSchemaTableName, desiredColumns *presto.PrestoThriftNullableColumnSet, outputConstraint *presto.PrestoThriftTupleDomain, maxSplitCount int32, nextToken *presto.PrestoThriftNullableToken) (*presto.PrestoThriftSplitBatch, error) {
+	var columns []string
+	if desiredColumns != nil {
+		for column := range desiredColumns.Columns {
+			columns = append(columns, column)
+		}
+	}
+
+	if err := s.authorize(s.identity, s.qualify(schemaTableName.SchemaName, schemaTableName.TableName), columns); err != nil {
+		return nil, err
+	}
+
+	return s.Server.PrestoGetSplits(schemaTableName, desiredColumns, outputConstraint, maxSplitCount, nextToken)
+}
+
+// PrestoGetTableMetadata returns metadata for a given table, without the columns the client can not query.
+func (s *session) PrestoGetTableMetadata(schemaTableName *presto.PrestoThriftSchemaTableName) (*presto.PrestoThriftNullableTableMetadata, error) {
+	table := s.qualify(schemaTableName.SchemaName, schemaTableName.TableName)
+	if err := s.authorize(s.identity, table, nil); err != nil {
+		return nil, err
+	}
+
+	meta, err := s.Server.PrestoGetTableMetadata(schemaTableName)
+	if err != nil || meta.TableMetadata == nil {
+		return meta, err
+	}
+
+	columns := meta.TableMetadata.Columns[:0]
+	for _, c := range meta.TableMetadata.Columns {
+		if s.canSelect(s.identity, table, c.Name) {
+			columns = append(columns, c)
+		}
+	}
+
+	meta.TableMetadata.Columns = columns
+	return meta, nil
+}
+
+// PrestoListTables returns the tables the client can query, for the given schema name.
+func (s *session) PrestoListTables(schemaNameOrNull *presto.PrestoThriftNullableSchemaName) ([]*presto.PrestoThriftSchemaTableName, error) {
+	tables, err := s.Server.PrestoListTables(schemaNameOrNull)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := tables[:0]
+	for _, t := range tables {
+		if s.canList(s.identity, s.qualify(t.SchemaName, t.TableName)) {
+			allowed = append(allowed, t)
+		}
+	}
+	return allowed, nil
+}
+
+// PrestoGetRows returns a batch of rows for the given split.
+func (s *session) PrestoGetRows(splitID *presto.PrestoThriftId, columns []string, maxBytes int64, nextToken *presto.PrestoThriftNullableToken) (*presto.PrestoThriftPageResult, error) {
+	id, err := decodeThriftID(splitID, nextToken)
+	if err != nil {
+		return nil, errors.Internal("decoding query failed", err)
+	}
+
+	if err := s.authorize(s.identity, id.Table, columns); err != nil {
+		return nil, err
+	}
+
+	return s.Server.PrestoGetRows(splitID, columns, maxBytes, nextToken)
+}
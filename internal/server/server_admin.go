@@ -0,0 +1,412 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/certs"
+	"github.com/kelindar/talaria/internal/server/cluster"
+	"github.com/kelindar/talaria/internal/table"
+)
+
+const (
+	adminTimeout = 10 * time.Second
+	meterWindow  = 60 // The window of the ingestion rate, in seconds
+)
+
+// SetMembership sets the membership of the cluster, which the administration API uses to list the nodes.
+func (s *Server) SetMembership(cluster Membership) {
+	s.cluster = cluster
+}
+
+// OnDrain sets the function which drains this node and leaves the cluster, when requested through the
+// administration API.
+func (s *Server) OnDrain(drain func()) {
+	s.drain = drain
+}
+
+// listenAdmin starts the administration HTTP listener and blocks until the context is cancelled.
+func (s *Server) listenAdmin(ctx context.Context, conf *config.Admin) error {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/admin/node", s.admin(s.handleNode)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/nodes", s.admin(s.handleNodes)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/flush", s.admin(s.audited("flush", s.handleFlush))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/gc", s.admin(s.audited("gc", s.handleGC))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/drain", s.admin(s.audited("drain", s.handleDrain))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/rebalance", s.admin(s.audited("rebalance", s.handleRebalance))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/replay", s.admin(s.audited("replay", s.handleReplay))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/delete", s.admin(s.audited("delete", s.handleDelete))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/validate", s.admin(s.handleValidate)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/tombstones", s.admin(s.handleTombstones)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/schemas", s.admin(s.handleSchemas)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/schemas/{name}", s.admin(s.handleSchema)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/tables", s.admin(s.handleTables)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/tables", s.admin(s.audited("table.create", s.handleCreate))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/tables/{name}", s.admin(s.audited("table.alter", s.handleAlter))).Methods(http.MethodPatch)
+	router.HandleFunc("/v1/admin/tables/{name}/truncate", s.admin(s.audited("table.truncate", s.handleTruncate))).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/tables/{name}", s.admin(s.audited("table.drop", s.handleDrop))).Methods(http.MethodDelete)
+
+	s.monitor.Info("server: listening for admin http on :%d...", conf.Port)
+	return serveHTTP(ctx, conf.Port, router, certs.ListenerConfig(nil, s.nodeTLS))
+}
+
+// admin wraps an administration handler, which always requires the bearer token. The requests without a
+// valid token are recorded in the audit trail.
+func (s *Server) admin(handle func(http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer s.handlePanic()
+		defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:admin")
+
+		conf := s.conf().Admin
+		if conf == nil || conf.Token == "" || !authorized(r, conf.Token) {
+			err := errors.Unauthenticated("a valid bearer token is required")
+			s.record(audit.Entry{
+				Identity: adminIdentity(r),
+				Address:  r.RemoteAddr,
+				Action:   "admin.denied",
+				Params:   map[string]interface{}{"method": r.Method, "path": r.URL.Path},
+				Error:    err.Error(),
+			})
+			writeError(w, err)
+			return
+		}
+
+		if err := handle(w, r); err != nil {
+			s.monitor.Warning(err)
+			writeError(w, err)
+		}
+	}
+}
+
+// handleNode returns the status of this node
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, s.nodeStatus())
+}
+
+// handleNodes returns the status of every node of the cluster, by asking each of them
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) error {
+	if s.cluster == nil {
+		return writeJSON(w, http.StatusOK, []nodeStatus{s.nodeStatus()})
+	}
+
+	members := s.cluster.Members()
+	out := make([]nodeStatus, len(members))
+	var wg sync.WaitGroup
+	for i, addr := range members {
+		if addr == s.self() {
+			out[i] = s.nodeStatus()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			out[i] = s.peerStatus(r.Context(), addr)
+		}(i, addr)
+	}
+
+	wg.Wait()
+	return writeJSON(w, http.StatusOK, out)
+}
+
+// handleFlush compacts the data buffered by one or all of the tables to their sinks
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) error {
+	tables := s.Tables()
+	if name := r.URL.Query().Get("table"); name != "" {
+		t, err := s.getTable(name)
+		if err != nil {
+			return errors.NotFound(err.Error())
+		}
+		tables = []table.Table{t}
+	}
+
+	flushed := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if f, ok := t.(flusher); ok {
+			if err := f.Flush(r.Context()); err != nil {
+				return errors.Internal("unable to flush "+t.Name(), err)
+			}
+			flushed = append(flushed, t.Name())
+		}
+	}
+
+	return writeJSON(w, http.StatusOK, map[string][]string{
+		"flushed": flushed,
+	})
+}
+
+// handleGC deletes the expired data of one or all of the tables and reclaims the space on disk, reporting the size
+// of each table before and after
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) error {
+	tables := s.Tables()
+	if name := r.URL.Query().Get("table"); name != "" {
+		t, err := s.getTable(name)
+		if err != nil {
+			return errors.NotFound(err.Error())
+		}
+		tables = []table.Table{t}
+	}
+
+	collected := make([]collectedTable, 0, len(tables))
+	for _, t := range tables {
+		c, ok := t.(collector)
+		if !ok {
+			continue
+		}
+
+		before := sizeOf(t)
+		if err := c.GC(r.Context()); err != nil {
+			return errors.Internal("unable to collect the garbage of "+t.Name(), err)
+		}
+
+		collected = append(collected, collectedTable{
+			Name:   t.Name(),
+			Before: before,
+			After:  sizeOf(t),
+		})
+	}
+
+	return writeJSON(w, http.StatusOK, map[string][]collectedTable{
+		"collected": collected,
+	})
+}
+
+// handleDrain drains this node in the background, the same way as on SIGTERM
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) error {
+	if s.drain == nil {
+		return errors.Unimplemented("draining is not supported by this node")
+	}
+
+	s.monitor.Info("server: drain requested by %s", r.RemoteAddr)
+	go s.drain()
+	return writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "draining",
+	})
+}
+
+// handleRebalance moves the data this node does not store anymore to the new owners, in the background
+func (s *Server) handleRebalance(w http.ResponseWriter, r *http.Request) error {
+	if s.ring == nil {
+		return errors.Unimplemented("the cluster does not partition the data")
+	}
+
+	s.monitor.Info("server: rebalance requested by %s", r.RemoteAddr)
+	go func() {
+		if _, err := s.Rebalance(context.Background()); err != nil {
+			s.monitor.Warning(errors.Internal("server: unable to rebalance", err))
+		}
+	}()
+
+	return writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "rebalancing",
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// flusher represents a table which can flush its buffered data on demand
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// collector represents a table which can reclaim the space of its expired data on demand
+type collector interface {
+	GC(ctx context.Context) error
+}
+
+// collectedTable represents the size of a table on disk before and after its garbage collection, in bytes
+type collectedTable struct {
+	Name   string `json:"name"`
+	Before int64  `json:"before"`
+	After  int64  `json:"after"`
+}
+
+// nodeStatus represents the status of a node, as returned by the administration API
+type nodeStatus struct {
+	Address string          `json:"address"`
+	Zone    string          `json:"zone,omitempty"`
+	Config  string          `json:"config,omitempty"` // The version of the config in use
+	Ranges  []cluster.Range `json:"ranges,omitempty"`
+	Tables  []tableStatus   `json:"tables,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// tableStatus represents the status of a table on a node
+type tableStatus struct {
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`               // The size of the data on disk, in bytes
+	IngestRate float64 `json:"ingestRate"`         // The ingested bytes per second, over the last minute
+	FlushLag   float64 `json:"flushLag,omitempty"` // The seconds since the last compaction, if compacted
+	Unflushed  float64 `json:"unflushed"`          // The age in seconds of the oldest row not flushed yet
+}
+
+// nodeStatus returns the status of this node
+func (s *Server) nodeStatus() nodeStatus {
+	status := nodeStatus{Address: s.self(), Config: s.settings().version}
+	if zones, ok := s.cluster.(interface{ ZoneOf(string) string }); ok {
+		status.Zone = zones.ZoneOf(status.Address)
+	}
+
+	if ring, ok := s.ring.(interface{ Ranges(string) []cluster.Range }); ok {
+		status.Ranges = ring.Ranges(status.Address)
+	}
+
+	for _, t := range s.Tables() {
+		if _, ok := t.(table.Appender); !ok {
+			continue
+		}
+
+		ts := tableStatus{Name: t.Name(), Size: sizeOf(t)}
+
+		if m, ok := s.meters.Load(t.Name()); ok {
+			ts.IngestRate = m.(*meter).Rate()
+		}
+
+		if f, ok := t.(interface{ Flushed() (time.Time, bool) }); ok {
+			if at, ok := f.Flushed(); ok {
+				ts.FlushLag = time.Since(at).Seconds()
+			}
+		}
+
+		if age, ok := unflushedAge(t); ok {
+			ts.Unflushed = age.Seconds()
+		}
+		status.Tables = append(status.Tables, ts)
+	}
+	return status
+}
+
+// peerStatus requests the status of another node, reporting the error if it can not be reached
+func (s *Server) peerStatus(ctx context.Context, addr string) nodeStatus {
+	status := nodeStatus{Address: addr}
+	if err := s.callPeer(ctx, http.MethodGet, addr, "/v1/admin/node", &status); err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// callPeer calls the administration API of another node and decodes its response into the output
+func (s *Server) callPeer(ctx context.Context, method, addr, path string, out interface{}) error {
+	conf := s.conf().Admin
+	if conf == nil {
+		return fmt.Errorf("the admin API is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, adminTimeout)
+	defer cancel()
+
+	// The admin API is served over TLS when the nodes have a certificate
+	scheme, client := "http", http.DefaultClient
+	if s.nodeTLS != nil {
+		scheme, client = "https", &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   s.peerTLS(nil),
+			DisableKeepAlives: true,
+		}}
+	}
+
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(addr, fmt.Sprint(conf.Port)), path)
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+conf.Token)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// self returns the address of this node, as it appears in the membership
+func (s *Server) self() string {
+	if s.cluster == nil {
+		return ""
+	}
+
+	addr := s.cluster.Addr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// measure records the bytes ingested by a table
+func (s *Server) measure(table string, bytes int64) {
+	m, ok := s.meters.Load(table)
+	if !ok {
+		m, _ = s.meters.LoadOrStore(table, new(meter))
+	}
+	m.(*meter).Add(bytes)
+}
+
+// sizeOf returns the size of the data stored by a table on this node, in bytes, if the table reports it
+func sizeOf(t table.Table) int64 {
+	if sizer, ok := t.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return 0
+}
+
+// writeJSON writes out the value as JSON, with the status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// meter measures a rate over a sliding window of one-second buckets
+type meter struct {
+	sync.Mutex
+	counts [meterWindow]int64 // The count for each second
+	stamps [meterWindow]int64 // The second of each bucket, in unix seconds
+}
+
+// Add adds to the count of the current second
+func (m *meter) Add(n int64) {
+	now := time.Now().Unix()
+	i := now % meterWindow
+
+	m.Lock()
+	defer m.Unlock()
+	if m.stamps[i] != now {
+		m.stamps[i] = now
+		m.counts[i] = 0
+	}
+	m.counts[i] += n
+}
+
+// Rate returns the average count per second over the window
+func (m *meter) Rate() float64 {
+	now := time.Now().Unix()
+
+	m.Lock()
+	defer m.Unlock()
+	var total int64
+	for i, stamp := range m.stamps {
+		if now-stamp < meterWindow {
+			total += m.counts[i]
+		}
+	}
+	return float64(total) / meterWindow
+}
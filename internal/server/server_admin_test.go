@@ -0,0 +1,216 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// flushTable is a table which records the flushes
+type flushTable struct {
+	appendTable
+	flushes     int
+	collections int
+	unsupported bool
+}
+
+func (t *flushTable) Size() int64                     { return 1024 }
+func (t *flushTable) Flush(ctx context.Context) error { t.flushes++; return nil }
+func (t *flushTable) GC(ctx context.Context) error {
+	if t.unsupported {
+		return errors.Unimplemented("the storage does not support garbage collection")
+	}
+	t.collections++
+	return nil
+}
+func (t *flushTable) Flushed() (time.Time, bool)   { return time.Now().Add(-time.Minute), true }
+func (t *flushTable) Unflushed() (time.Time, bool) { return time.Now().Add(-time.Hour), true }
+
+// adminMembership is a membership of two nodes, the local one being the second
+type adminMembership []string
+
+func (m adminMembership) Members() []string { return m }
+func (m adminMembership) Addr() string      { return m[1] + ":7946" }
+
+func TestAdmin(t *testing.T) {
+	events := &flushTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Admin: &config.Admin{Token: "secret"},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	s.measure("events", 600)
+
+	// Serve the remote node with the same server, on the admin port
+	remote := httptest.NewServer(s.admin(s.handleNode))
+	defer remote.Close()
+	u, _ := url.Parse(remote.URL)
+	port, _ := strconv.Atoi(u.Port())
+	conf.Admin.Port = int32(port)
+	s.SetMembership(adminMembership{"127.0.0.1", "127.0.0.2"})
+
+	call := func(method, url, token string, handle func(http.ResponseWriter, *http.Request) error) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.admin(handle)(w, r)
+		return w
+	}
+
+	// The token is required
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/v1/admin/node", "wrong", s.handleNode).Code)
+
+	// The status of the local node
+	w := call(http.MethodGet, "/v1/admin/node", "secret", s.handleNode)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var node nodeStatus
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&node))
+	assert.Equal(t, "127.0.0.2", node.Address)
+	assert.Len(t, node.Tables, 1)
+	assert.Equal(t, "events", node.Tables[0].Name)
+	assert.Equal(t, int64(1024), node.Tables[0].Size)
+	assert.Equal(t, float64(10), node.Tables[0].IngestRate)
+	assert.InDelta(t, 60, node.Tables[0].FlushLag, 5)
+	assert.InDelta(t, 3600, node.Tables[0].Unflushed, 5)
+
+	// The status of every node, the first one being requested over HTTP
+	w = call(http.MethodGet, "/v1/admin/nodes", "secret", s.handleNodes)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var all []nodeStatus
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&all))
+	assert.Len(t, all, 2)
+	assert.Empty(t, all[0].Error)
+	assert.Len(t, all[0].Tables, 1)
+	assert.Equal(t, "127.0.0.2", all[1].Address)
+
+	// Flush a table
+	w = call(http.MethodPost, "/v1/admin/flush?table=events", "secret", s.handleFlush)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"flushed\":[\"events\"]}\n", w.Body.String())
+	assert.Equal(t, 1, events.flushes)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, "/v1/admin/flush?table=xxx", "secret", s.handleFlush).Code)
+
+	// Collect the garbage of every table
+	w = call(http.MethodPost, "/v1/admin/gc", "secret", s.handleGC)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"collected\":[{\"name\":\"events\",\"before\":1024,\"after\":1024}]}\n", w.Body.String())
+	assert.Equal(t, 1, events.collections)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, "/v1/admin/gc?table=xxx", "secret", s.handleGC).Code)
+
+	// The tables which can not collect their garbage are reported
+	events.unsupported = true
+	w = call(http.MethodPost, "/v1/admin/gc", "secret", s.handleGC)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Contains(t, w.Body.String(), "does not support garbage collection")
+	events.unsupported = false
+
+	// Drain the node
+	assert.Equal(t, http.StatusNotImplemented, call(http.MethodPost, "/v1/admin/drain", "secret", s.handleDrain).Code)
+	drained := make(chan struct{})
+	s.OnDrain(func() { close(drained) })
+	assert.Equal(t, http.StatusAccepted, call(http.MethodPost, "/v1/admin/drain", "secret", s.handleDrain).Code)
+	<-drained
+}
+
+func TestAdmin_NoToken(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Admin: &config.Admin{},
+	})
+
+	// Without a token configured, the admin API is not accessible
+	r := httptest.NewRequest(http.MethodGet, "/v1/admin/node", nil)
+	w := httptest.NewRecorder()
+	s.admin(s.handleNode)(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	trail, err := audit.New("node-1", path, nil, monitor.NewNoop())
+	assert.NoError(t, err)
+
+	conf := &config.Config{
+		Admin:   &config.Admin{Token: "secret"},
+		Readers: config.Readers{ACL: []config.Access{{Identity: "analyst", Tables: []string{"events"}}}},
+		Version: "1",
+	}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil))
+	s.SetAudit(trail)
+
+	// The handler still reads the body, which is recorded along with the parameters
+	var body string
+	handle := s.admin(s.audited("table.create", func(w http.ResponseWriter, r *http.Request) error {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		return writeJSON(w, http.StatusCreated, nil)
+	}))
+
+	for _, token := range []string{"wrong", "secret"} {
+		r := httptest.NewRequest(http.MethodPost, "/v1/admin/tables?dry=1", strings.NewReader(`{"name":"orders"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		handle(httptest.NewRecorder(), r)
+	}
+	assert.Equal(t, `{"name":"orders"}`, body)
+
+	// The config reloads and the denied queries are recorded as well
+	s.reconfigure(&config.Config{Version: "2", Readers: conf.Readers})
+	assert.Error(t, s.authorize(&auth.Identity{Name: "analyst"}, "nodes", nil))
+	assert.NoError(t, trail.Close())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 4)
+
+	entries := make([]audit.Entry, len(lines))
+	for i, line := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(line), &entries[i]))
+	}
+
+	assert.Equal(t, "admin.denied", entries[0].Action)
+	assert.Equal(t, "table.create", entries[1].Action)
+	assert.Equal(t, "admin", entries[1].Identity)
+	assert.Equal(t, []interface{}{"1"}, entries[1].Params["dry"])
+	assert.Equal(t, map[string]interface{}{"name": "orders"}, entries[1].Params["body"])
+	assert.Equal(t, "config.reload", entries[2].Action)
+	assert.Equal(t, "2", entries[2].Params["version"])
+	assert.Equal(t, "query.denied", entries[3].Action)
+	assert.Equal(t, "analyst", entries[3].Identity)
+	assert.NotEmpty(t, entries[3].Error)
+}
+
+func TestMeter(t *testing.T) {
+	m := new(meter)
+	assert.Equal(t, float64(0), m.Rate())
+
+	m.Add(30)
+	m.Add(30)
+	assert.Equal(t, float64(1), m.Rate())
+}
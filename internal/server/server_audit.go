@@ -0,0 +1,88 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/server/audit"
+)
+
+const (
+	maxAuditBody   = 64 * 1024 // The maximum size of a request body recorded in the audit trail
+	systemIdentity = "system"  // The identity of the operations which are not requested by a client
+)
+
+// SetAudit sets the audit trail, recording the administrative operations and the denied queries.
+func (s *Server) SetAudit(trail *audit.Trail) {
+	s.audit = trail
+}
+
+// audited wraps an administration handler, recording the operation along with its parameters in the audit
+// trail once handled.
+func (s *Server) audited(action string, handle func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if s.audit == nil {
+			return handle(w, r)
+		}
+
+		params := make(map[string]interface{})
+		for k, v := range r.URL.Query() {
+			params[k] = v
+		}
+
+		for k, v := range mux.Vars(r) {
+			params[k] = v
+		}
+
+		// Keep the body, which is the definition of the table when creating or altering one
+		if r.Body != nil {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if len(body) > 0 && len(body) <= maxAuditBody && json.Valid(body) {
+				params["body"] = json.RawMessage(body)
+			}
+		}
+
+		err := handle(w, r)
+		s.record(audit.Entry{
+			Identity: adminIdentity(r),
+			Address:  r.RemoteAddr,
+			Action:   action,
+			Params:   params,
+			Error:    errorOf(err),
+		})
+		return err
+	}
+}
+
+// record records an entry in the audit trail, if any
+func (s *Server) record(entry audit.Entry) {
+	s.audit.Record(entry)
+}
+
+// adminIdentity returns the identity of the client of the administration API, which is the common name of its
+// certificate if it presented one, since the bearer token is shared.
+func adminIdentity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "admin"
+}
+
+// errorOf returns the message of the error, if any
+func errorOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
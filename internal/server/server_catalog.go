@@ -0,0 +1,182 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/catalog"
+	"github.com/kelindar/talaria/internal/storage"
+	"github.com/kelindar/talaria/internal/table"
+)
+
+// SetCatalog sets the catalog of the tables created at runtime. The tables of the catalog are opened right
+// away, and its changes are applied as they are made on this node or replicated from the other nodes. The
+// opener must be set beforehand.
+func (s *Server) SetCatalog(c *catalog.Catalog) {
+	s.catalog = c
+	c.OnChange(s.applyDefinition)
+	for _, def := range c.Tables() {
+		s.applyDefinition(def)
+	}
+}
+
+// applyDefinition opens, alters or drops a table of the catalog on this node
+func (s *Server) applyDefinition(def catalog.Definition) {
+	t, err := s.getTable(def.Name)
+	switch {
+	case def.Dropped && err == nil:
+		s.dropTable(t)
+	case def.Dropped:
+		return
+	case err != nil:
+		s.openTable(def.Name, s.withDefaults(def.Table))
+	default:
+		if a, ok := t.(interface{ Alter(typeof.Schema) }); ok {
+			if schema, static := def.Schema(); static {
+				a.Alter(schema)
+			}
+		}
+
+		if r, ok := t.(interface{ SetTTL(time.Duration) }); ok {
+			r.SetTTL(time.Duration(s.withDefaults(def.Table).TTL) * time.Second)
+		}
+	}
+
+	// Reload the pipelines, as the tables changed
+	s.dynamic.Store(s.loadSettings(s.conf()))
+}
+
+// withDefaults completes the settings of a table created at runtime with the default settings of the config
+func (s *Server) withDefaults(t config.Table) config.Table {
+	merged, err := config.WithDefaults(s.conf().Defaults, t)
+	if err != nil {
+		s.monitor.Error(errors.Internal("server: unable to apply the default table settings", err))
+		return t
+	}
+	return merged
+}
+
+// dropTable unregisters a table and deletes its data
+func (s *Server) dropTable(t table.Table) {
+	s.lock.Lock()
+	delete(s.tables, t.Name())
+	s.lock.Unlock()
+
+	s.monitor.Log(logging.LevelInfo, "server: dropped table", logging.F("table", t.Name()))
+	err := storage.Drop(t)
+	if err != nil {
+		s.monitor.Error(errors.Internal("server: unable to drop "+t.Name(), err))
+	}
+
+	// A table created again with the same name starts with a new schema
+	if s.registry != nil {
+		if err := s.registry.Forget(t.Name()); err != nil {
+			s.monitor.Warning(err)
+		}
+	}
+
+	// Every node records the deletion of its own data, whichever node the table was dropped through
+	s.record(audit.Entry{
+		Identity: systemIdentity,
+		Action:   "data.delete",
+		Params:   map[string]interface{}{"table": t.Name()},
+		Error:    errorOf(err),
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// tableRequest represents a request to create a table, or to add columns to it
+type tableRequest struct {
+	config.Table
+	Name    string        `json:"name"`
+	Columns typeof.Schema `json:"columns,omitempty"` // The static schema of the table, or the columns to add
+}
+
+// handleTables lists the tables created at runtime
+func (s *Server) handleTables(w http.ResponseWriter, r *http.Request) error {
+	if s.catalog == nil {
+		return errors.Unimplemented("the catalog is not enabled on this node")
+	}
+
+	return writeJSON(w, http.StatusOK, s.catalog.Tables())
+}
+
+// handleCreate creates a table on every node of the cluster
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) error {
+	req, err := s.readTableRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.getTable(req.Name); err == nil {
+		return errors.AlreadyExists("table " + req.Name + " already exists")
+	}
+
+	def, err := s.catalog.Create(req.Name, req.Table, req.Columns)
+	if err != nil {
+		return err
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: table created", logging.F("table", def.Name), logging.F("by", r.RemoteAddr))
+	return writeJSON(w, http.StatusCreated, def)
+}
+
+// handleAlter adds columns to a table on every node of the cluster
+func (s *Server) handleAlter(w http.ResponseWriter, r *http.Request) error {
+	req, err := s.readTableRequest(r)
+	if err != nil {
+		return err
+	}
+
+	def, err := s.catalog.Alter(mux.Vars(r)["name"], req.Columns)
+	if err != nil {
+		return err
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: table altered", logging.F("table", def.Name), logging.F("by", r.RemoteAddr))
+	return writeJSON(w, http.StatusOK, def)
+}
+
+// handleDrop drops a table and its data on every node of the cluster
+func (s *Server) handleDrop(w http.ResponseWriter, r *http.Request) error {
+	if s.catalog == nil {
+		return errors.Unimplemented("the catalog is not enabled on this node")
+	}
+
+	name := mux.Vars(r)["name"]
+	if _, ok := s.conf().Tables[name]; ok {
+		return errors.InvalidArgument("table " + name + " is defined in the config")
+	}
+
+	def, err := s.catalog.Drop(name)
+	if err != nil {
+		return err
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: table dropped", logging.F("table", def.Name), logging.F("by", r.RemoteAddr))
+	return writeJSON(w, http.StatusOK, def)
+}
+
+// readTableRequest decodes the body of a request to create or alter a table
+func (s *Server) readTableRequest(r *http.Request) (*tableRequest, error) {
+	if s.catalog == nil {
+		return nil, errors.Unimplemented("the catalog is not enabled on this node")
+	}
+
+	req := new(tableRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, errors.InvalidArgument("unable to decode the request: " + err.Error())
+	}
+	return req, nil
+}
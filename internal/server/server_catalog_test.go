@@ -0,0 +1,92 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/catalog"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// alteredTable is a table created at runtime, which records the changes
+type alteredTable struct {
+	namedTable
+	schema  typeof.Schema
+	dropped bool
+}
+
+func (t *alteredTable) Alter(schema typeof.Schema) { t.schema = schema }
+func (t *alteredTable) Drop() error                { t.dropped = true; return nil }
+
+func TestCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{
+		Admin:  &config.Admin{Token: "secret"},
+		Tables: config.Tables{"events": {}},
+	}
+
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	opened := make(map[string]*alteredTable)
+	s.SetOpener(func(name string, conf config.Table) (table.Table, error) {
+		t := &alteredTable{namedTable: namedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}, name: name}}
+		opened[name] = t
+		return t, nil
+	})
+
+	tables, err := catalog.New(dir)
+	assert.NoError(t, err)
+	s.SetCatalog(tables)
+
+	call := func(method, url, body string, vars map[string]string, handle func(http.ResponseWriter, *http.Request) error) int {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.admin(handle)(w, mux.SetURLVars(r, vars))
+		return w.Code
+	}
+
+	// Create a table, which is opened right away
+	assert.Equal(t, http.StatusCreated, call(http.MethodPost, "/v1/admin/tables",
+		`{"name": "orders", "ttl": 60, "columns": {"id": "string"}}`, nil, s.handleCreate))
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/v1/admin/tables",
+		`{"name": "events"}`, nil, s.handleCreate))
+
+	_, err = s.getTable("orders")
+	assert.NoError(t, err)
+	assert.Contains(t, s.settings().pipeline, "orders")
+
+	// Add a column
+	vars := map[string]string{"name": "orders"}
+	assert.Equal(t, http.StatusOK, call(http.MethodPatch, "/v1/admin/tables/orders",
+		`{"columns": {"amount": "float64"}}`, vars, s.handleAlter))
+	assert.Equal(t, typeof.Schema{"id": typeof.String, "amount": typeof.Float64}, opened["orders"].schema)
+
+	// Drop the table, but not the ones of the config
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodDelete, "/v1/admin/tables/events", "",
+		map[string]string{"name": "events"}, s.handleDrop))
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/v1/admin/tables/orders", "", vars, s.handleDrop))
+	assert.True(t, opened["orders"].dropped)
+
+	_, err = s.getTable("orders")
+	assert.Error(t, err)
+	assert.Empty(t, tables.Tables())
+}
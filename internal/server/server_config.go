@@ -0,0 +1,227 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/ingress/pipeline"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/server/acl"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/kelindar/talaria/internal/server/cluster"
+	"github.com/kelindar/talaria/internal/table"
+	"golang.org/x/sync/semaphore"
+)
+
+const configInterval = 10 * time.Second
+
+// Opener represents a function which opens a table added to the config while the server is running.
+type Opener func(name string, conf config.Table) (table.Table, error)
+
+// settings represents the settings of the server which are replaced as a whole when the config changes, so
+// that an ingestion request never sees a mix of the old and the new ones.
+type settings struct {
+	version  string                         // The version of the config the settings were loaded from
+	computed []column.Computed              // The set of computed columns
+	pipeline map[string][]applyFunc         // The ingestion stages applied before computed columns, per table
+	broken   map[string]error               // The error loading the pipeline, per table which can not ingest
+	parsed   map[string]config.Timestamps   // The timestamp columns parsed by the pipeline, per table
+	strict   map[string]*config.Strict      // The enforcement of the schema at ingestion, per strict table
+	tenantBy map[string]string              // The column set to the tenant of the producer, per shared table
+	tenants  config.Tenants                 // The tenants sharing the cluster (optional)
+	limits   map[string]*semaphore.Weighted // The ingestion requests appended concurrently, per table (optional)
+	auth     *auth.Authenticator            // The authentication of the producers (optional)
+	acl      *acl.ACL                       // The tables and the columns each client can query (optional)
+}
+
+// SetOpener sets the function opening the tables added to the config at runtime. Without it, the new tables
+// are only opened once the node restarts.
+func (s *Server) SetOpener(open Opener) {
+	s.open = open
+}
+
+// settings returns the settings currently in use
+func (s *Server) settings() *settings {
+	return s.dynamic.Load().(*settings)
+}
+
+// loadSettings loads the computed columns and the ingestion pipelines of the tables from the config
+func (s *Server) loadSettings(conf *config.Config) *settings {
+	out := &settings{
+		version:  conf.Version,
+		pipeline: make(map[string][]applyFunc, len(conf.Tables)),
+		broken:   make(map[string]error),
+		parsed:   make(map[string]config.Timestamps),
+		strict:   make(map[string]*config.Strict),
+		tenantBy: make(map[string]string),
+		tenants:  conf.Tenants,
+		limits:   make(map[string]*semaphore.Weighted),
+		auth:     auth.New(conf.Writers.Auth),
+		acl:      acl.New(conf.Readers.ACL),
+	}
+
+	for _, c := range conf.Computed {
+		if len(c.Columns) > 0 {
+			col, err := column.NewMulti(c.Name, c.Columns, c.Func, s.loader)
+			if err != nil {
+				s.monitor.Error(err)
+				continue
+			}
+
+			s.monitor.Info("server: loaded computed columns %v from %v", c.Columns.Columns(), c.Name)
+			out.computed = append(out.computed, col)
+			continue
+		}
+
+		col, err := column.NewComputed(c.Name, c.Type, c.Func, s.loader)
+		if err != nil {
+			s.monitor.Error(err)
+			continue
+		}
+
+		s.monitor.Info("server: loaded computed column %v of type %v", c.Name, c.Type)
+		out.computed = append(out.computed, col)
+	}
+
+	for name, t := range conf.Tables {
+		out.loadTable(s, name, t)
+	}
+
+	// The tables created at runtime, unless the config also defines them
+	if s.catalog != nil {
+		for _, def := range s.catalog.Tables() {
+			if _, ok := out.pipeline[def.Name]; !ok {
+				out.loadTable(s, def.Name, s.withDefaults(def.Table))
+			}
+		}
+	}
+	return out
+}
+
+// loadTable loads the ingestion pipeline, the timestamp columns, the strict schema, the tenant column and the concurrency
+// limit of a table. A table whose filter or masks can not be loaded refuses to ingest, rather than ingesting the
+// rows as they are.
+func (out *settings) loadTable(s *Server, name string, t config.Table) {
+	stages, err := pipeline.New(name, t, s.monitor, s.loader)
+	if err != nil {
+		s.monitor.Error(err)
+		out.broken[name] = err
+	}
+
+	out.pipeline[name] = stages
+	if len(t.Timestamps) > 0 {
+		out.parsed[name] = t.Timestamps
+	}
+	if t.Strict != nil {
+		out.strict[name] = t.Strict
+	}
+	if t.TenantBy != "" {
+		out.tenantBy[name] = t.TenantBy
+	}
+	if t.Concurrency > 0 {
+		out.limits[name] = semaphore.NewWeighted(int64(t.Concurrency))
+	}
+}
+
+// rotateKeys replaces the keys encrypting the gossip
+func (s *Server) rotateKeys(gossip interface{ SetKeys([][]byte) error }, encoded []string) error {
+	keys, err := cluster.DecodeKeys(encoded)
+	if err != nil {
+		return err
+	}
+	return gossip.SetKeys(keys)
+}
+
+// watchConfig periodically applies the changes of the dynamic settings and reports the version in use
+func (s *Server) watchConfig(ctx context.Context) (interface{}, error) {
+	ticker := time.NewTicker(configInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+			s.reconfigure(s.conf())
+		}
+	}
+}
+
+// reconfigure applies a new version of the config to the computed columns, the ingestion pipelines, the
+// authentication and the quotas of the producers and the tenants, the access control of the queries, the gossip keys, the
+// retention of the tables and the list of tables. The settings which are only read at startup, such as the
+// ports or the sinks, require a restart.
+func (s *Server) reconfigure(conf *config.Config) {
+	defer s.reportVersion()
+	previous := s.settings().version
+	if conf.Version == previous {
+		return
+	}
+
+	// Swap the computed columns and the pipelines at once
+	s.dynamic.Store(s.loadSettings(conf))
+	s.quotas.Configure(conf.Writers.Quotas)
+	s.tenants.Configure(quotasOf(conf.Tenants))
+
+	// Apply the retention of the tables and open the new ones
+	for name, tableConf := range conf.Tables {
+		t, err := s.getTable(name)
+		if err != nil {
+			s.openTable(name, tableConf)
+			continue
+		}
+
+		if r, ok := t.(interface{ SetTTL(time.Duration) }); ok {
+			r.SetTTL(time.Duration(tableConf.TTL) * time.Second)
+		}
+	}
+
+	// Rotate the keys encrypting the gossip
+	if len(conf.Cluster.Keys) > 0 {
+		if gossip, ok := s.cluster.(interface{ SetKeys([][]byte) error }); ok {
+			if err := s.rotateKeys(gossip, conf.Cluster.Keys); err != nil {
+				s.monitor.Error(errors.Internal("server: unable to rotate the gossip keys", err))
+			}
+		}
+	}
+
+	s.record(audit.Entry{
+		Identity: systemIdentity,
+		Action:   "config.reload",
+		Params:   map[string]interface{}{"version": conf.Version, "previous": previous},
+	})
+
+	s.monitor.Count1(ctxTag, "config.reload")
+	s.monitor.Log(logging.LevelInfo, "server: applied a new config", logging.F("version", conf.Version))
+}
+
+// openTable opens and registers a table added to the config
+func (s *Server) openTable(name string, conf config.Table) {
+	if s.open == nil {
+		s.monitor.Log(logging.LevelWarning, "server: a new table requires a restart", logging.F("table", name))
+		return
+	}
+
+	t, err := s.open(name, conf)
+	if err != nil {
+		s.monitor.Error(err)
+		return
+	}
+
+	s.Register(t)
+	s.loadSchema(name)
+}
+
+// reportVersion reports the version of the config in use, as a number
+func (s *Server) reportVersion() {
+	if v, err := strconv.ParseUint(s.settings().version, 16, 32); err == nil {
+		s.monitor.Gauge(ctxTag, "config.version", float64(v))
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// retainedTable is a table whose retention can be changed
+type retainedTable struct {
+	appendTable
+	ttl time.Duration
+}
+
+func (t *retainedTable) SetTTL(ttl time.Duration) { t.ttl = ttl }
+
+// namedTable is a table opened at runtime
+type namedTable struct {
+	appendTable
+	name string
+}
+
+func (t *namedTable) Name() string { return t.name }
+
+func TestReconfigure(t *testing.T) {
+	current := &config.Config{
+		Version: "0000000a",
+		Tables:  config.Tables{"events": {TTL: 60}},
+	}
+
+	events := &retainedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	s := New(func() *config.Config { return current }, monitor.NewNoop(), script.NewLoader(nil), events)
+	assert.Equal(t, "0000000a", s.settings().version)
+
+	var opened []string
+	s.SetOpener(func(name string, conf config.Table) (table.Table, error) {
+		opened = append(opened, name)
+		return &namedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}, name: name}, nil
+	})
+
+	// Nothing is applied while the version is unchanged
+	s.reconfigure(current)
+	assert.Zero(t, events.ttl)
+
+	// A new version changes the retention, the pipelines and opens the new tables
+	current = &config.Config{
+		Version: "0000000b",
+		Tables: config.Tables{
+			"events": {TTL: 120},
+			"orders": {Sample: &config.Sample{Rate: 0.5}},
+		},
+	}
+
+	s.reconfigure(current)
+	assert.Equal(t, "0000000b", s.settings().version)
+	assert.Equal(t, 120*time.Second, events.ttl)
+	assert.Equal(t, []string{"orders"}, opened)
+	assert.Len(t, s.settings().pipeline["orders"], 1)
+
+	_, err := s.getTable("orders")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
+)
+
+// purger represents a table which can delete the rows matching a tombstone from its store
+type purger interface {
+	Purge(ctx context.Context, t tombstone.Tombstone) (int, error)
+}
+
+// SetTombstones sets the log of the deletions of the rows. The tombstones are applied to the tables of this
+// node as they are added on this node or replicated from the other nodes, and the ones already in the log are
+// applied again in the background, in case the node stopped while applying them.
+func (s *Server) SetTombstones(l *tombstone.Log) {
+	s.tombstones = l
+	l.OnChange(func(t tombstone.Tombstone) {
+		go s.purge(t)
+	})
+
+	go func() {
+		for _, t := range l.All() {
+			s.purge(t)
+		}
+	}()
+}
+
+// purge deletes the rows matching a tombstone from the table on this node
+func (s *Server) purge(t tombstone.Tombstone) {
+	table, err := s.getTable(t.Table)
+	if err != nil {
+		return // The table is not opened on this node
+	}
+
+	p, ok := table.(purger)
+	if !ok {
+		return
+	}
+
+	rows, err := p.Purge(context.Background(), t)
+	if err != nil {
+		s.monitor.Warning(errors.Internal("server: unable to delete the rows of "+t.Table, err))
+	}
+
+	// Every node records the deletion of its own rows, whichever node the deletion was requested through
+	s.monitor.Log(logging.LevelInfo, "server: deleted rows", logging.F("table", t.Table), logging.F("tombstone", t.ID), logging.F("rows", rows))
+	s.record(audit.Entry{
+		Identity: systemIdentity,
+		Action:   "data.delete",
+		Params:   map[string]interface{}{"table": t.Table, "tombstone": t.ID, "rows": rows},
+		Error:    errorOf(err),
+	})
+}
+
+// handleDelete deletes the rows of a table with a value of the key column, within a time range, or both, on
+// every node of the cluster. The deletion is recorded as a tombstone, which also removes the matching rows
+// from the data compacted until every row stored at the time of the deletion expired.
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) error {
+	if s.tombstones == nil {
+		return errors.Unimplemented("the deletion of rows is not enabled on this node")
+	}
+
+	query := r.URL.Query()
+	name := query.Get("table")
+	t, err := s.getTable(name)
+	if err != nil {
+		return errors.NotFound(err.Error())
+	}
+
+	if _, ok := t.(purger); !ok {
+		return errors.InvalidArgument("table " + name + " does not support the deletion of rows")
+	}
+
+	ts := tombstone.Tombstone{Table: name, Key: query.Get("key")}
+	if ts.Key != "" && t.HashBy() == "" {
+		return errors.InvalidArgument("table " + name + " has no key column")
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, err := parseTime(v)
+		if err != nil {
+			return errors.InvalidArgument("the 'from' parameter must be a unix timestamp or RFC3339")
+		}
+		ts.From = from.UnixNano()
+	}
+
+	if v := query.Get("until"); v != "" {
+		until, err := parseTime(v)
+		if err != nil {
+			return errors.InvalidArgument("the 'until' parameter must be a unix timestamp or RFC3339")
+		}
+		ts.Until = until.UnixNano()
+	}
+
+	// The tombstone is kept as long as the rows stored when it was created
+	if e, ok := t.(interface{ TTL() time.Duration }); ok && e.TTL() > 0 {
+		ts.Expires = time.Now().Add(e.TTL()).UnixNano()
+	}
+
+	ts, err = s.tombstones.Add(ts)
+	if err != nil {
+		return err
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: deletion requested", logging.F("table", name), logging.F("tombstone", ts.ID), logging.F("by", r.RemoteAddr))
+	return writeJSON(w, http.StatusAccepted, ts)
+}
+
+// handleTombstones lists the deletions of the rows which did not expire, of a table or of every table
+func (s *Server) handleTombstones(w http.ResponseWriter, r *http.Request) error {
+	if s.tombstones == nil {
+		return errors.Unimplemented("the deletion of rows is not enabled on this node")
+	}
+
+	return writeJSON(w, http.StatusOK, s.tombstones.Of(r.URL.Query().Get("table")))
+}
@@ -0,0 +1,87 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// purgedTable is a keyed table which records the tombstones applied to it
+type purgedTable struct {
+	namedTable
+	purged chan tombstone.Tombstone
+}
+
+func (t *purgedTable) HashBy() string     { return "user" }
+func (t *purgedTable) TTL() time.Duration { return time.Hour }
+func (t *purgedTable) Purge(_ context.Context, ts tombstone.Tombstone) (int, error) {
+	t.purged <- ts
+	return 1, nil
+}
+
+func TestDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tombstone-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{Admin: &config.Admin{Token: "secret"}}
+	eventlog := &purgedTable{
+		namedTable: namedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}, name: "eventlog"},
+		purged:     make(chan tombstone.Tombstone, 4),
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), eventlog)
+	call := func(url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, url, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.admin(s.handleDelete)(w, r)
+		return w
+	}
+
+	// The deletion of rows requires the tombstone log
+	assert.Equal(t, http.StatusNotImplemented, call("/v1/admin/delete?table=eventlog&key=user-1").Code)
+
+	tombstones, err := tombstone.New(dir)
+	assert.NoError(t, err)
+	s.SetTombstones(tombstones)
+
+	// The request is validated
+	assert.Equal(t, http.StatusNotFound, call("/v1/admin/delete?table=orders&key=user-1").Code)
+	assert.Equal(t, http.StatusBadRequest, call("/v1/admin/delete?table=eventlog").Code)
+	assert.Equal(t, http.StatusBadRequest, call("/v1/admin/delete?table=eventlog&from=yesterday").Code)
+
+	// The deletion is recorded, and applied to the table
+	w := call("/v1/admin/delete?table=eventlog&key=user-1&from=2020-01-01T00:00:00Z&until=1577923200")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var ts tombstone.Tombstone
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ts))
+	assert.Equal(t, "user-1", ts.Key)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(), ts.From)
+	assert.Equal(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC).UnixNano(), ts.Until)
+	assert.NotZero(t, ts.Expires)
+	assert.Equal(t, []tombstone.Tombstone{ts}, tombstones.Of("eventlog"))
+
+	select {
+	case purged := <-eventlog.purged:
+		assert.Equal(t, ts.ID, purged.ID)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the tombstone was not applied")
+	}
+}
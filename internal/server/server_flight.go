@@ -0,0 +1,482 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v10/arrow"
+	"github.com/apache/arrow/go/v10/arrow/array"
+	"github.com/apache/arrow/go/v10/arrow/flight"
+	"github.com/apache/arrow/go/v10/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v10/arrow/flight/flightsql/schema_ref"
+	"github.com/apache/arrow/go/v10/arrow/memory"
+	"github.com/kelindar/binary"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+	"google.golang.org/grpc"
+)
+
+const defaultFlightBatchSize = 4 * 1024 * 1024 // 4 MB
+
+// flightServer serves the statements of the Arrow Flight SQL clients. Each statement is planned into an endpoint
+// per split, located on the nodes holding the split, from which the clients read its rows as record batches.
+type flightServer struct {
+	flightsql.BaseServer
+	server *Server
+}
+
+// flightHandle represents the split of a statement read with a ticket
+type flightHandle struct {
+	Table   string   // The name of the table
+	Split   []byte   // The encoded split key
+	Columns []string // The columns selected
+}
+
+// newFlightServer creates the Arrow Flight SQL service of the server, registered with flightServiceDesc
+func newFlightServer(s *Server) flight.FlightServer {
+	return flightsql.NewFlightServer(&flightServer{server: s})
+}
+
+// GetFlightInfoStatement plans a statement, with an endpoint per split of the table.
+func (f *flightServer) GetFlightInfoStatement(ctx context.Context, cmd flightsql.StatementQuery, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	s := f.server
+	defer s.handlePanic()
+	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:flight_info")
+
+	stmt, err := parseStatement(cmd.GetQuery())
+	if err != nil {
+		return nil, flightError(err)
+	}
+
+	t, err := s.getTable(s.qualify(s.SchemaOf(stmt.Table)))
+	if err != nil {
+		return nil, flightError(errors.NotFound(err.Error()))
+	}
+
+	schema, _ := t.Schema()
+	columns := stmt.Columns
+	if len(columns) == 0 {
+		columns = schema.Columns()
+	}
+
+	if err := s.authorize(s.queryIdentity(ctx), t.Name(), columns); err != nil {
+		return nil, flightError(err)
+	}
+
+	out, err := arrowSchemaOf(schema, columns)
+	if err != nil {
+		return nil, flightError(err)
+	}
+
+	domain, err := stmt.domainOf(t.HashBy(), t.SortBy())
+	if err != nil {
+		return nil, flightError(err)
+	}
+
+	splits, err := s.getSplits(t, columns, domain, math.MaxInt32)
+	if err != nil {
+		return nil, flightError(errors.InvalidArgument(err.Error()))
+	}
+
+	info := &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(out, memory.DefaultAllocator),
+		FlightDescriptor: desc,
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}
+
+	// Every member gets the same split, in which case it is only read from the node serving the statement
+	seen := make(map[string]bool, len(splits))
+	for _, split := range splits {
+		if seen[string(split.Key)] {
+			continue
+		}
+		seen[string(split.Key)] = true
+
+		handle, err := binary.Marshal(&flightHandle{Table: t.Name(), Split: split.Key, Columns: columns})
+		if err != nil {
+			return nil, flightError(errors.Internal("unable to encode a split", err))
+		}
+
+		ticket, err := flightsql.CreateStatementQueryTicket(handle)
+		if err != nil {
+			return nil, flightError(errors.Internal("unable to encode a ticket", err))
+		}
+
+		info.Endpoint = append(info.Endpoint, &flight.FlightEndpoint{
+			Ticket:   &flight.Ticket{Ticket: ticket},
+			Location: s.locationsOf(split),
+		})
+	}
+	return info, nil
+}
+
+// DoGetStatement streams the rows of a split of a statement as record batches.
+func (f *flightServer) DoGetStatement(ctx context.Context, ticket flightsql.StatementQueryTicket) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	s := f.server
+	defer s.handlePanic()
+
+	handle := new(flightHandle)
+	if err := binary.Unmarshal(ticket.GetStatementHandle(), handle); err != nil {
+		return nil, nil, flightError(errors.InvalidArgument("unable to decode the ticket: " + err.Error()))
+	}
+
+	t, err := s.getTable(handle.Table)
+	if err != nil {
+		return nil, nil, flightError(errors.NotFound(err.Error()))
+	}
+
+	if err := s.authorize(s.queryIdentity(ctx), t.Name(), handle.Columns); err != nil {
+		return nil, nil, flightError(err)
+	}
+
+	schema, _ := t.Schema()
+	out, err := arrowSchemaOf(schema, handle.Columns)
+	if err != nil {
+		return nil, nil, flightError(err)
+	}
+
+	batchSize := int64(defaultFlightBatchSize)
+	if conf := s.conf().Readers.FlightSQL; conf != nil && conf.BatchSize > 0 {
+		batchSize = conf.BatchSize
+	}
+
+	// Wait for the node to admit the request
+	release, err := s.admit(batchSize)
+	if err != nil {
+		return nil, nil, flightError(errors.ResourceExhausted(err.Error()))
+	}
+
+	// Read the first page right away, so the statements which can not be read fail before streaming
+	page, err := s.getRows(t, handle.Split, handle.Columns, batchSize)
+	if err != nil {
+		release()
+		return nil, nil, flightError(errors.Internal("unable to get rows from a table", err))
+	}
+
+	chunks := make(chan flight.StreamChunk)
+	go func() {
+		defer s.handlePanic()
+		defer release()
+		defer close(chunks)
+		for {
+			if len(page.Columns) == len(handle.Columns) {
+				select {
+				case chunks <- flight.StreamChunk{Data: recordOf(out, page.Columns)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if page.NextToken == nil {
+				return
+			}
+
+			if page, err = s.getRows(t, page.NextToken, handle.Columns, batchSize); err != nil {
+				s.monitor.Warning(errors.Internal("unable to get rows from a table", err))
+				select {
+				case chunks <- flight.StreamChunk{Err: flightError(errors.Internal("unable to get rows from a table", err))}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}()
+	return out, chunks, nil
+}
+
+// GetFlightInfoTables lists the tables the client can query.
+func (f *flightServer) GetFlightInfoTables(ctx context.Context, cmd flightsql.GetTables, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	schema := schema_ref.Tables
+	if cmd.GetIncludeSchema() {
+		schema = schema_ref.TablesWithIncludedSchema
+	}
+
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, memory.DefaultAllocator),
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: desc.Cmd}}},
+		TotalRecords:     -1,
+		TotalBytes:       -1,
+	}, nil
+}
+
+// DoGetTables streams the tables the client can query, along with their schema if requested.
+func (f *flightServer) DoGetTables(ctx context.Context, cmd flightsql.GetTables) (*arrow.Schema, <-chan flight.StreamChunk, error) {
+	s := f.server
+	identity := s.queryIdentity(ctx)
+	schema := schema_ref.Tables
+	if cmd.GetIncludeSchema() {
+		schema = schema_ref.TablesWithIncludedSchema
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	for _, t := range s.Tables() {
+		schemaName, tableName := s.SchemaOf(t.Name())
+		if !s.canList(identity, t.Name()) || !matchesPattern(cmd.GetDBSchemaFilterPattern(), schemaName) ||
+			!matchesPattern(cmd.GetTableNameFilterPattern(), tableName) {
+			continue
+		}
+
+		b.Field(0).AppendNull()
+		b.Field(1).(*array.StringBuilder).Append(schemaName)
+		b.Field(2).(*array.StringBuilder).Append(tableName)
+		b.Field(3).(*array.StringBuilder).Append("TABLE")
+		if cmd.GetIncludeSchema() {
+			columns := make([]string, 0, 8)
+			tableSchema, _ := t.Schema()
+			for _, c := range tableSchema.Columns() {
+				if s.canSelect(identity, t.Name(), c) {
+					columns = append(columns, c)
+				}
+			}
+
+			out, _ := arrowSchemaOf(tableSchema, columns)
+			b.Field(4).(*array.BinaryBuilder).Append(flight.SerializeSchema(out, memory.DefaultAllocator))
+		}
+	}
+
+	chunks := make(chan flight.StreamChunk, 1)
+	chunks <- flight.StreamChunk{Data: b.NewRecord()}
+	close(chunks)
+	return schema, chunks, nil
+}
+
+// locationsOf returns the locations of the nodes holding a split, on their gRPC listener. A split without any
+// location is read from the node serving the statement.
+func (s *Server) locationsOf(split table.Split) []*flight.Location {
+	conf := s.conf().Writers.GRPC
+	if conf == nil {
+		return nil
+	}
+
+	scheme := "grpc+tcp"
+	if s.grpcTLS != nil {
+		scheme = "grpc+tls"
+	}
+
+	port := strconv.FormatInt(int64(conf.Port), 10)
+	locations := make([]*flight.Location, 0, len(split.Addrs))
+	for _, addr := range split.Addrs {
+		locations = append(locations, &flight.Location{Uri: scheme + "://" + net.JoinHostPort(addr, port)})
+	}
+	return locations
+}
+
+// flightError converts an error to a gRPC status, so the clients get its code
+func flightError(err error) error {
+	if xerr, ok := err.(*errors.Error); ok {
+		return xerr.RPCError()
+	}
+	return err
+}
+
+// matchesPattern checks whether a name matches a filter pattern of Flight SQL, in which '%' matches any sequence
+// of characters and '_' any character. A missing pattern matches every name.
+func matchesPattern(pattern *string, name string) bool {
+	if pattern == nil {
+		return true
+	}
+
+	glob := make([]byte, 0, len(*pattern))
+	for i := 0; i < len(*pattern); i++ {
+		switch c := (*pattern)[i]; c {
+		case '%':
+			glob = append(glob, '*')
+		case '_':
+			glob = append(glob, '?')
+		case '*', '?', '[', '\\':
+			glob = append(glob, '\\', c)
+		default:
+			glob = append(glob, c)
+		}
+	}
+
+	ok, _ := path.Match(string(glob), name)
+	return ok
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// arrowSchemaOf returns the schema of the record batches of the columns of a table, the JSON columns being strings
+func arrowSchemaOf(schema typeof.Schema, columns []string) (*arrow.Schema, error) {
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, c := range columns {
+		typ, ok := schema[c]
+		if !ok {
+			return nil, errors.InvalidArgument(fmt.Sprintf("the column '%s' does not exist", c))
+		}
+
+		field := arrow.Field{Name: c, Nullable: true}
+		switch typ {
+		case typeof.Int32:
+			field.Type = arrow.PrimitiveTypes.Int32
+		case typeof.Int64:
+			field.Type = arrow.PrimitiveTypes.Int64
+		case typeof.Float64:
+			field.Type = arrow.PrimitiveTypes.Float64
+		case typeof.Bool:
+			field.Type = arrow.FixedWidthTypes.Boolean
+		case typeof.Timestamp:
+			field.Type = arrow.FixedWidthTypes.Timestamp_ms
+		default:
+			field.Type = arrow.BinaryTypes.String
+		}
+		fields = append(fields, field)
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// recordOf converts a page of columns to a record batch. The values of a column whose type changed since the
+// statement was planned are null.
+func recordOf(schema *arrow.Schema, columns []presto.Column) arrow.Record {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	for i, column := range columns {
+		switch c, field := column.AsThrift(), b.Field(i); {
+		case c.IntegerData != nil && field.Type().ID() == arrow.INT32:
+			field.(*array.Int32Builder).AppendValues(c.IntegerData.Ints, validOf(c.IntegerData.Nulls))
+		case c.BigintData != nil && field.Type().ID() == arrow.INT64:
+			field.(*array.Int64Builder).AppendValues(c.BigintData.Longs, validOf(c.BigintData.Nulls))
+		case c.DoubleData != nil && field.Type().ID() == arrow.FLOAT64:
+			field.(*array.Float64Builder).AppendValues(c.DoubleData.Doubles, validOf(c.DoubleData.Nulls))
+		case c.BooleanData != nil && field.Type().ID() == arrow.BOOL:
+			field.(*array.BooleanBuilder).AppendValues(c.BooleanData.Booleans, validOf(c.BooleanData.Nulls))
+		case c.TimestampData != nil && field.Type().ID() == arrow.TIMESTAMP:
+			values := make([]arrow.Timestamp, len(c.TimestampData.Timestamps))
+			for j, v := range c.TimestampData.Timestamps {
+				values[j] = arrow.Timestamp(v)
+			}
+			field.(*array.TimestampBuilder).AppendValues(values, validOf(c.TimestampData.Nulls))
+		case c.VarcharData != nil && field.Type().ID() == arrow.STRING:
+			appendStrings(field.(*array.StringBuilder), c.VarcharData.Nulls, c.VarcharData.Sizes, c.VarcharData.Bytes)
+		case c.JsonData != nil && field.Type().ID() == arrow.STRING:
+			appendStrings(field.(*array.StringBuilder), c.JsonData.Nulls, c.JsonData.Sizes, c.JsonData.Bytes)
+		default:
+			for j := 0; j < column.Count(); j++ {
+				field.AppendNull()
+			}
+		}
+	}
+	return b.NewRecord()
+}
+
+// appendStrings appends the strings of a block, stored back to back
+func appendStrings(b *array.StringBuilder, nulls []bool, sizes []int32, bytes []byte) {
+	var offset int32
+	for i, size := range sizes {
+		if i < len(nulls) && nulls[i] {
+			b.AppendNull()
+		} else {
+			b.Append(string(bytes[offset : offset+size]))
+		}
+		offset += size
+	}
+}
+
+// validOf returns the validity of the values of a block from its nulls
+func validOf(nulls []bool) []bool {
+	valid := make([]bool, len(nulls))
+	for i, null := range nulls {
+		valid[i] = !null
+	}
+	return valid
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// flightService is the name of the gRPC service of Arrow Flight
+const flightService = "arrow.flight.protocol.FlightService"
+
+// flightServiceDesc describes the methods of Arrow Flight used by Flight SQL, so the service can be registered on
+// the gRPC server of the node, the library only registering it on a server of its own.
+var flightServiceDesc = grpc.ServiceDesc{
+	ServiceName: flightService,
+	HandlerType: (*flight.FlightServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "GetFlightInfo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			return flightUnary(srv, ctx, dec, interceptor, "GetFlightInfo", new(flight.FlightDescriptor), func(ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.(flight.FlightServer).GetFlightInfo(ctx, in.(*flight.FlightDescriptor))
+			})
+		},
+	}, {
+		MethodName: "GetSchema",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			return flightUnary(srv, ctx, dec, interceptor, "GetSchema", new(flight.FlightDescriptor), func(ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.(flight.FlightServer).GetSchema(ctx, in.(*flight.FlightDescriptor))
+			})
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "DoGet",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := new(flight.Ticket)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(flight.FlightServer).DoGet(in, &flightDataStream{stream})
+		},
+	}, {
+		StreamName:    "DoAction",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := new(flight.Action)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(flight.FlightServer).DoAction(in, &flightResultStream{stream})
+		},
+	}, {
+		StreamName:    "ListActions",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := new(flight.Empty)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(flight.FlightServer).ListActions(in, &flightActionStream{stream})
+		},
+	}},
+	Metadata: "Flight.proto",
+}
+
+// flightUnary decodes the request of a unary method of Arrow Flight and calls it, through the interceptor if any
+func flightUnary(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor,
+	method string, in interface{}, call func(context.Context, interface{}) (interface{}, error)) (interface{}, error) {
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return call(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + flightService + "/" + method}
+	return interceptor(ctx, in, info, call)
+}
+
+// The server streams of Arrow Flight, sending the data of the record batches, the results of the actions and the
+// actions available
+type (
+	flightDataStream   struct{ grpc.ServerStream }
+	flightResultStream struct{ grpc.ServerStream }
+	flightActionStream struct{ grpc.ServerStream }
+)
+
+func (s *flightDataStream) Send(m *flight.FlightData) error   { return s.SendMsg(m) }
+func (s *flightResultStream) Send(m *flight.Result) error     { return s.SendMsg(m) }
+func (s *flightActionStream) Send(m *flight.ActionType) error { return s.SendMsg(m) }
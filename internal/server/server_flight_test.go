@@ -0,0 +1,132 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/apache/arrow/go/v10/arrow/array"
+	"github.com/apache/arrow/go/v10/arrow/flight/flightsql"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newFlightClient serves the gRPC listener of the server and connects a Flight SQL client to it
+func newFlightClient(t *testing.T, s *Server) (*flightsql.Client, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = s.server.Serve(lis) }()
+
+	client, err := flightsql.NewClient(lis.Addr().String(), nil, nil, grpc.WithInsecure())
+	assert.NoError(t, err)
+	return client, func() {
+		_ = client.Close()
+		s.server.Stop()
+	}
+}
+
+func TestFlightSQL(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto:    &config.Presto{Schema: "data"},
+			FlightSQL: &config.FlightSQL{},
+		},
+	})
+
+	client, closer := newFlightClient(t, s)
+	defer closer()
+
+	// Every member gets the same split of the nodes table, which is read once
+	ctx := context.Background()
+	info, err := client.Execute(ctx, `SELECT address, "started" FROM data.nodes;`)
+	assert.NoError(t, err)
+	assert.Len(t, info.Endpoint, 1)
+	assert.Empty(t, info.Endpoint[0].Location)
+
+	reader, err := client.DoGet(ctx, info.Endpoint[0].Ticket)
+	assert.NoError(t, err)
+	defer reader.Release()
+
+	assert.True(t, reader.Next())
+	record := reader.Record()
+	assert.Equal(t, int64(1), record.NumRows())
+	assert.Equal(t, "address", record.ColumnName(0))
+	assert.Equal(t, "127.0.0.1", record.Column(0).(*array.String).Value(0))
+	assert.True(t, record.Column(1).(*array.Int64).Value(0) > 0)
+	assert.False(t, reader.Next())
+	assert.NoError(t, reader.Err())
+
+	// The statements outside of the supported subset are refused
+	_, err = client.Execute(ctx, "SELECT address FROM nodes WHERE zone = 'a'")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Execute(ctx, "SELECT address FROM xxx")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Execute(ctx, "SELECT xxx FROM nodes")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The tables are listed along with their schema
+	info, err = client.GetTables(ctx, &flightsql.GetTablesOpts{IncludeSchema: true})
+	assert.NoError(t, err)
+	tables, err := client.DoGet(ctx, info.Endpoint[0].Ticket)
+	assert.NoError(t, err)
+	defer tables.Release()
+
+	assert.True(t, tables.Next())
+	assert.Equal(t, int64(1), tables.Record().NumRows())
+	assert.Equal(t, "data", tables.Record().Column(1).(*array.String).Value(0))
+	assert.Equal(t, "nodes", tables.Record().Column(2).(*array.String).Value(0))
+}
+
+func TestFlightSQL_Denied(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto:    &config.Presto{Schema: "data"},
+			FlightSQL: &config.FlightSQL{},
+			ACL:       []config.Access{{Identity: "analyst", Tables: []string{"events"}}},
+		},
+	})
+
+	client, closer := newFlightClient(t, s)
+	defer closer()
+
+	// The anonymous clients can not query the table, nor see it
+	ctx := context.Background()
+	_, err := client.Execute(ctx, "SELECT address FROM nodes")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	info, err := client.GetTables(ctx, &flightsql.GetTablesOpts{})
+	assert.NoError(t, err)
+	tables, err := client.DoGet(ctx, info.Endpoint[0].Ticket)
+	assert.NoError(t, err)
+	defer tables.Release()
+
+	assert.True(t, tables.Next())
+	assert.Equal(t, int64(0), tables.Record().NumRows())
+}
+
+func TestFlightSQL_Disabled(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+	})
+
+	client, closer := newFlightClient(t, s)
+	defer closer()
+
+	_, err := client.Execute(context.Background(), "SELECT address FROM nodes")
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestMatchesPattern(t *testing.T) {
+	pattern := func(v string) *string { return &v }
+	assert.True(t, matchesPattern(nil, "events"))
+	assert.True(t, matchesPattern(pattern("ev%"), "events"))
+	assert.True(t, matchesPattern(pattern("_vents"), "events"))
+	assert.False(t, matchesPattern(pattern("ev_"), "events"))
+	assert.False(t, matchesPattern(pattern("*"), "events"))
+}
@@ -0,0 +1,301 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/grab/async"
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/twmb/murmur3"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	forwardedMetadataKey = "talaria-forwarded"
+	forwardErrorKey      = "ingest.forward.error"
+	forwardCountKey      = "ingest.forward.count"
+	replicateErrorKey    = "ingest.replicate.error"
+	replicationAsync     = "async"
+	handoverTimeout      = 30 * time.Second
+)
+
+// Ownership represents a contract which assigns the hash keys to the nodes of the cluster.
+type Ownership interface {
+	Replicas(hash uint32) []string
+	IsLocal(addr string) bool
+}
+
+// SetOwnership sets the hash ring which decides which nodes store the rows of each hash key. Once set,
+// the rows ingested by this node are forwarded to their owner and replicas, if the node is not one of them.
+func (s *Server) SetOwnership(ring Ownership) {
+	s.ring = ring
+}
+
+// isForwarded checks whether the request was forwarded by another node of the cluster
+func isForwarded(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md.Get(forwardedMetadataKey)) > 0
+}
+
+// trustForwarded removes the mark of the forwarded rows from a request, unless it was sent by a node of the
+// cluster. Since the forwarded rows skip the ingestion controls, the other clients are treated as producers.
+func (s *Server) trustForwarded(ctx context.Context, settings *settings, identity *auth.Identity) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(forwardedMetadataKey)) == 0 || s.isClusterPeer(ctx, settings, identity) {
+		return ctx
+	}
+
+	md = md.Copy()
+	delete(md, forwardedMetadataKey)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// isClusterPeer checks whether a request was sent by a node of the cluster, which either presented the token of
+// the cluster or connected from the address of a member with a certificate issued by the CA of the cluster. If
+// neither the producers are authenticated nor the nodes secured with TLS, any member is trusted by its address.
+func (s *Server) isClusterPeer(ctx context.Context, settings *settings, identity *auth.Identity) bool {
+	switch {
+	case s.ring == nil:
+		return false
+	case identity != nil && identity.Internal:
+		return true
+	case settings.auth != nil && s.nodeTLS == nil:
+		return false
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return false
+	}
+
+	if s.nodeTLS != nil {
+		info, ok := p.AuthInfo.(credentials.TLSInfo)
+		if !ok || !s.nodeTLS.Issued(info.State.PeerCertificates) {
+			return false
+		}
+	}
+
+	members, ok := s.ring.(interface{ Members() []string })
+	if !ok {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	for _, member := range members.Members() {
+		if member == host {
+			return true
+		}
+	}
+	return false
+}
+
+// outbox represents the rows to send to a node, along with the blocks to keep locally if that fails
+type outbox struct {
+	events []client.Event
+	blocks []block.Block
+}
+
+// forward sends the blocks to their owner and replicas, and returns the blocks which should be stored
+// locally. If the owner of a block can not be reached, the block is kept locally instead.
+func (s *Server) forward(ctx context.Context, table string, blocks []block.Block) []block.Block {
+	if s.ring == nil || isForwarded(ctx) {
+		return blocks
+	}
+
+	local := make([]block.Block, 0, len(blocks))
+	owners := make(map[string]*outbox)
+	replicas := make(map[string]*outbox)
+	for _, b := range blocks {
+		addrs := s.ring.Replicas(murmur3.StringSum32(string(b.Key)))
+		if len(addrs) == 0 {
+			local = append(local, b)
+			continue
+		}
+
+		// Keep the block if the local node is one of its replicas
+		stored := false
+		for _, addr := range addrs {
+			if s.ring.IsLocal(addr) {
+				local = append(local, b)
+				stored = true
+			}
+		}
+
+		var events []client.Event
+		for i, addr := range addrs {
+			if s.ring.IsLocal(addr) {
+				continue
+			}
+
+			// Decode the rows only once, when the block needs to be sent
+			if events == nil {
+				var err error
+				if events, err = eventsOf(b); err != nil {
+					s.monitor.Count1(ctxTag, forwardErrorKey, "type:decode")
+					if !stored {
+						local = append(local, b)
+					}
+					break
+				}
+			}
+
+			target := replicas
+			if i == 0 {
+				target = owners
+			}
+
+			if target[addr] == nil {
+				target[addr] = new(outbox)
+			}
+			target[addr].events = append(target[addr].events, events...)
+			if i == 0 && !stored {
+				target[addr].blocks = append(target[addr].blocks, b)
+			}
+		}
+	}
+
+	// Send the rows to each of the owners, keeping the blocks locally if that fails
+	for addr, out := range owners {
+		if err := s.send(ctx, addr, table, out.events); err != nil {
+			s.monitor.Count1(ctxTag, forwardErrorKey, "type:send")
+			s.monitor.Warning(errors.Internal("unable to forward to "+addr, err))
+			local = append(local, out.blocks...)
+			continue
+		}
+
+		s.monitor.Count(ctxTag, forwardCountKey, int64(len(out.events)), "table:"+table)
+	}
+
+	// Send the copies to the replicas, in the background if replication is asynchronous. The replication is
+	// best-effort: a replica which can not be reached misses the rows, which are not sent to it again later.
+	replicate := func(ctx context.Context) (interface{}, error) {
+		for addr, out := range replicas {
+			if err := s.send(ctx, addr, table, out.events); err != nil {
+				s.monitor.Count1(ctxTag, replicateErrorKey, "table:"+table, "peer:"+addr)
+				s.monitor.Warning(errors.Internal("unable to replicate "+strconv.Itoa(len(out.events))+" rows of "+table+" to "+addr, err))
+			}
+		}
+		return nil, nil
+	}
+
+	switch {
+	case len(replicas) == 0:
+	case s.conf().Cluster.Replication == replicationAsync:
+		async.Invoke(context.Background(), replicate)
+	default:
+		_, _ = replicate(ctx)
+	}
+	return local
+}
+
+// Handover sends the blocks of a table to another node of the cluster, such as the leader compacting the
+// table. The rows are stored as they are by that node, and are not forwarded again.
+func (s *Server) Handover(addr, table string, blocks []block.Block) error {
+	var events []client.Event
+	for _, b := range blocks {
+		rows, err := eventsOf(b)
+		if err != nil {
+			return err
+		}
+		events = append(events, rows...)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handoverTimeout)
+	defer cancel()
+	return s.send(ctx, addr, table, events)
+}
+
+// send ingests the events on the owner, marking the request as forwarded so it is not forwarded again and
+// presenting the token of the cluster, if the producers are authenticated.
+func (s *Server) send(ctx context.Context, owner, table string, events []client.Event) error {
+	peer, err := s.peerOf(owner)
+	if err != nil {
+		return err
+	}
+
+	ctx = client.WithTables(ctx, table)
+	ctx = metadata.AppendToOutgoingContext(ctx, forwardedMetadataKey, "true")
+	if auth := s.conf().Writers.Auth; auth != nil && auth.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, authMetadataKey, "Bearer "+auth.Token)
+	}
+	return peer.IngestBatch(ctx, events)
+}
+
+// peerOf returns the client connected to a node of the cluster
+func (s *Server) peerOf(owner string) (*client.Client, error) {
+	if c, ok := s.peers.Load(owner); ok {
+		return c.(*client.Client), nil
+	}
+
+	grpc := s.conf().Writers.GRPC
+	if grpc == nil {
+		return nil, errors.New("the gRPC ingress is not configured")
+	}
+
+	port := strconv.FormatInt(int64(grpc.Port), 10)
+	var options []client.Option
+	if tlsConfig := s.peerTLS(s.grpcTLS); tlsConfig != nil {
+		options = append(options, client.WithCredential(credentials.NewTLS(tlsConfig)))
+	}
+
+	c, err := client.Dial(net.JoinHostPort(owner, port), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another request may have connected to the node meanwhile, in which case its client is used instead
+	actual, loaded := s.peers.LoadOrStore(owner, c)
+	if loaded {
+		_ = c.Close()
+	}
+	return actual.(*client.Client), nil
+}
+
+// eventsOf decodes the rows of a block as a set of events
+func eventsOf(b block.Block) ([]client.Event, error) {
+	schema := b.Schema()
+	columns, err := b.Select(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	count := columns.Max()
+	events := make([]client.Event, 0, count)
+	for i := 0; i < count; i++ {
+		event := make(client.Event, len(columns))
+		for name, column := range columns {
+			v := column.At(i)
+			if v == nil {
+				continue
+			}
+
+			// Keep the JSON columns as JSON, rather than plain strings
+			if s, ok := v.(string); ok && schema[name] == typeof.JSON {
+				v = json.RawMessage(s)
+			}
+			event[name] = v
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
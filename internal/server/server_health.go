@@ -0,0 +1,65 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/server/health"
+)
+
+// checker represents a component which can check its own health
+type checker interface {
+	Check(ctx context.Context) error
+}
+
+// sinkChecker represents a table which can check whether its sinks are reachable
+type sinkChecker interface {
+	CheckSink(ctx context.Context) error
+}
+
+// HealthChecks adds the checks of the server to the probes. The storage of the tables is checked by the
+// liveness probe, while the sinks, the S3/SQS ingestion and the membership of the cluster are only checked
+// by the readiness probe. This must be called once the tables are registered.
+func (s *Server) HealthChecks(h *health.Health) {
+	for _, t := range s.Tables() {
+		if c, ok := t.(checker); ok {
+			h.Live("storage."+t.Name(), c.Check)
+		}
+
+		if c, ok := t.(sinkChecker); ok {
+			h.Ready("sink."+t.Name(), c.CheckSink)
+		}
+	}
+
+	if s.conf().Writers.S3SQS != nil {
+		h.Ready("ingress.s3sqs", s.checkIngress)
+	}
+
+	if s.cluster != nil {
+		h.Ready("cluster", s.checkMembership)
+	}
+}
+
+// checkIngress checks whether the S3/SQS ingestion is consuming the queue
+func (s *Server) checkIngress(ctx context.Context) error {
+	if s.s3sqs == nil {
+		return errors.New("s3sqs: the ingestion has not started")
+	}
+
+	return s.s3sqs.Check(ctx)
+}
+
+// checkMembership checks whether this node is a member of the cluster
+func (s *Server) checkMembership(ctx context.Context) error {
+	self := s.self()
+	for _, addr := range s.cluster.Members() {
+		if addr == self {
+			return nil
+		}
+	}
+
+	return errors.New("cluster: this node is not a member of the cluster")
+}
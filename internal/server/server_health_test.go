@@ -0,0 +1,59 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/health"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkedTable is a table reporting its health
+type checkedTable struct {
+	appendTable
+	sink error
+}
+
+func (t *checkedTable) Check(ctx context.Context) error     { return nil }
+func (t *checkedTable) CheckSink(ctx context.Context) error { return t.sink }
+
+// leftMembership is the membership of a node which left the cluster
+type leftMembership struct{}
+
+func (leftMembership) Members() []string { return []string{"127.0.0.1"} }
+func (leftMembership) Addr() string      { return "127.0.0.2:7946" }
+
+func TestHealthChecks(t *testing.T) {
+	events := &checkedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	s := New(func() *config.Config { return &config.Config{} }, monitor.NewNoop(), script.NewLoader(nil), events)
+	s.SetMembership(adminMembership{"127.0.0.1", "127.0.0.2"})
+
+	h := health.New(time.Second)
+	s.HealthChecks(h)
+	assert.True(t, h.Liveness(context.Background()).Healthy)
+
+	report := h.Readiness(context.Background())
+	assert.True(t, report.Healthy)
+	assert.Len(t, report.Checks, 3)
+	assert.Contains(t, report.Checks, "storage.events")
+	assert.Contains(t, report.Checks, "sink.events")
+	assert.Contains(t, report.Checks, "cluster")
+
+	// The node is not ready when its sink fails or it left the cluster
+	events.sink = errors.New("unreachable")
+	s.SetMembership(leftMembership{})
+	report = h.Readiness(context.Background())
+	assert.False(t, report.Healthy)
+	assert.False(t, report.Checks["sink.events"].Healthy)
+	assert.False(t, report.Checks["cluster"].Healthy)
+	assert.True(t, h.Liveness(context.Background()).Healthy)
+}
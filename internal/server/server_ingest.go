@@ -5,64 +5,568 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/ingress/pipeline"
 	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/server/auth"
 	"github.com/kelindar/talaria/internal/storage"
 	"github.com/kelindar/talaria/internal/storage/stream"
 	"github.com/kelindar/talaria/internal/table"
 	talaria "github.com/kelindar/talaria/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 // applyFunc applies a transformation on a row and returns a new row
 type applyFunc = func(block.Row) (block.Row, error)
 
-const ingestErrorKey = "ingest.error"
+const (
+	ingestErrorKey   = "ingest.error"
+	tableMetadataKey = "talaria-table"
+	authMetadataKey  = "authorization"
+	sumMetadataKey   = "talaria-checksum"
+	maxIngestSize    = 32 * 1024 * 1024 // 32 MB, the same as a gRPC message
+	lagInterval      = 10 * time.Second
+)
 
 // Ingest implements ingress.IngressServer
 func (s *Server) Ingest(ctx context.Context, request *talaria.IngestRequest) (*talaria.IngestResponse, error) {
 	defer s.handlePanic()
 
+	// Authenticate the producer, if the producers are authenticated
+	settings := s.settings()
+	identity, err := s.authenticate(ctx, settings)
+	if err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:auth")
+		return nil, err
+	}
+
+	// Ignore the mark of the forwarded rows, unless they were sent by a node of the cluster
+	ctx = s.trustForwarded(ctx, settings, identity)
+
+	// Reject the data which was corrupted on its way, if the producer sent its checksum
+	if err := verifyChecksum(ctx, request); err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:checksum")
+		return nil, err
+	}
+
+	// Reject the request if the producer exceeded its quota, and charge it once ingested
+	producer := producerOf(ctx, identity)
+	if exceeded, err := s.quotas.Allow(producer); err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:quota")
+		s.monitor.Count1(ctxTag, "quota.exceeded", "producer:"+producer, "quota:"+string(exceeded))
+		return nil, err
+	}
+
+	// Likewise for the tenant of the producer
+	tenant := tenantOf(ctx, identity)
+	if exceeded, err := s.tenants.Allow(tenant); err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:quota")
+		s.monitor.Count1(ctxTag, "quota.exceeded", "tenant:"+tenant, "quota:"+string(exceeded))
+		return nil, err
+	}
+
+	response, events, bytes, err := s.ingest(ctx, request, settings, identity)
+	s.quotas.Charge(producer, events, bytes)
+	if producer != "" {
+		s.monitor.Count(ctxTag, "quota.events", events, "producer:"+producer)
+		s.monitor.Count(ctxTag, "quota.bytes", bytes, "producer:"+producer)
+	}
+
+	s.tenants.Charge(tenant, events, bytes)
+	if tenant != "" {
+		s.monitor.Count(ctxTag, "quota.events", events, "tenant:"+tenant)
+		s.monitor.Count(ctxTag, "quota.bytes", bytes, "tenant:"+tenant)
+	}
+	return response, err
+}
+
+// verifyChecksum compares the CRC-32C checksum of the data of the request with the one sent by the producer, in
+// hexadecimal, if any.
+func verifyChecksum(ctx context.Context, request *talaria.IngestRequest) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(sumMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return nil
+	}
+
+	expected, err := strconv.ParseUint(values[0], 16, 32)
+	if err != nil {
+		return errors.InvalidArgument("invalid checksum " + values[0])
+	}
+
+	if actual := talaria.Checksum(request); uint32(expected) != actual {
+		return errors.InvalidArgument(fmt.Sprintf("checksum mismatch, expected %08x but the data has %08x", expected, actual))
+	}
+	return nil
+}
+
+// producerOf returns the name of the producer the quota applies to, either its identity or its address if the
+// producers are not authenticated. The nodes forwarding rows to each other are not limited.
+func producerOf(ctx context.Context, identity *auth.Identity) string {
+	switch {
+	case isForwarded(ctx) || (identity != nil && identity.Internal):
+		return ""
+	case identity != nil:
+		return identity.Name
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// ingest appends the rows of the request to the tables the producer can write to, returning the number of
+// events and bytes ingested, counted once regardless of the number of tables.
+func (s *Server) ingest(ctx context.Context, request *talaria.IngestRequest, settings *settings, identity *auth.Identity) (*talaria.IngestResponse, int64, int64, error) {
+	// Retrieve the tables which should receive the data
+	tables, err := s.targetsOf(ctx, identity, settings)
+	if err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:table")
+		return nil, 0, 0, err
+	}
+
 	// Iterate through all of the appenders and append the blocks to them
-	for _, t := range s.tables {
+	var events, bytes int64
+	forwarded := isForwarded(ctx)
+	for _, t := range tables {
 		appender, ok := t.(table.Appender)
 		if !ok {
 			continue
 		}
 
-		// Set the filter only if the schema is static
-		var filter *typeof.Schema
-		if schema, static := t.Schema(); static {
-			filter = &schema
+		// Reject the rows of a table once its tenant reached its storage on this node
+		if !forwarded {
+			if err := s.checkStorage(t.Name(), settings); err != nil {
+				s.monitor.Count1(ctxTag, ingestErrorKey, "type:quota")
+				s.monitor.Count1(ctxTag, "quota.exceeded", "table:"+t.Name(), "quota:storage")
+				return nil, events, bytes, err
+			}
 		}
 
-		// Functions to be applied
-		funcs := []applyFunc{block.Transform(filter, s.computed...)}
+		rows, size, err := s.ingestTable(ctx, request, t, appender, settings, forwarded, tenantOf(ctx, identity))
+		events, bytes = max(events, rows), max(bytes, size)
+		if err != nil {
+			return nil, events, bytes, err
+		}
+	}
+
+	return &talaria.IngestResponse{}, events, bytes, nil
+}
+
+// authenticate returns the identity of the producer of a request, or nil if the producers are not authenticated
+func (s *Server) authenticate(ctx context.Context, settings *settings) (*auth.Identity, error) {
+	if settings.auth == nil {
+		return nil, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get(authMetadataKey); len(values) > 0 {
+		token = strings.TrimPrefix(values[0], "Bearer ")
+	}
+
+	identity, err := settings.auth.Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the nodes can forward rows, since these skip the ingestion pipelines
+	if isForwarded(ctx) && !identity.Internal {
+		return nil, errors.PermissionDenied("only the nodes of the cluster can forward rows")
+	}
+	return identity, nil
+}
+
+// listenIngest starts the HTTP ingestion listener and blocks until the context is cancelled.
+func (s *Server) listenIngest(ctx context.Context, conf *config.HTTP) error {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/ingest", s.handleIngest).Methods(http.MethodPost)
+	router.HandleFunc("/v1/prometheus/write", s.handlePrometheusWrite).Methods(http.MethodPost)
+	router.HandleFunc("/v1/traces", s.handleTraces).Methods(http.MethodPost)
+	router.HandleFunc("/v1/logs", s.handleLogs).Methods(http.MethodPost)
+
+	s.monitor.Info("server: listening for http ingestion on :%d...", conf.Port)
+	return serveHTTP(ctx, conf.Port, router, nil)
+}
+
+// handleIngest ingests a file sent in the body, or downloaded from the url parameter, into the tables named by
+// the table parameters (or every table the producer can write to). The format of the file is given by its
+// content type, either CSV, ORC, Parquet or JSON.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	request, err := requestOf(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Pass the tables and the token the same way as the gRPC ingress, so they are checked the same way
+	md := metadata.Pairs(authMetadataKey, r.Header.Get("Authorization"))
+	if checksum := r.Header.Get("Talaria-Checksum"); checksum != "" {
+		md.Append(sumMetadataKey, checksum)
+	}
+	for _, name := range r.URL.Query()["table"] {
+		md.Append(tableMetadataKey, name)
+	}
+
+	ctx := peer.NewContext(metadata.NewIncomingContext(r.Context(), md), &peer.Peer{Addr: httpAddr(r.RemoteAddr)})
+	if _, err := s.Ingest(ctx, request); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestOf reads the ingestion request of a file sent in the body, or downloaded from the url parameter. The
+// format of the file is given by its content type, either CSV, ORC, Parquet or JSON.
+func requestOf(w http.ResponseWriter, r *http.Request) (*talaria.IngestRequest, error) {
+	request := new(talaria.IngestRequest)
+	if url := r.URL.Query().Get("url"); url != "" {
+		request.Data = &talaria.IngestRequest_Url{Url: url}
+		return request, nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		return nil, errors.InvalidArgument("unable to read the body: " + err.Error())
+	}
+
+	switch ct := strings.ToLower(r.Header.Get("Content-Type")); {
+	case strings.HasPrefix(ct, "text/csv"):
+		request.Data = &talaria.IngestRequest_Csv{Csv: body}
+	case strings.HasSuffix(ct, "orc"):
+		request.Data = &talaria.IngestRequest_Orc{Orc: body}
+	case strings.HasSuffix(ct, "parquet"):
+		request.Data = &talaria.IngestRequest_Parquet{Parquet: body}
+	case strings.Contains(ct, "json"):
+		request.Data = &talaria.IngestRequest_Json{Json: body}
+	default:
+		return nil, errors.InvalidArgument("unsupported content type " + ct)
+	}
+	return request, nil
+}
+
+// httpAddr represents the address of an HTTP client
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// batchBuilder builds a batch of events received through another protocol, interning the strings of the batch
+type batchBuilder struct {
+	batch      *talaria.Batch
+	dictionary map[string]uint32
+}
+
+// newBatchBuilder creates a new builder of an empty batch
+func newBatchBuilder() *batchBuilder {
+	return &batchBuilder{
+		batch:      &talaria.Batch{Strings: make(map[uint32][]byte)},
+		dictionary: make(map[string]uint32),
+	}
+}
+
+// intern returns the identifier of a string of the batch, adding it to the batch if needed
+func (b *batchBuilder) intern(v string) uint32 {
+	if id, ok := b.dictionary[v]; ok {
+		return id
+	}
+
+	id := uint32(len(b.dictionary))
+	b.dictionary[v] = id
+	b.batch.Strings[id] = []byte(v)
+	return id
+}
+
+// append adds an event to the batch, with the columns holding a string, an int64, a float64, a bool or JSON. The
+// empty strings are skipped, as if the column was missing.
+func (b *batchBuilder) append(columns map[string]interface{}) {
+	event := &talaria.Event{Value: make(map[uint32]*talaria.Value, len(columns))}
+	for name, v := range columns {
+		var value *talaria.Value
+		switch v := v.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+			value = &talaria.Value{Value: &talaria.Value_String_{String_: b.intern(v)}}
+		case int64:
+			value = &talaria.Value{Value: &talaria.Value_Int64{Int64: v}}
+		case float64:
+			value = &talaria.Value{Value: &talaria.Value_Float64{Float64: v}}
+		case bool:
+			value = &talaria.Value{Value: &talaria.Value_Bool{Bool: v}}
+		case json.RawMessage:
+			value = &talaria.Value{Value: &talaria.Value_Json{Json: b.intern(string(v))}}
+		default:
+			continue
+		}
+		event.Value[b.intern(name)] = value
+	}
+	b.batch.Events = append(b.batch.Events, event)
+}
+
+// ingestTable appends the rows of the request to a table, once a slot is available if the table limits its
+// concurrent ingestion. It returns the number of rows decoded and the size of the blocks, including the ones
+// forwarded to other nodes. The rows of a producer belonging to a tenant are stamped with it, if the table is shared.
+func (s *Server) ingestTable(ctx context.Context, request *talaria.IngestRequest, t table.Table, appender table.Appender, settings *settings, forwarded bool, tenant string) (rows int64, size int64, err error) {
+	if err, ok := settings.broken[t.Name()]; ok && !forwarded {
+		s.ingestFailed(t.Name(), "pipeline", err)
+		return 0, 0, errors.Unavailable("table " + t.Name() + " can not ingest until its pipeline is loaded")
+	}
+
+	if limit, ok := settings.limits[t.Name()]; ok {
+		if err := limit.Acquire(ctx, 1); err != nil {
+			s.ingestFailed(t.Name(), "concurrency", err)
+			return 0, 0, errors.DeadlineExceeded("table " + t.Name() + " is ingesting too many requests")
+		}
+		defer limit.Release(1)
+	}
+
+	// Set the filter only if the schema is static
+	var filter *typeof.Schema
+	if schema, static := t.Schema(); static {
+		filter = &schema
+	}
+
+	// The timestamp columns are decoded as they are sent, so that the pipeline parses them in any of their formats
+	decode := s.hintsOf(t.Name(), request, filter)
+	if parsed, ok := settings.parsed[t.Name()]; ok && filter != nil && !forwarded {
+		decode = withoutColumns(*filter, parsed)
+	}
+
+	// Functions to be applied, starting with the pipeline of the table so it sees the row as decoded
+	funcs := make([]applyFunc, 0, 6)
+	funcs = append(funcs, func(r block.Row) (block.Row, error) {
+		rows++
+		return r, nil
+	})
+
+	// The rows of a strict table are decoded as they are sent, then checked against its schema
+	if strict, ok := settings.strict[t.Name()]; ok && !forwarded {
+		funcs = append(funcs, pipeline.Strict(t.Name(), strict, s.expectedOf(t.Name(), decode, settings), rejectOf(t), s.monitor))
+		decode = nil
+	}
+
+	if forwarded { // Already transformed and published by the node which forwarded it
+		funcs = append(funcs, block.Transform(filter))
+	} else {
+		if column, shared := settings.tenantBy[t.Name()]; shared && tenant != "" {
+			funcs = append(funcs, stampTenant(column, tenant))
+		}
+
+		funcs = append(funcs, settings.pipeline[t.Name()]...)
+		funcs = append(funcs, block.Transform(filter, computedFor(t, settings.computed)...))
 
 		// If table supports streaming, add publishing function
 		if streamer, ok := t.(storage.Streamer); ok {
 			funcs = append(funcs, stream.Publish(streamer, s.monitor))
 		}
+	}
+
+	// Partition the request for the table
+	blocks, err := block.FromRequestBy(request, appender.HashBy(), decode, funcs...)
+	if err != nil {
+		s.ingestFailed(t.Name(), "convert", err)
+		return rows, 0, errors.Internal("unable to read the block", err)
+	}
+
+	for _, b := range blocks {
+		size += b.Size
+	}
+
+	// Reject the columns whose type changed, and record the new ones as a new version of the schema
+	if err := s.evolve(t.Name(), blocks); err != nil {
+		s.ingestFailed(t.Name(), "schema", err)
+		return rows, size, err
+	}
+
+	// Forward the blocks owned by other nodes, if the cluster partitions the data
+	if appender.HashBy() != "" {
+		blocks = s.forward(ctx, t.Name(), blocks)
+	}
+
+	// Append all of the blocks
+	var appended int64
+	for _, block := range blocks {
+		if err := appender.Append(block); err != nil {
+			s.ingestFailed(t.Name(), "append", err)
+			return rows, size, err
+		}
+		appended += block.Size
+	}
+
+	s.measure(t.Name(), appended)
+	s.monitor.Count("server", fmt.Sprintf("%s.ingest.count", t.Name()), int64(len(blocks)))
+	return rows, size, nil
+}
+
+// max returns the larger of two numbers
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// ingestFailed reports an ingestion error for a table, so it is recorded as an event
+func (s *Server) ingestFailed(table, kind string, err error) {
+	s.monitor.Count1(ctxTag, ingestErrorKey, "type:"+kind)
+	s.monitor.Log(logging.LevelWarning, "server: unable to ingest", logging.Event(ingestErrorKey),
+		logging.F("table", table), logging.F("type", kind), logging.F("error", err))
+}
+
+// targetsOf returns the tables the request should be appended to. Unless the request metadata names
+// specific tables (optionally qualified with their schema), every table the producer can write to receives
+// the data.
+func (s *Server) targetsOf(ctx context.Context, identity *auth.Identity, settings *settings) ([]table.Table, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	names := md.Get(tableMetadataKey)
+	if len(names) == 0 {
+		tables := s.Tables()
+		allowed := make([]table.Table, 0, len(tables))
+		for _, t := range tables {
+			if settings.canWrite(identity, t.Name()) {
+				allowed = append(allowed, t)
+			}
+		}
+		return allowed, nil
+	}
 
-		// Partition the request for the table
-		blocks, err := block.FromRequestBy(request, appender.HashBy(), filter, funcs...)
+	tables := make([]table.Table, 0, len(names))
+	for _, name := range names {
+		t, err := s.getTable(s.qualify(s.SchemaOf(name)))
 		if err != nil {
-			s.monitor.Count1(ctxTag, ingestErrorKey, "type:convert")
-			return nil, errors.Internal("unable to read the block", err)
+			return nil, errors.NotFound(err.Error())
+		}
+
+		if !settings.canWrite(identity, t.Name()) {
+			return nil, errors.PermissionDenied(identity.Name + " can not write to table " + t.Name())
 		}
 
-		// Append all of the blocks
-		for _, block := range blocks {
-			if err := appender.Append(block); err != nil {
-				s.monitor.Count1(ctxTag, ingestErrorKey, "type:append")
-				return nil, err
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// computedFor returns the computed columns for the table, including the ingestion time if the table records it.
+func computedFor(t table.Table, columns []column.Computed) []column.Computed {
+	recorder, ok := t.(interface{ IngestedBy() string })
+	if !ok || recorder.IngestedBy() == "" {
+		return columns
+	}
+
+	ingested, err := column.NewComputed(recorder.IngestedBy(), typeof.Int64, "make://ingestion", nil)
+	if err != nil {
+		return columns
+	}
+
+	computed := make([]column.Computed, 0, len(columns)+1)
+	computed = append(computed, columns...)
+	return append(computed, ingested)
+}
+
+// withoutColumns returns a copy of the schema without the timestamp columns
+func withoutColumns(schema typeof.Schema, columns config.Timestamps) *typeof.Schema {
+	out := make(typeof.Schema, len(schema))
+	for k, v := range schema {
+		if _, ok := columns[k]; !ok {
+			out[k] = v
+		}
+	}
+	return &out
+}
+
+// measureLag periodically measures, for each table, the age of the oldest row not flushed to the sinks yet, along
+// with the reuse of the rows decoded during the ingestion
+func (s *Server) measureLag(ctx context.Context) (interface{}, error) {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+			for _, t := range s.Tables() {
+				if age, ok := unflushedAge(t); ok {
+					s.monitor.Gauge(ctxTag, "ingest.unflushed", age.Seconds(), "table:"+t.Name())
+				}
 			}
+
+			// Report how many of the decoded rows had to be allocated rather than reused
+			acquired, allocated := block.PoolStats()
+			s.monitor.Gauge(ctxTag, "ingest.rows.acquired", float64(acquired))
+			s.monitor.Gauge(ctxTag, "ingest.rows.allocated", float64(allocated))
 		}
+	}
+}
+
+// AppendTo appends blocks which were already transformed, such as the aggregates of a downsampled table, to a
+// table of the local node. The blocks skip the pipelines and the streams, and are not forwarded to other nodes.
+func (s *Server) AppendTo(name string, blocks []block.Block) error {
+	t, err := s.getTable(name)
+	if err != nil {
+		return errors.NotFound(err.Error())
+	}
 
-		s.monitor.Count("server", fmt.Sprintf("%s.ingest.count", t.Name()), int64(len(blocks)))
+	appender, ok := t.(table.Appender)
+	if !ok {
+		return errors.InvalidArgument("table " + name + " does not support ingestion")
+	}
+
+	if err := s.evolve(name, blocks); err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		if err := appender.Append(b); err != nil {
+			return err
+		}
 	}
 
-	return nil, nil
+	s.monitor.Count(ctxTag, "append.blocks", int64(len(blocks)), "table:"+name)
+	return nil
+}
+
+// buffered returns the approximate size of the data of every table not flushed to the sinks yet, in bytes
+func (s *Server) buffered() (size int64) {
+	for _, t := range s.Tables() {
+		if b, ok := t.(interface{ Buffered() (int64, bool) }); ok {
+			n, _ := b.Buffered()
+			size += n
+		}
+	}
+	return
+}
+
+// unflushedAge returns the age of the oldest row of the table which was not flushed to the sinks yet
+func unflushedAge(t table.Table) (time.Duration, bool) {
+	f, ok := t.(interface{ Unflushed() (time.Time, bool) })
+	if !ok {
+		return 0, false
+	}
+
+	if at, ok := f.Unflushed(); ok {
+		return time.Since(at), true
+	}
+	return 0, true
 }
@@ -0,0 +1,626 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	client "github.com/kelindar/talaria/client/golang"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/statsd"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestIngest_Targets(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+	})
+
+	// Without metadata, every table is targeted
+	tables, err := s.targetsOf(context.Background(), nil, s.settings())
+	assert.NoError(t, err)
+	assert.Len(t, tables, 1)
+
+	// Tables can be qualified with the default schema
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tableMetadataKey, "data.nodes"))
+	tables, err = s.targetsOf(ctx, nil, s.settings())
+	assert.NoError(t, err)
+	assert.Len(t, tables, 1)
+	assert.Equal(t, "nodes", tables[0].Name())
+
+	// Unknown tables are rejected
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(tableMetadataKey, "team.nodes"))
+	_, err = s.targetsOf(ctx, nil, s.settings())
+	assert.Error(t, err)
+}
+
+// appendTable is a table with a dynamic schema, which records the appended blocks
+type appendTable struct {
+	nodes.Table
+	blocks []block.Block
+	closed bool
+}
+
+func (t *appendTable) Name() string                  { return "events" }
+func (t *appendTable) HashBy() string                { return "event" }
+func (t *appendTable) Schema() (typeof.Schema, bool) { return nil, false }
+func (t *appendTable) Append(b block.Block) error    { t.blocks = append(t.blocks, b); return nil }
+func (t *appendTable) Close() error                  { t.closed = true; return nil }
+
+func TestClose(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return &config.Config{} }, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	// The tables are flushed and closed, even if the server was never started
+	assert.NotPanics(t, s.Close)
+	assert.True(t, events.closed)
+}
+
+func TestIngest_Mask(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	conf := &config.Config{
+		Tables: config.Tables{
+			"events": {Masks: []config.Mask{
+				{Column: "phone", Func: "mask", Length: 2},
+				{Column: "email", Func: "truncate", Length: 1},
+			}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone,email\nclick,+6591234567,roman@grab.com\n")},
+	})
+
+	// The columns are masked before they are stored
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	columns, err := events.blocks[0].Select(events.blocks[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, "+********67", columns.LastRow()["phone"])
+	assert.Equal(t, "r", columns.LastRow()["email"])
+}
+
+func TestIngest_Enrich(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "cities.csv")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("city_id,city_name\n1,Singapore\n"), 0644))
+
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	conf := &config.Config{
+		Tables: config.Tables{
+			"events": {Enrich: []config.Lookup{{
+				Source:  "file:///" + path,
+				Key:     "city_id",
+				Columns: typeof.Schema{"city_name": typeof.String},
+			}}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,city_id\nclick,1\n")},
+	})
+
+	// The rows are joined with the dataset
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	columns, err := events.blocks[0].Select(events.blocks[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, "Singapore", columns.LastRow()["city_name"])
+}
+
+func TestIngest_Filter(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	conf := &config.Config{
+		Tables: config.Tables{
+			"events": {Filter: `
+			function main(row)
+				return row["event"] ~= "heartbeat"
+			end`},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,value\nheartbeat,1\nclick,2\nheartbeat,3\n")},
+	})
+
+	// Only the rows kept by the filter are appended
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	assert.Equal(t, "click", string(events.blocks[0].Key))
+}
+
+// sha256Of returns the hash of a value, as masked with sha256
+func sha256Of(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(h[:])
+}
+
+// staticTable represents a table with a static schema
+type staticTable struct {
+	appendTable
+	schema typeof.Schema
+}
+
+func (t *staticTable) Schema() (typeof.Schema, bool) { return t.schema, true }
+
+func TestIngest_Timestamps(t *testing.T) {
+	events := &staticTable{
+		appendTable: appendTable{Table: *nodes.New(new(testMembership))},
+		schema:      typeof.Schema{"event": typeof.String, "time": typeof.Int64},
+	}
+
+	conf := &config.Config{
+		Tables: config.Tables{
+			"events": {Timestamps: config.Timestamps{
+				"time": {Formats: []string{"rfc3339", "epoch"}},
+			}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,time\nclick,2020-01-01T08:00:00+08:00\nclick,1577836800000\nclick,1577836800\n")},
+	})
+
+	// The timestamps sent in different formats are all stored as unix seconds
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	columns, err := events.blocks[0].Select(typeof.Schema{"time": typeof.Int64})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, columns["time"].Count())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, int64(1577836800), columns["time"].At(i))
+	}
+}
+
+// strictTable represents a table with a static schema, which records the rows it rejects
+type strictTable struct {
+	staticTable
+	rejected []block.Row
+}
+
+func (t *strictTable) Reject(row block.Row) error { t.rejected = append(t.rejected, row); return nil }
+
+func TestIngest_Strict(t *testing.T) {
+	events := &strictTable{staticTable: staticTable{
+		appendTable: appendTable{Table: *nodes.New(new(testMembership))},
+		schema:      typeof.Schema{"event": typeof.String, "count": typeof.Int64, "amount": typeof.Float64},
+	}}
+
+	conf := &config.Config{
+		Tables: config.Tables{
+			"events": {Strict: &config.Strict{Required: []string{"count"}}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Json{Json: []byte(`
+			{"event": "click", "count": 1, "amount": 2}
+			{"event": "click", "count": "many"}
+			{"event": "click", "amount": 1.5}
+		`)},
+	})
+
+	// The valid rows are converted to the schema and the others are rejected, with the reason
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	columns, err := events.blocks[0].Select(events.schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, columns["count"].Count())
+	assert.Equal(t, 2.0, columns["amount"].At(0))
+
+	assert.Len(t, events.rejected, 2)
+	assert.Equal(t, "type", events.rejected[0].Values["_error_class"])
+	assert.Equal(t, "many", events.rejected[0].Values["count"])
+	assert.Equal(t, "missing", events.rejected[1].Values["_error_class"])
+	assert.Equal(t, "column count is required", events.rejected[1].Values["_error"])
+}
+
+// staticRing is a hash ring which assigns every key to the same replicas
+type staticRing []string
+
+func (r staticRing) Replicas(hash uint32) []string { return r }
+func (r staticRing) IsLocal(addr string) bool      { return addr == "local" }
+
+// newTestNode starts a node listening for gRPC on a specific address
+func newTestNode(t *testing.T, addr string, conf *config.Config) *appendTable {
+	lis, err := net.Listen("tcp", addr)
+	assert.NoError(t, err)
+
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	node := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	go node.server.Serve(lis)
+	return events
+}
+
+func TestIngest_Forward(t *testing.T) {
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+		Tables: config.Tables{
+			"events": {Masks: []config.Mask{{Column: "phone", Func: "mask", Length: 2}}},
+		},
+	}
+
+	// Start the node owning every key
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, lis.Close())
+	owned := newTestNode(t, fmt.Sprintf("127.0.0.1:%d", port), conf)
+
+	// Ingest on a node which owns nothing
+	conf.Writers.GRPC = &config.GRPC{Port: int32(port)}
+	local := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), local)
+	s.SetOwnership(staticRing{"127.0.0.1"})
+
+	_, err = s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\nview,+6591234568\n")},
+	})
+	assert.NoError(t, err)
+
+	// The rows are stored by the owner only, and transformed only once
+	assert.Len(t, local.blocks, 0)
+	assert.Len(t, owned.blocks, 2)
+	for _, b := range owned.blocks {
+		columns, err := b.Select(b.Schema())
+		assert.NoError(t, err)
+		assert.Contains(t, []string{"+********67", "+********68"}, columns.LastRow()["phone"])
+	}
+
+	// Replicate to a second node and keep a copy locally
+	replica := newTestNode(t, fmt.Sprintf("127.0.0.2:%d", port), conf)
+	s.SetOwnership(staticRing{"127.0.0.1", "127.0.0.2", "local"})
+	_, err = s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\n")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, owned.blocks, 3)
+	assert.Len(t, replica.blocks, 1)
+	assert.Len(t, local.blocks, 1)
+
+	// If the owner is unreachable, its rows are kept locally
+	s.SetOwnership(staticRing{"127.0.0.3"})
+	_, err = s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\n")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, local.blocks, 2)
+}
+
+// memberRing is a hash ring which assigns every key to all of its members, one of which is the local node
+type memberRing struct {
+	members []string
+	local   string
+}
+
+func (r *memberRing) Replicas(hash uint32) []string { return r.members }
+func (r *memberRing) IsLocal(addr string) bool      { return addr == r.local }
+func (r *memberRing) Members() []string             { return r.members }
+
+// newClusterNode starts a node of a cluster whose every member replicates every key
+func newClusterNode(t *testing.T, addr string, conf *config.Config, members ...string) (*Server, *appendTable) {
+	lis, err := net.Listen("tcp", addr)
+	assert.NoError(t, err)
+
+	host, _, _ := net.SplitHostPort(addr)
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	node := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	node.SetOwnership(&memberRing{members: members, local: host})
+	go node.server.Serve(lis)
+	return node, events
+}
+
+func TestIngest_ForwardOnce(t *testing.T) {
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+		Tables: config.Tables{
+			"events": {Masks: []config.Mask{{Column: "phone", Func: "sha256"}}},
+		},
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, lis.Close())
+
+	// Without authentication nor TLS, the members of the cluster are trusted by their address
+	conf.Writers.GRPC = &config.GRPC{Port: int32(port)}
+	owner, owned := newClusterNode(t, fmt.Sprintf("127.0.0.1:%d", port), conf, "127.0.0.1", "127.0.0.2")
+	_, replicated := newClusterNode(t, fmt.Sprintf("127.0.0.2:%d", port), conf, "127.0.0.1", "127.0.0.2")
+
+	_, err = owner.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\n")},
+	})
+	assert.NoError(t, err)
+
+	// The row is stored once by the owner and once by the replica, masked only once, and not forwarded back
+	for _, events := range []*appendTable{owned, replicated} {
+		assert.Len(t, events.blocks, 1)
+		for _, b := range events.blocks {
+			columns, err := b.Select(b.Schema())
+			assert.NoError(t, err)
+			assert.Equal(t, sha256Of("+6591234567"), columns.LastRow()["phone"])
+		}
+	}
+}
+
+func TestIngest_Replicate(t *testing.T) {
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, lis.Close())
+	owned := newTestNode(t, fmt.Sprintf("127.0.0.1:%d", port), conf)
+
+	// The nodes share a single client per peer, the others being closed
+	var out bytes.Buffer
+	conf.Writers.GRPC = &config.GRPC{Port: int32(port)}
+	local := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return conf }, monitor.New(logging.NewJSON(&out, logging.LevelDebug, nil), statsd.NewNoop(), "talaria", "test"), script.NewLoader(nil), local)
+	s.SetOwnership(staticRing{"127.0.0.1", "127.0.0.3"})
+
+	var wg sync.WaitGroup
+	peers := make([]*client.Client, 4)
+	for i := range peers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			peers[i], _ = s.peerOf("127.0.0.1")
+		}(i)
+	}
+	wg.Wait()
+	stored, _ := s.peers.Load("127.0.0.1")
+	for _, peer := range peers {
+		assert.Equal(t, stored, peer)
+	}
+
+	// A replica which can not be reached misses the rows, without failing the ingestion
+	_, err = s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event\nclick\n")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, owned.blocks, 1)
+	assert.Len(t, local.blocks, 0)
+	assert.Contains(t, out.String(), "unable to replicate 1 rows of events to 127.0.0.3")
+}
+
+func TestIngest_Untrusted(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config {
+		return &config.Config{
+			Tables: config.Tables{
+				"events": {Filter: `
+				function main(row)
+					return row["event"] ~= "view"
+				end`},
+			},
+		}
+	}, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	// Without a ring, or from a client which is not a node, the rows marked as forwarded are still filtered
+	request := &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,value\nclick,1\nview,2\n")},
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedMetadataKey, "true"))
+	_, err := s.Ingest(ctx, request)
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 1)
+	assert.Equal(t, "click", string(events.blocks[0].Key))
+
+	s.SetOwnership(staticRing{"local"})
+	_, err = s.Ingest(ctx, request)
+	assert.NoError(t, err)
+	assert.Len(t, events.blocks, 2)
+	assert.Equal(t, "click", string(events.blocks[1].Key))
+	assert.False(t, isForwarded(s.trustForwarded(ctx, s.settings(), nil)))
+	assert.True(t, isForwarded(s.trustForwarded(ctx, s.settings(), &auth.Identity{Name: "cluster", Internal: true})))
+}
+
+func TestIngest_Auth(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config {
+		return &config.Config{
+			Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+			Writers: config.Writers{Auth: &config.Auth{
+				Token: "cluster",
+				Keys: []config.APIKey{
+					{Name: "events", Key: "key-1", Tables: []string{"events"}},
+					{Name: "nodes", Key: "key-2", Tables: []string{"nodes"}},
+				},
+			}},
+		}
+	}, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	request := &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\n")},
+	}
+
+	tests := []struct {
+		pairs  []string
+		blocks int
+		err    bool
+	}{
+		{pairs: nil, err: true},
+		{pairs: []string{authMetadataKey, "Bearer wrong"}, err: true},
+		{pairs: []string{authMetadataKey, "Bearer key-2", tableMetadataKey, "events"}, err: true},
+		{pairs: []string{authMetadataKey, "Bearer key-1", forwardedMetadataKey, "true"}, err: true},
+		{pairs: []string{authMetadataKey, "Bearer key-2"}, blocks: 0},
+		{pairs: []string{authMetadataKey, "Bearer key-1"}, blocks: 1},
+		{pairs: []string{authMetadataKey, "Bearer key-1", tableMetadataKey, "events"}, blocks: 2},
+		{pairs: []string{authMetadataKey, "Bearer cluster", forwardedMetadataKey, "true"}, blocks: 3},
+	}
+
+	for _, tc := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tc.pairs...))
+		_, err := s.Ingest(ctx, request)
+		assert.Equal(t, tc.err, err != nil, tc.pairs)
+		if !tc.err {
+			assert.Len(t, events.blocks, tc.blocks, tc.pairs)
+		}
+	}
+}
+
+func TestIngest_HTTP(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config {
+		return &config.Config{
+			Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+			Writers: config.Writers{Auth: &config.Auth{
+				Keys: []config.APIKey{
+					{Name: "events", Key: "key-1", Tables: []string{"events"}},
+					{Name: "nodes", Key: "key-2", Tables: []string{"nodes"}},
+				},
+			}},
+		}
+	}, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	tests := []struct {
+		token       string
+		url         string
+		contentType string
+		status      int
+	}{
+		{token: "wrong", url: "/v1/ingest", contentType: "text/csv", status: http.StatusUnauthorized},
+		{token: "key-2", url: "/v1/ingest?table=events", contentType: "text/csv", status: http.StatusForbidden},
+		{token: "key-1", url: "/v1/ingest", contentType: "application/xml", status: http.StatusBadRequest},
+		{token: "key-1", url: "/v1/ingest?table=events", contentType: "text/csv", status: http.StatusNoContent},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader("event,phone\nclick,+6591234567\n"))
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		s.handleIngest(w, r)
+		assert.Equal(t, tc.status, w.Code, tc.url)
+	}
+
+	assert.Len(t, events.blocks, 1)
+}
+
+func TestIngest_Quota(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config {
+		return &config.Config{
+			Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+			Writers: config.Writers{Quotas: map[string]config.Quota{
+				"*": {Rate: 1, Burst: 2},
+			}},
+		}
+	}, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	ingest := func(addr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/ingest?table=events", strings.NewReader("event\na\nb\nc\n"))
+		r.RemoteAddr = addr
+		r.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		s.handleIngest(w, r)
+		return w.Code
+	}
+
+	// The first request exceeds the burst, so the producer is rejected until it is paid back
+	assert.Equal(t, http.StatusNoContent, ingest("10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, ingest("10.0.0.1:5678"))
+
+	// Each producer has its own quota
+	assert.Equal(t, http.StatusNoContent, ingest("10.0.0.2:1234"))
+	assert.Len(t, events.blocks, 6) // One block per event, hashed by the event
+}
+
+func TestIngest_Checksum(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config {
+		return &config.Config{
+			Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+		}
+	}, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	data := "event,phone\nclick,+6591234567\n"
+	sum := fmt.Sprintf("%08x", talaria.Checksum(&talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte(data)},
+	}))
+
+	tests := []struct {
+		checksum string
+		status   int
+	}{
+		{checksum: "", status: http.StatusNoContent},
+		{checksum: sum, status: http.StatusNoContent},
+		{checksum: "deadbeef", status: http.StatusBadRequest},
+		{checksum: "invalid", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/ingest?table=events", strings.NewReader(data))
+		r.Header.Set("Content-Type", "text/csv")
+		r.Header.Set("Talaria-Checksum", tc.checksum)
+		w := httptest.NewRecorder()
+		s.handleIngest(w, r)
+		assert.Equal(t, tc.status, w.Code, tc.checksum)
+	}
+
+	assert.Len(t, events.blocks, 2)
+
+	// The checksum of a batch does not depend on the order of its maps
+	batch := &talaria.IngestRequest{Data: &talaria.IngestRequest_Batch{Batch: &talaria.Batch{
+		Strings: map[uint32][]byte{1: []byte("event"), 2: []byte("click"), 3: []byte("phone"), 4: []byte("+6591234567")},
+		Events: []*talaria.Event{{Value: map[uint32]*talaria.Value{
+			1: {Value: &talaria.Value_String_{String_: 2}},
+			3: {Value: &talaria.Value_String_{String_: 4}},
+		}}},
+	}}}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		tableMetadataKey, "events",
+		sumMetadataKey, fmt.Sprintf("%08x", talaria.Checksum(batch)),
+	))
+
+	_, err := s.Ingest(ctx, batch)
+	assert.NoError(t, err)
+
+	batch.GetBatch().Strings[2] = []byte("view")
+	_, err = s.Ingest(ctx, batch)
+	assert.Error(t, err)
+}
@@ -0,0 +1,299 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/kelindar/talaria/internal/encoding/otlp"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	talaria "github.com/kelindar/talaria/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// The attribute of the resource naming the service which produced the telemetry
+const serviceAttribute = "service.name"
+
+// traceServer represents the trace service of the OpenTelemetry collector
+type traceServer interface {
+	ExportTraces(context.Context, *otlp.TracesRequest) (*otlp.Response, error)
+}
+
+// logsServer represents the logs service of the OpenTelemetry collector
+type logsServer interface {
+	ExportLogs(context.Context, *otlp.LogsRequest) (*otlp.Response, error)
+}
+
+// The gRPC services of the OpenTelemetry collector, which are served along with the ingress
+var (
+	traceServiceDesc = grpc.ServiceDesc{
+		ServiceName: otlp.TraceService,
+		HandlerType: (*traceServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Export",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(otlp.TracesRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(traceServer).ExportTraces(ctx, in)
+				}
+
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + otlp.TraceService + "/Export"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(traceServer).ExportTraces(ctx, req.(*otlp.TracesRequest))
+				})
+			},
+		}},
+		Streams:  []grpc.StreamDesc{},
+		Metadata: "opentelemetry/proto/collector/trace/v1/trace_service.proto",
+	}
+
+	logsServiceDesc = grpc.ServiceDesc{
+		ServiceName: otlp.LogsService,
+		HandlerType: (*logsServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Export",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(otlp.LogsRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(logsServer).ExportLogs(ctx, in)
+				}
+
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + otlp.LogsService + "/Export"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(logsServer).ExportLogs(ctx, req.(*otlp.LogsRequest))
+				})
+			},
+		}},
+		Streams:  []grpc.StreamDesc{},
+		Metadata: "opentelemetry/proto/collector/logs/v1/logs_service.proto",
+	}
+)
+
+// ExportTraces ingests the spans sent by an OpenTelemetry exporter over gRPC into the configured table
+func (s *Server) ExportTraces(ctx context.Context, request *otlp.TracesRequest) (*otlp.Response, error) {
+	conf := s.conf().Writers.OTLP
+	if conf == nil || conf.Traces == "" {
+		return nil, errors.Unimplemented("the ingestion of the traces is not enabled on this node")
+	}
+
+	if err := s.ingestTelemetry(ctx, conf.Traces, "otlp.spans", spansOf(request)); err != nil {
+		return nil, err
+	}
+	return new(otlp.Response), nil
+}
+
+// ExportLogs ingests the log records sent by an OpenTelemetry exporter over gRPC into the configured table
+func (s *Server) ExportLogs(ctx context.Context, request *otlp.LogsRequest) (*otlp.Response, error) {
+	conf := s.conf().Writers.OTLP
+	if conf == nil || conf.Logs == "" {
+		return nil, errors.Unimplemented("the ingestion of the logs is not enabled on this node")
+	}
+
+	if err := s.ingestTelemetry(ctx, conf.Logs, "otlp.logs", logsOf(request)); err != nil {
+		return nil, err
+	}
+	return new(otlp.Response), nil
+}
+
+// handleTraces ingests the spans sent by an OpenTelemetry exporter over HTTP into the configured table
+func (s *Server) handleTraces(w http.ResponseWriter, r *http.Request) {
+	conf := s.conf().Writers.OTLP
+	if conf == nil || conf.Traces == "" {
+		writeError(w, errors.Unimplemented("the ingestion of the traces is not enabled on this node"))
+		return
+	}
+
+	body, err := readTelemetry(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var request otlp.TracesRequest
+	if err := request.Unmarshal(body); err != nil {
+		writeError(w, errors.InvalidArgument("unable to decode the body: "+err.Error()))
+		return
+	}
+
+	if err := s.ingestTelemetry(telemetryContext(r), conf.Traces, "otlp.spans", spansOf(&request)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleLogs ingests the log records sent by an OpenTelemetry exporter over HTTP into the configured table
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	conf := s.conf().Writers.OTLP
+	if conf == nil || conf.Logs == "" {
+		writeError(w, errors.Unimplemented("the ingestion of the logs is not enabled on this node"))
+		return
+	}
+
+	body, err := readTelemetry(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var request otlp.LogsRequest
+	if err := request.Unmarshal(body); err != nil {
+		writeError(w, errors.InvalidArgument("unable to decode the body: "+err.Error()))
+		return
+	}
+
+	if err := s.ingestTelemetry(telemetryContext(r), conf.Logs, "otlp.logs", logsOf(&request)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// readTelemetry reads the protobuf body of an OpenTelemetry export request, which may be compressed with gzip
+func readTelemetry(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/x-protobuf") {
+		return nil, errors.InvalidArgument("unsupported content type " + ct + ", only protobuf is supported")
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxIngestSize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, errors.InvalidArgument("unable to decompress the body: " + err.Error())
+		}
+		defer reader.Close()
+		body = io.LimitReader(reader, maxIngestSize)
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.InvalidArgument("unable to read the body: " + err.Error())
+	}
+	return b, nil
+}
+
+// telemetryContext passes the token and the address of an HTTP exporter the same way as the gRPC ingress
+func telemetryContext(r *http.Request) context.Context {
+	md := metadata.Pairs(authMetadataKey, r.Header.Get("Authorization"))
+	return peer.NewContext(metadata.NewIncomingContext(r.Context(), md), &peer.Peer{Addr: httpAddr(r.RemoteAddr)})
+}
+
+// ingestTelemetry ingests a batch of telemetry into a table, through the same authentication, quotas and pipeline
+// as the other ingestion requests.
+func (s *Server) ingestTelemetry(ctx context.Context, table, metric string, batch *talaria.Batch) error {
+	if len(batch.Events) == 0 {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(tableMetadataKey, table)
+	if _, err := s.Ingest(metadata.NewIncomingContext(ctx, md), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Batch{Batch: batch},
+	}); err != nil {
+		return err
+	}
+
+	s.monitor.Count(ctxTag, metric, int64(len(batch.Events)), "table:"+table)
+	return nil
+}
+
+// spansOf converts the spans of an export request into a batch of events, a row per span with its identifiers in
+// hexadecimal, its start time in unix nanoseconds and its duration in nanoseconds.
+func spansOf(request *otlp.TracesRequest) *talaria.Batch {
+	builder := newBatchBuilder()
+	for _, rs := range request.ResourceSpans {
+		resource, service := resourceOf(&rs.Resource)
+		for _, ss := range rs.ScopeSpans {
+			for i := range ss.Spans {
+				span := &ss.Spans[i]
+				builder.append(map[string]interface{}{
+					"trace_id":       hex.EncodeToString(span.TraceID),
+					"span_id":        hex.EncodeToString(span.SpanID),
+					"parent_span_id": hex.EncodeToString(span.ParentSpanID),
+					"name":           span.Name,
+					"kind":           span.KindName(),
+					"service":        service,
+					"scope":          ss.Scope.Name,
+					"time":           int64(span.StartTimeUnixNano),
+					"duration":       int64(span.EndTimeUnixNano) - int64(span.StartTimeUnixNano),
+					"status":         span.StatusName(),
+					"status_message": span.Status.Message,
+					"attributes":     jsonOf(otlp.Map(span.Attributes)),
+					"resource":       resource,
+				})
+			}
+		}
+	}
+	return builder.batch
+}
+
+// logsOf converts the log records of an export request into a batch of events, a row per log record with its time
+// in unix nanoseconds, the time it was observed at if it has none, and its body as a string.
+func logsOf(request *otlp.LogsRequest) *talaria.Batch {
+	builder := newBatchBuilder()
+	for _, rl := range request.ResourceLogs {
+		resource, service := resourceOf(&rl.Resource)
+		for _, sl := range rl.ScopeLogs {
+			for i := range sl.LogRecords {
+				record := &sl.LogRecords[i]
+				at := record.TimeUnixNano
+				if at == 0 {
+					at = record.ObservedTimeUnixNano
+				}
+
+				body, ok := record.Body.(string)
+				if !ok && record.Body != nil {
+					body = string(jsonOf(otlp.Plain(record.Body)))
+				}
+
+				builder.append(map[string]interface{}{
+					"trace_id":        hex.EncodeToString(record.TraceID),
+					"span_id":         hex.EncodeToString(record.SpanID),
+					"time":            int64(at),
+					"severity":        record.SeverityText,
+					"severity_number": int64(record.SeverityNumber),
+					"body":            body,
+					"service":         service,
+					"scope":           sl.Scope.Name,
+					"attributes":      jsonOf(otlp.Map(record.Attributes)),
+					"resource":        resource,
+				})
+			}
+		}
+	}
+	return builder.batch
+}
+
+// resourceOf returns the attributes of a resource as JSON, and the name of its service
+func resourceOf(resource *otlp.Resource) (json.RawMessage, string) {
+	attributes := otlp.Map(resource.Attributes)
+	service, _ := attributes[serviceAttribute].(string)
+	return jsonOf(attributes), service
+}
+
+// jsonOf encodes a plain value in JSON
+func jsonOf(v interface{}) json.RawMessage {
+	encoded, _ := json.Marshal(v)
+	return encoded
+}
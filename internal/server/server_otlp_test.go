@@ -0,0 +1,161 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/otlp"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// spansTable is a table of spans, hashed by their trace
+type spansTable struct {
+	appendTable
+}
+
+func (t *spansTable) Name() string   { return "spans" }
+func (t *spansTable) HashBy() string { return "trace_id" }
+
+// logsTable is a table of log records, hashed by their service
+type logsTable struct {
+	appendTable
+}
+
+func (t *logsTable) Name() string   { return "logs" }
+func (t *logsTable) HashBy() string { return "service" }
+
+func TestOTLP(t *testing.T) {
+	spans := &spansTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	logs := &logsTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+		Writers: config.Writers{
+			OTLP: &config.OTLP{Traces: "spans", Logs: "logs"},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), spans, logs)
+	call := func(handler http.HandlerFunc, body []byte, gzipped bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-protobuf")
+		if gzipped {
+			var buffer bytes.Buffer
+			writer := gzip.NewWriter(&buffer)
+			_, _ = writer.Write(body)
+			_ = writer.Close()
+			r = httptest.NewRequest(http.MethodPost, "/", &buffer)
+			r.Header.Set("Content-Encoding", "gzip")
+		}
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	traces := otlp.TracesRequest{ResourceSpans: []otlp.ResourceSpans{{
+		Resource: otlp.Resource{Attributes: []otlp.KeyValue{{Key: "service.name", Value: "checkout"}}},
+		ScopeSpans: []otlp.ScopeSpans{{
+			Scope: otlp.Scope{Name: "net/http"},
+			Spans: []otlp.Span{{
+				TraceID:           []byte{0xab, 0xcd},
+				SpanID:            []byte{0x01},
+				Name:              "GET /cart",
+				Kind:              2,
+				StartTimeUnixNano: 1600000000000000000,
+				EndTimeUnixNano:   1600000000250000000,
+				Attributes:        []otlp.KeyValue{{Key: "http.status_code", Value: int64(200)}},
+				Status:            otlp.Status{Code: 2, Message: "timeout"},
+			}},
+		}},
+	}}}
+
+	// A row is ingested per span
+	body, _ := traces.Marshal()
+	assert.Equal(t, http.StatusOK, call(s.handleTraces, body, true).Code)
+	assert.Len(t, spans.blocks, 1)
+
+	columns, err := spans.blocks[0].Select(spans.blocks[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"trace_id":       "abcd",
+		"span_id":        "01",
+		"name":           "GET /cart",
+		"kind":           "server",
+		"service":        "checkout",
+		"scope":          "net/http",
+		"time":           int64(1600000000000000000),
+		"duration":       int64(250000000),
+		"status":         "error",
+		"status_message": "timeout",
+		"attributes":     json.RawMessage(`{"http.status_code":200}`),
+		"resource":       json.RawMessage(`{"service.name":"checkout"}`),
+	}, columns.LastRow())
+
+	// A row is ingested per log record, over gRPC
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() { _ = s.server.Serve(lis) }()
+	defer s.server.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	records := otlp.LogsRequest{ResourceLogs: []otlp.ResourceLogs{{
+		Resource: otlp.Resource{Attributes: []otlp.KeyValue{{Key: "service.name", Value: "checkout"}}},
+		ScopeLogs: []otlp.ScopeLogs{{
+			LogRecords: []otlp.LogRecord{{
+				ObservedTimeUnixNano: 1600000000000000000,
+				SeverityNumber:       17,
+				SeverityText:         "ERROR",
+				Body:                 map[string]interface{}{"message": "declined"},
+				TraceID:              []byte{0xab, 0xcd},
+			}},
+		}},
+	}}}
+
+	assert.NoError(t, conn.Invoke(context.Background(), "/"+otlp.LogsService+"/Export", &records, new(otlp.Response)))
+	assert.Len(t, logs.blocks, 1)
+
+	columns, err = logs.blocks[0].Select(logs.blocks[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"trace_id":        "abcd",
+		"time":            int64(1600000000000000000),
+		"severity":        "ERROR",
+		"severity_number": int64(17),
+		"body":            `{"message":"declined"}`,
+		"service":         "checkout",
+		"attributes":      json.RawMessage(`{}`),
+		"resource":        json.RawMessage(`{"service.name":"checkout"}`),
+	}, columns.LastRow())
+
+	// The invalid requests are rejected
+	assert.Equal(t, http.StatusBadRequest, call(s.handleLogs, []byte{0x0a, 0xff}, false).Code)
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.handleTraces(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	conf.Writers.OTLP.Traces = ""
+	assert.Equal(t, http.StatusNotImplemented, call(s.handleTraces, body, false).Code)
+	assert.Error(t, conn.Invoke(context.Background(), "/"+otlp.TraceService+"/Export", &traces, new(otlp.Response)))
+}
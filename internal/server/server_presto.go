@@ -8,11 +8,41 @@ import (
 
 	"github.com/kelindar/talaria/internal/monitor/errors"
 	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
 )
 
 // PrestoGetIndexSplits returns a batch of index splits for the given batch of keys.
 func (s *Server) PrestoGetIndexSplits(schemaTableName *presto.PrestoThriftSchemaTableName, indexColumnNames []string, outputColumnNames []string, keys *presto.PrestoThriftPageResult, outputConstraint *presto.PrestoThriftTupleDomain, maxSplitCount int32, nextToken *presto.PrestoThriftNullableToken) (*presto.PrestoThriftSplitBatch, error) {
-	return nil, nil
+	defer s.handlePanic()
+	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:get_index_splits")
+
+	// Retrieve the table
+	table, err := s.getTable(s.qualify(schemaTableName.SchemaName, schemaTableName.TableName))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the lookups on the hash key are supported
+	if len(indexColumnNames) != 1 || indexColumnNames[0] != table.HashBy() || keys == nil || len(keys.ColumnBlocks) != 1 {
+		return nil, errors.InvalidArgument("index lookups are only supported on the hash key")
+	}
+
+	// Constrain the hash key to the batch of keys provided
+	domain := &presto.PrestoThriftTupleDomain{Domains: map[string]*presto.PrestoThriftDomain{}}
+	if outputConstraint != nil {
+		for k, v := range outputConstraint.Domains {
+			domain.Domains[k] = v
+		}
+	}
+	domain.Domains[table.HashBy()] = presto.KeyDomain(keys.ColumnBlocks[0])
+
+	// Get the splits
+	splits, err := s.getSplits(table, outputColumnNames, domain, int(maxSplitCount))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toThriftSplits(table.Name(), splits), nil
 }
 
 // PrestoGetSplits returns a batch of splits.
@@ -21,7 +51,7 @@ func (s *Server) PrestoGetSplits(schemaTableName *presto.PrestoThriftSchemaTable
 	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:get_splits")
 
 	// Retrieve the table
-	table, err := s.getTable(schemaTableName.TableName)
+	table, err := s.getTable(s.qualify(schemaTableName.SchemaName, schemaTableName.TableName))
 	if err != nil {
 		return nil, err
 	}
@@ -35,16 +65,21 @@ func (s *Server) PrestoGetSplits(schemaTableName *presto.PrestoThriftSchemaTable
 	}
 
 	// Get the splits
-	splits, err := table.GetSplits(columns, outputConstraint, int(maxSplitCount))
+	splits, err := s.getSplits(table, columns, outputConstraint, int(maxSplitCount))
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert the response to Presto response
+	return s.toThriftSplits(table.Name(), splits), nil
+}
+
+// toThriftSplits converts the splits of a table to a Presto split batch
+func (s *Server) toThriftSplits(tableName string, splits []table.Split) *presto.PrestoThriftSplitBatch {
 	batch := new(presto.PrestoThriftSplitBatch)
 	for _, split := range splits {
 		tsplit := &presto.PrestoThriftSplit{
-			SplitId: encodeThriftID(table.Name(), []byte(split.Key)),
+			SplitId: encodeThriftID(tableName, []byte(split.Key)),
 			Hosts:   make([]*presto.PrestoThriftHostAddress, 0, len(split.Addrs)),
 		}
 
@@ -53,7 +88,7 @@ func (s *Server) PrestoGetSplits(schemaTableName *presto.PrestoThriftSchemaTable
 		}
 		batch.Splits = append(batch.Splits, tsplit)
 	}
-	return batch, nil
+	return batch
 }
 
 // PrestoGetTableMetadata returns metadata for a given table.
@@ -62,7 +97,7 @@ func (s *Server) PrestoGetTableMetadata(schemaTableName *presto.PrestoThriftSche
 	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:get_table_metadata")
 
 	// Retrieve the table
-	table, err := s.getTable(schemaTableName.TableName)
+	table, err := s.getTable(s.qualify(schemaTableName.SchemaName, schemaTableName.TableName))
 	if err != nil {
 		return nil, err
 	}
@@ -79,11 +114,19 @@ func (s *Server) PrestoGetTableMetadata(schemaTableName *presto.PrestoThriftSche
 		})
 	}
 
+	// Tables partitioned by a hash key can be used for index lookups
+	var indexableKeys []map[string]struct{}
+	if hashBy := table.HashBy(); hashBy != "" {
+		indexableKeys = append(indexableKeys, map[string]struct{}{hashBy: {}})
+	}
+
 	// Prepare metadata result
+	schemaName, tableName := s.SchemaOf(table.Name())
 	return &presto.PrestoThriftNullableTableMetadata{
 		TableMetadata: &presto.PrestoThriftTableMetadata{
-			SchemaTableName: &presto.PrestoThriftSchemaTableName{SchemaName: s.conf().Readers.Presto.Schema, TableName: table.Name()},
+			SchemaTableName: &presto.PrestoThriftSchemaTableName{SchemaName: schemaName, TableName: tableName},
 			Columns:         columns,
+			IndexableKeys:   indexableKeys,
 		},
 	}, nil
 }
@@ -93,7 +136,14 @@ func (s *Server) PrestoListSchemaNames() ([]string, error) {
 	defer s.handlePanic()
 	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:get_schemas")
 
-	return []string{s.conf().Readers.Presto.Schema}, nil
+	// Collect the distinct schemas of the tables, with the default one first
+	schemas := []string{s.conf().Readers.Presto.Schema}
+	for _, table := range s.Tables() {
+		if schema, _ := s.SchemaOf(table.Name()); !contains(schemas, schema) {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas, nil
 }
 
 // PrestoListTables returns tables for the given schema name.
@@ -101,12 +151,17 @@ func (s *Server) PrestoListTables(schemaNameOrNull *presto.PrestoThriftNullableS
 	defer s.handlePanic()
 	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:get_tables")
 
-	// Return all of the tables configured in the server
-	tables := make([]*presto.PrestoThriftSchemaTableName, 0, len(s.tables))
-	for _, table := range s.tables {
+	// Return all of the tables configured in the server, optionally filtered by schema
+	var tables []*presto.PrestoThriftSchemaTableName
+	for _, table := range s.Tables() {
+		schemaName, tableName := s.SchemaOf(table.Name())
+		if schemaNameOrNull != nil && schemaNameOrNull.SchemaName != nil && *schemaNameOrNull.SchemaName != schemaName {
+			continue
+		}
+
 		tables = append(tables, &presto.PrestoThriftSchemaTableName{
-			SchemaName: s.conf().Readers.Presto.Schema,
-			TableName:  table.Name(),
+			SchemaName: schemaName,
+			TableName:  tableName,
 		})
 	}
 	return tables, nil
@@ -129,16 +184,46 @@ func (s *Server) PrestoGetRows(splitID *presto.PrestoThriftId, columns []string,
 		return nil, errors.Internal("unable to retrieve a table", err)
 	}
 
+	// Once the local pages are read, the remaining ones are read from the owner of the split
+	if id.Owner != "" {
+		return s.proxyRows(id, columns, maxBytes)
+	}
+
+	// Wait for the node to admit the request, Presto retries the rejected ones
+	release, err := s.admit(maxBytes)
+	if err != nil {
+		return nil, &presto.PrestoThriftServiceException{Message: err.Error(), Retryable: true}
+	}
+	defer release()
+
 	// Retrieve the rows for the table
 	result := new(presto.PrestoThriftPageResult)
-	page, err := table.GetRows(id.Split, columns, maxBytes)
+	page, err := s.getRows(table, id.Split, columns, maxBytes)
 	if err != nil {
 		return nil, errors.Internal("unable to get rows from a table", err)
 	}
 
-	// If a page has a token, we need to create a split to continue iterating
-	if page.NextToken != nil {
-		result.NextToken = encodeThriftID(table.Name(), page.NextToken)
+	// If a page has a token, we need to create a split to continue iterating. Once the local pages are read,
+	// the split continues on its owner if this node does not store the key, so the result is not partial.
+	origin := id.Origin
+	if origin == nil {
+		origin = id.Split
+	}
+
+	switch owner, remote := s.ownerOf(table, origin, id.Proxied); {
+	case page.NextToken != nil:
+		result.NextToken = &presto.PrestoThriftId{Id: encodeSplitID(&SplitID{
+			Table:   table.Name(),
+			Split:   page.NextToken,
+			Origin:  origin,
+			Proxied: id.Proxied,
+		})}
+	case remote:
+		result.NextToken = &presto.PrestoThriftId{Id: encodeSplitID(&SplitID{
+			Table: table.Name(),
+			Split: origin,
+			Owner: owner,
+		})}
 	}
 
 	// Return the result set
@@ -148,3 +233,13 @@ func (s *Server) PrestoGetRows(splitID *presto.PrestoThriftId, columns []string,
 	}
 	return result, nil
 }
+
+// contains checks whether the value is in the slice
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
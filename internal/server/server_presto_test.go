@@ -0,0 +1,231 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/kelindar/talaria/internal/table/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresto_Schemas(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+	})
+	s.Register(system.New(s, new(testMembership))...)
+
+	// Both the default and the system schemas should be listed
+	schemas, err := s.PrestoListSchemaNames()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data", "system"}, schemas)
+
+	// Tables are filtered by schema
+	name := "system"
+	tables, err := s.PrestoListTables(&presto.PrestoThriftNullableSchemaName{SchemaName: &name})
+	assert.NoError(t, err)
+	assert.Len(t, tables, 5)
+	assert.Equal(t, "columns", tables[0].TableName)
+
+	// Metadata is resolved through the schema
+	meta, err := s.PrestoGetTableMetadata(&presto.PrestoThriftSchemaTableName{SchemaName: "system", TableName: "nodes"})
+	assert.NoError(t, err)
+	assert.Equal(t, "system", meta.TableMetadata.SchemaTableName.SchemaName)
+	assert.Equal(t, "nodes", meta.TableMetadata.SchemaTableName.TableName)
+
+	meta, err = s.PrestoGetTableMetadata(&presto.PrestoThriftSchemaTableName{SchemaName: "data", TableName: "nodes"})
+	assert.NoError(t, err)
+	assert.Equal(t, "data", meta.TableMetadata.SchemaTableName.SchemaName)
+	assert.Len(t, meta.TableMetadata.Columns, 7)
+}
+
+// hashedTable is a table partitioned by a hash key, which records the last constraint
+type hashedTable struct {
+	nodes.Table
+	domain *presto.PrestoThriftTupleDomain
+}
+
+func (t *hashedTable) Name() string   { return "events" }
+func (t *hashedTable) HashBy() string { return "event" }
+func (t *hashedTable) GetSplits(_ []string, domain *presto.PrestoThriftTupleDomain, _ int) ([]table.Split, error) {
+	t.domain = domain
+	return []table.Split{{Key: []byte{1}, Addrs: []string{"127.0.0.1"}}}, nil
+}
+
+func TestPresto_IndexSplits(t *testing.T) {
+	events := &hashedTable{Table: *nodes.New(new(testMembership))}
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data", Port: 8042},
+		},
+	})
+	s.Register(events)
+
+	// The hash key is indexable
+	meta, err := s.PrestoGetTableMetadata(&presto.PrestoThriftSchemaTableName{SchemaName: "data", TableName: "events"})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]struct{}{{"event": {}}}, meta.TableMetadata.IndexableKeys)
+
+	keys := &presto.PrestoThriftPageResult{
+		ColumnBlocks: []*presto.PrestoThriftBlock{{
+			VarcharData: &presto.PrestoThriftVarchar{
+				Nulls: []bool{false, false},
+				Sizes: []int32{1, 1},
+				Bytes: []byte("ab"),
+			},
+		}},
+		RowCount: 2,
+	}
+
+	// Lookups by the hash key are turned into splits
+	name := &presto.PrestoThriftSchemaTableName{SchemaName: "data", TableName: "events"}
+	batch, err := s.PrestoGetIndexSplits(name, []string{"event"}, []string{"address"}, keys, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Splits, 1)
+	assert.Equal(t, int32(8042), batch.Splits[0].Hosts[0].Port)
+	assert.Len(t, events.domain.Domains["event"].ValueSet.RangeValueSet.Ranges, 2)
+
+	// Lookups by other columns are rejected
+	_, err = s.PrestoGetIndexSplits(name, []string{"address"}, []string{"address"}, keys, nil, 10, nil)
+	assert.Error(t, err)
+}
+
+func TestPresto_Admission(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto:    &config.Presto{Schema: "data"},
+			Admission: &config.Admission{Splits: 1},
+		},
+	})
+
+	split := encodeThriftID("nodes", []byte{0x00})
+	token := new(presto.PrestoThriftNullableToken)
+	page, err := s.PrestoGetRows(split, []string{"address"}, 1024, token)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), page.RowCount)
+
+	// While another split is being served, the request is rejected as retryable
+	release, err := s.admission.Acquire(1024)
+	assert.NoError(t, err)
+	defer release()
+
+	_, err = s.PrestoGetRows(split, []string{"address"}, 1024, token)
+	assert.IsType(t, new(presto.PrestoThriftServiceException), err)
+	assert.True(t, err.(*presto.PrestoThriftServiceException).Retryable)
+}
+
+// ownedTable is a table whose splits all read the same hash key
+type ownedTable struct {
+	nodes.Table
+}
+
+func (t *ownedTable) Name() string                       { return "events" }
+func (t *ownedTable) HashOf(split []byte) (uint32, bool) { return 1, true }
+
+func TestPresto_Proxy(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, lis.Close())
+
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data", Port: int32(port)},
+		},
+	}
+
+	// Start the owner, which reports its own address
+	owner := newTestServer(conf)
+	owner.Register(&ownedTable{Table: *nodes.New(adminMembership{"127.0.0.1", "owner"})})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go presto.Serve(ctx, int32(port), owner, nil)
+
+	s := newTestServer(conf)
+	s.Register(&ownedTable{Table: *nodes.New(new(testMembership))})
+	s.SetOwnership(staticRing{"127.0.0.1"})
+
+	// The local rows are read first, followed by the ones of the owner
+	var addrs []string
+	split := encodeThriftID("events", []byte{0x00})
+	token := new(presto.PrestoThriftNullableToken)
+	for i := 0; i < 2; i++ {
+		var page *presto.PrestoThriftPageResult
+		assert.Eventually(t, func() bool {
+			page, err = s.PrestoGetRows(split, []string{"address"}, 1024, token)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond)
+
+		assert.Equal(t, int32(1), page.RowCount)
+		addrs = append(addrs, string(page.ColumnBlocks[0].VarcharData.Bytes))
+		token = &presto.PrestoThriftNullableToken{Token: page.NextToken}
+	}
+
+	assert.Equal(t, []string{"127.0.0.1", "owner:7946"}, addrs)
+	assert.Nil(t, token.Token)
+
+	// The rows are not proxied if the node is one of the replicas
+	s.SetOwnership(staticRing{"127.0.0.1", "local"})
+	page, err := s.PrestoGetRows(split, []string{"address"}, 1024, new(presto.PrestoThriftNullableToken))
+	assert.NoError(t, err)
+	assert.Nil(t, page.NextToken)
+}
+
+func TestPresto_ACL(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+			ACL: []config.Access{{
+				Identity: "analyst",
+				Tables:   []string{"nodes"},
+				Columns:  map[string][]string{"nodes": {"address"}},
+			}},
+		},
+	})
+
+	name := "data"
+	table := &presto.PrestoThriftSchemaTableName{SchemaName: "data", TableName: "nodes"}
+	split := encodeThriftID("nodes", []byte{0x00})
+	analyst := &session{Server: s, identity: &auth.Identity{Name: "analyst"}}
+
+	// The analyst only sees the columns it can query
+	tables, err := analyst.PrestoListTables(&presto.PrestoThriftNullableSchemaName{SchemaName: &name})
+	assert.NoError(t, err)
+	assert.Len(t, tables, 1)
+
+	meta, err := analyst.PrestoGetTableMetadata(table)
+	assert.NoError(t, err)
+	assert.Len(t, meta.TableMetadata.Columns, 1)
+	assert.Equal(t, "address", meta.TableMetadata.Columns[0].Name)
+
+	_, err = analyst.PrestoGetRows(split, []string{"address"}, 1024, new(presto.PrestoThriftNullableToken))
+	assert.NoError(t, err)
+	_, err = analyst.PrestoGetRows(split, []string{"address", "private"}, 1024, new(presto.PrestoThriftNullableToken))
+	assert.Error(t, err)
+
+	// A client without a certificate can not query anything
+	anonymous := s.Bind(nil)
+	tables, err = anonymous.PrestoListTables(&presto.PrestoThriftNullableSchemaName{SchemaName: &name})
+	assert.NoError(t, err)
+	assert.Empty(t, tables)
+
+	_, err = anonymous.PrestoGetTableMetadata(table)
+	assert.Error(t, err)
+
+	// The other nodes are not restricted
+	node := &session{Server: s, identity: &auth.Identity{Name: "node", Internal: true}}
+	meta, err = node.PrestoGetTableMetadata(table)
+	assert.NoError(t, err)
+	assert.Len(t, meta.TableMetadata.Columns, 7)
+}
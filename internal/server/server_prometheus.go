@@ -0,0 +1,397 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/kelindar/talaria/internal/encoding/prompb"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+	talaria "github.com/kelindar/talaria/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// The columns of the rows of the samples of Prometheus
+const (
+	promName   = "name"
+	promTime   = "time"
+	promValue  = "value"
+	promLabels = "labels"
+)
+
+// handlePrometheusWrite ingests the samples sent by Prometheus with its remote-write protocol into the configured
+// table, a row per sample. The request goes through the same authentication, quotas and pipeline as the other
+// ingestion requests. As Prometheus retries on a server error, only the invalid requests get a client error.
+func (s *Server) handlePrometheusWrite(w http.ResponseWriter, r *http.Request) {
+	conf := s.conf().Writers.HTTP
+	if conf == nil || conf.Prometheus == nil {
+		writeError(w, errors.Unimplemented("the prometheus ingestion is not enabled on this node"))
+		return
+	}
+
+	body, err := readSnappy(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var samples prompb.WriteRequest
+	if err := samples.Unmarshal(body); err != nil {
+		writeError(w, errors.InvalidArgument("unable to decode the body: "+err.Error()))
+		return
+	}
+
+	batch := batchOf(&samples, conf.Prometheus.Labels)
+	if len(batch.Events) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	md := metadata.Pairs(authMetadataKey, r.Header.Get("Authorization"), tableMetadataKey, conf.Prometheus.Table)
+	ctx := peer.NewContext(metadata.NewIncomingContext(r.Context(), md), &peer.Peer{Addr: httpAddr(r.RemoteAddr)})
+	if _, err := s.Ingest(ctx, &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Batch{Batch: batch},
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.monitor.Count(ctxTag, "prometheus.samples", int64(len(batch.Events)), "table:"+conf.Prometheus.Table)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readSnappy reads a body compressed with snappy, rejecting the ones larger than the ingestion limit once
+// decompressed before allocating them.
+func readSnappy(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	compressed, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		return nil, errors.InvalidArgument("unable to read the body: " + err.Error())
+	}
+
+	size, err := snappy.DecodedLen(compressed)
+	switch {
+	case err != nil:
+		return nil, errors.InvalidArgument("unable to decompress the body: " + err.Error())
+	case size > maxIngestSize:
+		return nil, errors.TooLarge(fmt.Sprintf("the body is %d bytes once decompressed, above the limit of %d bytes", size, maxIngestSize))
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, errors.InvalidArgument("unable to decompress the body: " + err.Error())
+	}
+	return body, nil
+}
+
+// batchOf converts the samples of a remote-write request into a batch of events, a row per sample with the name of
+// its metric, its time in unix milliseconds, its value and its other labels as JSON. The labels given are also set
+// as their own column, unless they are named after one of the columns of the sample.
+func batchOf(request *prompb.WriteRequest, promoted []string) *talaria.Batch {
+	builder := newBatchBuilder()
+	batch, intern := builder.batch, builder.intern
+	nameKey, timeKey, valueKey, labelsKey := intern(promName), intern(promTime), intern(promValue), intern(promLabels)
+	for _, series := range request.Timeseries {
+		labels := make(map[string]string, len(series.Labels))
+		for _, l := range series.Labels {
+			if l.Name != prompb.MetricName {
+				labels[l.Name] = l.Value
+			}
+		}
+
+		// The values shared by every sample of the series
+		encoded, _ := json.Marshal(labels)
+		name := &talaria.Value{Value: &talaria.Value_String_{String_: intern(series.Name())}}
+		others := &talaria.Value{Value: &talaria.Value_Json{Json: intern(string(encoded))}}
+		columns := make(map[uint32]*talaria.Value, len(promoted))
+		for _, label := range promoted {
+			switch v, ok := labels[label]; {
+			case !ok, label == promName, label == promTime, label == promValue, label == promLabels:
+				continue
+			default:
+				columns[intern(label)] = &talaria.Value{Value: &talaria.Value_String_{String_: intern(v)}}
+			}
+		}
+
+		for _, sample := range series.Samples {
+			event := &talaria.Event{Value: make(map[uint32]*talaria.Value, 4+len(columns))}
+			event.Value[nameKey] = name
+			event.Value[timeKey] = &talaria.Value{Value: &talaria.Value_Int64{Int64: sample.Timestamp}}
+			event.Value[valueKey] = &talaria.Value{Value: &talaria.Value_Float64{Float64: sample.Value}}
+			event.Value[labelsKey] = others
+			for k, v := range columns {
+				event.Value[k] = v
+			}
+			batch.Events = append(batch.Events, event)
+		}
+	}
+	return batch
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// handlePrometheusRead serves the samples of the configured table to Prometheus with its remote-read protocol. Each
+// query is translated into a scan of the series of its metric over its time range, the other matchers being applied
+// on the labels of the rows. Only the data held by this node is returned, so in a cluster the queries are only
+// served by the replicas of their metric, which hold all of its samples.
+func (s *Server) handlePrometheusRead(w http.ResponseWriter, r *http.Request) {
+	defer s.handlePanic()
+	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:prometheus_read")
+
+	conf := s.conf().Readers.REST
+	if conf == nil || !authorized(r, conf.Token) {
+		writeError(w, errors.Unauthenticated("a valid bearer token is required"))
+		return
+	}
+
+	if conf.Prometheus == "" {
+		writeError(w, errors.Unimplemented("the prometheus remote-read is not enabled on this node"))
+		return
+	}
+
+	t, err := s.getTable(conf.Prometheus)
+	if err != nil {
+		writeError(w, errors.NotFound(err.Error()))
+		return
+	}
+
+	body, err := readSnappy(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var request prompb.ReadRequest
+	if err := request.Unmarshal(body); err != nil {
+		writeError(w, errors.InvalidArgument("unable to decode the body: "+err.Error()))
+		return
+	}
+
+	// Read the series of every query, the results being in the same order
+	response := prompb.ReadResponse{Results: make([]prompb.QueryResult, 0, len(request.Queries))}
+	for i := range request.Queries {
+		series, err := s.readSeries(t, &request.Queries[i])
+		if err != nil {
+			s.monitor.Warning(err)
+			writeError(w, err)
+			return
+		}
+
+		response.Results = append(response.Results, prompb.QueryResult{Timeseries: series})
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	_, _ = w.Write(snappy.Encode(nil, response.Marshal()))
+}
+
+// readSeries reads the rows of the table selected by the query and groups them into series, sorted by their labels,
+// with their samples sorted by time. As the rows are only read locally, the query is refused in a cluster unless
+// this node is one of the replicas of the metric, rather than returning some of its samples.
+func (s *Server) readSeries(t table.Table, query *prompb.Query) ([]prompb.TimeSeries, error) {
+	selector, err := newSelector(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	// A table without the columns of the samples has not received any yet
+	columns := []string{promName, promTime, promValue, promLabels}
+	schema, _ := t.Schema()
+	for _, c := range columns {
+		if _, ok := schema[c]; !ok {
+			return nil, nil
+		}
+	}
+
+	// The series of a metric are stored under its name, and the blocks only need to be read over the time range of
+	// the query if the table can map it to its keys. The time range is still applied on the rows of the blocks.
+	domain := &presto.PrestoThriftTupleDomain{Domains: map[string]*presto.PrestoThriftDomain{}}
+	if ranger, ok := t.(interface {
+		KeyRange(int64, int64) (*presto.PrestoThriftDomain, bool)
+	}); ok && t.SortBy() == promTime {
+		if r, ok := ranger.KeyRange(query.StartTimestampMs, query.EndTimestampMs); ok {
+			domain.Domains[promTime] = r
+		}
+	}
+
+	hashBy := t.HashBy()
+	if s.ring != nil && hashBy == "" {
+		return nil, errors.Unimplemented("the remote-read requires the table to be hashed by the name of the metric in a cluster")
+	}
+
+	if hashBy != "" {
+		if hashBy != promName || selector.name == "" {
+			return nil, errors.InvalidArgument("the query must match the name of the metric with an equality")
+		}
+
+		domain.Domains[hashBy] = presto.KeyDomain(&presto.PrestoThriftBlock{
+			VarcharData: &presto.PrestoThriftVarchar{
+				Nulls: []bool{false},
+				Sizes: []int32{int32(len(selector.name))},
+				Bytes: []byte(selector.name),
+			},
+		})
+	}
+
+	splits, err := t.GetSplits(columns, domain, 1)
+	if err != nil {
+		return nil, errors.InvalidArgument(err.Error())
+	}
+
+	series := make(map[string]*prompb.TimeSeries)
+	seen := make(map[string]bool, len(splits))
+	for _, split := range splits {
+		if seen[string(split.Key)] {
+			continue // Every member gets the same split, we only read locally
+		}
+		seen[string(split.Key)] = true
+		if owner, remote := s.ownerOf(t, split.Key, false); remote {
+			return nil, errors.Unimplemented("the samples of '" + selector.name + "' are held by " + owner + ", which should be queried instead")
+		}
+
+		for token := split.Key; token != nil; {
+			page, err := t.GetRows(token, columns, restPageSize)
+			if err != nil {
+				return nil, errors.Internal("unable to get rows from a table", err)
+			}
+
+			if len(page.Columns) == len(columns) {
+				selector.collect(series, page.Columns, query.StartTimestampMs, query.EndTimestampMs)
+			}
+			token = page.NextToken
+		}
+	}
+
+	// Sort the series by their labels, which Prometheus expects to be sorted by name
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]prompb.TimeSeries, 0, len(keys))
+	for _, k := range keys {
+		ts := series[k]
+		ts.SortLabels()
+		sort.Slice(ts.Samples, func(i, j int) bool {
+			return ts.Samples[i].Timestamp < ts.Samples[j].Timestamp
+		})
+		out = append(out, *ts)
+	}
+	return out, nil
+}
+
+// selector represents the label matchers of a query, compiled
+type selector struct {
+	name     string                 // The name of the metric, if matched with an equality
+	matchers []prompb.LabelMatcher  // The matchers of the query
+	patterns map[int]*regexp.Regexp // The anchored expressions of the regular expression matchers, by index
+	labels   map[string]map[string]string
+}
+
+// newSelector compiles the label matchers of a query
+func newSelector(matchers []prompb.LabelMatcher) (*selector, error) {
+	s := &selector{
+		matchers: matchers,
+		patterns: make(map[int]*regexp.Regexp),
+		labels:   make(map[string]map[string]string),
+	}
+
+	for i, m := range matchers {
+		switch m.Type {
+		case prompb.MatchEqual, prompb.MatchNotEqual:
+			if m.Type == prompb.MatchEqual && m.Name == prompb.MetricName {
+				s.name = m.Value
+			}
+		case prompb.MatchRegexp, prompb.MatchNotRegexp:
+			pattern, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, errors.InvalidArgument("invalid regular expression for label '" + m.Name + "': " + err.Error())
+			}
+			s.patterns[i] = pattern
+		default:
+			return nil, errors.InvalidArgument("unsupported matcher for label '" + m.Name + "'")
+		}
+	}
+	return s, nil
+}
+
+// collect adds the rows of the page which are in the time range and match every matcher to their series
+func (s *selector) collect(series map[string]*prompb.TimeSeries, columns []presto.Column, from, until int64) {
+	for i := 0; i < columns[0].Count(); i++ {
+		name, _ := columns[0].At(i).(string)
+		timestamp, _ := columns[1].At(i).(int64)
+		value, _ := columns[2].At(i).(float64)
+		encoded, _ := columns[3].At(i).(string)
+		if timestamp < from || timestamp > until {
+			continue
+		}
+
+		labels := s.labelsOf(encoded)
+		if !s.matches(name, labels) {
+			continue
+		}
+
+		key := name + "\x00" + encoded
+		ts, ok := series[key]
+		if !ok {
+			ts = &prompb.TimeSeries{Labels: make([]prompb.Label, 0, 1+len(labels))}
+			ts.Labels = append(ts.Labels, prompb.Label{Name: prompb.MetricName, Value: string([]byte(name))})
+			for k, v := range labels {
+				ts.Labels = append(ts.Labels, prompb.Label{Name: k, Value: v})
+			}
+			series[key] = ts
+		}
+
+		ts.Samples = append(ts.Samples, prompb.Sample{Value: value, Timestamp: timestamp})
+	}
+}
+
+// labelsOf decodes the labels of a row, which are shared by every sample of its series
+func (s *selector) labelsOf(encoded string) map[string]string {
+	if labels, ok := s.labels[encoded]; ok {
+		return labels
+	}
+
+	labels := make(map[string]string)
+	_ = json.Unmarshal([]byte(encoded), &labels)
+	s.labels[string([]byte(encoded))] = labels
+	return labels
+}
+
+// matches checks whether the labels of a series match every matcher, a missing label having an empty value
+func (s *selector) matches(name string, labels map[string]string) bool {
+	for i, m := range s.matchers {
+		value := labels[m.Name]
+		if m.Name == prompb.MetricName {
+			value = name
+		}
+
+		var ok bool
+		switch m.Type {
+		case prompb.MatchEqual:
+			ok = value == m.Value
+		case prompb.MatchNotEqual:
+			ok = value != m.Value
+		case prompb.MatchRegexp:
+			ok = s.patterns[i].MatchString(value)
+		case prompb.MatchNotRegexp:
+			ok = !s.patterns[i].MatchString(value)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/prompb"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/writer"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/kelindar/talaria/internal/table/timeseries"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricsTable is a table of samples, hashed by the name of their metric
+type metricsTable struct {
+	appendTable
+}
+
+func (t *metricsTable) Name() string   { return "metrics" }
+func (t *metricsTable) HashBy() string { return "name" }
+
+func TestPrometheusWrite(t *testing.T) {
+	metrics := &metricsTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+		Writers: config.Writers{
+			HTTP: &config.HTTP{Prometheus: &config.Prometheus{
+				Table:  "metrics",
+				Labels: []string{"job", "value"},
+			}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), metrics)
+	call := func(body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/prometheus/write", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		s.handlePrometheusWrite(w, r)
+		return w
+	}
+
+	request := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels: []prompb.Label{
+			{Name: prompb.MetricName, Value: "http_requests_total"},
+			{Name: "job", Value: "api"},
+			{Name: "code", Value: "200"},
+			{Name: "value", Value: "ignored"},
+		},
+		Samples: []prompb.Sample{
+			{Value: 1, Timestamp: 1600000000000},
+			{Value: 2, Timestamp: 1600000015000},
+		},
+	}, {
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1600000000000}},
+	}}}
+
+	// A row is ingested per sample, a block per metric
+	assert.Equal(t, http.StatusNoContent, call(snappy.Encode(nil, request.Marshal())).Code)
+	assert.Len(t, metrics.blocks, 2)
+
+	for _, b := range metrics.blocks {
+		columns, err := b.Select(b.Schema())
+		assert.NoError(t, err)
+		if string(b.Key) != "http_requests_total" {
+			assert.Equal(t, 1, columns["value"].Count())
+			continue
+		}
+
+		assert.Equal(t, typeof.Schema{
+			"name":   typeof.String,
+			"time":   typeof.Int64,
+			"value":  typeof.Float64,
+			"labels": typeof.JSON,
+			"job":    typeof.String,
+		}, b.Schema())
+		assert.Equal(t, 2, columns["value"].Count())
+		assert.Equal(t, map[string]interface{}{
+			"name":   "http_requests_total",
+			"time":   int64(1600000015000),
+			"value":  float64(2),
+			"labels": json.RawMessage(`{"code":"200","job":"api","value":"ignored"}`),
+			"job":    "api",
+		}, columns.LastRow())
+	}
+
+	// The invalid requests are not retried by Prometheus
+	assert.Equal(t, http.StatusBadRequest, call(request.Marshal()).Code)
+	assert.Equal(t, http.StatusBadRequest, call(snappy.Encode(nil, []byte{0x0a, 0xff})).Code)
+	assert.Equal(t, http.StatusNoContent, call(snappy.Encode(nil, nil)).Code)
+
+	// The length of the decompressed body is checked before allocating it
+	forged := make([]byte, binary.MaxVarintLen64)
+	forged = append(forged[:binary.PutUvarint(forged, 1<<31)], 0x00, 0x01)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, call(forged).Code)
+
+	conf.Writers.HTTP.Prometheus = nil
+	assert.Equal(t, http.StatusNotImplemented, call(nil).Code)
+}
+
+func TestPrometheusRead(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "testdata-")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	store := disk.Open(dir, "metrics", monitor.NewNoop(), config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor.NewNoop(), nil)
+	metrics := timeseries.New("metrics", new(testMembership), monitor.NewNoop(), store, &config.Table{
+		HashBy: "name",
+		SortBy: "time",
+		TTL:    3600,
+	}, streams)
+	defer metrics.Close()
+
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+			REST:   &config.REST{Token: "secret", Prometheus: "metrics"},
+		},
+		Writers: config.Writers{
+			HTTP: &config.HTTP{Prometheus: &config.Prometheus{Table: "metrics"}},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), metrics)
+	call := func(token string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/prometheus/read", bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.handlePrometheusRead(w, r)
+		return w
+	}
+
+	// Ingest the samples of two series of a metric and of another metric
+	now := time.Now().Unix() * 1000
+	written := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+		Samples: []prompb.Sample{{Value: 2, Timestamp: now - 15000}, {Value: 1, Timestamp: now - 30000}},
+	}, {
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "http_requests_total"}, {Name: "code", Value: "500"}},
+		Samples: []prompb.Sample{{Value: 3, Timestamp: now - 15000}},
+	}, {
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: now - 15000}},
+	}}}
+
+	w := httptest.NewRecorder()
+	s.handlePrometheusWrite(w, httptest.NewRequest(http.MethodPost, "/v1/prometheus/write",
+		bytes.NewReader(snappy.Encode(nil, written.Marshal()))))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	read := func(matchers ...prompb.LabelMatcher) *prompb.ReadResponse {
+		request := prompb.ReadRequest{Queries: []prompb.Query{{
+			StartTimestampMs: now - 20000,
+			EndTimestampMs:   now,
+			Matchers:         matchers,
+		}}}
+
+		w := call("secret", snappy.Encode(nil, request.Marshal()))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "snappy", w.Header().Get("Content-Encoding"))
+
+		body, err := snappy.Decode(nil, w.Body.Bytes())
+		assert.NoError(t, err)
+
+		var response prompb.ReadResponse
+		assert.NoError(t, response.Unmarshal(body))
+		return &response
+	}
+
+	// The series of the metric are returned with their samples in the time range
+	response := read(prompb.LabelMatcher{Type: prompb.MatchEqual, Name: prompb.MetricName, Value: "http_requests_total"})
+	assert.Equal(t, []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+		Samples: []prompb.Sample{{Value: 2, Timestamp: now - 15000}},
+	}, {
+		Labels:  []prompb.Label{{Name: prompb.MetricName, Value: "http_requests_total"}, {Name: "code", Value: "500"}},
+		Samples: []prompb.Sample{{Value: 3, Timestamp: now - 15000}},
+	}}, response.Results[0].Timeseries)
+
+	// The other matchers are applied on the labels
+	response = read(
+		prompb.LabelMatcher{Type: prompb.MatchEqual, Name: prompb.MetricName, Value: "http_requests_total"},
+		prompb.LabelMatcher{Type: prompb.MatchRegexp, Name: "code", Value: "5.."},
+	)
+	assert.Len(t, response.Results[0].Timeseries, 1)
+	assert.Equal(t, "500", response.Results[0].Timeseries[0].Labels[1].Value)
+
+	response = read(
+		prompb.LabelMatcher{Type: prompb.MatchEqual, Name: prompb.MetricName, Value: "up"},
+		prompb.LabelMatcher{Type: prompb.MatchNotEqual, Name: "code", Value: ""},
+	)
+	assert.Len(t, response.Results[0].Timeseries, 0)
+
+	// The name of the metric is required, as the table is hashed by it
+	invalid := prompb.ReadRequest{Queries: []prompb.Query{{Matchers: []prompb.LabelMatcher{
+		{Type: prompb.MatchRegexp, Name: prompb.MetricName, Value: "http_.*"},
+	}}}}
+	assert.Equal(t, http.StatusBadRequest, call("secret", snappy.Encode(nil, invalid.Marshal())).Code)
+	assert.Equal(t, http.StatusBadRequest, call("secret", invalid.Marshal()).Code)
+	assert.Equal(t, http.StatusUnauthorized, call("wrong", nil).Code)
+
+	// A body too large once decompressed is rejected before being decompressed
+	forged := make([]byte, binary.MaxVarintLen64)
+	forged = forged[:binary.PutUvarint(forged, 1<<31)]
+	assert.Equal(t, http.StatusRequestEntityTooLarge, call("secret", forged).Code)
+
+	// In a cluster, only the replicas of the metric serve its samples
+	query := prompb.ReadRequest{Queries: []prompb.Query{{
+		StartTimestampMs: now - 20000,
+		EndTimestampMs:   now,
+		Matchers:         []prompb.LabelMatcher{{Type: prompb.MatchEqual, Name: prompb.MetricName, Value: "up"}},
+	}}}
+	s.SetOwnership(staticRing{"10.0.0.2"})
+	w = call("secret", snappy.Encode(nil, query.Marshal()))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Contains(t, w.Body.String(), "held by 10.0.0.2")
+
+	s.SetOwnership(staticRing{"10.0.0.2", "local"})
+	assert.Len(t, read(query.Queries[0].Matchers...).Results[0].Timeseries, 1)
+
+	conf.Readers.REST.Prometheus = ""
+	assert.Equal(t, http.StatusNotImplemented, call("secret", nil).Code)
+}
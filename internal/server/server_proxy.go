@@ -0,0 +1,95 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+)
+
+const (
+	proxyTimeout  = 30 * time.Second
+	proxyCountKey = "query.proxy.count"
+	proxyErrorKey = "query.proxy.error"
+)
+
+// ownerOf returns the owner of the key read by a split, if the split is read on behalf of presto and this
+// node is not one of the replicas of the key, for example when presto planned the query before a scale-out.
+func (s *Server) ownerOf(t table.Table, split []byte, proxied bool) (string, bool) {
+	if s.ring == nil || proxied {
+		return "", false
+	}
+
+	hasher, ok := t.(interface{ HashOf([]byte) (uint32, bool) })
+	if !ok {
+		return "", false
+	}
+
+	hash, ok := hasher.HashOf(split)
+	if !ok {
+		return "", false
+	}
+
+	replicas := s.ring.Replicas(hash)
+	for _, addr := range replicas {
+		if s.ring.IsLocal(addr) {
+			return "", false
+		}
+	}
+
+	if len(replicas) == 0 {
+		return "", false
+	}
+	return replicas[0], true
+}
+
+// proxyRows reads a page of the split from its owner, on behalf of presto. If the owner can not be reached,
+// the request fails so that presto retries it rather than returning partial data.
+func (s *Server) proxyRows(id *SplitID, columns []string, maxBytes int64) (*presto.PrestoThriftPageResult, error) {
+	page, err := s.readFrom(id.Owner, &SplitID{
+		Table:   id.Table,
+		Split:   id.Split,
+		Proxied: true,
+	}, columns, maxBytes)
+	if err != nil {
+		s.monitor.Count1(ctxTag, proxyErrorKey, "table:"+id.Table)
+		s.monitor.Warning(errors.Internal("unable to read the rows from "+id.Owner, err))
+		return nil, &presto.PrestoThriftServiceException{Message: err.Error(), Retryable: true}
+	}
+
+	// Keep reading from the owner until it has no more pages
+	if page.NextToken != nil {
+		next, err := decodeID(page.NextToken.Id, nil)
+		if err != nil {
+			return nil, errors.Internal("decoding query failed", err)
+		}
+
+		page.NextToken = &presto.PrestoThriftId{Id: encodeSplitID(&SplitID{
+			Table: id.Table,
+			Split: next.Split,
+			Owner: id.Owner,
+		})}
+	}
+
+	s.monitor.Count1(ctxTag, proxyCountKey, "table:"+id.Table)
+	return page, nil
+}
+
+// readFrom reads a page of a split from the thrift service of another node
+func (s *Server) readFrom(addr string, id *SplitID, columns []string, maxBytes int64) (*presto.PrestoThriftPageResult, error) {
+	port := strconv.FormatInt(int64(s.conf().Readers.Presto.Port), 10)
+	conn, err := presto.Dial(net.JoinHostPort(addr, port), proxyTimeout, s.peerTLS(s.prestoTLS))
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	client := &presto.PrestoThriftServiceClient{Client: conn}
+	return client.PrestoGetRows(&presto.PrestoThriftId{Id: encodeSplitID(id)}, columns, maxBytes, new(presto.PrestoThriftNullableToken))
+}
@@ -5,10 +5,15 @@ package server
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/kelindar/talaria/internal/monitor/errors"
 	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/server/slowlog"
 	"github.com/kelindar/talaria/internal/table"
 	talaria "github.com/kelindar/talaria/proto"
 )
@@ -18,22 +23,33 @@ func (s *Server) Describe(ctx context.Context, _ *talaria.DescribeRequest) (*tal
 	defer s.handlePanic()
 	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:describe")
 
-	tables := make([]*talaria.TableMeta, 0, len(s.tables))
-	for _, table := range s.tables {
+	// Only describe the tables and the columns the client can query
+	identity := s.queryIdentity(ctx)
+	var tables []*talaria.TableMeta
+	for _, table := range s.Tables() {
+		if !s.canList(identity, table.Name()) {
+			continue
+		}
+
 		schema, _ := table.Schema()
 
 		// Populate the column metadata
 		var columns []*talaria.ColumnMeta
 		for k, v := range schema {
+			if !s.canSelect(identity, table.Name(), k) {
+				continue
+			}
+
 			columns = append(columns, &talaria.ColumnMeta{
 				Name: k,
 				Type: v.SQL(),
 			})
 		}
 
+		schemaName, tableName := s.SchemaOf(table.Name())
 		tables = append(tables, &talaria.TableMeta{
-			Schema:  s.conf().Readers.Presto.Schema,
-			Table:   table.Name(),
+			Schema:  schemaName,
+			Table:   tableName,
 			Columns: columns,
 		})
 	}
@@ -54,6 +70,10 @@ func (s *Server) GetSplits(ctx context.Context, request *talaria.GetSplitsReques
 		return nil, err
 	}
 
+	if err := s.authorize(s.queryIdentity(ctx), table.Name(), request.Columns); err != nil {
+		return nil, err
+	}
+
 	// Build the domain
 	domain, err := presto.NewDomain(table.HashBy(), table.SortBy(), request.Filters...)
 	if err != nil {
@@ -61,7 +81,7 @@ func (s *Server) GetSplits(ctx context.Context, request *talaria.GetSplitsReques
 	}
 
 	// Get the splits
-	splits, err := table.GetSplits(request.Columns, domain, int(request.MaxSplits))
+	splits, err := s.getSplits(table, request.Columns, domain, int(request.MaxSplits))
 	if err != nil {
 		return nil, err
 	}
@@ -100,9 +120,20 @@ func (s *Server) GetRows(ctx context.Context, request *talaria.GetRowsRequest) (
 		return nil, errors.Internal("unable to retrieve a table", err)
 	}
 
+	if err := s.authorize(s.queryIdentity(ctx), table.Name(), request.Columns); err != nil {
+		return nil, err
+	}
+
+	// Wait for the node to admit the request
+	release, err := s.admit(request.MaxBytes)
+	if err != nil {
+		return nil, errors.ResourceExhausted(err.Error())
+	}
+	defer release()
+
 	// Retrieve the rows for the table
 	result := new(talaria.GetRowsResponse)
-	page, err := table.GetRows(id.Split, request.Columns, request.MaxBytes)
+	page, err := s.getRows(table, id.Split, request.Columns, request.MaxBytes)
 	if err != nil {
 		return nil, errors.Internal("unable to get rows from a table", err)
 	}
@@ -122,9 +153,94 @@ func (s *Server) GetRows(ctx context.Context, request *talaria.GetRowsRequest) (
 
 // getTable returns the table or errors out
 func (s *Server) getTable(name string) (table.Table, error) {
+	s.lock.RLock()
 	table, ok := s.tables[name]
+	s.lock.RUnlock()
 	if !ok {
 		return nil, errors.Newf("table %s not found", name)
 	}
 	return table, nil
 }
+
+// admit waits for the node to admit a request reading up to the specified number of bytes, if admission
+// control is configured, and rejects it right away while the node is above its hard memory limit. The
+// returned function must be called once the request is served.
+func (s *Server) admit(maxBytes int64) (func(), error) {
+	if err := s.memory.Admit(); err != nil {
+		s.monitor.Count1(ctxTag, "memory.rejected")
+		return nil, err
+	}
+
+	if s.admission == nil {
+		return func() {}, nil
+	}
+
+	release, err := s.admission.Acquire(maxBytes)
+	if err != nil {
+		s.monitor.Count1(ctxTag, "admission.rejected")
+		return nil, err
+	}
+	return release, nil
+}
+
+// getSplits returns the splits of the table and records the request if it is slow
+func (s *Server) getSplits(t table.Table, columns []string, constraint *presto.PrestoThriftTupleDomain, maxSplits int) ([]table.Split, error) {
+	start := time.Now()
+	splits, err := t.GetSplits(columns, constraint, maxSplits)
+	if elapsed := time.Since(start); err == nil && s.slowlog != nil && s.slowlog.IsSlow(elapsed) {
+		encoded, _ := json.Marshal(constraint)
+		s.slowlog.Record(slowlog.Entry{
+			Table:      t.Name(),
+			Operation:  "get_splits",
+			Duration:   elapsed,
+			Rows:       int64(len(splits)),
+			Constraint: string(encoded),
+		})
+	}
+	return splits, err
+}
+
+// getRows returns the rows for a split of the table and records the request if it is slow
+func (s *Server) getRows(t table.Table, split []byte, columns []string, maxBytes int64) (*table.PageResult, error) {
+	start := time.Now()
+	page, err := s.readRows(t, split, columns, maxBytes)
+	if elapsed := time.Since(start); err == nil && s.slowlog != nil && s.slowlog.IsSlow(elapsed) {
+		entry := slowlog.Entry{
+			Table:      t.Name(),
+			Operation:  "get_rows",
+			Duration:   elapsed,
+			Constraint: hex.EncodeToString(split),
+		}
+
+		for _, c := range page.Columns {
+			entry.Bytes += int64(c.Size())
+			entry.Rows = int64(c.Count())
+		}
+		s.slowlog.Record(entry)
+	}
+	return page, err
+}
+
+// readRows returns the rows for a split of the table, served from the result cache if one is configured
+func (s *Server) readRows(t table.Table, split []byte, columns []string, maxBytes int64) (*table.PageResult, error) {
+	if s.cache == nil {
+		return t.GetRows(split, columns, maxBytes)
+	}
+
+	// The split encodes the constraint, hence it is part of the key
+	key := fmt.Sprintf("%s\x00%x\x00%s\x00%d", t.Name(), split, strings.Join(columns, ","), maxBytes)
+	if page, ok := s.cache.Get(key); ok {
+		s.monitor.Count1(ctxTag, "cache.hit")
+		return page, nil
+	}
+
+	s.monitor.Count1(ctxTag, "cache.miss")
+	page, err := t.GetRows(split, columns, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Put(key, page)
+	s.monitor.Gauge(ctxTag, "cache.size", float64(s.cache.Size()))
+	return page, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery_Cache(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			Cache: &config.Cache{TTL: 60, Size: 1024 * 1024},
+		},
+	})
+
+	nodes, err := s.getTable("nodes")
+	assert.NoError(t, err)
+
+	// The second read of the same split is served from the cache
+	page1, err := s.getRows(nodes, []byte{0x00}, []string{"address"}, 1024)
+	assert.NoError(t, err)
+	page2, err := s.getRows(nodes, []byte{0x00}, []string{"address"}, 1024)
+	assert.NoError(t, err)
+	assert.True(t, page1 == page2)
+
+	// A different set of columns is a different entry
+	page3, err := s.getRows(nodes, []byte{0x00}, []string{"address", "peers"}, 1024)
+	assert.NoError(t, err)
+	assert.Len(t, page3.Columns, 2)
+	assert.True(t, s.cache.Size() > 0)
+}
+
+func TestQuery_SlowLog(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			SlowQuery: &config.SlowQuery{Threshold: 0},
+		},
+	})
+
+	nodes, err := s.getTable("nodes")
+	assert.NoError(t, err)
+
+	splits, err := s.getSplits(nodes, []string{"address"}, nil, 10)
+	assert.NoError(t, err)
+	_, err = s.getRows(nodes, splits[0].Key, []string{"address"}, 1024)
+	assert.NoError(t, err)
+
+	// With a zero threshold, every request is slow
+	entries := s.SlowQueries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "get_splits", entries[0].Operation)
+	assert.Equal(t, "null", entries[0].Constraint)
+	assert.Equal(t, "get_rows", entries[1].Operation)
+	assert.Equal(t, int64(1), entries[1].Rows)
+}
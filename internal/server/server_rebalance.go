@@ -0,0 +1,158 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+)
+
+const (
+	defaultRebalanceRate     = 10 * 1024 * 1024 // 10 MB/s
+	defaultRebalanceInterval = 60 * time.Second
+)
+
+// rebalancer represents a table which can move the hash keys it does not store anymore to another node
+type rebalancer interface {
+	Rebalance(ctx context.Context, placement func(hash uint32) (string, bool),
+		move func(ctx context.Context, addr string, blocks []block.Block) error) (int, error)
+}
+
+// Rebalance moves the data of the hash keys which this node does not store anymore, for example after a
+// scale-out, to the node now owning them. The transfer is throttled, and only one rebalance runs at a time.
+func (s *Server) Rebalance(ctx context.Context) (int, error) {
+	if s.ring == nil {
+		return 0, nil
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.rebalancing, 0, 1) {
+		return 0, errors.New("a rebalance is already in progress")
+	}
+	defer atomic.StoreInt32(&s.rebalancing, 0)
+
+	rate := s.conf().Cluster.Rebalance.Rate
+	if rate <= 0 {
+		rate = defaultRebalanceRate
+	}
+
+	limit := newThrottle(rate)
+	total := 0
+	for _, t := range s.Tables() {
+		r, ok := t.(rebalancer)
+		if !ok {
+			continue
+		}
+
+		name := t.Name()
+		moved, err := r.Rebalance(ctx, s.placementOf, func(ctx context.Context, addr string, blocks []block.Block) error {
+			var size int64
+			for _, b := range blocks {
+				size += b.Size
+			}
+
+			if err := limit.Wait(ctx, size); err != nil {
+				return err
+			}
+			return s.Handover(addr, name, blocks)
+		})
+
+		total += moved
+		if err != nil {
+			return total, err
+		}
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: rebalanced the blocks to their owners", logging.F("blocks", total))
+	return total, nil
+}
+
+// placementOf returns the owner of the hash key, if this node is not one of its replicas
+func (s *Server) placementOf(hash uint32) (string, bool) {
+	replicas := s.ring.Replicas(hash)
+	for _, addr := range replicas {
+		if s.ring.IsLocal(addr) {
+			return "", false
+		}
+	}
+
+	if len(replicas) == 0 {
+		return "", false
+	}
+	return replicas[0], true
+}
+
+// rebalanceOnChange rebalances the data whenever the membership of the cluster changes
+func (s *Server) rebalanceOnChange(ctx context.Context) (interface{}, error) {
+	interval := defaultRebalanceInterval
+	if v := s.conf().Cluster.Rebalance.Interval; v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+			if s.cluster == nil {
+				continue
+			}
+
+			members := s.cluster.Members()
+			sort.Strings(members)
+			if current := strings.Join(members, ","); current != previous {
+				previous = current
+				if _, err := s.Rebalance(ctx); err != nil {
+					s.monitor.Warning(errors.Internal("server: unable to rebalance", err))
+				}
+			}
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// throttle limits the rate at which the bytes are transferred
+type throttle struct {
+	rate  float64   // The maximum number of bytes per second
+	start time.Time // The time the transfer started
+	sent  int64     // The number of bytes sent so far
+}
+
+// newThrottle creates a new throttle for a rate in bytes per second
+func newThrottle(rate int) *throttle {
+	return &throttle{
+		rate:  float64(rate),
+		start: time.Now(),
+	}
+}
+
+// Wait waits until the bytes can be sent without exceeding the rate
+func (t *throttle) Wait(ctx context.Context, n int64) error {
+	t.sent += n
+	due := t.start.Add(time.Duration(float64(t.sent) / t.rate * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
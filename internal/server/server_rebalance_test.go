@@ -0,0 +1,97 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// movingTable is a table which moves all of its blocks if their key is not stored locally
+type movingTable struct {
+	appendTable
+}
+
+func (t *movingTable) Rebalance(ctx context.Context, placement func(hash uint32) (string, bool),
+	move func(ctx context.Context, addr string, blocks []block.Block) error) (int, error) {
+	addr, ok := placement(1)
+	if !ok || len(t.blocks) == 0 {
+		return 0, nil
+	}
+
+	if err := move(ctx, addr, t.blocks); err != nil {
+		return 0, err
+	}
+
+	moved := len(t.blocks)
+	t.blocks = nil
+	return moved, nil
+}
+
+func TestRebalance(t *testing.T) {
+	conf := &config.Config{
+		Readers: config.Readers{
+			Presto: &config.Presto{Schema: "data"},
+		},
+	}
+
+	// Start the node owning every key
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, lis.Close())
+	owned := newTestNode(t, fmt.Sprintf("127.0.0.1:%d", port), conf)
+
+	// Ingest on a node before the owner joins the ring
+	conf.Writers.GRPC = &config.GRPC{Port: int32(port)}
+	local := &movingTable{appendTable{Table: *nodes.New(new(testMembership))}}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), local)
+	_, err = s.Ingest(context.Background(), &talaria.IngestRequest{
+		Data: &talaria.IngestRequest_Csv{Csv: []byte("event,phone\nclick,+6591234567\nview,+6591234568\n")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, local.blocks, 2)
+
+	// Nothing is moved if the data is not partitioned, or the node is a replica
+	n, err := s.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	s.SetOwnership(staticRing{"127.0.0.1", "local"})
+	n, err = s.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Once the owner joins, the blocks are moved to it
+	s.SetOwnership(staticRing{"127.0.0.1"})
+	n, err = s.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, local.blocks, 0)
+	assert.Len(t, owned.blocks, 2)
+}
+
+func TestThrottle(t *testing.T) {
+	limit := newThrottle(1000)
+	start := time.Now()
+	assert.NoError(t, limit.Wait(context.Background(), 100))
+	assert.NoError(t, limit.Wait(context.Background(), 100))
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// The wait is interrupted by the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, limit.Wait(ctx, 1000))
+}
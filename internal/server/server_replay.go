@@ -0,0 +1,84 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kelindar/talaria/internal/ingress/replay"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/table"
+	talaria "github.com/kelindar/talaria/proto"
+)
+
+// Replay re-ingests into a table the ORC and Parquet files previously flushed to a sink under the source, such
+// as "s3://bucket/prefix/", to rebuild the hot store after a disaster or a migration of the data format. The
+// rows were already transformed when first ingested, so they skip the pipelines and the streams, but they
+// are still forwarded to their owners if the cluster partitions the data. Only one replay runs at a time.
+func (s *Server) Replay(ctx context.Context, name, source string) (int, error) {
+	t, err := s.getTable(name)
+	if err != nil {
+		return 0, errors.NotFound(err.Error())
+	}
+
+	appender, ok := t.(table.Appender)
+	if !ok {
+		return 0, errors.InvalidArgument("table " + name + " does not support ingestion")
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.replaying, 0, 1) {
+		return 0, errors.New("a replay is already in progress")
+	}
+	defer atomic.StoreInt32(&s.replaying, 0)
+
+	ingress, err := replay.New("", s.monitor)
+	if err != nil {
+		return 0, err
+	}
+
+	s.monitor.Log(logging.LevelInfo, "server: replaying the files of a sink", logging.F("table", name), logging.F("source", source))
+	replayed, err := ingress.Range(ctx, source, func(file string, data []byte) error {
+		request := new(talaria.IngestRequest)
+		switch strings.ToLower(path.Ext(file)) {
+		case ".parquet":
+			request.Data = &talaria.IngestRequest_Parquet{Parquet: data}
+		default:
+			request.Data = &talaria.IngestRequest_Orc{Orc: data}
+		}
+
+		_, _, err := s.ingestTable(ctx, request, t, appender, s.settings(), true, "")
+		return err
+	})
+
+	s.monitor.Log(logging.LevelInfo, "server: replayed the files of a sink", logging.F("table", name), logging.F("files", replayed))
+	return replayed, err
+}
+
+// handleReplay re-ingests the files flushed to a sink into a table, in the background
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) error {
+	name, source := r.URL.Query().Get("table"), r.URL.Query().Get("source")
+	if name == "" || source == "" {
+		return errors.InvalidArgument("a table and a source are required")
+	}
+
+	if _, err := s.getTable(name); err != nil {
+		return errors.NotFound(err.Error())
+	}
+
+	s.monitor.Info("server: replay of %s into %s requested by %s", source, name, r.RemoteAddr)
+	go func() {
+		if _, err := s.Replay(context.Background(), name, source); err != nil {
+			s.monitor.Warning(errors.Internal("server: unable to replay "+source, err))
+		}
+	}()
+
+	return writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "replaying",
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer os.RemoveAll(dir)
+
+	orc, err := ioutil.ReadFile("../../test/test5.orc")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "dt=2020-01-01"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dt=2020-01-01", "a.orc"), orc, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dt=2020-01-01", "b.orc"), orc, 0644))
+
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return &config.Config{} }, monitor.NewNoop(), script.NewLoader(nil), events)
+
+	// Every file of the directory is ingested into the table
+	n, err := s.Replay(context.Background(), "events", "file:///"+filepath.ToSlash(dir))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotEmpty(t, events.blocks)
+
+	// The table must exist
+	_, err = s.Replay(context.Background(), "missing", "file:///"+filepath.ToSlash(dir))
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+)
+
+const (
+	defaultRESTLimit = 1000
+	restPageSize     = 4 * 1024 * 1024   // 4 MB
+	restErrorTrailer = "X-Talaria-Error" // The trailer reporting an error which occurred once the rows were streamed
+)
+
+// listenREST starts the HTTP query listener and blocks until the context is cancelled.
+func (s *Server) listenREST(ctx context.Context, conf *config.REST) error {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/query", s.handleQuery).Methods(http.MethodGet)
+	router.HandleFunc("/v1/prometheus/read", s.handlePrometheusRead).Methods(http.MethodPost)
+
+	s.monitor.Info("server: listening for http on :%d...", conf.Port)
+	return serveHTTP(ctx, conf.Port, router, nil)
+}
+
+// serveHTTP serves the handler on the port, over TLS if a config is given, and blocks until the context is
+// cancelled.
+func serveHTTP(ctx context.Context, port int32, handler http.Handler, tlsConfig *tls.Config) error {
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	serve := server.ListenAndServe
+	if tlsConfig != nil {
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+
+	if err := serve(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handleQuery serves the rows of a table as NDJSON or CSV. Only the data held by this node is returned. Once
+// the rows started to be streamed, the status can no longer change, so an error is reported in a trailer.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	defer s.handlePanic()
+	defer s.monitor.Duration(ctxTag, funcTag, time.Now(), "func:rest_query")
+
+	conf := s.conf().Readers.REST
+	if conf == nil || !authorized(r, conf.Token) {
+		writeError(w, errors.Unauthenticated("a valid bearer token is required"))
+		return
+	}
+
+	// Parse the request and stream out the rows
+	query, err := parseRESTQuery(r, conf.Limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	t, err := s.getTable(query.Table)
+	if err != nil {
+		writeError(w, errors.NotFound(err.Error()))
+		return
+	}
+
+	out := newRowWriter(w, query.Format)
+	if err := s.queryTable(t, query, out); err != nil {
+		s.monitor.Warning(err)
+		if out.started {
+			out.Fail(err)
+			return
+		}
+		writeError(w, err)
+	}
+}
+
+// queryTable reads the local rows of the table and writes them out in the requested format.
+func (s *Server) queryTable(t table.Table, query *restQuery, out *rowWriter) error {
+	columns := query.Columns
+	schema, _ := t.Schema()
+	if len(columns) == 0 {
+		columns = schema.Columns()
+	}
+
+	// Build the constraint from the filters and the time range
+	domain := &presto.PrestoThriftTupleDomain{Domains: map[string]*presto.PrestoThriftDomain{}}
+	if len(query.Filters) > 0 {
+		d, err := presto.NewDomain(t.HashBy(), t.SortBy(), query.Filters...)
+		if err != nil {
+			return errors.InvalidArgument(err.Error())
+		}
+		domain = d
+	}
+	domain.Domains[t.SortBy()] = presto.TimeRange(query.From, query.Until)
+
+	splits, err := t.GetSplits(columns, domain, 1)
+	if err != nil {
+		return errors.InvalidArgument(err.Error())
+	}
+
+	// The columns are only known once the schema of the table is read
+	out.columns, out.schema = columns, schema
+	seen := make(map[string]bool, len(splits))
+	remaining := query.Limit
+	for _, split := range splits {
+		if seen[string(split.Key)] {
+			continue // Every member gets the same split, we only read locally
+		}
+		seen[string(split.Key)] = true
+
+		for token := split.Key; token != nil && remaining > 0; {
+			page, err := t.GetRows(token, columns, restPageSize)
+			if err != nil {
+				return errors.Internal("unable to get rows from a table", err)
+			}
+
+			written, err := out.Write(page.Columns, remaining)
+			if err != nil {
+				return err
+			}
+
+			remaining -= written
+			token = page.NextToken
+		}
+	}
+
+	return out.Flush()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// restQuery represents a parsed HTTP query
+type restQuery struct {
+	Table   string
+	Columns []string
+	Filters []string
+	From    time.Time
+	Until   time.Time
+	Format  string
+	Limit   int
+}
+
+// parseRESTQuery parses the query string of the request
+func parseRESTQuery(r *http.Request, maxLimit int) (*restQuery, error) {
+	if maxLimit <= 0 {
+		maxLimit = defaultRESTLimit
+	}
+
+	params := r.URL.Query()
+	query := &restQuery{
+		Table:   params.Get("table"),
+		Filters: params["filter"],
+		From:    time.Unix(0, 0),
+		Until:   time.Unix(0, math.MaxInt64),
+		Format:  strings.ToLower(params.Get("format")),
+		Limit:   maxLimit,
+	}
+
+	if query.Table == "" {
+		return nil, errors.InvalidArgument("the 'table' parameter is required")
+	}
+
+	if v := params.Get("columns"); v != "" {
+		query.Columns = strings.Split(v, ",")
+	}
+
+	switch query.Format {
+	case "":
+		query.Format = "ndjson"
+	case "ndjson", "csv":
+	default:
+		return nil, errors.InvalidArgument("the 'format' parameter must be either 'ndjson' or 'csv'")
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, errors.InvalidArgument("the 'limit' parameter must be a positive integer")
+		}
+		if limit < maxLimit {
+			query.Limit = limit
+		}
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if query.From, err = parseTime(v); err != nil {
+			return nil, errors.InvalidArgument("the 'from' parameter must be a unix timestamp or RFC3339")
+		}
+	}
+
+	if v := params.Get("until"); v != "" {
+		if query.Until, err = parseTime(v); err != nil {
+			return nil, errors.InvalidArgument("the 'until' parameter must be a unix timestamp or RFC3339")
+		}
+	}
+
+	return query, nil
+}
+
+// parseTime parses either a unix timestamp (in seconds) or an RFC3339 time
+func parseTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
+
+// authorized checks the bearer token of the request, if one is configured
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// writeError writes the error out as JSON, with the appropriate status code
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if xerr, ok := err.(*errors.Error); ok {
+		status = xerr.HTTP()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// rowWriter writes out rows from a set of columns
+type rowWriter struct {
+	format  string
+	columns []string
+	schema  typeof.Schema
+	json    *json.Encoder
+	csv     *csv.Writer
+	started bool
+	out     http.ResponseWriter
+}
+
+// newRowWriter creates a new row writer for the format
+func newRowWriter(w http.ResponseWriter, format string) *rowWriter {
+	return &rowWriter{
+		format: format,
+		json:   json.NewEncoder(w),
+		csv:    csv.NewWriter(w),
+		out:    w,
+	}
+}
+
+// begin writes out the content type and the header, if needed
+func (w *rowWriter) begin() error {
+	if w.started {
+		return nil
+	}
+
+	w.started = true
+	w.out.Header().Set("Trailer", restErrorTrailer)
+	switch w.format {
+	case "csv":
+		w.out.Header().Set("Content-Type", "text/csv")
+		return w.csv.Write(w.columns)
+	default:
+		w.out.Header().Set("Content-Type", "application/x-ndjson")
+		return nil
+	}
+}
+
+// Write writes up to limit rows and returns the number of rows written
+func (w *rowWriter) Write(columns []presto.Column, limit int) (int, error) {
+	if err := w.begin(); err != nil {
+		return 0, err
+	}
+
+	// Transpose the columns into rows
+	rows := make([][]interface{}, 0, 64)
+	for i, column := range columns {
+		_ = column.Range(0, column.Count(), func(idx int, v interface{}) error {
+			if idx >= limit {
+				return io.EOF
+			}
+
+			if idx >= len(rows) {
+				rows = append(rows, make([]interface{}, len(columns)))
+			}
+
+			rows[idx][i] = v
+			return nil
+		})
+	}
+
+	for _, row := range rows {
+		if err := w.writeRow(row); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(rows), nil
+}
+
+// writeRow writes a single row
+func (w *rowWriter) writeRow(row []interface{}) error {
+	switch w.format {
+	case "csv":
+		record := make([]string, len(row))
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339)
+			default:
+				record[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		return w.csv.Write(record)
+
+	default:
+		record := make(map[string]interface{}, len(row))
+		for i, v := range row {
+			name := w.columns[i]
+			if s, ok := v.(string); ok && w.schema[name] == typeof.JSON {
+				v = json.RawMessage(s)
+			}
+			record[name] = v
+		}
+		return w.json.Encode(record)
+	}
+}
+
+// Flush flushes the writer
+func (w *rowWriter) Flush() error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+
+	if w.format == "csv" {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
+
+// Fail reports an error which occurred once the rows started to be streamed, in the trailer of the response.
+func (w *rowWriter) Fail(err error) {
+	if w.format == "csv" {
+		w.csv.Flush()
+	}
+	w.out.Header().Set(restErrorTrailer, err.Error())
+}
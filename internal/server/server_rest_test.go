@@ -0,0 +1,128 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMembership int
+
+func (m testMembership) Members() []string {
+	return []string{"127.0.0.1", "127.0.0.2"}
+}
+
+func (m testMembership) Addr() string {
+	return "127.0.0.1"
+}
+
+func newTestServer(conf *config.Config) *Server {
+	return New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil),
+		nodes.New(new(testMembership)),
+	)
+}
+
+func TestQuery_REST(t *testing.T) {
+	s := newTestServer(&config.Config{
+		Readers: config.Readers{
+			REST: &config.REST{Token: "secret"},
+		},
+	})
+
+	tests := []struct {
+		url    string
+		token  string
+		status int
+		body   string
+	}{
+		{url: "/v1/query?table=nodes&columns=address", token: "wrong", status: http.StatusUnauthorized},
+		{url: "/v1/query?columns=address", token: "secret", status: http.StatusBadRequest},
+		{url: "/v1/query?table=xxx", token: "secret", status: http.StatusNotFound},
+		{url: "/v1/query?table=nodes&format=xml", token: "secret", status: http.StatusBadRequest},
+		{url: "/v1/query?table=nodes&columns=address", token: "secret", status: http.StatusOK, body: "{\"address\":\"127.0.0.1\"}\n"},
+		{url: "/v1/query?table=nodes&columns=address&format=csv", token: "secret", status: http.StatusOK, body: "address\n127.0.0.1\n"},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		s.handleQuery(w, r)
+
+		assert.Equal(t, tc.status, w.Code, tc.url)
+		if tc.body != "" {
+			assert.Equal(t, tc.body, w.Body.String(), tc.url)
+		}
+	}
+}
+
+// failingTable represents a table which fails to read its rows after the first page
+type failingTable struct {
+	table.Table
+}
+
+func (t *failingTable) Name() string {
+	return "failing"
+}
+
+func (t *failingTable) GetRows(splitID []byte, columns []string, maxBytes int64) (*table.PageResult, error) {
+	if string(splitID) == "next" {
+		return nil, errors.New("boom")
+	}
+
+	page, err := t.Table.GetRows(splitID, columns, maxBytes)
+	if err == nil {
+		page.NextToken = []byte("next")
+	}
+	return page, err
+}
+
+func TestQuery_Trailer(t *testing.T) {
+	conf := &config.Config{Readers: config.Readers{REST: &config.REST{}}}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil),
+		&failingTable{Table: nodes.New(new(testMembership))},
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(s.handleQuery))
+	defer srv.Close()
+
+	for _, format := range []string{"ndjson", "csv"} {
+		resp, err := http.Get(srv.URL + "/v1/query?table=failing&columns=address&format=" + format)
+		assert.NoError(t, err)
+
+		// The rows already streamed are kept, and the error is reported in the trailer
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, format)
+		assert.Contains(t, string(body), "127.0.0.1", format)
+		assert.NotContains(t, string(body), "boom", format)
+		assert.Contains(t, resp.Trailer.Get(restErrorTrailer), "boom", format)
+	}
+}
+
+func TestQuery_Limit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/query?table=a&limit=5000&from=100&until=2020-01-01T00:00:00Z", nil)
+	q, err := parseRESTQuery(r, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, q.Limit)
+	assert.Equal(t, int64(100), q.From.Unix())
+	assert.Equal(t, int64(1577836800), q.Until.Unix())
+
+	r = httptest.NewRequest(http.MethodGet, "/v1/query?table=a&limit=-1", nil)
+	_, err = parseRESTQuery(r, 10)
+	assert.True(t, strings.Contains(err.Error(), "limit"))
+}
@@ -0,0 +1,148 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/server/registry"
+	"github.com/kelindar/talaria/internal/table"
+	talaria "github.com/kelindar/talaria/proto"
+)
+
+// SetRegistry sets the registry of the versions of the schemas of the tables. Once set, the columns of the
+// ingested rows are checked against the schema of their table, the new columns being added as a new version.
+// The tables are given the columns of their latest version right away, and of the versions added on this node
+// or replicated from the other nodes, so they can be queried before any row is ingested again.
+func (s *Server) SetRegistry(r *registry.Registry) {
+	s.registry = r
+	r.OnChange(s.applySchema)
+	for _, t := range s.Tables() {
+		s.loadSchema(t.Name())
+	}
+}
+
+// loadSchema gives a table the columns of the latest version of its schema, if any
+func (s *Server) loadSchema(table string) {
+	if s.registry == nil {
+		return
+	}
+
+	if version, ok := s.registry.Latest(table); ok {
+		s.applySchema(table, version)
+	}
+}
+
+// applySchema adds the columns of a version of the schema to its table, if the table has a dynamic schema
+func (s *Server) applySchema(table string, version registry.Version) {
+	t, err := s.getTable(table)
+	if err != nil {
+		return
+	}
+
+	if e, ok := t.(interface{ Evolve(typeof.Schema) }); ok {
+		e.Evolve(version.Schema)
+	}
+}
+
+// hintsOf returns the types to decode the columns of the request with, which are the ones of the static schema
+// of the table or, for JSON whose types are inferred, the ones of the latest version of its schema
+func (s *Server) hintsOf(table string, request *talaria.IngestRequest, static *typeof.Schema) *typeof.Schema {
+	if _, ok := request.GetData().(*talaria.IngestRequest_Json); !ok || static != nil || s.registry == nil {
+		return static
+	}
+
+	if version, ok := s.registry.Latest(table); ok {
+		return &version.Schema
+	}
+	return nil
+}
+
+// expectedOf returns the schema the rows of a strict table are checked against, which is the one its columns are
+// decoded with or, for a dynamic schema, the latest version of it. The timestamp columns parsed by the pipeline
+// are left out, as their values are sent in any of their formats.
+func (s *Server) expectedOf(table string, decode *typeof.Schema, settings *settings) typeof.Schema {
+	if decode != nil {
+		return *decode
+	}
+
+	if s.registry == nil {
+		return nil
+	}
+
+	version, ok := s.registry.Latest(table)
+	if !ok {
+		return nil
+	}
+	return *withoutColumns(version.Schema, settings.parsed[table])
+}
+
+// rejectOf returns the function routing the rows rejected by the strict schema of a table to its errors
+// destination, if the table has one
+func rejectOf(t table.Table) func(block.Row) error {
+	if r, ok := t.(interface{ Reject(block.Row) error }); ok {
+		return r.Reject
+	}
+	return nil
+}
+
+// evolve checks the columns of the blocks ingested into a table against the latest version of its schema,
+// adding the columns which are not known yet as a new version
+func (s *Server) evolve(table string, blocks []block.Block) error {
+	if s.registry == nil || len(blocks) == 0 {
+		return nil
+	}
+
+	schema := make(typeof.Schema, 8)
+	for _, b := range blocks {
+		for column, typ := range b.Schema() {
+			if existing, ok := schema[column]; ok && existing != typ {
+				return errors.InvalidArgument("column " + column + " of table " + table + " was ingested as both " + existing.String() + " and " + typ.String())
+			}
+			schema[column] = typ
+		}
+	}
+
+	previous, _ := s.registry.Latest(table)
+	version, err := s.registry.Evolve(table, schema)
+	if err != nil {
+		return err
+	}
+
+	if version.Version != previous.Version {
+		s.monitor.Count1(ctxTag, "schema.version", "table:"+table)
+		s.monitor.Log(logging.LevelInfo, "server: schema evolved", logging.F("table", table),
+			logging.F("version", version.Version), logging.F("added", version.Added))
+	}
+	return nil
+}
+
+// handleSchemas returns the latest schema of every table, as ingested
+func (s *Server) handleSchemas(w http.ResponseWriter, r *http.Request) error {
+	if s.registry == nil {
+		return errors.Unimplemented("the schema registry is not enabled on this node")
+	}
+
+	return writeJSON(w, http.StatusOK, s.registry.Schemas())
+}
+
+// handleSchema returns every version of the schema of a table, oldest first
+func (s *Server) handleSchema(w http.ResponseWriter, r *http.Request) error {
+	if s.registry == nil {
+		return errors.Unimplemented("the schema registry is not enabled on this node")
+	}
+
+	name := mux.Vars(r)["name"]
+	versions := s.registry.Versions(name)
+	if len(versions) == 0 {
+		return errors.NotFound("table " + name + " has no schema yet")
+	}
+
+	return writeJSON(w, http.StatusOK, versions)
+}
@@ -0,0 +1,112 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/registry"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngest_Schema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	schemas, err := registry.New(dir)
+	assert.NoError(t, err)
+
+	conf := &config.Config{Admin: &config.Admin{Token: "secret"}}
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	s.SetRegistry(schemas)
+	ingest := func(csv string) error {
+		_, err := s.Ingest(context.Background(), &talaria.IngestRequest{
+			Data: &talaria.IngestRequest_Csv{Csv: []byte(csv)},
+		})
+		return err
+	}
+
+	// The new columns are added as a new version of the schema
+	assert.NoError(t, ingest("event,count\nclick,1\n"))
+	assert.NoError(t, ingest("event,country\nview,SG\n"))
+	assert.NoError(t, ingest("event\nview\n"))
+	assert.Len(t, events.blocks, 3)
+
+	// The columns whose type changed are rejected
+	columns := make(column.Columns, 2)
+	columns.Append("event", "click", typeof.String)
+	columns.Append("count", int64(1), typeof.Int64)
+	b, err := block.FromColumns("click", columns)
+	assert.NoError(t, err)
+	assert.Error(t, s.AppendTo("events", []block.Block{b}))
+	assert.Len(t, events.blocks, 3)
+
+	// The versions are listed through the admin API
+	r := httptest.NewRequest(http.MethodGet, "/v1/admin/schemas/events", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.admin(s.handleSchema)(w, mux.SetURLVars(r, map[string]string{"name": "events"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var versions []registry.Version
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&versions))
+	assert.Len(t, versions, 2)
+	assert.Equal(t, []string{"country"}, versions[1].Added)
+	assert.Equal(t, typeof.Schema{"event": typeof.String, "count": typeof.String, "country": typeof.String}, versions[1].Schema)
+
+	w = httptest.NewRecorder()
+	s.admin(s.handleSchema)(w, mux.SetURLVars(r, map[string]string{"name": "orders"}))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestIngest_JSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	schemas, err := registry.New(dir)
+	assert.NoError(t, err)
+
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	s := New(func() *config.Config { return &config.Config{} }, monitor.NewNoop(), script.NewLoader(nil), events)
+	s.SetRegistry(schemas)
+	ingest := func(body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/ingest", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		s.handleIngest(w, r)
+		return w.Code
+	}
+
+	// The types are inferred, then the integers are converted to the floats of the schema
+	assert.Equal(t, http.StatusNoContent, ingest(`{"event": "click", "amount": 1.5}`))
+	assert.Equal(t, http.StatusNoContent, ingest(`{"event": "view", "amount": 2, "user": {"id": 1}}`))
+	assert.Len(t, events.blocks, 2)
+	assert.Equal(t, typeof.Schema{"event": typeof.String, "amount": typeof.Float64, "user": typeof.JSON}, events.blocks[1].Schema())
+
+	// The values which do not fit the schema are rejected
+	assert.Equal(t, http.StatusBadRequest, ingest(`{"event": "view", "amount": "a lot"}`))
+
+	latest, ok := schemas.Latest("events")
+	assert.True(t, ok)
+	assert.Equal(t, 2, latest.Version)
+}
@@ -0,0 +1,346 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/fluent"
+	"github.com/kelindar/talaria/internal/encoding/syslog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	maxShipment      = 1000                   // The maximum number of records ingested at once
+	maxRetained      = 10 * maxShipment       // The maximum number of records kept for the next shipment once they failed
+	shipmentInterval = 100 * time.Millisecond // The time after which the records received over UDP are ingested
+	maxDatagram      = 64 * 1024              // The maximum size of a syslog message received over UDP
+)
+
+// listenSyslog starts the syslog listeners, over TCP and UDP on the same port, and blocks until the context is
+// cancelled.
+func (s *Server) listenSyslog(ctx context.Context, conf *config.Shipper) error {
+	addr := fmt.Sprintf(":%d", conf.Port)
+	packets, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		packets.Close()
+	}()
+	go s.serveSyslogUDP(ctx, conf, packets)
+
+	s.monitor.Info("server: listening for syslog on :%d...", conf.Port)
+	return s.serveTCP(ctx, addr, func(conn net.Conn) {
+		s.serveSyslogTCP(ctx, conf, conn)
+	})
+}
+
+// listenFluent starts the listener of the forward protocol of Fluentd and Fluent Bit, and blocks until the context
+// is cancelled.
+func (s *Server) listenFluent(ctx context.Context, conf *config.Shipper) error {
+	s.monitor.Info("server: listening for fluent on :%d...", conf.Port)
+	return s.serveTCP(ctx, fmt.Sprintf(":%d", conf.Port), func(conn net.Conn) {
+		s.serveFluent(ctx, conf, conn)
+	})
+}
+
+// serveTCP accepts the connections, serving each of them until it is closed or the context is cancelled
+func (s *Server) serveTCP(ctx context.Context, addr string, serve func(net.Conn)) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer s.handlePanic()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-done:
+				}
+				conn.Close()
+			}()
+
+			serve(conn)
+		}()
+	}
+}
+
+// serveSyslogTCP ingests the syslog messages framed over a TCP connection, each time it has read every message
+// received so far.
+func (s *Server) serveSyslogTCP(ctx context.Context, conf *config.Shipper, conn net.Conn) {
+	shipment := newShipment(conf, "syslog", conn.RemoteAddr())
+	reader := bufio.NewReader(conn)
+	for {
+		switch frame, err := syslog.ReadFrame(reader); {
+		case err == syslog.ErrTooLarge:
+			s.monitor.Count1(ctxTag, ingestErrorKey, "type:syslog")
+		case err != nil:
+			if err != io.EOF && ctx.Err() == nil {
+				s.monitor.Warning(fmt.Errorf("server: unable to read syslog from %v: %v", conn.RemoteAddr(), err))
+			}
+			s.ship(ctx, shipment)
+			return
+		default:
+			s.receiveSyslog(shipment, frame)
+		}
+
+		if reader.Buffered() == 0 || shipment.count >= maxShipment {
+			s.ship(ctx, shipment)
+		}
+	}
+}
+
+// serveSyslogUDP ingests the syslog messages received in UDP datagrams, once a few were received or after a while
+func (s *Server) serveSyslogUDP(ctx context.Context, conf *config.Shipper, conn net.PacketConn) {
+	defer s.handlePanic()
+	shipments := make(map[string]*shipment)
+	buffer := make([]byte, maxDatagram)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(shipmentInterval))
+		n, addr, err := conn.ReadFrom(buffer)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			for k, shipment := range shipments {
+				s.ship(ctx, shipment)
+				if shipment.count == 0 {
+					delete(shipments, k) // The records which failed are shipped again with the next ones
+				}
+			}
+			continue
+		}
+
+		shipment, ok := shipments[addr.String()]
+		if !ok {
+			shipment = newShipment(conf, "syslog", addr)
+			shipments[addr.String()] = shipment
+		}
+
+		s.receiveSyslog(shipment, buffer[:n])
+		if shipment.count >= maxShipment {
+			s.ship(ctx, shipment)
+		}
+	}
+}
+
+// receiveSyslog adds a syslog message to the shipment, as a row in the table of its application
+func (s *Server) receiveSyslog(shipment *shipment, frame []byte) {
+	msg, err := syslog.Parse(frame)
+	if err != nil {
+		s.monitor.Count1(ctxTag, ingestErrorKey, "type:syslog")
+		return
+	}
+
+	at := msg.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	row := map[string]interface{}{
+		"time":     at.UnixNano(),
+		"facility": int64(msg.Facility),
+		"severity": msg.SeverityName(),
+		"hostname": msg.Hostname,
+		"app":      msg.AppName,
+		"procid":   msg.ProcID,
+		"msgid":    msg.MsgID,
+		"message":  msg.Message,
+	}
+	if len(msg.StructuredData) > 0 {
+		row["structured_data"] = jsonOf(msg.StructuredData)
+	}
+
+	shipment.add(msg.AppName, row)
+}
+
+// serveFluent ingests the messages of the forward protocol received over a TCP connection, each time it has read
+// every message received so far, and acknowledges the chunks once they are ingested.
+func (s *Server) serveFluent(ctx context.Context, conf *config.Shipper, conn net.Conn) {
+	shipment := newShipment(conf, "fluent", conn.RemoteAddr())
+	reader := bufio.NewReader(conn)
+	decoder := fluent.NewDecoder(reader)
+	for {
+		msg, err := decoder.Decode()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				s.monitor.Warning(fmt.Errorf("server: unable to read fluent from %v: %v", conn.RemoteAddr(), err))
+			}
+			s.ship(ctx, shipment)
+			return
+		}
+
+		for _, entry := range msg.Entries {
+			row := make(map[string]interface{}, len(entry.Record)+2)
+			for k, v := range entry.Record {
+				row[k] = valueOf(v)
+			}
+			row["tag"] = msg.Tag
+			row["time"] = entry.Time.UnixNano()
+			shipment.add(msg.Tag, row)
+		}
+
+		// The chunks are sent again until they are acknowledged, so they are ingested right away
+		if msg.Chunk == "" && reader.Buffered() > 0 && shipment.count < maxShipment {
+			continue
+		}
+
+		// A chunk is only acknowledged once every table ingested its records, otherwise the shipper sends it again
+		err = s.ship(ctx, shipment)
+		switch {
+		case msg.Chunk == "":
+		case err != nil:
+			shipment.reset()
+		default:
+			if _, err := conn.Write(fluent.Ack(msg.Chunk)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// valueOf converts a value of a record to a value of a column, the nested values being stored as JSON
+func valueOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, int64, float64, bool, nil:
+		return v
+	case []byte:
+		return string(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	default:
+		return jsonOf(v)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// shipment represents the records received from a log shipper and not ingested yet, by table
+type shipment struct {
+	kind    string                   // The kind of shipper
+	tables  map[string]string        // The table of each tag or pattern of tags
+	token   string                   // The token presented for the records
+	addr    net.Addr                 // The address of the shipper
+	batches map[string]*batchBuilder // The batches of the records, by table
+	count   int                      // The number of records
+}
+
+// newShipment creates a new empty shipment of the records of a shipper
+func newShipment(conf *config.Shipper, kind string, addr net.Addr) *shipment {
+	return &shipment{
+		kind:    kind,
+		tables:  conf.Tables,
+		token:   conf.Token,
+		addr:    addr,
+		batches: make(map[string]*batchBuilder),
+	}
+}
+
+// add adds a record to the batch of the table of its tag, the records of the other tags being dropped
+func (s *shipment) add(tag string, row map[string]interface{}) {
+	table, ok := tableOfTag(s.tables, tag)
+	if !ok {
+		return
+	}
+
+	batch, ok := s.batches[table]
+	if !ok {
+		batch = newBatchBuilder()
+		s.batches[table] = batch
+	}
+
+	batch.append(row)
+	s.count++
+}
+
+// reset empties the shipment
+func (s *shipment) reset() {
+	s.batches = make(map[string]*batchBuilder)
+	s.count = 0
+}
+
+// ship ingests the records of the shipment, presenting the token of the shipper. The records of the tables which
+// failed to ingest them are kept for the next shipment, unless too many of them are kept.
+func (s *Server) ship(ctx context.Context, shipment *shipment) (err error) {
+	if shipment.count == 0 {
+		return nil
+	}
+
+	md := metadata.Pairs(authMetadataKey, "Bearer "+shipment.token)
+	ctx = peer.NewContext(metadata.NewIncomingContext(ctx, md), &peer.Peer{Addr: shipment.addr})
+	for table, batch := range shipment.batches {
+		if e := s.ingestTelemetry(ctx, table, shipment.kind+".records", batch.batch); e != nil {
+			s.monitor.Warning(e)
+			err = e
+			continue
+		}
+
+		shipment.count -= len(batch.batch.Events)
+		delete(shipment.batches, table)
+	}
+
+	if shipment.count > maxRetained {
+		s.monitor.Count(ctxTag, ingestErrorKey, int64(shipment.count), "type:"+shipment.kind)
+		shipment.reset()
+	}
+	return
+}
+
+// tableOfTag returns the table of a tag, which is either listed or matches one of the patterns, the longest pattern
+// taking precedence.
+func tableOfTag(tables map[string]string, tag string) (string, bool) {
+	if table, ok := tables[tag]; ok {
+		return table, true
+	}
+
+	patterns := make([]string, 0, len(tables))
+	for pattern := range tables {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return tables[pattern], true
+		}
+	}
+	return "", false
+}
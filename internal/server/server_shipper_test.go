@@ -0,0 +1,189 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/fluent"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// systemTable is a table of syslog messages, hashed by their application
+type systemTable struct {
+	appendTable
+}
+
+func (t *systemTable) Name() string   { return "system" }
+func (t *systemTable) HashBy() string { return "app" }
+
+// webTable is a table of fluent records, hashed by their tag
+type webTable struct {
+	appendTable
+}
+
+func (t *webTable) Name() string   { return "web" }
+func (t *webTable) HashBy() string { return "tag" }
+
+func TestSyslog(t *testing.T) {
+	system := &systemTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+		Writers: config.Writers{Syslog: &config.Shipper{Tables: map[string]string{"*": "system"}}},
+	}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), system)
+
+	// The messages framed over TCP are ingested once every message sent was read
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.serveSyslogTCP(context.Background(), conf.Writers.Syslog, server)
+		close(done)
+	}()
+
+	_, _ = client.Write([]byte("<34>1 2020-01-01T00:00:00Z host su - ID1 [origin ip=\"10.0.0.1\"] 'su root' failed\n"))
+	_, _ = client.Write([]byte("not syslog\n"))
+	_, _ = client.Write([]byte("20 <14>1 - - cron - - -"))
+	client.Close()
+	<-done
+
+	var rows int
+	for _, b := range system.blocks {
+		columns, err := b.Select(b.Schema())
+		assert.NoError(t, err)
+		rows += columns["time"].Count()
+		if string(b.Key) == "su" {
+			row := columns.LastRow()
+			assert.Equal(t, "crit", row["severity"])
+			assert.Equal(t, int64(4), row["facility"])
+			assert.Equal(t, "'su root' failed", row["message"])
+			assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(), row["time"])
+			assert.Equal(t, json.RawMessage(`{"origin":{"ip":"10.0.0.1"}}`), row["structured_data"])
+		}
+	}
+	assert.Equal(t, 2, rows)
+
+	// The datagrams are ingested after a while
+	packets, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.serveSyslogUDP(ctx, conf.Writers.Syslog, packets)
+
+	sender, err := net.Dial("udp", packets.LocalAddr().String())
+	assert.NoError(t, err)
+	_, _ = sender.Write([]byte("<14>1 - - sshd - - - accepted"))
+	assert.Eventually(t, func() bool { return len(system.blocks) == 3 }, time.Second, 10*time.Millisecond)
+}
+
+func TestFluent(t *testing.T) {
+	web := &webTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+		Writers: config.Writers{Fluent: &config.Shipper{Tables: map[string]string{"app.web*": "web", "app.web.debug": ""}}},
+	}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), web)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.serveFluent(context.Background(), conf.Writers.Fluent, server)
+
+	at := time.Unix(1600000000, 500)
+	msg, err := fluent.Marshal(&fluent.Message{
+		Tag:   "app.web.access",
+		Chunk: "chunk-1",
+		Entries: []fluent.Entry{{Time: at, Record: map[string]interface{}{
+			"log":     "GET /cart",
+			"status":  int64(200),
+			"cached":  true,
+			"headers": map[string]interface{}{"host": "shop"},
+		}}},
+	})
+	assert.NoError(t, err)
+
+	// The chunk is acknowledged once ingested
+	go func() { _, _ = client.Write(msg) }()
+	var ack map[string]interface{}
+	assert.NoError(t, codec.NewDecoder(client, &codec.MsgpackHandle{}).Decode(&ack))
+	assert.Len(t, ack, 1)
+	assert.Len(t, web.blocks, 1)
+
+	columns, err := web.blocks[0].Select(web.blocks[0].Schema())
+	assert.NoError(t, err)
+	row := columns.LastRow()
+	assert.Equal(t, "app.web.access", row["tag"])
+	assert.Equal(t, at.UnixNano(), row["time"])
+	assert.Equal(t, "GET /cart", row["log"])
+	assert.Equal(t, int64(200), row["status"])
+	assert.Equal(t, true, row["cached"])
+
+	// A chunk is not acknowledged unless every table ingested its records
+	conf.Writers.Fluent.Tables["app.db"] = "missing"
+	var chunks []byte
+	for _, m := range []*fluent.Message{
+		{Tag: "app.db", Chunk: "chunk-2", Entries: []fluent.Entry{{Time: at, Record: map[string]interface{}{"log": "slow"}}}},
+		{Tag: "app.web.access", Chunk: "chunk-3", Entries: []fluent.Entry{{Time: at, Record: map[string]interface{}{"log": "GET /"}}}},
+	} {
+		msg, err := fluent.Marshal(m)
+		assert.NoError(t, err)
+		chunks = append(chunks, msg...)
+	}
+	go func() { _, _ = client.Write(chunks) }()
+
+	ack = nil
+	assert.NoError(t, codec.NewDecoder(client, &codec.MsgpackHandle{}).Decode(&ack))
+	assert.Equal(t, "chunk-3", string(ack["ack"].([]byte)))
+}
+
+func TestShip(t *testing.T) {
+	system := &systemTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}
+	conf := &config.Config{
+		Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+		Writers: config.Writers{Syslog: &config.Shipper{Tables: map[string]string{"su": "system", "cron": "missing"}}},
+	}
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), system)
+
+	// The records of the tables which failed are kept for the next shipment
+	shipment := newShipment(conf.Writers.Syslog, "syslog", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	shipment.add("su", map[string]interface{}{"app": "su", "time": time.Now().UnixNano(), "message": "failed"})
+	shipment.add("cron", map[string]interface{}{"message": "started"})
+	shipment.add("cron", map[string]interface{}{"message": "done"})
+	assert.Error(t, s.ship(context.Background(), shipment))
+	assert.Len(t, system.blocks, 1)
+	assert.Equal(t, 2, shipment.count)
+	assert.Contains(t, shipment.batches, "missing")
+
+	// Unless too many of them are kept
+	for i := 0; i < maxRetained; i++ {
+		shipment.add("cron", map[string]interface{}{"message": "started"})
+	}
+	assert.Error(t, s.ship(context.Background(), shipment))
+	assert.Equal(t, 0, shipment.count)
+	assert.Empty(t, shipment.batches)
+}
+
+func TestTableOfTag(t *testing.T) {
+	tables := map[string]string{"app.web": "web", "app.*": "apps", "*": "other"}
+	for tag, expected := range map[string]string{
+		"app.web": "web",
+		"app.db":  "apps",
+		"kernel":  "other",
+	} {
+		table, ok := tableOfTag(tables, tag)
+		assert.True(t, ok)
+		assert.Equal(t, expected, table, tag)
+	}
+
+	_, ok := tableOfTag(map[string]string{"app.*": "apps"}, "kernel")
+	assert.False(t, ok)
+}
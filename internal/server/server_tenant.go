@@ -0,0 +1,97 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/server/auth"
+)
+
+// tenantOf returns the tenant a request is charged to, if its producer belongs to one. The nodes forwarding
+// rows to each other are not charged, as the node which received the rows first already was.
+func tenantOf(ctx context.Context, identity *auth.Identity) string {
+	if isForwarded(ctx) || identity == nil || identity.Internal {
+		return ""
+	}
+	return identity.Tenant
+}
+
+// quotasOf returns the ingestion quotas of the tenants which have one
+func quotasOf(tenants config.Tenants) map[string]config.Quota {
+	quotas := make(map[string]config.Quota, len(tenants))
+	for name, t := range tenants {
+		if t.Quota != nil {
+			quotas[name] = *t.Quota
+		}
+	}
+	return quotas
+}
+
+// inNamespace checks whether the table belongs to the namespace of the tenant
+func inNamespace(tenant, table string) bool {
+	return strings.HasPrefix(table, tenant+".")
+}
+
+// isolated checks whether the table is outside of the namespace of the tenant of the client, if it has one
+func isolated(identity *auth.Identity, table string) bool {
+	return identity != nil && !identity.Internal && identity.Tenant != "" && !inNamespace(identity.Tenant, table)
+}
+
+// canWrite checks whether the producer can write to the table, the producers of a tenant being restricted to
+// the tables of its namespace and to the tables shared by the tenants.
+func (s *settings) canWrite(identity *auth.Identity, table string) bool {
+	if !identity.Allows(table) {
+		return false
+	}
+
+	if !isolated(identity, table) {
+		return true
+	}
+
+	_, shared := s.tenantBy[table]
+	return shared
+}
+
+// checkStorage returns a ResourceExhausted error if the table belongs to the namespace of a tenant whose tables
+// reached its storage on this node
+func (s *Server) checkStorage(table string, settings *settings) error {
+	i := strings.IndexByte(table, '.')
+	if i < 0 {
+		return nil
+	}
+
+	tenant := table[:i]
+	t, ok := settings.tenants[tenant]
+	if !ok || t.Storage <= 0 {
+		return nil
+	}
+
+	var size int64
+	for _, table := range s.Tables() {
+		if sizer, ok := table.(interface{ Size() int64 }); ok && inNamespace(tenant, table.Name()) {
+			size += sizer.Size()
+		}
+	}
+
+	if size >= t.Storage {
+		return errors.ResourceExhausted(tenant + " exceeded its storage")
+	}
+	return nil
+}
+
+// stampTenant sets the tenant column of the rows of a shared table to the tenant of the producer, overwriting
+// the value sent so that a producer can not write rows on behalf of another tenant.
+func stampTenant(column, tenant string) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		r.Schema[column] = typeof.String
+		r.Values[column] = tenant
+		return r, nil
+	}
+}
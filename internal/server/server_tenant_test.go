@@ -0,0 +1,110 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/server/auth"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	talaria "github.com/kelindar/talaria/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+// sizedTable represents a table with a name and a size
+type sizedTable struct {
+	namedTable
+	size int64
+}
+
+func (t *sizedTable) Size() int64 { return t.size }
+
+func newSizedTable(name string) *sizedTable {
+	return &sizedTable{namedTable: namedTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}, name: name}}
+}
+
+func TestIngest_Tenants(t *testing.T) {
+	owned, shared, other := newSizedTable("payments.orders"), newSizedTable("eventlog"), newSizedTable("billing.invoices")
+	conf := &config.Config{
+		Readers: config.Readers{Presto: &config.Presto{Schema: "data"}},
+		Writers: config.Writers{Auth: &config.Auth{
+			Keys: []config.APIKey{
+				{Name: "ledger", Key: "key-1", Tables: []string{"*"}, Tenant: "payments"},
+				{Name: "admin", Key: "key-2", Tables: []string{"*"}},
+			},
+		}},
+		Tables: config.Tables{
+			"eventlog": {TenantBy: "tenant"},
+		},
+		Tenants: config.Tenants{
+			"payments": {Quota: &config.Quota{Rate: 1, Burst: 2}},
+			"billing":  {Storage: 100},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), owned, shared, other)
+	ingest := func(token string, tables ...string) error {
+		pairs := []string{authMetadataKey, "Bearer " + token}
+		for _, t := range tables {
+			pairs = append(pairs, tableMetadataKey, t)
+		}
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		_, err := s.Ingest(ctx, &talaria.IngestRequest{
+			Data: &talaria.IngestRequest_Csv{Csv: []byte("event,tenant\nclick,billing\nview,billing\nbuy,billing\n")},
+		})
+		return err
+	}
+
+	// The producer of a tenant writes to the tables of its namespace and to the shared ones
+	assert.NoError(t, ingest("key-1"))
+	assert.Len(t, owned.blocks, 3)
+	assert.Len(t, shared.blocks, 3)
+	assert.Len(t, other.blocks, 0)
+
+	// The rows of the shared table are stamped with the tenant of the producer
+	for _, b := range shared.blocks {
+		columns, err := b.Select(b.Schema())
+		assert.NoError(t, err)
+		assert.Equal(t, "payments", columns.LastRow()["tenant"])
+	}
+
+	// The tenant exceeded its quota, shared by its producers
+	assert.Error(t, ingest("key-1", "payments.orders"))
+
+	// A producer without a tenant is not isolated, until the tenant of the table reached its storage
+	assert.NoError(t, ingest("key-2", "billing.invoices"))
+	assert.Len(t, other.blocks, 3)
+	other.size = 100
+	assert.Error(t, ingest("key-2", "billing.invoices"))
+	assert.Len(t, other.blocks, 3)
+
+	// The clients of a tenant only query the tables of its namespace
+	ledger := &auth.Identity{Name: "ledger", Tenant: "payments"}
+	assert.NoError(t, s.authorize(ledger, "payments.orders", nil))
+	assert.Error(t, s.authorize(ledger, "eventlog", nil))
+	assert.False(t, s.canList(ledger, "billing.invoices"))
+	assert.True(t, s.canList(&auth.Identity{Name: "admin"}, "billing.invoices"))
+}
+
+func TestCheckStorage(t *testing.T) {
+	orders := newSizedTable("payments.orders")
+	conf := &config.Config{
+		Tenants: config.Tenants{"payments": {Storage: 100}},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), orders, newSizedTable("payments"))
+	assert.NoError(t, s.checkStorage("payments.orders", s.settings()))
+
+	orders.size = 100
+	assert.Error(t, s.checkStorage("payments.orders", s.settings()))
+	assert.Error(t, s.checkStorage("payments.refunds", s.settings()))
+	assert.NoError(t, s.checkStorage("payments", s.settings()))
+	assert.NoError(t, s.checkStorage("billing.orders", s.settings()))
+}
@@ -0,0 +1,146 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+)
+
+const confirmWindow = 5 * time.Minute // The time a confirmation token is valid for, at least
+
+// truncater represents a table which can delete every row it stores at once
+type truncater interface {
+	Truncate() error
+}
+
+// truncateConfirmation represents the confirmation required to truncate a table
+type truncateConfirmation struct {
+	Table   string    `json:"table"`
+	Size    int64     `json:"size"`    // The size of the table on this node, in bytes
+	Confirm string    `json:"confirm"` // The token to send back as the confirm parameter
+	Expires time.Time `json:"expires"` // The time until which the token is valid, at least
+}
+
+// truncatedNode represents the outcome of the truncation of a table on a node
+type truncatedNode struct {
+	Address string `json:"address"`
+	Size    int64  `json:"size"` // The size of the table before it was truncated, in bytes
+	Error   string `json:"error,omitempty"`
+}
+
+// handleTruncate deletes every row of a table on every node of the cluster, including the ones not flushed to the
+// sinks yet. Without the confirm parameter, it returns the token to confirm the truncation with, which is valid
+// for a few minutes on every node. The data already flushed to the sinks is not modified.
+func (s *Server) handleTruncate(w http.ResponseWriter, r *http.Request) error {
+	name := mux.Vars(r)["name"]
+	t, err := s.getTable(name)
+	if err != nil {
+		return errors.NotFound(err.Error())
+	}
+
+	if _, ok := t.(truncater); !ok {
+		return errors.InvalidArgument("table " + name + " can not be truncated")
+	}
+
+	query := r.URL.Query()
+	confirm := query.Get("confirm")
+	if confirm == "" {
+		now := time.Now()
+		return writeJSON(w, http.StatusOK, truncateConfirmation{
+			Table:   name,
+			Size:    sizeOf(t),
+			Confirm: s.confirmation(name, now),
+			Expires: now.Truncate(confirmWindow).Add(confirmWindow),
+		})
+	}
+
+	if !s.confirmed(name, confirm) {
+		return errors.InvalidArgument("the confirmation of the truncation of " + name + " is invalid or expired")
+	}
+
+	// A node asked by another one only truncates its own copy
+	self := s.truncate(name)
+	if query.Get("local") == "true" || s.cluster == nil {
+		return writeJSON(w, http.StatusOK, []truncatedNode{self})
+	}
+
+	members := s.cluster.Members()
+	out := make([]truncatedNode, len(members))
+	path := "/v1/admin/tables/" + url.PathEscape(name) + "/truncate?local=true&confirm=" + url.QueryEscape(confirm)
+	var wg sync.WaitGroup
+	for i, addr := range members {
+		if addr == s.self() {
+			out[i] = self
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			var peer []truncatedNode
+			if err := s.callPeer(r.Context(), http.MethodPost, addr, path, &peer); err != nil || len(peer) == 0 {
+				out[i] = truncatedNode{Address: addr, Error: errorOf(err)}
+				return
+			}
+
+			out[i] = peer[0]
+			out[i].Address = addr
+		}(i, addr)
+	}
+
+	wg.Wait()
+	return writeJSON(w, http.StatusOK, out)
+}
+
+// truncate deletes every row of a table on this node
+func (s *Server) truncate(name string) truncatedNode {
+	out := truncatedNode{Address: s.self()}
+	t, err := s.getTable(name)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	out.Size = sizeOf(t)
+	if err := t.(truncater).Truncate(); err != nil {
+		out.Error = err.Error()
+		s.monitor.Warning(errors.Internal("server: unable to truncate "+name, err))
+		return out
+	}
+
+	s.monitor.Count1(ctxTag, "table.truncate", "table:"+name)
+	s.monitor.Log(logging.LevelWarning, "server: table truncated", logging.F("table", name), logging.F("size", out.Size))
+	return out
+}
+
+// confirmation returns the token confirming the truncation of a table during the window of a time, which every
+// node can verify since it is signed with the token of the admin API
+func (s *Server) confirmation(table string, at time.Time) string {
+	window := at.Truncate(confirmWindow).Unix()
+	mac := hmac.New(sha256.New, []byte(s.conf().Admin.Token))
+	_, _ = mac.Write([]byte("truncate:" + table + ":" + strconv.FormatInt(window, 10)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// confirmed checks whether the token confirms the truncation of a table, during the current or the previous window
+func (s *Server) confirmed(table, token string) bool {
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(-confirmWindow)} {
+		if hmac.Equal([]byte(token), []byte(s.confirmation(table, at))) {
+			return true
+		}
+	}
+	return false
+}
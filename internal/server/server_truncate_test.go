@@ -0,0 +1,90 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// truncateTable is a table which records its truncations
+type truncateTable struct {
+	flushTable
+	truncations int
+}
+
+func (t *truncateTable) Truncate() error { t.truncations++; return nil }
+
+func TestTruncate(t *testing.T) {
+	events := &truncateTable{flushTable: flushTable{appendTable: appendTable{Table: *nodes.New(new(testMembership))}}}
+	conf := &config.Config{
+		Admin: &config.Admin{Token: "secret"},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/admin/tables/{name}/truncate", s.admin(s.handleTruncate)).Methods(http.MethodPost)
+	call := func(url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, url, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// The truncation must be confirmed
+	w := call("/v1/admin/tables/events/truncate")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var confirmation truncateConfirmation
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&confirmation))
+	assert.Equal(t, "events", confirmation.Table)
+	assert.Equal(t, int64(1024), confirmation.Size)
+	assert.Len(t, confirmation.Confirm, 32)
+	assert.True(t, confirmation.Expires.After(time.Now()))
+	assert.Equal(t, 0, events.truncations)
+
+	assert.Equal(t, http.StatusBadRequest, call("/v1/admin/tables/events/truncate?confirm=wrong").Code)
+	assert.Equal(t, http.StatusNotFound, call("/v1/admin/tables/xxx/truncate").Code)
+	assert.True(t, s.confirmed("events", s.confirmation("events", time.Now().Add(-confirmWindow))))
+	assert.False(t, s.confirmed("events", s.confirmation("events", time.Now().Add(-2*confirmWindow))))
+	assert.False(t, s.confirmed("other", confirmation.Confirm))
+
+	// Without a cluster, only the table of this node is truncated
+	w = call("/v1/admin/tables/events/truncate?confirm=" + confirmation.Confirm)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, events.truncations)
+
+	// Serve the remote node with the same server, on the admin port
+	remote := httptest.NewServer(router)
+	defer remote.Close()
+	u, _ := url.Parse(remote.URL)
+	port, _ := strconv.Atoi(u.Port())
+	conf.Admin.Port = int32(port)
+	s.SetMembership(adminMembership{"127.0.0.1", "127.0.0.2"})
+
+	// The table is truncated on every node, the first one over HTTP
+	w = call("/v1/admin/tables/events/truncate?confirm=" + confirmation.Confirm)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var nodes []truncatedNode
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&nodes))
+	assert.Equal(t, []truncatedNode{
+		{Address: "127.0.0.1", Size: 1024},
+		{Address: "127.0.0.2", Size: 1024},
+	}, nodes)
+	assert.Equal(t, 3, events.truncations)
+}
@@ -0,0 +1,245 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/ingress/pipeline"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/table"
+	talaria "github.com/kelindar/talaria/proto"
+)
+
+const (
+	maxSamples = 5  // The number of rows and computed values given as a sample
+	maxErrors  = 20 // The number of errors reported, the others being only counted
+)
+
+// validation represents the outcome of the dry run of the ingestion of a request into a table
+type validation struct {
+	Table    string                     `json:"table"`              // The name of the table
+	Decoded  int64                      `json:"decoded"`            // The number of rows decoded
+	Accepted int64                      `json:"accepted"`           // The number of rows which would be appended
+	Dropped  map[string]int64           `json:"dropped,omitempty"`  // The number of rows dropped, by reason
+	Blocks   int                        `json:"blocks"`             // The number of blocks which would be appended
+	Size     int64                      `json:"size"`               // The size of the blocks, in bytes
+	Schema   typeof.Schema              `json:"schema"`             // The columns which would be stored, with their types
+	Added    []string                   `json:"added,omitempty"`    // The columns which would be added to the schema
+	Computed map[string]*computedOutput `json:"computed,omitempty"` // The values of the computed columns
+	Samples  []map[string]interface{}   `json:"samples,omitempty"`  // The first rows, as they would be stored
+	Errors   []string                   `json:"errors,omitempty"`   // The reasons the rows or the request would be rejected
+}
+
+// computedOutput represents the values of a computed column during a dry run
+type computedOutput struct {
+	Type    typeof.Type   `json:"type"`            // The type of the column
+	Count   int64         `json:"count"`           // The number of rows with a value
+	Errors  int64         `json:"errors"`          // The number of rows the column failed for
+	Error   string        `json:"error,omitempty"` // The first error of the column
+	Samples []interface{} `json:"samples,omitempty"`
+}
+
+// handleValidate runs a file sent in the body, or downloaded from the url parameter, through the decoding, the
+// pipeline, the computed columns and the schema of the tables named by the table parameters (or every table),
+// without appending, publishing, forwarding or recording anything. It reports the rows which would be stored,
+// so that a new producer can be validated safely.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) error {
+	request, err := requestOf(w, r)
+	if err != nil {
+		return err
+	}
+
+	tables := s.Tables()
+	if names := r.URL.Query()["table"]; len(names) > 0 {
+		tables = make([]table.Table, 0, len(names))
+		for _, name := range names {
+			t, err := s.getTable(name)
+			if err != nil {
+				return errors.NotFound(err.Error())
+			}
+			tables = append(tables, t)
+		}
+	}
+
+	settings := s.settings()
+	out := make([]*validation, 0, len(tables))
+	for _, t := range tables {
+		if appender, ok := t.(table.Appender); ok {
+			out = append(out, s.validate(request, t, appender, settings))
+		}
+	}
+
+	return writeJSON(w, http.StatusOK, out)
+}
+
+// validate runs the request through the same stages as ingestTable, up to the blocks which would be appended
+func (s *Server) validate(request *talaria.IngestRequest, t table.Table, appender table.Appender, settings *settings) *validation {
+	out := &validation{
+		Table:    t.Name(),
+		Dropped:  make(map[string]int64),
+		Computed: make(map[string]*computedOutput),
+	}
+
+	if err, ok := settings.broken[t.Name()]; ok {
+		out.fail(fmt.Sprintf("the pipeline of the table can not be loaded: %v", err))
+		return out
+	}
+
+	var filter *typeof.Schema
+	if schema, static := t.Schema(); static {
+		filter = &schema
+	}
+
+	decode := s.hintsOf(t.Name(), request, filter)
+	if parsed, ok := settings.parsed[t.Name()]; ok && filter != nil {
+		decode = withoutColumns(*filter, parsed)
+	}
+
+	funcs := make([]applyFunc, 0, 8)
+	funcs = append(funcs, func(r block.Row) (block.Row, error) {
+		out.Decoded++
+		return r, nil
+	})
+
+	// The rejected rows are only reported, rather than routed to the errors destination of the table
+	if strict, ok := settings.strict[t.Name()]; ok {
+		expected := s.expectedOf(t.Name(), decode, settings)
+		funcs = append(funcs, dropped(out, "strict", pipeline.Strict(t.Name(), strict, expected, func(r block.Row) error {
+			out.fail(fmt.Sprintf("row %d: %v", out.Decoded, r.Values[pipeline.ErrorColumn]))
+			return nil
+		}, s.monitor)))
+		decode = nil
+	}
+
+	for i, stage := range settings.pipeline[t.Name()] {
+		funcs = append(funcs, dropped(out, fmt.Sprintf("stage %d", i), stage))
+	}
+
+	computed := computedFor(t, settings.computed)
+	funcs = append(funcs, out.compute(computed), block.Transform(filter, computed...), out.sample(computed))
+
+	blocks, err := block.FromRequestBy(request, appender.HashBy(), decode, funcs...)
+	if err != nil {
+		out.fail(fmt.Sprintf("unable to read the block: %v", err))
+		return out
+	}
+
+	out.Blocks = len(blocks)
+	out.Schema = make(typeof.Schema)
+	for _, b := range blocks {
+		out.Size += b.Size
+		for column, typ := range b.Schema() {
+			if existing, ok := out.Schema[column]; ok && existing != typ {
+				out.fail(fmt.Sprintf("column %s was ingested as both %s and %s", column, existing, typ))
+				continue
+			}
+			out.Schema[column] = typ
+		}
+	}
+
+	// Compare with the latest version of the schema of the table, without evolving it
+	if s.registry != nil {
+		latest, _ := s.registry.Latest(t.Name())
+		for _, column := range out.Schema.Columns() {
+			switch existing, ok := latest.Schema[column]; {
+			case !ok:
+				out.Added = append(out.Added, column)
+			case existing != out.Schema[column]:
+				out.fail(fmt.Sprintf("column %s is %s in version %d of the schema, but was ingested as %s",
+					column, existing, latest.Version, out.Schema[column]))
+			}
+		}
+	}
+	return out
+}
+
+// dropped wraps a stage so that the rows it drops are counted for a reason
+func dropped(out *validation, reason string, apply applyFunc) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		row, err := apply(r)
+		if err == block.ErrDropped {
+			out.Dropped[reason]++
+		}
+		return row, err
+	}
+}
+
+// compute evaluates the computed columns on a row, recording their errors, since the transformation of the row
+// skips the columns which fail
+func (v *validation) compute(computed []column.Computed) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		for _, c := range computed {
+			var err error
+			if m, ok := c.(column.Multi); ok {
+				_, err = m.Values(r.Values)
+			} else {
+				_, err = c.Value(r.Values)
+			}
+
+			if err != nil {
+				output := v.outputOf(c.Name(), c.Type())
+				output.Errors++
+				if output.Error == "" {
+					output.Error = err.Error()
+				}
+			}
+		}
+		return r, nil
+	}
+}
+
+// sample records the values of the computed columns and the first rows, as they would be stored
+func (v *validation) sample(computed []column.Computed) applyFunc {
+	return func(r block.Row) (block.Row, error) {
+		v.Accepted++
+		for _, c := range computed {
+			names := typeof.Schema{c.Name(): c.Type()}
+			if m, ok := c.(column.Multi); ok {
+				names = m.Schema()
+			}
+
+			for name, typ := range names {
+				if value, ok := r.Values[name]; ok && value != nil {
+					output := v.outputOf(name, typ)
+					output.Count++
+					if len(output.Samples) < maxSamples {
+						output.Samples = append(output.Samples, value)
+					}
+				}
+			}
+		}
+
+		// Copy the values, since the row is reused once appended
+		if len(v.Samples) < maxSamples {
+			row := make(map[string]interface{}, len(r.Values))
+			for k, value := range r.Values {
+				row[k] = value
+			}
+			v.Samples = append(v.Samples, row)
+		}
+		return r, nil
+	}
+}
+
+// outputOf returns the output of a computed column
+func (v *validation) outputOf(name string, typ typeof.Type) *computedOutput {
+	output, ok := v.Computed[name]
+	if !ok {
+		output = &computedOutput{Type: typ}
+		v.Computed[name] = output
+	}
+	return output
+}
+
+// fail records an error, up to the maximum number of errors reported
+func (v *validation) fail(reason string) {
+	if len(v.Errors) < maxErrors {
+		v.Errors = append(v.Errors, reason)
+	}
+}
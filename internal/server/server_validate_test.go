@@ -0,0 +1,73 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	events := &appendTable{Table: *nodes.New(new(testMembership))}
+	conf := &config.Config{
+		Admin: &config.Admin{Token: "secret"},
+		Computed: []config.Computed{{Name: "double", Type: typeof.Int64, Func: `
+		function main(row)
+			return row["value"] * 2
+		end`}},
+		Tables: config.Tables{
+			"events": {Filter: `
+			function main(row)
+				return row["event"] ~= "heartbeat"
+			end`},
+		},
+	}
+
+	s := New(func() *config.Config { return conf }, monitor.NewNoop(), script.NewLoader(nil), events)
+	call := func(url, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.admin(s.handleValidate)(w, r)
+		return w
+	}
+
+	w := call("/v1/admin/validate?table=events", "text/csv", "event,value\nheartbeat,1\nclick,2\nview,x\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out []validation
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&out))
+	assert.Len(t, out, 1)
+	assert.Equal(t, "events", out[0].Table)
+	assert.Equal(t, int64(3), out[0].Decoded)
+	assert.Equal(t, int64(2), out[0].Accepted)
+	assert.Equal(t, map[string]int64{"stage 0": 1}, out[0].Dropped)
+	assert.Equal(t, 2, out[0].Blocks)
+	assert.Equal(t, typeof.Schema{"event": typeof.String, "value": typeof.String, "double": typeof.Int64}, out[0].Schema)
+	assert.Len(t, out[0].Samples, 2)
+
+	// The computed column failed for the value which is not a number
+	double := out[0].Computed["double"]
+	assert.Equal(t, int64(1), double.Count)
+	assert.Equal(t, int64(1), double.Errors)
+	assert.NotEmpty(t, double.Error)
+	assert.Equal(t, []interface{}{float64(4)}, double.Samples)
+
+	// Nothing was appended
+	assert.Empty(t, events.blocks)
+
+	assert.Equal(t, http.StatusNotFound, call("/v1/admin/validate?table=xxx", "text/csv", "event\nclick\n").Code)
+	assert.Equal(t, http.StatusBadRequest, call("/v1/admin/validate", "text/plain", "event\nclick\n").Code)
+}
@@ -0,0 +1,92 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package slowlog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+)
+
+const (
+	ctxTag          = "slowlog"
+	defaultCapacity = 1000
+)
+
+// Entry represents a single slow request
+type Entry struct {
+	Time       time.Time     // The time at which the request was completed
+	Table      string        // The name of the table
+	Operation  string        // The operation, either "get_splits" or "get_rows"
+	Duration   time.Duration // The duration of the request
+	Bytes      int64         // The number of bytes returned
+	Rows       int64         // The number of rows (or splits) returned
+	Constraint string        // The pushed-down constraint or the split
+}
+
+// Log represents a log of slow requests, keeping the most recent ones in memory.
+type Log struct {
+	lock      sync.Mutex      // The lock protecting the entries
+	threshold time.Duration   // The latency threshold above which a request is logged
+	monitor   monitor.Monitor // The monitoring layer
+	entries   []Entry         // The ring buffer of entries
+	next      int             // The next position in the ring buffer
+	full      bool            // Whether the ring buffer has wrapped around
+}
+
+// New creates a new slow request log.
+func New(threshold time.Duration, capacity int, monitor monitor.Monitor) *Log {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+
+	return &Log{
+		threshold: threshold,
+		monitor:   monitor,
+		entries:   make([]Entry, capacity),
+	}
+}
+
+// IsSlow checks whether the duration exceeds the latency threshold.
+func (l *Log) IsSlow(d time.Duration) bool {
+	return d >= l.threshold
+}
+
+// Record records the request if it exceeds the latency threshold.
+func (l *Log) Record(e Entry) {
+	if !l.IsSlow(e.Duration) {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.monitor.Count1(ctxTag, "slow."+e.Operation)
+	l.monitor.Log(logging.LevelInfo, "slowlog: slow "+e.Operation, logging.Event("slow_query"),
+		logging.F("table", e.Table), logging.F("operation", e.Operation), logging.F("duration", e.Duration),
+		logging.F("rows", e.Rows), logging.F("bytes", e.Bytes), logging.F("constraint", e.Constraint))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	l.full = l.full || l.next == 0
+}
+
+// Entries returns the recent slow requests, oldest first.
+func (l *Log) Entries() []Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.full {
+		return append([]Entry(nil), l.entries[:l.next]...)
+	}
+
+	out := make([]Entry, 0, len(l.entries))
+	out = append(out, l.entries[l.next:]...)
+	return append(out, l.entries[:l.next]...)
+}
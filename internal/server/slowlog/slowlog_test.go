@@ -0,0 +1,31 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package slowlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowLog(t *testing.T) {
+	l := New(10*time.Millisecond, 2, monitor.NewNoop())
+
+	// Fast requests are not recorded
+	l.Record(Entry{Table: "a", Duration: time.Millisecond})
+	assert.Empty(t, l.Entries())
+
+	// Only the most recent entries are kept
+	l.Record(Entry{Table: "a", Duration: time.Second})
+	l.Record(Entry{Table: "b", Duration: time.Second})
+	l.Record(Entry{Table: "c", Duration: time.Second})
+
+	entries := l.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "b", entries[0].Table)
+	assert.Equal(t, "c", entries[1].Table)
+	assert.False(t, entries[0].Time.IsZero())
+}
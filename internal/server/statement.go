@@ -0,0 +1,368 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/presto"
+)
+
+// statement represents a query of a table, in the subset of SQL served over Arrow Flight SQL:
+//
+//	SELECT * | column [, column]... FROM [schema.]table [WHERE condition [AND condition]...]
+//
+// where each condition either matches the hash key with '=', or bounds the sort key with '=', '<', '<=', '>',
+// '>=' or BETWEEN, the times being unix timestamps in seconds or RFC3339 strings.
+type statement struct {
+	Table   string      // The name of the table, qualified with its schema if given
+	Columns []string    // The columns selected, every column if empty
+	Where   []condition // The conditions on the hash and the sort keys
+}
+
+// condition represents a comparison of a column with a value, or two values for BETWEEN
+type condition struct {
+	Column   string
+	Operator string
+	Values   []token
+}
+
+// parseStatement parses a statement
+func parseStatement(sql string) (*statement, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	stmt := new(statement)
+	if err := p.keyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	// The columns selected
+	if p.symbol("*") {
+		stmt.Columns = nil
+	} else {
+		for {
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+
+			stmt.Columns = append(stmt.Columns, column)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.keyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	if stmt.Table, err = p.identifier(); err != nil {
+		return nil, err
+	}
+
+	if p.symbol(".") {
+		table, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Table += "." + table
+	}
+
+	// The conditions, which must all be met
+	if p.keyword("WHERE") == nil {
+		for {
+			c, err := p.condition()
+			if err != nil {
+				return nil, err
+			}
+
+			stmt.Where = append(stmt.Where, c)
+			if p.keyword("AND") != nil {
+				break
+			}
+		}
+	}
+
+	p.symbol(";")
+	if !p.done() {
+		return nil, errors.InvalidArgument("unexpected '" + p.peek().text + "' in the statement")
+	}
+	return stmt, nil
+}
+
+// domainOf builds the constraint of the table from the conditions of the statement
+func (s *statement) domainOf(hashBy, sortBy string) (*presto.PrestoThriftTupleDomain, error) {
+	domain := &presto.PrestoThriftTupleDomain{Domains: map[string]*presto.PrestoThriftDomain{}}
+	from, until := time.Unix(0, 0), time.Unix(0, math.MaxInt64)
+	for _, c := range s.Where {
+		switch {
+		case c.Column == hashBy && c.Operator == "=":
+			domain.Domains[hashBy] = presto.KeyDomain(c.Values[0].block())
+			continue
+		case c.Column != sortBy:
+			return nil, errors.InvalidArgument("only the hash key can be matched and the sort key bounded, not '" + c.Column + "'")
+		}
+
+		times := make([]time.Time, 0, 2)
+		for _, v := range c.Values {
+			t, err := v.time()
+			if err != nil {
+				return nil, err
+			}
+			times = append(times, t)
+		}
+
+		switch c.Operator {
+		case "=":
+			from, until = latest(from, times[0]), earliest(until, times[0])
+		case ">=":
+			from = latest(from, times[0])
+		case ">":
+			from = latest(from, times[0].Add(time.Second))
+		case "<=":
+			until = earliest(until, times[0])
+		case "<":
+			until = earliest(until, times[0].Add(-time.Second))
+		case "BETWEEN":
+			from, until = latest(from, times[0]), earliest(until, times[1])
+		default:
+			return nil, errors.InvalidArgument("the sort key can not be compared with '" + c.Operator + "'")
+		}
+	}
+
+	domain.Domains[sortBy] = presto.TimeRange(from, until)
+	return domain, nil
+}
+
+// latest returns the latest of two times
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// earliest returns the earliest of two times
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// The kinds of tokens of a statement
+const (
+	tokenIdentifier = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+// token represents a token of a statement
+type token struct {
+	kind int
+	text string
+}
+
+// block returns the value of the token as a block of a single value
+func (t token) block() *presto.PrestoThriftBlock {
+	if t.kind == tokenNumber {
+		if v, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &presto.PrestoThriftBlock{BigintData: &presto.PrestoThriftBigint{Nulls: []bool{false}, Longs: []int64{v}}}
+		}
+	}
+
+	return &presto.PrestoThriftBlock{VarcharData: &presto.PrestoThriftVarchar{
+		Nulls: []bool{false},
+		Sizes: []int32{int32(len(t.text))},
+		Bytes: []byte(t.text),
+	}}
+}
+
+// time returns the value of the token as a time, either a unix timestamp in seconds or an RFC3339 string
+func (t token) time() (time.Time, error) {
+	if v, err := parseTime(t.text); err == nil && t.kind != tokenSymbol && t.kind != tokenIdentifier {
+		return v, nil
+	}
+	return time.Time{}, errors.InvalidArgument("the sort key must be compared with a unix timestamp or an RFC3339 string, not '" + t.text + "'")
+}
+
+// tokenize splits a statement into tokens, the identifiers being either bare or double-quoted and the strings
+// single-quoted, a quote being escaped by doubling it.
+func tokenize(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		switch c := sql[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var text []byte
+			j := i + 1
+			for ; j < len(sql); j++ {
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						text = append(text, c)
+						j++
+						continue
+					}
+					break
+				}
+				text = append(text, sql[j])
+			}
+
+			if j >= len(sql) {
+				return nil, errors.InvalidArgument("unterminated quote in the statement")
+			}
+
+			kind := tokenString
+			if c == '"' {
+				kind = tokenIdentifier
+			}
+			tokens = append(tokens, token{kind: kind, text: string(text)})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: sql[i:j]})
+			i = j
+		case c == '_' || (c|0x20 >= 'a' && c|0x20 <= 'z'):
+			j := i + 1
+			for j < len(sql) && (sql[j] == '_' || (sql[j]|0x20 >= 'a' && sql[j]|0x20 <= 'z') || (sql[j] >= '0' && sql[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: sql[i:j]})
+			i = j
+		case c == '<' || c == '>':
+			if i+1 < len(sql) && sql[i+1] == '=' {
+				tokens = append(tokens, token{kind: tokenSymbol, text: sql[i : i+2]})
+				i += 2
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: sql[i : i+1]})
+			i++
+		case strings.IndexByte("*,.=;", c) >= 0:
+			tokens = append(tokens, token{kind: tokenSymbol, text: sql[i : i+1]})
+			i++
+		default:
+			return nil, errors.InvalidArgument("unexpected '" + string(c) + "' in the statement")
+		}
+	}
+	return tokens, nil
+}
+
+// parser reads the tokens of a statement
+type parser struct {
+	tokens []token
+	next   int
+}
+
+// done checks whether every token was read
+func (p *parser) done() bool {
+	return p.next >= len(p.tokens)
+}
+
+// peek returns the next token, without reading it
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenSymbol, text: "end of statement"}
+	}
+	return p.tokens[p.next]
+}
+
+// keyword reads a keyword, case insensitively
+func (p *parser) keyword(keyword string) error {
+	if t := p.peek(); t.kind != tokenIdentifier || !strings.EqualFold(t.text, keyword) {
+		return errors.InvalidArgument("expected " + keyword + " instead of '" + t.text + "' in the statement")
+	}
+
+	p.next++
+	return nil
+}
+
+// symbol reads a symbol if it is the next token
+func (p *parser) symbol(symbol string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == symbol && !p.done() {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// identifier reads an identifier
+func (p *parser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdentifier || p.done() {
+		return "", errors.InvalidArgument("expected an identifier instead of '" + t.text + "' in the statement")
+	}
+
+	p.next++
+	return t.text, nil
+}
+
+// value reads a string or a number
+func (p *parser) value() (token, error) {
+	t := p.peek()
+	if (t.kind != tokenString && t.kind != tokenNumber) || p.done() {
+		return token{}, errors.InvalidArgument("expected a value instead of '" + t.text + "' in the statement")
+	}
+
+	p.next++
+	return t, nil
+}
+
+// condition reads a comparison of a column with a value, or a BETWEEN with two values
+func (p *parser) condition() (condition, error) {
+	column, err := p.identifier()
+	if err != nil {
+		return condition{}, err
+	}
+
+	if p.keyword("BETWEEN") == nil {
+		low, err := p.value()
+		if err != nil {
+			return condition{}, err
+		}
+
+		if err := p.keyword("AND"); err != nil {
+			return condition{}, err
+		}
+
+		high, err := p.value()
+		if err != nil {
+			return condition{}, err
+		}
+		return condition{Column: column, Operator: "BETWEEN", Values: []token{low, high}}, nil
+	}
+
+	operator := p.peek()
+	switch operator.text {
+	case "=", "<", "<=", ">", ">=":
+		if operator.kind == tokenSymbol && !p.done() {
+			p.next++
+			break
+		}
+		fallthrough
+	default:
+		return condition{}, errors.InvalidArgument("expected a comparison instead of '" + operator.text + "' in the statement")
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return condition{}, err
+	}
+	return condition{Column: column, Operator: operator.text, Values: []token{value}}, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		sql     string
+		table   string
+		columns []string
+		where   int
+		err     bool
+	}{
+		{sql: "SELECT * FROM events", table: "events"},
+		{sql: "select a, \"b c\" from data.events;", table: "data.events", columns: []string{"a", "b c"}},
+		{sql: "SELECT a FROM events WHERE event = 'it''s' AND time BETWEEN 10 AND 20", table: "events", columns: []string{"a"}, where: 2},
+		{sql: "SELECT a FROM events WHERE time >= '2020-01-01T00:00:00Z' and time < 30", table: "events", columns: []string{"a"}, where: 2},
+		{sql: "SELECT FROM events", err: true},
+		{sql: "SELECT a FROM events WHERE", err: true},
+		{sql: "SELECT a FROM events WHERE a LIKE 'x'", err: true},
+		{sql: "SELECT a FROM events LIMIT 10", err: true},
+		{sql: "SELECT a FROM 'events", err: true},
+		{sql: "DELETE FROM events", err: true},
+	}
+
+	for _, tc := range tests {
+		stmt, err := parseStatement(tc.sql)
+		if tc.err {
+			assert.Error(t, err, tc.sql)
+			continue
+		}
+
+		assert.NoError(t, err, tc.sql)
+		assert.Equal(t, tc.table, stmt.Table, tc.sql)
+		assert.Equal(t, tc.columns, stmt.Columns, tc.sql)
+		assert.Len(t, stmt.Where, tc.where, tc.sql)
+	}
+}
+
+func TestStatement_Domain(t *testing.T) {
+	stmt, err := parseStatement("SELECT * FROM events WHERE event = 'a' AND time > 100 AND time <= '1970-01-01T00:03:20Z'")
+	assert.NoError(t, err)
+
+	domain, err := stmt.domainOf("event", "time")
+	assert.NoError(t, err)
+	assert.Equal(t, presto.TimeRange(time.Unix(101, 0), time.Unix(200, 0)), domain.Domains["time"])
+	assert.Equal(t, []byte("a"), domain.Domains["event"].ValueSet.RangeValueSet.Ranges[0].Low.Value.VarcharData.Bytes)
+
+	// Only the hash and the sort keys can be constrained
+	for _, sql := range []string{
+		"SELECT * FROM events WHERE other = 1",
+		"SELECT * FROM events WHERE event > 'a'",
+		"SELECT * FROM events WHERE time = 'yesterday'",
+	} {
+		stmt, err := parseStatement(sql)
+		assert.NoError(t, err, sql)
+		_, err = stmt.domainOf("event", "time")
+		assert.Error(t, err, sql)
+	}
+}
@@ -4,6 +4,7 @@
 package thriftlog
 
 import (
+	"crypto/tls"
 	"encoding/json"
 
 	"github.com/kelindar/talaria/internal/encoding/typeof"
@@ -17,6 +18,14 @@ type Service struct {
 	Monitor monitor.Monitor
 }
 
+// Bind serves a connection on behalf of its client, if the underlying service supports it
+func (s *Service) Bind(state *tls.ConnectionState) presto.PrestoThriftService {
+	if binder, ok := s.Service.(presto.Binder); ok {
+		return &Service{Service: binder.Bind(state), Monitor: s.Monitor}
+	}
+	return s
+}
+
 // Request information with additional data
 type requestPrestoGetIndexSplits struct {
 	SchemaTableName   *presto.PrestoThriftSchemaTableName `json:"schemaTableName,omitempty"`
@@ -5,7 +5,11 @@ package compact
 
 import (
 	"context"
+	"encoding/binary"
+	"math"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/grab/async"
@@ -14,6 +18,7 @@ import (
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/monitor/logging"
 	"github.com/kelindar/talaria/internal/storage"
 )
 
@@ -27,12 +32,39 @@ type BlockWriter interface {
 	WriteBlock([]block.Block, typeof.Schema) error
 }
 
+// Ownership represents a contract which decides whether the local node owns a hash key.
+type Ownership interface {
+	Owner(hash uint32) (addr string, local bool)
+}
+
+// Leader represents a function which returns the node elected to write the compacted data of the table.
+type Leader func() (addr string, local bool)
+
+// Handover represents a function which hands the blocks over to another node.
+type Handover func(addr string, blocks []block.Block) error
+
+// Filter represents a function which removes rows from the blocks before they are written, such as the rows
+// deleted after they were buffered.
+type Filter func(blocks []block.Block) ([]block.Block, error)
+
 // Storage represents compactor storage.
 type Storage struct {
-	compact async.Task      // The compaction worker
-	monitor monitor.Monitor // The monitor client
-	buffer  storage.Storage // The storage to use for buffering
-	dest    BlockWriter     // The compaction destination
+	name     string          // The name of the table, reported with the compaction events (optional)
+	compact  async.Task      // The compaction worker
+	monitor  monitor.Monitor // The monitor client
+	buffer   storage.Storage // The storage to use for buffering
+	dest     BlockWriter     // The compaction destination
+	ring     Ownership       // The ownership of the keys, when replicated (optional)
+	leader   Leader          // The election of the node writing to the destination (optional)
+	handover Handover        // The function handing the blocks over to the leader (optional)
+	flushed  int64           // The time of the last complete compaction, in unix nanoseconds
+	pending  int64           // The time of the oldest append not compacted yet, in unix nanoseconds
+	buffered int64           // The approximate size of the data not compacted yet, in bytes
+	failure  atomic.Value    // The error of the last write to the destination, if it failed
+	maxSize  int64           // The maximum size of the blocks merged together, in bytes (optional)
+	ranges   int             // The number of key ranges compacted concurrently, the number of CPUs by default
+	tiers    []BlockWriter   // The writers of the downsampled copies of the table (optional)
+	filter   Filter          // The function removing rows from the blocks before they are written (optional)
 }
 
 // New creates a new storage implementation.
@@ -41,11 +73,59 @@ func New(buffer storage.Storage, dest BlockWriter, monitor monitor.Monitor, inte
 		monitor: monitor,
 		buffer:  buffer,
 		dest:    dest,
+		flushed: time.Now().UnixNano(),
 	}
 	s.compact = compactEvery(interval, s.Compact)
 	return s
 }
 
+// SetName sets the name of the table compacted, so that the compaction events can be attributed to it.
+func (s *Storage) SetName(name string) {
+	s.name = name
+	if named, ok := s.dest.(interface{ SetName(string) }); ok {
+		named.SetName(name)
+	}
+}
+
+// SetMaxSize caps the size of the blocks merged together, so that a key with a large volume of data is
+// written out as several files instead of a single one. A size of zero leaves it unlimited.
+func (s *Storage) SetMaxSize(bytes int64) {
+	s.maxSize = bytes
+}
+
+// SetConcurrency sets the number of disjoint key ranges compacted concurrently. A count of zero compacts as
+// many ranges as there are CPUs.
+func (s *Storage) SetConcurrency(ranges int) {
+	s.ranges = ranges
+}
+
+// SetOwnership restricts the compaction to the keys owned by the local node. This is used when the keys are
+// replicated, so that only the owner writes them to the destination and the replicas let them expire.
+func (s *Storage) SetOwnership(ring Ownership) {
+	s.ring = ring
+}
+
+// SetCoordinator makes a single node of the cluster write the compacted data, so that every window of the
+// table produces one set of files. The other nodes hand their blocks over to the leader when compacting,
+// and keep them buffered until the handover succeeds.
+func (s *Storage) SetCoordinator(leader Leader, handover Handover) {
+	s.leader = leader
+	s.handover = handover
+}
+
+// SetDownsampling writes the compacted blocks to the tiers as well, once they were written to the destination,
+// so that each tier aggregates the rows before they leave the buffer. The blocks handed over to the leader
+// are downsampled by the leader instead.
+func (s *Storage) SetDownsampling(tiers ...BlockWriter) {
+	s.tiers = tiers
+}
+
+// SetFilter sets the function removing rows from the blocks before they are written or handed over, such as
+// the rows deleted since they were buffered. The blocks without any row left are deleted from the buffer.
+func (s *Storage) SetFilter(filter Filter) {
+	s.filter = filter
+}
+
 // compactEvery returns the task that compacts on a regular interval.
 func compactEvery(interval time.Duration, compact async.Work) async.Task {
 	return async.Invoke(context.Background(), func(ctx context.Context) (interface{}, error) {
@@ -63,6 +143,8 @@ func compactEvery(interval time.Duration, compact async.Work) async.Task {
 
 // Append adds an event into the buffer.
 func (s *Storage) Append(key key.Key, value []byte, ttl time.Duration) error {
+	atomic.CompareAndSwapInt64(&s.pending, 0, time.Now().UnixNano())
+	atomic.AddInt64(&s.buffered, int64(len(value)))
 	return s.buffer.Append(key, value, ttl)
 }
 
@@ -83,40 +165,97 @@ func (s *Storage) Delete(keys ...key.Key) error {
 	return s.buffer.Delete(keys...)
 }
 
-// Compact runs the compaction on the storage
+// Compact runs the compaction on the storage. The key space is partitioned into disjoint ranges of hashes,
+// which are compacted concurrently since the blocks of different hashes are never merged together.
 func (s *Storage) Compact(ctx context.Context) (interface{}, error) {
 	st := time.Now()
-	var hash uint32
-	var blocks []block.Block
-	var merged []key.Key
+	pending := atomic.SwapInt64(&s.pending, 0)
+	var failed int32
+	var count, size int64
 
 	concurrency := runtime.NumCPU()
 	queue := make(chan async.Task, concurrency)
 	wpool := async.Consume(context.Background(), concurrency, queue)
 
-	// Iterate through all of the blocks in the storage
+	// Iterate through all of the blocks in the storage, measuring again the size of the data not compacted
+	atomic.StoreInt64(&s.buffered, 0)
+	ranges := rangesOf(s.rangeCount())
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, seek, until key.Key) {
+			defer wg.Done()
+			n, bytes, err := s.compactRange(seek, until, queue, pending, &failed)
+			atomic.AddInt64(&count, n)
+			atomic.AddInt64(&size, bytes)
+			errs[i] = err
+		}(i, r[0], r[1])
+	}
+	wg.Wait()
+
+	// Wait for the pool to be close
+	close(queue)
+	out, err := wpool.Outcome()
+	if rangeErr := errors.Combine(errs...); rangeErr != nil {
+		out, err = nil, rangeErr
+	}
+
+	s.monitor.Histogram(ctxTag, "compactlatency", float64(time.Since(st)))
+	if err == nil {
+		atomic.StoreInt64(&s.flushed, st.UnixNano())
+		if atomic.LoadInt32(&failed) == 0 {
+			s.commit()
+		}
+	} else {
+		s.restore(pending)
+	}
+
+	s.report(st, count, size, err)
+	return out, err
+}
+
+// compactRange iterates through the blocks of a range of keys and queues the merges of the blocks of each hash
+func (s *Storage) compactRange(seek, until key.Key, queue chan<- async.Task, pending int64, failed *int32) (count, size int64, err error) {
+	var hash uint32
+	var merging int64
+	var blocks []block.Block
+	var merged []key.Key
+
 	schema := make(typeof.Schema, 4)
-	if err := s.buffer.Range(key.First(), key.Last(), func(k, v []byte) bool {
+	if err := s.buffer.Range(seek, until, func(k, v []byte) bool {
+		if s.ring != nil {
+			if _, local := s.ring.Owner(key.HashOf(k)); !local {
+				return false // Owned by another node, skip it
+			}
+		}
+
 		input, err := block.FromBuffer(v)
 		if err != nil {
 			s.monitor.Error(errors.Internal("compact: unable to read a buffer", err))
 			return true
 		}
 
+		count++
+		size += int64(len(v))
+		atomic.AddInt64(&s.buffered, int64(len(v)))
+
 		// Update the current hash
 		previous := hash
 		hash = key.HashOf(k)
 
-		// If the hash is unchanged and schemas merge cleanly, accumulate...
-		if mergedSchema, ok := schema.Union(input.Schema()); previous == 0 || (ok && hash == previous) {
+		// If the hash is unchanged, schemas merge cleanly and the size is under the limit, accumulate...
+		full := s.maxSize > 0 && merging+int64(len(v)) > s.maxSize
+		if mergedSchema, ok := schema.Union(input.Schema()); previous == 0 || (ok && hash == previous && !full) {
 			blocks = append(blocks, input)
 			merged = append(merged, key.Clone(k))
 			schema = mergedSchema
+			merging += int64(len(v))
 			return false
 		}
 
 		// Merge asynchronously and delete the keys on a successful merge
-		queue <- s.merge(merged, blocks, schema)
+		queue <- s.merge(merged, blocks, schema, merging, pending, failed)
 
 		// Reset both the schema and the set of blocks
 		blocks = make([]block.Block, 0, 16)
@@ -126,25 +265,144 @@ func (s *Storage) Compact(ctx context.Context) (interface{}, error) {
 		schema = input.Schema()
 		blocks = append(blocks, input)
 		merged = append(merged, key.Clone(k))
+		merging = int64(len(v))
 		return false
 	}); err != nil {
-		return nil, err
+		return count, size, err
 	}
 
 	// Merge one last time if we still have block
 	if len(blocks) > 0 {
-		queue <- s.merge(merged, blocks, schema)
+		queue <- s.merge(merged, blocks, schema, merging, pending, failed)
 	}
+	return count, size, nil
+}
 
-	// Wait for the pool to be close
-	close(queue)
-	out, err := wpool.Outcome()
-	s.monitor.Histogram(ctxTag, "compactlatency", float64(time.Since(st)))
-	return out, err
+// rangeCount returns the number of key ranges compacted concurrently
+func (s *Storage) rangeCount() int {
+	if s.ranges > 0 {
+		return s.ranges
+	}
+	return runtime.NumCPU()
+}
+
+// rangesOf partitions the key space into disjoint ranges of hashes, each one given by its first and last key
+func rangesOf(n int) [][2]key.Key {
+	if n < 1 {
+		n = 1
+	}
+
+	ranges := make([][2]key.Key, 0, n)
+	step := (uint64(math.MaxUint32) + 1) / uint64(n)
+	for i := 0; i < n; i++ {
+		seek, until := key.First(), key.Last()
+		binary.BigEndian.PutUint32(seek, uint32(uint64(i)*step))
+		if i < n-1 {
+			binary.BigEndian.PutUint32(until, uint32(uint64(i+1)*step-1))
+		}
+		ranges = append(ranges, [2]key.Key{seek, until})
+	}
+	return ranges
+}
+
+// commit completes the compaction window on the destination, if it supports it, such as by writing the manifest
+// of the files. A failure does not fail the compaction, as the data was written.
+func (s *Storage) commit() {
+	if committer, ok := s.dest.(interface{ Commit() error }); ok {
+		if err := committer.Commit(); err != nil {
+			s.monitor.Count1(ctxTag, "error", "type:commit")
+			s.monitor.Error(err)
+		}
+	}
 }
 
-// merge adds an key-value pair to the underlying database
-func (s *Storage) merge(keys []key.Key, blocks []block.Block, schema typeof.Schema) async.Task {
+// report records a compaction as an event, unless there was nothing to compact
+func (s *Storage) report(start time.Time, blocks, size int64, err error) {
+	if blocks == 0 && err == nil {
+		return
+	}
+
+	fields := []logging.Field{logging.Event("compaction"), logging.F("table", s.name),
+		logging.F("duration", time.Since(start)), logging.F("blocks", blocks), logging.F("bytes", size)}
+	if err != nil {
+		s.monitor.Log(logging.LevelWarning, "compact: compaction failed", append(fields, logging.F("error", err))...)
+		return
+	}
+
+	s.monitor.Log(logging.LevelInfo, "compact: compaction completed", fields...)
+}
+
+// restore restores the time of the oldest append not compacted, when a compaction fails
+func (s *Storage) restore(pending int64) {
+	for pending != 0 {
+		current := atomic.LoadInt64(&s.pending)
+		if (current != 0 && current <= pending) || atomic.CompareAndSwapInt64(&s.pending, current, pending) {
+			return
+		}
+	}
+}
+
+// Unflushed returns the time of the oldest append which was not compacted yet, if any.
+func (s *Storage) Unflushed() (time.Time, bool) {
+	if pending := atomic.LoadInt64(&s.pending); pending != 0 {
+		return time.Unix(0, pending), true
+	}
+	return time.Time{}, false
+}
+
+// Flushed returns the time at which the last complete compaction started. Every block appended
+// before that time was either compacted or is kept for another node.
+func (s *Storage) Flushed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.flushed))
+}
+
+// Buffered returns the approximate size of the data appended but not compacted yet, in bytes. It is
+// measured again by each compaction, so it does not drift when the blocks expire before being compacted.
+func (s *Storage) Buffered() int64 {
+	if size := atomic.LoadInt64(&s.buffered); size > 0 {
+		return size
+	}
+	return 0
+}
+
+// Size returns the size of the buffered data, in bytes, if the buffer reports it.
+func (s *Storage) Size() int64 {
+	if sizer, ok := s.buffer.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return 0
+}
+
+// Truncate deletes the data buffered and not compacted yet, if the buffer supports it, so that it never reaches
+// the destination.
+func (s *Storage) Truncate() error {
+	truncater, ok := s.buffer.(interface{ Truncate() error })
+	if !ok {
+		return errors.New("compact: the buffer can not be truncated")
+	}
+
+	if err := truncater.Truncate(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&s.pending, 0)
+	atomic.StoreInt64(&s.buffered, 0)
+	return nil
+}
+
+// GC runs the garbage collection on the buffer right away, or fails if the buffer does not support it.
+func (s *Storage) GC(ctx context.Context) (interface{}, error) {
+	if collector, ok := s.buffer.(interface {
+		GC(context.Context) (interface{}, error)
+	}); ok {
+		return collector.GC(ctx)
+	}
+	return nil, errors.Unimplemented("compact: the buffer does not support garbage collection")
+}
+
+// merge adds an key-value pair to the underlying database. If the blocks can not be written, the time of the
+// oldest append of the compaction is restored, as they are kept for the next one, and the failure is counted.
+func (s *Storage) merge(keys []key.Key, blocks []block.Block, schema typeof.Schema, size, pending int64, failed *int32) async.Task {
 	return async.NewTask(func(ctx context.Context) (_ interface{}, err error) {
 		if len(blocks) == 0 {
 			return
@@ -158,14 +416,29 @@ func (s *Storage) merge(keys []key.Key, blocks []block.Block, schema typeof.Sche
 			}
 		}
 
+		// Remove the rows deleted since the blocks were buffered
+		if s.filter != nil {
+			if blocks, err = s.filter(blocks); err != nil {
+				s.monitor.Count1(ctxTag, "error", "type:filter")
+				s.restore(pending)
+				atomic.AddInt32(failed, 1)
+				s.monitor.Error(err)
+				return
+			}
+		}
+
 		// Merge all blocks together and write it through
 		// TODO: add ttl := time.Duration(max-now) * time.Second
-		if err = s.dest.WriteBlock(blocks, schema); err != nil {
+		if err = s.write(keys[0], blocks, schema); err != nil {
 			s.monitor.Count1(ctxTag, "error", "type:append")
+			s.restore(pending)
+			atomic.AddInt32(failed, 1)
 			s.monitor.Error(err)
 			return
 		}
 
+		atomic.AddInt64(&s.buffered, -size)
+
 		start := time.Now()
 		//  Delete all of the keys that we have appended
 		if err = s.buffer.Delete(keys...); err != nil {
@@ -178,9 +451,90 @@ func (s *Storage) merge(keys []key.Key, blocks []block.Block, schema typeof.Sche
 	})
 }
 
+// Check checks whether the buffer is writable, if the buffer supports the check.
+func (s *Storage) Check(ctx context.Context) error {
+	if checker, ok := s.buffer.(interface{ Check(context.Context) error }); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// CheckSink returns the error of the last write to the destination, if it failed, or the error of the check of the
+// destination, if it supports it, such as while the circuit of a sink is open.
+func (s *Storage) CheckSink(ctx context.Context) error {
+	if failure, ok := s.failure.Load().(failure); ok && failure.err != nil {
+		return errors.Internal("compact: unable to write to the sink", failure.err)
+	}
+
+	if checker, ok := s.dest.(interface{ CheckSink(context.Context) error }); ok {
+		return checker.CheckSink(ctx)
+	}
+	return nil
+}
+
+// write writes the blocks to the destination, or hands them over to the leader if the local node is not the leader
+func (s *Storage) write(first key.Key, blocks []block.Block, schema typeof.Schema) error {
+	switch {
+	case len(blocks) == 0:
+		return nil // Every row was filtered out
+	case s.leader == nil:
+		return s.writeBlock(first, blocks, schema)
+	}
+
+	addr, local := s.leader()
+	if local {
+		return s.writeBlock(first, blocks, schema)
+	}
+
+	s.monitor.Count(ctxTag, "handover", int64(len(blocks)))
+	return s.handover(addr, blocks)
+}
+
+// writeBlock writes the blocks to the destination, along with the first buffered key if the destination names
+// the files after it, and records whether it succeeded
+func (s *Storage) writeBlock(first key.Key, blocks []block.Block, schema typeof.Schema) error {
+	var err error
+	if dest, ok := s.dest.(interface {
+		WriteBlockAt(key.Key, []block.Block, typeof.Schema) error
+	}); ok {
+		err = dest.WriteBlockAt(first, blocks, schema)
+	} else {
+		err = s.dest.WriteBlock(blocks, schema)
+	}
+
+	s.failure.Store(failure{err: err})
+	if err == nil {
+		s.downsample(blocks, schema)
+	}
+	return err
+}
+
+// downsample writes the blocks to the tiers. A failure does not fail the compaction, as the data was written
+// to the destination and writing it again would duplicate it.
+func (s *Storage) downsample(blocks []block.Block, schema typeof.Schema) {
+	for _, tier := range s.tiers {
+		if err := tier.WriteBlock(blocks, schema); err != nil {
+			s.monitor.Count1(ctxTag, "error", "type:downsample")
+			s.monitor.Error(errors.Internal("compact: unable to downsample", err))
+		}
+	}
+}
+
+// failure wraps the error of a write, as an atomic value can not store nil
+type failure struct {
+	err error
+}
+
 // Close is used to gracefully close storage.
 func (s *Storage) Close() error {
 	s.compact.Cancel()
 	s.Compact(context.Background())
 	return storage.Close(s.buffer, s.dest)
 }
+
+// Drop compacts the buffered data a last time, so it still reaches the destination, and deletes the buffer.
+func (s *Storage) Drop() error {
+	s.compact.Cancel()
+	s.Compact(context.Background())
+	return errors.Combine(storage.Close(s.dest), storage.Drop(s.buffer))
+}
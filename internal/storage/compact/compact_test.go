@@ -5,6 +5,7 @@ package compact
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"sync/atomic"
@@ -17,6 +18,7 @@ import (
 	"github.com/kelindar/talaria/internal/encoding/key"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/storage"
 	"github.com/kelindar/talaria/internal/storage/disk"
 	"github.com/stretchr/testify/assert"
 )
@@ -105,3 +107,352 @@ func TestRange(t *testing.T) {
 		assert.Equal(t, int64(4), count)
 	})
 }
+
+func TestCompact_MaxSize(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var files int64
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&files, 1)
+			return nil
+		}
+
+		// At most two blocks are merged into a single file
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetMaxSize(int64(2 * len(input)))
+		for i := 0; i < 5; i++ {
+			_ = store.Append(key.New("A", time.Unix(int64(i), 0)), input, 60*time.Second)
+		}
+
+		store.Compact(context.Background())
+		assert.Equal(t, int64(3), files)
+	})
+}
+
+func TestCompact_Concurrency(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var files, blocks int64
+		var dest blockWriter = func(input []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&files, 1)
+			atomic.AddInt64(&blocks, int64(len(input)))
+			return nil
+		}
+
+		// Each key is merged into its own file, whichever range it falls into
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetConcurrency(8)
+		for _, name := range []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"} {
+			_ = store.Append(key.New(name, time.Unix(0, 0)), input, 60*time.Second)
+			_ = store.Append(key.New(name, time.Unix(1, 0)), input, 60*time.Second)
+		}
+
+		_, err := store.Compact(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), files)
+		assert.Equal(t, int64(20), blocks)
+	})
+}
+
+func TestRangesOf(t *testing.T) {
+	ranges := rangesOf(3)
+	assert.Len(t, ranges, 3)
+	assert.Equal(t, key.First(), ranges[0][0])
+	assert.Equal(t, key.Last(), ranges[2][1])
+	for i := 1; i < len(ranges); i++ {
+		assert.Equal(t, key.HashOf(ranges[i-1][1])+1, key.HashOf(ranges[i][0]))
+	}
+}
+
+// ownedBy is an ownership which only assigns the hash of one key to the local node
+type ownedBy string
+
+func (o ownedBy) Owner(hash uint32) (string, bool) {
+	return "", key.HashOf(key.New(string(o), time.Unix(0, 0))) == hash
+}
+
+func TestCompact_Ownership(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var count int64
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&count, int64(len(blocks)))
+			return nil
+		}
+
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetOwnership(ownedBy("B"))
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		_ = store.Append(key.New("B", time.Unix(0, 0)), input, 60*time.Second)
+		_ = store.Append(key.New("B", time.Unix(1, 0)), input, 60*time.Second)
+
+		// Only the blocks of the owned key are compacted, the others are kept
+		store.Compact(context.Background())
+		assert.Equal(t, int64(2), count)
+
+		var remaining int
+		_ = buffer.Range(key.First(), key.Last(), func(k, v []byte) bool {
+			remaining++
+			return false
+		})
+		assert.Equal(t, 1, remaining)
+	})
+}
+
+func TestCompact_Coordinator(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var written, handed int64
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&written, int64(len(blocks)))
+			return nil
+		}
+
+		var reachable int32
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetCoordinator(func() (string, bool) {
+			return "10.0.0.2", false
+		}, func(addr string, blocks []block.Block) error {
+			assert.Equal(t, "10.0.0.2", addr)
+			if atomic.LoadInt32(&reachable) == 0 {
+				return errors.New("unreachable")
+			}
+
+			atomic.AddInt64(&handed, int64(len(blocks)))
+			return nil
+		})
+
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		_ = store.Append(key.New("B", time.Unix(0, 0)), input, 60*time.Second)
+
+		// The blocks are kept while the leader can not be reached
+		store.Compact(context.Background())
+		assert.Equal(t, int64(0), written+handed)
+		assert.Equal(t, 2, count(buffer))
+
+		// The blocks are handed over to the leader, rather than written
+		atomic.StoreInt32(&reachable, 1)
+		store.Compact(context.Background())
+		assert.Equal(t, int64(0), written)
+		assert.Equal(t, int64(2), handed)
+		assert.Equal(t, 0, count(buffer))
+	})
+}
+
+func count(buffer *disk.Storage) (n int) {
+	_ = buffer.Range(key.First(), key.Last(), func(k, v []byte) bool {
+		n++
+		return false
+	})
+	return
+}
+
+func TestCompact_Check(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var failing int32 = 1
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("unreachable")
+			}
+			return nil
+		}
+
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		assert.NoError(t, store.Check(context.Background()))
+		assert.NoError(t, store.CheckSink(context.Background()))
+
+		// The sink is reported as unhealthy until a write succeeds
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		store.Compact(context.Background())
+		assert.Error(t, store.CheckSink(context.Background()))
+
+		atomic.StoreInt32(&failing, 0)
+		store.Compact(context.Background())
+		assert.NoError(t, store.CheckSink(context.Background()))
+		assert.Equal(t, 0, count(buffer))
+	})
+}
+
+func TestCompact_Unflushed(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var failing int32 = 1
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("unreachable")
+			}
+			return nil
+		}
+
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		_, ok := store.Unflushed()
+		assert.False(t, ok)
+
+		// The oldest append is kept while the compaction fails
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		oldest, ok := store.Unflushed()
+		assert.True(t, ok)
+
+		_ = store.Append(key.New("B", time.Unix(0, 0)), input, 60*time.Second)
+		store.Compact(context.Background())
+		at, ok := store.Unflushed()
+		assert.True(t, ok)
+		assert.Equal(t, oldest, at)
+		assert.Equal(t, int64(2*len(input)), store.Buffered())
+
+		// Nothing is left once compacted
+		atomic.StoreInt32(&failing, 0)
+		store.Compact(context.Background())
+		_, ok = store.Unflushed()
+		assert.False(t, ok)
+		assert.Equal(t, int64(0), store.Buffered())
+	})
+}
+
+// committer is a destination counting the windows committed
+type committer struct {
+	blockWriter
+	commits int32
+}
+
+func (c *committer) Commit() error {
+	atomic.AddInt32(&c.commits, 1)
+	return nil
+}
+
+func TestCompact_Commit(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var failing int32 = 1
+		dest := &committer{blockWriter: func(blocks []block.Block, schema typeof.Schema) error {
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("unreachable")
+			}
+			return nil
+		}}
+
+		// The window is only committed once every block is written
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		store.Compact(context.Background())
+		assert.Equal(t, int32(0), atomic.LoadInt32(&dest.commits))
+
+		atomic.StoreInt32(&failing, 0)
+		store.Compact(context.Background())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&dest.commits))
+	})
+}
+
+// keyedWriter represents a destination naming the files after the first buffered key
+type keyedWriter struct {
+	blockWriter
+	keys []key.Key
+}
+
+func (w *keyedWriter) WriteBlockAt(first key.Key, blocks []block.Block, schema typeof.Schema) error {
+	w.keys = append(w.keys, first)
+	return nil
+}
+
+func TestCompact_WriteBlockAt(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		dest := new(keyedWriter)
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		first := key.New("A", time.Unix(0, 0))
+		_ = store.Append(first, input, 60*time.Second)
+		_ = store.Append(key.New("A", time.Unix(1, 0)), input, 60*time.Second)
+		store.Compact(context.Background())
+
+		// The blocks are merged into a single file, named after the first key
+		assert.Equal(t, []key.Key{first}, dest.keys)
+	})
+}
+
+func TestCompact_Downsampling(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var failing int32 = 1
+		var written, downsampled int64
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("unavailable")
+			}
+
+			atomic.AddInt64(&written, int64(len(blocks)))
+			return nil
+		}
+
+		var tier blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&downsampled, int64(len(blocks)))
+			return errors.New("tier is unavailable")
+		}
+
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetDownsampling(tier)
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+		_ = store.Append(key.New("B", time.Unix(0, 0)), input, 60*time.Second)
+
+		// The blocks are not downsampled until they are written to the destination
+		store.Compact(context.Background())
+		assert.Equal(t, int64(0), downsampled)
+		assert.Equal(t, 2, count(buffer))
+
+		// A failure of the tier does not keep the blocks, as they were written
+		atomic.StoreInt32(&failing, 0)
+		_, err := store.Compact(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), written)
+		assert.Equal(t, int64(2), downsampled)
+		assert.Equal(t, 0, count(buffer))
+	})
+}
+
+func TestCompact_Filter(t *testing.T) {
+	runTest(t, func(buffer *disk.Storage) {
+		var written int64
+		var dest blockWriter = func(blocks []block.Block, schema typeof.Schema) error {
+			atomic.AddInt64(&written, int64(len(blocks)))
+			return nil
+		}
+
+		var failing int32 = 1
+		store := New(buffer, dest, monitor.NewNoop(), time.Hour)
+		store.SetFilter(func(blocks []block.Block) ([]block.Block, error) {
+			if atomic.LoadInt32(&failing) == 1 {
+				return nil, errors.New("unavailable")
+			}
+			return nil, nil
+		})
+
+		_ = store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second)
+
+		// The blocks are kept while they can not be filtered
+		store.Compact(context.Background())
+		assert.Equal(t, 1, count(buffer))
+
+		// The blocks without any row left are deleted, without being written
+		atomic.StoreInt32(&failing, 0)
+		_, err := store.Compact(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), written)
+		assert.Equal(t, 0, count(buffer))
+	})
+}
+
+// plainBuffer is a buffer which does not support the garbage collection
+type plainBuffer struct {
+	storage.Storage
+}
+
+func TestCompact_GC(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "test")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// The garbage collection is forwarded to the lazily opened buffer
+	buffer := disk.OpenLazy(dir, "events", monitor.NewNoop(), config.Badger{}, time.Minute)
+	store := New(buffer, blockWriter(func([]block.Block, typeof.Schema) error { return nil }), monitor.NewNoop(), time.Hour)
+	defer store.Close()
+
+	assert.NoError(t, store.Append(key.New("A", time.Unix(0, 0)), input, 60*time.Second))
+	_, err := store.GC(context.Background())
+	assert.NoError(t, err)
+
+	// A buffer without garbage collection is reported
+	runTest(t, func(buffer *disk.Storage) {
+		_, err := New(plainBuffer{buffer}, nil, monitor.NewNoop(), time.Hour).GC(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package disk
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	defaultBatchSize  = 4 << 20              // The default maximum size of the appends committed together
+	defaultBatchDelay = 5 * time.Millisecond // The default maximum time an append waits for the others
+)
+
+// pending represents an append waiting for its transaction to be committed
+type pending struct {
+	entry *badger.Entry
+	done  chan error
+}
+
+// batcher represents a group commit of the appends. The appends made concurrently are committed in a single
+// transaction, which is committed once it reaches the maximum size or once its first append waited for the
+// delay. An append made while no other is in progress is committed right away. If a transaction fails, the
+// appends which were not committed are retried one at a time, so that an append only fails on its own error.
+type batcher struct {
+	queue   chan *pending                              // The appends handed over to the committer
+	stop    chan struct{}                              // The signal to stop committing
+	stopped chan struct{}                              // Closed once the committer stopped
+	waiting int32                                      // The number of appends in progress
+	size    int64                                      // The maximum size of a transaction, in bytes
+	delay   time.Duration                              // The maximum time an append waits for the others
+	commit  func(entries []*badger.Entry) (int, error) // The function committing the entries, returning how many were committed
+	measure func(count int)                            // The function measuring the number of entries committed together
+}
+
+// newBatcher creates a group commit and starts committing
+func newBatcher(size int64, delay time.Duration, commit func([]*badger.Entry) (int, error), measure func(int)) *batcher {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	if delay <= 0 {
+		delay = defaultBatchDelay
+	}
+
+	b := &batcher{
+		queue:   make(chan *pending),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		size:    size,
+		delay:   delay,
+		commit:  commit,
+		measure: measure,
+	}
+
+	go b.run()
+	return b
+}
+
+// Append hands an entry over to the committer and waits for its transaction to be committed.
+func (b *batcher) Append(entry *badger.Entry) error {
+	atomic.AddInt32(&b.waiting, 1)
+	defer atomic.AddInt32(&b.waiting, -1)
+
+	p := &pending{entry: entry, done: make(chan error, 1)}
+	select {
+	case b.queue <- p:
+		return <-p.done
+	case <-b.stop:
+		return errors.New(errClosed)
+	}
+}
+
+// Close stops committing, once the transaction in progress is committed.
+func (b *batcher) Close() {
+	close(b.stop)
+	<-b.stopped
+}
+
+// run commits the appends until stopped
+func (b *batcher) run() {
+	defer close(b.stopped)
+	for {
+		select {
+		case <-b.stop:
+			return
+		case p := <-b.queue:
+			group := b.collect(p)
+			entries := make([]*badger.Entry, 0, len(group))
+			for _, p := range group {
+				entries = append(entries, p.entry)
+			}
+
+			// The appends committed before the failure are persisted, while the others are retried alone
+			n, err := b.commit(entries)
+			for i, p := range group {
+				switch {
+				case i < n:
+					p.done <- nil
+				case len(group) > 1:
+					_, err := b.commit(entries[i : i+1])
+					p.done <- err
+				default:
+					p.done <- err
+				}
+			}
+			b.measure(len(group))
+		}
+	}
+}
+
+// collect groups the first append with the others in progress, until the transaction is large enough or the
+// first append waited for the delay.
+func (b *batcher) collect(first *pending) []*pending {
+	group, size := []*pending{first}, sizeOf(first.entry)
+	var timeout <-chan time.Time
+	for size < b.size && int(atomic.LoadInt32(&b.waiting)) > len(group) {
+		if timeout == nil {
+			timer := time.NewTimer(b.delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case p := <-b.queue:
+			group = append(group, p)
+			size += sizeOf(p.entry)
+		case <-timeout:
+			return group
+		}
+	}
+	return group
+}
+
+// sizeOf returns the size of an entry, in bytes
+func sizeOf(entry *badger.Entry) int64 {
+	return int64(len(entry.Key) + len(entry.Value))
+}
@@ -6,7 +6,10 @@ package disk
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -26,6 +29,7 @@ import (
 const (
 	ctxTag    = "disk"
 	errClosed = "unable to run commands on a closed database"
+	epochFile = "EPOCH" // The file keeping the epoch of the storage
 )
 
 // Assert contract compliance
@@ -34,8 +38,10 @@ var _ storage.Storage = new(Storage)
 // Storage represents disk storage.
 type Storage struct {
 	closed  int32           // The closed flag
+	dir     string          // The directory of the storage
 	gc      async.Task      // Closing channel
 	db      *badger.DB      // The underlying key-value store
+	batch   *batcher        // The group commit of the appends (optional)
 	monitor monitor.Monitor // The stats client
 }
 
@@ -70,6 +76,7 @@ func (s *Storage) Open(dir string, options config.Badger) error {
 		return err
 	}
 
+	s.dir = dir
 	opts := badger.DefaultOptions(dir)
 
 	switch options.Default {
@@ -138,12 +145,73 @@ func (s *Storage) Open(dir string, options config.Badger) error {
 	return nil
 }
 
+// OpenReadOnly opens an existing directory without writing to it nor collecting its garbage, such as to inspect the
+// directory of a node. It fails while a node has the directory open, or if the node was not closed properly.
+func (s *Storage) OpenReadOnly(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+
+	s.dir = dir
+	db, err := badger.Open(badger.DefaultOptions(dir).
+		WithReadOnly(true).
+		WithLogger(&logger{s.monitor}))
+	if err != nil {
+		return err
+	}
+
+	s.db = db
+	return nil
+}
+
+// Epoch returns the epoch of the storage, a random identifier generated when its directory is first used. It
+// tells apart the data buffered by different nodes, or by the same node after its directory was lost.
+func (s *Storage) Epoch() (string, error) {
+	return epochOf(s.dir)
+}
+
+// epochOf returns the epoch of the storage in a directory, generating it if it does not exist yet
+func epochOf(dir string) (string, error) {
+	file := path.Join(dir, epochFile)
+	if b, err := ioutil.ReadFile(file); err == nil && len(b) > 0 {
+		return string(bytes.TrimSpace(b)), nil
+	}
+
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	epoch := hex.EncodeToString(b[:])
+	if err := ioutil.WriteFile(file, []byte(epoch), 0644); err != nil {
+		return "", errors.Internal("disk: unable to write the epoch", err)
+	}
+	return epoch, nil
+}
+
+// SetBatch groups the appends made concurrently into a single transaction of up to the size, in bytes, with
+// an append waiting up to the delay for the others to join it. An append made while no other is in progress
+// is committed right away. A zero size or delay uses the defaults of 4MB and 5ms.
+func (s *Storage) SetBatch(size int64, delay time.Duration) {
+	s.batch = newBatcher(size, delay, s.appendAll, func(count int) {
+		s.monitor.Histogram(ctxTag, "batch.count", float64(count))
+	})
+}
+
 // Append adds an event into the storage.
 func (s *Storage) Append(key key.Key, value []byte, ttl time.Duration) error {
 	if s.isClosed() {
 		return errors.New(errClosed)
 	}
 
+	if s.batch != nil {
+		return s.batch.Append(&badger.Entry{
+			Key:       key,
+			Value:     value,
+			ExpiresAt: uint64(time.Now().Add(ttl).Unix()),
+		})
+	}
+
 	if err := s.db.Update(func(tx *badger.Txn) error {
 		return tx.SetEntry(&badger.Entry{
 			Key:       key,
@@ -156,6 +224,44 @@ func (s *Storage) Append(key key.Key, value []byte, ttl time.Duration) error {
 	return nil
 }
 
+// appendAll adds the entries into the storage, in as few transactions as possible, and returns the number of
+// entries committed, since the entries of the transactions committed before one which failed are persisted.
+func (s *Storage) appendAll(entries []*badger.Entry) (int, error) {
+	const msg = "unable to append"
+	txn := s.db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	committed := 0
+	for i, entry := range entries {
+		err := txn.SetEntry(entry)
+
+		// If the transaction is too big, commit the current transaction
+		switch {
+		case err == badger.ErrTxnTooBig:
+			if err := txn.Commit(); err != nil {
+				return committed, errors.Internal(msg, err)
+			}
+
+			// Create a new transaction and append
+			committed = i
+			txn = s.db.NewTransaction(true)
+			if err := txn.SetEntry(entry); err != nil {
+				return committed, errors.Internal(msg, err)
+			}
+
+		// On any other error, fail the append
+		case err != nil:
+			return committed, errors.Internal(msg, err)
+		}
+	}
+
+	// Commit the transaction
+	if err := txn.Commit(); err != nil {
+		return committed, errors.Internal(msg, err)
+	}
+	return len(entries), nil
+}
+
 // Range performs a range query against the storage. It calls f sequentially for each key and value present in
 // the store. If f returns false, range stops the iteration. The API is designed to be very similar to the concurrent
 // map. The implementation must guarantee that the keys are lexigraphically sorted.
@@ -277,6 +383,45 @@ func (s *Storage) GC(ctx context.Context) (interface{}, error) {
 	return nil, nil
 }
 
+// Truncate deletes every key of the storage at once, the appends being blocked until it completes.
+func (s *Storage) Truncate() error {
+	if s.isClosed() {
+		return errors.New(errClosed)
+	}
+
+	if err := s.db.DropAll(); err != nil {
+		return errors.Internal("unable to truncate", err)
+	}
+	return nil
+}
+
+// Size returns the size of the data on disk, in bytes.
+func (s *Storage) Size() int64 {
+	if s.isClosed() {
+		return 0
+	}
+
+	lsm, vlog := s.db.Size()
+	return lsm + vlog
+}
+
+// Check checks whether the storage is writable, by writing and deleting a key which sorts after every
+// other key, so it is never read by a range query.
+func (s *Storage) Check(ctx context.Context) error {
+	if s.isClosed() {
+		return errors.New(errClosed)
+	}
+
+	probe := append(key.Last(), "health"...)
+	if err := s.db.Update(func(tx *badger.Txn) error {
+		return tx.Set(probe, []byte{})
+	}); err != nil {
+		return errors.Internal("unable to write", err)
+	}
+
+	return s.Delete(probe)
+}
+
 // Close is used to gracefully close the connection.
 func (s *Storage) Close() error {
 	if s.gc != nil {
@@ -284,9 +429,21 @@ func (s *Storage) Close() error {
 	}
 
 	atomic.StoreInt32(&s.closed, 1)
+	if s.batch != nil {
+		s.batch.Close()
+	}
 	return s.db.Close()
 }
 
+// Drop closes the storage and deletes its directory.
+func (s *Storage) Drop() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(s.dir)
+}
+
 // isClosed checks if the DB is closed or not.
 func (s *Storage) isClosed() bool {
 	return atomic.LoadInt32(&s.closed) == int32(1)
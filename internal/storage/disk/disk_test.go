@@ -9,9 +9,12 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/dgraph-io/badger/v2"
 	"github.com/kelindar/talaria/internal/config"
 
 	"github.com/kelindar/talaria/internal/encoding/key"
@@ -243,6 +246,33 @@ func BenchmarkRange(b *testing.B) {
 	})
 }
 
+func TestCheck(t *testing.T) {
+	runTest(t, func(store *Storage) {
+		assert.NoError(t, store.Check(context.Background()))
+
+		// The probe is not left behind
+		count := 0
+		assert.NoError(t, store.Range(key.First(), append(key.Last(), 0xff), func(k, v []byte) bool {
+			count++
+			return false
+		}))
+		assert.Equal(t, 0, count)
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	runTest(t, func(store *Storage) {
+		populate(store)
+		assert.Equal(t, 9000, countKeys(store))
+		assert.NoError(t, store.Truncate())
+		assert.Equal(t, 0, countKeys(store))
+
+		// The storage is still writable
+		assert.NoError(t, store.Append(key.Key("1"), []byte("1"), 60*time.Second))
+		assert.Equal(t, 1, countKeys(store))
+	})
+}
+
 func populate(store *Storage) {
 	for i := 1000; i < 10000; i++ {
 		key := asBytes(fmt.Sprintf("%d", i))
@@ -261,3 +291,131 @@ func TestOpen(t *testing.T) {
 		os.RemoveAll("test-table")
 	}, "Panic while creating disk storage and opening the directory")
 }
+
+func TestDrop(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "test")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	store := New(monitor.NewNoop())
+	assert.NoError(t, store.Open(dir, config.Badger{}))
+	assert.NoError(t, store.Append(key.New("A", time.Unix(0, 0)), []byte("A"), 60*time.Second))
+
+	// The directory is deleted along with the data
+	assert.NoError(t, store.Drop())
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestEpoch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "test")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	store := New(monitor.NewNoop())
+	assert.NoError(t, store.Open(dir, config.Badger{}))
+	epoch, err := store.Epoch()
+	assert.NoError(t, err)
+	assert.Len(t, epoch, 8)
+	assert.NoError(t, store.Close())
+
+	// The epoch is kept when the directory is opened again
+	store = New(monitor.NewNoop())
+	assert.NoError(t, store.Open(dir, config.Badger{}))
+	defer store.Close()
+	again, err := store.Epoch()
+	assert.NoError(t, err)
+	assert.Equal(t, epoch, again)
+}
+
+func TestBatch(t *testing.T) {
+	runTest(t, func(store *Storage) {
+		store.SetBatch(1<<20, 50*time.Millisecond)
+
+		// A lone append does not wait for the others
+		start := time.Now()
+		assert.NoError(t, store.Append(key.New("A", time.Unix(0, 0)), []byte("A"), 60*time.Second))
+		assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+		// The concurrent appends are all committed
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, store.Append(key.New("B", time.Unix(int64(i), 0)), []byte("B"), 60*time.Second))
+			}(i)
+		}
+		wg.Wait()
+
+		count := 0
+		assert.NoError(t, store.Range(key.First(), key.Last(), func(k, v []byte) bool {
+			count++
+			return false
+		}))
+		assert.Equal(t, 101, count)
+	})
+}
+
+func TestBatcher(t *testing.T) {
+	var groups int32
+	b := newBatcher(0, 20*time.Millisecond, func(entries []*badger.Entry) (int, error) {
+		atomic.AddInt32(&groups, 1)
+		time.Sleep(time.Millisecond) // The others pile up while committing
+		return len(entries), nil
+	}, func(int) {})
+
+	// The concurrent appends are grouped in fewer transactions
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, b.Append(&badger.Entry{Key: []byte("A")}))
+		}()
+	}
+	wg.Wait()
+	assert.True(t, atomic.LoadInt32(&groups) < 100)
+
+	// Once closed, the appends fail
+	b.Close()
+	assert.Error(t, b.Append(&badger.Entry{Key: []byte("A")}))
+}
+
+func TestBatcher_Partial(t *testing.T) {
+	var lock sync.Mutex
+	var grouped bool
+	persisted := make(map[string]int)
+
+	// Commit the entries up to the invalid one, which fails the transaction
+	b := newBatcher(0, 20*time.Millisecond, func(entries []*badger.Entry) (int, error) {
+		time.Sleep(20 * time.Millisecond) // The others pile up while committing
+		lock.Lock()
+		defer lock.Unlock()
+		if len(entries) > 1 {
+			grouped = true
+		}
+
+		for i, e := range entries {
+			if string(e.Key) == "bad" {
+				return i, fmt.Errorf("invalid entry")
+			}
+			persisted[string(e.Key)]++
+		}
+		return len(entries), nil
+	}, func(int) {})
+	defer b.Close()
+
+	// Each append only fails on its own error, and the ones committed are not committed again
+	var wg sync.WaitGroup
+	for _, k := range []string{"A", "B", "bad", "C", "D", "E"} {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			err := b.Append(&badger.Entry{Key: []byte(k)})
+			assert.Equal(t, k == "bad", err != nil, k)
+		}(k)
+	}
+	wg.Wait()
+
+	assert.True(t, grouped)
+	assert.Equal(t, map[string]int{"A": 1, "B": 1, "C": 1, "D": 1, "E": 1}, persisted)
+}
@@ -0,0 +1,311 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package disk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/storage"
+)
+
+// The number of storages currently open on this node, across the tables
+var opened int64
+
+// Assert contract compliance
+var _ storage.Storage = new(Lazy)
+
+// Lazy represents a disk storage which is only opened once it is used, and closed again once it is idle so that
+// the tables which are not written to do not hold the memory and the files of the key-value store.
+type Lazy struct {
+	used    int64           // The time of the last append, or of the opening, in unix nanoseconds
+	lock    sync.RWMutex    // The lock of the storage, held exclusively while opening or closing it
+	dir     string          // The directory of the storage
+	name    string          // The name of the table
+	options config.Badger   // The options of the key-value store
+	idle    time.Duration   // The time after which an unused storage is closed
+	store   *Storage        // The open storage, nil while closed
+	empty   bool            // Whether the storage had no data when it was closed
+	size    int64           // The size of the data on disk when the storage was closed
+	closed  bool            // Whether the storage was closed for good
+	batch   func(*Storage)  // The group commit applied to the storage once opened (optional)
+	unload  async.Task      // The task closing the storage once idle
+	monitor monitor.Monitor // The stats client
+}
+
+// OpenLazy creates a disk storage for a table which is opened on its first use and closed once it was not
+// appended to for the idle duration. While closed, a storage which had no data is read as empty without
+// opening it, so that the compaction and the queries of an idle table do not open it again.
+func OpenLazy(dir string, name string, monitor monitor.Monitor, options config.Badger, idle time.Duration) *Lazy {
+	l := &Lazy{
+		dir:     path.Join(dir, name),
+		name:    name,
+		options: options,
+		idle:    idle,
+		empty:   isEmptyDir(path.Join(dir, name)),
+		monitor: monitor,
+	}
+
+	interval := idle / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	l.unload = async.Repeat(context.Background(), interval, l.unloadIdle)
+	return l
+}
+
+// SetBatch groups the appends made concurrently into a single transaction, once the storage is opened. See
+// the batching of the disk storage.
+func (l *Lazy) SetBatch(size int64, delay time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.batch = func(s *Storage) {
+		s.SetBatch(size, delay)
+	}
+
+	if l.store != nil {
+		l.batch(l.store)
+	}
+}
+
+// Append adds an event into the storage, opening it if needed.
+func (l *Lazy) Append(key key.Key, value []byte, ttl time.Duration) error {
+	atomic.StoreInt64(&l.used, time.Now().UnixNano())
+	store, release, err := l.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	return store.Append(key, value, ttl)
+}
+
+// Range performs a range query against the storage, opening it unless it was closed without data.
+func (l *Lazy) Range(seek, until key.Key, f func(key, value []byte) bool) error {
+	if l.isEmpty() {
+		return nil
+	}
+
+	store, release, err := l.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	return store.Range(seek, until, f)
+}
+
+// Delete deletes one or multiple keys from the storage, opening it unless it was closed without data.
+func (l *Lazy) Delete(keys ...key.Key) error {
+	if l.isEmpty() {
+		return nil
+	}
+
+	store, release, err := l.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	return store.Delete(keys...)
+}
+
+// Truncate deletes every key of the storage, opening it unless it was closed without data.
+func (l *Lazy) Truncate() error {
+	if l.isEmpty() {
+		return nil
+	}
+
+	store, release, err := l.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	return store.Truncate()
+}
+
+// GC runs the garbage collection of the storage right away, opening it unless it was closed without data.
+func (l *Lazy) GC(ctx context.Context) (interface{}, error) {
+	if l.isEmpty() {
+		return nil, nil
+	}
+
+	store, release, err := l.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+	return store.GC(ctx)
+}
+
+// Epoch returns the epoch of the storage, without opening it.
+func (l *Lazy) Epoch() (string, error) {
+	if err := os.MkdirAll(l.dir, 0777); err != nil {
+		return "", err
+	}
+	return epochOf(l.dir)
+}
+
+// Size returns the size of the data on disk, in bytes, as of when the storage was closed if it is.
+func (l *Lazy) Size() int64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.store == nil {
+		return l.size
+	}
+	return l.store.Size()
+}
+
+// Check checks whether the storage is writable, if it is open.
+func (l *Lazy) Check(ctx context.Context) error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.store == nil {
+		return nil
+	}
+	return l.store.Check(ctx)
+}
+
+// Close closes the storage, which is not opened again.
+func (l *Lazy) Close() error {
+	l.unload.Cancel()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	return l.close()
+}
+
+// Drop closes the storage and deletes its directory.
+func (l *Lazy) Drop() error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(l.dir)
+}
+
+// acquire returns the storage, opening it if it is closed, along with the function to release it once used
+func (l *Lazy) acquire() (*Storage, func(), error) {
+	l.lock.RLock()
+	if l.store != nil {
+		return l.store, l.lock.RUnlock, nil
+	}
+
+	// Open the storage, unless another caller opened it meanwhile
+	l.lock.RUnlock()
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil, nil, errors.New(errClosed)
+	}
+
+	if l.store == nil {
+		if err := l.open(); err != nil {
+			l.lock.Unlock()
+			return nil, nil, err
+		}
+	}
+
+	l.lock.Unlock()
+	return l.acquire()
+}
+
+// open opens the storage, the lock being held
+func (l *Lazy) open() error {
+	store := New(l.monitor)
+	if err := store.Open(l.dir, l.options); err != nil {
+		return err
+	}
+
+	if l.batch != nil {
+		l.batch(store)
+	}
+
+	l.store = store
+	atomic.StoreInt64(&l.used, time.Now().UnixNano())
+	l.monitor.Count1(ctxTag, "lazy.open", "table:"+l.name)
+	l.monitor.Gauge(ctxTag, "open", float64(atomic.AddInt64(&opened, 1)))
+	return nil
+}
+
+// close closes the storage if it is open, recording whether it has any data, the lock being held
+func (l *Lazy) close() error {
+	if l.store == nil {
+		return nil
+	}
+
+	empty := true
+	_ = l.store.Range(key.First(), key.Last(), func(_, _ []byte) bool {
+		empty = false
+		return true
+	})
+
+	size := l.store.Size()
+	if err := l.store.Close(); err != nil {
+		return err
+	}
+
+	l.store, l.empty, l.size = nil, empty, size
+	l.monitor.Count1(ctxTag, "lazy.close", "table:"+l.name)
+	l.monitor.Gauge(ctxTag, "open", float64(atomic.AddInt64(&opened, -1)))
+	return nil
+}
+
+// unloadIdle closes the storage if it was not appended to for the idle duration
+func (l *Lazy) unloadIdle(ctx context.Context) (interface{}, error) {
+	if !l.isIdle() {
+		return nil, nil
+	}
+
+	// Check again once locked, as the storage may have been opened while waiting for the lock
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.isIdle() {
+		return nil, nil
+	}
+
+	if err := l.close(); err != nil {
+		l.monitor.Error(err)
+	}
+	return nil, nil
+}
+
+// isIdle returns whether the storage was not used for the idle duration
+func (l *Lazy) isIdle() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&l.used))) >= l.idle
+}
+
+// isEmpty returns whether the storage is closed and had no data
+func (l *Lazy) isEmpty() bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.store == nil && l.empty
+}
+
+// isEmptyDir returns whether a directory does not exist or has no files other than the epoch
+func isEmptyDir(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return true
+	}
+
+	for _, f := range files {
+		if f.Name() != epochFile {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "test")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	store := OpenLazy(dir, "events", monitor.NewNoop(), config.Badger{}, 100*time.Millisecond)
+	isOpen := func() bool {
+		store.lock.RLock()
+		defer store.lock.RUnlock()
+		return store.store != nil
+	}
+
+	count := func() (n int) {
+		assert.NoError(t, store.Range(key.First(), key.Last(), func(_, _ []byte) bool {
+			n++
+			return false
+		}))
+		return
+	}
+
+	// A new storage is not opened to be read
+	epoch, err := store.Epoch()
+	assert.NoError(t, err)
+	assert.Len(t, epoch, 8)
+	assert.Equal(t, 0, count())
+	assert.False(t, isOpen())
+
+	// It is opened once appended to, then closed once idle
+	k := key.New("A", time.Unix(0, 0))
+	assert.NoError(t, store.Append(k, []byte("A"), 60*time.Second))
+	assert.True(t, isOpen())
+	assert.Eventually(t, func() bool { return !isOpen() }, 2*time.Second, 10*time.Millisecond)
+
+	// Its data is read by opening it again
+	assert.Equal(t, 1, count())
+	assert.True(t, isOpen())
+	assert.NoError(t, store.Delete(k))
+	assert.Eventually(t, func() bool { return !isOpen() }, 2*time.Second, 10*time.Millisecond)
+
+	// Once closed without data, it is not opened to be read
+	assert.Equal(t, 0, count())
+	assert.NoError(t, store.Delete(k))
+	assert.False(t, isOpen())
+
+	// The epoch is kept and the storage can not be used once closed
+	again, err := store.Epoch()
+	assert.NoError(t, err)
+	assert.Equal(t, epoch, again)
+	assert.NoError(t, store.Close())
+	assert.Error(t, store.Append(k, []byte("A"), 60*time.Second))
+}
+
+func TestLazy_Truncate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "test")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	store := OpenLazy(dir, "events", monitor.NewNoop(), config.Badger{}, 100*time.Millisecond)
+	defer store.Close()
+
+	// An idle storage with data is opened to be truncated
+	assert.NoError(t, store.Append(key.New("A", time.Unix(0, 0)), []byte("A"), 60*time.Second))
+	assert.Eventually(t, func() bool {
+		store.lock.RLock()
+		defer store.lock.RUnlock()
+		return store.store == nil && !store.empty
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, store.Truncate())
+
+	count := 0
+	assert.NoError(t, store.Range(key.First(), key.Last(), func(_, _ []byte) bool {
+		count++
+		return false
+	}))
+	assert.Equal(t, 0, count)
+}
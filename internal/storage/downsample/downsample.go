@@ -0,0 +1,323 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package downsample
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// CountColumn is the column of the number of rows aggregated by each row of a tier
+const CountColumn = "count"
+
+// The aggregations of the columns
+const (
+	aggSum   = "sum"   // The sum of the values
+	aggMin   = "min"   // The smallest value
+	aggMax   = "max"   // The largest value
+	aggFirst = "first" // The first value compacted
+	aggLast  = "last"  // The last value compacted
+)
+
+// Appender represents a function which appends the aggregated blocks to the table of a tier
+type Appender func(table string, blocks []block.Block) error
+
+// Writer aggregates the rows of a table into the time buckets of a tier, as they are compacted, and appends
+// the aggregates to the table of the tier. It is a block writer, so that it sees the same blocks as the sink.
+type Writer struct {
+	table    string            // The table of the tier
+	interval time.Duration     // The width of the time buckets
+	hashBy   string            // The key column of the table
+	sortBy   string            // The time column of the table
+	columns  map[string]string // The aggregation of each column
+	append   Appender          // The function appending the aggregates to the table of the tier
+}
+
+// New creates a new writer for a tier of a table keyed by the hashBy column and timed by the sortBy column
+func New(conf config.Tier, hashBy, sortBy string, append Appender) *Writer {
+	columns := make(map[string]string, len(conf.Columns))
+	for name, agg := range conf.Columns {
+		columns[name] = strings.ToLower(agg)
+	}
+
+	return &Writer{
+		table:    conf.Table,
+		interval: time.Duration(conf.Interval) * time.Second,
+		hashBy:   hashBy,
+		sortBy:   sortBy,
+		columns:  columns,
+		append:   append,
+	}
+}
+
+// WriteBlock aggregates the rows of the blocks by key and time bucket, and appends a block per key to the tier
+func (w *Writer) WriteBlock(blocks []block.Block, schema typeof.Schema) error {
+	groups := make(map[string]*group, 1)
+	var order []string
+	for _, b := range blocks {
+		key := string(b.Key)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{index: make(map[int64]*bucket, 4)}
+			groups[key] = g
+			order = append(order, key)
+		}
+
+		if err := w.aggregate(g, b, schema); err != nil {
+			return err
+		}
+	}
+
+	tier := make([]block.Block, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		if len(g.buckets) == 0 {
+			continue
+		}
+
+		columns := make(column.Columns, 3+len(w.columns))
+		for _, bucket := range g.buckets {
+			w.appendTo(columns, bucket, schema)
+		}
+
+		b, err := block.FromColumns(key, columns)
+		if err != nil {
+			return errors.Internal("downsample: unable to encode a block", err)
+		}
+		tier = append(tier, b)
+	}
+
+	if len(tier) == 0 {
+		return nil
+	}
+	return w.append(w.table, tier)
+}
+
+// group represents the time buckets of a key, in the order of their first row
+type group struct {
+	buckets []*bucket         // The buckets, in order
+	index   map[int64]*bucket // The buckets, by identifier
+}
+
+// bucket represents the aggregates of the rows of a key within a time bucket
+type bucket struct {
+	key    interface{}            // The value of the key column
+	start  interface{}            // The start of the bucket, in the type of the time column
+	count  int64                  // The number of rows aggregated
+	values map[string]interface{} // The aggregate of each column
+}
+
+// aggregate aggregates the rows of a block into the time buckets of its key
+func (w *Writer) aggregate(g *group, b block.Block, schema typeof.Schema) error {
+	selected := typeof.Schema{}
+	blockSchema := b.Schema()
+	for _, name := range append(w.columnNames(), w.hashBy, w.sortBy) {
+		if typ, ok := blockSchema[name]; ok {
+			selected[name] = typ
+		}
+	}
+
+	if _, ok := selected[w.sortBy]; !ok {
+		return nil // The rows have no time, they can not be bucketed
+	}
+
+	columns, err := b.Select(selected)
+	if err != nil {
+		return errors.Internal("downsample: unable to read a block", err)
+	}
+
+	times := columns[w.sortBy]
+	for i := 0; i < times.Count(); i++ {
+		start, id, ok := w.bucketOf(times.At(i))
+		if !ok {
+			continue
+		}
+
+		current, ok := g.index[id]
+		if !ok {
+			current = &bucket{start: start, values: make(map[string]interface{}, len(w.columns))}
+			if keys, ok := columns[w.hashBy]; ok {
+				current.key = keys.At(i)
+			}
+			g.index[id] = current
+			g.buckets = append(g.buckets, current)
+		}
+
+		current.count++
+		for name, agg := range w.columns {
+			if values, ok := columns[name]; ok {
+				current.values[name] = accumulate(agg, current.values[name], values.At(i), schema[name])
+			}
+		}
+	}
+	return nil
+}
+
+// appendTo appends a row with the aggregates of a bucket to the columns of a key
+func (w *Writer) appendTo(columns column.Columns, b *bucket, schema typeof.Schema) {
+	if b.key != nil {
+		columns.Append(w.hashBy, b.key, schema[w.hashBy])
+	}
+
+	columns.Append(w.sortBy, b.start, schema[w.sortBy])
+	columns.Append(CountColumn, b.count, typeof.Int64)
+	for name, agg := range w.columns {
+		if v, ok := b.values[name]; ok && v != nil {
+			columns.Append(name, v, outputType(agg, schema[name]))
+		}
+	}
+	columns.FillNulls()
+}
+
+// columnNames returns the names of the aggregated columns
+func (w *Writer) columnNames() []string {
+	names := make([]string, 0, len(w.columns))
+	for name := range w.columns {
+		names = append(names, name)
+	}
+	return names
+}
+
+// bucketOf returns the start of the bucket of a time, in the type and unit of the time, along with an identifier
+// of the bucket. The unit of an integer time is guessed from its value, the same way as the time constraints.
+func (w *Writer) bucketOf(v interface{}) (start interface{}, id int64, ok bool) {
+	switch t := v.(type) {
+	case time.Time:
+		start := t.Truncate(w.interval)
+		return start, start.UnixNano(), true
+	case int64:
+		start := t - mod(t, w.widthOf(t))
+		return start, start, true
+	case int32:
+		start := int64(t) - mod(int64(t), w.widthOf(int64(t)))
+		return int32(start), start, true
+	default:
+		return nil, 0, false
+	}
+}
+
+// widthOf returns the width of the buckets in the unit of the time
+func (w *Writer) widthOf(t int64) int64 {
+	watermark := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	unit := time.Second
+	switch {
+	case t > watermark:
+		unit = time.Nanosecond
+	case t > watermark/1000:
+		unit = time.Microsecond
+	case t > watermark/1000000:
+		unit = time.Millisecond
+	}
+
+	if width := int64(w.interval / unit); width > 0 {
+		return width
+	}
+	return 1
+}
+
+// mod returns the positive remainder of a division, so that the times before the epoch are bucketed as well
+func mod(a, b int64) int64 {
+	r := a % b
+	if r < 0 {
+		r += b
+	}
+	return r
+}
+
+// outputType returns the type of the aggregate of a column
+func outputType(agg string, typ typeof.Type) typeof.Type {
+	switch agg {
+	case aggSum, aggMin, aggMax:
+		if typ == typeof.Float64 {
+			return typeof.Float64
+		}
+		return typeof.Int64
+	default:
+		return typ
+	}
+}
+
+// accumulate adds a value to the aggregate of a column, skipping the missing values
+func accumulate(agg string, current, v interface{}, typ typeof.Type) interface{} {
+	if v == nil {
+		return current
+	}
+
+	switch agg {
+	case aggFirst:
+		if current == nil {
+			return v
+		}
+		return current
+	case aggLast:
+		return v
+	}
+
+	if typ == typeof.Float64 {
+		f, ok := v.(float64)
+		if !ok {
+			return current
+		}
+		if current == nil {
+			return f
+		}
+		return combineFloat(agg, current.(float64), f)
+	}
+
+	n, ok := toInt64(v)
+	if !ok {
+		return current
+	}
+	if current == nil {
+		return n
+	}
+	return combineInt(agg, current.(int64), n)
+}
+
+// combineFloat combines two floating-point values
+func combineFloat(agg string, a, b float64) float64 {
+	switch {
+	case agg == aggSum:
+		return a + b
+	case agg == aggMin && b < a, agg == aggMax && b > a:
+		return b
+	default:
+		return a
+	}
+}
+
+// combineInt combines two integer values
+func combineInt(agg string, a, b int64) int64 {
+	switch {
+	case agg == aggSum:
+		return a + b
+	case agg == aggMin && b < a, agg == aggMax && b > a:
+		return b
+	default:
+		return a
+	}
+}
+
+// toInt64 converts an integer or a boolean to an int64
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package downsample
+
+import (
+	"testing"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlock(t *testing.T, event string, rows ...[]interface{}) block.Block {
+	columns := make(column.Columns, 4)
+	for _, row := range rows {
+		columns.Append("event", event, typeof.String)
+		columns.Append("tsi", row[0], typeof.Int64)
+		columns.Append("latency", row[1], typeof.Float64)
+		columns.Append("status", row[2], typeof.String)
+		columns.FillNulls()
+	}
+
+	b, err := block.FromColumns(event, columns)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestWriteBlock(t *testing.T) {
+	var table string
+	var output []block.Block
+	w := New(config.Tier{
+		Table:    "eventlog_1m",
+		Interval: 60,
+		Columns: map[string]string{
+			"latency": "SUM",
+			"status":  "last",
+			"missing": "max",
+		},
+	}, "event", "tsi", func(name string, blocks []block.Block) error {
+		table, output = name, blocks
+		return nil
+	})
+
+	schema := typeof.Schema{"event": typeof.String, "tsi": typeof.Int64, "latency": typeof.Float64, "status": typeof.String}
+	assert.NoError(t, w.WriteBlock([]block.Block{
+		newBlock(t, "click", []interface{}{int64(1600000020), 1.5, "ok"}, []interface{}{int64(1600000079), nil, "failed"}),
+		newBlock(t, "view", []interface{}{int64(1600000020123), 2.0, "ok"}),
+		newBlock(t, "click", []interface{}{int64(1600000080), 0.5, "ok"}),
+	}, schema))
+
+	assert.Equal(t, "eventlog_1m", table)
+	assert.Len(t, output, 2)
+
+	// The rows of a key are aggregated by minute, even across the blocks
+	click, err := output[0].Select(output[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, "click", string(output[0].Key))
+	assert.Equal(t, 2, click["tsi"].Count())
+	assert.Equal(t, "click", click["event"].At(0))
+	assert.Equal(t, int64(1600000020), click["tsi"].At(0))
+	assert.Equal(t, int64(2), click[CountColumn].At(0))
+	assert.Equal(t, 1.5, click["latency"].At(0))
+	assert.Equal(t, "failed", click["status"].At(0))
+	assert.Equal(t, int64(1600000080), click["tsi"].At(1))
+	assert.Equal(t, int64(1), click[CountColumn].At(1))
+	assert.NotContains(t, click, "missing")
+
+	// The unit of the time is kept
+	view, err := output[1].Select(output[1].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1600000020000), view["tsi"].At(0))
+	assert.Equal(t, 2.0, view["latency"].At(0))
+}
+
+func TestAccumulate(t *testing.T) {
+	tests := []struct {
+		agg    string
+		typ    typeof.Type
+		values []interface{}
+		expect interface{}
+	}{
+		{agg: aggSum, typ: typeof.Int32, values: []interface{}{int32(1), nil, int32(2)}, expect: int64(3)},
+		{agg: aggMin, typ: typeof.Int64, values: []interface{}{int64(5), int64(2), int64(4)}, expect: int64(2)},
+		{agg: aggMax, typ: typeof.Float64, values: []interface{}{1.5, 3.5, 2.5}, expect: 3.5},
+		{agg: aggFirst, typ: typeof.String, values: []interface{}{nil, "a", "b"}, expect: "a"},
+		{agg: aggLast, typ: typeof.String, values: []interface{}{"a", "b", nil}, expect: "b"},
+		{agg: aggSum, typ: typeof.Bool, values: []interface{}{true, false, true}, expect: int64(2)},
+		{agg: aggSum, typ: typeof.String, values: []interface{}{"a", "b"}, expect: nil},
+	}
+
+	for _, tc := range tests {
+		var current interface{}
+		for _, v := range tc.values {
+			current = accumulate(tc.agg, current, v, tc.typ)
+		}
+		assert.Equal(t, tc.expect, current, tc.agg)
+	}
+}
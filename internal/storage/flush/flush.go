@@ -4,6 +4,12 @@
 package flush
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"path"
+	"time"
+
 	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/encoding/key"
 	"github.com/kelindar/talaria/internal/encoding/merge"
@@ -24,11 +30,22 @@ type Flusher struct {
 	merge        merge.Func      // The function used to merge blocks
 	fileNameFunc func(map[string]interface{}) (string, error)
 	streamer     storage.Streamer // The underlying row writer
+	manifest     *manifest        // The manifest of the files written (optional)
+	sketches     *sketches        // The distinct-count sketches of the rows written (optional)
+	naming       *naming          // The deterministic naming of the files (optional)
+	table        string           // The name of the table (optional)
+}
+
+// naming represents the deterministic naming of the files, after the buffered data they are written from
+type naming struct {
+	epoch     string        // The epoch of the buffer of the node
+	interval  time.Duration // The duration of a compaction window
+	partition bool          // Whether the files are partitioned by the day of their window, instead of the name function
 }
 
-// ForCompaction creates a new storage implementation.
-func ForCompaction(monitor monitor.Monitor, writer Writer, encoder string, fileNameFunc func(map[string]interface{}) (string, error)) (*Flusher, error) {
-	mergeFn, err := merge.New(encoder)
+// ForCompaction creates a new storage implementation, merging the blocks with the encoder and compression.
+func ForCompaction(monitor monitor.Monitor, writer Writer, encoder, compression string, fileNameFunc func(map[string]interface{}) (string, error)) (*Flusher, error) {
+	mergeFn, err := merge.NewWith(encoder, compression)
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +60,30 @@ func ForCompaction(monitor monitor.Monitor, writer Writer, encoder string, fileN
 
 // TODO: ForStreaming
 
+// SetName sets the name of the table, which prefixes the deterministic file names.
+func (s *Flusher) SetName(table string) {
+	s.table = table
+}
+
+// SetDeterministic names the files after the buffered data they are written from, with the epoch of the buffer
+// of the node, the compaction window of the first buffered key and the key itself. A compaction which is retried
+// after a crash, with the blocks still buffered, then overwrites the same files instead of duplicating them. The
+// directory of the files is given by the name function or, if partition is set, by the day of their window.
+func (s *Flusher) SetDeterministic(epoch string, interval time.Duration, partition bool) {
+	s.naming = &naming{
+		epoch:     epoch,
+		interval:  interval,
+		partition: partition,
+	}
+}
+
 // WriteBlock writes a one or multiple blocks to the underlying writer.
 func (s *Flusher) WriteBlock(blocks []block.Block, schema typeof.Schema) error {
+	return s.WriteBlockAt(nil, blocks, schema)
+}
+
+// WriteBlockAt writes a one or multiple blocks, buffered from the key onwards, to the underlying writer.
+func (s *Flusher) WriteBlockAt(first key.Key, blocks []block.Block, schema typeof.Schema) error {
 	if s.writer == nil || len(blocks) == 0 {
 		return nil
 	}
@@ -56,7 +95,25 @@ func (s *Flusher) WriteBlock(blocks []block.Block, schema typeof.Schema) error {
 	}
 
 	// Generate the file name and write the data to the underlying writer
-	return s.writer.Write(s.generateFileName(blocks[0]), buffer)
+	name := s.generateFileName(blocks[0])
+	if s.naming != nil && len(first) == 16 {
+		name = s.naming.nameOf(s.table, first, name)
+	}
+
+	if err := s.writer.Write(name, buffer); err != nil {
+		return err
+	}
+
+	// Record the file for the manifest of the compaction window
+	if s.manifest != nil {
+		s.manifest.record(name, blocks, buffer)
+	}
+
+	// Count the distinct values of the rows written, for the sketches of the compaction window
+	if s.sketches != nil {
+		s.sketches.record(blocks)
+	}
+	return nil
 }
 
 // WriteRow writes a single row to the underlying writer (i.e. streamer).
@@ -83,6 +140,35 @@ func (s *Flusher) generateFileName(b block.Block) []byte {
 	return []byte(output)
 }
 
+// nameOf returns the deterministic name of a file buffered from the key onwards, keeping the directory and the
+// extension of the name generated by the name function
+func (n *naming) nameOf(table string, first key.Key, generated []byte) []byte {
+	window := time.Unix(int64(binary.BigEndian.Uint64(first[4:12])), 0).UTC()
+	if n.interval > 0 {
+		window = window.Truncate(n.interval)
+	}
+
+	dir := path.Dir(string(generated))
+	if n.partition {
+		dir = window.Format("year=2006/month=1/day=2")
+	}
+
+	name := n.epoch + "-" + window.Format("20060102T150405Z") + "-" + hex.EncodeToString(first)
+	if table != "" {
+		name = table + "-" + name
+	}
+
+	return []byte(path.Join(dir, name+path.Ext(string(generated))))
+}
+
+// CheckSink checks the health of the underlying writer, if it supports it.
+func (s *Flusher) CheckSink(ctx context.Context) error {
+	if checker, ok := s.writer.(interface{ CheckSink(context.Context) error }); ok {
+		return checker.CheckSink(ctx)
+	}
+	return nil
+}
+
 // Close is used to gracefully close storage.
 func (s *Flusher) Close() error {
 	return nil
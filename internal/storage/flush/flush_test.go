@@ -5,12 +5,19 @@ package flush
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	eorc "github.com/crphang/orc"
 	"github.com/kelindar/talaria/internal/column"
 	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
 	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/sketch"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/kelindar/talaria/internal/monitor"
 	script "github.com/kelindar/talaria/internal/scripting"
@@ -52,7 +59,7 @@ func TestNameFunc(t *testing.T) {
 		return output.(string), err
 	}
 
-	flusher, _ := ForCompaction(monitor.NewNoop(), noop.New(), "orc", fileNameFunc)
+	flusher, _ := ForCompaction(monitor.NewNoop(), noop.New(), "orc", "", fileNameFunc)
 	schema := typeof.Schema{
 		"col0": typeof.String,
 		"col1": typeof.Timestamp,
@@ -78,3 +85,162 @@ func TestNameFunc(t *testing.T) {
 	assert.Equal(t, "year=46970/month=3/day=29/ns=eventName/0-0-0-127.0.0.1.orc", string(fileName))
 
 }
+
+// memory represents a writer keeping the files in memory
+type memory map[string][]byte
+
+func (m memory) Write(key key.Key, val []byte) error {
+	m[string(key)] = val
+	return nil
+}
+
+func TestManifest(t *testing.T) {
+	dest := memory{}
+	flusher, _ := ForCompaction(monitor.NewNoop(), dest, "orc", "", func(map[string]interface{}) (string, error) {
+		return "dt=2020-01-01/a.orc", nil
+	})
+	flusher.SetManifest("col1", true)
+
+	// Without any file written, nothing is committed
+	assert.NoError(t, flusher.Commit())
+	assert.Len(t, dest, 0)
+
+	schema := typeof.Schema{
+		"col0": typeof.String,
+		"col1": typeof.Timestamp,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	orcBuffer := &bytes.Buffer{}
+	writer, _ := eorc.NewWriter(orcBuffer, eorc.SetSchema(orcSchema))
+	_ = writer.Write("a", time.Unix(1577836800, 0))
+	_ = writer.Write("a", time.Unix(1577840400, 0))
+	_ = writer.Write("a", time.Unix(1577833200, 0))
+	_ = writer.Close()
+
+	blocks, err := block.FromOrcBy(orcBuffer.Bytes(), "col0", nil, block.Transform(nil))
+	assert.NoError(t, err)
+	assert.NoError(t, flusher.WriteBlock(blocks, schema))
+	assert.NoError(t, flusher.Commit())
+
+	// The manifest and the marker are written next to the file
+	assert.Len(t, dest, 3)
+	assert.Contains(t, dest, "_SUCCESS")
+
+	var manifest Manifest
+	for name, b := range dest {
+		if strings.HasPrefix(name, "_manifests/") {
+			assert.NoError(t, json.Unmarshal(b, &manifest))
+		}
+	}
+
+	checksum := sha256.Sum256(dest["dt=2020-01-01/a.orc"])
+	assert.Equal(t, int64(3), manifest.Rows)
+	assert.Len(t, manifest.Files, 1)
+	assert.Equal(t, "dt=2020-01-01/a.orc", manifest.Files[0].Name)
+	assert.Equal(t, int64(3), manifest.Files[0].Rows)
+	assert.Equal(t, len(dest["dt=2020-01-01/a.orc"]), manifest.Files[0].Size)
+	assert.Equal(t, hex.EncodeToString(checksum[:]), manifest.Files[0].Checksum)
+	assert.Equal(t, int64(1577833200), manifest.Files[0].MinTime.Unix())
+	assert.Equal(t, int64(1577840400), manifest.Files[0].MaxTime.Unix())
+
+	// The files are only listed in a single manifest
+	assert.NoError(t, flusher.Commit())
+	assert.Len(t, dest, 3)
+}
+
+func TestSketches(t *testing.T) {
+	dest := memory{}
+	flusher, _ := ForCompaction(monitor.NewNoop(), dest, "orc", "", func(map[string]interface{}) (string, error) {
+		return "dt=2020-01-01/a.orc", nil
+	})
+	assert.Error(t, flusher.SetSketches([]string{"col0"}, "col1", time.Hour, 20))
+	assert.NoError(t, flusher.SetSketches([]string{"col0"}, "col1", time.Hour, sketch.DefaultPrecision))
+
+	schema := typeof.Schema{
+		"col0": typeof.String,
+		"col1": typeof.Timestamp,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	orcBuffer := &bytes.Buffer{}
+	writer, _ := eorc.NewWriter(orcBuffer, eorc.SetSchema(orcSchema))
+	_ = writer.Write("a", time.Unix(1577836800, 0))
+	_ = writer.Write("a", time.Unix(1577836900, 0))
+	_ = writer.Write("b", time.Unix(1577837000, 0))
+	_ = writer.Write("a", time.Unix(1577840400, 0))
+	_ = writer.Close()
+
+	blocks, err := block.FromOrcBy(orcBuffer.Bytes(), "col0", nil, block.Transform(nil))
+	assert.NoError(t, err)
+	assert.NoError(t, flusher.WriteBlock(blocks, schema))
+	assert.NoError(t, flusher.Commit())
+
+	// The sketches are written next to the file, one per window
+	assert.Len(t, dest, 2)
+	var sketches Sketches
+	for name, b := range dest {
+		if strings.HasPrefix(name, "_sketches/") {
+			assert.NoError(t, json.Unmarshal(b, &sketches))
+		}
+	}
+
+	assert.Len(t, sketches.Sketches, 2)
+	assert.Equal(t, "col0", sketches.Sketches[0].Column)
+	assert.Equal(t, int64(1577836800), sketches.Sketches[0].From.Unix())
+	assert.Equal(t, int64(1577840400), sketches.Sketches[0].Until.Unix())
+	assert.Equal(t, uint64(2), sketches.Sketches[0].Estimate)
+	assert.Equal(t, uint64(1), sketches.Sketches[1].Estimate)
+
+	// The sketches of the windows can be merged
+	var merged, other sketch.HLL
+	assert.NoError(t, merged.UnmarshalBinary(sketches.Sketches[0].Sketch))
+	assert.NoError(t, other.UnmarshalBinary(sketches.Sketches[1].Sketch))
+	assert.NoError(t, merged.Merge(&other))
+	assert.Equal(t, uint64(2), merged.Estimate())
+
+	// The rows are only counted in a single commit
+	assert.NoError(t, flusher.Commit())
+	assert.Len(t, dest, 2)
+}
+
+func TestDeterministic(t *testing.T) {
+	dest := memory{}
+	flusher, _ := ForCompaction(monitor.NewNoop(), dest, "orc", "", func(map[string]interface{}) (string, error) {
+		return "dt=2020-01-01/a.orc", nil
+	})
+	flusher.SetDeterministic("3f2a9c1e", time.Minute, false)
+	flusher.SetName("eventlog")
+
+	schema := typeof.Schema{"col0": typeof.String}
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	orcBuffer := &bytes.Buffer{}
+	writer, _ := eorc.NewWriter(orcBuffer, eorc.SetSchema(orcSchema))
+	_ = writer.Write("a")
+	_ = writer.Close()
+
+	blocks, err := block.FromOrcBy(orcBuffer.Bytes(), "col0", nil, block.Transform(nil))
+	assert.NoError(t, err)
+
+	// A write retried from the same buffered key overwrites the same file
+	first := key.Key{0, 0, 0, 1, 0, 0, 0, 0, 0x5e, 0x0b, 0xe1, 0x1e, 0, 0, 0, 7}
+	assert.NoError(t, flusher.WriteBlockAt(first, blocks, schema))
+	assert.NoError(t, flusher.WriteBlockAt(first, blocks, schema))
+	assert.Len(t, dest, 1)
+	assert.Contains(t, dest, "dt=2020-01-01/eventlog-3f2a9c1e-20200101T000000Z-00000001000000005e0be11e00000007.orc")
+
+	// Without the name function, the files are partitioned by the day of their window
+	flusher.SetDeterministic("3f2a9c1e", time.Minute, true)
+	assert.NoError(t, flusher.WriteBlockAt(first, blocks, schema))
+	assert.Contains(t, dest, "year=2020/month=1/day=1/eventlog-3f2a9c1e-20200101T000000Z-00000001000000005e0be11e00000007.orc")
+
+	// Without the buffered key, the name function is used
+	assert.NoError(t, flusher.WriteBlock(blocks, schema))
+	assert.Contains(t, dest, "dt=2020-01-01/a.orc")
+}
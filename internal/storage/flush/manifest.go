@@ -0,0 +1,167 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package flush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	manifestDir   = "_manifests" // The directory of the manifests, relative to the destination
+	successMarker = "_SUCCESS"   // The marker written once the manifest is
+)
+
+// Manifest represents the list of the files written by a compaction window
+type Manifest struct {
+	Time  time.Time `json:"time"`  // The time at which the window was completed
+	Rows  int64     `json:"rows"`  // The total number of rows
+	Files []File    `json:"files"` // The files written
+}
+
+// File represents a file written to the destination
+type File struct {
+	Name     string     `json:"name"`              // The name of the file, relative to the destination
+	Rows     int64      `json:"rows"`              // The number of rows of the file
+	Size     int        `json:"size"`              // The size of the file, in bytes
+	Checksum string     `json:"checksum"`          // The SHA-256 of the file, encoded in hexadecimal
+	MinTime  *time.Time `json:"minTime,omitempty"` // The earliest event time of the file, if the column is known
+	MaxTime  *time.Time `json:"maxTime,omitempty"` // The latest event time of the file, if the column is known
+}
+
+// manifest represents the manifest being accumulated until the compaction window completes
+type manifest struct {
+	sync.Mutex
+	column  string // The column of the event time (optional)
+	success bool   // Whether the _SUCCESS marker is written
+	files   []File // The files written since the last commit
+}
+
+// SetManifest makes the flusher write a manifest of the files it wrote, along with their number of rows, event time
+// range and checksum, once the compaction window completes. The event time is read from the column, if specified,
+// which must be a timestamp or a unix time in seconds. If success is set, a _SUCCESS marker is written after it.
+func (s *Flusher) SetManifest(column string, success bool) {
+	s.manifest = &manifest{
+		column:  column,
+		success: success,
+	}
+}
+
+// record adds a file written to the manifest
+func (m *manifest) record(name key.Key, blocks []block.Block, buffer []byte) {
+	checksum := sha256.Sum256(buffer)
+	file := File{
+		Name:     string(name),
+		Size:     len(buffer),
+		Checksum: hex.EncodeToString(checksum[:]),
+	}
+
+	for _, b := range blocks {
+		cols, err := b.Select(b.Schema())
+		if err != nil {
+			continue
+		}
+
+		file.Rows += int64(cols.Max())
+		if col, ok := cols[m.column]; ok {
+			_ = col.Range(0, col.Count(), func(_ int, v interface{}) error {
+				if t, ok := timeOf(v); ok {
+					if file.MinTime == nil || t.Before(*file.MinTime) {
+						file.MinTime = &t
+					}
+					if file.MaxTime == nil || t.After(*file.MaxTime) {
+						file.MaxTime = &t
+					}
+				}
+				return nil
+			})
+		}
+	}
+
+	m.Lock()
+	m.files = append(m.files, file)
+	m.Unlock()
+}
+
+// timeOf converts the value of the event time column to a time
+func timeOf(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), true
+	case int64:
+		return time.Unix(v, 0).UTC(), true
+	case int32:
+		return time.Unix(int64(v), 0).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// Commit writes the sketches and the manifest of the files written since the last commit, followed by the _SUCCESS
+// marker, unless no file was written. If the manifest can not be written, its files are kept for the next commit.
+func (s *Flusher) Commit() error {
+	if s.writer == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if err := s.commitSketches(now); err != nil {
+		return err
+	}
+	return s.commitManifest(now)
+}
+
+// commitManifest writes the manifest of the files written since the last commit, if any
+func (s *Flusher) commitManifest(now time.Time) error {
+	if s.manifest == nil {
+		return nil
+	}
+
+	s.manifest.Lock()
+	files := s.manifest.files
+	s.manifest.files = nil
+	s.manifest.Unlock()
+	if len(files) == 0 {
+		return nil
+	}
+
+	m := Manifest{Time: now, Files: files}
+	for _, f := range files {
+		m.Rows += f.Rows
+	}
+
+	err := s.commit(m)
+	if err != nil {
+		s.manifest.Lock()
+		s.manifest.files = append(files, s.manifest.files...)
+		s.manifest.Unlock()
+	}
+	return err
+}
+
+// commit writes the manifest and the marker
+func (s *Flusher) commit(m Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Internal("flush: unable to encode the manifest", err)
+	}
+
+	name := manifestDir + "/" + m.Time.Format("20060102T150405.000000000Z") + ".json"
+	if err := s.writer.Write(key.Key(name), b); err != nil {
+		return errors.Internal("flush: unable to write the manifest", err)
+	}
+
+	if s.manifest.success {
+		if err := s.writer.Write(key.Key(successMarker), []byte{}); err != nil {
+			return errors.Internal("flush: unable to write the _SUCCESS marker", err)
+		}
+	}
+	return nil
+}
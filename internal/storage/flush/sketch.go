@@ -0,0 +1,200 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package flush
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/sketch"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const sketchDir = "_sketches" // The directory of the sketches, relative to the destination
+
+// Sketches represents the distinct-count sketches of the rows written by a compaction window
+type Sketches struct {
+	Time     time.Time `json:"time"`     // The time at which the window was completed
+	Sketches []Sketch  `json:"sketches"` // The sketches, by column and window of event time
+}
+
+// Sketch represents the distinct values of a column within a window of event time
+type Sketch struct {
+	Column   string    `json:"column"`   // The name of the column
+	From     time.Time `json:"from"`     // The start of the window of event time
+	Until    time.Time `json:"until"`    // The end of the window of event time, exclusive
+	Estimate uint64    `json:"estimate"` // The estimated number of distinct values
+	Sketch   []byte    `json:"sketch"`   // The HyperLogLog sketch, which can be merged with the other windows
+}
+
+// sketchKey represents a column within a window of event time
+type sketchKey struct {
+	column string
+	window int64
+}
+
+// sketches represents the sketches being accumulated until the compaction window completes
+type sketches struct {
+	sync.Mutex
+	columns   []string                  // The columns whose distinct values are counted
+	column    string                    // The column of the event time
+	window    time.Duration             // The width of the windows of event time
+	precision uint8                     // The precision of the sketches
+	pending   map[sketchKey]*sketch.HLL // The sketches of the rows written since the last commit
+}
+
+// SetSketches makes the flusher count the distinct values of the columns within each window of event time, read
+// from the column which must be a timestamp or a unix time in seconds, the windows lasting an hour by default. The
+// HyperLogLog sketches of the rows it wrote are written once the compaction window completes, so that approximate
+// distinct counts over long ranges can be computed by merging the sketches of their windows, without scanning
+// the files.
+func (s *Flusher) SetSketches(columns []string, column string, window time.Duration, precision uint8) error {
+	if _, err := sketch.New(precision); err != nil {
+		return err
+	}
+
+	if window <= 0 {
+		window = time.Hour
+	}
+
+	s.sketches = &sketches{
+		columns:   columns,
+		column:    column,
+		window:    window,
+		precision: precision,
+		pending:   make(map[sketchKey]*sketch.HLL),
+	}
+	return nil
+}
+
+// record adds the values of the blocks written to the sketches of their window
+func (k *sketches) record(blocks []block.Block) {
+	for _, b := range blocks {
+		schema := b.Schema()
+		selected := make(typeof.Schema, len(k.columns)+1)
+		for _, c := range k.columns {
+			if typ, ok := schema[c]; ok {
+				selected[c] = typ
+			}
+		}
+		if typ, ok := schema[k.column]; ok {
+			selected[k.column] = typ
+		}
+
+		cols, err := b.Select(selected)
+		if err != nil {
+			continue
+		}
+
+		// Find the window of each row, skipping the rows without an event time
+		times, ok := cols[k.column]
+		if !ok {
+			continue
+		}
+
+		windows := make([]int64, times.Count())
+		_ = times.Range(0, times.Count(), func(i int, v interface{}) error {
+			if t, ok := timeOf(v); ok {
+				windows[i] = t.Truncate(k.window).Unix()
+			} else {
+				windows[i] = -1
+			}
+			return nil
+		})
+
+		k.Lock()
+		for _, name := range k.columns {
+			col, ok := cols[name]
+			if !ok {
+				continue
+			}
+
+			_ = col.Range(0, col.Count(), func(i int, v interface{}) error {
+				if v == nil || i >= len(windows) || windows[i] < 0 {
+					return nil
+				}
+
+				id := sketchKey{column: name, window: windows[i]}
+				h, ok := k.pending[id]
+				if !ok {
+					h, _ = sketch.New(k.precision)
+					k.pending[id] = h
+				}
+				h.Add(v)
+				return nil
+			})
+		}
+		k.Unlock()
+	}
+}
+
+// take removes the sketches accumulated since the last commit
+func (k *sketches) take() map[sketchKey]*sketch.HLL {
+	k.Lock()
+	defer k.Unlock()
+	pending := k.pending
+	k.pending = make(map[sketchKey]*sketch.HLL)
+	return pending
+}
+
+// restore merges back the sketches which could not be written, so they are written by the next commit
+func (k *sketches) restore(pending map[sketchKey]*sketch.HLL) {
+	k.Lock()
+	defer k.Unlock()
+	for id, h := range pending {
+		if existing, ok := k.pending[id]; ok {
+			_ = h.Merge(existing)
+		}
+		k.pending[id] = h
+	}
+}
+
+// commitSketches writes the sketches of the rows written since the last commit, unless there are none. If they
+// can not be written, they are kept for the next commit.
+func (s *Flusher) commitSketches(now time.Time) error {
+	if s.sketches == nil {
+		return nil
+	}
+
+	pending := s.sketches.take()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	out := Sketches{Time: now, Sketches: make([]Sketch, 0, len(pending))}
+	for id, h := range pending {
+		encoded, _ := h.MarshalBinary()
+		from := time.Unix(id.window, 0).UTC()
+		out.Sketches = append(out.Sketches, Sketch{
+			Column:   id.column,
+			From:     from,
+			Until:    from.Add(s.sketches.window),
+			Estimate: h.Estimate(),
+			Sketch:   encoded,
+		})
+	}
+
+	sort.Slice(out.Sketches, func(i, j int) bool {
+		a, b := out.Sketches[i], out.Sketches[j]
+		return a.From.Before(b.From) || (a.From.Equal(b.From) && a.Column < b.Column)
+	})
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		s.sketches.restore(pending)
+		return errors.Internal("flush: unable to encode the sketches", err)
+	}
+
+	name := sketchDir + "/" + now.Format("20060102T150405.000000000Z") + ".json"
+	if err := s.writer.Write(key.Key(name), b); err != nil {
+		s.sketches.restore(pending)
+		return errors.Internal("flush: unable to write the sketches", err)
+	}
+	return nil
+}
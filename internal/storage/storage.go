@@ -41,6 +41,19 @@ type Streamer interface {
 	Stream(block.Row) error
 }
 
+// Dropper represents a contract that closes a storage and deletes all of its data.
+type Dropper interface {
+	Drop() error
+}
+
+// Drop attempts to drop a storage, or simply closes it if its data can not be deleted
+func Drop(obj interface{}) error {
+	if dropper, ok := obj.(Dropper); ok {
+		return dropper.Drop()
+	}
+	return Close(obj)
+}
+
 // Close attempts to close one or multiple storages
 func Close(objs ...interface{}) error {
 	var result error
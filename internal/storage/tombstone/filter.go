@@ -0,0 +1,148 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package tombstone
+
+import (
+	"fmt"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/timestamp"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Filter removes the rows matching the tombstones from the blocks of a table, whose rows are keyed by the hashBy
+// column and timed by the sortBy column. The unit of an integer time is guessed from its value.
+type Filter struct {
+	hashBy string            // The key column of the table
+	sortBy string            // The time column of the table
+	parser *timestamp.Parser // The parser of the times, in unix nanoseconds
+}
+
+// NewFilter creates a new filter for a table
+func NewFilter(hashBy, sortBy string) *Filter {
+	parser, _ := timestamp.New([]string{timestamp.Epoch}, "UTC", timestamp.UnixNano)
+	return &Filter{
+		hashBy: hashBy,
+		sortBy: sortBy,
+		parser: parser,
+	}
+}
+
+// Apply returns the blocks without the rows matching any of the tombstones, along with the number of rows
+// removed. The blocks without any matching row are returned as they are, and the ones without any row left
+// are left out.
+func (f *Filter) Apply(tombstones []Tombstone, blocks []block.Block) ([]block.Block, int, error) {
+	if len(tombstones) == 0 {
+		return blocks, 0, nil
+	}
+
+	var removed int
+	out := make([]block.Block, 0, len(blocks))
+	for _, b := range blocks {
+		filtered, n, err := f.apply(tombstones, b)
+		if err != nil {
+			return nil, removed, err
+		}
+
+		removed += n
+		if filtered.Columns != nil {
+			out = append(out, filtered)
+		}
+	}
+	return out, removed, nil
+}
+
+// apply removes the rows matching any of the tombstones from a block, returning an empty block if every row
+// was removed
+func (f *Filter) apply(tombstones []Tombstone, b block.Block) (block.Block, int, error) {
+	schema := b.Schema()
+	input, err := b.Select(schema)
+	if err != nil {
+		return block.Block{}, 0, errors.Internal("tombstone: unable to read a block", err)
+	}
+
+	count := input.Max()
+	keep := make([]bool, count)
+	var removed int
+	for i := 0; i < count; i++ {
+		if f.matches(tombstones, input, i) {
+			removed++
+			continue
+		}
+		keep[i] = true
+	}
+
+	switch removed {
+	case 0:
+		return b, 0, nil
+	case count:
+		return block.Block{}, removed, nil
+	}
+
+	output := column.MakeColumns(&schema)
+	for name, col := range input {
+		for i := 0; i < count; i++ {
+			if keep[i] {
+				output[name].Append(col.At(i))
+			}
+		}
+	}
+
+	filtered, err := block.FromColumns(string(b.Key), output)
+	if err != nil {
+		return block.Block{}, 0, errors.Internal("tombstone: unable to encode a block", err)
+	}
+
+	filtered.Expires = b.Expires
+	return filtered, removed, nil
+}
+
+// matches checks whether a row matches any of the tombstones
+func (f *Filter) matches(tombstones []Tombstone, columns column.Columns, row int) bool {
+	var key interface{}
+	if col, ok := columns[f.hashBy]; ok {
+		key = col.At(row)
+	}
+
+	var at interface{}
+	if col, ok := columns[f.sortBy]; ok {
+		at = col.At(row)
+	}
+
+	for _, t := range tombstones {
+		if t.Key != "" && (key == nil || toString(key) != t.Key) {
+			continue
+		}
+
+		if t.Timed() && !f.within(&t, at) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// within checks whether a time is within the time range of a tombstone
+func (f *Filter) within(t *Tombstone, at interface{}) bool {
+	if at == nil {
+		return false
+	}
+
+	v, ok := f.parser.Parse(at)
+	if !ok {
+		return false
+	}
+
+	ns := v.(int64)
+	return ns >= t.From && (t.Until == 0 || ns < t.Until)
+}
+
+// toString converts a value of the key column to a string
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package tombstone
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlock(t *testing.T, user string, times ...int64) block.Block {
+	columns := make(column.Columns, 2)
+	for _, at := range times {
+		columns.Append("user", user, typeof.String)
+		columns.Append("tsi", at, typeof.Int64)
+	}
+
+	b, err := block.FromColumns(user, columns)
+	assert.NoError(t, err)
+	b.Expires = 1234
+	return b
+}
+
+func TestFilter(t *testing.T) {
+	filter := NewFilter("user", "tsi")
+	hour := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	blocks := []block.Block{
+		newBlock(t, "user-1", hour.Unix(), hour.Unix()+60),
+		newBlock(t, "user-2", hour.Unix()-60, hour.Unix()*1000, hour.Add(time.Hour).Unix()),
+		newBlock(t, "user-3", hour.Unix()-60),
+	}
+
+	// Without any tombstone, the blocks are left as they are
+	out, removed, err := filter.Apply(nil, blocks)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	assert.Len(t, out, 3)
+
+	// The rows of a key, and the rows within a time range whichever their unit
+	out, removed, err = filter.Apply([]Tombstone{
+		{Key: "user-1"},
+		{From: hour.UnixNano(), Until: hour.Add(time.Hour).UnixNano()},
+	}, blocks)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Len(t, out, 2)
+
+	// A block with rows left is encoded again, and keeps its expiration
+	rows, err := out[0].Select(out[0].Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, "user-2", string(out[0].Key))
+	assert.Equal(t, 2, rows["tsi"].Count())
+	assert.Equal(t, hour.Unix()-60, rows["tsi"].At(0))
+	assert.Equal(t, hour.Add(time.Hour).Unix(), rows["tsi"].At(1))
+	assert.Equal(t, int64(1234), out[0].Expires)
+	assert.Equal(t, blocks[2].Data, out[1].Data)
+
+	// The rows of a key within a time range
+	out, removed, err = filter.Apply([]Tombstone{
+		{Key: "user-2", Until: hour.UnixNano()},
+	}, blocks)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Len(t, out, 3)
+}
@@ -0,0 +1,226 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package tombstone
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const fileName = "tombstones.json"
+
+// Tombstone represents the deletion of the rows of a table with a value of the key column, within a time range
+// of the time column, or both. It is applied to the rows already stored and to the rows compacted until it
+// expires, once every row stored when it was created expired as well.
+type Tombstone struct {
+	ID      string `json:"id"`
+	Table   string `json:"table"`
+	Key     string `json:"key,omitempty"`   // The value of the key column of the rows deleted, any value if empty
+	From    int64  `json:"from,omitempty"`  // The start of the time range (inclusive), in unix nanoseconds, unbounded if zero
+	Until   int64  `json:"until,omitempty"` // The end of the time range (exclusive), in unix nanoseconds, unbounded if zero
+	Created int64  `json:"created"`         // The time of the deletion, in unix nanoseconds
+	Expires int64  `json:"expires"`         // The time after which the tombstone is forgotten, in unix nanoseconds, never if zero
+}
+
+// Timed returns whether the tombstone deletes the rows within a time range
+func (t *Tombstone) Timed() bool {
+	return t.From != 0 || t.Until != 0
+}
+
+// Expired returns whether the tombstone expired at a time
+func (t *Tombstone) Expired(now time.Time) bool {
+	return t.Expires != 0 && t.Expires <= now.UnixNano()
+}
+
+// Log represents the tombstones of the tables, persisted in the storage directory of the node and replicated to
+// the other nodes, each tombstone being applied on every node.
+type Log struct {
+	lock      sync.Mutex
+	path      string               // The file in which the log is persisted
+	entries   map[string]Tombstone // The tombstones, by identifier
+	apply     func(Tombstone)      // The function applying a tombstone on the node (optional)
+	broadcast func([]byte)         // The function broadcasting a tombstone to the other nodes (optional)
+}
+
+// New loads the log persisted in the directory, or creates an empty one.
+func New(dir string) (*Log, error) {
+	if dir == "" {
+		dir = "/data" // Same default as the storage
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		path:    filepath.Join(dir, fileName),
+		entries: make(map[string]Tombstone),
+	}
+
+	b, err := ioutil.ReadFile(l.path)
+	switch {
+	case os.IsNotExist(err):
+		return l, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &l.entries); err != nil {
+		return nil, errors.Internal("tombstone: unable to read "+l.path, err)
+	}
+	return l, nil
+}
+
+// OnChange sets the function applying the tombstones added on this node or replicated from the other nodes.
+func (l *Log) OnChange(apply func(Tombstone)) {
+	l.apply = apply
+}
+
+// SetBroadcast sets the function broadcasting the tombstones added on this node to the other nodes.
+func (l *Log) SetBroadcast(broadcast func([]byte)) {
+	l.broadcast = broadcast
+}
+
+// Add records a new tombstone, persists it, applies it and broadcasts it.
+func (l *Log) Add(t Tombstone) (Tombstone, error) {
+	if t.Table == "" || (t.Key == "" && !t.Timed()) {
+		return Tombstone{}, errors.InvalidArgument("tombstone: a table and either a key or a time range are required")
+	}
+
+	if t.Until != 0 && t.Until <= t.From {
+		return Tombstone{}, errors.InvalidArgument("tombstone: the time range is empty")
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Tombstone{}, err
+	}
+
+	t.ID = hex.EncodeToString(id[:])
+	t.Created = time.Now().UnixNano()
+
+	l.lock.Lock()
+	l.prune(time.Now())
+	l.entries[t.ID] = t
+	err := l.persist()
+	l.lock.Unlock()
+	if err != nil {
+		return Tombstone{}, err
+	}
+
+	l.notify(t)
+	if l.broadcast != nil {
+		b, _ := json.Marshal(map[string]Tombstone{t.ID: t})
+		l.broadcast(b)
+	}
+	return t, nil
+}
+
+// All returns the tombstones which did not expire, oldest first.
+func (l *Log) All() []Tombstone {
+	return l.Of("")
+}
+
+// Of returns the tombstones of a table which did not expire, oldest first, or of every table if the name is empty.
+func (l *Log) Of(table string) []Tombstone {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	var out []Tombstone
+	for _, t := range l.entries {
+		if (table == "" || t.Table == table) && !t.Expired(now) {
+			out = append(out, t)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created < out[j].Created
+	})
+	return out
+}
+
+// Encode encodes the tombstones which did not expire.
+func (l *Log) Encode() []byte {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.prune(time.Now())
+	b, _ := json.Marshal(l.entries)
+	return b
+}
+
+// Merge merges the tombstones received from another node, applying the ones which were not known yet.
+func (l *Log) Merge(update []byte) {
+	var remote map[string]Tombstone
+	if err := json.Unmarshal(update, &remote); err != nil {
+		return
+	}
+
+	now := time.Now()
+	l.lock.Lock()
+	added := make([]Tombstone, 0, len(remote))
+	for id, t := range remote {
+		if _, ok := l.entries[id]; !ok && !t.Expired(now) {
+			l.entries[id] = t
+			added = append(added, t)
+		}
+	}
+
+	var err error
+	if len(added) > 0 {
+		err = l.persist()
+	}
+	l.lock.Unlock()
+
+	if err == nil {
+		l.notify(added...)
+	}
+}
+
+// notify applies the tombstones on this node
+func (l *Log) notify(tombstones ...Tombstone) {
+	if l.apply == nil {
+		return
+	}
+
+	for _, t := range tombstones {
+		l.apply(t)
+	}
+}
+
+// prune forgets the tombstones which expired
+func (l *Log) prune(now time.Time) {
+	for id, t := range l.entries {
+		if t.Expired(now) {
+			delete(l.entries, id)
+		}
+	}
+}
+
+// persist writes the log to its file, replacing the previous one at once
+func (l *Log) persist() error {
+	b, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Internal("tombstone: unable to write "+tmp, err)
+	}
+
+	if err := os.Rename(tmp, l.path); err != nil {
+		return errors.Internal("tombstone: unable to write "+l.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package tombstone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tombstone-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := New(dir)
+	assert.NoError(t, err)
+
+	var applied []Tombstone
+	var broadcast [][]byte
+	l.OnChange(func(t Tombstone) { applied = append(applied, t) })
+	l.SetBroadcast(func(b []byte) { broadcast = append(broadcast, b) })
+
+	// A tombstone requires a key or a time range
+	_, err = l.Add(Tombstone{Table: "eventlog"})
+	assert.Error(t, err)
+	_, err = l.Add(Tombstone{Table: "eventlog", From: 20, Until: 10})
+	assert.Error(t, err)
+
+	user, err := l.Add(Tombstone{Table: "eventlog", Key: "user-1"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, user.ID)
+	assert.NotZero(t, user.Created)
+
+	_, err = l.Add(Tombstone{Table: "orders", From: 10, Until: 20, Expires: time.Now().Add(time.Hour).UnixNano()})
+	assert.NoError(t, err)
+	assert.Len(t, l.All(), 2)
+	assert.Equal(t, []Tombstone{user}, l.Of("eventlog"))
+	assert.Len(t, applied, 2)
+	assert.Len(t, broadcast, 2)
+
+	// The log is persisted
+	reloaded, err := New(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, l.All(), reloaded.All())
+
+	// The tombstones of the other nodes are applied once, and the expired ones are forgotten
+	other, err := New(dir + "/other")
+	assert.NoError(t, err)
+	other.OnChange(func(t Tombstone) { applied = append(applied, t) })
+	other.Merge(l.Encode())
+	other.Merge(broadcast[0])
+	assert.Len(t, other.All(), 2)
+	assert.Len(t, applied, 4)
+
+	other.Merge([]byte(`{"x":{"id":"x","table":"eventlog","key":"user-2","expires":1}}`))
+	other.Merge([]byte("not json"))
+	assert.Len(t, other.All(), 2)
+	assert.Len(t, applied, 4)
+}
@@ -0,0 +1,196 @@
+package adls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	apiVersion   = "2019-12-12"
+	resource     = "https://storage.azure.com/"
+	temporaryDir = "_temporary" // The directory of the files being written, ignored by Spark and Hive
+	maxAttempts  = 5            // The maximum number of attempts of a request failing on a transient error
+	maxAppend    = 4 << 20      // The maximum size of the data appended by a single request
+)
+
+// Writer represents a writer for Azure Data Lake Storage Gen2. Each file is written in a temporary directory
+// and then renamed, which is atomic with the hierarchical namespace, so the external tables reading the
+// directory never see a partially written file.
+type Writer struct {
+	client     *http.Client
+	endpoint   string                 // The endpoint of the account, such as "https://account.dfs.core.windows.net"
+	filesystem string                 // The name of the file system
+	prefix     string                 // The directory of the files
+	token      func() (string, error) // The token authenticating the requests
+	backoff    time.Duration          // The delay before retrying, doubled on every attempt
+}
+
+// New creates a new writer, authenticated with Azure AD. A service principal is used when the AZURE_TENANT_ID,
+// AZURE_CLIENT_ID and AZURE_CLIENT_SECRET environment variables are set, and the managed identity otherwise.
+func New(account, filesystem, prefix string) (*Writer, error) {
+	if account == "" || filesystem == "" {
+		return nil, errors.New("adls: an account and a file system are required")
+	}
+
+	token, err := newToken(os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET"))
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := os.Getenv("AZURE_DFS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.dfs.core.windows.net", account)
+	}
+
+	return &Writer{
+		client:     &http.Client{Timeout: 5 * time.Minute},
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		filesystem: filesystem,
+		prefix:     strings.Trim(prefix, "/"),
+		token:      token,
+		backoff:    time.Second,
+	}, nil
+}
+
+// newToken creates a source of Azure AD tokens for the storage, either for a service principal or for the
+// managed identity
+func newToken(tenantID, clientID, clientSecret string) (func() (string, error), error) {
+	var spt *adal.ServicePrincipalToken
+	switch {
+	case tenantID != "" && clientID != "" && clientSecret != "":
+		config, err := adal.NewOAuthConfig("https://login.microsoftonline.com/", tenantID)
+		if err != nil {
+			return nil, errors.Internal("adls: unable to configure Azure AD", err)
+		}
+
+		if spt, err = adal.NewServicePrincipalToken(*config, clientID, clientSecret, resource); err != nil {
+			return nil, errors.Internal("adls: unable to create a service principal token", err)
+		}
+	default:
+		endpoint, err := adal.GetMSIVMEndpoint()
+		if err != nil {
+			return nil, errors.Internal("adls: unable to get the managed identity endpoint", err)
+		}
+
+		if spt, err = adal.NewServicePrincipalTokenFromMSI(endpoint, resource); err != nil {
+			return nil, errors.Internal("adls: unable to create a managed identity token", err)
+		}
+	}
+
+	return func() (string, error) {
+		if err := spt.EnsureFresh(); err != nil {
+			return "", err
+		}
+		return spt.OAuthToken(), nil
+	}, nil
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	name := path.Join(w.prefix, string(key))
+	temp := path.Join(w.prefix, temporaryDir, string(key))
+
+	// Create the temporary file, replacing any file left by a previous attempt
+	if err := w.do(http.MethodPut, temp, "resource=file", nil, nil); err != nil {
+		return errors.Internal("adls: unable to create "+temp, err)
+	}
+
+	for position := 0; position < len(val); position += maxAppend {
+		end := position + maxAppend
+		if end > len(val) {
+			end = len(val)
+		}
+
+		query := fmt.Sprintf("action=append&position=%d", position)
+		if err := w.do(http.MethodPatch, temp, query, nil, val[position:end]); err != nil {
+			return errors.Internal("adls: unable to append to "+temp, err)
+		}
+	}
+
+	query := fmt.Sprintf("action=flush&position=%d&close=true", len(val))
+	if err := w.do(http.MethodPatch, temp, query, nil, nil); err != nil {
+		return errors.Internal("adls: unable to flush "+temp, err)
+	}
+
+	// Atomically move the file into its directory, replacing any file with the same name
+	source := map[string]string{"x-ms-rename-source": w.pathOf(temp)}
+	if err := w.do(http.MethodPut, name, "", source, nil); err != nil {
+		return errors.Internal("adls: unable to rename "+temp, err)
+	}
+	return nil
+}
+
+// do sends a request for a path, retrying with an exponential backoff while it fails on a transient error
+func (w *Writer) do(method, name, query string, headers map[string]string, body []byte) error {
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		status, err := w.send(method, name, query, headers, body)
+		switch {
+		case err == nil && status < 300:
+			return nil
+		case err == nil && status == http.StatusNotFound && attempt > 0 && headers["x-ms-rename-source"] != "":
+			return nil // The rename succeeded but its response was lost
+		case err == nil && status != http.StatusTooManyRequests && status < 500:
+			return errors.Newf("adls: unexpected status %d", status)
+		case err == nil:
+			err = errors.Newf("adls: unexpected status %d", status)
+		}
+
+		if attempt+1 == maxAttempts {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send sends a request for a path
+func (w *Writer) send(method, name, query string, headers map[string]string, body []byte) (int, error) {
+	uri := w.endpoint + w.pathOf(name)
+	if query != "" {
+		uri += "?" + query
+	}
+
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := w.token()
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, nil
+}
+
+// pathOf returns the escaped path of a file within the file system
+func (w *Writer) pathOf(name string) string {
+	return (&url.URL{Path: "/" + w.filesystem + "/" + name}).EscapedPath()
+}
@@ -0,0 +1,100 @@
+package adls
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// filesystem represents a file system with a hierarchical namespace, failing while unavailable
+type filesystem struct {
+	*httptest.Server
+	files       map[string][]byte // The flushed files
+	pending     map[string][]byte // The data appended but not yet flushed
+	unavailable int               // The number of requests to fail
+}
+
+func newFilesystem(t *testing.T) *filesystem {
+	fs := &filesystem{files: map[string][]byte{}, pending: map[string][]byte{}}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, apiVersion, r.Header.Get("x-ms-version"))
+		if fs.unavailable > 0 {
+			fs.unavailable--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPut && query.Get("resource") == "file":
+			fs.files[r.URL.Path] = []byte{}
+			fs.pending[r.URL.Path] = []byte{}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPatch && query.Get("action") == "append":
+			position, _ := strconv.Atoi(query.Get("position"))
+			fs.pending[r.URL.Path] = append(fs.pending[r.URL.Path][:position], body...)
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPatch && query.Get("action") == "flush":
+			position, _ := strconv.Atoi(query.Get("position"))
+			assert.Equal(t, "true", query.Get("close"))
+			fs.files[r.URL.Path] = fs.pending[r.URL.Path][:position]
+		case r.Method == http.MethodPut && r.Header.Get("x-ms-rename-source") != "":
+			source, _ := url.PathUnescape(r.Header.Get("x-ms-rename-source"))
+			data, ok := fs.files[source]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			delete(fs.files, source)
+			fs.files[r.URL.Path] = data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return fs
+}
+
+func TestWrite(t *testing.T) {
+	fs := newFilesystem(t)
+	defer fs.Close()
+
+	w := &Writer{
+		client:     fs.Client(),
+		endpoint:   fs.URL,
+		filesystem: "lake",
+		prefix:     "events/dt=2020-01-01",
+		token:      func() (string, error) { return "token", nil },
+	}
+
+	// The file is written in the temporary directory, then renamed
+	data := make([]byte, maxAppend+10)
+	data[maxAppend] = 1
+	assert.NoError(t, w.Write([]byte("file 1.orc"), data))
+	assert.Equal(t, map[string][]byte{
+		"/lake/events/dt=2020-01-01/file 1.orc": data,
+	}, fs.files)
+
+	// A transient error is retried
+	fs.unavailable = 2
+	assert.NoError(t, w.Write([]byte("file 2.orc"), []byte("hello")))
+	assert.Equal(t, []byte("hello"), fs.files["/lake/events/dt=2020-01-01/file 2.orc"])
+	assert.Len(t, fs.files, 2)
+
+	// A request which keeps failing fails the write
+	fs.unavailable = maxAttempts
+	assert.Error(t, w.Write([]byte("file 3.orc"), []byte("hello")))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("", "lake", "")
+	assert.Error(t, err)
+}
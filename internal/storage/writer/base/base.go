@@ -20,7 +20,7 @@ type Func func(interface{}) ([]byte, error)
 type Writer struct {
 	task    async.Task
 	Process func(context.Context) error
-	filter  *lua.Script
+	filter  *script.Script
 	name    string
 	encode  Func
 }
@@ -55,7 +55,7 @@ func New(filter, encoderFunc string, loader *script.Loader) (*Writer, error) {
 }
 
 // newWithEncoder will generate a new encoder for a writer
-func newWithEncoder(name string, filter *lua.Script, encoder Func) (*Writer, error) {
+func newWithEncoder(name string, filter *script.Script, encoder Func) (*Writer, error) {
 	if encoder == nil {
 		encoder = Func(json.Marshal)
 	}
@@ -1,69 +1,328 @@
 package bigquery
 
 import (
-	"bytes"
 	"context"
+	stderrors "errors"
+	"time"
 
-	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/monitor/errors"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
-// Writer represents a writer for Google Cloud Storage.
+const (
+	ctxTag         = "bigquery"
+	maxRequestSize = 8 << 20 // The maximum size of the rows appended by a single request
+	maxAttempts    = 5       // The maximum number of attempts of an operation failing on a quota or transient error
+	maxBatch       = 500     // The maximum number of streamed rows appended at once
+)
+
+// Writer represents a writer for Google Big Query, using the Storage Write API. Every file is appended to a
+// pending stream which is committed once fully written, so that a file is either entirely written or not at
+// all, while the rows streamed are appended to a committed stream at explicit offsets, so that a retried
+// append is not written twice.
 type Writer struct {
-	dataset string
-	table   *bigquery.Table
-	client  *bigquery.Client
-	context context.Context
+	parent  string                       // The table, as projects/{project}/datasets/{dataset}/tables/{table}
+	client  *managedwriter.Client        // The client of the Storage Write API
+	monitor monitor.Monitor              // The monitoring layer
+	backoff time.Duration                // The delay before retrying, doubled on every attempt
+	buffer  chan block.Row               // The rows to stream
+	stream  *managedwriter.ManagedStream // The committed stream the rows are appended to
+	offset  int64                        // The offset of the next row in the committed stream
 }
 
 // New creates a new writer.
-func New(project, dataset, table string) (*Writer, error) {
-	ctx := context.Background()
-	client, err := bigquery.NewClient(ctx, project)
+func New(project, dataset, table string, monitor monitor.Monitor, opts ...option.ClientOption) (*Writer, error) {
+	client, err := managedwriter.NewClient(context.Background(), project, opts...)
 	if err != nil {
 		return nil, errors.Newf("bigquery: %v", err)
 	}
 
-	tableRef := client.Dataset(dataset).Table(table)
+	return newWithClient(managedwriter.TableParentFromParts(project, dataset, table), client, monitor), nil
+}
+
+// newWithClient creates a new writer for a client of the Storage Write API
+func newWithClient(parent string, client *managedwriter.Client, monitor monitor.Monitor) *Writer {
 	return &Writer{
-		dataset: dataset,
-		table:   tableRef,
+		parent:  parent,
 		client:  client,
-		context: ctx,
-	}, nil
+		monitor: monitor,
+		backoff: time.Second,
+		buffer:  make(chan block.Row, 65000),
+	}
 }
 
 // Write writes the data to the sink.
-func (w *Writer) Write(key key.Key, val []byte) error {
-	source := bigquery.NewReaderSource(bytes.NewReader(val))
-	source.FileConfig = bigquery.FileConfig{
-		SourceFormat:        bigquery.DataFormat("ORC"),
-		AutoDetect:          false,
-		IgnoreUnknownValues: true,
-		MaxBadRecords:       0,
+func (w *Writer) Write(_ key.Key, val []byte) error {
+	schema, rows, err := decode(val)
+	if err != nil {
+		return errors.Internal("bigquery: unable to decode", err)
 	}
 
-	// Run the loader
-	loader := w.table.LoaderFrom(source)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// Write the rows to a pending stream, which is discarded if the write fails
 	ctx := context.Background()
-	job, err := loader.Run(ctx)
-	if err != nil {
-		return errors.Internal("bigquery: unable to run a job", err)
+	var name string
+	if err := w.retry(func() (err error) {
+		name, err = w.writePending(ctx, schema, rows)
+		return
+	}); err != nil {
+		return errors.Internal("bigquery: unable to write", err)
+	}
+
+	if err := w.commit(ctx, name); err != nil {
+		return errors.Internal("bigquery: unable to commit", err)
 	}
+	return nil
+}
 
-	// Wait for the job to complete
-	status, err := job.Wait(ctx)
+// writePending appends the rows to a new pending stream and finalizes it
+func (w *Writer) writePending(ctx context.Context, schema *descriptorpb.DescriptorProto, rows [][]byte) (string, error) {
+	stream, err := w.client.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(w.parent),
+		managedwriter.WithType(managedwriter.PendingStream),
+		managedwriter.WithSchemaDescriptor(schema))
 	if err != nil {
-		return errors.Internal("bigquery: unable to write", err)
+		return "", err
 	}
-	if err := status.Err(); err != nil {
-		return errors.Internal("bigquery: unable to write", err)
+
+	defer stream.Close()
+	if err := w.append(ctx, stream, schema, rows, 0); err != nil {
+		return "", err
+	}
+
+	if _, err := stream.Finalize(ctx); err != nil {
+		return "", err
+	}
+	return stream.StreamName(), nil
+}
+
+// commit commits a pending stream. Since the commit of a stream is atomic, a commit which is retried, for
+// example when its response was lost, succeeds if the stream was already committed.
+func (w *Writer) commit(ctx context.Context, name string) error {
+	return w.retry(func() error {
+		response, err := w.client.BatchCommitWriteStreams(ctx, &storagepb.BatchCommitWriteStreamsRequest{
+			Parent:       w.parent,
+			WriteStreams: []string{name},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, serr := range response.GetStreamErrors() {
+			if serr.GetCode() != storagepb.StorageError_STREAM_ALREADY_COMMITTED {
+				return errors.Newf("bigquery: %s %v", serr.GetCode(), serr.GetErrorMessage())
+			}
+		}
+		return nil
+	})
+}
+
+// append appends the rows to the stream from an offset, in as many requests as required by their size. The
+// rows which were already appended at their offset are skipped by the stream.
+func (w *Writer) append(ctx context.Context, stream *managedwriter.ManagedStream, schema *descriptorpb.DescriptorProto, rows [][]byte, offset int64) error {
+	var results []*managedwriter.AppendResult
+	for _, chunk := range chunksOf(rows) {
+		result, err := stream.AppendRows(ctx, chunk,
+			managedwriter.WithOffset(offset),
+			managedwriter.UpdateSchemaDescriptor(schema))
+		if err != nil {
+			return err
+		}
+
+		results = append(results, result)
+		offset += int64(len(chunk))
+	}
+
+	// Every request is answered by a response, in order
+	for _, result := range results {
+		if _, err := result.GetResult(ctx); err != nil && codeOf(err) != codes.AlreadyExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// retry runs an operation until it succeeds, retrying with an exponential backoff while it fails on a quota
+// or a transient error
+func (w *Writer) retry(f func() error) (err error) {
+	delay := w.backoff
+	for attempt := 1; ; attempt++ {
+		if err = f(); err == nil || !retryable(err) || attempt == maxAttempts {
+			return err
+		}
+
+		w.monitor.Count1(ctxTag, "retry", "code:"+codeOf(err).String())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// retryable returns whether an error is caused by an exhausted quota or is transient
+func retryable(err error) bool {
+	switch codeOf(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// codeOf returns the code of a gRPC error, which the client may have wrapped
+func codeOf(err error) codes.Code {
+	var s interface{ GRPCStatus() *status.Status }
+	if stderrors.As(err, &s) {
+		return s.GRPCStatus().Code()
+	}
+	return status.Code(err)
+}
+
+// Stream pushes a row into the buffer, to be appended to the committed stream.
+func (w *Writer) Stream(row block.Row) error {
+	select {
+	case w.buffer <- row:
+	default:
+		return errors.New("bigquery: buffer is full")
 	}
 	return nil
 }
 
+// Run appends the rows streamed to the committed stream, in batches, until the context is cancelled.
+func (w *Writer) Run(ctx context.Context) (async.Task, error) {
+	return async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, w.process(ctx)
+	}), nil
+}
+
+// process reads from the buffer and appends the rows every second or once a batch is full
+func (w *Writer) process(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := make([]block.Row, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case row := <-w.buffer:
+			if batch = append(batch, row); len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if len(batch) > 0 {
+			if err := w.flush(ctx, batch); err != nil {
+				w.monitor.Error(errors.Internal("bigquery: unable to stream", err))
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush appends a batch of rows to the committed stream, creating it if required
+func (w *Writer) flush(ctx context.Context, batch []block.Row) error {
+	schema := make(typeof.Schema, 16)
+	for _, row := range batch {
+		for k, typ := range row.Schema {
+			if _, ok := schema[k]; !ok {
+				schema[k] = typ
+			}
+		}
+	}
+
+	columns := schema.Columns()
+	values := make([]interface{}, len(columns))
+	rows := make([][]byte, 0, len(batch))
+	for _, row := range batch {
+		for i, c := range columns {
+			values[i] = row.Values[c]
+		}
+		rows = append(rows, encodeRow(schema, columns, values))
+	}
+
+	descriptor := descriptorOf(schema, columns)
+	err := w.retry(func() error {
+		if w.stream == nil {
+			stream, err := w.client.NewManagedStream(ctx,
+				managedwriter.WithDestinationTable(w.parent),
+				managedwriter.WithType(managedwriter.CommittedStream),
+				managedwriter.WithSchemaDescriptor(descriptor))
+			if err != nil {
+				return err
+			}
+
+			w.stream = stream
+			w.offset = 0
+		}
+
+		return w.append(ctx, w.stream, descriptor, rows, w.offset)
+	})
+
+	// The offsets of a stream which failed are unknown, so the next batch is appended to a new one
+	if err != nil && w.stream != nil {
+		w.stream.Close()
+		w.stream = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w.monitor.Count(ctxTag, "stream.rows", int64(len(rows)))
+	w.offset += int64(len(rows))
+	return nil
+}
+
+// decode decodes the rows of an ORC file
+func decode(val []byte) (*descriptorpb.DescriptorProto, [][]byte, error) {
+	i, err := orc.FromBuffer(val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer i.Close()
+	schema := i.Schema()
+	columns := schema.Columns()
+
+	var rows [][]byte
+	i.Range(func(_ int, values []interface{}) bool {
+		rows = append(rows, encodeRow(schema, columns, values))
+		return false
+	}, columns...)
+	return descriptorOf(schema, columns), rows, nil
+}
+
+// chunksOf splits the rows in chunks which can be appended by a single request
+func chunksOf(rows [][]byte) (chunks [][][]byte) {
+	start, size := 0, 0
+	for i, row := range rows {
+		if size+len(row) > maxRequestSize && i > start {
+			chunks = append(chunks, rows[start:i])
+			start, size = i, 0
+		}
+		size += len(row)
+	}
+	return append(chunks, rows[start:])
+}
+
 // Close closes the writer.
 func (w *Writer) Close() error {
+	if w.stream != nil {
+		w.stream.Close()
+		w.stream = nil
+	}
 	return w.client.Close()
 }
@@ -1,14 +1,38 @@
 package bigquery
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	eorc "github.com/crphang/orc"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestWriter(t *testing.T) {
 
-	c, err := New("my-project-id", "mydataset", "mytable")
+	c, err := New("my-project-id", "mydataset", "mytable", monitor.NewNoop())
 
 	assert.Nil(t, c)
 	assert.Error(t, err)
@@ -17,3 +41,273 @@ func TestWriter(t *testing.T) {
 		c.Write([]byte("abc"), []byte("hello"))
 	})
 }
+
+func TestWrite(t *testing.T) {
+	schema := typeof.Schema{
+		"event": typeof.String,
+		"count": typeof.Int64,
+		"ratio": typeof.Float64,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := eorc.NewWriter(buffer, eorc.SetSchema(orcSchema))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(int64(1), "click", 0.5))
+	assert.NoError(t, writer.Write(int64(2), "view", 1.5))
+	assert.NoError(t, writer.Close())
+
+	// The first append fails on a quota, and is retried on a new stream, while the response of the commit
+	// is lost, so it is retried and the stream is only committed once
+	server := newFakeServer()
+	server.failures = []codes.Code{codes.ResourceExhausted}
+	server.lostCommit = true
+	w := newTestWriter(t, server)
+
+	assert.NoError(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+	assert.Len(t, server.streams, 2)
+	assert.Equal(t, []string{"count", "event", "ratio"}, server.fields)
+	assert.Equal(t, []map[protowire.Number]interface{}{
+		{1: uint64(1), 2: "click", 3: 0.5},
+		{1: uint64(2), 2: "view", 3: 1.5},
+	}, server.table())
+}
+
+func TestStream(t *testing.T) {
+	server := newFakeServer()
+	w := newTestWriter(t, server)
+
+	ts := time.Unix(1600000000, 0)
+	batch := []block.Row{
+		{Values: map[string]interface{}{"event": "click", "time": ts}, Schema: typeof.Schema{"event": typeof.String, "time": typeof.Timestamp}},
+		{Values: map[string]interface{}{"event": "view", "valid": true}, Schema: typeof.Schema{"event": typeof.String, "valid": typeof.Bool}},
+	}
+
+	// The response of the first append is lost, so it is retried and skipped at its offset
+	server.failures = []codes.Code{codes.Unavailable}
+	server.lost = true
+	assert.NoError(t, w.flush(context.Background(), batch))
+	assert.NoError(t, w.flush(context.Background(), batch[:1]))
+	assert.Len(t, server.streams, 1)
+	assert.Equal(t, int64(3), w.offset)
+	assert.Equal(t, []string{"event", "time"}, server.fields)
+	assert.Equal(t, []map[protowire.Number]interface{}{
+		{1: "click", 2: uint64(ts.UnixNano() / 1000)},
+		{1: "view", 3: uint64(1)},
+		{1: "click", 2: uint64(ts.UnixNano() / 1000)},
+	}, server.table())
+
+	// A stream failing on an error which is not retried is replaced
+	server.failures = []codes.Code{codes.InvalidArgument}
+	server.lost = false
+	assert.Error(t, w.flush(context.Background(), batch))
+	assert.NoError(t, w.flush(context.Background(), batch))
+	assert.Len(t, server.streams, 2)
+	assert.Len(t, server.table(), 5)
+}
+
+func TestChunksOf(t *testing.T) {
+	row := make([]byte, maxRequestSize/2)
+	assert.Len(t, chunksOf([][]byte{row}), 1)
+	assert.Len(t, chunksOf([][]byte{row, row}), 1)
+	assert.Len(t, chunksOf([][]byte{row, row, row}), 2)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// newTestWriter creates a writer for a fake Storage Write API, served on a local port
+func newTestWriter(t *testing.T, server *fakeServer) *Writer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := grpc.NewServer()
+	storagepb.RegisterBigQueryWriteServer(s, server)
+	go s.Serve(listener)
+
+	client, err := managedwriter.NewClient(context.Background(), "p",
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	assert.NoError(t, err)
+
+	w := newWithClient("projects/p/datasets/d/tables/t", client, monitor.NewNoop())
+	w.backoff = 0
+	t.Cleanup(func() {
+		w.Close()
+		s.Stop()
+	})
+	return w
+}
+
+// fakeStream represents a write stream of the fake server
+type fakeStream struct {
+	typ       storagepb.WriteStream_Type
+	rows      [][]byte
+	finalized bool
+	committed bool
+}
+
+// fakeServer represents a Storage Write API which fails the appends with the codes of the failures, once each
+type fakeServer struct {
+	storagepb.UnimplementedBigQueryWriteServer
+	lock       sync.Mutex
+	streams    map[string]*fakeStream
+	order      []string
+	fields     []string
+	failures   []codes.Code
+	lost       bool // Whether the failed appends are written regardless
+	lostCommit bool // Whether the response of the next commit is lost
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{streams: make(map[string]*fakeStream)}
+}
+
+// table returns the rows committed to the table, decoded
+func (c *fakeServer) table() (out []map[protowire.Number]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, name := range c.order {
+		if s := c.streams[name]; s.committed {
+			for _, row := range s.rows {
+				out = append(out, decodeRow(row))
+			}
+		}
+	}
+	return
+}
+
+func (c *fakeServer) CreateWriteStream(_ context.Context, in *storagepb.CreateWriteStreamRequest) (*storagepb.WriteStream, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	name := fmt.Sprintf("%s/streams/%d", in.Parent, len(c.streams))
+	c.streams[name] = &fakeStream{typ: in.WriteStream.Type, committed: in.WriteStream.Type == storagepb.WriteStream_COMMITTED}
+	c.order = append(c.order, name)
+	return &storagepb.WriteStream{Name: name, Type: in.WriteStream.Type}, nil
+}
+
+func (c *fakeServer) FinalizeWriteStream(_ context.Context, in *storagepb.FinalizeWriteStreamRequest) (*storagepb.FinalizeWriteStreamResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := c.streams[in.Name]
+	s.finalized = true
+	return &storagepb.FinalizeWriteStreamResponse{RowCount: int64(len(s.rows))}, nil
+}
+
+func (c *fakeServer) BatchCommitWriteStreams(_ context.Context, in *storagepb.BatchCommitWriteStreamsRequest) (*storagepb.BatchCommitWriteStreamsResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var errs []*storagepb.StorageError
+	for _, name := range in.WriteStreams {
+		switch s := c.streams[name]; {
+		case s.committed:
+			errs = append(errs, &storagepb.StorageError{Code: storagepb.StorageError_STREAM_ALREADY_COMMITTED, Entity: name})
+		case !s.finalized:
+			errs = append(errs, &storagepb.StorageError{Code: storagepb.StorageError_INVALID_STREAM_STATE, Entity: name})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &storagepb.BatchCommitWriteStreamsResponse{StreamErrors: errs}, nil
+	}
+
+	for _, name := range in.WriteStreams {
+		c.streams[name].committed = true
+	}
+
+	if c.lostCommit {
+		c.lostCommit = false
+		return nil, status.Error(codes.Unavailable, "response was lost")
+	}
+	return &storagepb.BatchCommitWriteStreamsResponse{CommitTime: timestamppb.Now()}, nil
+}
+
+func (c *fakeServer) AppendRows(server storagepb.BigQueryWrite_AppendRowsServer) error {
+	var name string
+	for {
+		in, err := server.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The stream and the schema are only sent by the first request of a connection
+		if in.WriteStream != "" {
+			name = in.WriteStream
+		}
+
+		if err := server.Send(c.append(name, in)); err != nil {
+			return err
+		}
+	}
+}
+
+// append appends the rows of a request to a stream and returns the response
+func (c *fakeServer) append(name string, in *storagepb.AppendRowsRequest) *storagepb.AppendRowsResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := c.streams[name]
+	data := in.GetProtoRows()
+	if schema := data.WriterSchema; schema != nil {
+		c.fields = c.fields[:0]
+		for _, f := range schema.ProtoDescriptor.Field {
+			c.fields = append(c.fields, f.GetName())
+		}
+	}
+
+	// Fail the append, writing the rows if the response is lost
+	if len(c.failures) > 0 {
+		code := c.failures[0]
+		c.failures = c.failures[1:]
+		if c.lost {
+			s.rows = append(s.rows, data.Rows.SerializedRows...)
+		}
+		return errorOf(code)
+	}
+
+	switch offset := in.Offset.GetValue(); {
+	case offset < int64(len(s.rows)):
+		return errorOf(codes.AlreadyExists)
+	case offset > int64(len(s.rows)):
+		return errorOf(codes.OutOfRange)
+	default:
+		s.rows = append(s.rows, data.Rows.SerializedRows...)
+		return &storagepb.AppendRowsResponse{
+			Response: &storagepb.AppendRowsResponse_AppendResult_{
+				AppendResult: &storagepb.AppendRowsResponse_AppendResult{Offset: wrapperspb.Int64(offset)},
+			},
+		}
+	}
+}
+
+func errorOf(code codes.Code) *storagepb.AppendRowsResponse {
+	return &storagepb.AppendRowsResponse{
+		Response: &storagepb.AppendRowsResponse_Error{Error: &spb.Status{Code: int32(code), Message: code.String()}},
+	}
+}
+
+// decodeRow decodes the fields of an encoded row
+func decodeRow(b []byte) map[protowire.Number]interface{} {
+	out := make(map[protowire.Number]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			out[num], b = v, b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			out[num], b = math.Float64frombits(v), b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			out[num], b = string(v), b[n:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package bigquery
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// descriptorOf returns the protocol buffer message describing the rows, with a field per column numbered
+// in the order of the columns.
+func descriptorOf(schema typeof.Schema, columns []string) *descriptorpb.DescriptorProto {
+	message := &descriptorpb.DescriptorProto{
+		Name:  proto.String("row"),
+		Field: make([]*descriptorpb.FieldDescriptorProto, 0, len(columns)),
+	}
+
+	for i, name := range columns {
+		message.Field = append(message.Field, &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   fieldTypeOf(schema[name]).Enum(),
+		})
+	}
+	return message
+}
+
+// fieldTypeOf returns the protocol buffer type of a column. Big Query converts an INT64 to a TIMESTAMP
+// column as microseconds since the epoch, and a STRING to a JSON column.
+func fieldTypeOf(typ typeof.Type) descriptorpb.FieldDescriptorProto_Type {
+	switch typ {
+	case typeof.Int32:
+		return descriptorpb.FieldDescriptorProto_TYPE_INT32
+	case typeof.Int64, typeof.Timestamp:
+		return descriptorpb.FieldDescriptorProto_TYPE_INT64
+	case typeof.Float64:
+		return descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	case typeof.Bool:
+		return descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	default:
+		return descriptorpb.FieldDescriptorProto_TYPE_STRING
+	}
+}
+
+// encodeRow encodes the values of a row, in the order of the columns, as a message of the descriptor. The
+// null values, and the ones which do not match the type of their column, are left out.
+func encodeRow(schema typeof.Schema, columns []string, values []interface{}) []byte {
+	var out []byte
+	for i, v := range values {
+		out = appendValue(out, protowire.Number(i+1), schema[columns[i]], v)
+	}
+	return out
+}
+
+// appendValue appends a field of the message
+func appendValue(b []byte, num protowire.Number, typ typeof.Type, value interface{}) []byte {
+	switch typ {
+	case typeof.Int32, typeof.Int64, typeof.Timestamp:
+		if v, ok := intOf(value); ok {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		}
+	case typeof.Float64:
+		if v, ok := floatOf(value); ok {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(v))
+		}
+	case typeof.Bool:
+		if v, ok := value.(bool); ok {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+		}
+	case typeof.String, typeof.JSON:
+		if v, ok := bytesOf(value); ok {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+	return b
+}
+
+// intOf converts an integer, or a time as microseconds since the epoch
+func intOf(value interface{}) (int64, bool) {
+	if v, ok := value.(time.Time); ok {
+		return v.UnixNano() / int64(time.Microsecond), true
+	}
+
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	default:
+		return 0, false
+	}
+}
+
+// floatOf converts a floating-point number, including the ones of the named types of the ORC decoder
+func floatOf(value interface{}) (float64, bool) {
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// bytesOf converts a string or a JSON document
+func bytesOf(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), true
+	case json.RawMessage:
+		return v, true
+	case []byte:
+		return v, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,155 @@
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "breaker"
+
+// The states of the circuit
+const (
+	closed   = iota // The writes reach the sink
+	open            // The writes fail right away, until the cooldown expires
+	halfOpen        // A single write probes whether the sink recovered
+)
+
+// SubWriter represents the writer of a sink, whose failures open the circuit
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// OpenError represents a write which was rejected without reaching the sink, as its circuit is open
+type OpenError struct {
+	Sink  string    // The name of the sink
+	Since time.Time // The time at which the circuit opened
+}
+
+// Error returns the message of the error
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("breaker: sink %s is unavailable since %s", e.Sink, e.Since.Format(time.RFC3339))
+}
+
+// CircuitOpen tells that the write did not reach the sink, so that it is not retried right away
+func (e *OpenError) CircuitOpen() bool {
+	return true
+}
+
+// Writer represents a circuit breaker in front of a sink. Once a number of consecutive writes failed, the circuit
+// opens and the writes fail right away without reaching the sink, so a sink which is down is not hammered by the
+// retries. Once the cooldown expires, a single write probes the sink, closing the circuit if it succeeds and
+// opening it again for another cooldown otherwise.
+type Writer struct {
+	sync.Mutex
+	dest      SubWriter        // The writer of the sink
+	name      string           // The name of the sink, reported with the metrics
+	monitor   monitor.Monitor  // The monitoring layer
+	threshold int              // The number of consecutive failures opening the circuit
+	cooldown  time.Duration    // The time the circuit stays open before a write probes the sink
+	state     int              // The state of the circuit
+	failures  int              // The number of consecutive failures
+	since     time.Time        // The time at which the circuit opened
+	last      error            // The error of the last write which reached the sink
+	now       func() time.Time // The clock
+}
+
+// New creates a new circuit breaker in front of a sink, opening once 5 consecutive writes failed and probing the
+// sink after 30 seconds, unless specified otherwise.
+func New(dest SubWriter, name string, threshold int, cooldown time.Duration, monitor monitor.Monitor) *Writer {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+
+	return &Writer{
+		dest:      dest,
+		name:      name,
+		monitor:   monitor,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Write writes the data to the sink, unless the circuit is open.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	if err := w.allow(); err != nil {
+		w.monitor.Count1(ctxTag, "rejected", "sink:"+w.name)
+		return err
+	}
+
+	err := w.dest.Write(key, val)
+	w.report(err)
+	return err
+}
+
+// allow returns whether a write can reach the sink, moving an open circuit to half-open once the cooldown expired
+func (w *Writer) allow() error {
+	w.Lock()
+	defer w.Unlock()
+	switch {
+	case w.state == closed:
+		return nil
+	case w.state == open && w.now().Sub(w.since) >= w.cooldown:
+		w.state = halfOpen
+		w.monitor.Gauge(ctxTag, "state", halfOpen, "sink:"+w.name)
+		return nil
+	default:
+		return &OpenError{Sink: w.name, Since: w.since}
+	}
+}
+
+// report records the outcome of a write which reached the sink, opening or closing the circuit
+func (w *Writer) report(err error) {
+	w.Lock()
+	defer w.Unlock()
+	w.last = err
+	if err == nil {
+		if w.state != closed {
+			w.monitor.Info("breaker: sink %s recovered, closing the circuit", w.name)
+			w.monitor.Gauge(ctxTag, "state", closed, "sink:"+w.name)
+		}
+
+		w.state, w.failures = closed, 0
+		return
+	}
+
+	w.failures++
+	switch {
+	case w.state == halfOpen:
+		w.state, w.since = open, w.now()
+		w.monitor.Gauge(ctxTag, "state", open, "sink:"+w.name)
+	case w.state == closed && w.failures >= w.threshold:
+		w.state, w.since = open, w.now()
+		w.monitor.Count1(ctxTag, "open", "sink:"+w.name)
+		w.monitor.Gauge(ctxTag, "state", open, "sink:"+w.name)
+		w.monitor.Warning(errors.Internal(fmt.Sprintf("breaker: sink %s failed %d times in a row, opening the circuit", w.name, w.failures), err))
+	}
+}
+
+// CheckSink returns an error while the circuit is not closed.
+func (w *Writer) CheckSink(ctx context.Context) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.state == closed {
+		return nil
+	}
+
+	return errors.Internal((&OpenError{Sink: w.name, Since: w.since}).Error(), w.last)
+}
+
+// Close closes the underlying writer.
+func (w *Writer) Close() error {
+	if closer, ok := w.dest.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/storage/writer/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// sink represents a sink which is either up or down
+type sink struct {
+	down   bool
+	writes int
+}
+
+func (s *sink) Write(key key.Key, val []byte) error {
+	s.writes++
+	if s.down {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	dest := &sink{down: true}
+	w := New(dest, "s3", 3, time.Minute, monitor.NewNoop())
+	w.now = func() time.Time { return now }
+
+	// The circuit opens after the consecutive failures, then the writes fail without reaching the sink
+	for i := 0; i < 3; i++ {
+		assert.Error(t, w.Write(key.Key("a"), []byte("a")))
+	}
+	assert.Error(t, w.CheckSink(context.Background()))
+
+	err := w.Write(key.Key("a"), []byte("a"))
+	assert.IsType(t, new(OpenError), err)
+	assert.Equal(t, 3, dest.writes)
+
+	// Once the cooldown expired, a single write probes the sink, which opens the circuit again if it fails
+	now = now.Add(time.Minute)
+	assert.Error(t, w.Write(key.Key("a"), []byte("a")))
+	assert.IsType(t, new(OpenError), w.Write(key.Key("a"), []byte("a")))
+	assert.Equal(t, 4, dest.writes)
+
+	// The circuit closes once a probe succeeds
+	now = now.Add(time.Minute)
+	dest.down = false
+	assert.NoError(t, w.Write(key.Key("a"), []byte("a")))
+	assert.NoError(t, w.Write(key.Key("a"), []byte("a")))
+	assert.NoError(t, w.CheckSink(context.Background()))
+	assert.Equal(t, 6, dest.writes)
+}
+
+func TestBreaker_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dest := &sink{down: true}
+	w := retry.New(New(dest, "s3", 1, time.Hour, monitor.NewNoop()), monitor.NewNoop())
+	w.SetBackoff(3, time.Millisecond, time.Millisecond, 0)
+	assert.NoError(t, w.SetDeadLetter(dir, time.Hour))
+	defer w.Close()
+
+	// While the circuit is open, the files are spilled without retrying the sink
+	assert.NoError(t, w.Write(key.Key("a.orc"), []byte("a")))
+	assert.NoError(t, w.Write(key.Key("b.orc"), []byte("b")))
+	assert.Equal(t, 1, dest.writes)
+	assert.Error(t, w.CheckSink(context.Background()))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "catalog"
+
+// SubWriter represents the writer of the files of the table
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Metastore represents a catalog, such as AWS Glue or the Hive Metastore, in which the partitions are registered
+type Metastore interface {
+	Table() (keys []string, location string, err error)
+	AddPartition(values []string, location string) error
+}
+
+// Writer represents a writer which registers the partition of every file written in a catalog, so that the
+// partitioned output can be queried right away without adding the partitions separately.
+type Writer struct {
+	sync.Mutex
+	dest     SubWriter           // The writer of the files
+	store    Metastore           // The catalog in which the partitions are registered
+	monitor  monitor.Monitor     // The monitoring layer
+	keys     []string            // The partition keys of the table, once loaded
+	location string              // The location of the table, once loaded
+	known    map[string]struct{} // The partitions already registered
+}
+
+// New creates a new writer registering the partitions of the files in the catalog.
+func New(dest SubWriter, store Metastore, monitor monitor.Monitor) *Writer {
+	return &Writer{
+		dest:    dest,
+		store:   store,
+		monitor: monitor,
+		known:   make(map[string]struct{}),
+	}
+}
+
+// Write writes the data to the underlying writer and registers its partition. As the file is written, a failure
+// to register the partition is only reported and the registration is attempted again with the next file.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	if err := w.dest.Write(key, val); err != nil {
+		return err
+	}
+
+	if err := w.register(string(key)); err != nil {
+		w.monitor.Count1(ctxTag, "error")
+		w.monitor.Error(err)
+	}
+	return nil
+}
+
+// register registers the partition of a file, named after the Hive convention with a "key=value" directory
+// per partition key, unless it was already registered. The hidden files, such as the manifests, are skipped.
+func (w *Writer) register(name string) error {
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+		return nil
+	}
+
+	dir := path.Dir(name)
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.known[dir]; ok {
+		return nil
+	}
+
+	// Load the partition keys and the location of the table, the first time
+	if w.keys == nil {
+		keys, location, err := w.store.Table()
+		if err != nil {
+			return errors.Internal("catalog: unable to get the table", err)
+		}
+
+		if len(keys) == 0 {
+			return errors.New("catalog: the table has no partition keys")
+		}
+
+		w.keys = keys
+		w.location = strings.TrimSuffix(location, "/")
+	}
+
+	values, err := valuesOf(dir, w.keys)
+	if err != nil {
+		return err
+	}
+
+	if err := w.store.AddPartition(values, w.location+"/"+dir); err != nil {
+		return errors.Internal("catalog: unable to add the partition "+dir, err)
+	}
+
+	w.known[dir] = struct{}{}
+	w.monitor.Count1(ctxTag, "partition")
+	return nil
+}
+
+// valuesOf returns the values of the partition keys, in the order of the keys, from a directory
+func valuesOf(dir string, keys []string) ([]string, error) {
+	found := make(map[string]string, len(keys))
+	for _, segment := range strings.Split(dir, "/") {
+		if i := strings.IndexByte(segment, '='); i > 0 {
+			value, err := url.PathUnescape(segment[i+1:])
+			if err != nil {
+				value = segment[i+1:]
+			}
+			found[segment[:i]] = value
+		}
+	}
+
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, ok := found[k]
+		if !ok {
+			return nil, errors.Newf("catalog: the directory %s has no value for the partition key %s", dir, k)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// CheckSink checks the health of the underlying writer, if it supports it.
+func (w *Writer) CheckSink(ctx context.Context) error {
+	if checker, ok := w.dest.(interface{ CheckSink(context.Context) error }); ok {
+		return checker.CheckSink(ctx)
+	}
+	return nil
+}
+
+// Close closes the underlying writer.
+func (w *Writer) Close() error {
+	if closer, ok := w.dest.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
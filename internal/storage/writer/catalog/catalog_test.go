@@ -0,0 +1,191 @@
+package catalog
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/samuel/go-thrift/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+// metastore represents a catalog keeping the partitions in memory
+type metastore struct {
+	failing    bool
+	partitions map[string][]string
+}
+
+func (m *metastore) Table() ([]string, string, error) {
+	return []string{"dt", "hour"}, "s3://bucket/events/", nil
+}
+
+func (m *metastore) AddPartition(values []string, location string) error {
+	if m.failing {
+		return errors.New("unavailable")
+	}
+
+	m.partitions[location] = values
+	return nil
+}
+
+// sink represents a sink counting the files written
+type sink int
+
+func (s *sink) Write(key.Key, []byte) error {
+	*s++
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	store := &metastore{partitions: map[string][]string{}}
+	dest := new(sink)
+	w := New(dest, store, monitor.NewNoop())
+
+	// The partition of the file is registered once
+	assert.NoError(t, w.Write(key.Key("hour=03/dt=2020-01-01/a.orc"), nil))
+	assert.NoError(t, w.Write(key.Key("hour=03/dt=2020-01-01/b.orc"), nil))
+	assert.Equal(t, map[string][]string{
+		"s3://bucket/events/hour=03/dt=2020-01-01": {"2020-01-01", "03"},
+	}, store.partitions)
+
+	// A file outside of the partitions is still written, and the hidden files are skipped
+	assert.NoError(t, w.Write(key.Key("dt=2020-01-01/c.orc"), nil))
+	assert.NoError(t, w.Write(key.Key("_SUCCESS"), nil))
+	assert.Len(t, store.partitions, 1)
+
+	// A failed registration is attempted again with the next file
+	store.failing = true
+	assert.NoError(t, w.Write(key.Key("hour=04/dt=2020-01-01/a.orc"), nil))
+	store.failing = false
+	assert.NoError(t, w.Write(key.Key("hour=04/dt=2020-01-01/b.orc"), nil))
+	assert.Len(t, store.partitions, 2)
+	assert.Equal(t, sink(6), *dest)
+}
+
+func TestValuesOf(t *testing.T) {
+	values, err := valuesOf("year=2020/month=1/event=a%3Ab", []string{"event", "year"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a:b", "2020"}, values)
+
+	_, err = valuesOf("year=2020", []string{"event"})
+	assert.Error(t, err)
+}
+
+// glueMock represents the AWS Glue API
+type glueMock struct {
+	created []*glue.PartitionInput
+}
+
+func (g *glueMock) GetTable(in *glue.GetTableInput) (*glue.GetTableOutput, error) {
+	return &glue.GetTableOutput{Table: &glue.TableData{
+		Name:          in.Name,
+		PartitionKeys: []*glue.Column{{Name: aws.String("dt")}},
+		StorageDescriptor: &glue.StorageDescriptor{
+			Location:    aws.String("s3://bucket/events"),
+			InputFormat: aws.String("org.apache.hadoop.hive.ql.io.orc.OrcInputFormat"),
+		},
+	}}, nil
+}
+
+func (g *glueMock) BatchCreatePartition(in *glue.BatchCreatePartitionInput) (*glue.BatchCreatePartitionOutput, error) {
+	if len(g.created) > 0 {
+		return &glue.BatchCreatePartitionOutput{Errors: []*glue.PartitionError{{
+			ErrorDetail: &glue.ErrorDetail{ErrorCode: aws.String(glue.ErrCodeAlreadyExistsException)},
+		}}}, nil
+	}
+
+	g.created = append(g.created, in.PartitionInputList...)
+	return &glue.BatchCreatePartitionOutput{}, nil
+}
+
+func TestGlue(t *testing.T) {
+	client := new(glueMock)
+	g := &Glue{client: client, database: "db", table: "events"}
+
+	keys, location, err := g.Table()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dt"}, keys)
+	assert.Equal(t, "s3://bucket/events", location)
+
+	// The partition is stored like the table, and an existing one is ignored
+	assert.NoError(t, g.AddPartition([]string{"2020-01-01"}, "s3://bucket/events/dt=2020-01-01"))
+	assert.NoError(t, g.AddPartition([]string{"2020-01-01"}, "s3://bucket/events/dt=2020-01-01"))
+	assert.Len(t, client.created, 1)
+	assert.Equal(t, "s3://bucket/events/dt=2020-01-01", *client.created[0].StorageDescriptor.Location)
+	assert.Equal(t, "org.apache.hadoop.hive.ql.io.orc.OrcInputFormat", *client.created[0].StorageDescriptor.InputFormat)
+	assert.Equal(t, "s3://bucket/events", *g.sd.Location)
+}
+
+// The arguments of the thrift API, exported as required by the RPC server
+type (
+	GetTableRequest       hiveGetTableRequest
+	GetTableResponse      hiveGetTableResponse
+	AddPartitionsRequest  hiveAddPartitionsRequest
+	AddPartitionsResponse hiveAddPartitionsResponse
+)
+
+// hiveMock represents the thrift API of a Hive Metastore
+type hiveMock struct {
+	added []*hivePartition
+}
+
+func (h *hiveMock) GetTable(req *GetTableRequest, res *GetTableResponse) error {
+	if req.TblName != "events" {
+		res.O2 = &hiveException{Message: "table not found"}
+		return nil
+	}
+
+	res.Value = &hiveTable{
+		TableName:     req.TblName,
+		DbName:        req.DbName,
+		Sd:            &hiveStorageDescriptor{Location: "hdfs://namenode/events", SerdeInfo: &hiveSerDeInfo{Name: "orc"}},
+		PartitionKeys: []*hiveFieldSchema{{Name: "dt", Type: "string"}},
+	}
+	return nil
+}
+
+func (h *hiveMock) AddPartitionsReq(req *AddPartitionsRequest, res *AddPartitionsResponse) error {
+	h.added = append(h.added, req.Request.Parts...)
+	res.Value = &hiveAddPartitionsResult{}
+	return nil
+}
+
+func TestHive(t *testing.T) {
+	mock := new(hiveMock)
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("Thrift", mock))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(thrift.NewServerCodec(thrift.NewTransport(conn, thrift.BinaryProtocol)))
+		}
+	}()
+
+	_, _, err = NewHive(listener.Addr().String(), "db", "missing").Table()
+	assert.Error(t, err)
+
+	h := NewHive(listener.Addr().String(), "db", "events")
+	keys, location, err := h.Table()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dt"}, keys)
+	assert.Equal(t, "hdfs://namenode/events", location)
+
+	assert.NoError(t, h.AddPartition([]string{"2020-01-01"}, "hdfs://namenode/events/dt=2020-01-01"))
+	assert.Len(t, mock.added, 1)
+	assert.Equal(t, []string{"2020-01-01"}, mock.added[0].Values)
+	assert.Equal(t, "events", mock.added[0].TableName)
+	assert.Equal(t, "hdfs://namenode/events/dt=2020-01-01", mock.added[0].Sd.Location)
+	assert.Equal(t, "orc", mock.added[0].Sd.SerdeInfo.Name)
+}
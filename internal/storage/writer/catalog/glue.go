@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// glueClient represents the part of the AWS Glue API used to register the partitions
+type glueClient interface {
+	GetTable(*glue.GetTableInput) (*glue.GetTableOutput, error)
+	BatchCreatePartition(*glue.BatchCreatePartitionInput) (*glue.BatchCreatePartitionOutput, error)
+}
+
+// Glue represents the AWS Glue Data Catalog.
+type Glue struct {
+	client    glueClient
+	catalogID string                  // The ID of the catalog, by default the one of the account (optional)
+	database  string                  // The name of the database
+	table     string                  // The name of the table
+	sd        *glue.StorageDescriptor // The storage descriptor of the table, copied to its partitions
+}
+
+// NewGlue creates a new AWS Glue Data Catalog for a table.
+func NewGlue(region, catalogID, database, table string) *Glue {
+	config := aws.NewConfig()
+	if region != "" {
+		config.WithRegion(region)
+	}
+
+	return &Glue{
+		client:    glue.New(session.New(), config),
+		catalogID: catalogID,
+		database:  database,
+		table:     table,
+	}
+}
+
+// Table returns the partition keys and the location of the table.
+func (g *Glue) Table() ([]string, string, error) {
+	input := &glue.GetTableInput{DatabaseName: aws.String(g.database), Name: aws.String(g.table)}
+	if g.catalogID != "" {
+		input.CatalogId = aws.String(g.catalogID)
+	}
+
+	out, err := g.client.GetTable(input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if out.Table == nil || out.Table.StorageDescriptor == nil {
+		return nil, "", errors.New("glue: the table has no storage descriptor")
+	}
+
+	keys := make([]string, 0, len(out.Table.PartitionKeys))
+	for _, k := range out.Table.PartitionKeys {
+		keys = append(keys, aws.StringValue(k.Name))
+	}
+
+	g.sd = out.Table.StorageDescriptor
+	return keys, aws.StringValue(g.sd.Location), nil
+}
+
+// AddPartition adds a partition to the table, with the storage descriptor of the table, unless it exists.
+func (g *Glue) AddPartition(values []string, location string) error {
+	sd := *g.sd
+	sd.Location = aws.String(location)
+	input := &glue.BatchCreatePartitionInput{
+		DatabaseName: aws.String(g.database),
+		TableName:    aws.String(g.table),
+		PartitionInputList: []*glue.PartitionInput{{
+			Values:            aws.StringSlice(values),
+			StorageDescriptor: &sd,
+		}},
+	}
+	if g.catalogID != "" {
+		input.CatalogId = aws.String(g.catalogID)
+	}
+
+	out, err := g.client.BatchCreatePartition(input)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range out.Errors {
+		if e.ErrorDetail != nil && aws.StringValue(e.ErrorDetail.ErrorCode) != glue.ErrCodeAlreadyExistsException {
+			return errors.New("glue: " + aws.StringValue(e.ErrorDetail.ErrorMessage))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package catalog
+
+import (
+	"net"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/samuel/go-thrift/thrift"
+)
+
+// Hive represents a Hive Metastore, reached through its thrift API with the buffered transport.
+type Hive struct {
+	address  string                 // The address of the metastore, such as "metastore:9083"
+	database string                 // The name of the database
+	table    string                 // The name of the table
+	timeout  time.Duration          // The timeout of a call to the metastore
+	sd       *hiveStorageDescriptor // The storage descriptor of the table, copied to its partitions
+}
+
+// NewHive creates a new Hive Metastore for a table.
+func NewHive(address, database, table string) *Hive {
+	return &Hive{
+		address:  address,
+		database: database,
+		table:    table,
+		timeout:  30 * time.Second,
+	}
+}
+
+// Table returns the partition keys and the location of the table.
+func (h *Hive) Table() ([]string, string, error) {
+	var res hiveGetTableResponse
+	if err := h.call("get_table", &hiveGetTableRequest{DbName: h.database, TblName: h.table}, &res); err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case res.O1 != nil:
+		return nil, "", res.O1
+	case res.O2 != nil:
+		return nil, "", res.O2
+	case res.Value == nil || res.Value.Sd == nil:
+		return nil, "", errors.New("hive: the table has no storage descriptor")
+	}
+
+	keys := make([]string, 0, len(res.Value.PartitionKeys))
+	for _, k := range res.Value.PartitionKeys {
+		keys = append(keys, k.Name)
+	}
+
+	h.sd = res.Value.Sd
+	return keys, h.sd.Location, nil
+}
+
+// AddPartition adds a partition to the table, with the storage descriptor of the table, unless it exists.
+func (h *Hive) AddPartition(values []string, location string) error {
+	sd := *h.sd
+	sd.Location = location
+	now := int32(time.Now().Unix())
+
+	var res hiveAddPartitionsResponse
+	if err := h.call("add_partitions_req", &hiveAddPartitionsRequest{Request: &hiveAddPartitions{
+		DbName:      h.database,
+		TblName:     h.table,
+		IfNotExists: true,
+		Parts: []*hivePartition{{
+			Values:         values,
+			DbName:         h.database,
+			TableName:      h.table,
+			CreateTime:     now,
+			LastAccessTime: now,
+			Sd:             &sd,
+			Parameters:     map[string]string{},
+		}},
+	}}, &res); err != nil {
+		return err
+	}
+
+	switch {
+	case res.O1 != nil:
+		return res.O1
+	case res.O3 != nil:
+		return res.O3
+	}
+	return nil // An existing partition is ignored
+}
+
+// call calls a method of the metastore on a new connection
+func (h *Hive) call(method string, req, res interface{}) error {
+	conn, err := net.DialTimeout("tcp", h.address, h.timeout)
+	if err != nil {
+		return errors.Internal("hive: unable to connect to the metastore", err)
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(h.timeout)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	client := thrift.NewClient(thrift.NewTransport(conn, thrift.BinaryProtocol), false)
+	defer client.Close()
+	if err := client.Call(method, req, res); err != nil {
+		return errors.Internal("hive: unable to call "+method, err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// hiveFieldSchema represents a column of a Hive table
+type hiveFieldSchema struct {
+	Name    string `thrift:"1"`
+	Type    string `thrift:"2"`
+	Comment string `thrift:"3"`
+}
+
+// hiveSerDeInfo represents the serialization of a Hive table
+type hiveSerDeInfo struct {
+	Name             string            `thrift:"1"`
+	SerializationLib string            `thrift:"2"`
+	Parameters       map[string]string `thrift:"3"`
+}
+
+// hiveOrder represents the sort order of a column
+type hiveOrder struct {
+	Col   string `thrift:"1"`
+	Order int32  `thrift:"2"`
+}
+
+// hiveStorageDescriptor represents the storage of a Hive table or partition
+type hiveStorageDescriptor struct {
+	Cols         []*hiveFieldSchema `thrift:"1"`
+	Location     string             `thrift:"2"`
+	InputFormat  string             `thrift:"3"`
+	OutputFormat string             `thrift:"4"`
+	Compressed   bool               `thrift:"5"`
+	NumBuckets   int32              `thrift:"6"`
+	SerdeInfo    *hiveSerDeInfo     `thrift:"7"`
+	BucketCols   []string           `thrift:"8"`
+	SortCols     []*hiveOrder       `thrift:"9"`
+	Parameters   map[string]string  `thrift:"10"`
+}
+
+// hiveTable represents a Hive table
+type hiveTable struct {
+	TableName     string                 `thrift:"1"`
+	DbName        string                 `thrift:"2"`
+	Sd            *hiveStorageDescriptor `thrift:"7"`
+	PartitionKeys []*hiveFieldSchema     `thrift:"8"`
+}
+
+// hivePartition represents a partition of a Hive table
+type hivePartition struct {
+	Values         []string               `thrift:"1"`
+	DbName         string                 `thrift:"2"`
+	TableName      string                 `thrift:"3"`
+	CreateTime     int32                  `thrift:"4"`
+	LastAccessTime int32                  `thrift:"5"`
+	Sd             *hiveStorageDescriptor `thrift:"6"`
+	Parameters     map[string]string      `thrift:"7,keepempty"`
+}
+
+// hiveAddPartitions represents a request adding partitions to a Hive table
+type hiveAddPartitions struct {
+	DbName      string           `thrift:"1,required"`
+	TblName     string           `thrift:"2,required"`
+	Parts       []*hivePartition `thrift:"3,required"`
+	IfNotExists bool             `thrift:"4,required"`
+	NeedResult  bool             `thrift:"5,required"`
+}
+
+// hiveAddPartitionsResult represents the partitions added, which are not requested
+type hiveAddPartitionsResult struct{}
+
+// hiveException represents an exception thrown by the metastore
+type hiveException struct {
+	Message string `thrift:"1"`
+}
+
+func (e *hiveException) Error() string {
+	return "hive: " + e.Message
+}
+
+// hiveGetTableRequest represents the arguments of get_table
+type hiveGetTableRequest struct {
+	DbName  string `thrift:"1"`
+	TblName string `thrift:"2"`
+}
+
+// hiveGetTableResponse represents the result of get_table
+type hiveGetTableResponse struct {
+	Value *hiveTable     `thrift:"0"`
+	O1    *hiveException `thrift:"1"` // MetaException
+	O2    *hiveException `thrift:"2"` // NoSuchObjectException
+}
+
+// hiveAddPartitionsRequest represents the arguments of add_partitions_req
+type hiveAddPartitionsRequest struct {
+	Request *hiveAddPartitions `thrift:"1"`
+}
+
+// hiveAddPartitionsResponse represents the result of add_partitions_req
+type hiveAddPartitionsResponse struct {
+	Value *hiveAddPartitionsResult `thrift:"0"`
+	O1    *hiveException           `thrift:"1"` // InvalidObjectException
+	O2    *hiveException           `thrift:"2"` // AlreadyExistsException
+	O3    *hiveException           `thrift:"3"` // MetaException
+}
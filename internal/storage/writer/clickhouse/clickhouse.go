@@ -0,0 +1,326 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	ctxTag      = "clickhouse"
+	maxAttempts = 5     // The maximum number of attempts of a statement failing on a replica or a transient error
+	maxBlock    = 65536 // The maximum number of rows of a block
+	maxBatch    = 10000 // The maximum number of streamed rows inserted at once
+)
+
+// Writer represents a writer for ClickHouse, inserting the rows in batches of columnar blocks in the Native
+// format through the HTTP interface. A statement failing on a replica or a transient error is retried on the
+// next endpoint, as the replicated tables deduplicate a block which was inserted twice.
+type Writer struct {
+	lock      sync.Mutex      // The lock protecting the columns of the table
+	client    *http.Client    // The HTTP client
+	endpoints []string        // The endpoints of the replicas
+	table     string          // The name of the table, optionally qualified by its database
+	user      string          // The user, if not the default one
+	password  string          // The password of the user
+	engine    string          // The engine of the table created by the writer, if any
+	columns   map[string]bool // The columns of the table created by the writer
+	monitor   monitor.Monitor // The monitoring layer
+	backoff   time.Duration   // The delay before retrying, doubled on every attempt
+	buffer    chan block.Row  // The rows to stream
+}
+
+// New creates a new writer, inserting into the table through the endpoints of the replicas.
+func New(endpoints []string, table, user, password string, monitor monitor.Monitor) (*Writer, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("clickhouse: no endpoint was configured")
+	}
+
+	return &Writer{
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		endpoints: endpoints,
+		table:     table,
+		user:      user,
+		password:  password,
+		monitor:   monitor,
+		backoff:   time.Second,
+		buffer:    make(chan block.Row, 65000),
+	}, nil
+}
+
+// SetEngine sets the engine of the table, such as "MergeTree() ORDER BY (event, time)", in which case the
+// table is created if it does not exist and a column is added whenever the schema of the rows has a new one.
+func (w *Writer) SetEngine(engine string) {
+	w.engine = engine
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(_ key.Key, val []byte) error {
+	schema, rows, err := decode(val)
+	if err != nil {
+		return errors.Internal("clickhouse: unable to decode", err)
+	}
+
+	return w.insert(schema, rows)
+}
+
+// Stream pushes a row into the buffer, to be inserted with the rows streamed within the same second.
+func (w *Writer) Stream(row block.Row) error {
+	select {
+	case w.buffer <- row:
+	default:
+		return errors.New("clickhouse: buffer is full")
+	}
+	return nil
+}
+
+// Run inserts the rows streamed, in batches, until the context is cancelled.
+func (w *Writer) Run(ctx context.Context) (async.Task, error) {
+	return async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, w.process(ctx)
+	}), nil
+}
+
+// process reads from the buffer and inserts the rows every second or once a batch is full
+func (w *Writer) process(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := make([]block.Row, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case row := <-w.buffer:
+			if batch = append(batch, row); len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if len(batch) > 0 {
+			if err := w.flush(batch); err != nil {
+				w.monitor.Error(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush inserts a batch of streamed rows
+func (w *Writer) flush(batch []block.Row) error {
+	schema := make(typeof.Schema, 16)
+	for _, row := range batch {
+		for k, typ := range row.Schema {
+			if _, ok := schema[k]; !ok {
+				schema[k] = typ
+			}
+		}
+	}
+
+	columns := schema.Columns()
+	rows := make([][]interface{}, 0, len(batch))
+	for _, row := range batch {
+		values := make([]interface{}, len(columns))
+		for i, c := range columns {
+			values[i] = row.Values[c]
+		}
+		rows = append(rows, values)
+	}
+
+	return w.insert(schema, rows)
+}
+
+// insert inserts the rows, whose values are in the order of the columns of the schema
+func (w *Writer) insert(schema typeof.Schema, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns := schema.Columns()
+	if err := w.ensure(schema, columns); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	for start := 0; start < len(rows); start += maxBlock {
+		end := start + maxBlock
+		if end > len(rows) {
+			end = len(rows)
+		}
+		encodeBlock(&body, schema, columns, rows[start:end])
+	}
+
+	names := make([]string, 0, len(columns))
+	for _, c := range columns {
+		names = append(names, quote(c))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) FORMAT Native", w.table, strings.Join(names, ", "))
+	if err := w.exec(query, body.Bytes()); err != nil {
+		return errors.Internal("clickhouse: unable to insert", err)
+	}
+
+	w.monitor.Count(ctxTag, "insert.rows", int64(len(rows)))
+	return nil
+}
+
+// ensure creates the table, or adds the columns it is missing, if the writer manages the table
+func (w *Writer) ensure(schema typeof.Schema, columns []string) error {
+	if w.engine == "" {
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.columns == nil {
+		definitions := make([]string, 0, len(columns))
+		for _, c := range columns {
+			definitions = append(definitions, quote(c)+" "+typeOf(schema[c]))
+		}
+
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = %s", w.table, strings.Join(definitions, ", "), w.engine)
+		if err := w.exec(query, nil); err != nil {
+			return errors.Internal("clickhouse: unable to create the table", err)
+		}
+
+		w.columns = make(map[string]bool, len(columns))
+		for _, c := range columns {
+			w.columns[c] = true
+		}
+	}
+
+	for _, c := range columns {
+		if w.columns[c] {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", w.table, quote(c), typeOf(schema[c]))
+		if err := w.exec(query, nil); err != nil {
+			return errors.Internal("clickhouse: unable to add the column "+c, err)
+		}
+		w.columns[c] = true
+	}
+	return nil
+}
+
+// exec executes a statement along with its data, trying the next endpoint with an exponential backoff while
+// it fails on a replica or a transient error
+func (w *Writer) exec(query string, data []byte) (err error) {
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		endpoint := w.endpoints[attempt%len(w.endpoints)]
+		if err = w.post(endpoint, query, data); err == nil || !retryable(err) || attempt+1 == maxAttempts {
+			return err
+		}
+
+		w.monitor.Count1(ctxTag, "retry")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends a statement to an endpoint
+func (w *Writer) post(endpoint, query string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/?query="+url.QueryEscape(query), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if w.user != "" {
+		req.Header.Set("X-ClickHouse-User", w.user)
+		req.Header.Set("X-ClickHouse-Key", w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &serverError{message: err.Error()}
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	code, _ := strconv.Atoi(resp.Header.Get("X-ClickHouse-Exception-Code"))
+	return &serverError{
+		status:  resp.StatusCode,
+		code:    code,
+		message: strings.TrimSpace(string(body)),
+	}
+}
+
+// serverError represents a statement which failed, either on the server or on the network
+type serverError struct {
+	status  int    // The HTTP status of the response, zero if none was received
+	code    int    // The code of the exception, if any
+	message string // The message of the exception
+}
+
+// Error returns the message of the error
+func (e *serverError) Error() string {
+	return e.message
+}
+
+// retryable returns whether a statement failed on a replica, such as a replica in read-only mode or which lost
+// its connection to ZooKeeper, or on a transient error
+func retryable(err error) bool {
+	e, ok := err.(*serverError)
+	if !ok {
+		return false
+	}
+
+	switch e.code {
+	case 159, // TIMEOUT_EXCEEDED
+		202, // TOO_MANY_SIMULTANEOUS_QUERIES
+		209, // SOCKET_TIMEOUT
+		210, // NETWORK_ERROR
+		225, // NO_ZOOKEEPER
+		242, // TABLE_IS_READ_ONLY
+		252, // TOO_MANY_PARTS
+		319, // UNKNOWN_STATUS_OF_INSERT
+		999: // KEEPER_EXCEPTION
+		return true
+	case 0:
+		return e.status == 0 || e.status >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// decode decodes the rows of an ORC file
+func decode(val []byte) (typeof.Schema, [][]interface{}, error) {
+	i, err := orc.FromBuffer(val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer i.Close()
+	schema := i.Schema()
+
+	var rows [][]interface{}
+	i.Range(func(_ int, values []interface{}) bool {
+		rows = append(rows, values)
+		return false
+	}, schema.Columns()...)
+	return schema, rows, nil
+}
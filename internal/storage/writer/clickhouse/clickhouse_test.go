@@ -0,0 +1,169 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	eorc "github.com/crphang/orc"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// replica represents a ClickHouse replica, failing with an exception code while broken
+type replica struct {
+	*httptest.Server
+	queries []string
+	blocks  []map[string][]interface{}
+	broken  string
+}
+
+func newReplica(t *testing.T) *replica {
+	r := new(replica)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.broken != "" {
+			w.Header().Set("X-ClickHouse-Exception-Code", r.broken)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		r.queries = append(r.queries, req.URL.Query().Get("query"))
+		if len(body) > 0 {
+			r.blocks = append(r.blocks, decodeBlock(t, bufio.NewReader(bytes.NewReader(body))))
+		}
+	}))
+	return r
+}
+
+func TestWrite(t *testing.T) {
+	schema := typeof.Schema{
+		"event": typeof.String,
+		"count": typeof.Int64,
+		"ratio": typeof.Float64,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := eorc.NewWriter(buffer, eorc.SetSchema(orcSchema))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(int64(1), "click", 0.5))
+	assert.NoError(t, writer.Write(int64(2), "view", 1.5))
+	assert.NoError(t, writer.Close())
+
+	// The first replica is read-only, so the insert is retried on the second one
+	r1, r2 := newReplica(t), newReplica(t)
+	defer r1.Close()
+	defer r2.Close()
+	r1.broken = "242"
+
+	w, err := New([]string{r1.URL, r2.URL}, "db.events", "", "", monitor.NewNoop())
+	assert.NoError(t, err)
+	w.backoff = 0
+
+	assert.NoError(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+	assert.Empty(t, r1.queries)
+	assert.Equal(t, []string{"INSERT INTO db.events (`count`, `event`, `ratio`) FORMAT Native"}, r2.queries)
+	assert.Equal(t, []map[string][]interface{}{{
+		"count Int64":   {int64(1), int64(2)},
+		"event String":  {"click", "view"},
+		"ratio Float64": {0.5, 1.5},
+	}}, r2.blocks)
+
+	// An error which is not caused by the replica is not retried
+	r1.broken, r2.broken = "62", "62"
+	assert.Error(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+}
+
+func TestStream(t *testing.T) {
+	r := newReplica(t)
+	defer r.Close()
+
+	w, err := New([]string{r.URL}, "events", "", "", monitor.NewNoop())
+	assert.NoError(t, err)
+	w.SetEngine("MergeTree() ORDER BY event")
+
+	ts := time.Unix(1600000000, 0)
+	assert.NoError(t, w.flush([]block.Row{
+		{Values: map[string]interface{}{"event": "click", "time": ts}, Schema: typeof.Schema{"event": typeof.String, "time": typeof.Timestamp}},
+		{Values: map[string]interface{}{"event": "view"}, Schema: typeof.Schema{"event": typeof.String}},
+	}))
+
+	// The table is altered when a new column is streamed
+	assert.NoError(t, w.flush([]block.Row{
+		{Values: map[string]interface{}{"event": "click", "valid": true}, Schema: typeof.Schema{"event": typeof.String, "valid": typeof.Bool}},
+	}))
+
+	assert.Equal(t, []string{
+		"CREATE TABLE IF NOT EXISTS events (`event` String, `time` DateTime64(6)) ENGINE = MergeTree() ORDER BY event",
+		"INSERT INTO events (`event`, `time`) FORMAT Native",
+		"ALTER TABLE events ADD COLUMN IF NOT EXISTS `valid` UInt8",
+		"INSERT INTO events (`event`, `valid`) FORMAT Native",
+	}, r.queries)
+	assert.Equal(t, []map[string][]interface{}{{
+		"event String":       {"click", "view"},
+		"time DateTime64(6)": {ts.UnixNano() / 1000, int64(0)},
+	}, {
+		"event String": {"click"},
+		"valid UInt8":  {uint8(1)},
+	}}, r.blocks)
+}
+
+// decodeBlock decodes a block in the Native format, keyed by the name and the type of the columns
+func decodeBlock(t *testing.T, r *bufio.Reader) map[string][]interface{} {
+	columns, err := binary.ReadUvarint(r)
+	assert.NoError(t, err)
+	rows, err := binary.ReadUvarint(r)
+	assert.NoError(t, err)
+
+	out := make(map[string][]interface{})
+	for i := 0; i < int(columns); i++ {
+		name, typ := readString(t, r), readString(t, r)
+		var values []interface{}
+		for j := 0; j < int(rows); j++ {
+			var b [8]byte
+			switch typ {
+			case "Int64", "DateTime64(6)":
+				_, err = io.ReadFull(r, b[:])
+				values = append(values, int64(binary.LittleEndian.Uint64(b[:])))
+			case "Float64":
+				_, err = io.ReadFull(r, b[:])
+				values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(b[:])))
+			case "UInt8":
+				_, err = io.ReadFull(r, b[:1])
+				values = append(values, b[0])
+			default:
+				values = append(values, readString(t, r))
+			}
+			assert.NoError(t, err)
+		}
+		out[name+" "+typ] = values
+	}
+	return out
+}
+
+// readString reads a string prefixed by its length
+func readString(t *testing.T, r *bufio.Reader) string {
+	n, err := binary.ReadUvarint(r)
+	assert.NoError(t, err)
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	assert.NoError(t, err)
+	return string(b)
+}
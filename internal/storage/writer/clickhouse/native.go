@@ -0,0 +1,131 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+)
+
+// typeOf returns the ClickHouse type of a column. The columns are not nullable, as recommended by ClickHouse,
+// so the null values are written as the default value of their type.
+func typeOf(typ typeof.Type) string {
+	switch typ {
+	case typeof.Int32:
+		return "Int32"
+	case typeof.Int64:
+		return "Int64"
+	case typeof.Float64:
+		return "Float64"
+	case typeof.Bool:
+		return "UInt8"
+	case typeof.Timestamp:
+		return "DateTime64(6)"
+	default:
+		return "String"
+	}
+}
+
+// quote quotes an identifier, such as the name of a column
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// encodeBlock appends a block of rows in the Native format, in which the values are laid out column by column
+func encodeBlock(buffer *bytes.Buffer, schema typeof.Schema, columns []string, rows [][]interface{}) {
+	writeUvarint(buffer, uint64(len(columns)))
+	writeUvarint(buffer, uint64(len(rows)))
+	for i, name := range columns {
+		typ := schema[name]
+		writeString(buffer, name)
+		writeString(buffer, typeOf(typ))
+		for _, row := range rows {
+			writeValue(buffer, typ, row[i])
+		}
+	}
+}
+
+// writeValue writes a single value of a column
+func writeValue(buffer *bytes.Buffer, typ typeof.Type, value interface{}) {
+	var b [8]byte
+	switch typ {
+	case typeof.Int32:
+		v, _ := intOf(value)
+		binary.LittleEndian.PutUint32(b[:4], uint32(v))
+		buffer.Write(b[:4])
+	case typeof.Int64:
+		v, _ := intOf(value)
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		buffer.Write(b[:])
+	case typeof.Timestamp:
+		var v int64
+		if t, ok := value.(time.Time); ok {
+			v = t.UnixNano() / int64(time.Microsecond)
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		buffer.Write(b[:])
+	case typeof.Float64:
+		v, _ := floatOf(value)
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buffer.Write(b[:])
+	case typeof.Bool:
+		if v, _ := value.(bool); v {
+			buffer.WriteByte(1)
+		} else {
+			buffer.WriteByte(0)
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			writeString(buffer, v)
+		case json.RawMessage:
+			writeString(buffer, string(v))
+		case nil:
+			writeString(buffer, "")
+		default:
+			encoded, _ := json.Marshal(v)
+			writeString(buffer, string(encoded))
+		}
+	}
+}
+
+// writeString writes a string prefixed by its length
+func writeString(buffer *bytes.Buffer, v string) {
+	writeUvarint(buffer, uint64(len(v)))
+	buffer.WriteString(v)
+}
+
+// writeUvarint writes an unsigned integer, with a variable length
+func writeUvarint(buffer *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buffer.Write(b[:n])
+}
+
+// intOf converts an integer, including the ones of the named types of the ORC decoder
+func intOf(value interface{}) (int64, bool) {
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	default:
+		return 0, false
+	}
+}
+
+// floatOf converts a floating-point number, including the ones of the named types of the ORC decoder
+func floatOf(value interface{}) (float64, bool) {
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
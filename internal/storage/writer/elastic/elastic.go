@@ -0,0 +1,320 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	ctxTag         = "elastic"
+	maxAttempts    = 5       // The maximum number of attempts of a request rejected or failing on a transient error
+	maxRequestSize = 5 << 20 // The maximum size of the documents indexed by a single bulk request
+	maxBatch       = 5000    // The maximum number of streamed rows indexed at once
+)
+
+// document represents a document to index
+type document struct {
+	action []byte // The action of the bulk request, with the index and the identifier of the document
+	source []byte // The document itself
+}
+
+// Writer represents a writer for Elasticsearch or OpenSearch, indexing the rows with bulk requests. The rows of
+// a file are indexed with an identifier derived from the name of the file, so a file which is written again
+// after a failure overwrites the documents it already indexed.
+type Writer struct {
+	lock      sync.Mutex      // The lock protecting the index template
+	client    *http.Client    // The HTTP client
+	endpoints []string        // The endpoints of the nodes
+	index     *indexName      // The name of the index of a row
+	user      string          // The user, if any
+	password  string          // The password of the user
+	template  string          // The index template, if any
+	templated bool            // Whether the index template was put
+	monitor   monitor.Monitor // The monitoring layer
+	backoff   time.Duration   // The delay before retrying, doubled on every attempt
+	buffer    chan block.Row  // The rows to stream
+}
+
+// New creates a new writer, indexing the rows in the index named after the pattern, which can contain a time
+// layout between braces, such as "eventlog-{2006.01.02}", formatted with the time of the row in the column.
+func New(endpoints []string, index, timeColumn, user, password string, monitor monitor.Monitor) (*Writer, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("elastic: no endpoint was configured")
+	}
+
+	name, err := newIndexName(index, timeColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		client:    &http.Client{Timeout: time.Minute},
+		endpoints: endpoints,
+		index:     name,
+		user:      user,
+		password:  password,
+		monitor:   monitor,
+		backoff:   time.Second,
+		buffer:    make(chan block.Row, 65000),
+	}, nil
+}
+
+// SetTemplate sets the index template, in JSON, which is put before the first documents are indexed so that
+// the indices created for every period share their settings and mappings.
+func (w *Writer) SetTemplate(template string) {
+	w.template = template
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	i, err := orc.FromBuffer(val)
+	if err != nil {
+		return errors.Internal("elastic: unable to decode", err)
+	}
+
+	defer i.Close()
+	columns := i.Schema().Columns()
+
+	var docs []document
+	i.Range(func(idx int, values []interface{}) bool {
+		row := make(map[string]interface{}, len(columns))
+		for j, c := range columns {
+			row[c] = values[j]
+		}
+
+		docs = append(docs, w.documentOf(row, fmt.Sprintf("%s-%d", key, idx)))
+		return false
+	}, columns...)
+	return w.bulk(docs)
+}
+
+// Stream pushes a row into the buffer, to be indexed with the rows streamed within the same second.
+func (w *Writer) Stream(row block.Row) error {
+	select {
+	case w.buffer <- row:
+	default:
+		return errors.New("elastic: buffer is full")
+	}
+	return nil
+}
+
+// Run indexes the rows streamed, in batches, until the context is cancelled.
+func (w *Writer) Run(ctx context.Context) (async.Task, error) {
+	return async.Invoke(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, w.process(ctx)
+	}), nil
+}
+
+// process reads from the buffer and indexes the rows every second or once a batch is full
+func (w *Writer) process(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := make([]document, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case row := <-w.buffer:
+			if batch = append(batch, w.documentOf(row.Values, "")); len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if len(batch) > 0 {
+			if err := w.bulk(batch); err != nil {
+				w.monitor.Error(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// documentOf encodes a row as a document, with an identifier generated by the cluster if none is given
+func (w *Writer) documentOf(row map[string]interface{}, id string) document {
+	meta := map[string]string{"_index": w.index.Of(row)}
+	if id != "" {
+		meta["_id"] = id
+	}
+
+	action, _ := json.Marshal(map[string]interface{}{"index": meta})
+	source, err := json.Marshal(row)
+	if err != nil {
+		source = []byte("{}")
+	}
+
+	return document{action: action, source: source}
+}
+
+// bulk indexes the documents in as many bulk requests as required by their size
+func (w *Writer) bulk(docs []document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	if err := w.putTemplate(); err != nil {
+		return err
+	}
+
+	start, size := 0, 0
+	for i, doc := range docs {
+		if n := len(doc.action) + len(doc.source) + 2; size+n > maxRequestSize && i > start {
+			if err := w.send(docs[start:i]); err != nil {
+				return err
+			}
+			start, size = i, 0
+		}
+		size += len(doc.action) + len(doc.source) + 2
+	}
+	return w.send(docs[start:])
+}
+
+// send indexes the documents with a single bulk request, retrying the documents which were rejected
+// because the cluster is overloaded or failed on a transient error
+func (w *Writer) send(docs []document) error {
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		var body bytes.Buffer
+		for _, doc := range docs {
+			body.Write(doc.action)
+			body.WriteByte('\n')
+			body.Write(doc.source)
+			body.WriteByte('\n')
+		}
+
+		status, resp, err := w.do(w.endpoints[attempt%len(w.endpoints)], http.MethodPost, "/_bulk", body.Bytes())
+		switch {
+		case err != nil:
+		case status == http.StatusOK:
+			rejected, rerr := rejectedOf(docs, resp)
+			if rerr != nil {
+				return rerr
+			}
+
+			w.monitor.Count(ctxTag, "index.docs", int64(len(docs)-len(rejected)))
+			if len(rejected) == 0 {
+				return nil
+			}
+
+			docs = rejected
+			err = errors.Newf("%d documents were rejected", len(rejected))
+		case status == http.StatusTooManyRequests || status >= http.StatusInternalServerError:
+			err = errors.New(string(resp))
+		default:
+			return errors.Newf("elastic: unable to index, %s", resp)
+		}
+
+		if attempt+1 == maxAttempts {
+			return errors.Internal("elastic: unable to index", err)
+		}
+
+		w.monitor.Count1(ctxTag, "retry")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// rejectedOf returns the documents rejected because the cluster is overloaded or which failed on a transient
+// error, or an error if any other document failed
+func rejectedOf(docs []document, resp []byte) ([]document, error) {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, errors.Internal("elastic: unable to decode the response", err)
+	}
+
+	if !result.Errors {
+		return nil, nil
+	}
+
+	var rejected []document
+	for i, item := range result.Items {
+		for _, r := range item {
+			switch {
+			case (r.Status == http.StatusTooManyRequests || r.Status >= http.StatusInternalServerError) && i < len(docs):
+				rejected = append(rejected, docs[i])
+			case r.Status >= 300:
+				return nil, errors.Newf("elastic: unable to index a document, %s", r.Error)
+			}
+		}
+	}
+	return rejected, nil
+}
+
+// putTemplate puts the index template, if any, before the first documents are indexed
+func (w *Writer) putTemplate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.template == "" || w.templated {
+		return nil
+	}
+
+	var err error
+	var status int
+	var resp []byte
+	for _, endpoint := range w.endpoints {
+		if status, resp, err = w.do(endpoint, http.MethodPut, "/_index_template/"+w.index.Prefix(), []byte(w.template)); err == nil {
+			break
+		}
+	}
+
+	switch {
+	case err != nil:
+		return errors.Internal("elastic: unable to put the index template", err)
+	case status != http.StatusOK:
+		return errors.Newf("elastic: unable to put the index template, %s", resp)
+	default:
+		w.templated = true
+		return nil
+	}
+}
+
+// do sends a request to a node
+func (w *Writer) do(endpoint, method, uri string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+uri, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if uri == "/_bulk" {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
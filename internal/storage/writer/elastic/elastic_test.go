@@ -0,0 +1,134 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	eorc "github.com/crphang/orc"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// cluster represents an Elasticsearch cluster, rejecting the first documents of a bulk request while overloaded
+type cluster struct {
+	*httptest.Server
+	templates  map[string]string
+	docs       map[string]map[string]string // The documents, per index and identifier
+	overloaded int                          // The number of documents to reject
+}
+
+func newCluster(t *testing.T) *cluster {
+	c := &cluster{templates: map[string]string{}, docs: map[string]map[string]string{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEqual(t, "", r.Header.Get("Content-Type"))
+		if strings.HasPrefix(r.URL.Path, "/_index_template/") {
+			var b bytes.Buffer
+			b.ReadFrom(r.Body)
+			c.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = b.String()
+			return
+		}
+
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action struct {
+				Index struct {
+					Index string `json:"_index"`
+					ID    string `json:"_id"`
+				} `json:"index"`
+			}
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+			assert.True(t, scanner.Scan())
+
+			if c.overloaded > 0 {
+				c.overloaded--
+				items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`)
+				continue
+			}
+
+			id := action.Index.ID
+			if id == "" {
+				id = fmt.Sprintf("auto-%d", len(c.docs[action.Index.Index]))
+			}
+
+			if c.docs[action.Index.Index] == nil {
+				c.docs[action.Index.Index] = map[string]string{}
+			}
+			c.docs[action.Index.Index][id] = scanner.Text()
+			items = append(items, `{"index":{"status":201}}`)
+		}
+
+		fmt.Fprintf(w, `{"errors":%v,"items":[%s]}`, strings.Contains(strings.Join(items, ""), "429"), strings.Join(items, ","))
+	}))
+	return c
+}
+
+func TestWrite(t *testing.T) {
+	schema := typeof.Schema{
+		"event": typeof.String,
+		"time":  typeof.Timestamp,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := eorc.NewWriter(buffer, eorc.SetSchema(orcSchema))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write("click", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)))
+	assert.NoError(t, writer.Write("view", time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)))
+	assert.NoError(t, writer.Close())
+
+	c := newCluster(t)
+	defer c.Close()
+
+	w, err := New([]string{c.URL}, "eventlog-{2006.01.02}", "time", "", "", monitor.NewNoop())
+	assert.NoError(t, err)
+	w.SetTemplate(`{"index_patterns":["eventlog-*"]}`)
+	w.backoff = 0
+
+	// The first document is rejected, and indexed again with the next attempt
+	c.overloaded = 1
+	assert.NoError(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+	assert.Equal(t, map[string]string{"eventlog": `{"index_patterns":["eventlog-*"]}`}, c.templates)
+	assert.Len(t, c.docs, 2)
+	assert.Contains(t, c.docs["eventlog-2020.01.01"]["file.orc-0"], `"event":"click"`)
+	assert.Contains(t, c.docs["eventlog-2020.01.02"]["file.orc-1"], `"event":"view"`)
+
+	// The file is written again, overwriting the same documents
+	assert.NoError(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+	assert.Len(t, c.docs["eventlog-2020.01.01"], 1)
+
+	// The documents which keep being rejected fail the write
+	c.overloaded = 2 * maxAttempts
+	assert.Error(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+}
+
+func TestIndexName(t *testing.T) {
+	n, err := newIndexName("logs-{2006.01}-v1", "ts")
+	assert.NoError(t, err)
+	n.now = func() time.Time { return time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	assert.Equal(t, "logs", n.Prefix())
+	assert.Equal(t, "logs-2020.12-v1", n.Of(map[string]interface{}{"ts": time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)}))
+	assert.Equal(t, "logs-2021.03-v1", n.Of(map[string]interface{}{}))
+
+	n, err = newIndexName("logs", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "logs", n.Of(nil))
+
+	_, err = newIndexName("logs-{2006", "")
+	assert.Error(t, err)
+}
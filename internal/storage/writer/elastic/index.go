@@ -0,0 +1,58 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package elastic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// indexName represents the name of the index of a row, which is either static or based on the time of the row
+type indexName struct {
+	prefix string           // The part of the name before the time
+	layout string           // The layout of the time, if the name is time-based
+	suffix string           // The part of the name after the time
+	column string           // The column with the time of the row, the current time being used otherwise
+	now    func() time.Time // The clock
+}
+
+// newIndexName parses the pattern of the name of the indices, such as "eventlog-{2006.01.02}"
+func newIndexName(pattern, column string) (*indexName, error) {
+	name := &indexName{prefix: pattern, column: column, now: time.Now}
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return name, nil
+	}
+
+	end := strings.IndexByte(pattern[start:], '}')
+	if end < 0 {
+		return nil, errors.Newf("elastic: the index %s has an unterminated time layout", pattern)
+	}
+
+	name.prefix = pattern[:start]
+	name.layout = pattern[start+1 : start+end]
+	name.suffix = pattern[start+end+1:]
+	return name, nil
+}
+
+// Of returns the name of the index of a row
+func (n *indexName) Of(row map[string]interface{}) string {
+	if n.layout == "" {
+		return n.prefix
+	}
+
+	t, ok := row[n.column].(time.Time)
+	if !ok {
+		t = n.now()
+	}
+
+	return n.prefix + t.UTC().Format(n.layout) + n.suffix
+}
+
+// Prefix returns the static part of the name, which names the index template
+func (n *indexName) Prefix() string {
+	return strings.Trim(n.prefix, "-_.")
+}
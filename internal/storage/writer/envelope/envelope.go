@@ -0,0 +1,148 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const keySize = 32 // The size of the master key and of the data keys, for AES-256
+
+// magic identifies the files encrypted by this writer, along with the version of the format
+var magic = []byte("TLE1")
+
+// SubWriter represents the writer of the encrypted files
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Writer represents a writer which encrypts every file before writing it to the underlying writer, using
+// envelope encryption: each file is encrypted with its own data key, which is itself encrypted with the
+// master key and stored along with the file.
+type Writer struct {
+	master cipher.AEAD // The master key, encrypting the data keys
+	dest   SubWriter   // The writer of the encrypted files
+}
+
+// New creates a new writer encrypting the files with a master key of 32 bytes, encoded in base64.
+func New(masterKey string, dest SubWriter) (*Writer, error) {
+	master, err := newMaster(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		master: master,
+		dest:   dest,
+	}, nil
+}
+
+// Write encrypts the file and writes it to the underlying writer.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return errors.Internal("envelope: unable to generate a data key", err)
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return errors.Internal("envelope: unable to encrypt", err)
+	}
+
+	wrapped, err := seal(w.master, dataKey)
+	if err != nil {
+		return errors.Internal("envelope: unable to encrypt the data key", err)
+	}
+
+	encrypted, err := seal(data, val)
+	if err != nil {
+		return errors.Internal("envelope: unable to encrypt", err)
+	}
+
+	// The file is laid out as: magic | length of the wrapped key | wrapped key | nonce | ciphertext
+	out := make([]byte, 0, len(magic)+2+len(wrapped)+len(encrypted))
+	out = append(out, magic...)
+	out = append(out, byte(len(wrapped)>>8), byte(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, encrypted...)
+	return w.dest.Write(key, out)
+}
+
+// Decrypt decrypts a file written by the writer, with the master key of 32 bytes encoded in base64.
+func Decrypt(masterKey string, file []byte) ([]byte, error) {
+	master, err := newMaster(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(file) < len(magic)+2 || !bytes.Equal(file[:len(magic)], magic) {
+		return nil, errors.New("envelope: the file is not encrypted")
+	}
+
+	file = file[len(magic):]
+	size := int(binary.BigEndian.Uint16(file))
+	if len(file) < 2+size {
+		return nil, errors.New("envelope: the file is truncated")
+	}
+
+	dataKey, err := open(master, file[2:2+size])
+	if err != nil {
+		return nil, errors.Internal("envelope: unable to decrypt the data key", err)
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, errors.Internal("envelope: unable to decrypt", err)
+	}
+
+	out, err := open(data, file[2+size:])
+	if err != nil {
+		return nil, errors.Internal("envelope: unable to decrypt", err)
+	}
+	return out, nil
+}
+
+// newMaster decodes the master key
+func newMaster(masterKey string) (cipher.AEAD, error) {
+	b, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil || len(b) != keySize {
+		return nil, errors.New("envelope: the master key must be 32 bytes encoded in base64")
+	}
+	return newAEAD(b)
+}
+
+// newAEAD creates an AES-256-GCM cipher
+func newAEAD(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, which prefixes the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a ciphertext prefixed by its nonce
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("envelope: the ciphertext is truncated")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
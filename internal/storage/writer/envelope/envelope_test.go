@@ -0,0 +1,64 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package envelope
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKey  = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	otherKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+// memoryWriter records the last file written
+type memoryWriter struct {
+	key  key.Key
+	file []byte
+}
+
+func (w *memoryWriter) Write(k key.Key, v []byte) error {
+	w.key, w.file = k, v
+	return nil
+}
+
+func TestEnvelope(t *testing.T) {
+	dest := new(memoryWriter)
+	w, err := New(testKey, dest)
+	assert.NoError(t, err)
+
+	plaintext := []byte("year=2020/month=1/day=1/events.orc")
+	assert.NoError(t, w.Write(key.Key("events.orc"), plaintext))
+	assert.Equal(t, key.Key("events.orc"), dest.key)
+	assert.False(t, bytes.Contains(dest.file, plaintext))
+
+	// Every file is encrypted with its own data key
+	first := dest.file
+	assert.NoError(t, w.Write(key.Key("events.orc"), plaintext))
+	assert.NotEqual(t, first, dest.file)
+
+	out, err := Decrypt(testKey, first)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, out)
+
+	// The file can not be decrypted with another key, nor once tampered with
+	_, err = Decrypt(otherKey, first)
+	assert.Error(t, err)
+	first[len(first)-1] ^= 0xff
+	_, err = Decrypt(testKey, first)
+	assert.Error(t, err)
+	_, err = Decrypt(testKey, plaintext)
+	assert.Error(t, err)
+}
+
+func TestEnvelope_InvalidKey(t *testing.T) {
+	_, err := New("c2hvcnQ=", new(memoryWriter))
+	assert.Error(t, err)
+	_, err = New("not base64", new(memoryWriter))
+	assert.Error(t, err)
+}
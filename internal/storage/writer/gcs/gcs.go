@@ -15,6 +15,8 @@ type Writer struct {
 	prefix  string
 	client  *storage.BucketHandle
 	context context.Context
+	kmsKey  string // The Cloud KMS key encrypting the objects (optional)
+	acl     string // The predefined ACL of the objects, such as "bucketOwnerFullControl" (optional)
 }
 
 // New creates a new writer.
@@ -34,12 +36,24 @@ func New(bucket, prefix string) (*Writer, error) {
 	}, nil
 }
 
+// SetKMSKey sets the name of the Cloud KMS key encrypting the objects on the server side.
+func (w *Writer) SetKMSKey(kmsKey string) {
+	w.kmsKey = kmsKey
+}
+
+// SetACL sets the predefined ACL of the objects, such as "bucketOwnerFullControl".
+func (w *Writer) SetACL(acl string) {
+	w.acl = acl
+}
+
 // Write writes the data to the sink.
 func (w *Writer) Write(key key.Key, val []byte) error {
 	obj := w.client.Object(path.Join(w.prefix, string(key)))
 
 	/// Write the payload
 	writer := obj.NewWriter(w.context)
+	writer.KMSKeyName = w.kmsKey
+	writer.PredefinedACL = w.acl
 	_, err := writer.Write(val)
 	if err != nil {
 		return errors.Internal("gcs: unable to write", err)
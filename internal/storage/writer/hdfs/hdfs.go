@@ -0,0 +1,223 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package hdfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const temporaryDir = "_temporary" // The directory of the files being written, ignored by Spark and Hive
+
+// Writer represents a writer for HDFS, through the WebHDFS REST API of the name nodes or through an HttpFS
+// gateway. Each file is written in a temporary directory and then renamed, so the external tables reading
+// the directory never see a partially written file. With several name nodes in high availability, a request
+// failing on the standby one is retried on the next.
+type Writer struct {
+	client    *http.Client
+	endpoints []string  // The endpoints of the name nodes or gateways, such as "http://namenode-1:9870"
+	current   int32     // The index of the endpoint of the active name node
+	directory string    // The directory of the files
+	user      string    // The user, with the simple authentication
+	kerberos  *Kerberos // The SPNEGO authentication, if any
+}
+
+// New creates a new writer for the directory, through the endpoints of the name nodes.
+func New(endpoints []string, directory string) (*Writer, error) {
+	if len(endpoints) == 0 || directory == "" {
+		return nil, errors.New("hdfs: endpoints and a directory are required")
+	}
+
+	// Keep the cookie of the authentication, so a SPNEGO token is only sent once in a while
+	jar, _ := cookiejar.New(nil)
+	trimmed := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		trimmed = append(trimmed, strings.TrimSuffix(endpoint, "/"))
+	}
+
+	return &Writer{
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+			Jar:     jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse // The redirection to the data node is followed with the data
+			},
+		},
+		endpoints: trimmed,
+		directory: "/" + strings.Trim(directory, "/"),
+	}, nil
+}
+
+// SetUser sets the user of the requests, with the simple authentication.
+func (w *Writer) SetUser(user string) {
+	w.user = user
+}
+
+// SetKerberos sets the SPNEGO authentication of the requests.
+func (w *Writer) SetKerberos(kerberos *Kerberos) {
+	w.kerberos = kerberos
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	name := path.Join(w.directory, string(key))
+	temp := path.Join(w.directory, temporaryDir, string(key))
+
+	// Create the temporary file, replacing any file left by a previous attempt, on the data node it redirects to
+	res, err := w.do(http.MethodPut, temp, url.Values{"op": {"CREATE"}, "overwrite": {"true"}}, nil)
+	if err != nil {
+		return errors.Internal("hdfs: unable to create "+temp, err)
+	}
+
+	if location := res.Header.Get("Location"); location != "" {
+		if _, err := w.send(http.MethodPut, location, val); err != nil {
+			return errors.Internal("hdfs: unable to write "+temp, err)
+		}
+	}
+
+	// Replace the file with the temporary one
+	if _, err := w.do(http.MethodPut, path.Dir(name), url.Values{"op": {"MKDIRS"}}, nil); err != nil {
+		return errors.Internal("hdfs: unable to create the directory of "+name, err)
+	}
+	if _, err := w.do(http.MethodDelete, name, url.Values{"op": {"DELETE"}}, nil); err != nil {
+		return errors.Internal("hdfs: unable to delete "+name, err)
+	}
+
+	res, err = w.do(http.MethodPut, temp, url.Values{"op": {"RENAME"}, "destination": {name}}, nil)
+	if err != nil {
+		return errors.Internal("hdfs: unable to rename "+temp, err)
+	}
+
+	var renamed struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := json.Unmarshal(res.Body, &renamed); err != nil || !renamed.Boolean {
+		return errors.New("hdfs: unable to rename " + temp)
+	}
+	return nil
+}
+
+// response represents a response of the API
+type response struct {
+	Header http.Header
+	Body   []byte
+}
+
+// do executes an operation on a path, on the endpoint of the active name node
+func (w *Writer) do(method, name string, query url.Values, body []byte) (*response, error) {
+	if w.user != "" && w.kerberos == nil {
+		query.Set("user.name", w.user)
+	}
+
+	var err error
+	for i := 0; i < len(w.endpoints); i++ {
+		current := int(atomic.LoadInt32(&w.current))
+		uri := w.endpoints[current] + "/webhdfs/v1" + (&url.URL{Path: name}).EscapedPath() + "?" + query.Encode()
+
+		var res *response
+		if res, err = w.send(method, uri, body); err == nil {
+			return res, nil
+		}
+
+		// Move on to the next name node, unless another request already did
+		if _, ok := err.(*standbyError); !ok && !isNetworkError(err) {
+			return nil, err
+		}
+		atomic.CompareAndSwapInt32(&w.current, int32(current), int32((current+1)%len(w.endpoints)))
+	}
+	return nil, err
+}
+
+// send sends a request, authenticated with a SPNEGO token if the server asks for it
+func (w *Writer) send(method, uri string, body []byte) (*response, error) {
+	res, err := w.request(method, uri, body, "")
+	if err != nil || res.StatusCode != http.StatusUnauthorized || w.kerberos == nil {
+		return w.decode(res, err)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := w.kerberos.Token(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	return w.decode(w.request(method, uri, body, "Negotiate "+base64.StdEncoding.EncodeToString(token)))
+}
+
+// request sends a request and reads its response
+func (w *Writer) request(method, uri string, body []byte, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return w.client.Do(req)
+}
+
+// decode reads the response, returning the remote exception of an error
+func (w *Writer) decode(res *http.Response, err error) (*response, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		var remote struct {
+			Exception struct {
+				Exception string `json:"exception"`
+				Message   string `json:"message"`
+			} `json:"RemoteException"`
+		}
+		_ = json.Unmarshal(body, &remote)
+		if remote.Exception.Exception == "StandbyException" {
+			return nil, &standbyError{message: remote.Exception.Message}
+		}
+
+		return nil, fmt.Errorf("%s %s: %s", res.Status, remote.Exception.Exception, remote.Exception.Message)
+	}
+
+	return &response{Header: res.Header, Body: body}, nil
+}
+
+// standbyError represents a request rejected by a standby name node
+type standbyError struct {
+	message string
+}
+
+func (e *standbyError) Error() string {
+	return "hdfs: the name node is in standby, " + e.message
+}
+
+// isNetworkError returns whether the error is caused by an unreachable endpoint
+func isNetworkError(err error) bool {
+	_, ok := err.(*url.Error)
+	return ok
+}
@@ -0,0 +1,219 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package hdfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/stretchr/testify/assert"
+)
+
+// namenode represents a name node of WebHDFS, redirecting the writes to itself as a data node
+type namenode struct {
+	*httptest.Server
+	sync.Mutex
+	standby    bool
+	keytab     *keytab.Keytab
+	negotiated int
+	files      map[string][]byte
+	requests   []string
+}
+
+func newNamenode() *namenode {
+	n := &namenode{files: map[string][]byte{}}
+	n.Server = httptest.NewServer(http.HandlerFunc(n.serve))
+	return n
+}
+
+func (n *namenode) serve(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.standby {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"RemoteException":{"exception":"StandbyException","message":"Operation category WRITE is not supported in state standby"}}`))
+		return
+	}
+
+	// Authenticate with SPNEGO, then with the cookie
+	if n.keytab != nil {
+		if _, err := r.Cookie("hadoop.auth"); err != nil {
+			authenticated := false
+			spnego.SPNEGOKRB5Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				authenticated = true
+			}), n.keytab).ServeHTTP(httptest.NewRecorder(), r)
+			if !authenticated {
+				w.Header().Set("WWW-Authenticate", "Negotiate")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			n.negotiated++
+			http.SetCookie(w, &http.Cookie{Name: "hadoop.auth", Value: "u=talaria", Path: "/"})
+		}
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/webhdfs/v1")
+	query := r.URL.Query()
+	n.requests = append(n.requests, r.Method+" "+query.Get("op")+" "+name)
+	switch query.Get("op") {
+	case "CREATE":
+		if query.Get("data") != "true" {
+			query.Set("data", "true")
+			w.Header().Set("Location", n.URL+r.URL.Path+"?"+query.Encode())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		n.files[name], _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case "MKDIRS":
+		_, _ = w.Write([]byte(`{"boolean":true}`))
+	case "DELETE":
+		_, ok := n.files[name]
+		delete(n.files, name)
+		_, _ = w.Write([]byte(`{"boolean":` + map[bool]string{true: "true", false: "false"}[ok] + `}`))
+	case "RENAME":
+		data, ok := n.files[name]
+		if ok {
+			n.files[query.Get("destination")] = data
+			delete(n.files, name)
+		}
+		_, _ = w.Write([]byte(`{"boolean":` + map[bool]string{true: "true", false: "false"}[ok] + `}`))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	standby, active := newNamenode(), newNamenode()
+	defer standby.Close()
+	defer active.Close()
+	standby.standby = true
+
+	w, err := New([]string{standby.URL, active.URL + "/"}, "/data/eventlog/")
+	assert.NoError(t, err)
+	w.SetUser("talaria")
+
+	// The write fails over to the active name node, which is then used directly
+	assert.NoError(t, w.Write(key.Key("dt=2020-01-01/a.orc"), []byte("hello")))
+	assert.NoError(t, w.Write(key.Key("dt=2020-01-01/a.orc"), []byte("world")))
+	assert.Equal(t, map[string][]byte{"/data/eventlog/dt=2020-01-01/a.orc": []byte("world")}, active.files)
+	assert.Equal(t, []string{
+		"PUT CREATE /data/eventlog/_temporary/dt=2020-01-01/a.orc",
+		"PUT CREATE /data/eventlog/_temporary/dt=2020-01-01/a.orc",
+		"PUT MKDIRS /data/eventlog/dt=2020-01-01",
+		"DELETE DELETE /data/eventlog/dt=2020-01-01/a.orc",
+		"PUT RENAME /data/eventlog/_temporary/dt=2020-01-01/a.orc",
+	}, active.requests[:5])
+
+	// Every name node is in standby
+	active.standby = true
+	assert.Error(t, w.Write(key.Key("b.orc"), []byte("hello")))
+}
+
+func TestWrite_Kerberos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krb5")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The KDC is unreachable, so the tickets must be in the credential cache
+	krb5conf := filepath.Join(dir, "krb5.conf")
+	assert.NoError(t, ioutil.WriteFile(krb5conf, []byte(`[libdefaults]
+  default_realm = EXAMPLE.COM
+  dns_lookup_kdc = false
+[realms]
+  EXAMPLE.COM = {
+    kdc = 127.0.0.1:1
+  }
+`), 0600))
+
+	n := newNamenode()
+	defer n.Close()
+	cache := filepath.Join(dir, "krb5cc")
+	n.keytab = newCache(t, cache, time.Now().Add(time.Hour))
+
+	// The token is verified by the name node with its keytab, then the cookie is used
+	w, err := New([]string{n.URL}, "/data")
+	assert.NoError(t, err)
+	w.SetKerberos(NewKerberos("FILE:"+cache, krb5conf, ""))
+	assert.NoError(t, w.Write(key.Key("a.orc"), []byte("hello")))
+	assert.Equal(t, map[string][]byte{"/data/a.orc": []byte("hello")}, n.files)
+	assert.Equal(t, 1, n.negotiated)
+
+	// An expired ticket is not used
+	newCache(t, cache, time.Now().Add(-time.Hour))
+	_, err = NewKerberos(cache, krb5conf, "HTTP").Token("127.0.0.1")
+	assert.Error(t, err)
+
+	// A cache which does not exist is reported
+	_, err = NewKerberos(filepath.Join(dir, "missing"), krb5conf, "HTTP").Token("127.0.0.1")
+	assert.Error(t, err)
+}
+
+// newCache writes a credential cache, in the version 4 of its format, with a ticket-granting ticket and a
+// service ticket for HTTP/127.0.0.1, and returns the keytab of the services which issued them
+func newCache(t *testing.T, path string, expires time.Time) *keytab.Keytab {
+	now := time.Now().Add(-2 * time.Hour)
+	kt := keytab.New()
+	assert.NoError(t, kt.AddEntry("krbtgt/EXAMPLE.COM", "EXAMPLE.COM", "secret", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	assert.NoError(t, kt.AddEntry("HTTP/127.0.0.1", "EXAMPLE.COM", "secret", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+
+	var b bytes.Buffer
+	u16 := func(v uint16) { _ = binary.Write(&b, binary.BigEndian, v) }
+	u32 := func(v uint32) { _ = binary.Write(&b, binary.BigEndian, v) }
+	data := func(v []byte) { u32(uint32(len(v))); b.Write(v) }
+	principal := func(name types.PrincipalName) {
+		u32(uint32(name.NameType))
+		u32(uint32(len(name.NameString)))
+		data([]byte("EXAMPLE.COM"))
+		for _, c := range name.NameString {
+			data([]byte(c))
+		}
+	}
+
+	u16(0x0504)
+	u16(0) // No header
+	client := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "talaria")
+	principal(client)
+	for _, service := range []string{"krbtgt/EXAMPLE.COM", "HTTP/127.0.0.1"} {
+		server := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, service)
+		ticket, key, err := messages.NewTicket(client, "EXAMPLE.COM", server, "EXAMPLE.COM", types.NewKrbFlags(),
+			kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, expires, expires)
+		assert.NoError(t, err)
+		encoded, err := ticket.Marshal()
+		assert.NoError(t, err)
+
+		principal(client)
+		principal(server)
+		u16(uint16(key.KeyType))
+		data(key.KeyValue)
+		u32(uint32(now.Unix()))
+		u32(uint32(now.Unix()))
+		u32(uint32(expires.Unix()))
+		u32(uint32(expires.Unix()))
+		b.Write([]byte{0, 0, 0, 0, 0})
+		u32(0) // No addresses
+		u32(0) // No authorization data
+		data(encoded)
+		data(nil)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(path, b.Bytes(), 0600))
+	return kt
+}
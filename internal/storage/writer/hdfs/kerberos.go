@@ -0,0 +1,88 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package hdfs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Kerberos represents the SPNEGO authentication of the requests, using the tickets obtained beforehand into a
+// credential cache, such as with "kinit" followed by "kvno HTTP/namenode.example.com".
+type Kerberos struct {
+	cache   string // The path to the credential cache
+	config  string // The path to the configuration of Kerberos
+	service string // The service name of the principal of the endpoints, such as "HTTP"
+}
+
+// NewKerberos creates a new SPNEGO authentication from a credential cache, by default the one of the KRB5CCNAME
+// environment variable or /tmp/krb5cc_<uid>, and a configuration, by default the one of the KRB5_CONFIG
+// environment variable or /etc/krb5.conf.
+func NewKerberos(cache, krb5conf, service string) *Kerberos {
+	if cache == "" {
+		cache = os.Getenv("KRB5CCNAME")
+	}
+	if cache == "" {
+		cache = "/tmp/krb5cc_" + strconv.Itoa(os.Getuid())
+	}
+	if krb5conf == "" {
+		krb5conf = os.Getenv("KRB5_CONFIG")
+	}
+	if krb5conf == "" {
+		krb5conf = "/etc/krb5.conf"
+	}
+	if service == "" {
+		service = "HTTP"
+	}
+
+	return &Kerberos{
+		cache:   strings.TrimPrefix(cache, "FILE:"),
+		config:  krb5conf,
+		service: service,
+	}
+}
+
+// Token returns a SPNEGO token for the host, to be sent in the "Authorization: Negotiate" header. The cache is
+// read on every call, so that the tickets renewed by another process are picked up. A service ticket missing
+// from the cache is requested from the KDC of the configuration, with the ticket-granting ticket of the cache.
+func (k *Kerberos) Token(host string) ([]byte, error) {
+	cache, err := credentials.LoadCCache(k.cache)
+	if err != nil {
+		return nil, errors.Internal("hdfs: unable to read the credential cache", err)
+	}
+
+	conf, err := k.loadConfig()
+	if err != nil {
+		return nil, errors.Internal("hdfs: unable to read the configuration of Kerberos", err)
+	}
+
+	cl, err := client.NewFromCCache(cache, conf, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, errors.Internal("hdfs: unable to use the credential cache", err)
+	}
+
+	defer cl.Destroy()
+	token, err := spnego.SPNEGOClient(cl, k.service+"/"+host).InitSecContext()
+	if err != nil {
+		return nil, errors.Internal("hdfs: unable to get a ticket for "+k.service+"/"+host, err)
+	}
+
+	return token.Marshal()
+}
+
+// loadConfig loads the configuration of Kerberos, or the default one if there is none
+func (k *Kerberos) loadConfig() (*config.Config, error) {
+	if _, err := os.Stat(k.config); os.IsNotExist(err) {
+		return config.New(), nil
+	}
+
+	return config.Load(k.config)
+}
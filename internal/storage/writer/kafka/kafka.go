@@ -0,0 +1,250 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	script "github.com/kelindar/talaria/internal/scripting"
+	"github.com/kelindar/talaria/internal/storage/writer/base"
+)
+
+const (
+	ctxTag   = "kafka"
+	maxBatch = 10000 // The maximum number of streamed rows produced at once
+)
+
+// Writer represents a writer producing the rows to a Kafka topic, one record per row. The rows can be streamed
+// as they are ingested or produced with every flush, in which case the rows of a file are produced together.
+type Writer struct {
+	*base.Writer
+	lock     sync.Mutex                          // The lock protecting the producer
+	producer sarama.SyncProducer                 // The producer, connected with the first batch
+	connect  func() (sarama.SyncProducer, error) // The function connecting the producer
+	config   *sarama.Config                      // The configuration of the producer
+	topic    string                              // The topic to produce to
+	key      string                              // The column keying the records, if any
+	monitor  monitor.Monitor                     // The monitoring layer
+	buffer   chan *sarama.ProducerMessage        // The records to stream
+}
+
+// New creates a new writer, producing to the topic through the brokers.
+func New(brokers []string, topic, encoding, filter string, loader *script.Loader, monitor monitor.Monitor) (*Writer, error) {
+	if len(brokers) == 0 || topic == "" {
+		return nil, errors.New("kafka: brokers and a topic are required")
+	}
+
+	encoder, err := base.New(filter, encoding, loader)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		Writer:  encoder,
+		config:  configOf(),
+		topic:   topic,
+		monitor: monitor,
+		buffer:  make(chan *sarama.ProducerMessage, 65000),
+	}
+	w.connect = func() (sarama.SyncProducer, error) {
+		return sarama.NewSyncProducer(brokers, w.config)
+	}
+	w.Process = w.process
+	return w, nil
+}
+
+// configOf returns the configuration of a producer which retries a batch failing on a transient error up to 5
+// times, after refreshing the metadata of the topic.
+func configOf() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = "talaria"
+	config.Version = sarama.V2_1_0_0
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 5
+	config.Producer.Partitioner = newPartitioner
+	return config
+}
+
+// SetKey sets the column whose value keys the records, so that the rows with the same value are produced to
+// the same partition. The rows without a value are spread across the partitions.
+func (w *Writer) SetKey(column string) {
+	w.key = column
+}
+
+// SetCompression sets the compression codec of the batches, either "none", "gzip", "snappy", "lz4" or "zstd".
+func (w *Writer) SetCompression(codec string) error {
+	switch codec {
+	case "", "none":
+		w.config.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		w.config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		w.config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		w.config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		w.config.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return errors.Newf("kafka: unsupported compression '%s'", codec)
+	}
+	return nil
+}
+
+// SetIdempotent makes the producer idempotent, so that the batches retried after a transient error are
+// written only once by the brokers. The brokers acknowledge a batch once all in-sync replicas have it.
+func (w *Writer) SetIdempotent(idempotent bool) {
+	w.config.Producer.Idempotent = idempotent
+	w.config.Producer.RequiredAcks = sarama.WaitForLocal
+	w.config.Net.MaxOpenRequests = 5
+	if idempotent {
+		w.config.Producer.RequiredAcks = sarama.WaitForAll
+		w.config.Net.MaxOpenRequests = 1
+	}
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(_ key.Key, val []byte) error {
+	i, err := orc.FromBuffer(val)
+	if err != nil {
+		return errors.Internal("kafka: unable to decode", err)
+	}
+
+	defer i.Close()
+	schema := i.Schema()
+	columns := schema.Columns()
+
+	var records []*sarama.ProducerMessage
+	i.Range(func(_ int, values []interface{}) bool {
+		row := block.Row{Values: make(map[string]interface{}, len(columns)), Schema: schema}
+		for j, c := range columns {
+			row.Values[c] = values[j]
+		}
+
+		if r, ok := w.recordOf(row); ok {
+			records = append(records, r)
+		}
+		return false
+	}, columns...)
+	return w.produce(records)
+}
+
+// Stream encodes the row and pushes it into the buffer, to be produced with the rows streamed within the same second.
+func (w *Writer) Stream(row block.Row) error {
+	r, ok := w.recordOf(row)
+	if !ok {
+		return nil
+	}
+
+	select {
+	case w.buffer <- r:
+	default:
+		return errors.New("kafka: buffer is full")
+	}
+	return nil
+}
+
+// process reads from the buffer and produces the records every second or once a batch is full
+func (w *Writer) process(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	batch := make([]*sarama.ProducerMessage, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r := <-w.buffer:
+			if batch = append(batch, r); len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if len(batch) > 0 {
+			if err := w.produce(batch); err != nil {
+				w.monitor.Error(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// recordOf encodes a row as a record, returning false if the row is filtered out or cannot be encoded
+func (w *Writer) recordOf(row block.Row) (*sarama.ProducerMessage, bool) {
+	value, err := w.Writer.Encode(row)
+	if err != nil {
+		w.monitor.Error(err)
+		return nil, false
+	}
+
+	if value == nil {
+		return nil, false
+	}
+
+	r := &sarama.ProducerMessage{Topic: w.topic, Value: sarama.ByteEncoder(value)}
+	switch v := row.Values[w.key].(type) {
+	case nil:
+	case string:
+		r.Key = sarama.StringEncoder(v)
+	case []byte:
+		r.Key = sarama.ByteEncoder(v)
+	default:
+		r.Key = sarama.StringEncoder(fmt.Sprint(v))
+	}
+	return r, true
+}
+
+// produce produces the records to the topic, connecting to the brokers first if needed
+func (w *Writer) produce(records []*sarama.ProducerMessage) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	producer, err := w.getProducer()
+	if err != nil {
+		return errors.Internal("kafka: unable to connect", err)
+	}
+
+	if err := producer.SendMessages(records); err != nil {
+		return errors.Internal("kafka: unable to produce", err)
+	}
+
+	w.monitor.Count(ctxTag, "produce.records", int64(len(records)))
+	return nil
+}
+
+// getProducer returns the producer, connecting it to the brokers on first use so that the brokers being
+// unavailable when the server starts only fails the batches produced until they are back.
+func (w *Writer) getProducer() (sarama.SyncProducer, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.producer == nil {
+		producer, err := w.connect()
+		if err != nil {
+			return nil, err
+		}
+		w.producer = producer
+	}
+	return w.producer, nil
+}
+
+// Close stops streaming and closes the connections to the brokers.
+func (w *Writer) Close() error {
+	w.Writer.Close()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.producer == nil {
+		return nil
+	}
+	return w.producer.Close()
+}
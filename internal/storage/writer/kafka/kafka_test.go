@@ -0,0 +1,134 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	eorc "github.com/crphang/orc"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMock replaces the producer of the writer with a mock seeing the given number of partitions
+func newMock(t *testing.T, w *Writer, partitions int32) *mocks.SyncProducer {
+	mock := mocks.NewSyncProducer(t, w.config)
+	mock.SetDefaultPartitions(partitions)
+	w.connect = func() (sarama.SyncProducer, error) {
+		return mock, nil
+	}
+	return mock
+}
+
+// expect expects a record with the key, value and partition given
+func expect(mock *mocks.SyncProducer, key, value string, partition int32) {
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(m *sarama.ProducerMessage) error {
+		var k []byte
+		if m.Key != nil {
+			k, _ = m.Key.Encode()
+		}
+
+		v, _ := m.Value.Encode()
+		switch {
+		case string(k) != key || string(v) != value:
+			return fmt.Errorf("expected %s=%s, got %s=%s", key, value, k, v)
+		case partition >= 0 && m.Partition != partition:
+			return fmt.Errorf("expected partition %d, got %d", partition, m.Partition)
+		}
+		return nil
+	})
+}
+
+func TestWrite(t *testing.T) {
+	schema := typeof.Schema{
+		"event": typeof.String,
+		"user":  typeof.String,
+	}
+
+	orcSchema, err := orc.SchemaFor(schema)
+	assert.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := eorc.NewWriter(buffer, eorc.SetSchema(orcSchema))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write("click", "bob"))
+	assert.NoError(t, writer.Write("view", "alice"))
+	assert.NoError(t, writer.Write("buy", "bob"))
+	assert.NoError(t, writer.Close())
+
+	w, err := New([]string{"localhost:9092"}, "events", "json", "", nil, monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+
+	w.SetKey("user")
+	w.SetIdempotent(true)
+	assert.NoError(t, w.SetCompression("gzip"))
+	assert.Equal(t, sarama.CompressionGZIP, w.config.Producer.Compression)
+	assert.Equal(t, sarama.WaitForAll, w.config.Producer.RequiredAcks)
+	assert.NoError(t, w.config.Validate())
+
+	// The records with the same key land in the partition the Java clients would pick
+	bob := (murmur2([]byte("bob")) & 0x7fffffff) % 4
+	alice := (murmur2([]byte("alice")) & 0x7fffffff) % 4
+	mock := newMock(t, w, 4)
+	expect(mock, "bob", `{"event":"click","user":"bob"}`, bob)
+	expect(mock, "alice", `{"event":"view","user":"alice"}`, alice)
+	expect(mock, "bob", `{"event":"buy","user":"bob"}`, bob)
+
+	assert.NoError(t, w.Write([]byte("file.orc"), buffer.Bytes()))
+}
+
+func TestStream(t *testing.T) {
+	w, err := New([]string{"localhost:9092"}, "events", "json", "", nil, monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.NoError(t, w.SetCompression("snappy"))
+	assert.NoError(t, w.SetCompression("zstd"))
+	assert.Error(t, w.SetCompression("brotli"))
+
+	mock := newMock(t, w, 2)
+	expect(mock, "", `{"event":"click"}`, -1)
+	expect(mock, "", `{"event":"view"}`, -1)
+
+	assert.NoError(t, w.Stream(block.Row{Values: map[string]interface{}{"event": "click"}}))
+	assert.NoError(t, w.Stream(block.Row{Values: map[string]interface{}{"event": "view"}}))
+	assert.NoError(t, w.produce([]*sarama.ProducerMessage{<-w.buffer, <-w.buffer}))
+}
+
+func TestProduce_Unavailable(t *testing.T) {
+	w, err := New([]string{"localhost:9092"}, "events", "json", "", nil, monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+
+	// The producer is connected again with the next batch
+	w.connect = func() (sarama.SyncProducer, error) {
+		return nil, sarama.ErrOutOfBrokers
+	}
+	assert.NoError(t, w.Stream(block.Row{Values: map[string]interface{}{"event": "click"}}))
+	assert.Error(t, w.produce([]*sarama.ProducerMessage{<-w.buffer}))
+
+	mock := newMock(t, w, 1)
+	mock.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	assert.NoError(t, w.Stream(block.Row{Values: map[string]interface{}{"event": "click"}}))
+	assert.Error(t, w.produce([]*sarama.ProducerMessage{<-w.buffer}))
+}
+
+func TestMurmur2(t *testing.T) {
+	for key, hash := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+	} {
+		assert.Equal(t, hash, murmur2([]byte(key)), key)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package kafka
+
+import (
+	"encoding/binary"
+	"hash"
+
+	"github.com/Shopify/sarama"
+)
+
+// newPartitioner creates a partitioner selecting the partition of a keyed record with the same hash as the
+// default partitioner of the Java clients, and a random partition for the records without a key.
+var newPartitioner = sarama.NewCustomPartitioner(
+	sarama.WithAbsFirst(),
+	sarama.WithCustomHashFunction(func() hash.Hash32 {
+		return new(murmur2Hash)
+	}),
+)
+
+// murmur2Hash represents the murmur2 hash of the keys, as computed by the Java clients
+type murmur2Hash struct {
+	data []byte
+}
+
+// Write appends the bytes to the data hashed
+func (h *murmur2Hash) Write(p []byte) (int, error) {
+	h.data = append(h.data, p...)
+	return len(p), nil
+}
+
+// Sum appends the hash to the slice
+func (h *murmur2Hash) Sum(b []byte) []byte {
+	v := h.Sum32()
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Sum32 returns the hash of the data written
+func (h *murmur2Hash) Sum32() uint32 {
+	return uint32(murmur2(h.data))
+}
+
+// Reset clears the data written
+func (h *murmur2Hash) Reset() {
+	h.data = h.data[:0]
+}
+
+// Size returns the number of bytes of the hash
+func (h *murmur2Hash) Size() int {
+	return 4
+}
+
+// BlockSize returns the number of bytes hashed at once
+func (h *murmur2Hash) BlockSize() int {
+	return 4
+}
+
+// murmur2 hashes a key the same way as the default partitioner of the Kafka clients, so that the records
+// with the same key land in the same partition whichever client produced them
+func murmur2(data []byte) int32 {
+	const m, r = int32(0x5bd1e995), 24
+	length := int32(len(data))
+	h := int32(uint32(0x9747b28c) ^ uint32(length))
+
+	for i := int32(0); i+4 <= length; i += 4 {
+		k := int32(binary.LittleEndian.Uint32(data[i:]))
+		k *= m
+		k ^= int32(uint32(k) >> r)
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= int32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= int32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= int32(tail[0])
+		h *= m
+	}
+
+	h ^= int32(uint32(h) >> 13)
+	h *= m
+	h ^= int32(uint32(h) >> 15)
+	return h
+}
@@ -51,6 +51,18 @@ func (w *Writer) Write(key key.Key, val []byte) error {
 	return nil
 }
 
+// CheckSink checks the health of the sub-writers which support it, returning the first error.
+func (w *Writer) CheckSink(ctx context.Context) error {
+	for _, w := range w.writers {
+		if checker, ok := w.(interface{ CheckSink(context.Context) error }); ok {
+			if err := checker.CheckSink(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Run launches the asynchronous infinite loop for streamers to start streaming data
 func (w *Writer) Run(ctx context.Context) (async.Task, error) {
 	for _, w := range w.streamers {
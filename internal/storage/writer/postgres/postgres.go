@@ -0,0 +1,366 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package postgres
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/lib/pq"
+)
+
+const ctxTag = "postgres"
+
+// SubWriter represents the writer staging the files for Redshift
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Writer represents a writer for PostgreSQL or Amazon Redshift. With PostgreSQL, the rows of every file are
+// copied into the table with a COPY FROM STDIN within a transaction. With Redshift, which does not support it, every file is staged
+// as a gzipped CSV using another sink, typically S3, then loaded into the table with a COPY from its location.
+// In both cases, a file is loaded by a single statement, so a file which failed is not partially loaded.
+type Writer struct {
+	lock     sync.Mutex      // The lock protecting the connections
+	db       *sql.DB         // The connections to the database, opened on first use
+	address  string          // The address of the database, such as "postgres:5432"
+	database string          // The name of the database
+	user     string          // The user
+	password string          // The password of the user
+	table    string          // The name of the table, optionally qualified by its schema
+	sslMode  string          // Whether the connection is encrypted, and whether the certificate is verified
+	timeout  time.Duration   // The timeout of a statement
+	redshift *redshift       // The staging of the files for Redshift, if any
+	monitor  monitor.Monitor // The monitoring layer
+}
+
+// redshift represents the staging of the files loaded into Redshift
+type redshift struct {
+	dest     SubWriter // The writer staging the files
+	location string    // The location the files are staged to, such as "s3://bucket/prefix/"
+	iamRole  string    // The ARN of the IAM role reading the staged files
+	region   string    // The region of the bucket, if not the one of the cluster
+}
+
+// New creates a new writer, copying the rows into a table of a PostgreSQL database.
+func New(address, database, user, password, table string, monitor monitor.Monitor) (*Writer, error) {
+	if address == "" || table == "" {
+		return nil, errors.New("postgres: an address and a table are required")
+	}
+
+	return &Writer{
+		address:  address,
+		database: database,
+		user:     user,
+		password: password,
+		table:    table,
+		sslMode:  "disable",
+		timeout:  5 * time.Minute,
+		monitor:  monitor,
+	}, nil
+}
+
+// SetSSLMode sets whether the connection is encrypted, either "disable" (default), "require" which does not
+// verify the certificate of the server, or "verify-full".
+func (w *Writer) SetSSLMode(mode string) error {
+	switch mode {
+	case "":
+		w.sslMode = "disable"
+	case "disable", "require", "verify-full":
+		w.sslMode = mode
+	default:
+		return errors.New("postgres: unsupported ssl mode " + mode)
+	}
+	return nil
+}
+
+// SetRedshift sets the staging of the files for Redshift, which are written by the destination writer to the
+// location read by the COPY, using the IAM role. The region is only required if the bucket is in another one.
+func (w *Writer) SetRedshift(dest SubWriter, location, iamRole, region string) {
+	if !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+
+	w.redshift = &redshift{
+		dest:     dest,
+		location: location,
+		iamRole:  iamRole,
+		region:   region,
+	}
+}
+
+// Write writes the data to the sink.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	schema, rows, err := decode(val)
+	if err != nil {
+		return errors.Internal("postgres: unable to decode", err)
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	db, err := w.getDB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	start := time.Now()
+	columns := schema.Columns()
+	switch {
+	case w.redshift != nil:
+		query, err := w.stage(string(key), columns, encodeCSV(rows))
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return errors.Internal("postgres: unable to copy from "+w.redshift.location, err)
+		}
+	default:
+		if err := w.copy(ctx, db, columns, rows); err != nil {
+			return errors.Internal("postgres: unable to copy into "+w.table, err)
+		}
+	}
+
+	w.monitor.Duration(ctxTag, "copy", start)
+	w.monitor.Count(ctxTag, "rows", int64(len(rows)))
+	return nil
+}
+
+// stage writes the CSV to the location read by Redshift and returns the COPY loading it
+func (w *Writer) stage(name string, columns []string, data []byte) (string, error) {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	if _, err := gz.Write(data); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	name = strings.TrimSuffix(name, path.Ext(name)) + ".csv.gz"
+	if err := w.redshift.dest.Write(key.Key(name), buffer.Bytes()); err != nil {
+		return "", err
+	}
+
+	query := "COPY " + w.table + " (" + quoteIdentifiers(columns) + ") FROM " + quoteLiteral(w.redshift.location+name) +
+		" IAM_ROLE " + quoteLiteral(w.redshift.iamRole)
+	if w.redshift.region != "" {
+		query += " REGION " + quoteLiteral(w.redshift.region)
+	}
+	return query + " FORMAT AS CSV GZIP TIMEFORMAT 'auto'", nil
+}
+
+// copy streams the rows into the table with a COPY FROM STDIN, which is committed once every row is copied
+func (w *Writer) copy(ctx context.Context, db *sql.DB, columns []string, rows [][]interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "COPY "+w.table+" ("+quoteIdentifiers(columns)+") FROM STDIN")
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+	values := make([]interface{}, len(columns))
+	for _, row := range rows {
+		for i, value := range row {
+			values[i] = valueOf(value)
+		}
+
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	// Flush the remaining rows and wait for the server to complete the copy
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getDB returns the connections to the database, opening them on first use
+func (w *Writer) getDB() (*sql.DB, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.db != nil {
+		return w.db, nil
+	}
+
+	connector, err := pq.NewConnector(w.dsn())
+	if err != nil {
+		return nil, errors.Internal("postgres: unable to configure the connection", err)
+	}
+
+	w.db = sql.OpenDB(connector)
+	return w.db, nil
+}
+
+// dsn returns the connection string of the database
+func (w *Writer) dsn() string {
+	host, port, err := net.SplitHostPort(w.address)
+	if err != nil {
+		host, port = w.address, "5432"
+	}
+
+	params := [][2]string{
+		{"host", host},
+		{"port", port},
+		{"dbname", w.database},
+		{"user", w.user},
+		{"password", w.password},
+		{"sslmode", w.sslMode},
+		{"connect_timeout", strconv.Itoa(int(w.timeout.Seconds()))},
+	}
+
+	dsn := make([]string, 0, len(params))
+	for _, p := range params {
+		if p[1] != "" {
+			dsn = append(dsn, p[0]+"="+quoteParam(p[1]))
+		}
+	}
+	return strings.Join(dsn, " ")
+}
+
+// Close closes the connections to the database and the writer staging the files, if any.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.db != nil {
+		if err := w.db.Close(); err != nil {
+			return err
+		}
+		w.db = nil
+	}
+
+	if w.redshift == nil {
+		return nil
+	}
+
+	if closer, ok := w.redshift.dest.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// decode decodes the ORC file into its schema and its rows
+func decode(val []byte) (typeof.Schema, [][]interface{}, error) {
+	i, err := orc.FromBuffer(val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer i.Close()
+	schema := i.Schema()
+
+	var rows [][]interface{}
+	i.Range(func(_ int, values []interface{}) bool {
+		rows = append(rows, values)
+		return false
+	}, schema.Columns()...)
+	return schema, rows, nil
+}
+
+// valueOf converts a value of a column to a value copied by the driver, the values which are neither numbers,
+// booleans, times nor strings being copied as JSON
+func valueOf(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, int64, float64, bool, time.Time, string:
+		return v
+	case int32:
+		return int64(v)
+	case json.RawMessage:
+		return string(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// encodeCSV encodes the rows as CSV, where an unquoted empty value is a null and a string is always quoted
+func encodeCSV(rows [][]interface{}) []byte {
+	var buffer bytes.Buffer
+	for _, row := range rows {
+		for i, value := range row {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			writeValue(&buffer, value)
+		}
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+// writeValue writes a single value of a column
+func writeValue(buffer *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case int32:
+		buffer.WriteString(strconv.FormatInt(int64(v), 10))
+	case int64:
+		buffer.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		buffer.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		buffer.WriteString(strconv.FormatBool(v))
+	case time.Time:
+		buffer.WriteString(v.UTC().Format("2006-01-02 15:04:05.999999-07"))
+	case string:
+		writeQuoted(buffer, v)
+	case json.RawMessage:
+		writeQuoted(buffer, string(v))
+	default:
+		encoded, _ := json.Marshal(v)
+		writeQuoted(buffer, string(encoded))
+	}
+}
+
+// writeQuoted writes a quoted CSV value, doubling its quotes
+func writeQuoted(buffer *bytes.Buffer, value string) {
+	buffer.WriteByte('"')
+	buffer.WriteString(strings.Replace(value, `"`, `""`, -1))
+	buffer.WriteByte('"')
+}
+
+// quoteIdentifiers returns the list of quoted identifiers
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, `"`+strings.Replace(name, `"`, `""`, -1)+`"`)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteParam returns a quoted parameter of a connection string, escaping its quotes and backslashes
+func quoteParam(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// quoteLiteral returns a quoted string literal
+func quoteLiteral(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
@@ -0,0 +1,304 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eorc "github.com/crphang/orc"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/encoding/orc"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// server represents a PostgreSQL server, authenticating the user with SCRAM-SHA-256 or MD5
+type server struct {
+	net.Listener
+	t        *testing.T
+	lock     sync.Mutex
+	password string
+	method   string
+	failing  bool
+	queries  []string
+	copied   []string
+}
+
+func newServer(t *testing.T, method, password string) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := &server{Listener: listener, t: t, password: password, method: method}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// serve serves a single connection, until the client terminates it
+func (s *server) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	// Read the startup message, which is the protocol version followed by the parameters
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	assert.NoError(s.t, err)
+	startup := make([]byte, binary.BigEndian.Uint32(size[:])-4)
+	_, err = io.ReadFull(r, startup)
+	assert.NoError(s.t, err)
+
+	params := map[string]string{}
+	fields := strings.Split(strings.TrimRight(string(startup[4:]), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[fields[i]] = fields[i+1]
+	}
+	assert.Equal(s.t, "talaria", params["user"])
+	assert.Equal(s.t, "analytics", params["database"])
+
+	if !s.authenticate(c, r) {
+		s.send(c, 'E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+		return
+	}
+
+	s.send(c, 'R', []byte{0, 0, 0, 0})
+	s.send(c, 'Z', []byte("I"))
+	for {
+		typ, body := s.receive(r)
+		if typ != 'Q' {
+			return
+		}
+
+		query := strings.TrimRight(string(body), "\x00")
+		s.lock.Lock()
+		s.queries = append(s.queries, query)
+		failing := s.failing
+		s.lock.Unlock()
+
+		switch {
+		case strings.HasPrefix(query, "BEGIN"):
+			s.send(c, 'C', []byte("BEGIN\x00"))
+			s.send(c, 'Z', []byte("T"))
+		case query == "COMMIT" || query == "ROLLBACK":
+			s.send(c, 'C', []byte(query+"\x00"))
+			s.send(c, 'Z', []byte("I"))
+		case strings.HasSuffix(query, "FROM STDIN"):
+			s.send(c, 'G', []byte{0, 0, 0})
+			var data []byte
+			for {
+				typ, body := s.receive(r)
+				if typ != 'd' {
+					break
+				}
+				data = append(data, body...)
+			}
+
+			s.lock.Lock()
+			s.copied = append(s.copied, string(data))
+			s.lock.Unlock()
+			s.complete(c, failing, "E", "T")
+		default:
+			s.complete(c, failing, "I", "I")
+		}
+	}
+}
+
+// complete completes a copy, or fails it
+func (s *server) complete(c net.Conn, failing bool, failed, succeeded string) {
+	if failing {
+		s.send(c, 'E', []byte("SERROR\x00C22P02\x00Minvalid input syntax\x00\x00"))
+		s.send(c, 'Z', []byte(failed))
+		return
+	}
+
+	s.send(c, 'C', []byte("COPY 2\x00"))
+	s.send(c, 'Z', []byte(succeeded))
+}
+
+// authenticate authenticates the user with the password
+func (s *server) authenticate(c net.Conn, r *bufio.Reader) bool {
+	if s.method == "md5" {
+		s.send(c, 'R', []byte{0, 0, 0, 5, 1, 2, 3, 4})
+		_, body := s.receive(r)
+		inner := md5.Sum([]byte(s.password + "talaria"))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), 1, 2, 3, 4))
+		return string(body) == "md5"+hex.EncodeToString(outer[:])+"\x00"
+	}
+
+	s.send(c, 'R', append([]byte{0, 0, 0, 10}, "SCRAM-SHA-256\x00\x00"...))
+	_, body := s.receive(r)
+	clientBare := string(body[bytes.IndexByte(body, 0)+5+3:])
+	nonce := scramAttributes(clientBare)["r"] + "server"
+	salt := []byte("salt")
+	serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	s.send(c, 'R', append([]byte{0, 0, 0, 11}, serverFirst...))
+
+	_, body = s.receive(r)
+	clientFinal := string(body)
+	withoutProof := clientFinal[:strings.Index(clientFinal, ",p=")]
+	proof, _ := base64.StdEncoding.DecodeString(scramAttributes(clientFinal)["p"])
+	authMessage := clientBare + "," + serverFirst + "," + withoutProof
+
+	// Recover the key of the client from its proof, and verify it against the stored key
+	salted := pbkdf2.Key([]byte(s.password), salt, 4096, 32, sha256.New)
+	storedKey := sha256.Sum256(hmacOf(salted, "Client Key"))
+	signature := hmacOf(storedKey[:], authMessage)
+	for i := range signature {
+		signature[i] ^= proof[i]
+	}
+	if sha256.Sum256(signature) != storedKey {
+		return false
+	}
+
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(hmacOf(hmacOf(salted, "Server Key"), authMessage))
+	s.send(c, 'R', append([]byte{0, 0, 0, 12}, serverFinal...))
+	return true
+}
+
+func (s *server) send(c net.Conn, typ byte, body []byte) {
+	msg := make([]byte, 5, 5+len(body))
+	msg[0] = typ
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(body)+4))
+	_, err := c.Write(append(msg, body...))
+	assert.NoError(s.t, err)
+}
+
+func (s *server) receive(r *bufio.Reader) (byte, []byte) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(r, body)
+	assert.NoError(s.t, err)
+	return header[0], body
+}
+
+// scramAttributes parses the attributes of a SCRAM message, such as "r=...,s=...,i=4096"
+func scramAttributes(message string) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) > 2 && attribute[1] == '=' {
+			attributes[attribute[:1]] = attribute[2:]
+		}
+	}
+	return attributes
+}
+
+// hmacOf returns the HMAC-SHA-256 of a message
+func hmacOf(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// sink represents a sink keeping the files written
+type sink map[string][]byte
+
+func (s sink) Write(key key.Key, val []byte) error {
+	s[string(key)] = val
+	return nil
+}
+
+// newFile encodes the rows into an ORC file
+func newFile(t *testing.T) []byte {
+	orcSchema, err := orc.SchemaFor(typeof.Schema{
+		"event": typeof.String,
+		"count": typeof.Int64,
+		"time":  typeof.Timestamp,
+	})
+	assert.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	writer, err := eorc.NewWriter(buffer, eorc.SetSchema(orcSchema))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(int64(1), `say "hi"`, time.Unix(1600000000, 0)))
+	assert.NoError(t, writer.Write(int64(2), "", nil))
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestWrite(t *testing.T) {
+	s := newServer(t, "scram", "secret")
+	defer s.Close()
+
+	w, err := New(s.Addr().String(), "analytics", "talaria", "secret", "public.events", monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.NoError(t, w.Write(key.Key("file.orc"), newFile(t)))
+	assert.Equal(t, []string{"BEGIN READ WRITE", `COPY public.events ("count", "event", "time") FROM STDIN`, "COMMIT"}, s.queries)
+	assert.Equal(t, []string{"1\tsay \"hi\"\t2020-09-13 12:26:40Z\n2\t\t\\N\n"}, s.copied)
+
+	// A failed copy is reported and rolled back
+	s.failing = true
+	assert.Error(t, w.Write(key.Key("file.orc"), newFile(t)))
+	assert.Equal(t, "ROLLBACK", s.queries[len(s.queries)-1])
+}
+
+func TestWrite_Password(t *testing.T) {
+	s := newServer(t, "md5", "secret")
+	defer s.Close()
+
+	w, err := New(s.Addr().String(), "analytics", "talaria", "wrong", "events", monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.Error(t, w.Write(key.Key("file.orc"), newFile(t)))
+	assert.Empty(t, s.queries)
+}
+
+func TestRedshift(t *testing.T) {
+	s := newServer(t, "md5", "secret")
+	defer s.Close()
+
+	staged := sink{}
+	w, err := New(s.Addr().String(), "analytics", "talaria", "secret", "events", monitor.NewNoop())
+	assert.NoError(t, err)
+	defer w.Close()
+	w.SetRedshift(staged, "s3://bucket/staging", "arn:aws:iam::123456789012:role/loader", "")
+	assert.NoError(t, w.Write(key.Key("dt=2020-09-13/file.orc"), newFile(t)))
+	assert.Equal(t, []string{`COPY events ("count", "event", "time") FROM 's3://bucket/staging/dt=2020-09-13/file.csv.gz' ` +
+		`IAM_ROLE 'arn:aws:iam::123456789012:role/loader' FORMAT AS CSV GZIP TIMEFORMAT 'auto'`}, s.queries)
+	assert.Empty(t, s.copied)
+
+	gz, err := gzip.NewReader(bytes.NewReader(staged["dt=2020-09-13/file.csv.gz"]))
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,\"say \"\"hi\"\"\",2020-09-13 12:26:40+00\n2,\"\",\n", string(data))
+}
+
+func TestSSLMode(t *testing.T) {
+	w, err := New("localhost:5432", "analytics", "talaria", "it's", "events", monitor.NewNoop())
+	assert.NoError(t, err)
+	assert.Equal(t, `host='localhost' port='5432' dbname='analytics' user='talaria' password='it\'s' sslmode='disable' connect_timeout='300'`, w.dsn())
+	assert.NoError(t, w.SetSSLMode("require"))
+	assert.Contains(t, w.dsn(), "sslmode='require'")
+	assert.NoError(t, w.SetSSLMode("verify-full"))
+	assert.Contains(t, w.dsn(), "sslmode='verify-full'")
+	assert.Error(t, w.SetSSLMode("prefer"))
+}
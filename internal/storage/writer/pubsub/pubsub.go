@@ -2,11 +2,12 @@ package pubsub
 
 import (
 	"context"
+	"fmt"
 	"runtime"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/pubsub"
-	"github.com/grab/async"
+	vkit "cloud.google.com/go/pubsub/apiv1"
 	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/encoding/key"
 	"github.com/kelindar/talaria/internal/monitor"
@@ -14,24 +15,49 @@ import (
 	script "github.com/kelindar/talaria/internal/scripting"
 	"github.com/kelindar/talaria/internal/storage/writer/base"
 	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+const (
+	maxMessages = 1000    // The maximum number of messages of a publish request
+	maxBytes    = 9 << 20 // The maximum size of the messages of a publish request
+)
+
+// message represents an encoded message to publish
+type message struct {
+	data        []byte            // The encoded row
+	orderingKey string            // The ordering key, if any
+	attributes  map[string]string // The attributes, if any
+}
+
+// size returns the size of the message, as accounted by the flow control
+func (m *message) size() int {
+	n := len(m.data) + len(m.orderingKey)
+	for k, v := range m.attributes {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
 // Writer to write and stream to PubSub
 type Writer struct {
 	*base.Writer
-	client  *pubsub.Client
-	topic   *pubsub.Topic
-	monitor monitor.Monitor
-	context context.Context
-	buffer  chan []byte
-	queue   chan async.Task
+	client     *vkit.PublisherClient
+	topic      string
+	monitor    monitor.Monitor
+	buffer     chan message
+	pending    map[string][]message // The messages which failed to publish, per ordering key
+	flow       *flowController
+	ordering   string   // The column of the ordering key, if any
+	attributes []string // The columns of the attributes, if any
 }
 
 // New creates a new writer
 func New(project, topic, encoding, filter string, loader *script.Loader, monitor monitor.Monitor, opts ...option.ClientOption) (*Writer, error) {
 	ctx := context.Background()
-	client, err := pubsub.NewClient(ctx, project, opts...)
-
+	client, err := vkit.NewPublisherClient(ctx, opts...)
 	if err != nil {
 		return nil, errors.Newf("pubsub: %v", err)
 	}
@@ -45,30 +71,47 @@ func New(project, topic, encoding, filter string, loader *script.Loader, monitor
 	// Check if topic exists
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	topicRef := client.Topic(topic)
-	ok, err := topicRef.Exists(ctx)
-
-	if err != nil {
+	name := fmt.Sprintf("projects/%s/topics/%s", project, topic)
+	if _, err := client.GetTopic(ctx, &pb.GetTopicRequest{Topic: name}); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errors.New("pubsub: topic does not exist")
+		}
 		return nil, errors.Newf("pubsub: %v", err)
 	}
-	if !ok {
-		return nil, errors.New("pubsub: topic does not exist")
-	}
 
 	w := &Writer{
-		topic:   topicRef,
+		topic:   name,
 		client:  client,
 		Writer:  encoderWriter,
 		monitor: monitor,
-		context: ctx,
-		buffer:  make(chan []byte, 65000),
-		queue:   make(chan async.Task),
+		buffer:  make(chan message, 65000),
+		pending: make(map[string][]message),
+		flow:    newFlowController(0, 0, false),
 	}
 	w.Process = w.process
 
 	return w, nil
 }
 
+// SetOrdering sets the column whose value is the ordering key of the messages, so that the messages with the
+// same key are published in the order they were streamed and delivered in that order to the subscriptions
+// with message ordering enabled.
+func (w *Writer) SetOrdering(column string) {
+	w.ordering = column
+}
+
+// SetAttributes sets the columns whose values are copied into the attributes of the messages, so that the
+// subscriptions can filter on them without decoding the messages.
+func (w *Writer) SetAttributes(columns []string) {
+	w.attributes = columns
+}
+
+// SetFlowControl limits the number and the total size of the messages streamed but not yet published. Once a
+// limit is reached, streaming a row either blocks until some messages are published or fails.
+func (w *Writer) SetFlowControl(maxOutstandingMessages, maxOutstandingBytes int, block bool) {
+	w.flow = newFlowController(maxOutstandingMessages, maxOutstandingBytes, block)
+}
+
 // Write writes the data to the sink.
 func (w *Writer) Write(key.Key, []byte) error {
 	return nil // Noop
@@ -76,19 +119,44 @@ func (w *Writer) Write(key.Key, []byte) error {
 
 // Stream encodes data and pushes it into buffer
 func (w *Writer) Stream(row block.Row) error {
-	message, err := w.Writer.Encode(row)
+	data, err := w.Writer.Encode(row)
 	if err != nil {
 		return err
 	}
 
 	// If message is filtered out, return nil
-	if message == nil {
+	if data == nil {
+		return nil
+	}
+
+	m := message{data: data}
+	if v, ok := row.Values[w.ordering]; ok && v != nil {
+		m.orderingKey = fmt.Sprint(v)
+	}
+
+	for _, c := range w.attributes {
+		if v, ok := row.Values[c]; ok && v != nil {
+			if m.attributes == nil {
+				m.attributes = make(map[string]string, len(w.attributes))
+			}
+			m.attributes[c] = fmt.Sprint(v)
+		}
+	}
+
+	if err := w.flow.acquire(m.size()); err != nil {
+		return err
+	}
+
+	// Unless the flow control blocks, a full buffer fails the row instead of blocking the ingestion
+	if w.flow.block {
+		w.buffer <- m
 		return nil
 	}
 
 	select {
-	case w.buffer <- message:
+	case w.buffer <- m:
 	default:
+		w.flow.release(m.size())
 		return errors.New("pubsub: buffer is full")
 	}
 	return nil
@@ -96,42 +164,144 @@ func (w *Writer) Stream(row block.Row) error {
 
 // process will read from buffer and publish to PubSub
 func (w *Writer) process(parent context.Context) error {
-	async.Consume(parent, runtime.NumCPU()*8, w.queue)
-	for message := range w.buffer {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var batch []message
+	for {
 		select {
 		// Returns error if the parent context gets cancelled. Done() returns an empty struct
 		case <-parent.Done():
 			return parent.Err()
-		default:
+		case m := <-w.buffer:
+			if batch = append(batch, m); len(batch) < maxMessages {
+				continue
+			}
+		case <-ticker.C:
 		}
 
-		// Asynchronously adds a task to publish to Pub/Sub and check its response
-		encoded := message
-		w.queue <- async.NewTask(func(ctx context.Context) (interface{}, error) {
-			result := w.topic.Publish(ctx, &pubsub.Message{
-				Data: message,
-			})
-			return nil, w.processResult(result, encoded)
-		})
+		w.flush(parent, batch)
+		batch = batch[:0]
+	}
+}
 
+// flush publishes the messages along with the ones which previously failed. The messages with the same
+// ordering key are published sequentially, in order, while the different keys are published concurrently.
+func (w *Writer) flush(ctx context.Context, batch []message) {
+	for _, m := range batch {
+		w.pending[m.orderingKey] = append(w.pending[m.orderingKey], m)
 	}
-	return nil
+
+	// The messages which fail are kept, in order, to be published with the next batch
+	messages := w.pending
+	w.pending = make(map[string][]message, len(messages))
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, runtime.NumCPU()*8)
+	for orderingKey, messages := range messages {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(orderingKey string, messages []message) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			published, err := w.publish(ctx, messages)
+			if err == nil {
+				return
+			}
+
+			lock.Lock()
+			w.pending[orderingKey] = messages[published:]
+			lock.Unlock()
+			if w.monitor != nil {
+				w.monitor.Error(errors.Internal("pubsub: unable to publish", err))
+			}
+		}(orderingKey, messages)
+	}
+	wg.Wait()
 }
 
-// processResult will process the result from publish to check if there are errors
-func (w *Writer) processResult(res *pubsub.PublishResult, message []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// publish publishes the messages in as many requests as required, stopping at the first failure, and returns
+// the number of messages published
+func (w *Writer) publish(ctx context.Context, messages []message) (int, error) {
+	published := 0
+	for published < len(messages) {
+		req := &pb.PublishRequest{Topic: w.topic}
+		size := 0
+		for _, m := range messages[published:] {
+			if len(req.Messages) == maxMessages || (size+m.size() > maxBytes && len(req.Messages) > 0) {
+				break
+			}
 
-	// If stream hits error, repopulate message in buffer and return error
-	if _, err := res.Get(ctx); err != nil {
-		w.buffer <- message
-		return err
+			size += m.size()
+			req.Messages = append(req.Messages, &pb.PubsubMessage{
+				Data:        m.data,
+				Attributes:  m.attributes,
+				OrderingKey: m.orderingKey,
+			})
+		}
+
+		if _, err := w.client.Publish(ctx, req); err != nil {
+			return published, err
+		}
+
+		for _, m := range messages[published : published+len(req.Messages)] {
+			w.flow.release(m.size())
+		}
+		published += len(req.Messages)
 	}
-	return nil
+	return published, nil
 }
 
 // Close closes the Pub/Sub client
 func (w *Writer) Close() error {
+	w.Writer.Close()
 	return w.client.Close()
 }
+
+// flowController limits the number and the size of the messages outstanding
+type flowController struct {
+	cond     *sync.Cond
+	maxCount int  // The maximum number of messages, zero for no limit
+	maxBytes int  // The maximum size of the messages, zero for no limit
+	block    bool // Whether to block instead of failing once a limit is reached
+	count    int  // The number of messages outstanding
+	bytes    int  // The size of the messages outstanding
+}
+
+// newFlowController creates a new flow controller
+func newFlowController(maxCount, maxBytes int, block bool) *flowController {
+	return &flowController{
+		cond:     sync.NewCond(new(sync.Mutex)),
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		block:    block,
+	}
+}
+
+// acquire accounts for a message, waiting for the outstanding messages to be released or failing if it would
+// exceed a limit. A message larger than the size limit is accepted when no other message is outstanding.
+func (f *flowController) acquire(size int) error {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	for (f.maxCount > 0 && f.count+1 > f.maxCount) || (f.maxBytes > 0 && f.count > 0 && f.bytes+size > f.maxBytes) {
+		if !f.block {
+			return errors.New("pubsub: flow control limits exceeded")
+		}
+		f.cond.Wait()
+	}
+
+	f.count++
+	f.bytes += size
+	return nil
+}
+
+// release releases a message which was published
+func (f *flowController) release(size int) {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	f.count--
+	f.bytes -= size
+	f.cond.Broadcast()
+}
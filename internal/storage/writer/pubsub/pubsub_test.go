@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/pubsub"
+	vkit "cloud.google.com/go/pubsub/apiv1"
 	"cloud.google.com/go/pubsub/pstest"
 	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/monitor"
@@ -13,6 +14,7 @@ import (
 	"github.com/kelindar/talaria/internal/monitor/statsd"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
 	"google.golang.org/grpc"
 )
 
@@ -83,7 +85,7 @@ func TestFullMessageBuffer(t *testing.T) {
 	assert.IsType(t, &Writer{}, c)
 	assert.NoError(t, err)
 
-	c.buffer = make(chan []byte, 1)
+	c.buffer = make(chan message, 1)
 
 	row := block.Row{
 		Values: map[string]interface{}{
@@ -108,3 +110,42 @@ func TestWrite(t *testing.T) {
 	res := c.Write(nil, nil)
 	assert.Nil(t, res)
 }
+
+func TestOrdering(t *testing.T) {
+	conn := setup()
+	setup2(conn)
+
+	ctx := context.Background()
+	client, _ := pubsub.NewClient(ctx, "gcp-project", option.WithGRPCConn(conn))
+	_, err := client.CreateSubscription(ctx, "sub", pubsub.SubscriptionConfig{Topic: client.Topic("talaria")})
+	assert.NoError(t, err)
+
+	c, err := New("gcp-project", "talaria", "", "", nil, monitor.NewNoop(), option.WithGRPCConn(conn))
+	assert.NoError(t, err)
+	c.SetOrdering("user")
+	c.SetAttributes([]string{"event", "missing"})
+	c.SetFlowControl(2, 0, false)
+
+	// The flow control fails the rows once the limit of outstanding messages is reached
+	assert.NoError(t, c.Stream(block.Row{Values: map[string]interface{}{"user": "bob", "event": "click"}}))
+	assert.NoError(t, c.Stream(block.Row{Values: map[string]interface{}{"user": "bob", "event": "view"}}))
+	assert.Error(t, c.Stream(block.Row{Values: map[string]interface{}{"user": "alice", "event": "buy"}}))
+
+	c.flush(ctx, []message{<-c.buffer, <-c.buffer})
+	assert.Empty(t, c.pending)
+	assert.NoError(t, c.Stream(block.Row{Values: map[string]interface{}{"event": "buy"}}))
+
+	sub, err := vkit.NewSubscriberClient(ctx, option.WithGRPCConn(conn))
+	assert.NoError(t, err)
+	resp, err := sub.Pull(ctx, &pb.PullRequest{Subscription: "projects/gcp-project/subscriptions/sub", MaxMessages: 10})
+	assert.NoError(t, err)
+
+	messages := make(map[string]*pb.PubsubMessage)
+	for _, m := range resp.ReceivedMessages {
+		messages[string(m.Message.Data)] = m.Message
+	}
+
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "bob", messages[`{"event":"click","user":"bob"}`].OrderingKey)
+	assert.Equal(t, map[string]string{"event": "view"}, messages[`{"event":"view","user":"bob"}`].Attributes)
+}
@@ -0,0 +1,236 @@
+package retry
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/grab/async"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const (
+	ctxTag    = "retry"
+	tmpSuffix = ".tmp"
+)
+
+// SubWriter represents the writer whose writes are retried
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Writer represents a writer which retries the failed writes with an exponential backoff and, once the attempts
+// are exhausted, spills the files into a local dead-letter directory from which they are replayed until the
+// underlying writer accepts them again.
+type Writer struct {
+	dest       SubWriter             // The writer whose writes are retried
+	monitor    monitor.Monitor       // The monitoring layer
+	attempts   int                   // The maximum number of attempts of a write
+	backoff    time.Duration         // The delay before the first retry, doubled on every attempt
+	maxBackoff time.Duration         // The maximum delay between two attempts
+	jitter     float64               // The fraction of the delay which is randomized
+	dir        string                // The dead-letter directory, if any
+	spilled    int64                 // The number of files in the dead-letter directory
+	sleep      func(d time.Duration) // The function waiting between two attempts
+	task       async.Task            // The task replaying the dead letters
+}
+
+// New creates a new writer retrying up to 5 attempts, with a backoff starting at one second.
+func New(dest SubWriter, monitor monitor.Monitor) *Writer {
+	return &Writer{
+		dest:       dest,
+		monitor:    monitor,
+		attempts:   5,
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		jitter:     0.2,
+		sleep:      time.Sleep,
+	}
+}
+
+// SetBackoff sets the maximum number of attempts of a write and the delay between two attempts, which starts
+// at the backoff and doubles up to the maximum backoff. The jitter, between 0 and 1, is the fraction of the
+// delay which is randomized so that the nodes do not retry in lockstep. A zero value keeps the default.
+func (w *Writer) SetBackoff(attempts int, backoff, maxBackoff time.Duration, jitter float64) {
+	if attempts > 0 {
+		w.attempts = attempts
+	}
+	if backoff > 0 {
+		w.backoff = backoff
+	}
+	if maxBackoff > 0 {
+		w.maxBackoff = maxBackoff
+	}
+	if jitter > 0 && jitter <= 1 {
+		w.jitter = jitter
+	}
+}
+
+// SetDeadLetter sets the directory into which the files are spilled once their attempts are exhausted, and
+// replays them to the underlying writer at every interval. The files left by a previous run are replayed too.
+func (w *Writer) SetDeadLetter(dir string, interval time.Duration) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Internal("retry: unable to create the dead-letter directory", err)
+	}
+
+	w.dir = dir
+	atomic.StoreInt64(&w.spilled, int64(len(w.deadLetters())))
+	w.task = async.Invoke(context.Background(), func(ctx context.Context) (interface{}, error) {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, nil
+			case <-time.After(interval):
+				if err := w.Replay(); err != nil {
+					w.monitor.Warning(err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Write writes the data to the underlying writer, retrying on failure, and spills it into the dead-letter
+// directory if every attempt failed.
+func (w *Writer) Write(key key.Key, val []byte) error {
+
+	// While files are waiting to be replayed, the destination is most likely down so a single attempt is made
+	attempts := w.attempts
+	if atomic.LoadInt64(&w.spilled) > 0 {
+		attempts = 1
+	}
+
+	err := w.write(key, val, attempts)
+	if err == nil || w.dir == "" {
+		return err
+	}
+
+	if serr := w.spill(key, val); serr != nil {
+		return errors.Combine(err, serr)
+	}
+
+	w.monitor.Warning(errors.Internal("retry: spilled "+string(key)+" into the dead-letter directory", err))
+	w.monitor.Count1(ctxTag, "spill")
+	return nil
+}
+
+// write writes the data to the underlying writer, waiting with an exponential backoff between the attempts
+func (w *Writer) write(key key.Key, val []byte, attempts int) (err error) {
+	delay := w.backoff
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			w.monitor.Count1(ctxTag, "attempt")
+			w.sleep(w.jittered(delay))
+			if delay *= 2; delay > w.maxBackoff {
+				delay = w.maxBackoff
+			}
+		}
+
+		if err = w.dest.Write(key, val); err == nil {
+			return nil
+		}
+
+		// The circuit of the sink is open, so the write would fail again until it is probed
+		if open, ok := err.(interface{ CircuitOpen() bool }); ok && open.CircuitOpen() {
+			return err
+		}
+	}
+	return err
+}
+
+// jittered randomizes a delay by up to the fraction of jitter, in either direction
+func (w *Writer) jittered(delay time.Duration) time.Duration {
+	return time.Duration(float64(delay) * (1 + w.jitter*(2*rand.Float64()-1)))
+}
+
+// spill writes the data into the dead-letter directory, atomically so that a partial file is never replayed
+func (w *Writer) spill(key key.Key, val []byte) error {
+	name := filepath.Join(w.dir, url.PathEscape(string(key)))
+	if err := ioutil.WriteFile(name+tmpSuffix, val, 0644); err != nil {
+		return errors.Internal("retry: unable to spill into the dead-letter directory", err)
+	}
+
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		atomic.AddInt64(&w.spilled, 1)
+	}
+
+	if err := os.Rename(name+tmpSuffix, name); err != nil {
+		return errors.Internal("retry: unable to spill into the dead-letter directory", err)
+	}
+	return nil
+}
+
+// Replay writes the files of the dead-letter directory to the underlying writer, oldest first, and stops at the
+// first failure.
+func (w *Writer) Replay() error {
+	for _, name := range w.deadLetters() {
+		path := filepath.Join(w.dir, name)
+		val, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Internal("retry: unable to read a dead letter", err)
+		}
+
+		k, err := url.PathUnescape(name)
+		if err != nil {
+			k = name
+		}
+
+		if err := w.dest.Write(key.Key(k), val); err != nil {
+			return errors.Internal("retry: unable to replay "+k, err)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return errors.Internal("retry: unable to remove a dead letter", err)
+		}
+
+		atomic.AddInt64(&w.spilled, -1)
+		w.monitor.Count1(ctxTag, "replay")
+	}
+	return nil
+}
+
+// deadLetters returns the names of the files in the dead-letter directory, oldest first
+func (w *Writer) deadLetters() []string {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasSuffix(f.Name(), tmpSuffix) {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+// CheckSink checks the health of the underlying writer, if it supports it.
+func (w *Writer) CheckSink(ctx context.Context) error {
+	if checker, ok := w.dest.(interface{ CheckSink(context.Context) error }); ok {
+		return checker.CheckSink(ctx)
+	}
+	return nil
+}
+
+// Close stops replaying the dead letters and closes the underlying writer.
+func (w *Writer) Close() error {
+	if w.task != nil {
+		w.task.Cancel()
+	}
+
+	if closer, ok := w.dest.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+// sink represents a sink which fails a number of writes
+type sink struct {
+	failures int
+	writes   int
+	files    map[string][]byte
+}
+
+func (s *sink) Write(key key.Key, val []byte) error {
+	s.writes++
+	if s.failures != 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+
+	s.files[string(key)] = val
+	return nil
+}
+
+func newWriter(dest *sink) (*Writer, *[]time.Duration) {
+	var delays []time.Duration
+	w := New(dest, monitor.New(logging.NewStandard(), statsd.NewNoop(), "x", "x"))
+	w.sleep = func(d time.Duration) { delays = append(delays, d) }
+	return w, &delays
+}
+
+func TestRetry(t *testing.T) {
+	dest := &sink{files: map[string][]byte{}, failures: 3}
+	w, delays := newWriter(dest)
+	w.SetBackoff(4, 100*time.Millisecond, 300*time.Millisecond, 0.5)
+
+	// The write succeeds at its fourth attempt, with an exponential and capped backoff
+	assert.NoError(t, w.Write(key.Key("a.orc"), []byte("a")))
+	assert.Equal(t, 4, dest.writes)
+	assert.Len(t, *delays, 3)
+	for i, max := range []time.Duration{100, 200, 300} {
+		assert.True(t, (*delays)[i] >= max*time.Millisecond/2)
+		assert.True(t, (*delays)[i] <= max*time.Millisecond*3/2)
+	}
+
+	// Without a dead-letter directory, the error is returned once the attempts are exhausted
+	dest.failures = 4
+	assert.Error(t, w.Write(key.Key("b.orc"), []byte("b")))
+	assert.Equal(t, 8, dest.writes)
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dest := &sink{files: map[string][]byte{}, failures: 5}
+	w, _ := newWriter(dest)
+	assert.NoError(t, w.SetDeadLetter(dir, time.Hour))
+	defer w.Close()
+
+	// The file is spilled once its attempts are exhausted
+	assert.NoError(t, w.Write(key.Key("dt=2020/a.orc"), []byte("a")))
+	assert.Equal(t, int64(1), w.spilled)
+	assert.Len(t, dest.files, 0)
+
+	// While the sink is down, a single attempt is made before spilling
+	dest.failures = 1
+	assert.NoError(t, w.Write(key.Key("dt=2020/b.orc"), []byte("b")))
+	assert.Equal(t, 6, dest.writes)
+	assert.Equal(t, int64(2), w.spilled)
+
+	// A failed replay keeps the files
+	dest.failures = 1
+	assert.Error(t, w.Replay())
+	assert.Len(t, w.deadLetters(), 2)
+
+	// Once the sink is back, the files are replayed with their original name
+	assert.NoError(t, w.Replay())
+	assert.Equal(t, map[string][]byte{
+		"dt=2020/a.orc": []byte("a"),
+		"dt=2020/b.orc": []byte("b"),
+	}, dest.files)
+	assert.Len(t, w.deadLetters(), 0)
+	assert.Equal(t, int64(0), w.spilled)
+
+	// The files left by a previous run are picked up
+	assert.NoError(t, ioutil.WriteFile(dir+"/c.orc", []byte("c"), 0644))
+	other, _ := newWriter(dest)
+	assert.NoError(t, other.SetDeadLetter(dir, time.Hour))
+	defer other.Close()
+	assert.Equal(t, int64(1), other.spilled)
+}
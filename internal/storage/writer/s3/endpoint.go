@@ -0,0 +1,65 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package s3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Endpoint represents a custom endpoint of an S3-compatible store, such as MinIO or Ceph.
+type Endpoint struct {
+	URL        string // The URL of the endpoint, such as "https://minio:9000"
+	PathStyle  *bool  // Whether the bucket is addressed in the path rather than the host, by default with a custom URL
+	DisableSSL bool   // Whether to use plain HTTP, by default with an "http://" URL
+	CACert     string // The PEM-encoded certificate of a custom certificate authority, or the path to it (optional)
+}
+
+// Apply configures the client for the endpoint, leaving the configuration untouched for Amazon S3.
+func (e *Endpoint) Apply(config *aws.Config) error {
+	if e.URL != "" {
+		pathStyle := true
+		if e.PathStyle != nil {
+			pathStyle = *e.PathStyle
+		}
+
+		config.WithEndpoint(e.URL).
+			WithS3ForcePathStyle(pathStyle).
+			WithDisableSSL(e.DisableSSL || strings.HasPrefix(e.URL, "http://"))
+	}
+
+	if e.CACert == "" {
+		return nil
+	}
+
+	// Trust the certificate authority on top of the ones of the system
+	pem := []byte(e.CACert)
+	if !strings.HasPrefix(strings.TrimSpace(e.CACert), "-----BEGIN") {
+		b, err := ioutil.ReadFile(e.CACert)
+		if err != nil {
+			return errors.Internal("s3: unable to read the certificate authority", err)
+		}
+		pem = b
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("s3: unable to parse the certificate authority")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	config.WithHTTPClient(&http.Client{Transport: transport})
+	return nil
+}
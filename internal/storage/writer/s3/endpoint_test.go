@@ -0,0 +1,56 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package s3
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoint(t *testing.T) {
+
+	// Amazon S3 is left untouched
+	config := aws.NewConfig()
+	assert.NoError(t, (&Endpoint{}).Apply(config))
+	assert.Nil(t, config.Endpoint)
+	assert.Nil(t, config.S3ForcePathStyle)
+
+	// A custom endpoint uses the path-style addressing by default
+	config = aws.NewConfig()
+	assert.NoError(t, (&Endpoint{URL: "http://minio:9000"}).Apply(config))
+	assert.Equal(t, "http://minio:9000", *config.Endpoint)
+	assert.True(t, *config.S3ForcePathStyle)
+	assert.True(t, *config.DisableSSL)
+
+	config = aws.NewConfig()
+	assert.NoError(t, (&Endpoint{URL: "https://ceph", PathStyle: aws.Bool(false)}).Apply(config))
+	assert.False(t, *config.S3ForcePathStyle)
+	assert.False(t, *config.DisableSSL)
+
+	// An invalid certificate authority is rejected
+	assert.Error(t, (&Endpoint{CACert: "-----BEGIN CERTIFICATE-----"}).Apply(aws.NewConfig()))
+	assert.Error(t, (&Endpoint{CACert: "/missing/ca.pem"}).Apply(aws.NewConfig()))
+}
+
+func TestEndpoint_CACert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// The server is only trusted once its certificate authority is
+	_, err := http.DefaultClient.Get(srv.URL)
+	assert.Error(t, err)
+
+	config := aws.NewConfig()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, (&Endpoint{URL: srv.URL, CACert: string(ca)}).Apply(config))
+
+	resp, err := config.HTTPClient.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
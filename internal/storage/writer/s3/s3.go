@@ -30,19 +30,23 @@ type Writer struct {
 	bucket   string
 	prefix   string
 	sse      string
+	kmsKey   string // The KMS key encrypting the objects, for SSE-KMS (optional)
+	acl      string // The canned ACL of the objects, such as "bucket-owner-full-control" (optional)
 }
 
 // New initializes a new S3 writer.
-func New(bucket, prefix, region, endpoint, sse, access, secret string, concurrency int) (*Writer, error) {
+func New(bucket, prefix, region string, endpoint Endpoint, sse, access, secret string, concurrency int) (*Writer, error) {
 	if concurrency == 0 {
 		concurrency = runtime.NumCPU()
 	}
 
 	config := &aws.Config{
-		Region:           aws.String(region),
-		Endpoint:         aws.String(endpoint),
-		DisableSSL:       aws.Bool(strings.HasPrefix(endpoint, "http://")),
-		S3ForcePathStyle: aws.Bool(endpoint != ""),
+		Region: aws.String(region),
+	}
+
+	// Optionally use the endpoint of an S3-compatible store
+	if err := endpoint.Apply(config); err != nil {
+		return nil, err
 	}
 
 	// Optionally set static credentials
@@ -61,6 +65,21 @@ func New(bucket, prefix, region, endpoint, sse, access, secret string, concurren
 	}, nil
 }
 
+// SetKMSKey sets the ARN or the ID of the KMS key encrypting the objects on the server side, which enables
+// SSE-KMS unless another server-side encryption is set.
+func (w *Writer) SetKMSKey(kmsKey string) {
+	w.kmsKey = kmsKey
+	if w.sse == "" && kmsKey != "" {
+		w.sse = s3.ServerSideEncryptionAwsKms
+	}
+}
+
+// SetACL sets the canned ACL of the objects, such as "bucket-owner-full-control" when writing to a bucket owned
+// by another account.
+func (w *Writer) SetACL(acl string) {
+	w.acl = acl
+}
+
 // Write writes creates object of S3 bucket prefix key in S3Writer bucket with value val
 func (w *Writer) Write(key key.Key, val []byte) error {
 	uploadInput := &s3manager.UploadInput{
@@ -74,6 +93,14 @@ func (w *Writer) Write(key key.Key, val []byte) error {
 		uploadInput.ServerSideEncryption = aws.String(w.sse)
 	}
 
+	if w.kmsKey != "" {
+		uploadInput.SSEKMSKeyId = aws.String(w.kmsKey)
+	}
+
+	if w.acl != "" {
+		uploadInput.ACL = aws.String(w.acl)
+	}
+
 	// Upload to S3
 	if _, err := w.uploader.Upload(uploadInput); err != nil {
 		return errors.Internal("s3: unable to write", err)
@@ -6,13 +6,14 @@ package s3
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/kelindar/talaria/internal/encoding/key"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func TestS3Writer(t *testing.T) {
-	_, err := New("testBucket", "", "us-east-1", "", "", "", "", 128)
+	_, err := New("testBucket", "", "us-east-1", Endpoint{}, "", "", "", 128)
 
 	assert.Nil(t, err)
 }
@@ -32,3 +33,22 @@ func TestS3Writer_Write(t *testing.T) {
 
 	assert.Equal(t, err, nil)
 }
+
+func TestS3Writer_KMS(t *testing.T) {
+	mockUploader := &MockS3Uploader{}
+	mockUploader.On("Upload", mock.MatchedBy(func(input *s3manager.UploadInput) bool {
+		return *input.ServerSideEncryption == "aws:kms" &&
+			*input.SSEKMSKeyId == "arn:aws:kms:ap-southeast-1:123456789012:key/test" &&
+			*input.ACL == "bucket-owner-full-control"
+	})).Return(nil, nil).Once()
+
+	s3Writer := &Writer{
+		uploader: mockUploader,
+		bucket:   "testBucket",
+	}
+
+	s3Writer.SetKMSKey("arn:aws:kms:ap-southeast-1:123456789012:key/test")
+	s3Writer.SetACL("bucket-owner-full-control")
+	assert.NoError(t, s3Writer.Write(key.Key("testKey"), []byte("Test Upload Data")))
+	mockUploader.AssertExpectations(t)
+}
@@ -0,0 +1,115 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package snowflake
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const tokenLifetime = 59 * time.Minute // Snowflake rejects the tokens which expire in more than an hour
+
+// signer represents the key pair authentication of a user, issuing the JSON web tokens of the requests
+type signer struct {
+	lock    sync.Mutex
+	key     *rsa.PrivateKey  // The private key of the user
+	issuer  string           // The user qualified by the fingerprint of its public key
+	subject string           // The user qualified by its account
+	token   string           // The last token issued
+	expires time.Time        // The time at which the last token expires
+	now     func() time.Time // The clock
+}
+
+// newSigner loads the private key of the user
+func newSigner(account, user, privateKey string) (*signer, error) {
+	b, err := ioutil.ReadFile(privateKey)
+	if err != nil {
+		return nil, errors.Internal("snowflake: unable to read the private key", err)
+	}
+
+	key, err := parseKey(b)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, errors.Internal("snowflake: unable to encode the public key", err)
+	}
+
+	// The account is the account locator, without the region or the cloud
+	fingerprint := sha256.Sum256(public)
+	subject := strings.ToUpper(strings.Split(account, ".")[0] + "." + user)
+	return &signer{
+		key:     key,
+		issuer:  subject + ".SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		subject: subject,
+		now:     time.Now,
+	}, nil
+}
+
+// parseKey parses an unencrypted RSA private key, in either PKCS #8 or PKCS #1 format
+func parseKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("snowflake: the private key is not in PEM format")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Internal("snowflake: unable to parse the private key", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("snowflake: the private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Token returns a token authenticating the user, issuing a new one when the last one is about to expire
+func (s *signer) Token() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	if s.token != "" && now.Add(5*time.Minute).Before(s.expires) {
+		return s.token, nil
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": s.issuer,
+		"sub": s.subject,
+		"iat": now.Unix(),
+		"exp": now.Add(tokenLifetime).Unix(),
+	})
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Internal("snowflake: unable to sign a token", err)
+	}
+
+	s.token = payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+	s.expires = now.Add(tokenLifetime)
+	return s.token, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package snowflake
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const statementTimeout = 10 * time.Minute // The maximum duration of a COPY INTO
+
+// SubWriter represents the writer uploading the files to the location of the stage
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Writer represents a writer for Snowflake. Every file is uploaded to the location of an external stage, then
+// loaded into the table either by a COPY INTO executed through the SQL API, or by a Snowpipe through its REST
+// API. Since Snowflake keeps track of the files it loaded, a file which is uploaded and loaded again after a
+// failure is not loaded twice.
+type Writer struct {
+	client    *http.Client  // The HTTP client
+	endpoint  string        // The URL of the account
+	auth      *signer       // The key pair authentication of the user
+	table     string        // The fully qualified name of the table
+	stage     string        // The fully qualified name of the external stage
+	pipe      string        // The fully qualified name of the Snowpipe, if any
+	warehouse string        // The warehouse running the statements, if not the default one of the user
+	role      string        // The role running the statements, if not the default one of the user
+	dest      SubWriter     // The writer uploading the files to the location of the stage
+	poll      time.Duration // The interval at which a statement still running is polled
+}
+
+// New creates a new writer, authenticating the user with the private key at the path.
+func New(account, user, privateKey, table, stage string, dest SubWriter) (*Writer, error) {
+	auth, err := newSigner(account, user, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		client:   &http.Client{Timeout: time.Minute},
+		endpoint: "https://" + strings.ToLower(account) + ".snowflakecomputing.com",
+		auth:     auth,
+		table:    table,
+		stage:    stage,
+		dest:     dest,
+		poll:     time.Second,
+	}, nil
+}
+
+// SetPipe sets the Snowpipe loading the files, instead of a COPY INTO.
+func (w *Writer) SetPipe(pipe string) {
+	w.pipe = pipe
+}
+
+// SetSession sets the warehouse and the role running the COPY INTO, either of which is optional.
+func (w *Writer) SetSession(warehouse, role string) {
+	w.warehouse = warehouse
+	w.role = role
+}
+
+// Write uploads the file to the location of the stage and loads it into the table.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	if err := w.dest.Write(key, val); err != nil {
+		return err
+	}
+
+	if w.pipe != "" {
+		return w.ingest(string(key))
+	}
+	return w.load(string(key))
+}
+
+// load loads a file of the stage into the table, with a COPY INTO
+func (w *Writer) load(path string) error {
+	statement := fmt.Sprintf("COPY INTO %s FROM @%s FILES = ('%s') FILE_FORMAT = (TYPE = ORC) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE",
+		w.table, w.stage, strings.ReplaceAll(path, "'", "''"))
+
+	body, err := json.Marshal(request{
+		Statement: statement,
+		Timeout:   int(statementTimeout.Seconds()),
+		Warehouse: w.warehouse,
+		Role:      w.role,
+	})
+	if err != nil {
+		return errors.Internal("snowflake: unable to encode the statement", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statementTimeout)
+	defer cancel()
+
+	// A statement which does not complete within a few seconds runs asynchronously, and is polled
+	status, result, err := w.do(ctx, http.MethodPost, "/api/v2/statements", bytes.NewReader(body))
+	for err == nil && status == http.StatusAccepted {
+		select {
+		case <-ctx.Done():
+			return errors.Internal("snowflake: unable to copy "+path, ctx.Err())
+		case <-time.After(w.poll):
+			status, result, err = w.do(ctx, http.MethodGet, result.StatusURL, nil)
+		}
+	}
+
+	switch {
+	case err != nil:
+		return errors.Internal("snowflake: unable to copy "+path, err)
+	case status != http.StatusOK:
+		return errors.Newf("snowflake: unable to copy %s, %s", path, result.Message)
+	default:
+		return nil
+	}
+}
+
+// ingest submits a file of the stage to the Snowpipe, which loads it asynchronously
+func (w *Writer) ingest(path string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"files": []map[string]string{{"path": path}},
+	})
+	if err != nil {
+		return errors.Internal("snowflake: unable to encode the files", err)
+	}
+
+	uri := fmt.Sprintf("/v1/data/pipes/%s/insertFiles?requestId=%s", url.PathEscape(w.pipe), newRequestID())
+	switch status, result, err := w.do(context.Background(), http.MethodPost, uri, bytes.NewReader(body)); {
+	case err != nil:
+		return errors.Internal("snowflake: unable to ingest "+path, err)
+	case status != http.StatusOK:
+		return errors.Newf("snowflake: unable to ingest %s, %s", path, result.Message)
+	default:
+		return nil
+	}
+}
+
+// request represents a statement executed by the SQL API
+type request struct {
+	Statement string `json:"statement"`           // The SQL statement
+	Timeout   int    `json:"timeout"`             // The timeout of the statement, in seconds
+	Warehouse string `json:"warehouse,omitempty"` // The warehouse running the statement
+	Role      string `json:"role,omitempty"`      // The role running the statement
+}
+
+// response represents the response of the SQL or the Snowpipe API
+type response struct {
+	Message   string `json:"message"`            // The outcome of the request
+	StatusURL string `json:"statementStatusUrl"` // The URL polled for the status of a statement
+}
+
+// do sends an authenticated request to the account
+func (w *Writer) do(ctx context.Context, method, uri string, body io.Reader) (int, response, error) {
+	var result response
+	token, err := w.auth.Token()
+	if err != nil {
+		return 0, result, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, w.endpoint+uri, body)
+	if err != nil {
+		return 0, result, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "talaria")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, result, err
+	}
+
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, result, err
+	}
+
+	// The error responses are not necessarily encoded in JSON
+	if json.Unmarshal(b, &result) != nil || result.Message == "" {
+		result.Message = strings.TrimSpace(string(b))
+	}
+	return resp.StatusCode, result, nil
+}
+
+// newRequestID returns a random UUID, identifying a request to the Snowpipe
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
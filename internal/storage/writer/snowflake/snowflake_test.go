@@ -0,0 +1,168 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package snowflake
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryWriter records the files uploaded
+type memoryWriter map[string][]byte
+
+func (w memoryWriter) Write(k key.Key, v []byte) error {
+	w[string(k)] = v
+	return nil
+}
+
+func TestWriter_Copy(t *testing.T) {
+	pk, path := newKey(t)
+	defer os.Remove(path)
+
+	polled := false
+	var statement request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "KEYPAIR_JWT", r.Header.Get("X-Snowflake-Authorization-Token-Type"))
+		verify(t, &pk.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v2/statements":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&statement))
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"message":"Asynchronous execution in progress.","statementStatusUrl":"/api/v2/statements/abc"}`))
+		case "GET /api/v2/statements/abc":
+			polled = true
+			w.Write([]byte(`{"message":"Statement executed successfully."}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dest := memoryWriter{}
+	w, err := New("xy12345.ap-southeast-1", "talaria", path, "db.public.events", "db.public.stage", dest)
+	assert.NoError(t, err)
+	w.endpoint = server.URL
+	w.poll = 0
+	w.SetSession("loading", "")
+
+	assert.NoError(t, w.Write(key.Key("2020/01/01/event's.orc"), []byte("ORC...")))
+	assert.Contains(t, dest, "2020/01/01/event's.orc")
+	assert.True(t, polled)
+	assert.Equal(t, "loading", statement.Warehouse)
+	assert.Empty(t, statement.Role)
+	assert.Equal(t, "COPY INTO db.public.events FROM @db.public.stage FILES = ('2020/01/01/event''s.orc') "+
+		"FILE_FORMAT = (TYPE = ORC) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE", statement.Statement)
+}
+
+func TestWriter_Pipe(t *testing.T) {
+	_, path := newKey(t)
+	defer os.Remove(path)
+
+	var files []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/pipes/db.public.pipe/insertFiles" || r.URL.Query().Get("requestId") == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Specified object does not exist"))
+			return
+		}
+
+		var body struct {
+			Files []struct {
+				Path string `json:"path"`
+			} `json:"files"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		for _, f := range body.Files {
+			files = append(files, f.Path)
+		}
+		w.Write([]byte(`{"responseCode":"SUCCESS"}`))
+	}))
+	defer server.Close()
+
+	w, err := New("xy12345", "talaria", path, "db.public.events", "db.public.stage", memoryWriter{})
+	assert.NoError(t, err)
+	w.endpoint = server.URL
+
+	w.SetPipe("db.public.pipe")
+	assert.NoError(t, w.Write(key.Key("a.orc"), []byte("ORC...")))
+	assert.Equal(t, []string{"a.orc"}, files)
+
+	w.SetPipe("db.public.missing")
+	err = w.Write(key.Key("b.orc"), []byte("ORC..."))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Specified object does not exist")
+}
+
+func TestSigner(t *testing.T) {
+	pk, path := newKey(t)
+	defer os.Remove(path)
+
+	s, err := newSigner("xy12345.ap-southeast-1.aws", "talaria", path)
+	assert.NoError(t, err)
+
+	token, err := s.Token()
+	assert.NoError(t, err)
+	claims := verify(t, &pk.PublicKey, token)
+	assert.Equal(t, "XY12345.TALARIA", claims["sub"])
+	assert.True(t, strings.HasPrefix(claims["iss"].(string), "XY12345.TALARIA.SHA256:"))
+
+	// The token is reused until it is about to expire
+	again, err := s.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, token, again)
+
+	_, err = newSigner("xy12345", "talaria", filepath.Join(os.TempDir(), "missing.p8"))
+	assert.Error(t, err)
+}
+
+// newKey generates a private key, written to a temporary file in PKCS #8 format
+func newKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "snowflake")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	assert.NoError(t, pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return key, f.Name()
+}
+
+// verify verifies the signature of a token and returns its claims
+func verify(t *testing.T, key *rsa.PublicKey, token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+
+	var claims map[string]interface{}
+	assert.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
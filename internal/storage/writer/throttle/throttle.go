@@ -0,0 +1,173 @@
+package throttle
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const ctxTag = "throttle"
+
+// SubWriter represents the writer whose bandwidth is limited
+type SubWriter interface {
+	Write(key.Key, []byte) error
+}
+
+// Window represents a time window of the week with its own bandwidth, such as the business hours
+type Window struct {
+	From      time.Duration         // The start of the window, since midnight
+	Until     time.Duration         // The end of the window, since midnight, on the next day if not after the start
+	Days      map[time.Weekday]bool // The days on which the window starts, every day if empty
+	Bandwidth int64                 // The maximum bytes per second during the window
+}
+
+// Writer represents a writer which limits the bandwidth of the writes to the underlying writer, on average over
+// the files, by delaying each write until the previous ones fit within the bandwidth.
+type Writer struct {
+	sync.Mutex
+	dest      SubWriter             // The writer whose bandwidth is limited
+	name      string                // The name of the sink, reported with the metrics
+	monitor   monitor.Monitor       // The monitoring layer
+	bandwidth int64                 // The maximum bytes per second outside of the windows, unlimited if zero
+	windows   []Window              // The windows with their own bandwidth
+	location  *time.Location        // The timezone of the windows
+	next      time.Time             // The time from which the next write can start
+	now       func() time.Time      // The clock
+	sleep     func(d time.Duration) // The function waiting for the delay of a write
+}
+
+// New creates a new writer limiting the bandwidth of a sink, in bytes per second, unlimited if zero.
+func New(dest SubWriter, name string, bandwidth int64, monitor monitor.Monitor) *Writer {
+	return &Writer{
+		dest:      dest,
+		name:      name,
+		monitor:   monitor,
+		bandwidth: bandwidth,
+		location:  time.UTC,
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+}
+
+// SetTimezone sets the timezone of the windows, such as "Asia/Singapore", which is UTC by default.
+func (w *Writer) SetTimezone(timezone string) error {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return errors.Internal("throttle: unknown timezone "+timezone, err)
+	}
+
+	w.location = location
+	return nil
+}
+
+// AddWindow adds a window, from and until a time of the day formatted as "15:04", during which the bandwidth
+// is different. The days, such as "mon" or "sat", restrict the window to the days it starts on.
+func (w *Writer) AddWindow(from, until string, days []string, bandwidth int64) error {
+	window := Window{Bandwidth: bandwidth}
+	var err error
+	if window.From, err = parseTime(from); err != nil {
+		return err
+	}
+	if window.Until, err = parseTime(until); err != nil {
+		return err
+	}
+	if window.Days, err = parseDays(days); err != nil {
+		return err
+	}
+
+	w.windows = append(w.windows, window)
+	return nil
+}
+
+// Write writes the data to the underlying writer, once it fits within the bandwidth.
+func (w *Writer) Write(key key.Key, val []byte) error {
+	now := w.now()
+	bandwidth := w.bandwidthAt(now)
+	w.monitor.Gauge(ctxTag, "bandwidth", float64(bandwidth), "sink:"+w.name)
+	if bandwidth <= 0 {
+		return w.dest.Write(key, val)
+	}
+
+	// Reserve the time needed to transfer the data, after the previous writes
+	w.Lock()
+	start := w.next
+	if start.Before(now) {
+		start = now
+	}
+	w.next = start.Add(time.Duration(float64(len(val)) / float64(bandwidth) * float64(time.Second)))
+	w.Unlock()
+
+	delay := start.Sub(now)
+	w.monitor.Gauge(ctxTag, "delay", delay.Seconds(), "sink:"+w.name)
+	if delay > 0 {
+		w.monitor.Count1(ctxTag, "throttled", "sink:"+w.name)
+		w.sleep(delay)
+	}
+
+	return w.dest.Write(key, val)
+}
+
+// bandwidthAt returns the bandwidth at a point in time, the one of the first window containing it if any
+func (w *Writer) bandwidthAt(t time.Time) int64 {
+	t = t.In(w.location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.location)
+	elapsed := t.Sub(midnight)
+	yesterday := midnight.AddDate(0, 0, -1).Weekday()
+	for _, window := range w.windows {
+		switch {
+		case window.From < window.Until && elapsed >= window.From && elapsed < window.Until && window.on(t.Weekday()):
+			return window.Bandwidth
+		case window.From >= window.Until && elapsed >= window.From && window.on(t.Weekday()):
+			return window.Bandwidth // Started today, ends tomorrow
+		case window.From >= window.Until && elapsed < window.Until && window.on(yesterday):
+			return window.Bandwidth // Started yesterday, ends today
+		}
+	}
+	return w.bandwidth
+}
+
+// on returns whether the window starts on a day of the week
+func (w *Window) on(day time.Weekday) bool {
+	return len(w.Days) == 0 || w.Days[day]
+}
+
+// Close closes the underlying writer.
+func (w *Writer) Close() error {
+	if closer, ok := w.dest.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// parseTime parses a time of the day formatted as "15:04" into the duration since midnight.
+func parseTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.Internal("throttle: invalid time of the day "+value, err)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseDays parses the days of the week, such as "mon" or "sunday".
+func parseDays(days []string) (map[time.Weekday]bool, error) {
+	out := make(map[time.Weekday]bool, len(days))
+	for _, day := range days {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if v := strings.ToLower(day); v == name || v == name[:3] {
+				out[d], found = true, true
+			}
+		}
+
+		if !found {
+			return nil, errors.New("throttle: invalid day of the week " + day)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+// sink represents a sink counting the bytes written
+type sink int
+
+func (s *sink) Write(_ key.Key, val []byte) error {
+	*s += sink(len(val))
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	var delays []time.Duration
+	dest := new(sink)
+	w := New(dest, "s3", 100, monitor.NewNoop())
+	w.now = func() time.Time { return now }
+	w.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	// Each write waits for the previous ones to fit within the bandwidth
+	assert.NoError(t, w.Write(key.Key("a"), make([]byte, 200)))
+	assert.NoError(t, w.Write(key.Key("b"), make([]byte, 100)))
+	assert.NoError(t, w.Write(key.Key("c"), make([]byte, 100)))
+	assert.Equal(t, []time.Duration{2 * time.Second, 3 * time.Second}, delays)
+	assert.Equal(t, sink(400), *dest)
+
+	// Once the bandwidth is available again, the write is immediate
+	now = now.Add(time.Minute)
+	assert.NoError(t, w.Write(key.Key("d"), make([]byte, 100)))
+	assert.Len(t, delays, 2)
+}
+
+func TestBandwidthAt(t *testing.T) {
+	w := New(new(sink), "s3", 1000, monitor.NewNoop())
+	assert.NoError(t, w.SetTimezone("Asia/Singapore"))
+	assert.NoError(t, w.AddWindow("09:00", "18:00", []string{"mon", "Tuesday"}, 10))
+	assert.NoError(t, w.AddWindow("22:00", "06:00", []string{"fri"}, 0))
+	assert.Error(t, w.AddWindow("9am", "18:00", nil, 10))
+	assert.Error(t, w.AddWindow("09:00", "18:00", []string{"someday"}, 10))
+	assert.Error(t, w.SetTimezone("Mars/Olympus"))
+
+	sgt, _ := time.LoadLocation("Asia/Singapore")
+	for at, expect := range map[time.Time]int64{
+		time.Date(2020, 1, 6, 10, 0, 0, 0, sgt):     10,   // Monday, business hours
+		time.Date(2020, 1, 6, 2, 0, 0, 0, time.UTC): 10,   // Monday, business hours in Singapore
+		time.Date(2020, 1, 6, 18, 0, 0, 0, sgt):     1000, // Monday, after the business hours
+		time.Date(2020, 1, 8, 10, 0, 0, 0, sgt):     1000, // Wednesday
+		time.Date(2020, 1, 10, 23, 0, 0, 0, sgt):    0,    // Friday night
+		time.Date(2020, 1, 11, 5, 0, 0, 0, sgt):     0,    // Saturday morning, in the window of Friday
+		time.Date(2020, 1, 11, 23, 0, 0, 0, sgt):    1000, // Saturday night
+	} {
+		assert.Equal(t, expect, w.bandwidthAt(at), at.String())
+	}
+}
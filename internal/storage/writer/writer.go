@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"hash/maphash"
+	"os"
 	"sort"
 	"time"
 
 	"github.com/kelindar/talaria/internal/column"
 	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/sketch"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	"github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/monitor/errors"
@@ -16,15 +18,27 @@ import (
 	"github.com/kelindar/talaria/internal/storage"
 	"github.com/kelindar/talaria/internal/storage/compact"
 	"github.com/kelindar/talaria/internal/storage/flush"
+	"github.com/kelindar/talaria/internal/storage/writer/adls"
 	"github.com/kelindar/talaria/internal/storage/writer/azure"
 	"github.com/kelindar/talaria/internal/storage/writer/bigquery"
+	"github.com/kelindar/talaria/internal/storage/writer/breaker"
+	"github.com/kelindar/talaria/internal/storage/writer/catalog"
+	"github.com/kelindar/talaria/internal/storage/writer/clickhouse"
+	"github.com/kelindar/talaria/internal/storage/writer/elastic"
+	"github.com/kelindar/talaria/internal/storage/writer/envelope"
 	"github.com/kelindar/talaria/internal/storage/writer/file"
 	"github.com/kelindar/talaria/internal/storage/writer/gcs"
+	"github.com/kelindar/talaria/internal/storage/writer/hdfs"
+	"github.com/kelindar/talaria/internal/storage/writer/kafka"
 	"github.com/kelindar/talaria/internal/storage/writer/multi"
 	"github.com/kelindar/talaria/internal/storage/writer/noop"
+	"github.com/kelindar/talaria/internal/storage/writer/postgres"
 	"github.com/kelindar/talaria/internal/storage/writer/pubsub"
+	"github.com/kelindar/talaria/internal/storage/writer/retry"
 	"github.com/kelindar/talaria/internal/storage/writer/s3"
+	"github.com/kelindar/talaria/internal/storage/writer/snowflake"
 	"github.com/kelindar/talaria/internal/storage/writer/talaria"
+	"github.com/kelindar/talaria/internal/storage/writer/throttle"
 )
 
 var seed = maphash.MakeSeed()
@@ -41,11 +55,28 @@ func ForStreaming(config config.Streams, monitor monitor.Monitor, loader *script
 
 // ForCompaction creates a compaction writer
 func ForCompaction(config *config.Compaction, monitor monitor.Monitor, store storage.Storage, loader *script.Loader) (*compact.Storage, error) {
-	writer, err := newWriter(config.Sinks, loader)
+	writer, err := newWriter(config.Sinks, config.Throttle, config.Breaker, monitor, loader)
 	if err != nil {
 		return nil, err
 	}
 
+	// Retry the failed writes and spill them into the dead-letter directory, if configured
+	if config.Retry != nil {
+		if writer, err = withRetry(writer, config.Retry, monitor); err != nil {
+			return nil, err
+		}
+	}
+
+	// Register the partitions of the files in the catalog, if configured
+	if c := config.Catalog; c != nil {
+		switch {
+		case c.Glue != nil:
+			writer = catalog.New(writer, catalog.NewGlue(c.Glue.Region, c.Glue.CatalogID, c.Database, c.Table), monitor)
+		case c.Hive != nil:
+			writer = catalog.New(writer, catalog.NewHive(c.Hive.Address, c.Database, c.Table), monitor)
+		}
+	}
+
 	// Configure the flush interval, default to 30s
 	interval := 30 * time.Second
 	if config.Interval > 0 {
@@ -72,25 +103,69 @@ func ForCompaction(config *config.Compaction, monitor monitor.Monitor, store sto
 	monitor.Info("server: setting up compaction %T to run every %.0fs...", writer, interval.Seconds())
 
 	// TODO: once we have everything working, consider making the flusher per writer (requires changing all writers)
-	flusher, err := flush.ForCompaction(monitor, writer, config.Encoder, nameFunc)
+	flusher, err := flush.ForCompaction(monitor, writer, config.Encoder, config.Compression, nameFunc)
 	if err != nil {
 		return nil, err
 	}
 
-	return compact.New(store, flusher, monitor, interval), nil
+	// Write the manifest of the files after each compaction window, if configured
+	if config.Manifest != nil {
+		flusher.SetManifest(config.Manifest.Column, config.Manifest.Success)
+	}
+
+	// Write the distinct-count sketches of the columns after each compaction window, if configured
+	if k := config.Sketches; k != nil {
+		precision := uint8(k.Precision)
+		if precision == 0 {
+			precision = sketch.DefaultPrecision
+		}
+
+		if err := flusher.SetSketches(k.Columns, k.Column, time.Duration(k.Window)*time.Second, precision); err != nil {
+			return nil, err
+		}
+	}
+
+	// Name the files after the buffered data, so a compaction retried after a crash overwrites the same files
+	if config.Deterministic {
+		epoch, err := epochOf(store)
+		if err != nil {
+			return nil, err
+		}
+
+		flusher.SetDeterministic(epoch, interval, config.NameFunc == "")
+	}
+
+	compactor := compact.New(store, flusher, monitor, interval)
+	compactor.SetMaxSize(config.MaxSize)
+	compactor.SetConcurrency(config.Concurrency)
+	return compactor, nil
 }
 
 // NewWriter creates a new writer from the configuration.
-func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error) {
+func newWriter(config config.Sinks, throttles map[string]*config.Throttle, breaker *config.Breaker, monitor monitor.Monitor, loader *script.Loader) (flush.Writer, error) {
 	var writers []multi.SubWriter
 
 	// Configure S3 writer if present
 	if config.S3 != nil {
-		w, err := s3.New(config.S3.Bucket, config.S3.Prefix, config.S3.Region, config.S3.Endpoint, config.S3.SSE, config.S3.AccessKey, config.S3.SecretKey, config.S3.Concurrency)
+		endpoint := s3.Endpoint{
+			URL:        config.S3.Endpoint,
+			PathStyle:  config.S3.PathStyle,
+			DisableSSL: config.S3.DisableSSL,
+			CACert:     config.S3.CACert,
+		}
+
+		w, err := s3.New(config.S3.Bucket, config.S3.Prefix, config.S3.Region, endpoint, config.S3.SSE, config.S3.AccessKey, config.S3.SecretKey, config.S3.Concurrency)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+
+		w.SetKMSKey(config.S3.KMSKey)
+		w.SetACL(config.S3.ACL)
+		encrypted, err := withEncryption(w, config.S3.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, withBreaker("s3", withThrottle("s3", encrypted, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure Azure writer if present
@@ -99,7 +174,16 @@ func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+		writers = append(writers, withBreaker("azure", withThrottle("azure", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure Azure Data Lake Storage Gen2 writer if present
+	if config.ADLS != nil {
+		w, err := adls.New(config.ADLS.Account, config.ADLS.Filesystem, config.ADLS.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, withBreaker("adls", withThrottle("adls", w, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure GCS writer if present
@@ -108,16 +192,23 @@ func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+
+		w.SetKMSKey(config.GCS.KMSKey)
+		w.SetACL(config.GCS.ACL)
+		encrypted, err := withEncryption(w, config.GCS.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, withBreaker("gcs", withThrottle("gcs", encrypted, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure BigQuery writer if present
 	if config.BigQuery != nil {
-		w, err := bigquery.New(config.BigQuery.Project, config.BigQuery.Dataset, config.BigQuery.Table)
+		w, err := bigquery.New(config.BigQuery.Project, config.BigQuery.Dataset, config.BigQuery.Table, monitor)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+		writers = append(writers, withBreaker("bigquery", withThrottle("bigquery", w, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure File writer if present
@@ -126,7 +217,7 @@ func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+		writers = append(writers, withBreaker("file", withThrottle("file", w, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure Talaria writer if present
@@ -135,16 +226,113 @@ func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+		writers = append(writers, withBreaker("talaria", withThrottle("talaria", w, throttles, monitor), breaker, monitor))
 	}
 
 	// Configure Google Pub/Sub writer if present
 	if config.PubSub != nil {
-		w, err := pubsub.New(config.PubSub.Project, config.PubSub.Topic, config.PubSub.Encoder, config.PubSub.Filter, loader, nil)
+		w, err := pubsub.New(config.PubSub.Project, config.PubSub.Topic, config.PubSub.Encoder, config.PubSub.Filter, loader, monitor)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, w)
+
+		w.SetOrdering(config.PubSub.OrderingKey)
+		w.SetAttributes(config.PubSub.Attributes)
+		if fc := config.PubSub.FlowControl; fc != nil {
+			w.SetFlowControl(fc.MaxOutstandingMessages, fc.MaxOutstandingBytes, fc.LimitExceeded == "block")
+		}
+		writers = append(writers, withBreaker("pubsub", withThrottle("pubsub", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure Snowflake writer if present, uploading the files to the location of its stage
+	if config.Snowflake != nil {
+		upload, err := newWriter(config.Snowflake.Upload, nil, nil, monitor, loader)
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := snowflake.New(config.Snowflake.Account, config.Snowflake.User, config.Snowflake.PrivateKey, config.Snowflake.Table, config.Snowflake.Stage, upload)
+		if err != nil {
+			return nil, err
+		}
+
+		w.SetPipe(config.Snowflake.Pipe)
+		w.SetSession(config.Snowflake.Warehouse, config.Snowflake.Role)
+		writers = append(writers, withBreaker("snowflake", withThrottle("snowflake", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure ClickHouse writer if present
+	if config.ClickHouse != nil {
+		w, err := clickhouse.New(config.ClickHouse.Endpoints, config.ClickHouse.Table, config.ClickHouse.User, config.ClickHouse.Password, monitor)
+		if err != nil {
+			return nil, err
+		}
+
+		w.SetEngine(config.ClickHouse.Engine)
+		writers = append(writers, withBreaker("clickhouse", withThrottle("clickhouse", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure HDFS writer if present
+	if config.HDFS != nil {
+		w, err := hdfs.New(config.HDFS.Endpoints, config.HDFS.Directory)
+		if err != nil {
+			return nil, err
+		}
+
+		w.SetUser(config.HDFS.User)
+		if k := config.HDFS.Kerberos; k != nil {
+			w.SetKerberos(hdfs.NewKerberos(k.CCache, k.Config, k.Service))
+		}
+		writers = append(writers, withBreaker("hdfs", withThrottle("hdfs", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure PostgreSQL writer if present, staging the files to copy them into Redshift
+	if config.Postgres != nil {
+		w, err := postgres.New(config.Postgres.Address, config.Postgres.Database, config.Postgres.User, config.Postgres.Password, config.Postgres.Table, monitor)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := w.SetSSLMode(config.Postgres.SSLMode); err != nil {
+			return nil, err
+		}
+
+		if r := config.Postgres.Redshift; r != nil {
+			upload, err := newWriter(r.Upload, nil, nil, monitor, loader)
+			if err != nil {
+				return nil, err
+			}
+
+			w.SetRedshift(upload, r.Location, r.IAMRole, r.Region)
+		}
+		writers = append(writers, withBreaker("postgres", withThrottle("postgres", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure Elasticsearch writer if present
+	if config.Elastic != nil {
+		w, err := elastic.New(config.Elastic.Endpoints, config.Elastic.Index, config.Elastic.TimeColumn, config.Elastic.User, config.Elastic.Password, monitor)
+		if err != nil {
+			return nil, err
+		}
+
+		w.SetTemplate(config.Elastic.Template)
+		writers = append(writers, withBreaker("elastic", withThrottle("elastic", w, throttles, monitor), breaker, monitor))
+	}
+
+	// Configure Kafka writer if present
+	if config.Kafka != nil {
+		w, err := kafka.New(config.Kafka.Brokers, config.Kafka.Topic, config.Kafka.Encoder, config.Kafka.Filter, loader, monitor)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := w.SetCompression(config.Kafka.Compression); err != nil {
+			return nil, err
+		}
+
+		w.SetKey(config.Kafka.Key)
+		w.SetIdempotent(config.Kafka.Idempotent)
+		writers = append(writers, withBreaker("kafka", withThrottle("kafka", w, throttles, monitor), breaker, monitor))
 	}
 
 	// If no writers were configured, error out
@@ -156,6 +344,63 @@ func newWriter(config config.Sinks, loader *script.Loader) (flush.Writer, error)
 	return multi.New(writers...), nil
 }
 
+// withEncryption wraps the writer so that the files are encrypted before being written, if configured
+func withEncryption(w multi.SubWriter, conf *config.Encryption) (multi.SubWriter, error) {
+	if conf == nil {
+		return w, nil
+	}
+	return envelope.New(conf.Key, w)
+}
+
+// withThrottle wraps the writer of a sink so that its bandwidth is limited, if configured
+func withThrottle(name string, w multi.SubWriter, throttles map[string]*config.Throttle, monitor monitor.Monitor) multi.SubWriter {
+	conf, ok := throttles[name]
+	if !ok || conf == nil {
+		return w
+	}
+
+	t := throttle.New(w, name, conf.Bandwidth, monitor)
+	if conf.Timezone != "" {
+		if err := t.SetTimezone(conf.Timezone); err != nil {
+			monitor.Error(err)
+		}
+	}
+
+	for _, window := range conf.Windows {
+		if err := t.AddWindow(window.From, window.Until, window.Days, window.Bandwidth); err != nil {
+			monitor.Error(err)
+		}
+	}
+	return t
+}
+
+// withBreaker wraps the writer of a sink with a circuit breaker, if configured
+func withBreaker(name string, w multi.SubWriter, conf *config.Breaker, monitor monitor.Monitor) multi.SubWriter {
+	if conf == nil {
+		return w
+	}
+	return breaker.New(w, name, conf.Failures, time.Duration(conf.Cooldown)*time.Second, monitor)
+}
+
+// withRetry wraps the writer with the retry policy, spilling into the dead-letter directory if configured
+func withRetry(w flush.Writer, conf *config.Retry, monitor monitor.Monitor) (flush.Writer, error) {
+	r := retry.New(w, monitor)
+	r.SetBackoff(conf.MaxAttempts, time.Duration(conf.Backoff)*time.Second, time.Duration(conf.MaxBackoff)*time.Second, conf.Jitter)
+	if conf.DeadLetter == "" {
+		return r, nil
+	}
+
+	interval := time.Minute
+	if conf.ReplayInterval > 0 {
+		interval = time.Duration(conf.ReplayInterval) * time.Second
+	}
+
+	if err := r.SetDeadLetter(conf.DeadLetter, interval); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // newStreamer creates a new streamer from the configuration.
 func newStreamer(config config.Streams, monitor monitor.Monitor, loader *script.Loader) (flush.Writer, error) {
 	var writers []multi.SubWriter
@@ -166,7 +411,7 @@ func newStreamer(config config.Streams, monitor monitor.Monitor, loader *script.
 	}
 
 	for _, v := range config {
-		w, err := newWriter(v, loader)
+		w, err := newWriter(v, nil, nil, monitor, loader)
 		if err != nil {
 			return noop.New(), err
 		}
@@ -179,6 +424,15 @@ func newStreamer(config config.Streams, monitor monitor.Monitor, loader *script.
 	return multiWriters, err
 }
 
+// epochOf returns the epoch of the buffer, which tells apart the data buffered by the nodes, or the host name
+// if the buffer has none
+func epochOf(store storage.Storage) (string, error) {
+	if epoch, ok := store.(interface{ Epoch() (string, error) }); ok {
+		return epoch.Epoch()
+	}
+	return os.Hostname()
+}
+
 // defaultNameFunc represents a default name function
 func defaultNameFunc(row map[string]interface{}) (s string, e error) {
 	return fmt.Sprintf("%s-%x.orc",
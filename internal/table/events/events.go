@@ -0,0 +1,189 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/writer"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/system"
+	"github.com/kelindar/talaria/internal/table/timeseries"
+)
+
+// Assert the contract
+var _ table.Table = new(Table)
+var _ logging.Logger = new(Table)
+var _ logging.Structured = new(Table)
+
+const defaultTTL = 24 * 3600 // 1 day
+
+// Membership represents a contract required for recovering cluster information.
+type Membership interface {
+	Members() []string
+	Addr() string
+}
+
+// Table represents a table of the operational events of the node, such as the compactions, the ingestion
+// errors or the slow queries. It is a logger which only records the structured entries marked as events.
+type Table struct {
+	timeseries.Table
+	cluster Membership
+}
+
+// New creates a new table implementation.
+func New(cfg config.Func, cluster Membership, monitor monitor.Monitor) *Table {
+	const name = "events"
+
+	// Open a dedicated events storage, which does not clash with a user table named "events"
+	store := disk.Open(cfg().Storage.Directory, system.Schema+"_"+name, monitor, cfg().Storage.Badger)
+
+	// Create a noop streamer
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+
+	ttl := int64(defaultTTL)
+	if c := cfg().Events; c != nil && c.TTL > 0 {
+		ttl = c.TTL
+	}
+
+	base := timeseries.New(system.Schema+"."+name, cluster, monitor, store, &config.Table{
+		TTL:    ttl,
+		SortBy: "time",
+		HashBy: "",
+		Schema: "",
+	}, streams)
+	return &Table{
+		Table:   *base,
+		cluster: cluster,
+	}
+}
+
+// Errorf ignores the message, since it is not an event.
+func (t *Table) Errorf(f string, v ...interface{}) {}
+
+// Warningf ignores the message, since it is not an event.
+func (t *Table) Warningf(f string, v ...interface{}) {}
+
+// Infof ignores the message, since it is not an event.
+func (t *Table) Infof(f string, v ...interface{}) {}
+
+// Debugf ignores the message, since it is not an event.
+func (t *Table) Debugf(f string, v ...interface{}) {}
+
+// Log records the entry if it is marked as an event, and ignores it otherwise.
+func (t *Table) Log(level logging.Level, msg string, fields ...logging.Field) {
+	_ = t.Append(level, msg, fields...)
+}
+
+// Append converts the event to a block and appends it to the timeseries
+func (t *Table) Append(level logging.Level, msg string, fields ...logging.Field) error {
+	columns, ok := t.toColumns(level, msg, fields)
+	if !ok {
+		return nil
+	}
+
+	block, err := block.FromColumns("", columns)
+	if err != nil {
+		return err
+	}
+
+	return t.Table.Append(block)
+}
+
+// toColumns converts the event to columns. The well-known fields each have their own column, while the
+// others are kept as a JSON object.
+func (t *Table) toColumns(level logging.Level, msg string, fields []logging.Field) (column.Columns, bool) {
+	var event, tableName, errText string
+	var duration float64
+	var rows, size int64
+	others := make(map[string]interface{})
+	for _, f := range fields {
+		switch f.Key {
+		case logging.EventKey:
+			event = fmt.Sprint(f.Value)
+		case "table":
+			tableName = fmt.Sprint(f.Value)
+		case "error":
+			errText = fmt.Sprint(f.Value)
+		case "duration":
+			duration = millisOf(f.Value)
+		case "rows":
+			rows = int64Of(f.Value)
+		case "bytes":
+			size = int64Of(f.Value)
+		default:
+			others[f.Key] = valueOf(f.Value)
+		}
+	}
+
+	if event == "" {
+		return nil, false
+	}
+
+	extra, _ := json.Marshal(others)
+	columns := make(column.Columns, 11)
+	columns.Append("time", time.Now(), typeof.Timestamp)
+	columns.Append("address", t.cluster.Addr(), typeof.String)
+	columns.Append("event", event, typeof.String)
+	columns.Append("level", string(level), typeof.String)
+	columns.Append("message", msg, typeof.String)
+	columns.Append("table", tableName, typeof.String)
+	columns.Append("duration", duration, typeof.Float64)
+	columns.Append("rows", rows, typeof.Int64)
+	columns.Append("bytes", size, typeof.Int64)
+	columns.Append("error", errText, typeof.String)
+	columns.Append("fields", string(extra), typeof.JSON)
+	return columns, true
+}
+
+// millisOf converts a duration to milliseconds
+func millisOf(v interface{}) float64 {
+	switch d := v.(type) {
+	case time.Duration:
+		return float64(d) / float64(time.Millisecond)
+	case float64:
+		return d
+	default:
+		return float64(int64Of(v))
+	}
+}
+
+// int64Of converts an integer of any size to int64
+func int64Of(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case uint32:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
+
+// valueOf converts a value so it can be encoded as JSON
+func valueOf(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Duration:
+		return x.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package events
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/stretchr/testify/assert"
+)
+
+type noopMembership int
+
+func (m noopMembership) Members() []string {
+	return []string{"127.0.0.1"}
+}
+
+func (m noopMembership) Addr() string {
+	return "127.0.0.1"
+}
+
+type mockConfigurer struct {
+	dir string
+}
+
+func (m *mockConfigurer) Configure(c *config.Config) error {
+	c.Storage.Directory = m.dir
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "testevents-")
+	assert.NoError(t, err)
+
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cfg := config.Load(context.Background(), 60*time.Second, &mockConfigurer{
+		dir: dir,
+	})
+
+	events := New(cfg, new(noopMembership), monitor.NewNoop())
+	assert.NotNil(t, events)
+	assert.Equal(t, "system.events", events.Name())
+	defer events.Close()
+
+	// The events must not receive the ingested data
+	_, ok := interface{}(events).(table.Appender)
+	assert.False(t, ok)
+
+	// Only the entries marked as events are recorded
+	{
+		events.Infof("not an event")
+		events.Log(logging.LevelInfo, "not an event either", logging.F("table", "eventlog"))
+		events.Log(logging.LevelInfo, "compact: compaction completed", logging.Event("compaction"),
+			logging.F("table", "eventlog"), logging.F("duration", 1500*time.Millisecond), logging.F("blocks", int64(3)))
+		events.Log(logging.LevelWarning, "server: unable to ingest", logging.Event("ingest.error"),
+			logging.F("table", "eventlog"), logging.F("error", errors.New("boom")))
+	}
+
+	// Get the schema
+	{
+		schema, static := events.Schema()
+		assert.False(t, static)
+		assert.Len(t, schema, 11)
+	}
+
+	// Get the rows
+	{
+		splits, err := events.GetSplits([]string{}, newSplitQuery("eventlog", "table"), 10000)
+		assert.NoError(t, err)
+		assert.Len(t, splits, 1)
+
+		page, err := events.GetRows(splits[0].Key, []string{"event", "duration", "error", "fields"}, 1*1024*1024)
+		assert.NoError(t, err)
+		assert.Len(t, page.Columns, 4)
+		assert.Equal(t, 2, page.Columns[0].Count())
+
+		ed := page.Columns[0].AsThrift().VarcharData
+		assert.Equal(t, "compaction", string(ed.Bytes[:ed.Sizes[0]]))
+		assert.Equal(t, "ingest.error", string(ed.Bytes[ed.Sizes[0]:ed.Sizes[0]+ed.Sizes[1]]))
+
+		dd := page.Columns[1].AsThrift().DoubleData
+		assert.Equal(t, 1500.0, dd.Doubles[0])
+
+		rd := page.Columns[2].AsThrift().VarcharData
+		assert.Equal(t, "boom", string(rd.Bytes[rd.Sizes[0]:rd.Sizes[0]+rd.Sizes[1]]))
+
+		fd := page.Columns[3].AsThrift().JsonData
+		assert.Equal(t, `{"blocks":3}`, string(fd.Bytes[:fd.Sizes[0]]))
+	}
+}
+
+func newSplitQuery(value, colName string) *presto.PrestoThriftTupleDomain {
+	return &presto.PrestoThriftTupleDomain{
+		Domains: map[string]*presto.PrestoThriftDomain{
+			colName: {
+				ValueSet: &presto.PrestoThriftValueSet{
+					RangeValueSet: &presto.PrestoThriftRangeValueSet{
+						Ranges: []*presto.PrestoThriftRange{{
+							Low: &presto.PrestoThriftMarker{
+								Value: &presto.PrestoThriftBlock{
+									VarcharData: &presto.PrestoThriftVarchar{
+										Bytes: []byte(value),
+										Sizes: []int32{int32(len(value))},
+									},
+								},
+								Bound: presto.PrestoThriftBoundExactly,
+							},
+							High: &presto.PrestoThriftMarker{
+								Value: &presto.PrestoThriftBlock{
+									VarcharData: &presto.PrestoThriftVarchar{
+										Bytes: []byte(value),
+										Sizes: []int32{int32(len(value))},
+									},
+								},
+								Bound: presto.PrestoThriftBoundExactly,
+							},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
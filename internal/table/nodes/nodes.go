@@ -28,6 +28,11 @@ type Membership interface {
 	Addr() string
 }
 
+// Zones represents a contract which returns the availability zone of the members of the cluster.
+type Zones interface {
+	ZoneOf(addr string) string
+}
+
 // Table represents a nodes table.
 type Table struct {
 	cluster   Membership // The membership list to use
@@ -61,6 +66,7 @@ func (t *Table) Schema() (typeof.Schema, bool) {
 		"started": typeof.Int64,
 		"uptime":  typeof.String,
 		"peers":   typeof.String,
+		"zone":    typeof.String,
 	}, true
 }
 
@@ -125,10 +131,20 @@ func (t *Table) getColumn(columnName string, columnType typeof.Type) (presto.Col
 		column.Append(durafmt.Parse(time.Now().Sub(t.startedAt)).String())
 	case "peers":
 		column.Append(encode(t.cluster.Members()))
+	case "zone":
+		column.Append(t.zone())
 	}
 	return column, nil
 }
 
+// zone returns the availability zone of the node, if known
+func (t *Table) zone() string {
+	if zones, ok := t.cluster.(Zones); ok {
+		return zones.ZoneOf(t.cluster.Addr())
+	}
+	return ""
+}
+
 // Formats the set of addresses
 func formatAddrs(addrs []net.IPAddr, err error) string {
 	if err != nil {
@@ -20,6 +20,10 @@ func (m noopMembership) Addr() string {
 	return "127.0.0.1:8080"
 }
 
+func (m noopMembership) ZoneOf(addr string) string {
+	return "ap-southeast-1a"
+}
+
 func TestNodes(t *testing.T) {
 	table := nodes.New(new(noopMembership))
 	assert.NotNil(t, table)
@@ -29,7 +33,7 @@ func TestNodes(t *testing.T) {
 	// Get the schema
 	schema, static := table.Schema()
 	assert.True(t, static)
-	assert.Len(t, schema, 6)
+	assert.Len(t, schema, 7)
 
 	// Get the splits
 	splits, err := table.GetSplits([]string{}, nil, 10000)
@@ -38,15 +42,16 @@ func TestNodes(t *testing.T) {
 	assert.Equal(t, "127.0.0.1", splits[0].Addrs[0])
 
 	// Get the rows
-	page, err := table.GetRows(splits[0].Key, []string{"private", "uptime", "started", "public", "peers", "address"}, 1*1024*1024)
+	page, err := table.GetRows(splits[0].Key, []string{"private", "uptime", "started", "public", "peers", "address", "zone"}, 1*1024*1024)
 	assert.NotNil(t, page)
 	assert.NoError(t, err)
-	assert.Len(t, page.Columns, 6)
+	assert.Len(t, page.Columns, 7)
 	assert.NotEmpty(t, page.Columns[0].AsThrift().VarcharData.Bytes)
 	assert.Contains(t, string(page.Columns[1].AsThrift().VarcharData.Bytes), "seconds")
 	assert.Equal(t, 1, page.Columns[2].AsThrift().BigintData.Count())
 	assert.Equal(t, `["127.0.0.1"]`, string(page.Columns[4].AsThrift().VarcharData.Bytes))
 	assert.Equal(t, `127.0.0.1:8080`, string(page.Columns[5].AsThrift().VarcharData.Bytes))
+	assert.Equal(t, `ap-southeast-1a`, string(page.Columns[6].AsThrift().VarcharData.Bytes))
 }
 
 func TestNodes_NoColumn(t *testing.T) {
@@ -0,0 +1,273 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package system
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kelindar/talaria/internal/column"
+	"github.com/kelindar/talaria/internal/encoding/typeof"
+	"github.com/kelindar/talaria/internal/presto"
+	"github.com/kelindar/talaria/internal/server/cluster"
+	"github.com/kelindar/talaria/internal/server/slowlog"
+	"github.com/kelindar/talaria/internal/table"
+)
+
+// Schema is the name of the schema under which the system tables are exposed.
+const Schema = "system"
+
+// Assert the contract
+var _ table.Table = new(Table)
+
+// SplitKey is not required for these tables
+var splitKey = []byte{0x00}
+
+// Catalog represents a contract required for listing the tables served by the node.
+type Catalog interface {
+	Tables() []table.Table
+	SchemaOf(name string) (schema, table string)
+	SlowQueries() []slowlog.Entry
+}
+
+// Membership represents a contract required for recovering cluster information.
+type Membership interface {
+	Members() []string
+	Addr() string
+}
+
+// Ownership represents a contract required for recovering the ranges of hash keys owned by the nodes, when
+// the keys are assigned with a consistent hash ring.
+type Ownership interface {
+	Ranges(addr string) []cluster.Range
+}
+
+// New creates the set of system tables which expose the catalog and the cluster.
+func New(catalog Catalog, cluster Membership) []table.Table {
+	return []table.Table{
+		newTable("tables", cluster, typeof.Schema{
+			"schema":  typeof.String,
+			"table":   typeof.String,
+			"hash_by": typeof.String,
+			"sort_by": typeof.String,
+			"static":  typeof.Bool,
+			"columns": typeof.Int32,
+		}, func(out column.Columns) {
+			for _, t := range catalog.Tables() {
+				schemaName, tableName := catalog.SchemaOf(t.Name())
+				schema, static := t.Schema()
+				out["schema"].Append(schemaName)
+				out["table"].Append(tableName)
+				out["hash_by"].Append(t.HashBy())
+				out["sort_by"].Append(t.SortBy())
+				out["static"].Append(static)
+				out["columns"].Append(int32(len(schema)))
+			}
+		}),
+
+		newTable("columns", cluster, typeof.Schema{
+			"schema": typeof.String,
+			"table":  typeof.String,
+			"column": typeof.String,
+			"type":   typeof.String,
+		}, func(out column.Columns) {
+			for _, t := range catalog.Tables() {
+				schemaName, tableName := catalog.SchemaOf(t.Name())
+				schema, _ := t.Schema()
+				for _, c := range schema.Columns() {
+					out["schema"].Append(schemaName)
+					out["table"].Append(tableName)
+					out["column"].Append(c)
+					out["type"].Append(schema[c].SQL())
+				}
+			}
+		}),
+
+		newTable("nodes", cluster, typeof.Schema{
+			"address": typeof.String,
+			"self":    typeof.Bool,
+		}, func(out column.Columns) {
+			for _, m := range sorted(cluster.Members()) {
+				out["address"].Append(m)
+				out["self"].Append(m == cluster.Addr())
+			}
+		}),
+
+		newTable("splits", cluster, typeof.Schema{
+			"schema":  typeof.String,
+			"table":   typeof.String,
+			"address": typeof.String,
+			"from":    typeof.Int64,
+			"to":      typeof.Int64,
+		}, func(out column.Columns) {
+			for _, t := range catalog.Tables() {
+				schemaName, tableName := catalog.SchemaOf(t.Name())
+				for _, s := range splitsOf(t, cluster) {
+					out["schema"].Append(schemaName)
+					out["table"].Append(tableName)
+					out["address"].Append(s.addr)
+					out["from"].Append(int64(s.From))
+					out["to"].Append(int64(s.To))
+				}
+			}
+		}),
+
+		newTable("slow_queries", cluster, typeof.Schema{
+			"time":       typeof.Timestamp,
+			"address":    typeof.String,
+			"table":      typeof.String,
+			"operation":  typeof.String,
+			"duration":   typeof.Int64,
+			"bytes":      typeof.Int64,
+			"rows":       typeof.Int64,
+			"constraint": typeof.String,
+		}, func(out column.Columns) {
+			for _, e := range catalog.SlowQueries() {
+				out["time"].Append(e.Time)
+				out["address"].Append(cluster.Addr())
+				out["table"].Append(e.Table)
+				out["operation"].Append(e.Operation)
+				out["duration"].Append(e.Duration.Milliseconds())
+				out["bytes"].Append(e.Bytes)
+				out["rows"].Append(e.Rows)
+				out["constraint"].Append(e.Constraint)
+			}
+		}),
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+
+// Table represents a read-only system table, computed on every read.
+type Table struct {
+	name    string               // The name of the table
+	schema  typeof.Schema        // The schema of the table
+	cluster Membership           // The membership list to use
+	read    func(column.Columns) // The function which populates the rows
+}
+
+// newTable creates a new system table.
+func newTable(name string, cluster Membership, schema typeof.Schema, read func(column.Columns)) *Table {
+	return &Table{
+		name:    name,
+		schema:  schema,
+		cluster: cluster,
+		read:    read,
+	}
+}
+
+// Close implements io.Closer interface.
+func (t *Table) Close() error {
+	return nil
+}
+
+// Name returns the name of the table.
+func (t *Table) Name() string {
+	return Schema + "." + t.name
+}
+
+// Schema retrieves the metadata for the table
+func (t *Table) Schema() (typeof.Schema, bool) {
+	return t.schema, true
+}
+
+// HashBy returns the column by which the table should be hashed.
+func (t *Table) HashBy() string {
+	return ""
+}
+
+// SortBy returns the column by which the table should be sorted.
+func (t *Table) SortBy() string {
+	return ""
+}
+
+// GetSplits retrieves the splits
+func (t *Table) GetSplits(desiredColumns []string, outputConstraint *presto.PrestoThriftTupleDomain, maxSplitCount int) ([]table.Split, error) {
+
+	// Every node holds the same view of the catalog and the cluster, hence a single split served
+	// by the node which was asked is sufficient.
+	return []table.Split{{
+		Key:   splitKey,
+		Addrs: []string{t.cluster.Addr()},
+	}}, nil
+}
+
+// GetRows retrieves the data
+func (t *Table) GetRows(splitID []byte, columns []string, maxBytes int64) (*table.PageResult, error) {
+	data := column.MakeColumns(&t.schema)
+	t.read(data)
+
+	result := &table.PageResult{
+		Columns: make([]presto.Column, 0, len(columns)),
+	}
+
+	for _, c := range columns {
+		column, ok := data[c]
+		if !ok {
+			return nil, fmt.Errorf("system: table %s does not contain column %s", t.Name(), c)
+		}
+
+		result.Columns = append(result.Columns, column)
+	}
+	return result, nil
+}
+
+// split represents the range of hash keys of a table served by a node
+type split struct {
+	cluster.Range
+	addr string
+}
+
+// splitsOf returns the ranges of hash keys of a table served by each node. If the table is hashed and the keys
+// are owned by the nodes, these are the ranges each node owns, otherwise every node queried by the splits
+// of the table serves every key.
+func splitsOf(t table.Table, membership Membership) (out []split) {
+	members := sorted(membership.Members())
+	if ring, ok := membership.(Ownership); ok && t.HashBy() != "" {
+		for _, m := range members {
+			for _, r := range ring.Ranges(m) {
+				out = append(out, split{Range: r, addr: m})
+			}
+		}
+		return
+	}
+
+	// The splits of a table which is not hashed do not depend on the constraint, unless it can not be split
+	addrs := members
+	if splits, err := t.GetSplits(nil, &presto.PrestoThriftTupleDomain{
+		Domains: map[string]*presto.PrestoThriftDomain{},
+	}, 0); err == nil {
+		addrs = addrs[:0:0]
+		for _, s := range splits {
+			if len(s.Addrs) > 0 && !contains(addrs, s.Addrs[0]) {
+				addrs = append(addrs, s.Addrs[0])
+			}
+		}
+		sort.Strings(addrs)
+	}
+
+	for _, addr := range addrs {
+		out = append(out, split{Range: cluster.Range{To: math.MaxUint32}, addr: addr})
+	}
+	return
+}
+
+// contains checks whether the address is in the list
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns a sorted copy of the addresses
+func sorted(addrs []string) []string {
+	out := make([]string, len(addrs))
+	copy(out, addrs)
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,135 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package system_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/server/cluster"
+	"github.com/kelindar/talaria/internal/server/slowlog"
+	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/kelindar/talaria/internal/table/system"
+	"github.com/stretchr/testify/assert"
+)
+
+type noopMembership int
+
+func (m noopMembership) Members() []string {
+	return []string{"127.0.0.2", "127.0.0.1"}
+}
+
+func (m noopMembership) Addr() string {
+	return "127.0.0.1"
+}
+
+type testCatalog []table.Table
+
+func (c testCatalog) Tables() []table.Table {
+	return c
+}
+
+func (c testCatalog) SlowQueries() []slowlog.Entry {
+	return []slowlog.Entry{{
+		Time:      time.Unix(1600000000, 0),
+		Table:     "nodes",
+		Operation: "get_rows",
+		Duration:  time.Second,
+	}}
+}
+
+func (c testCatalog) SchemaOf(name string) (string, string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "data", name
+}
+
+func TestSystem(t *testing.T) {
+	tables := system.New(testCatalog{nodes.New(new(noopMembership))}, new(noopMembership))
+	assert.Len(t, tables, 5)
+
+	get := func(name string, columns ...string) []interface{} {
+		for _, tbl := range tables {
+			if tbl.Name() != "system."+name {
+				continue
+			}
+
+			splits, err := tbl.GetSplits(columns, nil, 100)
+			assert.NoError(t, err)
+			assert.Len(t, splits, 1)
+			assert.Equal(t, []string{"127.0.0.1"}, splits[0].Addrs)
+
+			page, err := tbl.GetRows(splits[0].Key, columns, 1024*1024)
+			assert.NoError(t, err)
+
+			var out []interface{}
+			for _, c := range page.Columns {
+				for i := 0; i < c.Count(); i++ {
+					out = append(out, c.At(i))
+				}
+			}
+			return out
+		}
+		return nil
+	}
+
+	assert.Equal(t, []interface{}{"data", "nodes", "address", int32(7)}, get("tables", "schema", "table", "sort_by", "columns"))
+	columns := get("columns", "column", "type")
+	assert.Len(t, columns, 14)
+	assert.Equal(t, []interface{}{"address", "peers"}, columns[0:2])
+	assert.Equal(t, []interface{}{"VARCHAR", "VARCHAR"}, columns[7:9])
+	assert.Equal(t, []interface{}{"127.0.0.1", "127.0.0.2", true, false}, get("nodes", "address", "self"))
+	assert.Equal(t, []interface{}{"nodes", "nodes", "127.0.0.1", "127.0.0.2", int64(0), int64(0), int64(math.MaxUint32), int64(math.MaxUint32)},
+		get("splits", "table", "address", "from", "to"))
+	assert.Equal(t, []interface{}{"nodes", "get_rows", int64(1000)}, get("slow_queries", "table", "operation", "duration"))
+}
+
+type ringMembership struct {
+	noopMembership
+}
+
+func (m ringMembership) Ranges(addr string) []cluster.Range {
+	if addr == "127.0.0.1" {
+		return []cluster.Range{{From: 0, To: 99}, {From: 200, To: math.MaxUint32}}
+	}
+	return []cluster.Range{{From: 100, To: 199}}
+}
+
+type hashedTable struct {
+	table.Table
+}
+
+func (t hashedTable) HashBy() string {
+	return "event"
+}
+
+func TestSystem_Ranges(t *testing.T) {
+	tables := system.New(testCatalog{hashedTable{nodes.New(new(noopMembership))}}, new(ringMembership))
+	splits := tables[3]
+	assert.Equal(t, "system.splits", splits.Name())
+
+	page, err := splits.GetRows(nil, []string{"address", "from", "to"}, 1024*1024)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Columns[0].Count())
+
+	var rows [][]interface{}
+	for i := 0; i < page.Columns[0].Count(); i++ {
+		rows = append(rows, []interface{}{page.Columns[0].At(i), page.Columns[1].At(i), page.Columns[2].At(i)})
+	}
+	assert.Equal(t, [][]interface{}{
+		{"127.0.0.1", int64(0), int64(99)},
+		{"127.0.0.1", int64(200), int64(math.MaxUint32)},
+		{"127.0.0.2", int64(100), int64(199)},
+	}, rows)
+}
+
+func TestSystem_NoColumn(t *testing.T) {
+	tables := system.New(testCatalog{}, new(noopMembership))
+	_, err := tables[0].GetRows(nil, []string{"xxx"}, 1024*1024)
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timeseries
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+)
+
+const (
+	defaultLateness = time.Hour // The default time the rows can arrive behind the watermark
+	latePrefix      = "late/"   // The prefix of the key of the partition of the late rows
+)
+
+// The policies of the late rows
+const (
+	latePartition = "partition" // The late rows are kept in a partition of their own
+	lateDrop      = "drop"      // The late rows are dropped
+	lateMetric    = "metric"    // The late rows are only counted
+)
+
+// watermark tracks the latest event time seen by a table, the rows older than the lateness behind it being late
+type watermark struct {
+	column   string        // The column of the event time
+	lateness time.Duration // The time the rows can arrive behind the latest event time
+	policy   string        // The policy of the late rows
+	latest   int64         // The latest event time seen, in unix nanoseconds
+}
+
+// newWatermark creates a new watermark from the configuration
+func newWatermark(conf *config.EventTime, sortBy string) *watermark {
+	w := &watermark{
+		column:   conf.Column,
+		lateness: time.Duration(conf.Lateness) * time.Second,
+		policy:   strings.ToLower(conf.Late),
+	}
+
+	if w.column == "" {
+		w.column = sortBy
+	}
+	if w.lateness <= 0 {
+		w.lateness = defaultLateness
+	}
+	if w.policy == "" {
+		w.policy = latePartition
+	}
+	return w
+}
+
+// Observe advances the watermark with the newest event time of a block, and returns whether the block is late,
+// which is when even its newest row is older than the watermark. The watermark never advances past the clock of
+// the node, so that a row from the future, such as one from a producer with a skewed clock, does not make the
+// rows which follow late.
+func (w *watermark) Observe(newest time.Time) (late bool) {
+	ts := newest.UnixNano()
+	if now := time.Now().UnixNano(); ts > now {
+		ts = now
+	}
+
+	for {
+		latest := atomic.LoadInt64(&w.latest)
+		if ts <= latest {
+			return latest > 0 && ts < latest-int64(w.lateness)
+		}
+
+		if atomic.CompareAndSwapInt64(&w.latest, latest, ts) {
+			return false
+		}
+	}
+}
+
+// Time returns the time behind which the rows are late
+func (w *watermark) Time() time.Time {
+	latest := atomic.LoadInt64(&w.latest)
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, latest).Add(-w.lateness)
+}
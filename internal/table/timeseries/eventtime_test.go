@@ -0,0 +1,26 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timeseries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermark_Future(t *testing.T) {
+	now := time.Now()
+	w := newWatermark(&config.EventTime{Lateness: 60}, "time")
+
+	// A row from the future only advances the watermark up to the clock of the node
+	assert.False(t, w.Observe(now.Add(365*24*time.Hour)))
+	assert.False(t, w.Time().After(time.Now().Add(-time.Minute)))
+
+	// The rows which follow are on time, unless they are older than the lateness
+	assert.False(t, w.Observe(now))
+	assert.False(t, w.Observe(now.Add(-30*time.Second)))
+	assert.True(t, w.Observe(now.Add(-time.Hour)))
+}
@@ -0,0 +1,110 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timeseries
+
+import (
+	"context"
+	"time"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
+)
+
+// Purge deletes the rows of the table matching the tombstone from the store. The blocks of a key are looked up
+// by its hash, including its late partition, while a time range alone requires scanning every block. A block
+// whose rows all match is deleted, and one with some rows left is written again with the remaining rows. It
+// returns the number of rows deleted.
+func (t *Table) Purge(ctx context.Context, ts tombstone.Tombstone) (int, error) {
+	ranges := [][2]key.Key{{key.First(), key.Last()}}
+	if ts.Key != "" {
+		ranges = [][2]key.Key{rangeOf(ts.Key), rangeOf(latePrefix + ts.Key)}
+	}
+
+	filter := tombstone.NewFilter(t.hashBy, t.sortBy)
+	tombstones := []tombstone.Tombstone{ts}
+	purged := 0
+	for _, r := range ranges {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		// Filter the blocks of the range, the ones with some rows left being written again
+		var deleted []key.Key
+		var rewritten []key.Key
+		var blocks []block.Block
+		var failure error
+		if err := t.store.Range(r[0], r[1], func(k, v []byte) bool {
+			b, err := block.FromBuffer(v)
+			if err != nil {
+				t.monitor.Error(errors.Internal("purge: unable to read a buffer", err))
+				return false
+			}
+
+			filtered, n, err := filter.Apply(tombstones, []block.Block{b})
+			if err != nil {
+				failure = err
+				return true
+			}
+
+			switch {
+			case n == 0:
+			case len(filtered) == 0:
+				deleted = append(deleted, key.Clone(k))
+			default:
+				rewritten = append(rewritten, key.Clone(k))
+				blocks = append(blocks, filtered[0])
+			}
+			purged += n
+			return false
+		}); err != nil {
+			return purged, err
+		}
+
+		if failure != nil {
+			return purged, failure
+		}
+
+		if err := t.rewrite(rewritten, blocks); err != nil {
+			return purged, err
+		}
+
+		if err := t.store.Delete(deleted...); err != nil {
+			return purged, err
+		}
+	}
+
+	t.monitor.Count(ctxTag, "purge.rows", int64(purged), "table:"+t.name)
+	return purged, nil
+}
+
+// rewrite writes the blocks again under their keys, with the time they had left to live
+func (t *Table) rewrite(keys []key.Key, blocks []block.Block) error {
+	for i, b := range blocks {
+		ttl := time.Until(time.Unix(b.Expires, 0))
+		if ttl <= 0 {
+			if err := t.store.Delete(keys[i]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		buffer, err := b.Encode()
+		if err != nil {
+			return err
+		}
+
+		if err := t.store.Append(keys[i], buffer, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeOf returns the first and the last key of the blocks of a partition
+func rangeOf(partition string) [2]key.Key {
+	seek, until := key.RangeOf(partition)
+	return [2]key.Key{seek, until}
+}
@@ -25,7 +25,8 @@ var (
 type query struct {
 	Begin  []byte // The first key of the range
 	Until  []byte // The last key of the range
-	Offset int64  // The last offset of the file we need to process
+	Offset int64  // The number of rows of the first block which were already read
+	AsOf   int64  // The ingestion time (in unix nanoseconds) up to which the data is read, if set
 }
 
 // Encode creates a split ID by encoding a query.
@@ -117,3 +118,30 @@ func parseWithSort(req *presto.PrestoThriftTupleDomain, sortKey string) ([]query
 
 	return []query{newQuery("", from, until)}, nil
 }
+
+// parseAsOf retrieves the upper bound of the ingestion time from the presto constraint
+func parseAsOf(req *presto.PrestoThriftTupleDomain, ingestedBy string) (time.Time, bool) {
+	if req == nil || ingestedBy == "" {
+		return time.Time{}, false
+	}
+
+	domain, ok := req.Domains[ingestedBy]
+	if !ok || domain.ValueSet == nil || domain.ValueSet.RangeValueSet == nil {
+		return time.Time{}, false
+	}
+
+	// Take the largest upper bound, unless one of the ranges is unbounded
+	var asOf time.Time
+	for _, r := range domain.ValueSet.RangeValueSet.Ranges {
+		t, ok := r.AsUpperBound()
+		if !ok {
+			return time.Time{}, false
+		}
+
+		if t.After(asOf) {
+			asOf = t
+		}
+	}
+
+	return asOf, !asOf.IsZero()
+}
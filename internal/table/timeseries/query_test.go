@@ -5,6 +5,7 @@ package timeseries
 
 import (
 	"testing"
+	"time"
 
 	"github.com/kelindar/talaria/internal/presto"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +17,7 @@ func TestSplitCodec(t *testing.T) {
 		q.Begin = []byte("ABC")
 
 		id := q.Encode()
-		assert.Equal(t, []byte{0x3, 0x41, 0x42, 0x43, 0x0, 0x0}, id)
+		assert.Equal(t, []byte{0x3, 0x41, 0x42, 0x43, 0x0, 0x0, 0x0}, id)
 
 		out, err := decodeQuery(id)
 		assert.NoError(t, err)
@@ -54,6 +55,36 @@ func TestParseKeyColDisabled(t *testing.T) {
 	assert.Len(t, queries, 1)
 }
 
+func TestParseAsOf(t *testing.T) {
+	asOf := time.Unix(1600000000, 0)
+	domain := newSplitQuery("test")
+	domain.Domains["ingested"] = &presto.PrestoThriftDomain{
+		ValueSet: &presto.PrestoThriftValueSet{
+			RangeValueSet: &presto.PrestoThriftRangeValueSet{
+				Ranges: []*presto.PrestoThriftRange{{
+					Low: &presto.PrestoThriftMarker{Bound: presto.PrestoThriftBoundAbove},
+					High: &presto.PrestoThriftMarker{
+						Value: &presto.PrestoThriftBlock{
+							BigintData: &presto.PrestoThriftBigint{Nulls: []bool{false}, Longs: []int64{asOf.UnixNano()}},
+						},
+						Bound: presto.PrestoThriftBoundExactly,
+					},
+				}},
+			},
+		},
+	}
+
+	out, ok := parseAsOf(domain, "ingested")
+	assert.True(t, ok)
+	assert.Equal(t, asOf.UnixNano(), out.UnixNano())
+
+	_, ok = parseAsOf(domain, "")
+	assert.False(t, ok)
+
+	_, ok = parseAsOf(domain, "_col5")
+	assert.False(t, ok)
+}
+
 func newSplitQuery(eventName string) *presto.PrestoThriftTupleDomain {
 	return &presto.PrestoThriftTupleDomain{
 		Domains: map[string]*presto.PrestoThriftDomain{
@@ -86,3 +117,11 @@ func newSplitQuery(eventName string) *presto.PrestoThriftTupleDomain {
 		},
 	}
 }
+
+func TestEventTime(t *testing.T) {
+	at := time.Date(2020, 4, 10, 6, 29, 17, 0, time.UTC)
+	assert.Equal(t, at.Unix(), eventTime(at.Unix()).Unix())
+	assert.Equal(t, at.Unix(), eventTime(at.UnixNano()/1000000).Unix())
+	assert.Equal(t, at.Unix(), eventTime(at.UnixNano()/1000).Unix())
+	assert.Equal(t, at.Unix(), eventTime(at.UnixNano()).Unix())
+}
@@ -0,0 +1,84 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package timeseries
+
+import (
+	"context"
+
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/encoding/key"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+const rebalanceBatch = 4 * 1024 * 1024 // 4 MB
+
+// Rebalance moves the blocks of the hash keys which this node does not store anymore to the node which
+// should store them, in batches, and deletes them once moved. The placement returns the node storing a
+// hash key and whether it needs to be moved there. The blocks which can not be moved are kept, and moved
+// on the next rebalance. It returns the number of blocks moved.
+func (t *Table) Rebalance(ctx context.Context, placement func(hash uint32) (string, bool),
+	move func(ctx context.Context, addr string, blocks []block.Block) error) (int, error) {
+	if t.hashBy == "" {
+		return 0, nil
+	}
+
+	moved := 0
+	seek := key.First()
+	for {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+
+		// Read the next batch of blocks to move, grouped by destination
+		var size, scanned int
+		var last key.Key
+		batch := make(map[string][]key.Key)
+		blocks := make(map[string][]block.Block)
+		if err := t.store.Range(seek, key.Last(), func(k, v []byte) bool {
+			scanned++
+			last = key.Clone(k)
+			addr, ok := placement(key.HashOf(k))
+			if !ok {
+				return false
+			}
+
+			b, err := block.FromBuffer(v)
+			if err != nil {
+				t.monitor.Error(errors.Internal("rebalance: unable to read a buffer", err))
+				return false
+			}
+
+			batch[addr] = append(batch[addr], last)
+			blocks[addr] = append(blocks[addr], b)
+			size += len(v)
+			return size >= rebalanceBatch
+		}); err != nil {
+			return moved, err
+		}
+
+		// Move the blocks, and delete them once they are stored by their new node
+		for addr, keys := range batch {
+			if err := move(ctx, addr, blocks[addr]); err != nil {
+				t.monitor.Count1(ctxTag, "rebalance.error", "table:"+t.name)
+				t.monitor.Warning(errors.Internal("rebalance: unable to move the blocks", err,
+					errors.WithTag("table", t.name),
+					errors.WithTag("addr", addr)))
+				continue
+			}
+
+			if err := t.store.Delete(keys...); err != nil {
+				return moved, err
+			}
+
+			moved += len(keys)
+			t.monitor.Count(ctxTag, "rebalance.count", int64(len(keys)), "table:"+t.name)
+		}
+
+		// Continue right after the last key read, unless everything was read
+		if size < rebalanceBatch || scanned == 0 {
+			return moved, nil
+		}
+		seek = append(last, 0x00)
+	}
+}
@@ -4,10 +4,11 @@
 package timeseries
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/url"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -40,33 +41,52 @@ type Membership interface {
 	Members() []string
 }
 
+// Ownership represents a contract which assigns the hash keys to the nodes of the cluster.
+type Ownership interface {
+	Replicas(hash uint32) []string
+}
+
+// Zones represents a contract which returns the availability zone of the members of the cluster.
+type Zones interface {
+	Addr() string
+	ZoneOf(addr string) string
+}
+
 // Table represents a timeseries table.
 type Table struct {
 	name         string           // The name of the table
 	hashBy       string           // The name of the key column
 	sortBy       string           // The name of the time column
-	ttl          time.Duration    // The default TTL
+	ingestedBy   string           // The name of the ingestion time column (optional)
+	ttl          int64            // The default TTL, in nanoseconds, which can be changed at runtime
 	store        storage.Storage  // The storage to use
-	schema       atomic.Value     // The latest schema
+	schema       atomic.Value     // The columns ingested so far, with their latest type
 	loader       *loader.Loader   // The loader used to watch schema updates
 	cluster      Membership       // The membership list to use
 	monitor      monitor.Monitor  // The monitoring client
 	staticSchema *typeof.Schema   // The static schema of the timeseries table
 	stream       storage.Streamer // The streams that a table has
+	rejects      storage.Streamer // The streams the rows rejected by the strict schema are sent to (optional)
+	watermark    *watermark       // The latest event time seen, if the rows are keyed by their event time (optional)
 }
 
 // New creates a new table implementation.
 func New(name string, cluster Membership, monitor monitor.Monitor, store storage.Storage, cfg *config.Table, stream storage.Streamer) *Table {
 	t := &Table{
-		name:    name,
-		store:   store,
-		hashBy:  cfg.HashBy,
-		sortBy:  cfg.SortBy,
-		ttl:     time.Duration(cfg.TTL) * time.Second,
-		cluster: cluster,
-		monitor: monitor,
-		loader:  loader.New(),
-		stream:  stream,
+		name:       name,
+		store:      store,
+		hashBy:     cfg.HashBy,
+		sortBy:     cfg.SortBy,
+		ingestedBy: cfg.IngestedBy,
+		ttl:        int64(time.Duration(cfg.TTL) * time.Second),
+		cluster:    cluster,
+		monitor:    monitor,
+		loader:     loader.New(),
+		stream:     stream,
+	}
+
+	if cfg.EventTime != nil {
+		t.watermark = newWatermark(cfg.EventTime, cfg.SortBy)
 	}
 
 	t.staticSchema = t.loadStaticSchema(cfg.Schema)
@@ -78,6 +98,91 @@ func (t *Table) Close() error {
 	return t.store.Close()
 }
 
+// Drop closes the table and deletes the data stored by the table on this node.
+func (t *Table) Drop() error {
+	return storage.Drop(t.store)
+}
+
+// Size returns the size of the data stored by the table on this node, in bytes.
+func (t *Table) Size() int64 {
+	if sizer, ok := t.store.(interface{ Size() int64 }); ok {
+		return sizer.Size()
+	}
+	return 0
+}
+
+// Buffered returns the approximate size of the data not flushed to the sinks yet, in bytes, if compaction is enabled.
+func (t *Table) Buffered() (int64, bool) {
+	if compactor, ok := t.store.(interface{ Buffered() int64 }); ok {
+		return compactor.Buffered(), true
+	}
+	return 0, false
+}
+
+// Flush compacts the data buffered by the table to its sinks right away, if compaction is enabled.
+func (t *Table) Flush(ctx context.Context) error {
+	if compactor, ok := t.store.(interface {
+		Compact(context.Context) (interface{}, error)
+	}); ok {
+		_, err := compactor.Compact(ctx)
+		return err
+	}
+	return nil
+}
+
+// Truncate deletes every row stored by the table on this node at once, including the ones not flushed to the
+// sinks yet. The data already flushed to the sinks is not modified.
+func (t *Table) Truncate() error {
+	if truncater, ok := t.store.(interface{ Truncate() error }); ok {
+		return truncater.Truncate()
+	}
+	return errors.New("timeseries: the storage of " + t.name + " can not be truncated")
+}
+
+// GC deletes the expired data of the table and reclaims the space on disk right away, or fails if the storage
+// does not support it.
+func (t *Table) GC(ctx context.Context) error {
+	if collector, ok := t.store.(interface {
+		GC(context.Context) (interface{}, error)
+	}); ok {
+		_, err := collector.GC(ctx)
+		return err
+	}
+	return errors.Unimplemented("timeseries: the storage of " + t.name + " does not support garbage collection")
+}
+
+// Flushed returns the time of the last complete compaction, if compaction is enabled.
+func (t *Table) Flushed() (time.Time, bool) {
+	if compactor, ok := t.store.(interface{ Flushed() time.Time }); ok {
+		return compactor.Flushed(), true
+	}
+	return time.Time{}, false
+}
+
+// Unflushed returns the time of the oldest append which was not compacted to the sinks yet, if any.
+func (t *Table) Unflushed() (time.Time, bool) {
+	if compactor, ok := t.store.(interface{ Unflushed() (time.Time, bool) }); ok {
+		return compactor.Unflushed()
+	}
+	return time.Time{}, false
+}
+
+// Check checks whether the storage of the table is writable, if the storage supports the check.
+func (t *Table) Check(ctx context.Context) error {
+	if checker, ok := t.store.(interface{ Check(context.Context) error }); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// CheckSink returns the error of the last write to the sinks, if compaction is enabled and it failed.
+func (t *Table) CheckSink(ctx context.Context) error {
+	if checker, ok := t.store.(interface{ CheckSink(context.Context) error }); ok {
+		return checker.CheckSink(ctx)
+	}
+	return nil
+}
+
 // Name returns the name of the table.
 func (t *Table) Name() string {
 	return t.name
@@ -88,6 +193,15 @@ func (t *Table) Schema() (typeof.Schema, bool) {
 	return t.getSchema(), t.staticSchema != nil
 }
 
+// Alter replaces the static schema of the table, once columns were added to it.
+func (t *Table) Alter(schema typeof.Schema) {
+	altered := make(typeof.Schema, len(schema))
+	for name, typ := range schema {
+		altered[name] = typ
+	}
+	t.staticSchema = &altered
+}
+
 // HashBy returns the column by which the table should be hashed.
 func (t *Table) HashBy() string {
 	return t.hashBy
@@ -98,11 +212,39 @@ func (t *Table) SortBy() string {
 	return t.sortBy
 }
 
+// IngestedBy returns the column in which the ingestion time should be recorded.
+func (t *Table) IngestedBy() string {
+	return t.ingestedBy
+}
+
+// TTL returns the time-to-live of the data appended to the table.
+func (t *Table) TTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.ttl))
+}
+
+// SetTTL changes the time-to-live of the data appended from now on, when the retention is reconfigured.
+func (t *Table) SetTTL(ttl time.Duration) {
+	atomic.StoreInt64(&t.ttl, int64(ttl))
+}
+
 // Stream will stream the row and return errors if any
 func (t *Table) Stream(row block.Row) error {
 	return t.stream.Stream(row)
 }
 
+// SetRejects sets the streams the rows rejected by the strict schema of the table are sent to
+func (t *Table) SetRejects(rejects storage.Streamer) {
+	t.rejects = rejects
+}
+
+// Reject streams a row rejected by the strict schema of the table, if the table has an errors destination
+func (t *Table) Reject(row block.Row) error {
+	if t.rejects == nil {
+		return nil
+	}
+	return t.rejects.Stream(row)
+}
+
 func (t *Table) loadStaticSchema(uriOrSchema string) *typeof.Schema {
 	staticSchema := &typeof.Schema{}
 
@@ -162,9 +304,35 @@ func (t *Table) GetSplits(desiredColumns []string, outputConstraint *presto.Pres
 		return nil, err
 	}
 
+	// Only read the data ingested up to a point in time, if requested
+	if asOf, ok := parseAsOf(outputConstraint, t.ingestedBy); ok {
+		for i := range queries {
+			queries[i].AsOf = asOf.UnixNano()
+		}
+	}
+
+	// If the keys are owned by specific nodes, only the replicas of each key need to be queried. These
+	// are all alive members, with the owner listed first so Presto prefers it, unless another replica
+	// is in the same zone as this node.
+	splits := make([]table.Split, 0, 16)
+	if ring, ok := t.cluster.(Ownership); ok && t.hashBy != "" {
+		for _, q := range queries {
+			if replicas := ring.Replicas(key.HashOf(q.Begin)); len(replicas) > 0 {
+				splits = append(splits, table.Split{
+					Key:   q.Encode(),
+					Addrs: t.preferLocalZone(replicas),
+				})
+			}
+		}
+
+		if len(splits) == len(queries) {
+			return splits, nil
+		}
+		splits = splits[:0]
+	}
+
 	// We need to generate as many splits as we have nodes in our cluster. Each split needs to contain the IP address of the
 	// node containing that split, so Presto can reach it and request the data.
-	splits := make([]table.Split, 0, 16)
 	for _, m := range t.cluster.Members() {
 		for _, q := range queries {
 			splits = append(splits, table.Split{
@@ -176,6 +344,59 @@ func (t *Table) GetSplits(desiredColumns []string, outputConstraint *presto.Pres
 	return splits, nil
 }
 
+// preferLocalZone orders the addresses so that the ones in the zone of this node come first, which
+// lets Presto read the data without crossing zones.
+func (t *Table) preferLocalZone(addrs []string) []string {
+	zones, ok := t.cluster.(Zones)
+	if !ok || len(addrs) < 2 {
+		return addrs
+	}
+
+	zone := zones.ZoneOf(zones.Addr())
+	if zone == "" {
+		return addrs
+	}
+
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return zones.ZoneOf(addrs[i]) == zone && zones.ZoneOf(addrs[j]) != zone
+	})
+	return addrs
+}
+
+// KeyRange returns the domain of the sort key selecting the blocks which may hold rows with a time within the
+// range, given in the raw values of the sort column. The blocks are keyed by their event time if the table has one,
+// or by the raw value of their oldest row read as unix nanoseconds, to the second. The range is widened by a
+// second on each side so the blocks keyed within the same second as one of its bounds are kept.
+func (t *Table) KeyRange(from, until int64) (*presto.PrestoThriftDomain, bool) {
+	var t0, t1 time.Time
+	switch {
+	case t.watermark == nil:
+		t0, t1 = time.Unix(0, from), time.Unix(0, until)
+	case t.watermark.column == t.sortBy:
+		t0, t1 = eventTime(from), eventTime(until)
+	default:
+		return nil, false
+	}
+
+	if t0.Unix() < 1 {
+		t0 = time.Unix(1, 0)
+	}
+	return presto.TimeRange(t0.Add(-time.Second), t1.Add(time.Second)), true
+}
+
+// HashOf returns the hash of the key read by a split, if the table is partitioned by a hash key.
+func (t *Table) HashOf(splitID []byte) (uint32, bool) {
+	if t.hashBy == "" {
+		return 0, false
+	}
+
+	query, err := decodeQuery(splitID)
+	if err != nil {
+		return 0, false
+	}
+	return key.HashOf(query.Begin), true
+}
+
 // GetRows retrieves the data
 func (t *Table) GetRows(splitID []byte, requestedColumns []string, maxBytes int64) (result *table.PageResult, err error) {
 	result = &table.PageResult{
@@ -201,34 +422,52 @@ func (t *Table) GetRows(splitID []byte, requestedColumns []string, maxBytes int6
 		return nil, err
 	}
 
-	// Range through the keys in our data store
+	// Range through the keys in our data store, starting after the rows of the first block already read
 	bytesLeft := int(float64(maxBytes) * 0.95) // Leave 5% buffer in case we estimating the size poorly
 	frames := make(map[string][]presto.Column, len(requestedColumns))
+	read := 0
 	if err = t.store.Range(query.Begin, query.Until, func(key, value []byte) bool {
 
-		// Read the data frame from the specified offset
-		frame, readError := t.readDataFrame(localSchema, value, bytesLeft)
+		// Skip the blocks ingested after the requested point in time
+		if query.AsOf > 0 && !t.ingestedBefore(value, query.AsOf) {
+			return false
+		}
 
-		// Set the next token if we don't have enough to process
-		if readError == io.ErrShortBuffer {
-			query.Begin = key // Continue from the current key (at 0 offset)
-			result.NextToken = query.Encode()
-			return true
+		// Read the data frame, skipping empty frames which should not happen most of the time
+		frame, count := t.readDataFrame(localSchema, value, requestedColumns)
+		if count == 0 {
+			return false
 		}
 
-		// Skip empty frames, should not happen most of the time
-		if frame.Size() == 0 {
-			return false // Ignore
+		offset := 0
+		if bytes.Equal(key, query.Begin) {
+			offset = int(query.Offset)
 		}
 
-		// Append each column to the map (we'll merge later)
-		for _, columnName := range requestedColumns {
-			f := frame[columnName]
-			frames[columnName] = append(frames[columnName], f)
+		// If the rows left do not fit, read as many as the page allows and continue from the next row. At
+		// least one row is read per page so that a block larger than the page is still read eventually.
+		until := count
+		if size := frame.Size() * (count - offset) / count; size > bytesLeft {
+			until = offset + bytesLeft*(count-offset)/size
+			if until == offset && read == 0 {
+				until++
+			}
+
+			query.Begin = key
+			query.Offset = int64(until)
+			result.NextToken = query.Encode()
 		}
 
-		bytesLeft -= frame.Size()
-		return readError != io.EOF
+		// Append each column to the map (we'll merge later)
+		if until > offset {
+			for _, columnName := range requestedColumns {
+				frames[columnName] = append(frames[columnName], presto.Slice(frame[columnName], offset, until))
+			}
+
+			read += until - offset
+			bytesLeft -= frame.Size() * (until - offset) / count
+		}
+		return result.NextToken != nil
 	}); err != nil {
 		t.monitor.Warning(errors.Internal("range through the key failed", err))
 		return
@@ -245,22 +484,32 @@ func (t *Table) GetRows(splitID []byte, requestedColumns []string, maxBytes int6
 	return
 }
 
-// ReadDataFrame reads a column data frame and returns the set of columns requested.
-func (t *Table) readDataFrame(schema typeof.Schema, buffer []byte, maxBytes int) (column.Columns, error) {
+// readDataFrame reads a column data frame and returns the set of columns requested, along with the number of rows.
+func (t *Table) readDataFrame(schema typeof.Schema, buffer []byte, columns []string) (column.Columns, int) {
 	result, err := block.Read(buffer, schema)
 	if err != nil {
-		return nil, errors.Internal("block read failed", err)
+		t.monitor.Warning(errors.Internal("block read failed", err))
+		return nil, 0
 	}
 
 	// Log the data frame read
-	t.monitor.Debug("reading a data frame size=%v bytesLeft=%v", result.Size(), maxBytes)
+	t.monitor.Debug("reading a data frame size=%v", result.Size())
+	if len(columns) == 0 || result.Size() == 0 {
+		return result, 0
+	}
+	return result, result[columns[0]].Count()
+}
 
-	// If we don't have enough space, skip this data frame and stop here
-	if result.Size() >= maxBytes {
-		return nil, io.ErrShortBuffer
+// ingestedBefore checks whether the encoded block was ingested before the time, in unix nanoseconds. Blocks
+// which do not record their ingestion time are considered to be ingested before.
+func (t *Table) ingestedBefore(buffer []byte, asOf int64) bool {
+	blk, err := block.FromBuffer(buffer)
+	if err != nil {
+		return true // Let the reader handle it
 	}
 
-	return result, io.EOF
+	ingested, ok := blk.Min(t.ingestedBy)
+	return !ok || ingested <= asOf
 }
 
 // Append appends a block to the store.
@@ -272,31 +521,96 @@ func (t *Table) Append(block block.Block) error {
 		ts = 0
 	}
 
+	// Measure how late the oldest event of the block is
+	if hasTs && ts > 0 {
+		t.monitor.Gauge(ctxTag, "ingest.lag", time.Since(eventTime(ts)).Seconds(), "table:"+t.name)
+	}
+
+	// Key the block by its event time, handling it according to the policy if it arrived late
+	name, at := string(block.Key), time.Unix(0, ts)
+	if t.watermark != nil {
+		oldest, newest, ok := block.Bounds(t.watermark.column)
+		if ok {
+			at = eventTime(oldest)
+			if late := t.watermark.Observe(eventTime(newest)); late {
+				t.monitor.Count1(ctxTag, "late.blocks", "table:"+t.name, "policy:"+t.watermark.policy)
+				t.monitor.Count(ctxTag, "late.bytes", block.Size, "table:"+t.name, "policy:"+t.watermark.policy)
+				switch t.watermark.policy {
+				case lateDrop:
+					return nil
+				case latePartition:
+					name = latePrefix + name
+				}
+			}
+			t.monitor.Gauge(ctxTag, "watermark", float64(t.watermark.Time().Unix()), "table:"+t.name)
+		}
+	}
+
 	// Encode the block
-	block.Expires = time.Now().Add(t.ttl).Unix()
+	ttl := t.TTL()
+	block.Expires = time.Now().Add(ttl).Unix()
 	buffer, err := block.Encode()
 	if err != nil {
 		return err
 	}
 
-	// Store the latest schema
-	t.schema.Store(block.Schema())
+	// Add the new columns to the schema
+	t.Evolve(block.Schema())
 
 	// Append the block to the store
-	return t.store.Append(key.New(string(block.Key), time.Unix(0, ts)), buffer, t.ttl)
+	return t.store.Append(key.New(name, at), buffer, ttl)
 }
 
-// getSchema gets the latest ingested schema.
-func (t *Table) getSchema() typeof.Schema {
-	if s := t.staticSchema; s != nil && len(*s) > 0 {
-		return *s
+// Evolve adds the columns to the schema of the table, such as the columns of an appended block, so the columns
+// missing from the latest blocks can still be queried, their type changing with the latest block. A column
+// added by concurrent appends might be missed, until the next block with that column is appended.
+func (t *Table) Evolve(schema typeof.Schema) {
+	current := t.getIngested()
+	if _, same := current.Compare(schema); same {
+		return
+	}
+
+	evolved := make(typeof.Schema, len(current)+len(schema))
+	for name, typ := range current {
+		evolved[name] = typ
+	}
+	for name, typ := range schema {
+		evolved[name] = typ
 	}
+	t.schema.Store(evolved)
+}
 
+// getIngested gets the schema of the ingested columns.
+func (t *Table) getIngested() typeof.Schema {
 	if s := t.schema.Load(); s != nil {
 		if schema, ok := s.(typeof.Schema); ok {
 			return schema
 		}
 	}
-
 	return typeof.Schema{}
 }
+
+// getSchema gets the latest ingested schema.
+func (t *Table) getSchema() typeof.Schema {
+	if s := t.staticSchema; s != nil && len(*s) > 0 {
+		return *s
+	}
+
+	return t.getIngested()
+}
+
+// eventTime converts the value of the time column to a time, guessing whether it is in unix seconds,
+// milliseconds, microseconds or nanoseconds, the same way as the presto time constraints.
+func eventTime(ts int64) time.Time {
+	watermark := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	switch {
+	case ts > watermark:
+		return time.Unix(0, ts)
+	case ts > watermark/1000:
+		return time.Unix(0, ts*1000)
+	case ts > watermark/1000000:
+		return time.Unix(0, ts*1000000)
+	default:
+		return time.Unix(ts, 0)
+	}
+}
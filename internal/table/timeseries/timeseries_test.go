@@ -4,16 +4,22 @@
 package timeseries_test
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/kelindar/talaria/internal/column"
 	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/encoding/block"
 	"github.com/kelindar/talaria/internal/encoding/typeof"
 	monitor2 "github.com/kelindar/talaria/internal/monitor"
 	"github.com/kelindar/talaria/internal/presto"
 	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
 	"github.com/kelindar/talaria/internal/storage/writer"
 	"github.com/kelindar/talaria/internal/table/timeseries"
 	"github.com/stretchr/testify/assert"
@@ -28,6 +34,30 @@ func (m noopMembership) Members() []string {
 	return []string{"127.0.0.1"}
 }
 
+type ownedMembership []string
+
+func (m ownedMembership) Members() []string {
+	return m
+}
+
+func (m ownedMembership) Replicas(hash uint32) []string {
+	i := int(hash % uint32(len(m)))
+	return []string{m[i], m[(i+1)%len(m)]}
+}
+
+type zonedMembership struct {
+	ownedMembership
+	zones map[string]string
+}
+
+func (m zonedMembership) Addr() string {
+	return "10.0.0.1:7946"
+}
+
+func (m zonedMembership) ZoneOf(addr string) string {
+	return m.zones[strings.TrimSuffix(addr, ":7946")]
+}
+
 type mockConfigurer struct {
 	dir string
 }
@@ -131,6 +161,14 @@ func TestTimeseries_DynamicSchema(t *testing.T) {
 		assert.Len(t, page.Columns, 2)
 		assert.Equal(t, 5, page.Columns[0].Count())
 	}
+
+	// Collecting the garbage keeps the data which did not expire
+	{
+		assert.NoError(t, eventlog.GC(context.Background()))
+		splits, err := eventlog.GetSplits([]string{}, newSplitQuery("110010100101010010101000100001", tableConf.HashBy), 10000)
+		assert.NoError(t, err)
+		assert.Len(t, splits, 1)
+	}
 }
 
 func TestTimeSeries_LoadStaticSchema(t *testing.T) {
@@ -165,6 +203,133 @@ int1: int64
 	assert.Equal(t, expectedSchema, actualSchema)
 }
 
+func TestTimeseries_AsOf(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy:     "string1",
+		SortBy:     "int1",
+		IngestedBy: "ingested",
+		TTL:        3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	eventlog := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+	assert.Equal(t, "ingested", eventlog.IngestedBy())
+	defer eventlog.Close()
+
+	// Append the same file twice, remembering the time in between
+	var asOf time.Time
+	for i := 0; i < 2; i++ {
+		b, err := ioutil.ReadFile(testFile3)
+		assert.NoError(t, err)
+		ingested, err := column.NewComputed(tableConf.IngestedBy, typeof.Int64, "make://ingestion", nil)
+		assert.NoError(t, err)
+		blocks, err := block.FromOrcBy(b, tableConf.HashBy, nil, block.Transform(nil, ingested))
+		assert.NoError(t, err)
+		for _, block := range blocks {
+			assert.NoError(t, eventlog.Append(block))
+		}
+
+		if i == 0 {
+			asOf = time.Now()
+		}
+	}
+
+	count := func(query *presto.PrestoThriftTupleDomain) int {
+		splits, err := eventlog.GetSplits([]string{}, query, 10000)
+		assert.NoError(t, err)
+		assert.Len(t, splits, 1)
+
+		page, err := eventlog.GetRows(splits[0].Key, []string{"string1"}, 1*1024*1024)
+		assert.NoError(t, err)
+		return page.Columns[0].Count()
+	}
+
+	// Without the constraint, both files are read
+	query := newSplitQuery("110010100101010010101000100001", tableConf.HashBy)
+	assert.Equal(t, 10, count(query))
+
+	// With the constraint, only the first file is read
+	query.Domains[tableConf.IngestedBy] = &presto.PrestoThriftDomain{
+		ValueSet: &presto.PrestoThriftValueSet{
+			RangeValueSet: &presto.PrestoThriftRangeValueSet{
+				Ranges: []*presto.PrestoThriftRange{{
+					Low: &presto.PrestoThriftMarker{Bound: presto.PrestoThriftBoundAbove},
+					High: &presto.PrestoThriftMarker{
+						Value: &presto.PrestoThriftBlock{
+							BigintData: &presto.PrestoThriftBigint{Nulls: []bool{false}, Longs: []int64{asOf.UnixNano()}},
+						},
+						Bound: presto.PrestoThriftBoundExactly,
+					},
+				}},
+			},
+		},
+	}
+	assert.Equal(t, 5, count(query))
+}
+
+func TestTimeseries_Ownership(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy: "string1",
+		SortBy: "int1",
+		TTL:    3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	members := ownedMembership{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	eventlog := timeseries.New(name, members, monitor, store, &tableConf, streams)
+	defer eventlog.Close()
+
+	// Only the replicas of the key are queried
+	splits, err := eventlog.GetSplits([]string{}, newSplitQuery("110010100101010010101000100001", tableConf.HashBy), 10000)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 1)
+	assert.Len(t, splits[0].Addrs, 2)
+	assert.Subset(t, []string(members), splits[0].Addrs)
+}
+
+func TestTimeseries_Zones(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy: "string1",
+		SortBy: "int1",
+		TTL:    3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	members := zonedMembership{
+		ownedMembership: ownedMembership{"10.0.0.2", "10.0.0.1"},
+		zones:           map[string]string{"10.0.0.1": "a", "10.0.0.2": "b"},
+	}
+
+	eventlog := timeseries.New(name, members, monitor, store, &tableConf, streams)
+	defer eventlog.Close()
+
+	// The replica in the same zone is listed first
+	for _, event := range []string{"a", "b", "c", "d"} {
+		splits, err := eventlog.GetSplits([]string{}, newSplitQuery(event, tableConf.HashBy), 10000)
+		assert.NoError(t, err)
+		assert.Len(t, splits, 1)
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, splits[0].Addrs)
+	}
+}
+
 func newSplitQuery(eventName, colName string) *presto.PrestoThriftTupleDomain {
 	return &presto.PrestoThriftTupleDomain{
 		Domains: map[string]*presto.PrestoThriftDomain{
@@ -197,3 +362,302 @@ func newSplitQuery(eventName, colName string) *presto.PrestoThriftTupleDomain {
 		},
 	}
 }
+
+func TestTimeseries_Rebalance(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy: "string1",
+		SortBy: "int1",
+		TTL:    3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	eventlog := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+	defer eventlog.Close()
+
+	b, err := ioutil.ReadFile(testFile3)
+	assert.NoError(t, err)
+	blocks, err := block.FromOrcBy(b, tableConf.HashBy, nil, block.Transform(nil))
+	assert.NoError(t, err)
+	for _, block := range blocks {
+		assert.NoError(t, eventlog.Append(block))
+	}
+
+	owned := func(hash uint32) (string, bool) { return "", false }
+	moved := func(hash uint32) (string, bool) { return "10.0.0.2", true }
+
+	// Nothing is moved while the keys are owned
+	n, err := eventlog.Rebalance(context.Background(), owned, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// If the blocks can not be moved, they are kept
+	n, err = eventlog.Rebalance(context.Background(), moved, func(_ context.Context, addr string, _ []block.Block) error {
+		return fmt.Errorf("%s is unreachable", addr)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// The blocks are moved to their owner and deleted
+	var received []block.Block
+	n, err = eventlog.Rebalance(context.Background(), moved, func(_ context.Context, addr string, b []block.Block) error {
+		assert.Equal(t, "10.0.0.2", addr)
+		received = append(received, b...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(blocks), n)
+	assert.Len(t, received, len(blocks))
+
+	n, err = eventlog.Rebalance(context.Background(), moved, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestTimeseries_LargeBlock(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy: "event",
+		SortBy: "int1",
+		TTL:    3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	eventlog := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+	defer eventlog.Close()
+
+	// Append a single block of 1000 rows
+	events, ints := new(presto.PrestoThriftVarchar), new(presto.PrestoThriftBigint)
+	for i := 0; i < 1000; i++ {
+		events.Append("a")
+		ints.Append(int64(i))
+	}
+
+	blk, err := block.FromColumns("a", column.Columns{"event": events, "int1": ints})
+	assert.NoError(t, err)
+	assert.NoError(t, eventlog.Append(blk))
+
+	splits, err := eventlog.GetSplits([]string{}, newSplitQuery("a", tableConf.HashBy), 10000)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 1)
+
+	// The block is read in several pages, each within the size requested
+	var pages int
+	var values []int64
+	for split := splits[0].Key; split != nil; pages++ {
+		page, err := eventlog.GetRows(split, []string{"int1"}, 1000)
+		assert.NoError(t, err)
+		assert.True(t, page.Columns[0].Size() <= 1000)
+
+		values = append(values, page.Columns[0].(*presto.PrestoThriftBigint).Longs...)
+		split = page.NextToken
+	}
+
+	assert.True(t, pages > 10)
+	assert.Len(t, values, 1000)
+	for i, v := range values {
+		assert.Equal(t, int64(i), v)
+	}
+
+	// A page too small for a single row still reads one row at a time
+	page, err := eventlog.GetRows(splits[0].Key, []string{"int1"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, page.Columns[0].Count())
+	assert.NotNil(t, page.NextToken)
+}
+
+func TestTimeseries_EventTime(t *testing.T) {
+	now := time.Now()
+	for policy, expect := range map[string][2]int{
+		"partition": {2, 1},
+		"drop":      {2, 0},
+		"metric":    {3, 0},
+	} {
+		dir, _ := ioutil.TempDir(".", "testdata-")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		const name = "eventlog"
+		tableConf := config.Table{
+			HashBy:    "event",
+			SortBy:    "tsi",
+			TTL:       3600,
+			EventTime: &config.EventTime{Lateness: 60, Late: policy},
+		}
+
+		monitor := monitor2.NewNoop()
+		store := disk.Open(dir, name, monitor, config.Badger{})
+		streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+		eventlog := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+
+		// The second block arrives an hour late, the third one within the lateness
+		for _, at := range []time.Time{now, now.Add(-time.Hour), now.Add(-30 * time.Second)} {
+			events, times := new(presto.PrestoThriftVarchar), new(presto.PrestoThriftBigint)
+			events.Append("a")
+			times.Append(at.Unix())
+
+			blk, err := block.FromColumns("a", column.Columns{"event": events, "tsi": times})
+			assert.NoError(t, err)
+			assert.NoError(t, eventlog.Append(blk))
+		}
+
+		count := func(query *presto.PrestoThriftTupleDomain) int {
+			splits, err := eventlog.GetSplits([]string{}, query, 10000)
+			assert.NoError(t, err)
+
+			var rows int
+			for split := splits[0].Key; split != nil; {
+				page, err := eventlog.GetRows(split, []string{"tsi"}, 1*1024*1024)
+				assert.NoError(t, err)
+				rows += page.Columns[0].Count()
+				split = page.NextToken
+			}
+			return rows
+		}
+
+		// The rows are keyed by their event time, so a time range only reads the rows within it
+		recent := newSplitQuery("a", tableConf.HashBy)
+		recent.Domains[tableConf.SortBy] = &presto.PrestoThriftDomain{
+			ValueSet: &presto.PrestoThriftValueSet{
+				RangeValueSet: &presto.PrestoThriftRangeValueSet{
+					Ranges: []*presto.PrestoThriftRange{{
+						Low: &presto.PrestoThriftMarker{
+							Value: &presto.PrestoThriftBlock{
+								BigintData: &presto.PrestoThriftBigint{Nulls: []bool{false}, Longs: []int64{now.Add(-time.Minute).Unix()}},
+							},
+							Bound: presto.PrestoThriftBoundAbove,
+						},
+					}},
+				},
+			},
+		}
+
+		assert.Equal(t, expect[0], count(newSplitQuery("a", tableConf.HashBy)), policy)
+		assert.Equal(t, expect[1], count(newSplitQuery("late/a", tableConf.HashBy)), policy)
+		assert.Equal(t, 2, count(recent), policy)
+		assert.NoError(t, eventlog.Close())
+	}
+}
+
+func TestTimeseries_KeyRange(t *testing.T) {
+	now := time.Now()
+	for _, eventTime := range []*config.EventTime{nil, {Late: "metric"}} {
+		dir, _ := ioutil.TempDir(".", "testdata-")
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		const name = "metrics"
+		tableConf := config.Table{
+			HashBy:    "name",
+			SortBy:    "time",
+			TTL:       3600,
+			EventTime: eventTime,
+		}
+
+		monitor := monitor2.NewNoop()
+		store := disk.Open(dir, name, monitor, config.Badger{})
+		streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+		metrics := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+
+		// The samples are in unix milliseconds, the first block being two months old
+		for _, at := range []time.Time{now.Add(-60 * 24 * time.Hour), now} {
+			names, times := new(presto.PrestoThriftVarchar), new(presto.PrestoThriftBigint)
+			names.Append("up")
+			times.Append(at.UnixNano() / 1e6)
+
+			blk, err := block.FromColumns("up", column.Columns{"name": names, "time": times})
+			assert.NoError(t, err)
+			assert.NoError(t, metrics.Append(blk))
+		}
+
+		count := func(query *presto.PrestoThriftTupleDomain) int {
+			splits, err := metrics.GetSplits([]string{}, query, 10000)
+			assert.NoError(t, err)
+
+			var rows int
+			for split := splits[0].Key; split != nil; {
+				page, err := metrics.GetRows(split, []string{"time"}, 1*1024*1024)
+				assert.NoError(t, err)
+				rows += page.Columns[0].Count()
+				split = page.NextToken
+			}
+			return rows
+		}
+
+		// Only the recent block is read over the last minutes
+		recent := newSplitQuery("up", tableConf.HashBy)
+		domain, ok := metrics.KeyRange(now.Add(-2*time.Minute).UnixNano()/1e6, now.UnixNano()/1e6)
+		assert.True(t, ok)
+		recent.Domains[tableConf.SortBy] = domain
+
+		assert.Equal(t, 2, count(newSplitQuery("up", tableConf.HashBy)))
+		assert.Equal(t, 1, count(recent))
+		assert.NoError(t, metrics.Close())
+	}
+}
+
+func TestTimeseries_Purge(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "testdata-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const name = "eventlog"
+	tableConf := config.Table{
+		HashBy: "event",
+		SortBy: "tsi",
+		TTL:    3600,
+	}
+
+	monitor := monitor2.NewNoop()
+	store := disk.Open(dir, name, monitor, config.Badger{})
+	streams, _ := writer.ForStreaming(config.Streams{}, monitor, nil)
+	eventlog := timeseries.New(name, new(noopMembership), monitor, store, &tableConf, streams)
+	defer eventlog.Close()
+
+	now := time.Now()
+	for _, event := range []string{"a", "b"} {
+		events, times := new(presto.PrestoThriftVarchar), new(presto.PrestoThriftBigint)
+		for _, at := range []time.Time{now.Add(-time.Hour), now} {
+			events.Append(event)
+			times.Append(at.Unix())
+		}
+
+		blk, err := block.FromColumns(event, column.Columns{"event": events, "tsi": times})
+		assert.NoError(t, err)
+		assert.NoError(t, eventlog.Append(blk))
+	}
+
+	count := func(event string) int {
+		splits, err := eventlog.GetSplits([]string{}, newSplitQuery(event, tableConf.HashBy), 10000)
+		assert.NoError(t, err)
+
+		var rows int
+		for split := splits[0].Key; split != nil; {
+			page, err := eventlog.GetRows(split, []string{"tsi"}, 1*1024*1024)
+			assert.NoError(t, err)
+			rows += page.Columns[0].Count()
+			split = page.NextToken
+		}
+		return rows
+	}
+
+	// Delete every row of a key
+	purged, err := eventlog.Purge(context.Background(), tombstone.Tombstone{Table: name, Key: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, 0, count("a"))
+	assert.Equal(t, 2, count("b"))
+
+	// Delete the rows within a time range, the remaining rows being kept
+	purged, err = eventlog.Purge(context.Background(), tombstone.Tombstone{Table: name, Until: now.Add(-time.Minute).UnixNano()})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, 1, count("b"))
+}
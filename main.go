@@ -5,37 +5,63 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	eorc "github.com/crphang/orc"
 	"github.com/gorilla/mux"
 	"github.com/kelindar/lua"
+	"github.com/kelindar/talaria/internal/bench"
 	"github.com/kelindar/talaria/internal/config"
 	"github.com/kelindar/talaria/internal/config/env"
 	"github.com/kelindar/talaria/internal/config/s3"
+	"github.com/kelindar/talaria/internal/config/secret"
 	"github.com/kelindar/talaria/internal/config/static"
+	"github.com/kelindar/talaria/internal/encoding/block"
+	"github.com/kelindar/talaria/internal/export"
+	"github.com/kelindar/talaria/internal/inspect"
 	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/errors"
 	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/kelindar/talaria/internal/monitor/profile"
+	"github.com/kelindar/talaria/internal/monitor/sink"
 	"github.com/kelindar/talaria/internal/monitor/statsd"
 	script "github.com/kelindar/talaria/internal/scripting"
 	mlog "github.com/kelindar/talaria/internal/scripting/log"
 	mnet "github.com/kelindar/talaria/internal/scripting/net"
 	mstats "github.com/kelindar/talaria/internal/scripting/stats"
 	"github.com/kelindar/talaria/internal/server"
+	"github.com/kelindar/talaria/internal/server/audit"
+	"github.com/kelindar/talaria/internal/server/catalog"
+	"github.com/kelindar/talaria/internal/server/certs"
 	"github.com/kelindar/talaria/internal/server/cluster"
+	"github.com/kelindar/talaria/internal/server/health"
+	"github.com/kelindar/talaria/internal/server/registry"
 	"github.com/kelindar/talaria/internal/storage"
+	"github.com/kelindar/talaria/internal/storage/compact"
 	"github.com/kelindar/talaria/internal/storage/disk"
+	"github.com/kelindar/talaria/internal/storage/downsample"
+	"github.com/kelindar/talaria/internal/storage/tombstone"
 	"github.com/kelindar/talaria/internal/storage/writer"
+	filewriter "github.com/kelindar/talaria/internal/storage/writer/file"
+	s3writer "github.com/kelindar/talaria/internal/storage/writer/s3"
 	"github.com/kelindar/talaria/internal/table"
+	"github.com/kelindar/talaria/internal/table/events"
 	"github.com/kelindar/talaria/internal/table/log"
 	"github.com/kelindar/talaria/internal/table/nodes"
+	"github.com/kelindar/talaria/internal/table/system"
 	"github.com/kelindar/talaria/internal/table/timeseries"
+	talaria "github.com/kelindar/talaria/proto"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -43,26 +69,62 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspect(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
+	validate := flag.Bool("validate", false, "validates the config and exits, with a non-zero status if it is invalid")
+	flag.Parse()
+	if *validate {
+		os.Exit(validateConfig())
+	}
+
 	eorc.DefaultCompressionChunkSize = 16 * eorc.DefaultCompressionChunkSize
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	s3Configurer := s3.New(logging.NewStandard())
-	configure := config.Load(ctx, 60*time.Second, static.New(), env.New("TALARIA"), s3Configurer)
+	configure := config.Load(ctx, 60*time.Second, static.New(), env.New("TALARIA"), s3Configurer, secret.New())
 	conf := configure()
 
-	// Setup gossip
-	gossip := cluster.New(7946)
+	// Setup gossip, advertising the availability zone of the node
+	gossip, err := newGossip(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	if conf.Cluster.Zone != "" {
+		if err := gossip.SetZone(conf.Cluster.Zone); err != nil {
+			panic(err)
+		}
+	}
 
 	// Create a log table and a simple stdout monitor
+	stdout := newLogger(conf.Logging)
 	stats := statsd.New(conf.Statsd.Host, int(conf.Statsd.Port))
 	logTable := log.New(configure, gossip, monitor.New(
-		logging.NewStandard(), stats, conf.AppName, conf.Env), // Use stdout monitor
+		stdout, stats, conf.AppName, conf.Env), // Use stdout monitor
 	)
 
-	// Setup the final logger and a monitor
-	logger := logging.NewComposite(logTable, logging.NewStandard())
-	monitor := monitor.New(logger, stats, conf.AppName, conf.Env)
+	// Setup the final logger and a monitor, forwarding the errors to the sinks (if configured)
+	tables := []table.Table{nodes.New(gossip), logTable}
+	logger := logging.NewComposite(logTable, stdout)
+
+	// Record the operational events into their own table, if configured
+	if conf.Events != nil {
+		eventTable := events.New(configure, gossip, monitor.New(stdout, stats, conf.AppName, conf.Env))
+		tables = append(tables, eventTable)
+		logger = logging.NewComposite(logTable, stdout, eventTable)
+	}
+	monitor := monitor.New(logger, stats, conf.AppName, conf.Env, newErrorSinks(conf.Errors)...)
 
 	// Updating the logger to use the composite logger. This is to make sure the logs from the config is sent to log table as well as stdout
 	s3Configurer.SetLogger(logger)
@@ -73,30 +135,130 @@ func main() {
 		mstats.New(monitor),
 		mnet.New(monitor),
 	})
+	loader.SetMonitor(monitor)
+	loader.SetLimits(conf.Scripting)
+
+	// Assign the hash keys to the nodes with a consistent hash ring, if configured
+	var membership cluster.Membership = gossip
+	var ring *cluster.Ring
+	if conf.Cluster.Ownership {
+		ring = cluster.NewRing(gossip, conf.Cluster.VNodes, conf.Cluster.Replicas)
+		membership = ring
+	}
+
+	// The tables hand the blocks over to their compaction leader through the server, created afterwards
+	var srv *server.Server
+	handover := func(addr, table string, blocks []block.Block) error {
+		return srv.Handover(addr, table, blocks)
+	}
+
+	// The tables append the aggregates of their downsampled rows to the tables of their tiers through the server
+	appendTo := func(table string, blocks []block.Block) error {
+		return srv.AppendTo(table, blocks)
+	}
+
+	// Record the deletions of the rows, applied to the tables and to their compaction on every node
+	tombstones, err := tombstone.New(conf.Storage.Directory)
+	if err != nil {
+		panic(err)
+	}
+	tombstones.SetBroadcast(gossip.ReplicateAs("tombstones", tombstones))
 
 	// Open every table configured
-	tables := []table.Table{nodes.New(gossip), logTable}
+	open := func(name string, tableConf config.Table) (table.Table, error) {
+		return openTable(name, conf.Storage, conf.Cluster, tableConf, membership, gossip, handover, appendTo, tombstones, monitor, loader)
+	}
+
 	for name, tableConf := range conf.Tables {
-		tables = append(tables, openTable(name, conf.Storage, tableConf, gossip, monitor, loader))
+		t, err := open(name, tableConf)
+		if err != nil {
+			panic(err)
+		}
+		tables = append(tables, t)
 	}
 
 	// Start the new server
 	server := server.New(configure, monitor, loader, tables...)
+	srv = server
+	server.Register(system.New(server, membership)...)
+	server.SetMembership(gossip)
+	server.SetOpener(open) // Open the tables added to the config at runtime
+
+	// Record the administrative operations and the denied queries, if configured
+	trail, err := newAudit(ctx, conf.Audit, gossip.Addr(), monitor)
+	if err != nil {
+		panic(err)
+	}
+	server.SetAudit(trail)
 
-	// onSignal will be called when a OS-level signal is received.
+	// Create, alter and drop tables at runtime, replicating the changes to the other nodes through the gossip
+	tableCatalog, err := catalog.New(conf.Storage.Directory)
+	if err != nil {
+		panic(err)
+	}
+
+	tableCatalog.SetBroadcast(gossip.Broadcast)
+	gossip.Replicate(tableCatalog)
+	server.SetCatalog(tableCatalog)
+	server.SetTombstones(tombstones)
+
+	// Version the schemas of the tables as the ingested columns evolve, replicating them to the other nodes
+	schemas, err := registry.New(conf.Storage.Directory)
+	if err != nil {
+		panic(err)
+	}
+
+	schemas.SetBroadcast(gossip.ReplicateAs("schemas", schemas))
+	server.SetRegistry(schemas)
+	if ring != nil {
+		server.SetOwnership(ring)
+	}
+
+	// drain will be called when a OS-level signal is received or when requested through the admin API. The
+	// server is drained and its data flushed before leaving the cluster, and the process only exits once done.
+	var once sync.Once
+	drained := make(chan struct{})
+	drain := func() {
+		once.Do(func() {
+			server.Close() // Drain the server and flush the tables
+			if err := trail.Close(); err != nil {
+				monitor.Warning(err)
+			}
+
+			if err := gossip.Close(); err != nil {
+				monitor.Warning(errors.Internal("server: unable to leave the cluster", err))
+			}
+
+			cancel() // Cancel the context
+			close(drained)
+		})
+	}
+
+	server.OnDrain(drain)
 	onSignal(func(_ os.Signal) {
-		cancel()       // Cancel the context
-		gossip.Close() // Close the gossip layer
-		server.Close() // Close the server and database
+		drain()
 	})
 
-	// Join the cluster
-	monitor.Info("server: joining cluster on %s...", conf.Domain)
-	gossip.JoinHostname(conf.Domain)
+	// Join the cluster and keep discovering the peers
+	discovery, err := newDiscovery(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	monitor.Info("server: joining cluster with %T discovery...", discovery)
+	gossip.Discover(ctx, discovery, discoveryInterval(conf.Cluster.Discovery), monitor)
 
 	// run HTTP server for readiness and liveness probes if k8s config is set
 	if conf.K8s != nil {
-		startHTTPServerAsync(conf.K8s.ProbePort)
+		probes := health.New(5 * time.Second)
+		probes.Ready("disk", health.Headroom(conf.Storage.Directory, headroomOf(conf.K8s)))
+		server.HealthChecks(probes)
+		startHTTPServerAsync(conf.K8s.ProbePort, probes)
+	}
+
+	// Serve the pprof handlers on their own port and upload the profiles periodically, if configured
+	if conf.Profiling != nil {
+		startProfilingAsync(ctx, conf.Profiling, gossip.Addr(), monitor)
 	}
 
 	// Start listenHandler
@@ -105,29 +267,471 @@ func main() {
 	if err := server.Listen(ctx, conf.Readers.Presto.Port, conf.Writers.GRPC.Port); err != nil {
 		panic(err)
 	}
+
+	// Wait for the server to be drained
+	<-drained
 }
 
 // openTable creates a new table with storage & optional compaction fully configured
-func openTable(name string, storageConf config.Storage, tableConf config.Table, cluster cluster.Membership, monitor monitor.Monitor, loader *script.Loader) table.Table {
-	monitor.Info("server: opening table %s...", name)
+func openTable(name string, storageConf config.Storage, clusterConf config.Cluster, tableConf config.Table, membership cluster.Membership,
+	gossip *cluster.Cluster, handover func(addr, table string, blocks []block.Block) error, appendTo downsample.Appender,
+	tombstones *tombstone.Log, monitor monitor.Monitor, loader *script.Loader) (table.Table, error) {
+	monitor.Log(logging.LevelInfo, "server: opening table...", logging.F("table", name))
 
 	// Create a new storage layer and optional compaction
-	store := storage.Storage(disk.Open(storageConf.Directory, name, monitor, storageConf.Badger))
+	store := openBuffer(name, storageConf, tableConf, monitor)
 	if tableConf.Compact != nil {
-		var err error
-		store, err = writer.ForCompaction(tableConf.Compact, monitor, store, loader)
+		compactor, err := writer.ForCompaction(tableConf.Compact, monitor, store, loader)
 		if err != nil {
-			panic(err)
+			_ = store.Close()
+			return nil, err
+		}
+
+		compactor.SetName(name)
+
+		// When replicated, only the owner of a key compacts it
+		if ring, ok := membership.(compact.Ownership); ok && clusterConf.Replicas > 1 {
+			compactor.SetOwnership(ring)
+		}
+
+		// When coordinated, only the leader of the table writes the compacted files
+		if tableConf.Compact.Coordinate {
+			compactor.SetCoordinator(func() (string, bool) {
+				return gossip.Leader(name)
+			}, func(addr string, blocks []block.Block) error {
+				return handover(addr, name, blocks)
+			})
 		}
+
+		// Aggregate the rows into the tables of the tiers as they are compacted
+		tiers := make([]compact.BlockWriter, 0, len(tableConf.Downsample))
+		for _, tier := range tableConf.Downsample {
+			tiers = append(tiers, downsample.New(tier, tableConf.HashBy, tableConf.SortBy, appendTo))
+		}
+		compactor.SetDownsampling(tiers...)
+
+		// Remove the rows deleted since they were buffered
+		filter := tombstone.NewFilter(tableConf.HashBy, tableConf.SortBy)
+		compactor.SetFilter(func(blocks []block.Block) ([]block.Block, error) {
+			out, _, err := filter.Apply(tombstones.Of(name), blocks)
+			return out, err
+		})
+		store = compactor
 	}
 
 	// Returns noop streamer if array is empty
 	streams, err := writer.ForStreaming(tableConf.Streams, monitor, loader)
 	if err != nil {
-		panic(err)
+		_ = store.Close()
+		return nil, err
+	}
+
+	t := timeseries.New(name, membership, monitor, store, &tableConf, streams)
+
+	// Route the rows rejected by the strict schema to their destination
+	if strict := tableConf.Strict; strict != nil && len(strict.Errors) > 0 {
+		rejects, err := writer.ForStreaming(strict.Errors, monitor, loader)
+		if err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+		t.SetRejects(rejects)
+	}
+	return t, nil
+}
+
+// openBuffer opens the local storage of a table, which is only opened once used and closed once idle if an idle
+// duration is configured
+func openBuffer(name string, storageConf config.Storage, tableConf config.Table, monitor monitor.Monitor) storage.Storage {
+	if storageConf.Idle > 0 {
+		buffer := disk.OpenLazy(storageConf.Directory, name, monitor, storageConf.Badger, time.Duration(storageConf.Idle)*time.Second)
+		if batch := tableConf.Batch; batch != nil {
+			buffer.SetBatch(batch.Size, time.Duration(batch.Delay)*time.Millisecond)
+		}
+		return buffer
+	}
+
+	buffer := disk.Open(storageConf.Directory, name, monitor, storageConf.Badger)
+	if batch := tableConf.Batch; batch != nil {
+		buffer.SetBatch(batch.Size, time.Duration(batch.Delay)*time.Millisecond)
+	}
+	return buffer
+}
+
+// validateConfig loads and validates the config, without resolving its secrets which may not be available
+// where it is validated, such as in CI.
+func validateConfig() int {
+	sources := s3.New(logging.NewNoop())
+	sources.SetStrict(true)
+
+	conf, err := config.Check(static.New(), env.New("TALARIA"), sources)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config is invalid: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("config is valid, version %s with %d table(s)\n", conf.Version, len(conf.Tables))
+	return 0
+}
+
+// runBench sends synthetic events matching the schema of a table to the gRPC or HTTP ingress of a node, at a
+// target rate, and prints the throughput, the latency of the requests and the allocations per event.
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	grpcAddr := flags.String("grpc", "localhost:8080", "the address of the gRPC ingress")
+	httpAddr := flags.String("http", "", "the base URL of the HTTP ingress, such as http://localhost:8081, used instead of gRPC if set")
+	tableName := flags.String("table", "", "the table to ingest into, whose schema is read from the config unless given")
+	schema := flags.String("schema", "", "the inline schema of the events, such as {event: string, tsi: int64, value: float64}")
+	hashBy := flags.String("hashBy", "", "the column used as key, defaults to the one of the table or 'event'")
+	sortBy := flags.String("sortBy", "", "the column used as time, defaults to the one of the table or 'tsi'")
+	token := flags.String("token", "", "the API key or JSON Web Token of the producer")
+	rate := flags.Int("rate", 1000, "the target number of events per second, unlimited if zero")
+	batch := flags.Int("batch", 100, "the number of events per request")
+	concurrency := flags.Int("concurrency", 4, "the number of requests in flight")
+	duration := flags.Duration("duration", 30*time.Second, "the duration of the benchmark")
+	_ = flags.Parse(args)
+
+	// Read the schema and the key columns of the table from the config, unless given
+	options := bench.Options{
+		HashBy:      *hashBy,
+		SortBy:      *sortBy,
+		Rate:        *rate,
+		Batch:       *batch,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+	}
+
+	if *schema == "" && *tableName != "" {
+		conf, err := config.Check(static.New(), env.New("TALARIA"), s3.New(logging.NewNoop()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config is invalid: %s\n", err)
+			return 1
+		}
+
+		tableConf, ok := conf.Tables[*tableName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "table %s is not configured\n", *tableName)
+			return 1
+		}
+
+		*schema = tableConf.Schema
+		options.HashBy = firstOf(options.HashBy, tableConf.HashBy)
+		options.SortBy = firstOf(options.SortBy, tableConf.SortBy)
+	}
+
+	if err := yaml.Unmarshal([]byte(*schema), &options.Schema); err != nil {
+		fmt.Fprintf(os.Stderr, "schema is invalid, only an inline schema is supported: %s\n", err)
+		return 1
+	}
+	options.HashBy = firstOf(options.HashBy, "event")
+	options.SortBy = firstOf(options.SortBy, "tsi")
+
+	// Send the events through either the HTTP or the gRPC ingress
+	var tables []string
+	if *tableName != "" {
+		tables = append(tables, *tableName)
+	}
+
+	var sender bench.Sender
+	var err error
+	if *httpAddr != "" {
+		sender, err = bench.NewHTTP(*httpAddr, *token, 30*time.Second, tables...)
+	} else {
+		sender, err = bench.NewGRPC(*grpcAddr, *token, *concurrency, 30*time.Second, tables...)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to connect: %s\n", err)
+		return 1
+	}
+	defer sender.Close()
+
+	// Stop early on a signal, still reporting the requests sent so far
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onSignal(func(_ os.Signal) {
+		cancel()
+	})
+
+	report := bench.Run(ctx, sender, options)
+	fmt.Print(report.String())
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runInspect opens the data directory of a stopped node read-only and prints the tables it stores, with their
+// rows, keys, time bounds and size on disk, or dumps the rows of a key range of a table as newline-delimited JSON.
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	dir := flags.String("dir", "", "the data directory of the node, defaults to the storage directory of the config")
+	tableName := flags.String("table", "", "the table to inspect, every table of the directory if empty")
+	keys := flags.Bool("keys", false, "lists the keys of the tables, with their rows and time bounds")
+	dump := flags.Bool("dump", false, "dumps the rows of the table as newline-delimited JSON instead")
+	keyName := flags.String("key", "", "the key of the rows to dump, every key if empty")
+	from := flags.String("from", "", "the time of the oldest block to dump, in RFC 3339")
+	until := flags.String("until", "", "the time of the newest block to dump, in RFC 3339")
+	output := flags.String("out", "", "the file to dump the rows to, stdout if empty")
+	_ = flags.Parse(args)
+
+	// Read the storage directory from the config, unless given
+	if *dir == "" {
+		conf, err := config.Check(static.New(), env.New("TALARIA"), s3.New(logging.NewNoop()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config is invalid, the directory must be given: %s\n", err)
+			return 1
+		}
+		*dir = conf.Storage.Directory
+	}
+
+	if *dump {
+		if *tableName == "" {
+			fmt.Fprintln(os.Stderr, "the table to dump must be given")
+			return 1
+		}
+
+		r, err := rangeOf(*keyName, *from, *until)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "time is invalid: %s\n", err)
+			return 1
+		}
+
+		w := os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to create the output: %s\n", err)
+				return 1
+			}
+			defer f.Close()
+			w = f
+		}
+
+		count, err := inspect.Dump(*dir, *tableName, r, w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to dump %s: %s\n", *tableName, err)
+			return 1
+		}
+
+		fmt.Fprintf(os.Stderr, "dumped %d row(s)\n", count)
+		return 0
 	}
 
-	return timeseries.New(name, cluster, monitor, store, &tableConf, streams)
+	// Summarize the table, or every table of the directory
+	tables := []string{*tableName}
+	if *tableName == "" {
+		var err error
+		if tables, err = inspect.Tables(*dir); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to read %s: %s\n", *dir, err)
+			return 1
+		}
+	}
+
+	status := 0
+	for _, name := range tables {
+		summary, err := inspect.Summarize(*dir, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to inspect %s: %s\n", name, err)
+			status = 1
+			continue
+		}
+		fmt.Println(summary.Report(*keys))
+	}
+	return status
+}
+
+// runExport reads the rows of a table from the data directory of a stopped node, or from a live node through its
+// gRPC query API, and writes them as ORC or Parquet files to a local directory or to S3.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := flags.String("dir", "", "the data directory of the node, defaults to the storage directory of the config")
+	grpcAddr := flags.String("grpc", "", "the address of the gRPC API of a live node to read from instead, such as localhost:8080")
+	tableName := flags.String("table", "", "the table to export")
+	keyName := flags.String("key", "", "the key of the rows to export from the directory, every key if empty")
+	from := flags.String("from", "", "the time of the oldest block to export from the directory, in RFC 3339")
+	until := flags.String("until", "", "the time of the newest block to export from the directory, in RFC 3339")
+	filter := flags.String("filter", "", "the filter of the rows to read from a live node, such as \"event == 'click'\"")
+	columns := flags.String("columns", "", "the comma-separated columns to read from a live node, every column if empty")
+	format := flags.String("format", "orc", "the format of the files, either orc or parquet")
+	compression := flags.String("compression", "", "the compression of the files, defaults to zlib for orc and snappy for parquet")
+	size := flags.Int64("size", 128, "the size of the rows of a file before encoding, in megabytes")
+	output := flags.String("out", ".", "the directory or the s3://bucket/prefix to write the files to")
+	region := flags.String("region", "", "the region of the S3 bucket")
+	_ = flags.Parse(args)
+
+	if *tableName == "" {
+		fmt.Fprintln(os.Stderr, "the table to export must be given")
+		return 1
+	}
+
+	// Write the files either locally or to S3
+	var sink export.Sink
+	var err error
+	if strings.HasPrefix(*output, "s3://") {
+		bucket, prefix := strings.TrimPrefix(*output, "s3://"), ""
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, prefix = bucket[:i], bucket[i+1:]
+		}
+		sink, err = s3writer.New(bucket, prefix, *region, s3writer.Endpoint{}, "", "", "", 0)
+	} else {
+		sink, err = filewriter.New(*output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write to %s: %s\n", *output, err)
+		return 1
+	}
+
+	// Read the blocks either from a live node or from the directory
+	var source export.Source
+	ctx := context.Background()
+	switch {
+	case *grpcAddr != "":
+		conn, err := grpc.Dial(*grpcAddr, grpc.WithInsecure())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to connect: %s\n", err)
+			return 1
+		}
+		defer conn.Close()
+
+		client := talaria.NewQueryClient(conn)
+		names := strings.Split(*columns, ",")
+		if *columns == "" {
+			if names, err = export.ColumnsOf(ctx, client, *tableName); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to describe %s: %s\n", *tableName, err)
+				return 1
+			}
+		}
+
+		var filters []string
+		if *filter != "" {
+			filters = append(filters, *filter)
+		}
+		source = export.FromNode(ctx, client, *tableName, names, filters...)
+	default:
+		if *dir == "" {
+			conf, err := config.Check(static.New(), env.New("TALARIA"), s3.New(logging.NewNoop()))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "config is invalid, the directory must be given: %s\n", err)
+				return 1
+			}
+			*dir = conf.Storage.Directory
+		}
+
+		r, err := rangeOf(*keyName, *from, *until)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "time is invalid: %s\n", err)
+			return 1
+		}
+		source = export.FromDirectory(*dir, *tableName, r)
+	}
+
+	report, err := export.Run(source, sink, *tableName, export.Options{
+		Format:      *format,
+		Compression: *compression,
+		FileSize:    *size * 1024 * 1024,
+	})
+	if report != nil {
+		fmt.Print(report.String())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to export %s: %s\n", *tableName, err)
+		return 1
+	}
+	return 0
+}
+
+// rangeOf returns the range of the blocks of a key between two times in RFC 3339, each optional
+func rangeOf(key, from, until string) (r inspect.Range, err error) {
+	r.Key = key
+	if from != "" {
+		if r.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return
+		}
+	}
+	if until != "" {
+		r.Until, err = time.Parse(time.RFC3339, until)
+	}
+	return
+}
+
+// firstOf returns the first non-empty value
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// newGossip creates the gossip of the cluster, encrypted with the keys and secured with mutual TLS if configured
+func newGossip(conf *config.Config) (*cluster.Cluster, error) {
+	keys, err := cluster.DecodeKeys(conf.Cluster.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	security := cluster.Security{Keys: keys}
+	if conf.Cluster.TLS != nil {
+		node, err := certs.New(conf.Cluster.TLS)
+		if err != nil {
+			return nil, err
+		}
+		security.Server, security.Client = node.ServerConfig(), node.ClientConfig()
+	}
+
+	return cluster.NewSecure(7946, security)
+}
+
+// newDiscovery creates the mechanism used to discover the peers of the cluster
+func newDiscovery(conf *config.Config) (cluster.Discovery, error) {
+	switch d := conf.Cluster.Discovery; d.Provider {
+	case "kubernetes":
+		return cluster.NewKubernetes(d.Namespace, d.Service)
+	case "static":
+		return &cluster.Static{Addrs: d.Peers, SRV: d.SRV}, nil
+	case "", "dns":
+		return cluster.DNS(conf.Domain), nil
+	default:
+		return nil, fmt.Errorf("cluster: unsupported discovery provider %s", d.Provider)
+	}
+}
+
+// discoveryInterval returns the interval at which the peers are discovered, defaults to 30 seconds
+func discoveryInterval(conf config.Discovery) time.Duration {
+	if conf.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(conf.Interval) * time.Second
+}
+
+// newLogger creates the logger writing to stdout and stderr, either as text or as lines of JSON
+func newLogger(conf config.Logging) logging.Logger {
+	if conf.Format != "json" {
+		return logging.NewStandard()
+	}
+
+	return logging.NewJSON(os.Stdout, logging.ParseLevel(conf.Level), logging.NewSampler(conf.Initial, conf.Thereafter))
+}
+
+// newErrorSinks creates the sinks to which the errors are forwarded
+func newErrorSinks(conf *config.Errors) (sinks []sink.Sink) {
+	if conf == nil {
+		return nil
+	}
+
+	if conf.Sentry != "" {
+		sentry, err := sink.NewSentry(conf.Sentry)
+		if err != nil {
+			panic(err)
+		}
+		sinks = append(sinks, sentry)
+	}
+
+	if conf.Webhook != "" {
+		sinks = append(sinks, sink.NewWebhook(conf.Webhook))
+	}
+	return
 }
 
 // onSignal hooks a callback for a signal.
@@ -141,12 +745,19 @@ func onSignal(callback func(sig os.Signal)) {
 	}()
 }
 
-func startHTTPServerAsync(portNum int32) {
+// headroomOf returns the minimum fraction of free disk space, defaults to 5%
+func headroomOf(conf *config.K8s) float64 {
+	if conf.Headroom <= 0 {
+		return 0.05
+	}
+	return conf.Headroom
+}
+
+func startHTTPServerAsync(portNum int32, probes *health.Health) {
 	go func() {
 		handler := mux.NewRouter()
-		handler.HandleFunc("/healthz", func(resp http.ResponseWriter, req *http.Request) {
-			_, _ = resp.Write([]byte(`talaria-health-check`))
-		}).Methods(http.MethodGet, http.MethodHead)
+		handler.HandleFunc("/healthz", probes.HandleLive).Methods(http.MethodGet, http.MethodHead)
+		handler.HandleFunc("/readyz", probes.HandleReady).Methods(http.MethodGet, http.MethodHead)
 		handler.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 
 		server := &http.Server{
@@ -158,3 +769,75 @@ func startHTTPServerAsync(portNum int32) {
 		}
 	}()
 }
+
+// startProfilingAsync serves the pprof handlers and uploads the heap and CPU profiles of the node to S3
+func startProfilingAsync(ctx context.Context, conf *config.Profiling, node string, monitor monitor.Monitor) {
+	if conf.Port != 0 {
+		go func() {
+			server := &http.Server{
+				Addr:    fmt.Sprintf(":%d", conf.Port),
+				Handler: profile.Handler(),
+			}
+			if err := server.ListenAndServe(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	if conf.S3 == nil {
+		return
+	}
+
+	s3 := conf.S3
+	dest, err := s3writer.New(s3.Bucket, s3.Prefix, s3.Region, endpointOf(s3), s3.SSE, s3.AccessKey, s3.SecretKey, s3.Concurrency)
+	if err != nil {
+		panic(err)
+	}
+
+	profiler := profile.New(node, secondsOr(conf.CPU, 30), dest, monitor)
+	go profiler.Run(ctx, secondsOr(conf.Interval, 300))
+}
+
+// endpointOf returns the custom endpoint of an S3 sink, if any
+func endpointOf(conf *config.S3Sink) s3writer.Endpoint {
+	return s3writer.Endpoint{
+		URL:        conf.Endpoint,
+		PathStyle:  conf.PathStyle,
+		DisableSSL: conf.DisableSSL,
+		CACert:     conf.CACert,
+	}
+}
+
+// newAudit creates the audit trail appending to a file and uploading to S3, or returns nil if not configured
+func newAudit(ctx context.Context, conf *config.Audit, node string, monitor monitor.Monitor) (*audit.Trail, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	var dest audit.Writer
+	if s3 := conf.S3; s3 != nil {
+		w, err := s3writer.New(s3.Bucket, s3.Prefix, s3.Region, endpointOf(s3), s3.SSE, s3.AccessKey, s3.SecretKey, s3.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		dest = w
+	}
+
+	trail, err := audit.New(node, conf.File, dest, monitor)
+	if err != nil {
+		return nil, err
+	}
+
+	if dest != nil {
+		go trail.Run(ctx, secondsOr(conf.Interval, 60))
+	}
+	return trail, nil
+}
+
+// secondsOr returns the number of seconds as a duration, or the default if not set
+func secondsOr(seconds, defaultSeconds int64) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
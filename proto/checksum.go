@@ -0,0 +1,81 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package talaria
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"sort"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the CRC-32C checksum of the data of an ingestion request, which a producer sends along with the
+// request so that the data corrupted on its way is rejected. The checksum of a file is the checksum of its bytes,
+// while a batch is read in the order of the keys of its maps, as this order is not kept once it is encoded.
+func Checksum(m *IngestRequest) uint32 {
+	switch data := m.GetData().(type) {
+	case *IngestRequest_Batch:
+		return checksumOf(data.Batch)
+	case *IngestRequest_Orc:
+		return crc32.Checksum(data.Orc, castagnoli)
+	case *IngestRequest_Csv:
+		return crc32.Checksum(data.Csv, castagnoli)
+	case *IngestRequest_Url:
+		return crc32.Checksum([]byte(data.Url), castagnoli)
+	case *IngestRequest_Parquet:
+		return crc32.Checksum(data.Parquet, castagnoli)
+	case *IngestRequest_Json:
+		return crc32.Checksum(data.Json, castagnoli)
+	default:
+		return 0
+	}
+}
+
+// checksumOf returns the checksum of a batch, its dictionary and its events being read in the order of their keys
+func checksumOf(b *Batch) uint32 {
+	h := crc32.New(castagnoli)
+	if b == nil {
+		return h.Sum32()
+	}
+
+	keys := make([]uint32, 0, len(b.Strings))
+	for k := range b.Strings {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		writeField(h, k, b.Strings[k])
+	}
+
+	for _, event := range b.Events {
+		keys = keys[:0]
+		for k := range event.Value {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		writeUint32(h, uint32(len(keys)))
+		for _, k := range keys {
+			value, _ := event.Value[k].Marshal()
+			writeField(h, k, value)
+		}
+	}
+	return h.Sum32()
+}
+
+// writeField writes a key and its length-prefixed value to the hash
+func writeField(h hash.Hash32, k uint32, v []byte) {
+	writeUint32(h, k)
+	writeUint32(h, uint32(len(v)))
+	_, _ = h.Write(v)
+}
+
+// writeUint32 writes an integer to the hash
+func writeUint32(h hash.Hash32, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	_, _ = h.Write(b[:])
+}
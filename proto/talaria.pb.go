@@ -39,6 +39,7 @@ type IngestRequest struct {
 	//	*IngestRequest_Csv
 	//	*IngestRequest_Url
 	//	*IngestRequest_Parquet
+	//	*IngestRequest_Json
 	Data isIngestRequest_Data `protobuf_oneof:"data"`
 }
 
@@ -96,12 +97,16 @@ type IngestRequest_Url struct {
 type IngestRequest_Parquet struct {
 	Parquet []byte `protobuf:"bytes,5,opt,name=parquet,proto3,oneof" json:"parquet,omitempty"`
 }
+type IngestRequest_Json struct {
+	Json []byte `protobuf:"bytes,6,opt,name=json,proto3,oneof" json:"json,omitempty"`
+}
 
 func (*IngestRequest_Batch) isIngestRequest_Data()   {}
 func (*IngestRequest_Orc) isIngestRequest_Data()     {}
 func (*IngestRequest_Csv) isIngestRequest_Data()     {}
 func (*IngestRequest_Url) isIngestRequest_Data()     {}
 func (*IngestRequest_Parquet) isIngestRequest_Data() {}
+func (*IngestRequest_Json) isIngestRequest_Data()    {}
 
 func (m *IngestRequest) GetData() isIngestRequest_Data {
 	if m != nil {
@@ -145,6 +150,13 @@ func (m *IngestRequest) GetParquet() []byte {
 	return nil
 }
 
+func (m *IngestRequest) GetJson() []byte {
+	if x, ok := m.GetData().(*IngestRequest_Json); ok {
+		return x.Json
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*IngestRequest) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
@@ -153,6 +165,7 @@ func (*IngestRequest) XXX_OneofWrappers() []interface{} {
 		(*IngestRequest_Csv)(nil),
 		(*IngestRequest_Url)(nil),
 		(*IngestRequest_Parquet)(nil),
+		(*IngestRequest_Json)(nil),
 	}
 }
 
@@ -1457,75 +1470,75 @@ func init() {
 func init() { proto.RegisterFile("talaria.proto", fileDescriptor_8f344df92059c5ff) }
 
 var fileDescriptor_8f344df92059c5ff = []byte{
-	// 1074 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x56, 0x4b, 0x6f, 0x1b, 0x55,
-	0x14, 0x9e, 0x6b, 0x7b, 0xfc, 0x38, 0x79, 0xf6, 0x12, 0x25, 0x53, 0x83, 0x46, 0xd1, 0x08, 0x85,
-	0x80, 0x5a, 0x23, 0x5c, 0x13, 0x41, 0x2b, 0x55, 0xaa, 0x9b, 0xb4, 0x09, 0x12, 0x20, 0x6e, 0x2b,
-	0x24, 0x96, 0x13, 0xe7, 0x26, 0x31, 0x1d, 0xcf, 0x75, 0xe7, 0x5e, 0xa7, 0x31, 0x0b, 0x84, 0xf8,
-	0x05, 0xec, 0xd8, 0xb3, 0x62, 0xc9, 0x92, 0x9f, 0xc0, 0x32, 0xcb, 0x2e, 0x89, 0x23, 0x24, 0x96,
-	0xfd, 0x09, 0xe8, 0xbe, 0xe6, 0x95, 0xb8, 0x12, 0xbb, 0x39, 0xdf, 0x79, 0x7c, 0xe7, 0x9e, 0x73,
-	0xe7, 0x9b, 0x81, 0x25, 0x11, 0x46, 0x61, 0x32, 0x0c, 0x3b, 0xe3, 0x84, 0x09, 0x86, 0x1b, 0xc6,
-	0x0c, 0x7e, 0x45, 0xb0, 0x74, 0x10, 0x9f, 0x50, 0x2e, 0x08, 0x7d, 0x39, 0xa1, 0x5c, 0xe0, 0x2d,
-	0x70, 0x0f, 0x43, 0x31, 0x38, 0xf5, 0xd0, 0x26, 0xda, 0x5e, 0xe8, 0x2e, 0x77, 0x6c, 0x66, 0x5f,
-	0xa2, 0xfb, 0x0e, 0xd1, 0x6e, 0x8c, 0xa1, 0xca, 0x92, 0x81, 0x57, 0xd9, 0x44, 0xdb, 0x8b, 0xfb,
-	0x0e, 0x91, 0x86, 0xc4, 0x06, 0xfc, 0xcc, 0xab, 0x5a, 0x6c, 0xc0, 0xcf, 0x24, 0x36, 0x49, 0x22,
-	0xaf, 0xb6, 0x89, 0xb6, 0x5b, 0x12, 0x9b, 0x24, 0x11, 0x6e, 0x43, 0x63, 0x1c, 0x26, 0x2f, 0x27,
-	0x54, 0x78, 0xae, 0x89, 0xb5, 0x40, 0xbf, 0x0e, 0xb5, 0xa3, 0x50, 0x84, 0xc1, 0x2a, 0x2c, 0xdb,
-	0xc6, 0xf8, 0x98, 0xc5, 0x9c, 0x06, 0xbf, 0x21, 0x70, 0x55, 0x13, 0xf8, 0x53, 0x68, 0x70, 0x91,
-	0x0c, 0xe3, 0x13, 0xee, 0xa1, 0xcd, 0xea, 0xf6, 0x42, 0xf7, 0xdd, 0x62, 0x97, 0x9d, 0x67, 0xda,
-	0xbb, 0x17, 0x8b, 0x64, 0x4a, 0x6c, 0x2c, 0xde, 0x82, 0x3a, 0x3d, 0xa3, 0xb1, 0xe0, 0x5e, 0x45,
-	0x65, 0x65, 0x67, 0xdb, 0x93, 0x30, 0x31, 0xde, 0xf6, 0x7d, 0x58, 0xcc, 0x17, 0xc0, 0xab, 0x50,
-	0x7d, 0x41, 0xa7, 0x6a, 0x20, 0x4b, 0x44, 0x3e, 0xe2, 0x35, 0x70, 0xcf, 0xc2, 0x68, 0x42, 0xf5,
-	0xf1, 0x89, 0x36, 0xee, 0x57, 0x3e, 0x43, 0xc1, 0xcf, 0x08, 0x5c, 0x55, 0x0d, 0x7f, 0x6c, 0x63,
-	0x74, 0x8b, 0xb7, 0x8b, 0x64, 0x9d, 0x6f, 0xa5, 0x4f, 0x37, 0xa8, 0xe3, 0xda, 0xfb, 0x00, 0x19,
-	0x78, 0x03, 0xe9, 0xfb, 0x79, 0xd2, 0x7c, 0xf7, 0x2a, 0x2b, 0xdf, 0xc4, 0x9f, 0x08, 0x5c, 0x05,
-	0xe2, 0x75, 0x70, 0x87, 0xb1, 0xb8, 0xd7, 0x55, 0x75, 0x5c, 0xb9, 0x3d, 0x65, 0x1a, 0x7c, 0xa7,
-	0xa7, 0x6a, 0x55, 0x0d, 0xbe, 0xd3, 0x93, 0x9b, 0x39, 0x8e, 0x58, 0x28, 0x3d, 0x72, 0x8b, 0x48,
-	0x6e, 0xc6, 0x00, 0xd8, 0x83, 0xba, 0x9e, 0xa4, 0x5a, 0xe6, 0xd2, 0xbe, 0x43, 0x8c, 0x8d, 0xd7,
-	0xa0, 0x76, 0xc8, 0x58, 0xa4, 0x96, 0xd9, 0xdc, 0x77, 0x88, 0xb2, 0x24, 0x2a, 0x86, 0x23, 0xea,
-	0xd5, 0x0d, 0x85, 0xb2, 0x24, 0xfa, 0x3d, 0x67, 0xb1, 0xd7, 0x30, 0x35, 0x94, 0xd5, 0x6f, 0x98,
-	0xb3, 0x05, 0xb7, 0x60, 0x65, 0x97, 0xf2, 0x41, 0x32, 0x3c, 0xa4, 0xe6, 0x46, 0x06, 0x0f, 0x61,
-	0x35, 0x83, 0xf4, 0x5d, 0xc0, 0x1f, 0x41, 0x5d, 0x84, 0x87, 0x11, 0xb5, 0x17, 0x00, 0xa7, 0xc3,
-	0x78, 0x2e, 0xe1, 0x2f, 0xa9, 0x08, 0x89, 0x89, 0x08, 0x4e, 0xa1, 0x95, 0x82, 0x78, 0x1d, 0xea,
-	0x7c, 0x70, 0x4a, 0x47, 0xa1, 0x9a, 0x48, 0x8b, 0x18, 0x4b, 0x6e, 0x54, 0x85, 0xab, 0x81, 0xb4,
-	0x88, 0x36, 0xf0, 0x5d, 0x68, 0x0c, 0x58, 0x34, 0x19, 0xc5, 0xdc, 0xab, 0x2a, 0x9e, 0x77, 0x52,
-	0x9e, 0xc7, 0x0a, 0x57, 0x44, 0x36, 0x26, 0xf8, 0x0a, 0x20, 0x83, 0x31, 0x86, 0x5a, 0x1c, 0x8e,
-	0xa8, 0x21, 0x52, 0xcf, 0x12, 0x13, 0xd3, 0xb1, 0x65, 0x51, 0xcf, 0xd8, 0x93, 0x24, 0xa3, 0x11,
-	0x8d, 0x85, 0x9a, 0x79, 0x8b, 0x58, 0x33, 0xf8, 0x03, 0xc1, 0xea, 0x53, 0x2a, 0x9e, 0x8d, 0xa3,
-	0xa1, 0xe0, 0xf6, 0x05, 0xfd, 0x7f, 0x27, 0xf0, 0x8a, 0x27, 0x68, 0xa5, 0xcd, 0x4a, 0xcf, 0xf1,
-	0x30, 0x12, 0x34, 0xe1, 0x5e, 0x4d, 0x7b, 0x8c, 0x89, 0xdf, 0x83, 0xd6, 0x28, 0x3c, 0xd7, 0xac,
-	0x6a, 0xa7, 0x2e, 0xc9, 0x00, 0xe9, 0x8d, 0xe9, 0xb9, 0x78, 0xce, 0x5e, 0xd0, 0x58, 0xed, 0x76,
-	0x91, 0x64, 0x40, 0xf0, 0x1d, 0xdc, 0xca, 0x75, 0x6c, 0xb6, 0xb5, 0x05, 0x75, 0xae, 0xab, 0xa1,
-	0xd2, 0x8b, 0xa7, 0x02, 0x89, 0xf1, 0x16, 0x4b, 0x57, 0xca, 0xa5, 0xbb, 0xd0, 0xdc, 0x8b, 0x8f,
-	0xc6, 0x6c, 0x18, 0x0b, 0x39, 0xc7, 0x53, 0xc6, 0x85, 0x9d, 0xad, 0x7c, 0x96, 0xd8, 0x98, 0x25,
-	0x42, 0x25, 0xba, 0x44, 0x3d, 0x07, 0x5f, 0x80, 0xab, 0x28, 0xe4, 0x69, 0x15, 0xc9, 0xc1, 0xae,
-	0xca, 0x59, 0x24, 0xd6, 0xc4, 0x1f, 0x80, 0x2b, 0xd3, 0xad, 0x28, 0xdc, 0xca, 0xde, 0x53, 0x43,
-	0x46, 0xb4, 0x3f, 0xf8, 0x11, 0x96, 0x9f, 0x52, 0x41, 0xd8, 0xab, 0x74, 0x15, 0xf3, 0x8b, 0xe6,
-	0xc6, 0x5e, 0x29, 0x8e, 0xbd, 0x0d, 0xcd, 0x51, 0x78, 0xde, 0x9f, 0x0a, 0xca, 0xd5, 0xba, 0xab,
-	0x24, 0xb5, 0x8b, 0xe7, 0xaf, 0x95, 0xcf, 0x7f, 0x06, 0x2b, 0x29, 0xbf, 0x19, 0xec, 0x87, 0x19,
-	0x8d, 0x9e, 0xec, 0x4a, 0xe9, 0x7e, 0x16, 0x78, 0x13, 0xf6, 0xea, 0x31, 0x9b, 0xc4, 0x76, 0x42,
-	0xa9, 0x5d, 0xe4, 0xad, 0x96, 0x79, 0xff, 0xa9, 0x40, 0x5d, 0x57, 0xc3, 0x9d, 0xbc, 0x9c, 0x2c,
-	0x74, 0xd7, 0x4b, 0x6c, 0x5f, 0x1f, 0x1f, 0x48, 0x6f, 0x26, 0x33, 0x9d, 0xbc, 0xcc, 0xcc, 0x89,
-	0xdf, 0xe9, 0x65, 0xf2, 0xd3, 0x2b, 0xca, 0xcf, 0x42, 0xd7, 0xbb, 0x96, 0xf1, 0x44, 0xfb, 0xf3,
-	0xc2, 0xf4, 0x49, 0x41, 0x98, 0x16, 0xba, 0x1b, 0xd7, 0x92, 0xb4, 0x9c, 0xe7, 0x14, 0xeb, 0x4e,
-	0x4e, 0xb1, 0x6e, 0xea, 0xab, 0xcf, 0x58, 0xc4, 0x53, 0x25, 0xbb, 0x93, 0x53, 0xb2, 0xb7, 0x9d,
-	0x42, 0x2b, 0xdc, 0xdd, 0x9c, 0xc2, 0xbd, 0xb5, 0x99, 0x92, 0xf4, 0x7d, 0x0e, 0x4b, 0x85, 0x31,
-	0xca, 0x37, 0x3a, 0x9e, 0x44, 0x91, 0xde, 0x6d, 0x93, 0x68, 0x43, 0x5e, 0xf3, 0xa1, 0xfd, 0x86,
-	0xb9, 0x44, 0x3d, 0x07, 0x0f, 0x0a, 0xa9, 0x3b, 0xbd, 0x39, 0xa9, 0x6b, 0xe0, 0x46, 0x4c, 0x7e,
-	0x35, 0x65, 0x6e, 0x95, 0x68, 0x23, 0x78, 0x04, 0x2b, 0xa5, 0xe1, 0xce, 0x49, 0xf7, 0xa0, 0x71,
-	0xc4, 0x26, 0x4a, 0x75, 0x65, 0x01, 0x44, 0xac, 0x99, 0xe7, 0x57, 0x93, 0x9b, 0xcf, 0x2f, 0xe7,
-	0xa9, 0xd3, 0x9b, 0x44, 0x1b, 0x01, 0x81, 0xe5, 0xe2, 0x68, 0xe6, 0x67, 0xf3, 0xe1, 0x0f, 0xd4,
-	0x9e, 0x5c, 0x1b, 0xaa, 0x66, 0xfa, 0x32, 0x2d, 0x12, 0x6d, 0x74, 0x9f, 0x40, 0xe3, 0x20, 0x3e,
-	0x49, 0x28, 0xe7, 0xf8, 0x01, 0xd4, 0xf5, 0x8f, 0x04, 0xce, 0x16, 0x57, 0xf8, 0xe5, 0x69, 0x6f,
-	0x5c, 0xc3, 0xcd, 0x1f, 0x87, 0xd3, 0xbd, 0x40, 0xe0, 0x7e, 0x33, 0xa1, 0xc9, 0x14, 0x3f, 0x82,
-	0xa6, 0xfd, 0x0a, 0xe1, 0xec, 0x56, 0x96, 0xbe, 0x55, 0xed, 0xdb, 0x37, 0x78, 0x6c, 0x31, 0xbc,
-	0x0b, 0xad, 0x54, 0x1b, 0x71, 0x16, 0x59, 0x56, 0xf8, 0x76, 0xfb, 0x26, 0x57, 0x5a, 0xe5, 0x21,
-	0x34, 0x8c, 0x0c, 0xe0, 0x8d, 0x7c, 0x60, 0x4e, 0x98, 0xda, 0xde, 0x75, 0x87, 0xcd, 0xef, 0xf7,
-	0x2e, 0x2e, 0x7d, 0xe7, 0xf5, 0xa5, 0xef, 0xbc, 0xb9, 0xf4, 0xd1, 0x4f, 0x33, 0x1f, 0xfd, 0x3e,
-	0xf3, 0xd1, 0x5f, 0x33, 0x1f, 0x5d, 0xcc, 0x7c, 0xf4, 0xf7, 0xcc, 0x47, 0xff, 0xce, 0x7c, 0xe7,
-	0xcd, 0xcc, 0x47, 0xbf, 0x5c, 0xf9, 0xce, 0xc5, 0x95, 0xef, 0xbc, 0xbe, 0xf2, 0x9d, 0xc3, 0xba,
-	0xfa, 0x71, 0xbc, 0xf7, 0x5f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x79, 0x20, 0x85, 0x26, 0x49, 0x0a,
-	0x00, 0x00,
+	// 1075 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x56, 0x4d, 0x6f, 0x1b, 0x45,
+	0x18, 0xf6, 0xd8, 0xde, 0xb5, 0xfd, 0xe6, 0xb3, 0x43, 0x94, 0x6c, 0x0d, 0x5a, 0x45, 0x2b, 0x14,
+	0x02, 0x6a, 0x8d, 0x70, 0x4d, 0x04, 0xad, 0x54, 0xa9, 0x69, 0xd2, 0x26, 0x48, 0x80, 0x98, 0x56,
+	0x48, 0x1c, 0x37, 0xce, 0x24, 0x31, 0x5d, 0xef, 0xb8, 0x3b, 0xb3, 0x69, 0xc2, 0x01, 0x21, 0x7e,
+	0x01, 0xbf, 0x81, 0x13, 0x17, 0x24, 0x8e, 0xfc, 0x04, 0x8e, 0x39, 0xf6, 0x48, 0x1c, 0x21, 0x71,
+	0xec, 0x4f, 0x40, 0xf3, 0xb5, 0x5f, 0x89, 0x2b, 0x71, 0x9b, 0xe7, 0x79, 0x3f, 0x9e, 0x99, 0xf7,
+	0x9d, 0x79, 0x77, 0x61, 0x41, 0x84, 0x51, 0x98, 0x8c, 0xc2, 0xde, 0x24, 0x61, 0x82, 0xe1, 0x96,
+	0x81, 0xc1, 0xef, 0x08, 0x16, 0xf6, 0xe3, 0x63, 0xca, 0x05, 0xa1, 0x2f, 0x53, 0xca, 0x05, 0xde,
+	0x00, 0xe7, 0x20, 0x14, 0xc3, 0x13, 0x0f, 0xad, 0xa3, 0xcd, 0xb9, 0xfe, 0x62, 0xcf, 0x46, 0x6e,
+	0x4b, 0x76, 0xaf, 0x46, 0xb4, 0x19, 0x63, 0x68, 0xb0, 0x64, 0xe8, 0xd5, 0xd7, 0xd1, 0xe6, 0xfc,
+	0x5e, 0x8d, 0x48, 0x20, 0xb9, 0x21, 0x3f, 0xf5, 0x1a, 0x96, 0x1b, 0xf2, 0x53, 0xc9, 0xa5, 0x49,
+	0xe4, 0x35, 0xd7, 0xd1, 0x66, 0x47, 0x72, 0x69, 0x12, 0xe1, 0x2e, 0xb4, 0x26, 0x61, 0xf2, 0x32,
+	0xa5, 0xc2, 0x73, 0x8c, 0xaf, 0x25, 0xf0, 0x0a, 0x34, 0xbf, 0xe7, 0x2c, 0xf6, 0x5c, 0x63, 0x50,
+	0x68, 0xdb, 0x85, 0xe6, 0x61, 0x28, 0xc2, 0x60, 0x19, 0x16, 0xed, 0x76, 0xf9, 0x84, 0xc5, 0x9c,
+	0x06, 0xbf, 0x22, 0x70, 0xd4, 0xd6, 0xf0, 0xa7, 0xd0, 0xe2, 0x22, 0x19, 0xc5, 0xc7, 0xdc, 0x43,
+	0xeb, 0x8d, 0xcd, 0xb9, 0xfe, 0xbb, 0xe5, 0xbd, 0xf7, 0x9e, 0x69, 0xeb, 0x6e, 0x2c, 0x92, 0x73,
+	0x62, 0x7d, 0xf1, 0x06, 0xb8, 0xf4, 0x94, 0xc6, 0x82, 0x7b, 0xf5, 0xf5, 0x46, 0xe9, 0xc4, 0xbb,
+	0x92, 0x26, 0xc6, 0xda, 0xbd, 0x0f, 0xf3, 0xc5, 0x04, 0x78, 0x19, 0x1a, 0x2f, 0xe8, 0xb9, 0x2a,
+	0xd3, 0x02, 0x91, 0x4b, 0xbc, 0x02, 0xce, 0x69, 0x18, 0xa5, 0x54, 0x17, 0x85, 0x68, 0x70, 0xbf,
+	0xfe, 0x19, 0x0a, 0x7e, 0x46, 0xe0, 0xa8, 0x6c, 0xf8, 0x63, 0xeb, 0xa3, 0xb7, 0x78, 0xbb, 0x2c,
+	0xd6, 0xfb, 0x56, 0xda, 0xf4, 0x06, 0xb5, 0x5f, 0x77, 0x0f, 0x20, 0x27, 0x6f, 0x10, 0x7d, 0xbf,
+	0x28, 0x5a, 0xdc, 0xbd, 0x8a, 0x2a, 0x6e, 0xe2, 0x4f, 0x04, 0x8e, 0x22, 0xf1, 0x2a, 0x38, 0xa3,
+	0x58, 0xdc, 0xeb, 0xab, 0x3c, 0x8e, 0xec, 0xa9, 0x82, 0x86, 0xdf, 0x1a, 0xa8, 0x5c, 0x0d, 0xc3,
+	0x6f, 0x0d, 0x64, 0xbf, 0x8e, 0x22, 0x16, 0x4a, 0x8b, 0xec, 0x2d, 0x92, 0xfd, 0x32, 0x04, 0xf6,
+	0xc0, 0xd5, 0x95, 0x54, 0x2d, 0x5e, 0xd8, 0xab, 0x11, 0x83, 0x65, 0x27, 0x0f, 0x18, 0x8b, 0x54,
+	0x8b, 0xdb, 0xb2, 0x93, 0x12, 0x49, 0x56, 0x8c, 0xc6, 0xd4, 0x73, 0x8d, 0x84, 0x42, 0x59, 0xd7,
+	0x5b, 0x26, 0x87, 0xee, 0x7a, 0xcb, 0x9c, 0x2d, 0xb8, 0x05, 0x4b, 0x3b, 0x94, 0x0f, 0x93, 0xd1,
+	0x01, 0x35, 0xf7, 0x34, 0x78, 0x08, 0xcb, 0x39, 0xa5, 0xef, 0x02, 0xfe, 0x08, 0x5c, 0x11, 0x1e,
+	0x44, 0xd4, 0x5e, 0x00, 0x9c, 0x15, 0xe3, 0xb9, 0xa4, 0xbf, 0xa4, 0x22, 0x24, 0xc6, 0x23, 0x38,
+	0x81, 0x4e, 0x46, 0xe2, 0x55, 0x70, 0xf9, 0xf0, 0x84, 0x8e, 0x43, 0x55, 0x91, 0x0e, 0x31, 0x48,
+	0x76, 0x54, 0xb9, 0xab, 0x82, 0x74, 0x88, 0x06, 0xf8, 0x2e, 0xb4, 0x86, 0x2c, 0x4a, 0xc7, 0x31,
+	0xf7, 0x1a, 0x4a, 0xe7, 0x9d, 0x4c, 0xe7, 0xb1, 0xe2, 0x95, 0x90, 0xf5, 0x09, 0xbe, 0x02, 0xc8,
+	0x69, 0x8c, 0xa1, 0x19, 0x87, 0x63, 0x6a, 0x84, 0xd4, 0x5a, 0x72, 0xe2, 0x7c, 0x62, 0x55, 0xd4,
+	0x1a, 0x7b, 0x52, 0x64, 0x3c, 0xa6, 0xb1, 0x50, 0x35, 0xef, 0x10, 0x0b, 0x83, 0x3f, 0x10, 0x2c,
+	0x3f, 0xa5, 0xe2, 0xd9, 0x24, 0x1a, 0x09, 0x6e, 0x9f, 0xed, 0xff, 0x3b, 0x81, 0x57, 0x3e, 0x41,
+	0x27, 0xdb, 0xac, 0xb4, 0x1c, 0x8d, 0x22, 0x41, 0x13, 0xee, 0x35, 0xb5, 0xc5, 0x40, 0xfc, 0x1e,
+	0x74, 0xc6, 0xe1, 0x99, 0x56, 0x55, 0x3d, 0x75, 0x48, 0x4e, 0x48, 0x6b, 0x4c, 0xcf, 0xc4, 0x73,
+	0xf6, 0x82, 0x9a, 0xb7, 0x4b, 0x72, 0x22, 0xf8, 0x0e, 0x6e, 0x15, 0x76, 0x6c, 0xba, 0xb5, 0x01,
+	0x2e, 0xd7, 0xd9, 0x50, 0xe5, 0xe1, 0x29, 0x47, 0xe2, 0xf2, 0x1b, 0x52, 0xd7, 0xab, 0xa9, 0xfb,
+	0xd0, 0xde, 0x8d, 0x0f, 0x27, 0x6c, 0x14, 0x0b, 0x59, 0xc7, 0x13, 0xc6, 0x85, 0xad, 0xad, 0x5c,
+	0x4b, 0x6e, 0xc2, 0x12, 0xa1, 0x02, 0x1d, 0xa2, 0xd6, 0xc1, 0x17, 0xe0, 0x28, 0x09, 0x79, 0x5a,
+	0x25, 0xb2, 0xbf, 0xa3, 0x62, 0xe6, 0x89, 0x85, 0xf8, 0x03, 0x70, 0x64, 0xb8, 0x1d, 0x0a, 0xb7,
+	0xf2, 0x77, 0x6a, 0xc4, 0x88, 0xb6, 0x07, 0x3f, 0xc2, 0xe2, 0x53, 0x2a, 0x08, 0x7b, 0x95, 0xb5,
+	0x62, 0x76, 0xd2, 0x42, 0xd9, 0xeb, 0xe5, 0xb2, 0x77, 0xa1, 0x3d, 0x0e, 0xcf, 0xb6, 0xcf, 0x05,
+	0xe5, 0xaa, 0xdd, 0x0d, 0x92, 0xe1, 0xf2, 0xf9, 0x9b, 0xd5, 0xf3, 0x9f, 0xc2, 0x52, 0xa6, 0x6f,
+	0x0a, 0xfb, 0x61, 0x2e, 0xa3, 0x2b, 0xbb, 0x54, 0xb9, 0x9f, 0x25, 0xdd, 0x84, 0xbd, 0x7a, 0xcc,
+	0xd2, 0xd8, 0x56, 0x28, 0xc3, 0x65, 0xdd, 0x46, 0x55, 0xf7, 0x9f, 0x3a, 0xb8, 0x3a, 0x1b, 0xee,
+	0x15, 0xc7, 0xc9, 0x5c, 0x7f, 0xb5, 0xa2, 0xf6, 0xf5, 0xd1, 0xbe, 0xb4, 0xe6, 0x63, 0xa6, 0x57,
+	0x1c, 0x33, 0x33, 0xfc, 0xb7, 0x06, 0xf9, 0xf8, 0x19, 0x94, 0xc7, 0xcf, 0x5c, 0xdf, 0xbb, 0x16,
+	0xf1, 0x44, 0xdb, 0x8b, 0x83, 0xe9, 0x93, 0xd2, 0x60, 0x9a, 0xeb, 0xaf, 0x5d, 0x0b, 0xd2, 0xe3,
+	0xbc, 0x30, 0xb1, 0xee, 0x14, 0x26, 0xd6, 0x4d, 0xfb, 0xda, 0x66, 0x2c, 0xe2, 0xd9, 0x24, 0xbb,
+	0x53, 0x98, 0x64, 0x6f, 0x3b, 0x85, 0xf2, 0xc2, 0x77, 0x0b, 0x13, 0xee, 0xad, 0x9b, 0xa9, 0x8c,
+	0xbe, 0xcf, 0x61, 0xa1, 0x54, 0x46, 0xf9, 0xa2, 0xe3, 0x34, 0x8a, 0x74, 0x6f, 0xdb, 0x44, 0x03,
+	0x79, 0xcd, 0x47, 0xf6, 0x1b, 0xe6, 0x10, 0xb5, 0x0e, 0x1e, 0x94, 0x42, 0xb7, 0x06, 0x33, 0x42,
+	0x57, 0xc0, 0x89, 0x58, 0x7c, 0xac, 0x63, 0x1b, 0x44, 0x83, 0xe0, 0x11, 0x2c, 0x55, 0x8a, 0x3b,
+	0x23, 0xdc, 0x83, 0xd6, 0x21, 0x4b, 0xd5, 0xd4, 0x95, 0x09, 0x10, 0xb1, 0xb0, 0xa8, 0xaf, 0x2a,
+	0x37, 0x5b, 0x5f, 0xd6, 0x53, 0x87, 0xb7, 0x89, 0x06, 0x01, 0x81, 0xc5, 0x72, 0x69, 0x66, 0x47,
+	0xf3, 0xd1, 0x0f, 0xd4, 0x9e, 0x5c, 0x03, 0x95, 0x33, 0x7b, 0x4c, 0xf3, 0x44, 0x83, 0xfe, 0x13,
+	0x68, 0xed, 0xc7, 0xc7, 0x09, 0xe5, 0x1c, 0x3f, 0x00, 0x57, 0xff, 0x48, 0xe0, 0xbc, 0x71, 0xa5,
+	0x1f, 0xa1, 0xee, 0xda, 0x35, 0xde, 0xfc, 0x71, 0xd4, 0xfa, 0x17, 0x08, 0x9c, 0x6f, 0x52, 0x9a,
+	0x9c, 0xe3, 0x47, 0xd0, 0xb6, 0x5f, 0x21, 0x9c, 0xdf, 0xca, 0xca, 0xb7, 0xaa, 0x7b, 0xfb, 0x06,
+	0x8b, 0x4d, 0x86, 0x77, 0xa0, 0x93, 0xcd, 0x46, 0x9c, 0x7b, 0x56, 0x27, 0x7c, 0xb7, 0x7b, 0x93,
+	0x29, 0xcb, 0xf2, 0x10, 0x5a, 0x66, 0x0c, 0xe0, 0xb5, 0xa2, 0x63, 0x61, 0x30, 0x75, 0xbd, 0xeb,
+	0x06, 0x1b, 0xbf, 0x3d, 0xb8, 0xb8, 0xf4, 0x6b, 0xaf, 0x2f, 0xfd, 0xda, 0x9b, 0x4b, 0x1f, 0xfd,
+	0x34, 0xf5, 0xd1, 0x6f, 0x53, 0x1f, 0xfd, 0x35, 0xf5, 0xd1, 0xc5, 0xd4, 0x47, 0x7f, 0x4f, 0x7d,
+	0xf4, 0xef, 0xd4, 0xaf, 0xbd, 0x99, 0xfa, 0xe8, 0x97, 0x2b, 0xbf, 0x76, 0x71, 0xe5, 0xd7, 0x5e,
+	0x5f, 0xf9, 0xb5, 0x03, 0x57, 0xfd, 0x4e, 0xde, 0xfb, 0x6f, 0x00, 0x60, 0xfa, 0xc9, 0x68, 0x5f,
+	0x0a, 0x00, 0x00,
 }
 
 func (this *IngestRequest) Equal(that interface{}) bool {
@@ -1678,6 +1691,30 @@ func (this *IngestRequest_Parquet) Equal(that interface{}) bool {
 	}
 	return true
 }
+func (this *IngestRequest_Json) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*IngestRequest_Json)
+	if !ok {
+		that2, ok := that.(IngestRequest_Json)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if !bytes.Equal(this.Json, that1.Json) {
+		return false
+	}
+	return true
+}
 func (this *IngestResponse) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
@@ -2681,7 +2718,7 @@ func (this *IngestRequest) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 9)
+	s := make([]string, 0, 10)
 	s = append(s, "&talaria.IngestRequest{")
 	if this.Data != nil {
 		s = append(s, "Data: "+fmt.Sprintf("%#v", this.Data)+",\n")
@@ -2729,6 +2766,14 @@ func (this *IngestRequest_Parquet) GoString() string {
 		`Parquet:` + fmt.Sprintf("%#v", this.Parquet) + `}`}, ", ")
 	return s
 }
+func (this *IngestRequest_Json) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&talaria.IngestRequest_Json{` +
+		`Json:` + fmt.Sprintf("%#v", this.Json) + `}`}, ", ")
+	return s
+}
 func (this *IngestResponse) GoString() string {
 	if this == nil {
 		return "nil"
@@ -3457,6 +3502,22 @@ func (m *IngestRequest_Parquet) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	}
 	return len(dAtA) - i, nil
 }
+func (m *IngestRequest_Json) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *IngestRequest_Json) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	if m.Json != nil {
+		i -= len(m.Json)
+		copy(dAtA[i:], m.Json)
+		i = encodeVarintTalaria(dAtA, i, uint64(len(m.Json)))
+		i--
+		dAtA[i] = 0x32
+	}
+	return len(dAtA) - i, nil
+}
 func (m *IngestResponse) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -4677,6 +4738,18 @@ func (m *IngestRequest_Parquet) Size() (n int) {
 	}
 	return n
 }
+func (m *IngestRequest_Json) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Json != nil {
+		l = len(m.Json)
+		n += 1 + l + sovTalaria(uint64(l))
+	}
+	return n
+}
 func (m *IngestResponse) Size() (n int) {
 	if m == nil {
 		return 0
@@ -5269,6 +5342,16 @@ func (this *IngestRequest_Parquet) String() string {
 	}, "")
 	return s
 }
+func (this *IngestRequest_Json) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&IngestRequest_Json{`,
+		`Json:` + fmt.Sprintf("%v", this.Json) + `,`,
+		`}`,
+	}, "")
+	return s
+}
 func (this *IngestResponse) String() string {
 	if this == nil {
 		return "nil"
@@ -5884,6 +5967,39 @@ func (m *IngestRequest) Unmarshal(dAtA []byte) error {
 			copy(v, dAtA[iNdEx:postIndex])
 			m.Data = &IngestRequest_Parquet{v}
 			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Json", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTalaria
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTalaria
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTalaria
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := make([]byte, postIndex-iNdEx)
+			copy(v, dAtA[iNdEx:postIndex])
+			m.Data = &IngestRequest_Json{v}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTalaria(dAtA[iNdEx:])
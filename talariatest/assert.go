@@ -0,0 +1,63 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package talariatest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Result represents the columns returned by a query, by column name, as returned by the Query of a node.
+type Result = map[string][]interface{}
+
+// AssertRows asserts that every column of the result has the expected number of rows.
+func AssertRows(t testing.TB, result Result, expected int) bool {
+	t.Helper()
+	ok := true
+	for name, values := range result {
+		ok = assert.Len(t, values, expected, "column %s", name) && ok
+	}
+	return ok
+}
+
+// AssertColumn asserts that a column of the result has the expected values, in the order of the rows.
+func AssertColumn(t testing.TB, result Result, column string, expected ...interface{}) bool {
+	t.Helper()
+	values, ok := result[column]
+	if !ok {
+		return assert.Fail(t, "column "+column+" was not returned", "columns: %v", namesOf(result))
+	}
+	return assert.Equal(t, expected, values, "column %s", column)
+}
+
+// AssertColumnMatch asserts that a column of the result has the expected values, regardless of the order of
+// the rows, since the rows of different keys are not returned in any particular order.
+func AssertColumnMatch(t testing.TB, result Result, column string, expected ...interface{}) bool {
+	t.Helper()
+	values, ok := result[column]
+	if !ok {
+		return assert.Fail(t, "column "+column+" was not returned", "columns: %v", namesOf(result))
+	}
+	return assert.ElementsMatch(t, expected, values, "column %s", column)
+}
+
+// Eventually asserts that the condition becomes true within the timeout, such as the files notified through
+// the queue being ingested asynchronously.
+func Eventually(t testing.TB, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	return assert.Eventually(t, condition, timeout, 10*time.Millisecond)
+}
+
+// namesOf returns the sorted names of the columns of the result
+func namesOf(result Result) []string {
+	out := make([]string, 0, len(result))
+	for name := range result {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
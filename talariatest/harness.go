@@ -0,0 +1,117 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+// Package talariatest provides the support to write integration tests against Talaria without AWS: an
+// in-process node storing its tables in a temporary directory, an in-memory monitor, a fake SQS queue and
+// a fake S3 bucket, as well as assertions on the columns returned by a query.
+package talariatest
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/embedded"
+)
+
+const readyTimeout = 10 * time.Second // The maximum time waited for the node to listen
+
+// Harness represents a node running in-process for the duration of a test, along with its fakes.
+type Harness struct {
+	*embedded.Node
+	Dir     string   // The temporary directory of the tables, removed once the test is done
+	Monitor *Monitor // The monitor receiving the logs and the metrics of the node
+	Queue   *Queue   // The queue notifying the files to ingest
+	Bucket  *Bucket  // The bucket the notified files are downloaded from
+	grpc    int32    // The port of the gRPC listener
+	presto  int32    // The port of the Presto Thrift listener
+}
+
+// New starts a node in the background with its config, in the YAML format of the config file, and closes
+// it once the test is done. The test fails right away if the node can not be started.
+func New(t testing.TB, config string, options ...embedded.Option) *Harness {
+	dir, err := ioutil.TempDir("", "talariatest")
+	if err != nil {
+		t.Fatalf("talariatest: unable to create the directory: %v", err)
+	}
+
+	h := &Harness{
+		Dir:     dir,
+		Monitor: NewMonitor(),
+		Queue:   NewQueue(),
+		Bucket:  NewBucket(),
+		grpc:    freePort(t),
+		presto:  freePort(t),
+	}
+
+	h.Node, err = embedded.New(dir, append([]embedded.Option{
+		embedded.WithConfig([]byte(config)),
+		embedded.WithMonitor(h.Monitor),
+		embedded.WithS3SQS(h.Queue, h.Bucket),
+	}, options...)...)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatalf("talariatest: unable to create the node: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = h.Node.Close()
+		_ = h.Queue.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	// Listen in the background and wait for the gRPC listener to accept connections
+	go func() {
+		if err := h.Listen(context.Background(), h.presto, h.grpc); err != nil {
+			h.Monitor.Error(err)
+		}
+	}()
+
+	if !awaitListener(h.GRPC(), readyTimeout) {
+		t.Fatalf("talariatest: the node is not listening on %s", h.GRPC())
+	}
+	return h
+}
+
+// GRPC returns the address of the gRPC listener, to connect a client to.
+func (h *Harness) GRPC() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(h.grpc)))
+}
+
+// Presto returns the address of the Presto Thrift listener.
+func (h *Harness) Presto() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(h.presto)))
+}
+
+// Upload puts the file in the fake bucket and notifies it through the fake queue, so that the node ingests it.
+func (h *Harness) Upload(bucket, key string, data []byte) {
+	h.Bucket.Put(bucket, key, data)
+	h.Queue.Notify(bucket, key, len(data))
+}
+
+// freePort returns a port which is free to listen on
+func freePort(t testing.TB) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("talariatest: unable to find a free port: %v", err)
+	}
+
+	defer l.Close()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// awaitListener waits until the address accepts connections, or the timeout elapses
+func awaitListener(addr string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+			_ = conn.Close()
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package talariatest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor"
+	"github.com/kelindar/talaria/internal/monitor/logging"
+)
+
+// Assert contract compliance
+var _ monitor.Monitor = new(Monitor)
+
+// Monitor represents a monitor which keeps the metrics and the logs in memory, so that a test can assert on
+// them. The metrics are named after their context tag and their key, such as "server.ingest.error", and
+// summed regardless of their tags.
+type Monitor struct {
+	lock   sync.Mutex
+	counts map[string]int64   // The counters, by name
+	gauges map[string]float64 // The last value of the gauges, by name
+	logs   []string           // The messages logged, prefixed by their level
+	errors []error            // The errors and the warnings reported
+}
+
+// NewMonitor creates a new in-memory monitor.
+func NewMonitor() *Monitor {
+	return &Monitor{
+		counts: make(map[string]int64),
+		gauges: make(map[string]float64),
+	}
+}
+
+// Duration records the time elapsed since the start, in milliseconds, as a gauge
+func (m *Monitor) Duration(contextTag, key string, start time.Time, tags ...string) {
+	m.Gauge(contextTag, key, float64(time.Since(start))/float64(time.Millisecond), tags...)
+}
+
+// Gauge records the last value of a gauge
+func (m *Monitor) Gauge(contextTag, key string, value float64, tags ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.gauges[contextTag+"."+key] = value
+}
+
+// Histogram records the last value of a histogram, as a gauge
+func (m *Monitor) Histogram(contextTag, key string, value float64, tags ...string) {
+	m.Gauge(contextTag, key, value, tags...)
+}
+
+// Count1 increments a counter
+func (m *Monitor) Count1(contextTag, key string, tags ...string) {
+	m.Count(contextTag, key, 1, tags...)
+}
+
+// Count adds an amount to a counter
+func (m *Monitor) Count(contextTag, key string, amount int64, tags ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counts[contextTag+"."+key] += amount
+}
+
+// Debug records a debug message
+func (m *Monitor) Debug(f string, v ...interface{}) {
+	m.log(logging.LevelDebug, fmt.Sprintf(f, v...))
+}
+
+// Info records an informational message
+func (m *Monitor) Info(f string, v ...interface{}) {
+	m.log(logging.LevelInfo, fmt.Sprintf(f, v...))
+}
+
+// Warning records a warning
+func (m *Monitor) Warning(err error) {
+	m.report(logging.LevelWarning, err)
+}
+
+// Error records an error
+func (m *Monitor) Error(err error) {
+	m.report(logging.LevelError, err)
+}
+
+// Log records a structured message, with its fields as key=value pairs
+func (m *Monitor) Log(level logging.Level, msg string, fields ...logging.Field) {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, f := range fields {
+		sb.WriteString(fmt.Sprintf(" %s=%v", f.Key, f.Value))
+	}
+	m.log(level, sb.String())
+}
+
+// CountOf returns the value of a counter, such as "server.ingest.error".
+func (m *Monitor) CountOf(name string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counts[name]
+}
+
+// GaugeOf returns the last value of a gauge and whether it was recorded.
+func (m *Monitor) GaugeOf(name string) (float64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.gauges[name]
+	return v, ok
+}
+
+// Logs returns the messages logged so far, each prefixed by its level such as "[info] ".
+func (m *Monitor) Logs() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]string(nil), m.logs...)
+}
+
+// Errors returns the errors and the warnings reported so far.
+func (m *Monitor) Errors() []error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]error(nil), m.errors...)
+}
+
+// log records a message
+func (m *Monitor) log(level logging.Level, msg string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.logs = append(m.logs, "["+string(level)+"] "+msg)
+}
+
+// report records an error, and logs it
+func (m *Monitor) report(level logging.Level, err error) {
+	if err == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.errors = append(m.errors, err)
+	m.lock.Unlock()
+	m.log(level, err.Error())
+}
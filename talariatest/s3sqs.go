@@ -0,0 +1,136 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package talariatest
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/kelindar/talaria/internal/ingress/s3sqs"
+	"github.com/kelindar/talaria/internal/monitor/errors"
+)
+
+// Assert contract compliance
+var _ s3sqs.Reader = new(Queue)
+var _ s3sqs.Downloader = new(Bucket)
+
+// Queue represents a fake SQS queue, notifying the objects put in a bucket the way S3 does.
+type Queue struct {
+	lock     sync.Mutex
+	messages chan *awssqs.Message // The messages waiting to be received
+	done     chan struct{}        // The channel closed once the queue is closed
+	closed   bool                 // Whether the queue was closed
+	sent     int64                // The number of messages sent, used as their receipt handle
+	deleted  int64                // The number of messages deleted once received
+}
+
+// NewQueue creates a new fake SQS queue.
+func NewQueue() *Queue {
+	return &Queue{
+		messages: make(chan *awssqs.Message, 1024),
+		done:     make(chan struct{}),
+	}
+}
+
+// Notify sends a message notifying that an object was created in a bucket, in the format of the S3 events.
+func (q *Queue) Notify(bucket, key string, size int) {
+	type object = map[string]interface{}
+	body, _ := json.Marshal(object{
+		"Records": []object{{
+			"eventSource": "aws:s3",
+			"eventName":   "ObjectCreated:Put",
+			"s3": object{
+				"bucket": object{"name": bucket},
+				"object": object{"key": url.QueryEscape(key), "size": size},
+			},
+		}},
+	})
+	q.Send(string(body))
+}
+
+// Send sends a message with a raw body, such as a corrupt one. It blocks while the queue is full, until the
+// message is received or the queue is closed.
+func (q *Queue) Send(body string) {
+	q.lock.Lock()
+	closed := q.closed
+	q.lock.Unlock()
+	if closed {
+		return
+	}
+
+	receipt := strconv.FormatInt(atomic.AddInt64(&q.sent, 1), 10)
+	select {
+	case q.messages <- &awssqs.Message{
+		MessageId:     aws.String(receipt),
+		ReceiptHandle: aws.String(receipt),
+		Body:          aws.String(body),
+	}:
+	case <-q.done:
+	}
+}
+
+// StartPolling returns the channel of the messages sent to the queue.
+func (q *Queue) StartPolling(maxPerRead, sleepMs int64, attributeNames, messageAttributeNames []*string) <-chan *awssqs.Message {
+	return q.messages
+}
+
+// DeleteMessage deletes a message once received.
+func (q *Queue) DeleteMessage(msg *awssqs.Message) error {
+	atomic.AddInt64(&q.deleted, 1)
+	return nil
+}
+
+// Deleted returns the number of messages deleted, which is the number of messages received by the ingestion.
+func (q *Queue) Deleted() int {
+	return int(atomic.LoadInt64(&q.deleted))
+}
+
+// Close closes the queue, the messages sent afterwards or still blocked in Send being dropped.
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	return nil
+}
+
+// Bucket represents a fake S3 storage, keeping the objects in memory.
+type Bucket struct {
+	lock    sync.Mutex
+	objects map[string][]byte // The objects, by their s3://bucket/key uri
+}
+
+// NewBucket creates a new fake S3 storage.
+func NewBucket() *Bucket {
+	return &Bucket{
+		objects: make(map[string][]byte),
+	}
+}
+
+// Put stores an object in a bucket.
+func (b *Bucket) Put(bucket, key string, data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.objects["s3://"+bucket+"/"+strings.TrimPrefix(key, "/")] = data
+}
+
+// Load downloads an object, given its s3://bucket/key uri.
+func (b *Bucket) Load(ctx context.Context, uri string) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if data, ok := b.objects[uri]; ok {
+		return append([]byte(nil), data...), nil
+	}
+
+	return nil, errors.NotFound("talariatest: object " + uri + " does not exist")
+}
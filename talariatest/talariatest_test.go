@@ -0,0 +1,118 @@
+// Copyright 2019-2020 Grabtaxi Holdings PTE LTE (GRAB), All rights reserved.
+// Use of this source code is governed by an MIT-style license that can be found in the LICENSE file
+
+package talariatest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelindar/talaria/internal/monitor/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor()
+	m.Count1("server", "ingest")
+	m.Count("server", "ingest", 2, "table:events")
+	m.Gauge("s3sqs", "inflight", 42)
+	m.Info("hello %s", "world")
+	m.Log(logging.LevelDebug, "sqs: downloaded", logging.F("key", "a.orc"))
+	m.Error(errors.New("boom"))
+	m.Warning(nil)
+
+	assert.Equal(t, int64(3), m.CountOf("server.ingest"))
+	assert.Equal(t, int64(0), m.CountOf("server.unknown"))
+	gauge, ok := m.GaugeOf("s3sqs.inflight")
+	assert.True(t, ok)
+	assert.Equal(t, 42.0, gauge)
+	assert.Len(t, m.Errors(), 1)
+	assert.Equal(t, []string{
+		"[info] hello world",
+		"[debug] sqs: downloaded key=a.orc",
+		"[error] boom",
+	}, m.Logs())
+}
+
+func TestBucket(t *testing.T) {
+	b := NewBucket()
+	b.Put("bucket", "/dir/a.orc", []byte("hello"))
+
+	data, err := b.Load(context.Background(), "s3://bucket/dir/a.orc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = b.Load(context.Background(), "s3://bucket/dir/b.orc")
+	assert.Error(t, err)
+}
+
+func TestQueue_Close(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < cap(q.messages); i++ {
+		q.Send("hello")
+	}
+
+	// A send blocked on the full queue must not prevent it from being closed
+	sent := make(chan struct{})
+	go func() {
+		q.Send("blocked")
+		close(sent)
+	}()
+
+	assert.NoError(t, q.Close())
+	<-sent
+	q.Send("dropped")
+	assert.Equal(t, cap(q.messages), len(q.messages))
+}
+
+func TestHarness(t *testing.T) {
+	h := New(t, `
+tables:
+  events:
+    hashBy: event
+    sortBy: time
+    ttl: 3600
+  orc:
+    hashBy: string1
+    sortBy: int1
+    ttl: 3600
+`)
+
+	// Append through code and query back
+	assert.NoError(t, h.Append(context.Background(), "events",
+		map[string]interface{}{"event": "click", "time": 1600000000, "value": 1.5},
+		map[string]interface{}{"event": "click", "time": 1600000001, "value": 2.5},
+		map[string]interface{}{"event": "view", "time": 1600000002, "value": 3.5},
+	))
+
+	result, err := h.Query(context.Background(), "events", []string{"event", "value"}, "event == 'click'")
+	assert.NoError(t, err)
+	AssertRows(t, result, 2)
+	AssertColumn(t, result, "event", "click", "click")
+	AssertColumnMatch(t, result, "value", 2.5, 1.5)
+
+	// Ingest a file notified through the fake queue
+	orc, err := ioutil.ReadFile("../test/test2.orc")
+	assert.NoError(t, err)
+	h.Upload("bucket", "data/test2.orc", orc)
+	Eventually(t, 5*time.Second, func() bool {
+		result, err := h.Query(context.Background(), "orc", []string{"string1"}, "string1 == 'hi'")
+		return err == nil && len(result["string1"]) == 1
+	})
+	assert.Equal(t, 1, h.Queue.Deleted())
+
+	// A missing file is reported to the monitor
+	h.Queue.Notify("bucket", "missing.orc", 10)
+	Eventually(t, 5*time.Second, func() bool {
+		for _, err := range h.Monitor.Errors() {
+			if strings.Contains(err.Error(), "missing.orc") {
+				return true
+			}
+		}
+		return false
+	})
+}